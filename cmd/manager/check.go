@@ -0,0 +1,187 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+// checkResult is the outcome of planning one RBAC Definition
+type checkResult struct {
+	RBACDefinition string           `json:"rbacDefinition"`
+	InSync         bool             `json:"inSync"`
+	Error          string           `json:"error,omitempty"`
+	Plan           *reconciler.Plan `json:"plan,omitempty"`
+}
+
+// check plans every RBAC Definition without changing anything and exits with
+// 1 if any of them is not in sync
+func check(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: rbac-manager check [--output=text|json]")
+		fs.PrintDefaults()
+	}
+	var output string
+	fs.StringVar(&output, "output", "text", "Output format, text or json")
+	fs.StringVar(&output, "o", "text", "Output format, text or json")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 0 {
+		fs.Usage()
+		return 2
+	}
+	if output != "text" && output != "json" {
+		logrus.Errorf("unknown output format %v, expected text or json", output)
+		return 2
+	}
+
+	rbacDefs, err := kube.GetRbacDefinitions()
+	if err != nil {
+		logrus.Errorf("cannot list RBAC Definitions: %v", err)
+		return 1
+	}
+
+	clientset := kube.GetClientsetOrDie()
+	results := []checkResult{}
+	converged := true
+	for i := range rbacDefs.Items {
+		rbacDef := &rbacDefs.Items[i]
+		result := checkResult{RBACDefinition: rbacDef.Name}
+
+		r := reconciler.Reconciler{Clientset: clientset}
+		plan, err := r.Plan(rbacDef)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Plan = plan
+			result.InSync = plan.InSync()
+		}
+
+		converged = converged && result.InSync
+		results = append(results, result)
+	}
+
+	if output == "json" {
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			logrus.Error(err)
+			return 1
+		}
+		fmt.Fprintln(os.Stdout, string(out))
+	} else {
+		printCheckResults(os.Stdout, results)
+	}
+
+	if !converged {
+		return 1
+	}
+	return 0
+}
+
+func printCheckResults(w io.Writer, results []checkResult) {
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			fmt.Fprintf(w, "RBACDefinition %v cannot be planned: %v\n", result.RBACDefinition, result.Error)
+		case result.InSync:
+			fmt.Fprintf(w, "RBACDefinition %v is in sync\n", result.RBACDefinition)
+		default:
+			fmt.Fprintf(w, "RBACDefinition %v is not in sync\n", result.RBACDefinition)
+			printPlanResources(w, "+", &result.Plan.Create, nil)
+			printPlanResources(w, "~", &result.Plan.Update, &result.Plan.Existing)
+			printPlanResources(w, "-", &result.Plan.Delete, nil)
+		}
+	}
+}
+
+// printPlanResources prints one line per resource. Cluster Role Bindings
+// updated in place are followed by the subjects they gain and lose compared
+// with existing.
+func printPlanResources(w io.Writer, prefix string, resources *reconciler.PlanResources, existing *reconciler.PlanResources) {
+	for _, namespace := range resources.Namespaces {
+		fmt.Fprintf(w, "  %v Namespace %v\n", prefix, namespace.Name)
+	}
+	for _, sa := range resources.ServiceAccounts {
+		fmt.Fprintf(w, "  %v ServiceAccount %v/%v\n", prefix, sa.Namespace, sa.Name)
+	}
+	for _, crb := range resources.ClusterRoleBindings {
+		fmt.Fprintf(w, "  %v ClusterRoleBinding %v (%v %v)\n", prefix, crb.Name, crb.RoleRef.Kind, crb.RoleRef.Name)
+		if existing != nil {
+			printSubjectChanges(w, crb.Subjects, existingSubjects(existing, crb.Name))
+		}
+	}
+	for _, role := range resources.Roles {
+		fmt.Fprintf(w, "  %v Role %v/%v\n", prefix, role.Namespace, role.Name)
+	}
+	for _, rb := range resources.RoleBindings {
+		fmt.Fprintf(w, "  %v RoleBinding %v/%v (%v %v)\n", prefix, rb.Namespace, rb.Name, rb.RoleRef.Kind, rb.RoleRef.Name)
+	}
+}
+
+// existingSubjects returns the subjects of the existing Cluster Role Binding
+// called name
+func existingSubjects(existing *reconciler.PlanResources, name string) []rbacv1.Subject {
+	for _, crb := range existing.ClusterRoleBindings {
+		if crb.Name == name {
+			return crb.Subjects
+		}
+	}
+	return nil
+}
+
+func printSubjectChanges(w io.Writer, desired []rbacv1.Subject, existing []rbacv1.Subject) {
+	for _, subject := range desired {
+		if !containsSubject(existing, subject) {
+			fmt.Fprintf(w, "      + %v\n", subjectString(subject))
+		}
+	}
+	for _, subject := range existing {
+		if !containsSubject(desired, subject) {
+			fmt.Fprintf(w, "      - %v\n", subjectString(subject))
+		}
+	}
+}
+
+func containsSubject(subjects []rbacv1.Subject, subject rbacv1.Subject) bool {
+	for _, s := range subjects {
+		if s.Kind == subject.Kind && s.Name == subject.Name && s.Namespace == subject.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func subjectString(subject rbacv1.Subject) string {
+	if subject.Namespace != "" {
+		return fmt.Sprintf("%v %v/%v", subject.Kind, subject.Namespace, subject.Name)
+	}
+	return fmt.Sprintf("%v %v", subject.Kind, subject.Name)
+}
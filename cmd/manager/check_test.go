@@ -0,0 +1,75 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+func TestPrintCheckResults(t *testing.T) {
+	// A Service Account whose image pull secrets drifted is only updated
+	plan := &reconciler.Plan{RBACDefinition: "ci"}
+	plan.Update.ServiceAccounts = []v1.ServiceAccount{{ObjectMeta: metav1.ObjectMeta{Name: "deployer", Namespace: "ci"}}}
+	assert.False(t, plan.InSync())
+
+	out := &bytes.Buffer{}
+	printCheckResults(out, []checkResult{
+		{RBACDefinition: "devs", InSync: true, Plan: &reconciler.Plan{RBACDefinition: "devs"}},
+		{RBACDefinition: "ci", InSync: plan.InSync(), Plan: plan},
+	})
+	assert.Equal(t, "RBACDefinition devs is in sync\n"+
+		"RBACDefinition ci is not in sync\n"+
+		"  ~ ServiceAccount ci/deployer\n", out.String())
+}
+
+func TestPrintCheckResultsSubjectPatches(t *testing.T) {
+	// A Cluster Role Binding whose subjects alone drifted is patched in place
+	roleRef := rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"}
+	plan := &reconciler.Plan{RBACDefinition: "ops"}
+	plan.Existing.ClusterRoleBindings = []rbacv1.ClusterRoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{Name: "ops-view"},
+		RoleRef:    roleRef,
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.UserKind, Name: "joe"},
+			{Kind: rbacv1.ServiceAccountKind, Name: "robot", Namespace: "ops"},
+		},
+	}}
+	plan.Update.ClusterRoleBindings = []rbacv1.ClusterRoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{Name: "ops-view"},
+		RoleRef:    roleRef,
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.UserKind, Name: "joe"},
+			{Kind: rbacv1.GroupKind, Name: "ops"},
+		},
+	}}
+	assert.False(t, plan.InSync())
+
+	out := &bytes.Buffer{}
+	printCheckResults(out, []checkResult{{RBACDefinition: "ops", InSync: plan.InSync(), Plan: plan}})
+	assert.Equal(t, "RBACDefinition ops is not in sync\n"+
+		"  ~ ClusterRoleBinding ops-view (ClusterRole view)\n"+
+		"      + Group ops\n"+
+		"      - ServiceAccount ops/robot\n", out.String())
+}
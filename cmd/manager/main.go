@@ -20,30 +20,99 @@ import (
 	"flag"
 	"net/http"
 	"os"
+	"strings"
+	"time"
+
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	ctrlwebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"github.com/schlapzz/rbac-manager/pkg/access"
 	"github.com/schlapzz/rbac-manager/pkg/apis"
 	"github.com/schlapzz/rbac-manager/pkg/controller"
+	"github.com/schlapzz/rbac-manager/pkg/debug"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
 	"github.com/schlapzz/rbac-manager/pkg/metrics"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
 	"github.com/schlapzz/rbac-manager/pkg/watcher"
+	"github.com/schlapzz/rbac-manager/pkg/webhook"
 	"github.com/schlapzz/rbac-manager/version"
 )
 
 var logLevel = flag.String("log-level", logrus.InfoLevel.String(), "Logrus log level")
 var addr = flag.String("metrics-address", ":8042", "The address to serve prometheus metrics.")
+var parallelism = flag.Int("parallelism", reconciler.DefaultParallelism, "Maximum number of concurrent create or delete calls per reconcile phase.")
+var watchWorkers = flag.Int("watch-workers", watcher.Workers, "Number of workers reconciling RBAC Definitions after changes to related resources.")
+var watcherHeartbeatTimeout = flag.Duration("watcher-heartbeat-timeout", watcher.HeartbeatTimeout, "How long a watcher of related resources may go without a heartbeat before it is restarted.")
+var watchResources = flag.String("watch-resources", strings.Join(watcher.AllResources, ","), "Comma separated kinds of resources to watch for changes. Changes to the other kinds are only picked up by the periodic resync.")
+var forbiddenSubjects = flag.String("forbidden-subjects", "", "Comma separated subjects that are never bound, as Kind:name or ServiceAccount:namespace/name. Names may contain shell patterns.")
+var enableGrantWebhook = flag.Bool("enable-grant-webhook", false, "Serve the webhook that checks approvals of RBAC Temporary Grants. Requires a serving certificate. Approved grants are only granted while it is enabled.")
+var defaultUserPrefix = flag.String("default-user-prefix", "", "Prefix prepended to the names of User subjects of RBAC Definitions that don't set defaults.userPrefix, such as the username prefix of an OIDC identity provider.")
+var defaultGroupPrefix = flag.String("default-group-prefix", "", "Prefix prepended to the names of Group subjects of RBAC Definitions that don't set defaults.groupPrefix, such as the groups prefix of an OIDC identity provider.")
+var grantApproverRole = flag.String("grant-approver-cluster-role", webhook.ApproverClusterRole, "ClusterRole whose holders may approve RBAC Temporary Grants.")
+var enableDebugEndpoints = flag.Bool("enable-debug-endpoints", false, "Serve the desired and actual state of RBAC Definitions under /debug/definitions, and plans of RBAC Definitions posted to /preview, on the metrics address. Exposes RBAC contents.")
+var syncInterval = flag.Duration("sync-interval", reconciler.DefaultSyncInterval, "How often to reconcile every RBAC Definition even if nothing changed, 0 disables periodic resyncs.")
+var maxPrunes = flag.Int("max-prunes", reconciler.MaxPrunes, "Maximum number of resources of one kind a reconcile may delete because its RBAC Definition no longer requests them, 0 for no limit. Larger prunes wait for approval through the approve-prunes annotation.")
+var subjectPatchLimit = flag.Int("subject-patch-limit", reconciler.SubjectPatchLimit, "Maximum number of subjects of an existing Cluster Role Binding that are added and removed one by one with a JSON patch. Bindings with more changed subjects are updated as a whole, as are all bindings if 0.")
+var orphanSweepInterval = flag.Duration("orphan-sweep-interval", time.Hour, "How often to look for managed resources whose RBAC Definition no longer exists, 0 disables the sweep.")
+var orphanSweepReportOnly = flag.Bool("orphan-sweep-report-only", false, "Log managed resources whose RBAC Definition no longer exists instead of deleting them.")
+var overlapCheckInterval = flag.Duration("overlap-check-interval", time.Hour, "How often to look for roles several RBAC Definitions grant to the same subjects, 0 disables the check.")
+var legacyOwners = flag.String("legacy-owners", "", "Comma separated group/version, or group/version:Kind, of owner references written by earlier versions of RBAC Manager. Resources owned by them are migrated on startup.")
+var legacyManagedLabels = flag.String("legacy-managed-labels", "", "Comma separated key=value labels earlier versions of RBAC Manager marked managed resources with, replaced when migrating.")
+var migrateFromLabels = flag.String("migrate-from-labels", "", "Label selector of resources created by another deployment of RBAC Manager to import on startup. Selected resources with a legacy owner reference are migrated if their RBACDefinition still requests them and pruned otherwise.")
+var migrateOnly = flag.Bool("migrate-only", false, "Migrate resources with legacy owner references and exit.")
+var namespaceEvents = flag.Bool("namespace-events", false, "Record events on namespaces when Role Bindings are created or deleted in them, for every RBAC Definition.")
+var preflightBindChecks = flag.Bool("preflight-bind-checks", true, "Check that RBAC Manager may bind a role before creating bindings to it, reporting roles it may not bind once per reconcile.")
+var cleanUpTokenSecrets = flag.Bool("clean-up-token-secrets", reconciler.CleanUpTokenSecrets, "Delete the service account token Secrets of managed Service Accounts when they are deleted.")
+var crbDeleteGracePeriod = flag.Duration("crb-delete-grace-period", 0, "How long a Cluster Role Binding that is no longer requested is marked with the pending-delete annotation before it is deleted, 0 deletes it right away.")
+var strictFields = flag.Bool("strict-fields", false, "Fail the reconcile of RBAC Definitions whose last applied configuration has unknown fields, such as misspelled ones the API server drops, and report them in the UnknownFieldsDetected condition.")
+var strictClusterServiceAccounts = flag.Bool("strict-cluster-service-accounts", false, "Fail the reconcile of RBAC Definitions that bind ServiceAccount subjects in clusterRoleBindings entries without limitToNamespaceSelector, instead of warning about them.")
+var stateConfigMap = flag.String("state-configmap", "", "Keep the state of RBAC Definitions that should survive restarts, such as the fingerprint of their resources, in this ConfigMap, given as namespace/name. It is created if it doesn't exist.")
+var validateBeforeApply = flag.Bool("validate-before-apply", false, "Dry run the creates of each kind of resource before making any of them, skipping and reporting resources the API server rejects. Doubles the API calls for creates.")
+var listPageSize = flag.Int64("list-page-size", reconciler.ListPageSize, "Number of existing resources listed per request while reconciling, which bounds the memory a reconcile needs.")
+var objectRetries = flag.Int("object-retries", reconciler.MaxObjectRetries, "Number of times creating or deleting a single resource is retried after timeouts, throttling, or server errors during one reconcile.")
+var reconcileTimeout = flag.Duration("reconcile-timeout", reconciler.ReconcileTimeout, "Maximum duration of a single reconcile, after which it is abandoned and retried with backoff. A value of 0 disables the timeout.")
+var driftReports = flag.Bool("drift-reports", true, "Write an RBACDriftReport for every RBAC Definition listing the drift repaired during its last reconcile and the changes that were not made.")
+var useCache = flag.Bool("use-cache", false, "Read existing resources from informer caches instead of listing them on every reconcile.")
+var outputDir = flag.String("output-dir", "", "Write the resources RBAC Definitions request to files in this directory instead of creating them, along with the resources to delete in deletions.yaml. Nothing in the cluster is changed.")
+var outputInterval = flag.Duration("output-interval", 0, "How often to write the files of output-dir, 0 writes them once and exits.")
+var managedNamespaces = flag.String("managed-namespaces", "", "Comma separated namespaces to manage in namespaced mode, which only needs Roles in these namespaces. RBAC Definitions with clusterRoleBindings or other resources outside these namespaces are rejected.")
+
+var childLabels keyValueFlag
+var childAnnotations keyValueFlag
+
+// keyValueFlag collects the key=value pairs of a flag that may be repeated
+type keyValueFlag []string
+
+func (f *keyValueFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *keyValueFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
 
 func init() {
 	klog.InitFlags(nil)
+	flag.Var(&childLabels, "child-labels", "Label set on every resource RBAC Manager manages, as key=value, beneath the labels requested for the resource. May be repeated.")
+	flag.Var(&childAnnotations, "child-annotations", "Annotation set on every resource RBAC Manager manages, as key=value, beneath the annotations requested for the resource. May be repeated.")
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 
 	parsedLevel, err := logrus.ParseLevel(*logLevel)
@@ -54,6 +123,173 @@ func main() {
 		logrus.SetLevel(parsedLevel)
 	}
 
+	if *parallelism < 1 {
+		logrus.Errorf("parallelism flag must be at least 1, got %d", *parallelism)
+		os.Exit(1)
+	}
+	reconciler.DefaultParallelism = *parallelism
+
+	if *listPageSize < 1 {
+		logrus.Errorf("list-page-size flag must be at least 1, got %d", *listPageSize)
+		os.Exit(1)
+	}
+	reconciler.ListPageSize = *listPageSize
+
+	if *objectRetries < 0 {
+		logrus.Errorf("object-retries flag must not be negative, got %d", *objectRetries)
+		os.Exit(1)
+	}
+	reconciler.MaxObjectRetries = *objectRetries
+
+	if *reconcileTimeout < 0 {
+		logrus.Errorf("reconcile-timeout flag must not be negative, got %v", *reconcileTimeout)
+		os.Exit(1)
+	}
+	reconciler.ReconcileTimeout = *reconcileTimeout
+
+	if *watchWorkers < 1 {
+		logrus.Errorf("watch-workers flag must be at least 1, got %d", *watchWorkers)
+		os.Exit(1)
+	}
+	watcher.Workers = *watchWorkers
+
+	if *watcherHeartbeatTimeout <= 0 {
+		logrus.Errorf("watcher-heartbeat-timeout flag must be positive, got %v", *watcherHeartbeatTimeout)
+		os.Exit(1)
+	}
+	watcher.HeartbeatTimeout = *watcherHeartbeatTimeout
+
+	watcher.Resources, err = watcher.ParseResources(*watchResources)
+	if err != nil {
+		logrus.Errorf("watch-resources flag is invalid: %v", err)
+		os.Exit(1)
+	}
+
+	if *syncInterval < 0 {
+		logrus.Errorf("sync-interval flag must not be negative, got %v", *syncInterval)
+		os.Exit(1)
+	}
+	reconciler.DefaultSyncInterval = *syncInterval
+
+	if *maxPrunes < 0 {
+		logrus.Errorf("max-prunes flag must not be negative, got %d", *maxPrunes)
+		os.Exit(1)
+	}
+	reconciler.MaxPrunes = *maxPrunes
+
+	if *subjectPatchLimit < 0 {
+		logrus.Errorf("subject-patch-limit flag must not be negative, got %d", *subjectPatchLimit)
+		os.Exit(1)
+	}
+	reconciler.SubjectPatchLimit = *subjectPatchLimit
+	reconciler.NamespaceEvents = *namespaceEvents
+	reconciler.PreflightBindChecks = *preflightBindChecks
+	reconciler.ValidateBeforeApply = *validateBeforeApply
+	reconciler.CleanUpTokenSecrets = *cleanUpTokenSecrets
+	reconciler.DefaultUserPrefix = *defaultUserPrefix
+	reconciler.DefaultGroupPrefix = *defaultGroupPrefix
+	reconciler.DriftReports = *driftReports
+
+	if *crbDeleteGracePeriod < 0 {
+		logrus.Errorf("crb-delete-grace-period flag must not be negative, got %v", *crbDeleteGracePeriod)
+		os.Exit(1)
+	}
+	reconciler.CRBDeleteGracePeriod = *crbDeleteGracePeriod
+	reconciler.StrictFields = *strictFields
+	reconciler.StrictClusterServiceAccounts = *strictClusterServiceAccounts
+
+	if *orphanSweepInterval < 0 {
+		logrus.Errorf("orphan-sweep-interval flag must not be negative, got %v", *orphanSweepInterval)
+		os.Exit(1)
+	}
+	if *overlapCheckInterval < 0 {
+		logrus.Errorf("overlap-check-interval flag must not be negative, got %v", *overlapCheckInterval)
+		os.Exit(1)
+	}
+	if *outputInterval < 0 {
+		logrus.Errorf("output-interval flag must not be negative, got %v", *outputInterval)
+		os.Exit(1)
+	}
+	var stateNamespace, stateName string
+	if *stateConfigMap != "" {
+		parts := strings.Split(*stateConfigMap, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			logrus.Errorf("state-configmap flag must be namespace/name, got %v", *stateConfigMap)
+			os.Exit(1)
+		}
+		stateNamespace, stateName = parts[0], parts[1]
+	}
+	if *outputDir != "" {
+		if info, err := os.Stat(*outputDir); err != nil || !info.IsDir() {
+			logrus.Errorf("output-dir flag must be an existing directory, got %v", *outputDir)
+			os.Exit(1)
+		}
+	}
+
+	reconciler.ChildLabels, err = reconciler.ParseKeyValues(childLabels)
+	if err != nil {
+		logrus.Errorf("child-labels flag is invalid: %v", err)
+		os.Exit(1)
+	}
+	reconciler.ChildAnnotations, err = reconciler.ParseKeyValues(childAnnotations)
+	if err != nil {
+		logrus.Errorf("child-annotations flag is invalid: %v", err)
+		os.Exit(1)
+	}
+	err = reconciler.ValidateChildMetadata(reconciler.ChildLabels, reconciler.ChildAnnotations)
+	if err != nil {
+		logrus.Errorf("child-labels or child-annotations flag is invalid: %v", err)
+		os.Exit(1)
+	}
+
+	reconciler.ForbiddenSubjects, err = reconciler.ParseSubjectPatterns(*forbiddenSubjects)
+	if err != nil {
+		logrus.Errorf("forbidden-subjects flag is invalid: %v", err)
+		os.Exit(1)
+	}
+
+	reconciler.ManagedNamespaces, err = reconciler.ParseManagedNamespaces(*managedNamespaces)
+	if err != nil {
+		logrus.Errorf("managed-namespaces flag is invalid: %v", err)
+		os.Exit(1)
+	}
+	if reconciler.NamespacedMode() && *useCache {
+		logrus.Error("use-cache flag can't be used with managed-namespaces")
+		os.Exit(1)
+	}
+	// Informer caches watch every kind of resource
+	if !watcher.WatchesAll() && *useCache {
+		logrus.Error("use-cache flag requires watching every kind of resource")
+		os.Exit(1)
+	}
+
+	migrator := &reconciler.Migrator{ListDefinitions: kube.GetRbacDefinitions}
+	migrator.LegacyOwners, err = reconciler.ParseLegacyOwners(*legacyOwners)
+	if err != nil {
+		logrus.Errorf("legacy-owners flag is invalid: %v", err)
+		os.Exit(1)
+	}
+	migrator.LegacyLabels, err = labels.ConvertSelectorToLabelsMap(*legacyManagedLabels)
+	if err != nil {
+		logrus.Errorf("legacy-managed-labels flag is invalid: %v", err)
+		os.Exit(1)
+	}
+	if *migrateFromLabels != "" {
+		migrator.Selector, err = labels.Parse(*migrateFromLabels)
+		if err != nil {
+			logrus.Errorf("migrate-from-labels flag is invalid: %v", err)
+			os.Exit(1)
+		}
+		if len(migrator.LegacyOwners) == 0 {
+			logrus.Error("migrate-from-labels flag requires legacy-owners")
+			os.Exit(1)
+		}
+	}
+	if *migrateOnly && len(migrator.LegacyOwners) == 0 {
+		logrus.Error("migrate-only flag requires legacy-owners")
+		os.Exit(1)
+	}
+
 	logrus.Info("----------------------------------")
 	logrus.Infof("rbac-manager %v running", version.Version)
 	logrus.Info("----------------------------------")
@@ -68,7 +304,8 @@ func main() {
 
 	// Create a new Cmd to provide shared dependencies and start components
 	logrus.Debug("Setting up manager")
-	mgr, err := manager.New(cfg, manager.Options{})
+	// Clients built from the manager's config record their request durations
+	mgr, err := manager.New(kube.Instrument(cfg), manager.Options{})
 	if err != nil {
 		logrus.Error(err, ": unable to set up overall controller manager")
 		os.Exit(1)
@@ -83,6 +320,69 @@ func main() {
 		os.Exit(1)
 	}
 
+	rbacVersion, err := kube.DiscoverRBACVersion(kube.GetClientsetOrDie().Discovery())
+	if err != nil {
+		logrus.Errorf("Error discovering the version of the RBAC API: %v", err)
+		os.Exit(1)
+	}
+	if rbacVersion != kube.RBACVersion {
+		// Informer caches only list rbac/v1
+		if *useCache {
+			logrus.Errorf("use-cache flag can't be used with clusters that don't serve rbac.authorization.k8s.io/%v", kube.RBACVersion)
+			os.Exit(1)
+		}
+		logrus.Warnf("Using rbac.authorization.k8s.io/%v, which this cluster prefers", rbacVersion)
+		kube.RBACVersion = rbacVersion
+	}
+
+	// Manifest mode only reads from the cluster, so nothing is migrated and
+	// no controller is started
+	if *outputDir != "" {
+		writer := &reconciler.ManifestWriter{
+			Clientset:       kube.GetClientsetOrDie(),
+			ListDefinitions: kube.GetRbacDefinitions,
+			GetDefinition:   kube.GetRbacDefinition,
+			Dir:             *outputDir,
+		}
+		if *outputInterval == 0 {
+			if err := writer.Write(); err != nil {
+				logrus.Errorf("Error writing manifests of RBAC Definitions: %v", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		writer.WritePeriodically(signals.SetupSignalHandler(), *outputInterval)
+		os.Exit(0)
+	}
+
+	// Legacy resources have to be migrated before anything reconciles, or
+	// duplicates of them would be created
+	if len(migrator.LegacyOwners) > 0 {
+		migrator.Clientset = kube.GetClientsetOrDie()
+		// Events are sent in the background, with migrate-only the process
+		// may exit before all of them are
+		migrator.Recorder = mgr.GetEventRecorderFor("rbac-manager")
+		migrated, err := migrator.Migrate()
+		logrus.Infof("Migrated %d resources with legacy owner references", len(migrated))
+		if err != nil {
+			logrus.Errorf("Error migrating resources with legacy owner references: %v", err)
+			if *migrateOnly {
+				os.Exit(1)
+			}
+		}
+		if *migrateOnly {
+			os.Exit(0)
+		}
+	}
+
+	if *stateConfigMap != "" {
+		reconciler.UseStateStore(&reconciler.ConfigMapStateStore{
+			Clientset: kube.GetClientsetOrDie(),
+			Namespace: stateNamespace,
+			Name:      stateName,
+		})
+	}
+
 	// Setup all Controllers
 	logrus.Debug("Setting up controller")
 	if err := controller.Add(mgr); err != nil {
@@ -90,14 +390,56 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *enableGrantWebhook {
+		logrus.Debug("Setting up grant webhook")
+		webhook.ApproverClusterRole = *grantApproverRole
+		reconciler.GrantApprovalsVerified = true
+		mgr.GetWebhookServer().Register(webhook.GrantPath, &ctrlwebhook.Admission{
+			Handler: &webhook.GrantValidator{Clientset: kube.GetClientsetOrDie()},
+		})
+	}
+
+	ctx := signals.SetupSignalHandler()
+
+	if *useCache {
+		logrus.Info("Starting caches for resources related to RBAC Definitions")
+		reconciler.DefaultCache, err = reconciler.NewCache(ctx, kube.GetClientsetOrDie())
+		if err != nil {
+			logrus.Error(err, ": unable to start caches")
+			os.Exit(1)
+		}
+	}
+
 	// Watch Related Resources
 	logrus.Info("Watching resources related to RBAC Definitions")
-	watcher.WatchRelatedResources()
+	watcher.WatchRelatedResources(ctx)
+
+	// Watchers must be running before SIGHUP or the sync interval can queue resyncs
+	handleSignals(ctx)
+	go watcher.ResyncPeriodically(ctx)
+
+	if *orphanSweepInterval > 0 {
+		sweeper := &reconciler.Sweeper{
+			Clientset:       kube.GetClientsetOrDie(),
+			ListDefinitions: kube.GetRbacDefinitions,
+			ReportOnly:      *orphanSweepReportOnly,
+		}
+		go sweeper.SweepPeriodically(ctx, *orphanSweepInterval)
+	}
+
+	if *overlapCheckInterval > 0 {
+		go access.ReportOverlapsPeriodically(ctx, kube.GetClientsetOrDie(), kube.GetRbacDefinitions, *overlapCheckInterval)
+	}
 
 	// Start metrics endpoint
 	go func() {
 		metrics.RegisterMetrics()
 		http.Handle("/metrics", promhttp.Handler())
+		http.Handle(watcher.HealthPath, watcher.HealthHandler{})
+		if *enableDebugEndpoints {
+			handler := &debug.Handler{Clientset: kube.GetClientsetOrDie()}
+			handler.Register(http.DefaultServeMux)
+		}
 		if err := http.ListenAndServe(*addr, nil); err != nil {
 			logrus.Error(err, ": unable to serve the metrics endpoint")
 			os.Exit(1)
@@ -106,7 +448,7 @@ func main() {
 
 	// Start the Cmd
 	logrus.Info("Watching RBAC Definitions")
-	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		logrus.Error(err, ": unable to run the manager")
 		os.Exit(1)
 	}
@@ -0,0 +1,83 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/schlapzz/rbac-manager/pkg/manifests"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+	"github.com/schlapzz/rbac-manager/pkg/watcher"
+)
+
+// printManifests prints the resources RBAC Manager is installed with, with a
+// ClusterRole limited to the features that are enabled
+func printManifests(args []string) int {
+	fs := flag.NewFlagSet("manifests", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: rbac-manager manifests [--namespace=rbac-manager] [--managed-namespaces=a,b] [--watch-resources=a,b] [--<feature>=false]")
+		fs.PrintDefaults()
+	}
+	defaults := manifests.DefaultFeatures()
+	options := manifests.Options{}
+	var managedNamespaces string
+	var watchResources string
+	fs.StringVar(&options.Namespace, "namespace", manifests.DefaultNamespace, "Namespace to deploy RBAC Manager to")
+	fs.StringVar(&options.Image, "image", manifests.DefaultImage, "Image of RBAC Manager")
+	fs.StringVar(&managedNamespaces, "managed-namespaces", "", "Comma separated namespaces to manage in namespaced mode, see the flag of the same name")
+	fs.StringVar(&managedNamespaces, "watch-namespaces", "", "Alias of managed-namespaces")
+	fs.StringVar(&watchResources, "watch-resources", "", "Comma separated kinds of resources to watch, see the flag of the same name. Every kind is watched if empty")
+	fs.BoolVar(&options.Features.CreateNamespaces, "create-namespaces", defaults.CreateNamespaces, "Allow createIfMissing and the DeleteNamespaces deletion policy")
+	fs.BoolVar(&options.Features.CopyRoles, "copy-roles", defaults.CopyRoles, "Allow roleFrom, which needs the escalate verb on Roles")
+	fs.BoolVar(&options.Features.DriftReports, "drift-reports", defaults.DriftReports, "Write RBAC Drift Reports, see the flag of the same name")
+	fs.BoolVar(&options.Features.PreflightBindChecks, "preflight-bind-checks", defaults.PreflightBindChecks, "Check roles may be bound before binding them, see the flag of the same name")
+	fs.BoolVar(&options.Features.CleanUpTokenSecrets, "clean-up-token-secrets", defaults.CleanUpTokenSecrets, "Delete the token Secrets of deleted Service Accounts, see the flag of the same name")
+	fs.BoolVar(&options.Features.DebugEndpoints, "enable-debug-endpoints", defaults.DebugEndpoints, "Serve the debug endpoints, including /preview, see the flag of the same name")
+	fs.BoolVar(&options.Features.RemoteClusters, "remote-clusters", defaults.RemoteClusters, "Allow reading kubeconfigs of remote clusters from Secrets in the namespace of RBAC Manager")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	options.Features.ManagedNamespaces, err = reconciler.ParseManagedNamespaces(managedNamespaces)
+	if err != nil {
+		logrus.Errorf("managed-namespaces flag is invalid: %v", err)
+		return 2
+	}
+	if watchResources != "" {
+		options.Features.WatchResources, err = watcher.ParseResources(watchResources)
+		if err != nil {
+			logrus.Errorf("watch-resources flag is invalid: %v", err)
+			return 2
+		}
+	}
+
+	if err := manifests.Write(os.Stdout, options); err != nil {
+		logrus.Error(err)
+		return 1
+	}
+	return 0
+}
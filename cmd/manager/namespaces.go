@@ -0,0 +1,136 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+// namespaces prints the namespaces every entry of an RBAC Definition read
+// from a file would bind in, evaluated against the namespaces in the cluster
+func namespaces(args []string) int {
+	fs := flag.NewFlagSet("namespaces", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: rbac-manager namespaces -f definition.yaml [--explain] [-o table|json]")
+		fs.PrintDefaults()
+	}
+	var file string
+	fs.StringVar(&file, "f", "", "File holding the RBAC Definition as YAML or JSON, - for stdin")
+	fs.StringVar(&file, "filename", "", "File holding the RBAC Definition as YAML or JSON, - for stdin")
+	explain := fs.Bool("explain", false, "Explain why each namespace matched, and why namespaces that nearly matched didn't")
+	output := fs.String("o", "table", "Output format, table or json")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 0 || file == "" {
+		fs.Usage()
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		logrus.Errorf("unknown output format %v, expected table or json", *output)
+		return 2
+	}
+
+	rbacDef, err := readDefinition(file)
+	if err != nil {
+		logrus.Error(err)
+		return 1
+	}
+
+	p := reconciler.Parser{Clientset: kube.GetClientsetOrDie()}
+	matches, err := p.MatchNamespaces(rbacDef, *explain)
+	if err != nil {
+		logrus.Errorf("RBACDefinition %v is invalid: %v", rbacDef.Name, err)
+		return 1
+	}
+
+	if *output == "json" {
+		out, err := json.MarshalIndent(matches, "", "  ")
+		if err != nil {
+			logrus.Error(err)
+			return 1
+		}
+		fmt.Fprintln(os.Stdout, string(out))
+	} else {
+		printNamespaces(os.Stdout, matches, *explain)
+	}
+	return 0
+}
+
+func readDefinition(file string) (rbacmanagerv1beta1.RBACDefinition, error) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return rbacDef, fmt.Errorf("cannot read RBAC Definition: %v", err)
+	}
+	err = yaml.Unmarshal(data, &rbacDef)
+	if err != nil {
+		return rbacDef, fmt.Errorf("cannot decode RBAC Definition: %v", err)
+	}
+	if rbacDef.Kind != "" && rbacDef.Kind != "RBACDefinition" {
+		return rbacDef, fmt.Errorf("expected an RBACDefinition, got %v", rbacDef.Kind)
+	}
+	return rbacDef, nil
+}
+
+func printNamespaces(w io.Writer, matches []reconciler.EntryNamespaces, explain bool) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if !explain {
+		fmt.Fprintln(tw, "RBACBINDING\tROLE\tNAMESPACES")
+		for _, match := range matches {
+			namespaces := "<none>"
+			if len(match.Namespaces) > 0 {
+				namespaces = strings.Join(match.Namespaces, ",")
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\n", match.RBACBinding, match.Role, namespaces)
+		}
+		tw.Flush()
+		return
+	}
+
+	fmt.Fprintln(tw, "RBACBINDING\tROLE\tNAMESPACE\tMATCHED\tREASONS")
+	for _, match := range matches {
+		if len(match.Explanations) == 0 {
+			fmt.Fprintf(tw, "%v\t%v\t<none>\t\t\n", match.RBACBinding, match.Role)
+		}
+		for _, explanation := range match.Explanations {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", match.RBACBinding, match.Role, explanation.Namespace, explanation.Matched, strings.Join(explanation.Reasons, "; "))
+		}
+	}
+	tw.Flush()
+}
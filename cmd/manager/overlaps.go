@@ -0,0 +1,106 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/schlapzz/rbac-manager/pkg/access"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// overlaps lists the roles several RBAC Definitions grant to the same
+// subjects in the same scope and exits with 1 if there are any
+func overlaps(args []string) int {
+	fs := flag.NewFlagSet("overlaps", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: rbac-manager overlaps [-o table|json]")
+		fs.PrintDefaults()
+	}
+	output := fs.String("o", "table", "Output format, table or json")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 0 {
+		fs.Usage()
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		logrus.Errorf("unknown output format %v, expected table or json", *output)
+		return 2
+	}
+
+	rbacDefs, err := kube.GetRbacDefinitions()
+	if err != nil {
+		logrus.Errorf("cannot list RBAC Definitions: %v", err)
+		return 1
+	}
+	accesses, err := access.Expand(kube.GetClientsetOrDie(), rbacDefs.Items)
+	if err != nil {
+		logrus.Error(err)
+		return 1
+	}
+	found := access.Overlaps(accesses)
+
+	if *output == "json" {
+		out, err := json.MarshalIndent(found, "", "  ")
+		if err != nil {
+			logrus.Error(err)
+			return 1
+		}
+		fmt.Fprintln(os.Stdout, string(out))
+	} else {
+		printOverlaps(os.Stdout, found)
+	}
+
+	if len(found) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printOverlaps(w io.Writer, overlaps []access.Overlap) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROLE\tNAMESPACE\tRBACDEFINITIONS\tSUBJECTS")
+	for _, overlap := range overlaps {
+		namespace := overlap.Namespace
+		if namespace == "" {
+			namespace = "*"
+		}
+		subjects := []string{}
+		for _, subject := range overlap.Subjects {
+			name := subject.Name
+			if subject.Namespace != "" {
+				name = subject.Namespace + "/" + name
+			}
+			subjects = append(subjects, subject.Kind+" "+name)
+		}
+		fmt.Fprintf(tw, "%v/%v\t%v\t%v\t%v\n", overlap.RoleRef.Kind, overlap.RoleRef.Name, namespace,
+			strings.Join(overlap.RBACDefinitions, ", "), strings.Join(subjects, ", "))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/schlapzz/rbac-manager/pkg/access"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// commands are run instead of the manager when named by the first argument
+var commands = map[string]func(args []string) int{
+	"who-can":    whoCan,
+	"subjects":   subjects,
+	"check":      check,
+	"report":     report,
+	"manifests":  printManifests,
+	"overlaps":   overlaps,
+	"namespaces": namespaces,
+}
+
+func whoCan(args []string) int {
+	fs := flag.NewFlagSet("who-can", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: rbac-manager who-can <verb> <resource> [-n namespace] [-o table|json]")
+		fs.PrintDefaults()
+	}
+	var namespace string
+	fs.StringVar(&namespace, "n", "", "Namespace to check, all namespaces if empty")
+	fs.StringVar(&namespace, "namespace", "", "Namespace to check, all namespaces if empty")
+	output := fs.String("o", "table", "Output format, table or json")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	accesses, err := loadAccess()
+	if err != nil {
+		logrus.Error(err)
+		return 1
+	}
+
+	return printAccess(os.Stdout, access.WhoCan(accesses, positional[0], positional[1], namespace), *output)
+}
+
+func subjects(args []string) int {
+	fs := flag.NewFlagSet("subjects", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: rbac-manager subjects <name> [-o table|json]")
+		fs.PrintDefaults()
+	}
+	output := fs.String("o", "table", "Output format, table or json")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	accesses, err := loadAccess()
+	if err != nil {
+		logrus.Error(err)
+		return 1
+	}
+
+	return printAccess(os.Stdout, access.Subjects(accesses, positional[0]), *output)
+}
+
+// parseInterspersed parses flags that may appear before, between, or after
+// positional arguments and returns the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func loadAccess() ([]access.Access, error) {
+	rbacDefs, err := kube.GetRbacDefinitions()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list RBAC Definitions: %v", err)
+	}
+	return access.Load(kube.GetClientsetOrDie(), rbacDefs.Items)
+}
+
+func printAccess(w io.Writer, accesses []access.Access, output string) int {
+	switch output {
+	case "json":
+		out, err := json.MarshalIndent(accesses, "", "  ")
+		if err != nil {
+			logrus.Error(err)
+			return 1
+		}
+		fmt.Fprintln(w, string(out))
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KIND\tSUBJECT\tNAMESPACE\tROLE\tRBACDEFINITION\tRBACBINDING")
+		for _, a := range accesses {
+			subject := a.Subject.Name
+			if a.Subject.Namespace != "" {
+				subject = a.Subject.Namespace + "/" + subject
+			}
+			namespace := a.Namespace
+			if namespace == "" {
+				namespace = "*"
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v/%v\t%v\t%v\n", a.Subject.Kind, subject, namespace, a.RoleRef.Kind, a.RoleRef.Name, a.RBACDefinition, a.RBACBinding)
+		}
+		tw.Flush()
+	default:
+		logrus.Errorf("unknown output format %v, expected table or json", output)
+		return 2
+	}
+	return 0
+}
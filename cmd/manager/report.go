@@ -0,0 +1,176 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/schlapzz/rbac-manager/pkg/access"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// report prints every binding RBAC Manager renders as one row per subject
+// for access reviews
+func report(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: rbac-manager report [--format=csv|json] [--include-unmanaged] [--include-rules]")
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "csv", "Output format, csv or json")
+	includeUnmanaged := fs.Bool("include-unmanaged", false, "Include bindings in the cluster that RBAC Manager does not manage")
+	includeRules := fs.Bool("include-rules", false, "Include the rules of the bound roles")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 0 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "csv" && *format != "json" {
+		logrus.Errorf("unknown format %v, expected csv or json", *format)
+		return 2
+	}
+
+	rbacDefs, err := kube.GetRbacDefinitions()
+	if err != nil {
+		logrus.Errorf("cannot list RBAC Definitions: %v", err)
+		return 1
+	}
+
+	clientset := kube.GetClientsetOrDie()
+	accesses, err := access.Expand(clientset, rbacDefs.Items)
+	if err != nil {
+		logrus.Error(err)
+		return 1
+	}
+
+	if *includeUnmanaged {
+		unmanaged, err := access.Unmanaged(clientset)
+		if err != nil {
+			logrus.Errorf("cannot list bindings: %v", err)
+			return 1
+		}
+		accesses = append(accesses, unmanaged...)
+	}
+
+	if *includeRules {
+		err = access.ResolveRules(clientset, accesses)
+		if err != nil {
+			logrus.Errorf("cannot read roles: %v", err)
+			return 1
+		}
+	}
+
+	if *format == "json" {
+		out, err := json.MarshalIndent(accesses, "", "  ")
+		if err != nil {
+			logrus.Error(err)
+			return 1
+		}
+		fmt.Fprintln(os.Stdout, string(out))
+		return 0
+	}
+
+	err = writeReportCSV(os.Stdout, accesses, *includeRules)
+	if err != nil {
+		logrus.Error(err)
+		return 1
+	}
+	return 0
+}
+
+func writeReportCSV(w io.Writer, accesses []access.Access, includeRules bool) error {
+	cw := csv.NewWriter(w)
+	header := []string{"subject", "subjectKind", "subjectNamespace", "roleKind", "role", "scope", "binding", "managed", "rbacDefinition", "rbacBinding"}
+	if includeRules {
+		header = append(header, "rules")
+	}
+	err := cw.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range accesses {
+		scope := a.Namespace
+		if scope == "" {
+			scope = "cluster"
+		}
+		row := []string{
+			a.Subject.Name, a.Subject.Kind, a.Subject.Namespace,
+			a.RoleRef.Kind, a.RoleRef.Name, scope, a.Binding,
+			strconv.FormatBool(a.Managed), a.RBACDefinition, a.RBACBinding,
+		}
+		if includeRules {
+			row = append(row, formatRules(a.Rules))
+		}
+		err = cw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatRules renders rules as verbs:resources pairs separated by semicolons,
+// with API groups and resource names included when the rule sets them
+func formatRules(rules []rbacv1.PolicyRule) string {
+	formatted := []string{}
+	for _, rule := range rules {
+		var resources []string
+		if len(rule.NonResourceURLs) > 0 {
+			resources = rule.NonResourceURLs
+		} else {
+			for _, resource := range rule.Resources {
+				for _, group := range groupsOrCore(rule.APIGroups) {
+					if group == "" {
+						resources = append(resources, resource)
+					} else {
+						resources = append(resources, resource+"."+group)
+					}
+				}
+			}
+		}
+		entry := strings.Join(rule.Verbs, ",") + ":" + strings.Join(resources, ",")
+		if len(rule.ResourceNames) > 0 {
+			entry += "[" + strings.Join(rule.ResourceNames, ",") + "]"
+		}
+		formatted = append(formatted, entry)
+	}
+	return strings.Join(formatted, ";")
+}
+
+func groupsOrCore(groups []string) []string {
+	if len(groups) == 0 {
+		return []string{""}
+	}
+	return groups
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/schlapzz/rbac-manager/pkg/watcher"
+)
+
+// handleSignals resyncs every RBAC Definition on SIGHUP and makes logging more
+// verbose on SIGUSR1 and less verbose on SIGUSR2 until ctx is done
+func handleSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				switch sig {
+				case syscall.SIGHUP:
+					logrus.Info("Received SIGHUP, resyncing all RBAC Definitions")
+					if err := watcher.Resync(); err != nil {
+						logrus.Errorf("Error resyncing RBAC Definitions: %v", err)
+					}
+				case syscall.SIGUSR1:
+					setLogLevel(shiftLogLevel(logrus.GetLevel(), 1))
+				case syscall.SIGUSR2:
+					setLogLevel(shiftLogLevel(logrus.GetLevel(), -1))
+				}
+			}
+		}
+	}()
+}
+
+// shiftLogLevel returns the level delta steps more verbose than level, kept
+// between error and trace
+func shiftLogLevel(level logrus.Level, delta int) logrus.Level {
+	shifted := int(level) + delta
+	if shifted < int(logrus.ErrorLevel) {
+		return logrus.ErrorLevel
+	}
+	if shifted > int(logrus.TraceLevel) {
+		return logrus.TraceLevel
+	}
+	return logrus.Level(shifted)
+}
+
+func setLogLevel(level logrus.Level) {
+	logrus.SetLevel(level)
+	// Logged at warning so the change is visible at every level it can be set to
+	logrus.Warnf("Log level set to %v", level)
+}
@@ -0,0 +1,27 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package deploy holds the manifests RBAC Manager is installed with, and
+// makes the Custom Resource Definitions available to the binary
+package deploy
+
+import (
+	// Embedding the CRDs
+	_ "embed"
+)
+
+// CRDs holds the Custom Resource Definitions of RBAC Manager as YAML
+//
+//go:embed 2_crd.yaml
+var CRDs string
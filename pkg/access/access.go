@@ -0,0 +1,284 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package access answers questions about the access RBAC Definitions grant
+package access
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+// Access is a subject bound to a role by a binding an RBAC Definition manages
+type Access struct {
+	Subject rbacv1.Subject `json:"subject"`
+	// Namespace the access is limited to, empty for Cluster Role Bindings
+	Namespace string `json:"namespace,omitempty"`
+	// Managed is false for bindings RBAC Manager did not create, which have no
+	// RBACDefinition and RBACBinding
+	Managed        bool                `json:"managed"`
+	RBACDefinition string              `json:"rbacDefinition,omitempty"`
+	RBACBinding    string              `json:"rbacBinding,omitempty"`
+	Binding        string              `json:"binding"`
+	RoleRef        rbacv1.RoleRef      `json:"roleRef"`
+	Rules          []rbacv1.PolicyRule `json:"rules,omitempty"`
+}
+
+// Load expands rbacDefs and returns the access each binding they manage grants,
+// with the rules of the bound roles read from the cluster
+func Load(clientset kubernetes.Interface, rbacDefs []rbacmanagerv1beta1.RBACDefinition) ([]Access, error) {
+	accesses, err := Expand(clientset, rbacDefs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ResolveRules(clientset, accesses)
+	if err != nil {
+		return nil, err
+	}
+	return accesses, nil
+}
+
+// Expand returns the access each binding managed by rbacDefs grants, without
+// the rules of the bound roles
+func Expand(clientset kubernetes.Interface, rbacDefs []rbacmanagerv1beta1.RBACDefinition) ([]Access, error) {
+	definitions := map[string]rbacmanagerv1beta1.RBACDefinition{}
+	for _, rbacDef := range rbacDefs {
+		definitions[rbacDef.Name] = rbacDef
+	}
+	getDefinition := func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+		rbacDef, ok := definitions[name]
+		if !ok {
+			return rbacDef, apierrors.NewNotFound(schema.GroupResource{Group: rbacmanagerv1beta1.SchemeGroupVersion.Group, Resource: "rbacdefinitions"}, name)
+		}
+		return rbacDef, nil
+	}
+
+	accesses := []Access{}
+	for _, rbacDef := range rbacDefs {
+		p := reconciler.Parser{Clientset: clientset, GetDefinition: getDefinition}
+		expanded, err := p.Expand(rbacDef)
+		if err != nil {
+			return nil, fmt.Errorf("cannot expand RBACDefinition %v: %v", rbacDef.Name, err)
+		}
+
+		for _, entry := range expanded {
+			for _, crb := range entry.ClusterRoleBindings {
+				for _, subject := range crb.Subjects {
+					accesses = append(accesses, Access{
+						Subject:        subject,
+						Managed:        true,
+						RBACDefinition: rbacDef.Name,
+						RBACBinding:    entry.RBACBinding,
+						Binding:        crb.Name,
+						RoleRef:        crb.RoleRef,
+					})
+				}
+			}
+
+			for _, rb := range entry.RoleBindings {
+				for _, subject := range rb.Subjects {
+					accesses = append(accesses, Access{
+						Subject:        subject,
+						Namespace:      rb.Namespace,
+						Managed:        true,
+						RBACDefinition: rbacDef.Name,
+						RBACBinding:    entry.RBACBinding,
+						Binding:        rb.Name,
+						RoleRef:        rb.RoleRef,
+					})
+				}
+			}
+		}
+	}
+
+	return accesses, nil
+}
+
+// Unmanaged returns the access granted by bindings in the cluster that RBAC
+// Manager did not create, without the rules of the bound roles
+func Unmanaged(clientset kubernetes.Interface) ([]Access, error) {
+	accesses := []Access{}
+
+	crbs, err := kube.RBAC(clientset).ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, crb := range crbs.Items {
+		if crb.Labels[kube.LabelKey] == kube.LabelValue {
+			continue
+		}
+		for _, subject := range crb.Subjects {
+			accesses = append(accesses, Access{Subject: subject, Binding: crb.Name, RoleRef: crb.RoleRef})
+		}
+	}
+
+	rbs, err := kube.RBAC(clientset).RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, rb := range rbs.Items {
+		if rb.Labels[kube.LabelKey] == kube.LabelValue {
+			continue
+		}
+		for _, subject := range rb.Subjects {
+			accesses = append(accesses, Access{Subject: subject, Namespace: rb.Namespace, Binding: rb.Name, RoleRef: rb.RoleRef})
+		}
+	}
+
+	return accesses, nil
+}
+
+// ResolveRules sets the rules of every access to those of the role it binds
+func ResolveRules(clientset kubernetes.Interface, accesses []Access) error {
+	rules := ruleCache{clientset: clientset, rules: map[string][]rbacv1.PolicyRule{}}
+	for i := range accesses {
+		roleRules, err := rules.get(accesses[i].Namespace, accesses[i].RoleRef)
+		if err != nil {
+			return err
+		}
+		accesses[i].Rules = roleRules
+	}
+	return nil
+}
+
+// WhoCan returns the access that allows verb on resource in namespace. The
+// resource may include an API group and a subresource, as in
+// deployments.apps or pods/log. An empty namespace matches every namespace.
+func WhoCan(accesses []Access, verb, resource, namespace string) []Access {
+	group, resource := splitResource(resource)
+
+	matching := []Access{}
+	for _, access := range accesses {
+		if namespace != "" && access.Namespace != "" && access.Namespace != namespace {
+			continue
+		}
+		for _, rule := range access.Rules {
+			if ruleAllows(&rule, verb, group, resource) {
+				matching = append(matching, access)
+				break
+			}
+		}
+	}
+	return matching
+}
+
+// Subjects returns the access granted to subjects named name. Service
+// Accounts can also be named by their username,
+// system:serviceaccount:namespace:name.
+func Subjects(accesses []Access, name string) []Access {
+	matching := []Access{}
+	for _, access := range accesses {
+		subject := access.Subject
+		if subject.Name == name ||
+			(subject.Kind == rbacv1.ServiceAccountKind && fmt.Sprintf("system:serviceaccount:%v:%v", subject.Namespace, subject.Name) == name) {
+			matching = append(matching, access)
+		}
+	}
+	return matching
+}
+
+// splitResource splits resource.group into its group and resource
+func splitResource(resource string) (string, string) {
+	name := resource
+	subresource := ""
+	if index := strings.Index(resource, "/"); index >= 0 {
+		name, subresource = resource[:index], resource[index:]
+	}
+
+	if index := strings.Index(name, "."); index >= 0 {
+		return name[index+1:], name[:index] + subresource
+	}
+	return "", resource
+}
+
+// ruleAllows reports whether rule allows verb on resource. An empty group
+// matches rules for any API group.
+func ruleAllows(rule *rbacv1.PolicyRule, verb, group, resource string) bool {
+	if !containsOrWildcard(rule.Verbs, verb) {
+		return false
+	}
+	if group != "" && !containsOrWildcard(rule.APIGroups, group) {
+		return false
+	}
+	return containsOrWildcard(rule.Resources, resource)
+}
+
+func containsOrWildcard(values []string, value string) bool {
+	for _, v := range values {
+		if v == rbacv1.ResourceAll || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleCache reads the rules of each role once
+type ruleCache struct {
+	clientset kubernetes.Interface
+	rules     map[string][]rbacv1.PolicyRule
+}
+
+func (c *ruleCache) get(namespace string, roleRef rbacv1.RoleRef) ([]rbacv1.PolicyRule, error) {
+	if roleRef.Kind == "ClusterRole" {
+		namespace = ""
+	}
+	key := fmt.Sprintf("%v/%v/%v", roleRef.Kind, namespace, roleRef.Name)
+	if rules, ok := c.rules[key]; ok {
+		return rules, nil
+	}
+
+	var rules []rbacv1.PolicyRule
+	var err error
+	if roleRef.Kind == "ClusterRole" {
+		var clusterRole *rbacv1.ClusterRole
+		clusterRole, err = kube.RBAC(c.clientset).ClusterRoles().Get(context.TODO(), roleRef.Name, metav1.GetOptions{})
+		if err == nil {
+			rules = clusterRole.Rules
+		}
+	} else {
+		var role *rbacv1.Role
+		role, err = kube.RBAC(c.clientset).Roles(namespace).Get(context.TODO(), roleRef.Name, metav1.GetOptions{})
+		if err == nil {
+			rules = role.Rules
+		}
+	}
+
+	if apierrors.IsNotFound(err) {
+		// Bindings to missing roles grant nothing until the role is created
+		logrus.Warnf("%v %v referenced by RBAC Manager does not exist", roleRef.Kind, roleRef.Name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if rules == nil {
+		rules = []rbacv1.PolicyRule{}
+	}
+	c.rules[key] = rules
+	return rules, nil
+}
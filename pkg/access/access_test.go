@@ -0,0 +1,166 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package access
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+func TestWhoCanAndSubjects(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "secret-reader"},
+			Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get", "list"}}},
+		},
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster-admin"},
+			Rules:      []rbacv1.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}}},
+		},
+		&rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "deployer", Namespace: "web"},
+			Rules:      []rbacv1.PolicyRule{{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"update"}}},
+		},
+	)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "audit"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "admins",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "sre"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "cluster-admin",
+		}},
+	}, {
+		Name:     "payments-readers",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "alice"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "payments",
+			ClusterRole: "secret-reader",
+		}},
+	}, {
+		Name: "web-deployers",
+		Subjects: []rbacmanagerv1beta1.Subject{
+			{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "alice"}},
+			{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Namespace: "ci", Name: "deployer"}},
+		},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace: "web",
+			Role:      "deployer",
+		}, {
+			Namespace: "web",
+			Role:      "missing",
+		}},
+	}}
+
+	accesses, err := Load(client, []rbacmanagerv1beta1.RBACDefinition{rbacDef})
+	assert.NoError(t, err)
+	assert.Len(t, accesses, 4, "bindings to missing roles are not created")
+	assert.True(t, accesses[0].Managed)
+
+	secrets := WhoCan(accesses, "get", "secrets", "payments")
+	if assert.Len(t, secrets, 2) {
+		assert.Equal(t, "sre", secrets[0].Subject.Name)
+		assert.Equal(t, "", secrets[0].Namespace)
+		assert.Equal(t, "admins", secrets[0].RBACBinding)
+		assert.Equal(t, "alice", secrets[1].Subject.Name)
+		assert.Equal(t, "payments", secrets[1].Namespace)
+		assert.Equal(t, "audit", secrets[1].RBACDefinition)
+		assert.Equal(t, "payments-readers", secrets[1].RBACBinding)
+		assert.Equal(t, "audit-payments-readers-secret-reader", secrets[1].Binding)
+	}
+
+	assert.Len(t, WhoCan(accesses, "get", "secrets", "web"), 1)
+	assert.Len(t, WhoCan(accesses, "delete", "secrets", "payments"), 1)
+	assert.Len(t, WhoCan(accesses, "update", "deployments.apps", "web"), 3)
+	assert.Len(t, WhoCan(accesses, "update", "deployments.extensions", "web"), 1)
+	assert.Len(t, WhoCan(accesses, "update", "deployments", ""), 3)
+
+	alice := Subjects(accesses, "alice")
+	assert.Len(t, alice, 2)
+
+	deployer := Subjects(accesses, "system:serviceaccount:ci:deployer")
+	if assert.Len(t, deployer, 1) {
+		assert.Equal(t, rbacv1.RoleRef{Kind: "Role", Name: "deployer"}, deployer[0].RoleRef)
+	}
+}
+
+func TestUnmanaged(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "view"},
+			Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+		},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "managed", Labels: map[string]string{kube.LabelKey: kube.LabelValue}},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "alice"}},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"},
+		},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "hand-made"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "bob"}},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"},
+		},
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "hand-made", Namespace: "web"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "web-team"}},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"},
+		},
+	)
+
+	accesses, err := Unmanaged(client)
+	assert.NoError(t, err)
+	if assert.Len(t, accesses, 2) {
+		assert.Equal(t, "bob", accesses[0].Subject.Name)
+		assert.Equal(t, "", accesses[0].Namespace)
+		assert.False(t, accesses[0].Managed)
+		assert.Empty(t, accesses[0].Rules, "rules should only be read on request")
+		assert.Equal(t, "web-team", accesses[1].Subject.Name)
+		assert.Equal(t, "web", accesses[1].Namespace)
+	}
+
+	assert.NoError(t, ResolveRules(client, accesses))
+	assert.Equal(t, []string{"pods"}, accesses[1].Rules[0].Resources)
+}
+
+func TestSplitResource(t *testing.T) {
+	group, resource := splitResource("secrets")
+	assert.Equal(t, "", group)
+	assert.Equal(t, "secrets", resource)
+
+	group, resource = splitResource("deployments.apps")
+	assert.Equal(t, "apps", group)
+	assert.Equal(t, "deployments", resource)
+
+	group, resource = splitResource("deployments.apps/scale")
+	assert.Equal(t, "apps", group)
+	assert.Equal(t, "deployments/scale", resource)
+
+	group, resource = splitResource("pods/log")
+	assert.Equal(t, "", group)
+	assert.Equal(t, "pods/log", resource)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package access
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/kubernetes"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// Overlap is a role that two RBAC Definitions both grant to the same subjects
+// in the same namespace, or cluster wide if Namespace is empty
+type Overlap struct {
+	RoleRef   rbacv1.RoleRef `json:"roleRef"`
+	Namespace string         `json:"namespace,omitempty"`
+	// RBACDefinitions are the names of the two definitions, sorted
+	RBACDefinitions []string         `json:"rbacDefinitions"`
+	Subjects        []rbacv1.Subject `json:"subjects"`
+}
+
+// Overlaps returns the grants of accesses that more than one RBAC Definition
+// makes, one for every role, scope, and pair of definitions. Subjects only
+// overlap if they have the same kind, name, and namespace. Unmanaged access is
+// ignored.
+func Overlaps(accesses []Access) []Overlap {
+	type grant struct {
+		roleRef   rbacv1.RoleRef
+		namespace string
+		subject   string
+	}
+	definitions := map[grant]map[string]bool{}
+	subjects := map[string]rbacv1.Subject{}
+	for _, a := range accesses {
+		if !a.Managed {
+			continue
+		}
+		key := grant{roleRef: a.RoleRef, namespace: a.Namespace, subject: subjectKey(a.Subject)}
+		if definitions[key] == nil {
+			definitions[key] = map[string]bool{}
+		}
+		definitions[key][a.RBACDefinition] = true
+		subjects[key.subject] = a.Subject
+	}
+
+	type pair struct {
+		roleRef   rbacv1.RoleRef
+		namespace string
+		first     string
+		second    string
+	}
+	overlaps := map[pair]*Overlap{}
+	for key, names := range definitions {
+		if len(names) < 2 {
+			continue
+		}
+		sorted := []string{}
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+		for i := range sorted {
+			for j := i + 1; j < len(sorted); j++ {
+				p := pair{roleRef: key.roleRef, namespace: key.namespace, first: sorted[i], second: sorted[j]}
+				if overlaps[p] == nil {
+					overlaps[p] = &Overlap{RoleRef: key.roleRef, Namespace: key.namespace, RBACDefinitions: []string{sorted[i], sorted[j]}}
+				}
+				overlaps[p].Subjects = append(overlaps[p].Subjects, subjects[key.subject])
+			}
+		}
+	}
+
+	result := []Overlap{}
+	for _, overlap := range overlaps {
+		sort.Slice(overlap.Subjects, func(i, j int) bool {
+			return subjectKey(overlap.Subjects[i]) < subjectKey(overlap.Subjects[j])
+		})
+		result = append(result, *overlap)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return overlapKey(&result[i]) < overlapKey(&result[j])
+	})
+	return result
+}
+
+// ReportOverlaps logs every overlap between rbacDefs and records the number
+// of overlapping subjects of each pair of definitions in the
+// overlapping_grants metric
+func ReportOverlaps(clientset kubernetes.Interface, rbacDefs []rbacmanagerv1beta1.RBACDefinition) ([]Overlap, error) {
+	accesses, err := Expand(clientset, rbacDefs)
+	if err != nil {
+		return nil, err
+	}
+
+	overlaps := Overlaps(accesses)
+	metrics.OverlappingGrants.Reset()
+	for _, overlap := range overlaps {
+		logrus.Warnf("RBACDefinitions %v and %v both bind %v %v %v to %v", overlap.RBACDefinitions[0], overlap.RBACDefinitions[1],
+			overlap.RoleRef.Kind, overlap.RoleRef.Name, overlapScope(&overlap), subjectNames(overlap.Subjects))
+		metrics.OverlappingGrants.WithLabelValues(overlap.RBACDefinitions[0], overlap.RBACDefinitions[1]).Add(float64(len(overlap.Subjects)))
+	}
+	return overlaps, nil
+}
+
+// ReportOverlapsPeriodically calls ReportOverlaps with the RBAC Definitions
+// listDefinitions returns every interval until ctx is done
+func ReportOverlapsPeriodically(ctx context.Context, clientset kubernetes.Interface, listDefinitions func() (rbacmanagerv1beta1.RBACDefinitionList, error), interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			logrus.Debug("Looking for grants RBAC Definitions share")
+			rbacDefs, err := listDefinitions()
+			if err != nil {
+				logrus.Errorf("Error listing RBAC Definitions to look for overlapping grants: %v", err)
+				continue
+			}
+			if _, err := ReportOverlaps(clientset, rbacDefs.Items); err != nil {
+				logrus.Errorf("Error looking for overlapping grants: %v", err)
+			}
+		}
+	}
+}
+
+func subjectKey(subject rbacv1.Subject) string {
+	return subject.Kind + "/" + subject.Namespace + "/" + subject.Name
+}
+
+func overlapKey(overlap *Overlap) string {
+	return strings.Join([]string{overlap.Namespace, overlap.RoleRef.Kind, overlap.RoleRef.Name, overlap.RBACDefinitions[0], overlap.RBACDefinitions[1]}, "/")
+}
+
+func overlapScope(overlap *Overlap) string {
+	if overlap.Namespace == "" {
+		return "cluster wide"
+	}
+	return "in namespace " + overlap.Namespace
+}
+
+func subjectNames(subjects []rbacv1.Subject) string {
+	names := []string{}
+	for _, subject := range subjects {
+		name := subject.Kind + " " + subject.Name
+		if subject.Namespace != "" {
+			name = subject.Kind + " " + subject.Namespace + "/" + subject.Name
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
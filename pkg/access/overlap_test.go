@@ -0,0 +1,86 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package access
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestReportOverlaps(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "web"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "api", Labels: map[string]string{"team": "web"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "db"}},
+	)
+
+	byTeam := rbacmanagerv1beta1.RBACDefinition{}
+	byTeam.Name = "by-team"
+	byTeam.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "web-editors",
+		Subjects: []rbacmanagerv1beta1.Subject{
+			{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "web-devs"}},
+			{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "alice"}},
+		},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:       "edit",
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}},
+		}},
+	}}
+
+	byNamespace := rbacmanagerv1beta1.RBACDefinition{}
+	byNamespace.Name = "by-namespace"
+	byNamespace.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "web",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "web-devs"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}, {
+			Namespace:   "db",
+			ClusterRole: "edit",
+		}, {
+			Namespace:   "api",
+			ClusterRole: "view",
+		}},
+	}}
+
+	overlaps, err := ReportOverlaps(client, []rbacmanagerv1beta1.RBACDefinition{byTeam, byNamespace})
+	assert.NoError(t, err)
+	assert.Equal(t, []Overlap{{
+		RoleRef:         rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"},
+		Namespace:       "web",
+		RBACDefinitions: []string{"by-namespace", "by-team"},
+		Subjects:        []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "web-devs"}},
+	}}, overlaps)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.OverlappingGrants.WithLabelValues("by-namespace", "by-team")))
+
+	// Grants within one definition don't overlap
+	overlaps, err = ReportOverlaps(client, []rbacmanagerv1beta1.RBACDefinition{byTeam})
+	assert.NoError(t, err)
+	assert.Empty(t, overlaps)
+	assert.Equal(t, 0, testutil.CollectAndCount(metrics.OverlappingGrants))
+}
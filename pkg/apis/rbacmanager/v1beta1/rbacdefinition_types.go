@@ -25,8 +25,38 @@ import (
 type Subject struct {
 	rbacv1.Subject
 	ImagePullSecrets []string `json:"imagePullSecrets"`
+	// Annotations, Labels, and AutomountServiceAccountToken are set on the
+	// Service Account of a ServiceAccount subject. Changing them updates the
+	// Service Account in place.
+	Annotations                  map[string]string `json:"annotations,omitempty"`
+	Labels                       map[string]string `json:"labels,omitempty"`
+	AutomountServiceAccountToken *bool             `json:"automountServiceAccountToken,omitempty"`
+	// CreateServiceAccount set to false binds the Service Account of a
+	// ServiceAccount subject without creating it, for Service Accounts that
+	// something else owns. Defaults to defaults.createServiceAccount.
+	CreateServiceAccount *bool `json:"createServiceAccount,omitempty"`
+	// Selector and NamespaceSelector choose the existing Service Accounts a
+	// ServiceAccountSelector subject stands for
+	Selector          *metav1.LabelSelector `json:"selector,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// RawName uses the name of a User or Group subject as it is, without the
+	// prefix from the defaults
+	RawName bool `json:"rawName,omitempty"`
 }
 
+// ServiceAccountsInNamespaceKind is a subject kind standing for every Service
+// Account in the subject's namespace, which is bound as the
+// system:serviceaccounts:<namespace> Group
+const ServiceAccountsInNamespaceKind = "ServiceAccountsInNamespace"
+
+// ServiceAccountSelectorKind is a subject kind standing for every existing
+// Service Account matching the subject's selectors
+const ServiceAccountSelectorKind = "ServiceAccountSelector"
+
+// RBACDefinitionServiceAccountsKind is a subject kind standing for every
+// Service Account the RBAC Definition named by the subject creates
+const RBACDefinitionServiceAccountsKind = "RBACDefinitionServiceAccounts"
+
 // RBACBinding is a specification for a RBACBinding resource
 type RBACBinding struct {
 	Name                string               `json:"name"`
@@ -37,31 +67,321 @@ type RBACBinding struct {
 
 // ClusterRoleBinding is a specification for a ClusterRoleBinding resource
 type ClusterRoleBinding struct {
+	// Name of the Cluster Role Binding created for this entry, which defaults
+	// to the names of the RBAC Definition, the rbacBindings entry, and the
+	// ClusterRole. With LimitToNamespaceSelector it names the Role Bindings.
+	Name        string `json:"name,omitempty"`
 	ClusterRole string `json:"clusterRole"`
+	// LimitToNamespaceSelector grants the ClusterRole through a RoleBinding in
+	// each matching namespace instead of through a ClusterRoleBinding
+	LimitToNamespaceSelector *metav1.LabelSelector `json:"limitToNamespaceSelector,omitempty"`
 }
 
 // RoleBinding is a specification for a RoleBinding resource
 type RoleBinding struct {
-	ClusterRole       string               `json:"clusterRole,omitempty"`
-	Role              string               `json:"role,omitempty"`
+	// Name of the Role Bindings created for this entry, which defaults to the
+	// names of the RBAC Definition, the rbacBindings entry, and the role. It
+	// may be a template, rendered for every namespace.
+	Name        string `json:"name,omitempty"`
+	ClusterRole string `json:"clusterRole,omitempty"`
+	Role        string `json:"role,omitempty"`
+	// ClusterRoles and Roles bind several roles to the same subjects in the
+	// same namespaces, with one Role Binding per role. They can't be combined
+	// with ClusterRole, Role or RoleFrom.
+	ClusterRoles []string `json:"clusterRoles,omitempty"`
+	Roles        []string `json:"roles,omitempty"`
+	// RoleFrom binds a copy of a Role from another namespace, which is
+	// created in and kept in sync for every namespace the entry applies to
+	RoleFrom          *RoleSource          `json:"roleFrom,omitempty"`
 	Namespace         string               `json:"namespace,omitempty"`
+	Namespaces        []string             `json:"namespaces,omitempty"`
 	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// NamespaceAnnotationSelector selects namespaces by annotation. When set
+	// together with NamespaceSelector a namespace must match both.
+	NamespaceAnnotationSelector *NamespaceAnnotationSelector `json:"namespaceAnnotationSelector,omitempty"`
+	// NamespaceOwnedBy selects namespaces created by an operator. When set
+	// together with other selectors a namespace must match all of them.
+	NamespaceOwnedBy *NamespaceOwner `json:"namespaceOwnedBy,omitempty"`
+	// RequireNamespaceLabel is a label namespaces must also carry for Role
+	// Bindings to be created in them
+	RequireNamespaceLabel *NamespaceLabelRequirement `json:"requireNamespaceLabel,omitempty"`
+	// CreateIfMissing creates the namespaces named by Namespace and Namespaces
+	// that don't exist yet, labeled with NamespaceLabels
+	CreateIfMissing bool              `json:"createIfMissing,omitempty"`
+	NamespaceLabels map[string]string `json:"namespaceLabels,omitempty"`
+	// PropagateToChildren also creates the Role Bindings in every descendant
+	// of the matched namespaces in the Hierarchical Namespace Controller tree
+	PropagateToChildren bool `json:"propagateToChildren,omitempty"`
+	// RequireRole fails the RBAC Definition when the Role named by Role
+	// doesn't exist in a namespace the entry applies to. Otherwise those
+	// namespaces are skipped until the Role is created.
+	RequireRole bool `json:"requireRole,omitempty"`
+}
+
+// RoleSource locates the Role that is copied for a roleBindings entry
+type RoleSource struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// NamespaceAnnotationSelector matches namespaces by their annotations. A
+// namespace matches if it has every annotation in MatchAnnotations with the
+// given value and every annotation listed in Exists with any value.
+type NamespaceAnnotationSelector struct {
+	MatchAnnotations map[string]string `json:"matchAnnotations,omitempty"`
+	Exists           []string          `json:"exists,omitempty"`
+}
+
+// NamespaceOwner matches namespaces by the operator that created them. A
+// namespace matches if it has an owner reference of Kind named Name, or of
+// Kind with any name if Name is empty. Operators that record the owner in a
+// label instead are matched by Label, the key of a label whose value is Name.
+type NamespaceOwner struct {
+	Kind  string `json:"kind,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Label string `json:"label,omitempty"`
+}
+
+// NamespaceLabelRequirement is a label a namespace must carry, with any value
+// if Value is empty
+type NamespaceLabelRequirement struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// Defaults holds values used for fields that entries in an RBACDefinition leave unset
+type Defaults struct {
+	// ServiceAccountNamespace is used as the namespace of ServiceAccount subjects that don't specify one
+	ServiceAccountNamespace string `json:"serviceAccountNamespace,omitempty"`
+	// UserPrefix and GroupPrefix are prepended to the names of User and Group
+	// subjects, such as the prefixes an OIDC identity provider adds
+	UserPrefix  string `json:"userPrefix,omitempty"`
+	GroupPrefix string `json:"groupPrefix,omitempty"`
+	// CreateServiceAccount is used for ServiceAccount subjects that don't set
+	// createServiceAccount. Service Accounts are created unless it is false.
+	CreateServiceAccount *bool `json:"createServiceAccount,omitempty"`
+}
+
+// ConflictPolicy determines how a requested resource is handled when an
+// object with the same name exists that this RBAC Definition doesn't manage.
+// An empty ConflictPolicy behaves like ConflictPolicyFail.
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyFail leaves the existing object alone and reports the conflict
+	ConflictPolicyFail ConflictPolicy = "Fail"
+	// ConflictPolicySkip leaves the existing object alone and omits the requested resource
+	ConflictPolicySkip ConflictPolicy = "Skip"
+	// ConflictPolicyAdopt takes over the existing object if its roleRef matches
+	ConflictPolicyAdopt ConflictPolicy = "Adopt"
+)
+
+// DeletionPolicy determines what happens to the resources an RBAC Definition
+// manages when the RBAC Definition is deleted. An empty DeletionPolicy behaves
+// like DeletionPolicyDelete.
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes managed resources along with the RBAC Definition
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan leaves managed resources in place as unmanaged resources
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyDeleteNamespaces deletes managed resources along with the
+	// RBAC Definition, and the namespaces it created as well
+	DeletionPolicyDeleteNamespaces DeletionPolicy = "DeleteNamespaces"
+)
+
+// SyncMode determines whether an RBAC Definition is applied. An empty SyncMode
+// behaves like SyncModeFull.
+type SyncMode string
+
+const (
+	// SyncModeFull creates, updates, and deletes resources to match the RBAC Definition
+	SyncModeFull SyncMode = "Full"
+	// SyncModeReportOnly leaves resources alone and lists the changes a full
+	// sync would make in the status of the RBAC Definition
+	SyncModeReportOnly SyncMode = "ReportOnly"
+)
+
+// MaxPlannedChanges is the number of planned changes listed in the status of
+// an RBAC Definition
+const MaxPlannedChanges = 100
+
+// PlannedChange is a change that an RBAC Definition in the ReportOnly sync
+// mode would make
+type PlannedChange struct {
+	// Action is create, update or delete
+	Action    string `json:"action"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// RoleRef is the Kind/name of the role a binding refers to
+	RoleRef string `json:"roleRef,omitempty"`
+}
+
+// DefaultKubeconfigKey is the key of the kubeconfig in a Secret referenced
+// by a KubeconfigSecretReference that doesn't set one
+const DefaultKubeconfigKey = "kubeconfig"
+
+// RemoteCluster is another cluster an RBAC Definition is applied to in
+// addition to the cluster RBAC Manager runs in
+type RemoteCluster struct {
+	// Name identifies the cluster in status, events, and metrics
+	Name             string                    `json:"name"`
+	KubeconfigSecret KubeconfigSecretReference `json:"kubeconfigSecret"`
+}
+
+// KubeconfigSecretReference locates a kubeconfig stored in a Secret of the
+// cluster RBAC Manager runs in
+type KubeconfigSecretReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Key of the kubeconfig within the Secret, DefaultKubeconfigKey if empty
+	Key string `json:"key,omitempty"`
 }
 
+// ConditionResourceConflict is true when requested resources could not be
+// created because unmanaged objects with the same names exist
+const ConditionResourceConflict = "ResourceConflict"
+
+// ConditionBindingForbidden is true when RBAC Manager is not permitted to
+// bind some of the requested roles
+const ConditionBindingForbidden = "BindingForbidden"
+
+// ConditionValidationFailed is true when the API server rejected dry runs of
+// some of the requested resources, which were then not created
+const ConditionValidationFailed = "ValidationFailed"
+
+// ConditionBlockedByPolicy is true when admission webhooks denied the
+// creation of some of the requested resources
+const ConditionBlockedByPolicy = "BlockedByPolicy"
+
+// ConditionNoNamespacesMatched is true when namespace selectors of some
+// roleBindings entries match no namespace
+const ConditionNoNamespacesMatched = "NoNamespacesMatched"
+
+// ConditionEmptySubjects is true when the subjects of some rbacBindings
+// entries resolve to none, so that they create no bindings
+const ConditionEmptySubjects = "EmptySubjects"
+
+// ConditionRoleMissingInNamespaces is true when Roles referenced by some
+// roleBindings entries don't exist in namespaces the entries apply to
+const ConditionRoleMissingInNamespaces = "RoleMissingInNamespaces"
+
+// ConditionGuardLabelMissing is true when some roleBindings entries left out
+// namespaces that don't carry the label they require
+const ConditionGuardLabelMissing = "GuardLabelMissing"
+
+// ConditionNamespacesTrimmed is true when some requested bindings were left
+// out because namespace policies don't allow their namespaces
+const ConditionNamespacesTrimmed = "NamespacesTrimmed"
+
+// ConditionPruneBlocked is true when the prune limit withheld deleting
+// resources the RBAC Definition no longer requests
+const ConditionPruneBlocked = "PruneBlocked"
+
+// ConditionUnknownFieldsDetected is true when the configuration an RBAC
+// Definition was last applied with has fields the API server pruned
+const ConditionUnknownFieldsDetected = "UnknownFieldsDetected"
+
+// ConditionClusterSynced is true when an RBAC Definition was last applied to
+// a remote cluster successfully
+const ConditionClusterSynced = "Synced"
+
+// ConditionReady is true when the last reconcile of an RBAC Definition
+// succeeded and all of its resources are in place
+const ConditionReady = "Ready"
+
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
-// RBACDefinition is the Schema for the rbacdefinitions API
+// RBACDefinition is the Schema for the rbacdefinitions API. Imports names
+// other RBACDefinitions whose rbacBindings are included before its own.
+// MergeBindings coalesces generated bindings that grant the same role in the
+// same namespace into a single binding. NamespaceEvents records an event on a
+// namespace whenever a Role Binding is created or deleted in it. SyncMode
+// ReportOnly plans changes without making them.
 // +k8s:openapi-gen=true
 type RBACDefinition struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata"`
 	RBACBindings      []RBACBinding        `json:"rbacBindings"`
+	Defaults          Defaults             `json:"defaults,omitempty"`
+	ConflictPolicy    ConflictPolicy       `json:"conflictPolicy,omitempty"`
+	DeletionPolicy    DeletionPolicy       `json:"deletionPolicy,omitempty"`
+	Imports           []string             `json:"imports,omitempty"`
+	Clusters          []RemoteCluster      `json:"clusters,omitempty"`
+	MergeBindings     bool                 `json:"mergeBindings,omitempty"`
+	NamespaceEvents   bool                 `json:"namespaceEvents,omitempty"`
+	SyncMode          SyncMode             `json:"syncMode,omitempty"`
 	Status            RBACDefinitionStatus `json:"status,omitempty"`
 }
 
 // RBACDefinitionStatus defines the observed state of RBACDefinition
 type RBACDefinitionStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// ObservedGeneration is the generation that was last reconciled
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastSync is the value of the sync annotation that was last handled
+	LastSync string `json:"lastSync,omitempty"`
+	// PlannedChanges lists the changes a full sync would make while the sync
+	// mode is ReportOnly, up to MaxPlannedChanges of them.
+	// PlannedChangesOmitted counts the ones left out.
+	PlannedChanges        []PlannedChange `json:"plannedChanges,omitempty"`
+	PlannedChangesOmitted int             `json:"plannedChangesOmitted,omitempty"`
+	// Clusters holds the state of every remote cluster the RBAC Definition
+	// has resources in, including clusters that are being removed
+	Clusters []RemoteClusterStatus `json:"clusters,omitempty"`
+	// Desired counts the resources the last reconcile requested, and Actual
+	// those of them that were in place once it finished
+	Desired *ResourceCounts `json:"desired,omitempty"`
+	Actual  *ResourceCounts `json:"actual,omitempty"`
+	// LastSpecChange summarizes how the resources of the RBAC Definition
+	// changed when its spec last changed
+	LastSpecChange *SpecChange `json:"lastSpecChange,omitempty"`
+	// Fingerprint records the resources of the last reconcile to summarize
+	// the next spec change with
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+}
+
+// ResourceCounts counts the resources of an RBAC Definition by kind
+type ResourceCounts struct {
+	ServiceAccounts     int `json:"serviceAccounts"`
+	RoleBindings        int `json:"roleBindings"`
+	ClusterRoleBindings int `json:"clusterRoleBindings"`
+}
+
+// MaxFingerprintEntries is the number of resources an RBAC Definition can
+// have for them to be listed in its fingerprint
+const MaxFingerprintEntries = 500
+
+// Fingerprint is a compact record of the resources a generation of an RBAC
+// Definition requested
+type Fingerprint struct {
+	Generation int64 `json:"generation"`
+	// Count is the number of resources
+	Count int `json:"count"`
+	// Resources holds the kind, namespace, name, and a short hash of the spec
+	// of every resource, unless there are more than MaxFingerprintEntries
+	Resources []string `json:"resources,omitempty"`
+}
+
+// SpecChange summarizes the difference in resources between two generations
+// of an RBAC Definition
+type SpecChange struct {
+	// Generation is the generation the change led to
+	Generation int64  `json:"generation"`
+	Summary    string `json:"summary"`
+}
+
+// RemoteClusterStatus is the observed state of an RBAC Definition in a
+// remote cluster
+type RemoteClusterStatus struct {
+	Name string `json:"name"`
+	// KubeconfigSecret is kept so that resources can be removed after the
+	// cluster is dropped from the RBAC Definition
+	KubeconfigSecret KubeconfigSecretReference `json:"kubeconfigSecret"`
+	Conditions       []metav1.Condition        `json:"conditions,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
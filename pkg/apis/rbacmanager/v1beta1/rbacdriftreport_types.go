@@ -0,0 +1,92 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reasons of the results in an RBACDriftReport
+const (
+	// DriftReasonModified resources were changed outside of RBAC Manager and replaced
+	DriftReasonModified = "Modified"
+	// DriftReasonDeleted resources were deleted outside of RBAC Manager and created again
+	DriftReasonDeleted = "Deleted"
+	// DriftReasonRelabeled resources lost the labels or owner references RBAC
+	// Manager finds them by and had them restored
+	DriftReasonRelabeled = "Relabeled"
+	// DriftReasonReportOnly changes were not made because the RBAC Definition
+	// is in the ReportOnly sync mode
+	DriftReasonReportOnly = "ReportOnly"
+	// DriftReasonAdmissionDenied resources were not created because an
+	// admission webhook denied them
+	DriftReasonAdmissionDenied = "AdmissionDenied"
+)
+
+// MaxDriftResults is the number of repaired and of blocked resources listed
+// in an RBACDriftReport
+const MaxDriftResults = 100
+
+// DriftResult is a managed resource listed in an RBACDriftReport
+type DriftResult struct {
+	// Action is the change that was or would have been made, create or delete
+	Action    string `json:"action,omitempty"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+	Message   string `json:"message,omitempty"`
+}
+
+// DriftSummary counts the results of an RBACDriftReport, including those
+// left out of its lists
+type DriftSummary struct {
+	Repaired int `json:"repaired"`
+	Blocked  int `json:"blocked"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RBACDriftReport lists the drift RBAC Manager repaired during the last
+// reconcile of an RBAC Definition, and the changes it didn't make because the
+// definition is in report only mode or admission webhooks denied them. Each
+// RBAC Definition has a report with the same name that it owns.
+// +k8s:openapi-gen=true
+type RBACDriftReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	RBACDefinition    string `json:"rbacDefinition"`
+	// ObservedGeneration is the generation of the RBAC Definition the report
+	// was written for
+	ObservedGeneration int64         `json:"observedGeneration,omitempty"`
+	Summary            DriftSummary  `json:"summary"`
+	Repaired           []DriftResult `json:"repaired,omitempty"`
+	Blocked            []DriftResult `json:"blocked,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RBACDriftReportList contains a list of RBACDriftReport
+type RBACDriftReportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RBACDriftReport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RBACDriftReport{}, &RBACDriftReportList{})
+}
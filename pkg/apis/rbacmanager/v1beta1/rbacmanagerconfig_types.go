@@ -0,0 +1,111 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConfigName is the name of the only RBACManagerConfig RBAC Manager reads
+const ConfigName = "default"
+
+// ConditionConfigValid reports whether the spec of an RBACManagerConfig was applied
+const ConditionConfigValid = "Valid"
+
+// RBACManagerConfigSpec holds settings that replace the values of the
+// corresponding flags while RBAC Manager runs. Unset fields keep the flag
+// values.
+type RBACManagerConfigSpec struct {
+	SyncInterval      *metav1.Duration `json:"syncInterval,omitempty"`
+	Parallelism       *int             `json:"parallelism,omitempty"`
+	ForbiddenSubjects []string         `json:"forbiddenSubjects,omitempty"`
+	MaxImportDepth    *int             `json:"maxImportDepth,omitempty"`
+	// MaxPrunes limits the resources of one kind a reconcile may delete
+	// because an RBAC Definition no longer requests them, 0 for no limit
+	MaxPrunes *int `json:"maxPrunes,omitempty"`
+	// SubjectPatchLimit is the largest number of subjects of a Cluster Role
+	// Binding that are added and removed one by one, 0 to always update
+	// all of them
+	SubjectPatchLimit *int `json:"subjectPatchLimit,omitempty"`
+	// NamespacePolicies limit the namespaces RBAC Definitions can create
+	// Role Bindings in
+	NamespacePolicies []NamespacePolicy `json:"namespacePolicies,omitempty"`
+	// ChildLabels and ChildAnnotations are set on every resource RBAC
+	// Manager manages, beneath the labels and annotations requested for it
+	ChildLabels      map[string]string `json:"childLabels,omitempty"`
+	ChildAnnotations map[string]string `json:"childAnnotations,omitempty"`
+}
+
+// NamespacePolicy allows the RBAC Definitions matching DefinitionSelector to
+// create Role Bindings in the namespaces named by AllowedNamespaces or
+// matching AllowedNamespaceSelector. A definition several policies select
+// may use the namespaces any of them allows.
+type NamespacePolicy struct {
+	// DefinitionSelector selects RBAC Definitions by label, an empty
+	// selector selects every RBAC Definition
+	DefinitionSelector metav1.LabelSelector `json:"definitionSelector"`
+	// AllowedNamespaces are namespace names, which may contain shell patterns
+	AllowedNamespaces        []string              `json:"allowedNamespaces,omitempty"`
+	AllowedNamespaceSelector *metav1.LabelSelector `json:"allowedNamespaceSelector,omitempty"`
+}
+
+// EffectiveConfig shows the settings RBAC Manager is using
+type EffectiveConfig struct {
+	SyncInterval      string   `json:"syncInterval"`
+	Parallelism       int      `json:"parallelism"`
+	ForbiddenSubjects []string `json:"forbiddenSubjects,omitempty"`
+	MaxImportDepth    int      `json:"maxImportDepth"`
+	MaxPrunes         int      `json:"maxPrunes,omitempty"`
+	SubjectPatchLimit int      `json:"subjectPatchLimit"`
+	// NamespacePolicies is the number of namespace policies in use
+	NamespacePolicies int               `json:"namespacePolicies,omitempty"`
+	ChildLabels       map[string]string `json:"childLabels,omitempty"`
+	ChildAnnotations  map[string]string `json:"childAnnotations,omitempty"`
+}
+
+// RBACManagerConfigStatus defines the observed state of RBACManagerConfig
+type RBACManagerConfigStatus struct {
+	Effective  *EffectiveConfig   `json:"effective,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RBACManagerConfig is the Schema for the rbacmanagerconfigs API
+// +k8s:openapi-gen=true
+type RBACManagerConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              RBACManagerConfigSpec   `json:"spec,omitempty"`
+	Status            RBACManagerConfigStatus `json:"status,omitempty"`
+}
+
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RBACManagerConfigList contains a list of RBACManagerConfig
+type RBACManagerConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RBACManagerConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RBACManagerConfig{}, &RBACManagerConfigList{})
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GrantPhase is the lifecycle phase of an RBACTemporaryGrant
+type GrantPhase string
+
+const (
+	// GrantPhasePending grants are waiting for approval
+	GrantPhasePending GrantPhase = "Pending"
+	// GrantPhaseActive grants have been approved and their bindings exist
+	GrantPhaseActive GrantPhase = "Active"
+	// GrantPhaseExpired grants have been revoked after their duration passed
+	GrantPhaseExpired GrantPhase = "Expired"
+	// GrantPhaseFailed grants reference a template that can't be used
+	GrantPhaseFailed GrantPhase = "Failed"
+)
+
+// RBACTemporaryGrantSpec requests the roles of an rbacBindings entry for a
+// limited time. The roles are bound in the namespace of the grant.
+type RBACTemporaryGrantSpec struct {
+	// RBACDefinition and RBACBinding name the rbacBindings entry whose roles are granted
+	RBACDefinition string `json:"rbacDefinition"`
+	RBACBinding    string `json:"rbacBinding"`
+	// Requester is the user that receives the roles
+	Requester string          `json:"requester"`
+	Duration  metav1.Duration `json:"duration"`
+	// Approved can only be set by holders of the approver role
+	Approved bool `json:"approved,omitempty"`
+}
+
+// RBACTemporaryGrantStatus defines the observed state of RBACTemporaryGrant
+type RBACTemporaryGrantStatus struct {
+	Phase     GrantPhase   `json:"phase,omitempty"`
+	Message   string       `json:"message,omitempty"`
+	GrantedAt *metav1.Time `json:"grantedAt,omitempty"`
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RBACTemporaryGrant is the Schema for the rbactemporarygrants API
+// +k8s:openapi-gen=true
+type RBACTemporaryGrant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              RBACTemporaryGrantSpec   `json:"spec"`
+	Status            RBACTemporaryGrantStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RBACTemporaryGrantList contains a list of RBACTemporaryGrant
+type RBACTemporaryGrantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RBACTemporaryGrant `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RBACTemporaryGrant{}, &RBACTemporaryGrantList{})
+}
@@ -5,12 +5,18 @@
 package v1beta1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterRoleBinding) DeepCopyInto(out *ClusterRoleBinding) {
 	*out = *in
+	if in.LimitToNamespaceSelector != nil {
+		in, out := &in.LimitToNamespaceSelector, &out.LimitToNamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -24,23 +30,256 @@ func (in *ClusterRoleBinding) DeepCopy() *ClusterRoleBinding {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Defaults) DeepCopyInto(out *Defaults) {
+	*out = *in
+	if in.CreateServiceAccount != nil {
+		in, out := &in.CreateServiceAccount, &out.CreateServiceAccount
+		*out = new(bool)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Defaults.
+func (in *Defaults) DeepCopy() *Defaults {
+	if in == nil {
+		return nil
+	}
+	out := new(Defaults)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DriftResult) DeepCopyInto(out *DriftResult) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriftResult.
+func (in *DriftResult) DeepCopy() *DriftResult {
+	if in == nil {
+		return nil
+	}
+	out := new(DriftResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DriftSummary) DeepCopyInto(out *DriftSummary) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriftSummary.
+func (in *DriftSummary) DeepCopy() *DriftSummary {
+	if in == nil {
+		return nil
+	}
+	out := new(DriftSummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EffectiveConfig) DeepCopyInto(out *EffectiveConfig) {
+	*out = *in
+	if in.ForbiddenSubjects != nil {
+		in, out := &in.ForbiddenSubjects, &out.ForbiddenSubjects
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ChildLabels != nil {
+		in, out := &in.ChildLabels, &out.ChildLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ChildAnnotations != nil {
+		in, out := &in.ChildAnnotations, &out.ChildAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EffectiveConfig.
+func (in *EffectiveConfig) DeepCopy() *EffectiveConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(EffectiveConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Fingerprint) DeepCopyInto(out *Fingerprint) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Fingerprint.
+func (in *Fingerprint) DeepCopy() *Fingerprint {
+	if in == nil {
+		return nil
+	}
+	out := new(Fingerprint)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeconfigSecretReference) DeepCopyInto(out *KubeconfigSecretReference) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeconfigSecretReference.
+func (in *KubeconfigSecretReference) DeepCopy() *KubeconfigSecretReference {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeconfigSecretReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespaceAnnotationSelector) DeepCopyInto(out *NamespaceAnnotationSelector) {
+	*out = *in
+	if in.MatchAnnotations != nil {
+		in, out := &in.MatchAnnotations, &out.MatchAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Exists != nil {
+		in, out := &in.Exists, &out.Exists
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceAnnotationSelector.
+func (in *NamespaceAnnotationSelector) DeepCopy() *NamespaceAnnotationSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceAnnotationSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespaceLabelRequirement) DeepCopyInto(out *NamespaceLabelRequirement) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceLabelRequirement.
+func (in *NamespaceLabelRequirement) DeepCopy() *NamespaceLabelRequirement {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceLabelRequirement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespaceOwner) DeepCopyInto(out *NamespaceOwner) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceOwner.
+func (in *NamespaceOwner) DeepCopy() *NamespaceOwner {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceOwner)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacePolicy) DeepCopyInto(out *NamespacePolicy) {
+	*out = *in
+	in.DefinitionSelector.DeepCopyInto(&out.DefinitionSelector)
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedNamespaceSelector != nil {
+		in, out := &in.AllowedNamespaceSelector, &out.AllowedNamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacePolicy.
+func (in *NamespacePolicy) DeepCopy() *NamespacePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlannedChange) DeepCopyInto(out *PlannedChange) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlannedChange.
+func (in *PlannedChange) DeepCopy() *PlannedChange {
+	if in == nil {
+		return nil
+	}
+	out := new(PlannedChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RBACBinding) DeepCopyInto(out *RBACBinding) {
 	*out = *in
 	if in.Subjects != nil {
 		in, out := &in.Subjects, &out.Subjects
 		*out = make([]Subject, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	if in.ClusterRoleBindings != nil {
 		in, out := &in.ClusterRoleBindings, &out.ClusterRoleBindings
 		*out = make([]ClusterRoleBinding, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	if in.RoleBindings != nil {
 		in, out := &in.RoleBindings, &out.RoleBindings
 		*out = make([]RoleBinding, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	return
 }
@@ -67,7 +306,18 @@ func (in *RBACDefinition) DeepCopyInto(out *RBACDefinition) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
-	out.Status = in.Status
+	in.Defaults.DeepCopyInto(&out.Defaults)
+	if in.Imports != nil {
+		in, out := &in.Imports, &out.Imports
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Clusters != nil {
+		in, out := &in.Clusters, &out.Clusters
+		*out = make([]RemoteCluster, len(*in))
+		copy(*out, *in)
+	}
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -125,6 +375,45 @@ func (in *RBACDefinitionList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RBACDefinitionStatus) DeepCopyInto(out *RBACDefinitionStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PlannedChanges != nil {
+		in, out := &in.PlannedChanges, &out.PlannedChanges
+		*out = make([]PlannedChange, len(*in))
+		copy(*out, *in)
+	}
+	if in.Clusters != nil {
+		in, out := &in.Clusters, &out.Clusters
+		*out = make([]RemoteClusterStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Desired != nil {
+		in, out := &in.Desired, &out.Desired
+		*out = new(ResourceCounts)
+		**out = **in
+	}
+	if in.Actual != nil {
+		in, out := &in.Actual, &out.Actual
+		*out = new(ResourceCounts)
+		**out = **in
+	}
+	if in.LastSpecChange != nil {
+		in, out := &in.LastSpecChange, &out.LastSpecChange
+		*out = new(SpecChange)
+		**out = **in
+	}
+	if in.Fingerprint != nil {
+		in, out := &in.Fingerprint, &out.Fingerprint
+		*out = new(Fingerprint)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -138,9 +427,437 @@ func (in *RBACDefinitionStatus) DeepCopy() *RBACDefinitionStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RBACDriftReport) DeepCopyInto(out *RBACDriftReport) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Summary = in.Summary
+	if in.Repaired != nil {
+		in, out := &in.Repaired, &out.Repaired
+		*out = make([]DriftResult, len(*in))
+		copy(*out, *in)
+	}
+	if in.Blocked != nil {
+		in, out := &in.Blocked, &out.Blocked
+		*out = make([]DriftResult, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RBACDriftReport.
+func (in *RBACDriftReport) DeepCopy() *RBACDriftReport {
+	if in == nil {
+		return nil
+	}
+	out := new(RBACDriftReport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RBACDriftReport) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RBACDriftReportList) DeepCopyInto(out *RBACDriftReportList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RBACDriftReport, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RBACDriftReportList.
+func (in *RBACDriftReportList) DeepCopy() *RBACDriftReportList {
+	if in == nil {
+		return nil
+	}
+	out := new(RBACDriftReportList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RBACDriftReportList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RBACManagerConfig) DeepCopyInto(out *RBACManagerConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RBACManagerConfig.
+func (in *RBACManagerConfig) DeepCopy() *RBACManagerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RBACManagerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RBACManagerConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RBACManagerConfigList) DeepCopyInto(out *RBACManagerConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RBACManagerConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RBACManagerConfigList.
+func (in *RBACManagerConfigList) DeepCopy() *RBACManagerConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(RBACManagerConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RBACManagerConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RBACManagerConfigSpec) DeepCopyInto(out *RBACManagerConfigSpec) {
+	*out = *in
+	if in.SyncInterval != nil {
+		in, out := &in.SyncInterval, &out.SyncInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Parallelism != nil {
+		in, out := &in.Parallelism, &out.Parallelism
+		*out = new(int)
+		**out = **in
+	}
+	if in.ForbiddenSubjects != nil {
+		in, out := &in.ForbiddenSubjects, &out.ForbiddenSubjects
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxImportDepth != nil {
+		in, out := &in.MaxImportDepth, &out.MaxImportDepth
+		*out = new(int)
+		**out = **in
+	}
+	if in.MaxPrunes != nil {
+		in, out := &in.MaxPrunes, &out.MaxPrunes
+		*out = new(int)
+		**out = **in
+	}
+	if in.SubjectPatchLimit != nil {
+		in, out := &in.SubjectPatchLimit, &out.SubjectPatchLimit
+		*out = new(int)
+		**out = **in
+	}
+	if in.NamespacePolicies != nil {
+		in, out := &in.NamespacePolicies, &out.NamespacePolicies
+		*out = make([]NamespacePolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ChildLabels != nil {
+		in, out := &in.ChildLabels, &out.ChildLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ChildAnnotations != nil {
+		in, out := &in.ChildAnnotations, &out.ChildAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RBACManagerConfigSpec.
+func (in *RBACManagerConfigSpec) DeepCopy() *RBACManagerConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RBACManagerConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RBACManagerConfigStatus) DeepCopyInto(out *RBACManagerConfigStatus) {
+	*out = *in
+	if in.Effective != nil {
+		in, out := &in.Effective, &out.Effective
+		*out = new(EffectiveConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RBACManagerConfigStatus.
+func (in *RBACManagerConfigStatus) DeepCopy() *RBACManagerConfigStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RBACManagerConfigStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RBACTemporaryGrant) DeepCopyInto(out *RBACTemporaryGrant) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RBACTemporaryGrant.
+func (in *RBACTemporaryGrant) DeepCopy() *RBACTemporaryGrant {
+	if in == nil {
+		return nil
+	}
+	out := new(RBACTemporaryGrant)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RBACTemporaryGrant) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RBACTemporaryGrantList) DeepCopyInto(out *RBACTemporaryGrantList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RBACTemporaryGrant, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RBACTemporaryGrantList.
+func (in *RBACTemporaryGrantList) DeepCopy() *RBACTemporaryGrantList {
+	if in == nil {
+		return nil
+	}
+	out := new(RBACTemporaryGrantList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RBACTemporaryGrantList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RBACTemporaryGrantSpec) DeepCopyInto(out *RBACTemporaryGrantSpec) {
+	*out = *in
+	out.Duration = in.Duration
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RBACTemporaryGrantSpec.
+func (in *RBACTemporaryGrantSpec) DeepCopy() *RBACTemporaryGrantSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RBACTemporaryGrantSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RBACTemporaryGrantStatus) DeepCopyInto(out *RBACTemporaryGrantStatus) {
+	*out = *in
+	if in.GrantedAt != nil {
+		in, out := &in.GrantedAt, &out.GrantedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RBACTemporaryGrantStatus.
+func (in *RBACTemporaryGrantStatus) DeepCopy() *RBACTemporaryGrantStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RBACTemporaryGrantStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemoteCluster) DeepCopyInto(out *RemoteCluster) {
+	*out = *in
+	out.KubeconfigSecret = in.KubeconfigSecret
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteCluster.
+func (in *RemoteCluster) DeepCopy() *RemoteCluster {
+	if in == nil {
+		return nil
+	}
+	out := new(RemoteCluster)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemoteClusterStatus) DeepCopyInto(out *RemoteClusterStatus) {
+	*out = *in
+	out.KubeconfigSecret = in.KubeconfigSecret
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteClusterStatus.
+func (in *RemoteClusterStatus) DeepCopy() *RemoteClusterStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RemoteClusterStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceCounts) DeepCopyInto(out *ResourceCounts) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceCounts.
+func (in *ResourceCounts) DeepCopy() *ResourceCounts {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceCounts)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RoleBinding) DeepCopyInto(out *RoleBinding) {
 	*out = *in
+	if in.ClusterRoles != nil {
+		in, out := &in.ClusterRoles, &out.ClusterRoles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RoleFrom != nil {
+		in, out := &in.RoleFrom, &out.RoleFrom
+		*out = new(RoleSource)
+		**out = **in
+	}
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.NamespaceSelector.DeepCopyInto(&out.NamespaceSelector)
+	if in.NamespaceAnnotationSelector != nil {
+		in, out := &in.NamespaceAnnotationSelector, &out.NamespaceAnnotationSelector
+		*out = new(NamespaceAnnotationSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NamespaceOwnedBy != nil {
+		in, out := &in.NamespaceOwnedBy, &out.NamespaceOwnedBy
+		*out = new(NamespaceOwner)
+		**out = **in
+	}
+	if in.RequireNamespaceLabel != nil {
+		in, out := &in.RequireNamespaceLabel, &out.RequireNamespaceLabel
+		*out = new(NamespaceLabelRequirement)
+		**out = **in
+	}
+	if in.NamespaceLabels != nil {
+		in, out := &in.NamespaceLabels, &out.NamespaceLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	return
 }
 
@@ -153,3 +870,91 @@ func (in *RoleBinding) DeepCopy() *RoleBinding {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoleSource) DeepCopyInto(out *RoleSource) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoleSource.
+func (in *RoleSource) DeepCopy() *RoleSource {
+	if in == nil {
+		return nil
+	}
+	out := new(RoleSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SpecChange) DeepCopyInto(out *SpecChange) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SpecChange.
+func (in *SpecChange) DeepCopy() *SpecChange {
+	if in == nil {
+		return nil
+	}
+	out := new(SpecChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Subject) DeepCopyInto(out *Subject) {
+	*out = *in
+	out.Subject = in.Subject
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.AutomountServiceAccountToken != nil {
+		in, out := &in.AutomountServiceAccountToken, &out.AutomountServiceAccountToken
+		*out = new(bool)
+		**out = **in
+	}
+	if in.CreateServiceAccount != nil {
+		in, out := &in.CreateServiceAccount, &out.CreateServiceAccount
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Subject.
+func (in *Subject) DeepCopy() *Subject {
+	if in == nil {
+		return nil
+	}
+	out := new(Subject)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package builder assembles RBAC Definitions in Go code:
+//
+//	rbacDef, err := builder.NewDefinition("team-a").
+//		Binding("devs").
+//		Subject(builder.Group("team-a")).
+//		ClusterRole("edit").NamespaceSelector("team", "a").
+//		ClusterRole("view").
+//		Build()
+//
+// A ClusterRole granted without namespaces is bound cluster wide, otherwise it
+// is bound through Role Bindings in the given or selected namespaces. Build
+// checks the result with the same validation RBAC Manager applies before
+// reconciling it.
+package builder
+
+import (
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+// User returns a subject for the user name
+func User(name string) rbacmanagerv1beta1.Subject {
+	return rbacmanagerv1beta1.Subject{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: name}}
+}
+
+// Group returns a subject for the group name
+func Group(name string) rbacmanagerv1beta1.Subject {
+	return rbacmanagerv1beta1.Subject{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: name}}
+}
+
+// ServiceAccount returns a subject for the Service Account name in namespace,
+// which RBAC Manager creates if it doesn't exist
+func ServiceAccount(namespace, name string) rbacmanagerv1beta1.Subject {
+	return rbacmanagerv1beta1.Subject{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Namespace: namespace, Name: name}}
+}
+
+// DefinitionBuilder assembles an RBAC Definition
+type DefinitionBuilder struct {
+	rbacDef  rbacmanagerv1beta1.RBACDefinition
+	bindings []*BindingBuilder
+}
+
+// NewDefinition starts an RBAC Definition called name
+func NewDefinition(name string) *DefinitionBuilder {
+	d := &DefinitionBuilder{}
+	d.rbacDef.APIVersion = rbacmanagerv1beta1.SchemeGroupVersion.String()
+	d.rbacDef.Kind = "RBACDefinition"
+	d.rbacDef.Name = name
+	return d
+}
+
+// Label sets a label on the RBAC Definition
+func (d *DefinitionBuilder) Label(key, value string) *DefinitionBuilder {
+	if d.rbacDef.Labels == nil {
+		d.rbacDef.Labels = map[string]string{}
+	}
+	d.rbacDef.Labels[key] = value
+	return d
+}
+
+// ConflictPolicy sets how existing resources that the RBAC Definition doesn't
+// manage are handled
+func (d *DefinitionBuilder) ConflictPolicy(policy rbacmanagerv1beta1.ConflictPolicy) *DefinitionBuilder {
+	d.rbacDef.ConflictPolicy = policy
+	return d
+}
+
+// DeletionPolicy sets what happens to managed resources when the RBAC
+// Definition is deleted
+func (d *DefinitionBuilder) DeletionPolicy(policy rbacmanagerv1beta1.DeletionPolicy) *DefinitionBuilder {
+	d.rbacDef.DeletionPolicy = policy
+	return d
+}
+
+// Binding starts an rbacBindings entry called name
+func (d *DefinitionBuilder) Binding(name string) *BindingBuilder {
+	b := &BindingBuilder{definition: d, name: name}
+	d.bindings = append(d.bindings, b)
+	return b
+}
+
+// Build returns the RBAC Definition, or an error if RBAC Manager would
+// reject it
+func (d *DefinitionBuilder) Build() (*rbacmanagerv1beta1.RBACDefinition, error) {
+	rbacDef := d.rbacDef.DeepCopy()
+	for _, b := range d.bindings {
+		rbacDef.RBACBindings = append(rbacDef.RBACBindings, b.build())
+	}
+
+	err := reconciler.Validate(rbacDef)
+	if err != nil {
+		return nil, err
+	}
+	return rbacDef, nil
+}
+
+// BindingBuilder assembles an rbacBindings entry, granting roles to subjects
+type BindingBuilder struct {
+	definition *DefinitionBuilder
+	name       string
+	subjects   []rbacmanagerv1beta1.Subject
+	grants     []*GrantBuilder
+}
+
+// Subject adds subjects to the entry
+func (b *BindingBuilder) Subject(subjects ...rbacmanagerv1beta1.Subject) *BindingBuilder {
+	b.subjects = append(b.subjects, subjects...)
+	return b
+}
+
+// ClusterRole grants the ClusterRole name to the subjects of the entry, cluster
+// wide unless namespaces are added to the grant
+func (b *BindingBuilder) ClusterRole(name string) *GrantBuilder {
+	g := &GrantBuilder{binding: b, clusterRole: name}
+	b.grants = append(b.grants, g)
+	return g
+}
+
+// Role grants the Role name to the subjects of the entry in the namespaces
+// added to the grant
+func (b *BindingBuilder) Role(name string) *GrantBuilder {
+	g := &GrantBuilder{binding: b, role: name}
+	b.grants = append(b.grants, g)
+	return g
+}
+
+// Binding starts another rbacBindings entry called name
+func (b *BindingBuilder) Binding(name string) *BindingBuilder {
+	return b.definition.Binding(name)
+}
+
+// Build returns the RBAC Definition, or an error if RBAC Manager would
+// reject it
+func (b *BindingBuilder) Build() (*rbacmanagerv1beta1.RBACDefinition, error) {
+	return b.definition.Build()
+}
+
+func (b *BindingBuilder) build() rbacmanagerv1beta1.RBACBinding {
+	rbacBinding := rbacmanagerv1beta1.RBACBinding{
+		Name:     b.name,
+		Subjects: append([]rbacmanagerv1beta1.Subject{}, b.subjects...),
+	}
+	for _, g := range b.grants {
+		if g.clusterRole != "" && len(g.namespaces) == 0 && g.namespaceSelector == nil {
+			rbacBinding.ClusterRoleBindings = append(rbacBinding.ClusterRoleBindings, rbacmanagerv1beta1.ClusterRoleBinding{
+				ClusterRole: g.clusterRole,
+			})
+			continue
+		}
+		rbacBinding.RoleBindings = append(rbacBinding.RoleBindings, g.build())
+	}
+	return rbacBinding
+}
+
+// GrantBuilder assembles the grant of a role within an rbacBindings entry
+type GrantBuilder struct {
+	binding           *BindingBuilder
+	clusterRole       string
+	role              string
+	namespaces        []string
+	namespaceSelector *metav1.LabelSelector
+}
+
+// Namespace adds namespaces the role is granted in
+func (g *GrantBuilder) Namespace(namespaces ...string) *GrantBuilder {
+	g.namespaces = append(g.namespaces, namespaces...)
+	return g
+}
+
+// NamespaceSelector grants the role in namespaces labeled key=value. Calling
+// it again requires namespaces to have all the labels.
+func (g *GrantBuilder) NamespaceSelector(key, value string) *GrantBuilder {
+	if g.namespaceSelector == nil {
+		g.namespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{}}
+	}
+	g.namespaceSelector.MatchLabels[key] = value
+	return g
+}
+
+// ClusterRole grants another ClusterRole to the subjects of the entry
+func (g *GrantBuilder) ClusterRole(name string) *GrantBuilder {
+	return g.binding.ClusterRole(name)
+}
+
+// Role grants another Role to the subjects of the entry
+func (g *GrantBuilder) Role(name string) *GrantBuilder {
+	return g.binding.Role(name)
+}
+
+// Subject adds subjects to the entry
+func (g *GrantBuilder) Subject(subjects ...rbacmanagerv1beta1.Subject) *GrantBuilder {
+	g.binding.Subject(subjects...)
+	return g
+}
+
+// Binding starts another rbacBindings entry called name
+func (g *GrantBuilder) Binding(name string) *BindingBuilder {
+	return g.binding.Binding(name)
+}
+
+// Build returns the RBAC Definition, or an error if RBAC Manager would
+// reject it
+func (g *GrantBuilder) Build() (*rbacmanagerv1beta1.RBACDefinition, error) {
+	return g.binding.Build()
+}
+
+func (g *GrantBuilder) build() rbacmanagerv1beta1.RoleBinding {
+	rb := rbacmanagerv1beta1.RoleBinding{
+		ClusterRole: g.clusterRole,
+		Role:        g.role,
+	}
+	// namespace can't be combined with a selector, namespaces can
+	if len(g.namespaces) == 1 && g.namespaceSelector == nil {
+		rb.Namespace = g.namespaces[0]
+	} else if len(g.namespaces) > 0 {
+		rb.Namespaces = append([]string{}, g.namespaces...)
+	}
+	if g.namespaceSelector != nil {
+		rb.NamespaceSelector = *g.namespaceSelector.DeepCopy()
+	}
+	return rb
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestBuild(t *testing.T) {
+	rbacDef, err := NewDefinition("team-a").
+		Label("team", "a").
+		Binding("devs").
+		Subject(Group("team-a"), ServiceAccount("ci", "deployer")).
+		ClusterRole("edit").NamespaceSelector("team", "a").
+		ClusterRole("view").
+		Role("debugger").Namespace("web").
+		Binding("ops").
+		Subject(User("joe")).
+		ClusterRole("admin").Namespace("web", "api").
+		Build()
+	assert.NoError(t, err)
+
+	expected := &rbacmanagerv1beta1.RBACDefinition{
+		TypeMeta: metav1.TypeMeta{APIVersion: "rbacmanager.reactiveops.io/v1beta1", Kind: "RBACDefinition"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "team-a",
+			Labels: map[string]string{"team": "a"},
+		},
+		RBACBindings: []rbacmanagerv1beta1.RBACBinding{{
+			Name: "devs",
+			Subjects: []rbacmanagerv1beta1.Subject{
+				{Subject: rbacv1.Subject{Kind: "Group", APIGroup: "rbac.authorization.k8s.io", Name: "team-a"}},
+				{Subject: rbacv1.Subject{Kind: "ServiceAccount", Namespace: "ci", Name: "deployer"}},
+			},
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{
+				{ClusterRole: "edit", NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}},
+				{Role: "debugger", Namespace: "web"},
+			},
+		}, {
+			Name: "ops",
+			Subjects: []rbacmanagerv1beta1.Subject{
+				{Subject: rbacv1.Subject{Kind: "User", APIGroup: "rbac.authorization.k8s.io", Name: "joe"}},
+			},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{
+				{ClusterRole: "admin", Namespaces: []string{"web", "api"}},
+			},
+		}},
+	}
+	assert.Equal(t, expected, rbacDef)
+}
+
+func TestBuildNamespacesAndSelector(t *testing.T) {
+	rbacDef, err := NewDefinition("team-a").
+		Binding("devs").
+		Subject(Group("team-a")).
+		ClusterRole("edit").Namespace("shared").NamespaceSelector("team", "a").
+		Build()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"shared"}, rbacDef.RBACBindings[0].RoleBindings[0].Namespaces)
+	assert.Empty(t, rbacDef.RBACBindings[0].RoleBindings[0].Namespace)
+}
+
+func TestBuildInvalid(t *testing.T) {
+	_, err := NewDefinition("team-a").
+		Binding("devs").
+		ClusterRole("edit").
+		Build()
+	assert.EqualError(t, err, "rbacBindings[0] 'devs': subjects: no subjects specified")
+
+	_, err = NewDefinition("team-a").
+		Binding("devs").
+		Subject(Group("team-a")).
+		Role("debugger").
+		Build()
+	assert.Error(t, err, "Roles must be granted in namespaces")
+
+	_, err = NewDefinition("team-a").
+		ConflictPolicy("Replace").
+		Build()
+	assert.Error(t, err)
+}
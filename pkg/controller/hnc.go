@@ -0,0 +1,126 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// subnamespaceAnchors is the resource the Hierarchical Namespace Controller
+// creates subnamespaces from. The anchor has the name of the subnamespace.
+var subnamespaceAnchors = schema.GroupVersionResource{Group: "hnc.x-k8s.io", Version: "v1alpha2", Resource: "subnamespaceanchors"}
+
+// subnamespaceReady is the status of an anchor whose subnamespace has been
+// created and joined the hierarchy
+const subnamespaceReady = "Ok"
+
+// watchSubnamespaceAnchors queues the subnamespace of every anchor that
+// finishes provisioning with the namespace controller c, so that selectors
+// are evaluated again once the subnamespace is part of the hierarchy. Nothing
+// is watched if the Hierarchical Namespace Controller isn't installed.
+func watchSubnamespaceAnchors(mgr manager.Manager, c controller.Controller) error {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+
+	resources, err := discoveryClient.ServerResourcesForGroupVersion(subnamespaceAnchors.GroupVersion().String())
+	if apierrors.IsNotFound(err) {
+		logrus.Debug("Hierarchical Namespace Controller not installed, not watching subnamespace anchors")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	found := false
+	for _, resource := range resources.APIResources {
+		found = found || resource.Name == subnamespaceAnchors.Resource
+	}
+	if !found {
+		logrus.Debug("Hierarchical Namespace Controller not installed, not watching subnamespace anchors")
+		return nil
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0)
+	informer := factory.ForResource(subnamespaceAnchors).Informer()
+	err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		factory.Start(ctx.Done())
+		<-ctx.Done()
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+
+	logrus.Info("Watching subnamespace anchors of the Hierarchical Namespace Controller")
+	return c.Watch(&source.Informer{Informer: informer}, handler.EnqueueRequestsFromMapFunc(anchoredNamespace), subnamespaceProvisioned())
+}
+
+// anchoredNamespace maps an anchor to the subnamespace it creates
+func anchoredNamespace(anchor client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: anchor.GetName()}}}
+}
+
+// subnamespaceProvisioned only passes anchors whose subnamespace just became
+// ready, or already was when the anchor was first seen
+func subnamespaceProvisioned() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return anchorStatus(e.Object) == subnamespaceReady
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return anchorStatus(e.ObjectOld) != subnamespaceReady && anchorStatus(e.ObjectNew) == subnamespaceReady
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+func anchorStatus(obj client.Object) string {
+	anchor, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return ""
+	}
+	status, _, _ := unstructured.NestedString(anchor.Object, "status", "status")
+	return status
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+// importsIndex indexes RBAC Definitions by the names of the definitions they
+// import or bind the Service Accounts of
+const importsIndex = "imports"
+
+func indexImports(obj client.Object) []string {
+	rbacDef, ok := obj.(*rbacmanagerv1beta1.RBACDefinition)
+	if !ok {
+		return nil
+	}
+	return reconciler.DefinitionDependencies(rbacDef)
+}
+
+// importers maps a changed RBAC Definition to every definition that imports
+// it or binds its Service Accounts, directly or through other definitions
+func importers(c client.Reader) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		requests := []reconcile.Request{}
+		seen := map[string]bool{obj.GetName(): true}
+		pending := []string{obj.GetName()}
+
+		for len(pending) > 0 {
+			name := pending[0]
+			pending = pending[1:]
+
+			var rbacDefList rbacmanagerv1beta1.RBACDefinitionList
+			err := c.List(context.TODO(), &rbacDefList, client.MatchingFields{importsIndex: name})
+			if err != nil {
+				logrus.Errorf("Error listing RBACDefinitions importing %v: %v", name, err)
+				metrics.ErrorCounter.Inc()
+				continue
+			}
+
+			for _, importer := range rbacDefList.Items {
+				if seen[importer.Name] {
+					continue
+				}
+				seen[importer.Name] = true
+				pending = append(pending, importer.Name)
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: importer.Name}})
+			}
+		}
+
+		return requests
+	}
+}
@@ -16,14 +16,22 @@ package controller
 import (
 	"context"
 
+	"github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
 	"github.com/schlapzz/rbac-manager/pkg/kube"
@@ -31,16 +39,43 @@ import (
 	"github.com/schlapzz/rbac-manager/pkg/reconciler"
 )
 
+// watchNamespaceRetries queues the namespaces passed to
+// reconciler.NamespaceRetries with the namespace controller c, so that creates
+// rejected while a namespace settles are retried
+func watchNamespaceRetries(mgr manager.Manager, c controller.Controller) error {
+	retries := make(chan event.GenericEvent)
+	err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		for {
+			select {
+			case name := <-reconciler.NamespaceRetries:
+				select {
+				case retries <- event.GenericEvent{Object: &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}}:
+				case <-ctx.Done():
+					return nil
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}))
+	if err != nil {
+		return err
+	}
+
+	return c.Watch(&source.Channel{Source: retries}, &handler.EnqueueRequestForObject{})
+}
+
 // newNamespaceReconciler returns a new reconcile.Reconciler
 func newNamespaceReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileNamespace{Client: mgr.GetClient(), config: mgr.GetConfig(), scheme: mgr.GetScheme()}
+	return &ReconcileNamespace{Client: mgr.GetClient(), config: mgr.GetConfig(), scheme: mgr.GetScheme(), recorder: mgr.GetEventRecorderFor("rbac-manager")}
 }
 
 // ReconcileNamespace reconciles a Namespace object
 type ReconcileNamespace struct {
 	client.Client
-	scheme *runtime.Scheme
-	config *rest.Config
+	scheme   *runtime.Scheme
+	config   *rest.Config
+	recorder record.EventRecorder
 }
 
 // Reconcile makes changes in response to Namespace changes
@@ -53,7 +88,7 @@ func (r *ReconcileNamespace) Reconcile(ctx context.Context, request reconcile.Re
 
 	if err != nil {
 		if errors.IsNotFound(err) {
-			err = reconcileNamespace(r.config, namespace)
+			err = reconcileNamespace(ctx, r.Client, r.config, r.recorder, request.Name, namespace, true)
 			if err != nil {
 				metrics.ErrorCounter.Inc()
 				return reconcile.Result{}, err
@@ -65,7 +100,7 @@ func (r *ReconcileNamespace) Reconcile(ctx context.Context, request reconcile.Re
 		return reconcile.Result{}, err
 	}
 
-	err = reconcileNamespace(r.config, namespace)
+	err = reconcileNamespace(ctx, r.Client, r.config, r.recorder, request.Name, namespace, false)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
@@ -73,11 +108,11 @@ func (r *ReconcileNamespace) Reconcile(ctx context.Context, request reconcile.Re
 	return reconcile.Result{}, nil
 }
 
-func reconcileNamespace(config *rest.Config, namespace *v1.Namespace) error {
+func reconcileNamespace(ctx context.Context, c client.Client, config *rest.Config, recorder record.EventRecorder, name string, namespace *v1.Namespace, deleted bool) error {
 	metrics.ReconcileCounter.WithLabelValues("namespace").Inc()
 	var err error
 	var rbacDefList rbacmanagerv1beta1.RBACDefinitionList
-	rdr := reconciler.Reconciler{}
+	rdr := reconciler.Reconciler{Recorder: recorder, Trigger: reconciler.TriggerNamespace}
 
 	// Full Kubernetes ClientSet is required because RBAC types don't
 	//   implement methods required for controller-runtime methods to work
@@ -92,12 +127,35 @@ func reconcileNamespace(config *rest.Config, namespace *v1.Namespace) error {
 		return err
 	}
 
-	for _, rbacDef := range rbacDefList.Items {
+	// Only definitions whose namespaces or selectors could include the
+	// namespace, before or after the change, are looked at
+	current := namespace
+	if deleted {
+		current = nil
+	}
+	rbacDefs := reconciler.DefinitionsForNamespace(rbacDefList.Items, name, current)
+	logrus.Debugf("Namespace %v may affect %d of %d RBAC Definitions", name, len(rbacDefs), len(rbacDefList.Items))
+
+	// A definition that fails must not keep the others from being reconciled
+	errs := []error{}
+	for _, rbacDef := range rbacDefs {
 		err = rdr.ReconcileNamespaceChange(&rbacDef, namespace)
 		if err != nil {
-			return err
+			logrus.Errorf("Error reconciling namespace %v for RBACDefinition %v: %v", namespace.Name, rbacDef.Name, err)
+			errs = append(errs, err)
+		}
+
+		// Selectors that matched nothing may match the namespace now, and
+		// guard labels may have been added to or removed from it
+		noMatchChanged := rdr.UpdateNoNamespacesMatched(&rbacDef)
+		if rdr.UpdateGuardLabelMissing(&rbacDef) || noMatchChanged {
+			err = c.Status().Update(ctx, &rbacDef)
+			if err != nil {
+				logrus.Errorf("Error updating status of RBACDefinition %v: %v", rbacDef.Name, err)
+				errs = append(errs, err)
+			}
 		}
 	}
 
-	return nil
+	return utilerrors.NewAggregate(errs)
 }
@@ -19,18 +19,33 @@ package controller
 import (
 	"context"
 
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
 	"github.com/schlapzz/rbac-manager/pkg/metrics"
 	"github.com/schlapzz/rbac-manager/pkg/reconciler"
 )
 
+// orphanFinalizer keeps RBAC Definitions with an Orphan deletion policy
+// around until their resources have been released
+const orphanFinalizer = "rbacmanager.reactiveops.io/orphan"
+
+// namespaceFinalizer keeps RBAC Definitions with a DeleteNamespaces deletion
+// policy around until the namespaces created for them have been deleted
+const namespaceFinalizer = "rbacmanager.reactiveops.io/namespaces"
+
 // newRbacDefReconciler returns a new reconcile.Reconciler
 func newRbacDefReconciler(mgr manager.Manager) reconcile.Reconciler {
 	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
@@ -44,6 +59,7 @@ func newRbacDefReconciler(mgr manager.Manager) reconcile.Reconciler {
 		Client:    mgr.GetClient(),
 		clientset: clientset,
 		scheme:    mgr.GetScheme(),
+		recorder:  mgr.GetEventRecorderFor("rbac-manager"),
 	}
 }
 
@@ -52,13 +68,14 @@ type ReconcileRBACDefinition struct {
 	client.Client
 	scheme    *runtime.Scheme
 	clientset kubernetes.Interface
+	recorder  record.EventRecorder
 }
 
 // Reconcile makes changes in response to RBACDefinition changes
 func (r *ReconcileRBACDefinition) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	metrics.ReconcileCounter.WithLabelValues("rbacdefinition").Inc()
 	var err error
-	rdr := reconciler.Reconciler{Clientset: r.clientset}
+	rdr := reconciler.Reconciler{Clientset: r.clientset, Recorder: r.recorder, Trigger: reconciler.TriggerSpecChange}
 
 	// Fetch the RBACDefinition instance
 	rbacDef := &rbacmanagerv1beta1.RBACDefinition{}
@@ -68,16 +85,175 @@ func (r *ReconcileRBACDefinition) Reconcile(ctx context.Context, request reconci
 		if errors.IsNotFound(err) {
 			// Object not found, return.  Created objects are automatically garbage collected.
 			// For additional cleanup logic use finalizers.
+			reconciler.PolicyBlocks.Forget(request.Name)
+			metrics.BindingsWithNoMatch.DeleteLabelValues(request.Name)
+			reconciler.ForgetSpecSnapshots(request.Name)
+			reconciler.ForgetServiceAccountSelectors(request.Name)
+			reconciler.ForgetNamespaceSelectors(request.Name)
+			reconciler.ForgetBlockedPrunes(request.Name)
+			reconciler.ForgetGeneration(request.Name)
+			reconciler.ForgetState(request.Name)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
 
-	err = rdr.Reconcile(rbacDef)
+	if !rbacDef.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, r.finalize(ctx, &rdr, rbacDef)
+	}
+
+	// The cache may not have caught up with a revision a watcher already
+	// reconciled. The update that brings it will queue the definition again.
+	if reconciler.SupersededGeneration(rbacDef) {
+		logrus.Debugf("Skipping generation %v of RBACDefinition %v, a newer one has been reconciled", rbacDef.Generation, rbacDef.Name)
+		return reconcile.Result{}, nil
+	}
+
+	reconciler.IndexServiceAccountSelectors(rbacDef)
+	reconciler.IndexNamespaceSelectors(rbacDef)
+
+	err = r.updateFinalizer(ctx, rbacDef)
 	if err != nil {
+		logrus.Errorf("Error updating finalizers of RBACDefinition %v: %v", rbacDef.Name, err)
 		metrics.ErrorCounter.Inc()
+		return reconcile.Result{}, err
+	}
+
+	status := rbacDef.Status.DeepCopy()
+
+	if syncToken, ok := manualSyncRequested(rbacDef); ok {
+		logrus.Infof("Manual sync of RBACDefinition %v triggered", rbacDef.Name)
+		r.recorder.Event(rbacDef, corev1.EventTypeNormal, "ManualSync", "manual sync triggered")
+		rdr.SkipCache = true
+		rdr.Trigger = reconciler.TriggerManualSync
+		rbacDef.Status.LastSync = syncToken
+	}
+
+	var reconcileErr error
+	if rbacDef.SyncMode == rbacmanagerv1beta1.SyncModeReportOnly {
+		reconcileErr = rdr.ReportPlan(rbacDef)
+		if reconcileErr != nil {
+			metrics.ErrorCounter.Inc()
+		}
+		reconciler.SetReadyCondition(rbacDef, reconcileErr)
+	} else {
+		rbacDef.Status.PlannedChanges = nil
+		rbacDef.Status.PlannedChangesOmitted = 0
+
+		reconcileErr = rdr.Reconcile(rbacDef)
+		if reconcileErr != nil {
+			metrics.ErrorCounter.Inc()
+		}
+
+		clustersErr := r.reconcileClusters(rbacDef, rdr.Trigger)
+		reconciler.SetReadyCondition(rbacDef, utilerrors.NewAggregate([]error{reconcileErr, clustersErr}))
+		if reconcileErr == nil {
+			reconcileErr = clustersErr
+		}
+	}
+
+	rdr.WriteDriftReport(rbacDef)
+
+	if !equality.Semantic.DeepEqual(status, &rbacDef.Status) {
+		err = r.Status().Update(ctx, rbacDef)
+		if err != nil {
+			logrus.Errorf("Error updating status of RBACDefinition %v: %v", rbacDef.Name, err)
+			metrics.ErrorCounter.Inc()
+			return reconcile.Result{}, err
+		}
+	}
+
+	// Returning the error requeues the definition with reconciler.FailureBackoff
+	return reconcile.Result{}, reconcileErr
+}
+
+// manualSyncRequested returns the value of the sync annotation if it has
+// changed since the RBAC Definition was last synced because of it
+func manualSyncRequested(rbacDef *rbacmanagerv1beta1.RBACDefinition) (string, bool) {
+	syncToken, ok := rbacDef.Annotations[kube.SyncAnnotation]
+	if !ok || syncToken == rbacDef.Status.LastSync {
+		return "", false
+	}
+	return syncToken, true
+}
+
+// updateFinalizer adds the orphan finalizer to RBAC Definitions that need to
+// release their resources before being deleted and the remote finalizer to
+// RBAC Definitions with resources in remote clusters, and removes them from
+// others
+func (r *ReconcileRBACDefinition) updateFinalizer(ctx context.Context, rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
+	orphan := rbacDef.DeletionPolicy == rbacmanagerv1beta1.DeletionPolicyOrphan
+	remote := len(rbacDef.Clusters) > 0 || len(rbacDef.Status.Clusters) > 0
+
+	deleteNamespaces := rbacDef.DeletionPolicy == rbacmanagerv1beta1.DeletionPolicyDeleteNamespaces
+
+	changed := setFinalizer(rbacDef, orphanFinalizer, orphan)
+	changed = setFinalizer(rbacDef, remoteFinalizer, remote) || changed
+	changed = setFinalizer(rbacDef, namespaceFinalizer, deleteNamespaces) || changed
+	if !changed {
+		return nil
+	}
+
+	return r.Update(ctx, rbacDef)
+}
+
+// setFinalizer adds or removes a finalizer and reports whether that changed
+// the RBAC Definition
+func setFinalizer(rbacDef *rbacmanagerv1beta1.RBACDefinition, finalizer string, wanted bool) bool {
+	if wanted == controllerutil.ContainsFinalizer(rbacDef, finalizer) {
+		return false
+	}
+
+	if wanted {
+		controllerutil.AddFinalizer(rbacDef, finalizer)
+	} else {
+		controllerutil.RemoveFinalizer(rbacDef, finalizer)
+	}
+	return true
+}
+
+// finalize releases the resources of an RBAC Definition that is being deleted
+// from remote clusters and orphans its local resources or deletes the
+// namespaces created for it if its deletion policy asks for it, then lets the
+// deletion continue
+func (r *ReconcileRBACDefinition) finalize(ctx context.Context, rdr *reconciler.Reconciler, rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
+	if !controllerutil.ContainsFinalizer(rbacDef, orphanFinalizer) && !controllerutil.ContainsFinalizer(rbacDef, remoteFinalizer) &&
+		!controllerutil.ContainsFinalizer(rbacDef, namespaceFinalizer) {
+		return nil
+	}
+
+	if controllerutil.ContainsFinalizer(rbacDef, remoteFinalizer) {
+		err := r.releaseClusters(rbacDef)
+		if err != nil {
+			logrus.Errorf("Error releasing resources of RBACDefinition %v in remote clusters: %v", rbacDef.Name, err)
+			return err
+		}
+		controllerutil.RemoveFinalizer(rbacDef, remoteFinalizer)
+	}
+
+	if controllerutil.ContainsFinalizer(rbacDef, orphanFinalizer) {
+		if rbacDef.DeletionPolicy == rbacmanagerv1beta1.DeletionPolicyOrphan {
+			err := rdr.Orphan(rbacDef)
+			if err != nil {
+				logrus.Errorf("Error orphaning resources of RBACDefinition %v: %v", rbacDef.Name, err)
+				metrics.ErrorCounter.Inc()
+				return err
+			}
+		}
+		controllerutil.RemoveFinalizer(rbacDef, orphanFinalizer)
+	}
+
+	if controllerutil.ContainsFinalizer(rbacDef, namespaceFinalizer) {
+		if rbacDef.DeletionPolicy == rbacmanagerv1beta1.DeletionPolicyDeleteNamespaces {
+			err := rdr.DeleteNamespaces(rbacDef)
+			if err != nil {
+				logrus.Errorf("Error deleting namespaces of RBACDefinition %v: %v", rbacDef.Name, err)
+				return err
+			}
+		}
+		controllerutil.RemoveFinalizer(rbacDef, namespaceFinalizer)
 	}
 
-	return reconcile.Result{}, nil
+	return r.Update(ctx, rbacDef)
 }
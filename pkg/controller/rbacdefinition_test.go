@@ -0,0 +1,164 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+func TestManualSyncRequested(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		lastSync    string
+		expected    string
+		requested   bool
+	}{
+		{name: "no annotation"},
+		{name: "new value", annotations: map[string]string{kube.SyncAnnotation: "1"}, expected: "1", requested: true},
+		{name: "changed value", annotations: map[string]string{kube.SyncAnnotation: "2"}, lastSync: "1", expected: "2", requested: true},
+		{name: "handled value", annotations: map[string]string{kube.SyncAnnotation: "1"}, lastSync: "1"},
+		{name: "removed annotation", lastSync: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rbacDef := &rbacmanagerv1beta1.RBACDefinition{}
+			rbacDef.Annotations = tt.annotations
+			rbacDef.Status.LastSync = tt.lastSync
+
+			syncToken, requested := manualSyncRequested(rbacDef)
+			assert.Equal(t, tt.requested, requested)
+			assert.Equal(t, tt.expected, syncToken)
+		})
+	}
+}
+
+func TestReconcileManualSync(t *testing.T) {
+	tests := []struct {
+		name       string
+		syncToken  string
+		lastSync   string
+		manualSync bool
+	}{
+		{name: "without sync annotation"},
+		{name: "with handled sync annotation", syncToken: "1", lastSync: "1"},
+		{name: "with new sync annotation", syncToken: "1", manualSync: true},
+		{name: "with changed sync annotation", syncToken: "2", lastSync: "1", manualSync: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rbacDef := newSyncTestDefinition("admin")
+			defer reconciler.ForgetState(rbacDef.Name)
+			defer reconciler.ForgetSpecSnapshots(rbacDef.Name)
+
+			// The definition used to grant admin, so a binding to admin
+			// exists that the cache has never seen
+			clientset := fake.NewSimpleClientset()
+			previous := reconciler.Reconciler{Clientset: clientset, SkipCache: true}
+			assert.NoError(t, previous.Reconcile(rbacDef))
+			assert.Equal(t, []string{"admin"}, boundClusterRoles(t, clientset))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			staleCache, err := reconciler.NewCache(ctx, fake.NewSimpleClientset())
+			assert.NoError(t, err)
+			defer func(cache *reconciler.Cache) { reconciler.DefaultCache = cache }(reconciler.DefaultCache)
+			reconciler.DefaultCache = staleCache
+
+			rbacDef = newSyncTestDefinition("edit")
+			if tt.syncToken != "" {
+				rbacDef.Annotations = map[string]string{kube.SyncAnnotation: tt.syncToken}
+			}
+			rbacDef.Status.LastSync = tt.lastSync
+
+			scheme := runtime.NewScheme()
+			assert.NoError(t, rbacmanagerv1beta1.AddToScheme(scheme))
+			recorder := record.NewFakeRecorder(10)
+			r := &ReconcileRBACDefinition{
+				Client:    crfake.NewClientBuilder().WithScheme(scheme).WithObjects(rbacDef).Build(),
+				scheme:    scheme,
+				clientset: clientset,
+				recorder:  recorder,
+			}
+
+			_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: rbacDef.Name}})
+			assert.NoError(t, err)
+
+			updated := &rbacmanagerv1beta1.RBACDefinition{}
+			assert.NoError(t, r.Get(ctx, types.NamespacedName{Name: rbacDef.Name}, updated))
+			if tt.manualSync {
+				assert.Equal(t, tt.syncToken, updated.Status.LastSync)
+			} else {
+				assert.Equal(t, tt.lastSync, updated.Status.LastSync)
+			}
+
+			events := []string{}
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			if tt.manualSync {
+				assert.Contains(t, events, "Normal ManualSync manual sync triggered")
+				// Listing from the API finds the binding to admin and removes it
+				assert.Equal(t, []string{"edit"}, boundClusterRoles(t, clientset))
+			} else {
+				assert.NotContains(t, events, "Normal ManualSync manual sync triggered")
+				// The cache hides the binding to admin, so it is left behind
+				assert.ElementsMatch(t, []string{"admin", "edit"}, boundClusterRoles(t, clientset))
+			}
+		})
+	}
+}
+
+func newSyncTestDefinition(clusterRole string) *rbacmanagerv1beta1.RBACDefinition {
+	rbacDef := &rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "manual-sync"
+	rbacDef.UID = "manual-sync-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "devs",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "devs"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: clusterRole}},
+	}}
+	return rbacDef
+}
+
+func boundClusterRoles(t *testing.T, clientset *fake.Clientset) []string {
+	crbs, err := clientset.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+
+	roles := []string{}
+	for _, crb := range crbs.Items {
+		roles = append(roles, crb.RoleRef.Name)
+	}
+	return roles
+}
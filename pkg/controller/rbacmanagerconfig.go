@@ -0,0 +1,107 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+// newConfigReconciler returns a new reconcile.Reconciler
+func newConfigReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileRBACManagerConfig{Client: mgr.GetClient()}
+}
+
+// ReconcileRBACManagerConfig applies the settings of the RBACManagerConfig
+// named rbacmanagerv1beta1.ConfigName
+type ReconcileRBACManagerConfig struct {
+	client.Client
+}
+
+// Reconcile replaces the reconciler options in response to RBACManagerConfig
+// changes. Invalid specs leave the options in use unchanged.
+func (r *ReconcileRBACManagerConfig) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	metrics.ReconcileCounter.WithLabelValues("rbacmanagerconfig").Inc()
+
+	config := &rbacmanagerv1beta1.RBACManagerConfig{}
+	err := r.Get(ctx, request.NamespacedName, config)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			if request.Name == rbacmanagerv1beta1.ConfigName {
+				logrus.Info("RBACManagerConfig was deleted, using flag values")
+				reconciler.SetOptions(reconciler.DefaultOptions())
+			}
+			return reconcile.Result{}, nil
+		}
+		metrics.ErrorCounter.Inc()
+		return reconcile.Result{}, err
+	}
+
+	status := config.Status.DeepCopy()
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionConfigValid,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: config.Generation,
+		Reason:             "Applied",
+		Message:            "Settings are in use",
+	}
+
+	if config.Name != rbacmanagerv1beta1.ConfigName {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Ignored"
+		condition.Message = fmt.Sprintf("Only the RBACManagerConfig named %v is used", rbacmanagerv1beta1.ConfigName)
+		config.Status.Effective = nil
+	} else if options, err := reconciler.OptionsFromConfig(&config.Spec); err != nil {
+		logrus.Errorf("Not applying invalid RBACManagerConfig: %v", err)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "InvalidSpec"
+		condition.Message = err.Error()
+	} else {
+		effective := options.EffectiveConfig()
+		if !equality.Semantic.DeepEqual(effective, reconciler.CurrentOptions().EffectiveConfig()) {
+			logrus.Infof("Applying RBACManagerConfig: sync interval %v, parallelism %d, forbidden subjects %v, max import depth %d",
+				effective.SyncInterval, effective.Parallelism, effective.ForbiddenSubjects, effective.MaxImportDepth)
+		}
+		reconciler.SetOptions(options)
+		config.Status.Effective = effective
+	}
+
+	meta.SetStatusCondition(&config.Status.Conditions, condition)
+	if !equality.Semantic.DeepEqual(status, &config.Status) {
+		err = r.Status().Update(ctx, config)
+		if err != nil {
+			logrus.Errorf("Error updating status of RBACManagerConfig %v: %v", config.Name, err)
+			metrics.ErrorCounter.Inc()
+			return reconcile.Result{}, err
+		}
+	}
+
+	return reconcile.Result{}, nil
+}
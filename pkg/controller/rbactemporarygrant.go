@@ -0,0 +1,102 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+// newGrantReconciler returns a new reconcile.Reconciler
+func newGrantReconciler(mgr manager.Manager) reconcile.Reconciler {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+
+	if err != nil {
+		// If we can't get a clientset we can't do anything else
+		panic(err)
+	}
+
+	return &ReconcileRBACTemporaryGrant{
+		Client:    mgr.GetClient(),
+		clientset: clientset,
+		recorder:  mgr.GetEventRecorderFor("rbac-manager"),
+	}
+}
+
+// ReconcileRBACTemporaryGrant reconciles a RBACTemporaryGrant object
+type ReconcileRBACTemporaryGrant struct {
+	client.Client
+	clientset kubernetes.Interface
+	recorder  record.EventRecorder
+}
+
+// Reconcile makes changes in response to RBACTemporaryGrant changes. Active
+// grants are requeued for the moment they expire.
+func (r *ReconcileRBACTemporaryGrant) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	metrics.ReconcileCounter.WithLabelValues("rbactemporarygrant").Inc()
+	rdr := reconciler.Reconciler{Clientset: r.clientset, Recorder: r.recorder}
+
+	grant := &rbacmanagerv1beta1.RBACTemporaryGrant{}
+	err := r.Get(ctx, request.NamespacedName, grant)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Role Bindings of deleted grants are garbage collected
+			return reconcile.Result{}, nil
+		}
+		metrics.ErrorCounter.Inc()
+		return reconcile.Result{}, err
+	}
+
+	if !grant.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	status := grant.Status.DeepCopy()
+
+	requeueAfter, reconcileErr := rdr.ReconcileGrant(grant, time.Now())
+	if reconcileErr != nil {
+		logrus.Errorf("Error reconciling RBACTemporaryGrant %v/%v: %v", grant.Namespace, grant.Name, reconcileErr)
+		metrics.ErrorCounter.Inc()
+	}
+
+	if !equality.Semantic.DeepEqual(status, &grant.Status) {
+		err = r.Status().Update(ctx, grant)
+		if err != nil {
+			logrus.Errorf("Error updating status of RBACTemporaryGrant %v/%v: %v", grant.Namespace, grant.Name, err)
+			metrics.ErrorCounter.Inc()
+			return reconcile.Result{}, err
+		}
+	}
+
+	if reconcileErr != nil {
+		return reconcile.Result{}, reconcileErr
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+// remoteFinalizer keeps RBAC Definitions with resources in remote clusters
+// around until those resources have been deleted or orphaned, since garbage
+// collection can't remove them
+const remoteFinalizer = "rbacmanager.reactiveops.io/remote-clusters"
+
+// reconcileClusters applies rbacDef to every remote cluster it lists and
+// prunes its resources from clusters it no longer lists, recording the
+// outcome for each cluster in its status
+func (r *ReconcileRBACDefinition) reconcileClusters(rbacDef *rbacmanagerv1beta1.RBACDefinition, trigger reconciler.Trigger) error {
+	previous := map[string]rbacmanagerv1beta1.RemoteClusterStatus{}
+	for _, status := range rbacDef.Status.Clusters {
+		previous[status.Name] = status
+	}
+
+	statuses := []rbacmanagerv1beta1.RemoteClusterStatus{}
+	errs := []error{}
+
+	for _, cluster := range rbacDef.Clusters {
+		status := previous[cluster.Name]
+		delete(previous, cluster.Name)
+		status.Name = cluster.Name
+		status.KubeconfigSecret = cluster.KubeconfigSecret
+
+		rdr, err := r.remoteReconciler(cluster.Name, cluster.KubeconfigSecret)
+		if err == nil {
+			rdr.Trigger = trigger
+			err = rdr.Reconcile(rbacDef)
+			meta.SetStatusCondition(&status.Conditions, rdr.ConflictCondition(rbacDef.Generation))
+		}
+		recordClusterSync(cluster.Name, rbacDef.Name, err)
+
+		condition := metav1.Condition{
+			Type:               rbacmanagerv1beta1.ConditionClusterSynced,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: rbacDef.Generation,
+			Reason:             "Synced",
+			Message:            "Resources were applied to the cluster",
+		}
+		if err != nil {
+			logrus.Errorf("Error reconciling RBACDefinition %v in cluster %v: %v", rbacDef.Name, cluster.Name, err)
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "SyncFailed"
+			condition.Message = err.Error()
+			errs = append(errs, fmt.Errorf("cluster %v: %v", cluster.Name, err))
+		}
+		meta.SetStatusCondition(&status.Conditions, condition)
+		statuses = append(statuses, status)
+	}
+
+	// Clusters dropped from the RBAC Definition keep their status until
+	// their resources are gone so that pruning is retried
+	for _, status := range rbacDef.Status.Clusters {
+		if _, ok := previous[status.Name]; !ok {
+			continue
+		}
+
+		err := r.releaseCluster(rbacDef, status.Name, status.KubeconfigSecret, false)
+		recordClusterSync(status.Name, rbacDef.Name, err)
+		if err != nil {
+			logrus.Errorf("Error pruning RBACDefinition %v from cluster %v: %v", rbacDef.Name, status.Name, err)
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               rbacmanagerv1beta1.ConditionClusterSynced,
+				Status:             metav1.ConditionFalse,
+				ObservedGeneration: rbacDef.Generation,
+				Reason:             "PruneFailed",
+				Message:            err.Error(),
+			})
+			errs = append(errs, fmt.Errorf("cluster %v: %v", status.Name, err))
+			statuses = append(statuses, status)
+			continue
+		}
+		logrus.Infof("Removed RBACDefinition %v from cluster %v", rbacDef.Name, status.Name)
+	}
+
+	if len(statuses) == 0 {
+		statuses = nil
+	}
+	rbacDef.Status.Clusters = statuses
+	return utilerrors.NewAggregate(errs)
+}
+
+// releaseClusters deletes or orphans the resources of an RBAC Definition that
+// is being deleted in every remote cluster it has resources in
+func (r *ReconcileRBACDefinition) releaseClusters(rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
+	orphan := rbacDef.DeletionPolicy == rbacmanagerv1beta1.DeletionPolicyOrphan
+	released := map[string]bool{}
+	errs := []error{}
+
+	release := func(name string, ref rbacmanagerv1beta1.KubeconfigSecretReference) {
+		if released[name] {
+			return
+		}
+		released[name] = true
+
+		err := r.releaseCluster(rbacDef, name, ref, orphan)
+		recordClusterSync(name, rbacDef.Name, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cluster %v: %v", name, err))
+		}
+	}
+
+	for _, cluster := range rbacDef.Clusters {
+		release(cluster.Name, cluster.KubeconfigSecret)
+	}
+	for _, status := range rbacDef.Status.Clusters {
+		release(status.Name, status.KubeconfigSecret)
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// releaseCluster deletes or orphans the resources of an RBAC Definition in a
+// remote cluster. A missing kubeconfig Secret leaves the resources in place,
+// as there is no way to reach the cluster.
+func (r *ReconcileRBACDefinition) releaseCluster(rbacDef *rbacmanagerv1beta1.RBACDefinition, name string, ref rbacmanagerv1beta1.KubeconfigSecretReference, orphan bool) error {
+	rdr, err := r.remoteReconciler(name, ref)
+	if errors.IsNotFound(err) {
+		logrus.Warnf("Leaving resources of RBACDefinition %v in cluster %v in place: %v", rbacDef.Name, name, err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if orphan {
+		return rdr.Orphan(rbacDef)
+	}
+	return rdr.Prune(rbacDef)
+}
+
+func (r *ReconcileRBACDefinition) remoteReconciler(name string, ref rbacmanagerv1beta1.KubeconfigSecretReference) (*reconciler.Reconciler, error) {
+	clientset, err := kube.GetRemoteClientset(r.clientset, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &reconciler.Reconciler{Clientset: clientset, Recorder: r.recorder, Cluster: name}, nil
+}
+
+func recordClusterSync(cluster, rbacDefName string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+		metrics.ErrorCounter.Inc()
+	}
+	metrics.RemoteClusterSyncCounter.WithLabelValues(cluster, rbacDefName, result).Inc()
+}
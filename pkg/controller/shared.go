@@ -17,8 +17,11 @@ limitations under the License.
 package controller
 
 import (
+	"context"
+
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -27,6 +30,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+	"github.com/schlapzz/rbac-manager/pkg/watcher"
 )
 
 // Add creates a new RBACDefinition Controller and adds it to the Manager.
@@ -35,30 +40,79 @@ func Add(mgr manager.Manager) error {
 	var err error
 
 	rbacDef := &rbacmanagerv1beta1.RBACDefinition{}
-	err = addController(mgr, newRbacDefReconciler(mgr), "rbacdefinition", rbacDef)
+	err = mgr.GetFieldIndexer().IndexField(context.TODO(), rbacDef, importsIndex, indexImports)
+	if err != nil {
+		logrus.Errorf("Error indexing RBAC Definition imports")
+		return err
+	}
+
+	c, err := addController(mgr, newRbacDefReconciler(mgr), "rbacdefinition", rbacDef, reconciler.FailureBackoff)
 
 	if err != nil {
 		logrus.Errorf("Error adding RBAC Definition reconciler")
 		return err
 	}
 
+	// Reconcile definitions that import a changed definition
+	err = c.Watch(&source.Kind{Type: rbacDef}, handler.EnqueueRequestsFromMapFunc(importers(mgr.GetClient())))
+	if err != nil {
+		logrus.Errorf("Error watching RBAC Definition imports")
+		return err
+	}
+
+	config := &rbacmanagerv1beta1.RBACManagerConfig{}
+	_, err = addController(mgr, newConfigReconciler(mgr), "rbacmanagerconfig", config, nil)
+
+	if err != nil {
+		logrus.Errorf("Error adding RBAC Manager Config reconciler")
+		return err
+	}
+
+	grant := &rbacmanagerv1beta1.RBACTemporaryGrant{}
+	_, err = addController(mgr, newGrantReconciler(mgr), "rbactemporarygrant", grant, nil)
+
+	if err != nil {
+		logrus.Errorf("Error adding RBAC Temporary Grant reconciler")
+		return err
+	}
+
+	// Without watching namespaces, new and relabeled namespaces and retries
+	// of namespaces that were settling wait for the periodic resync
+	if !watcher.Watches("namespaces") {
+		logrus.Info("Not watching namespaces, changes to them are picked up by the periodic resync")
+		return nil
+	}
+
 	namespace := &corev1.Namespace{}
-	err = addController(mgr, newNamespaceReconciler(mgr), "namespace", namespace)
+	c, err = addController(mgr, newNamespaceReconciler(mgr), "namespace", namespace, nil)
 
 	if err != nil {
 		logrus.Errorf("Error adding Namespace reconciler")
 		return err
 	}
 
+	err = watchSubnamespaceAnchors(mgr, c)
+	if err != nil {
+		logrus.Errorf("Error watching subnamespace anchors")
+		return err
+	}
+
+	err = watchNamespaceRetries(mgr, c)
+	if err != nil {
+		logrus.Errorf("Error watching namespace retries")
+		return err
+	}
+
 	return nil
 }
 
-// add adds a new Controller to mgr with r as the reconcile.Reconciler
-func addController(mgr manager.Manager, r reconcile.Reconciler, name string, cType client.Object) error {
+// add adds a new Controller to mgr with r as the reconcile.Reconciler. A nil
+// rateLimiter uses the controller-runtime default.
+func addController(mgr manager.Manager, r reconcile.Reconciler, name string, cType client.Object, rateLimiter workqueue.RateLimiter) (controller.Controller, error) {
 	// Create a new controller
-	c, err := controller.New(name, mgr, controller.Options{Reconciler: r})
+	c, err := controller.New(name, mgr, controller.Options{Reconciler: r, RateLimiter: rateLimiter})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Watch for changes to Resource
@@ -67,8 +121,8 @@ func addController(mgr manager.Manager, r reconcile.Reconciler, name string, cTy
 	}, &handler.EnqueueRequestForObject{})
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return c, nil
 }
@@ -0,0 +1,134 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package debug serves read-only views of the state RBAC Manager manages,
+// and plans of proposed RBAC Definitions
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+// DefinitionsPath lists RBAC Definitions, DefinitionsPath/<name> shows the
+// plan of a single one
+const DefinitionsPath = "/debug/definitions"
+
+// DefinitionSummary describes whether an RBAC Definition is in sync
+type DefinitionSummary struct {
+	Name   string `json:"name"`
+	InSync bool   `json:"inSync"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Handler serves the plans of existing and proposed RBAC Definitions as JSON
+type Handler struct {
+	Clientset kubernetes.Interface
+	// GetDefinition and ListDefinitions default to reading RBAC Definitions from the cluster
+	GetDefinition   func(name string) (rbacmanagerv1beta1.RBACDefinition, error)
+	ListDefinitions func() (rbacmanagerv1beta1.RBACDefinitionList, error)
+}
+
+// Register adds the handler to mux
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.Handle(DefinitionsPath, h)
+	mux.Handle(DefinitionsPath+"/", h)
+	mux.HandleFunc(PreviewPath, h.servePreview)
+}
+
+// ServeHTTP serves the summary of all RBAC Definitions or the plan of one
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(req.URL.Path, DefinitionsPath), "/")
+	if name == "" {
+		h.serveDefinitions(w)
+		return
+	}
+
+	rbacDef, err := h.getDefinition(name)
+	if apierrors.IsNotFound(err) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	plan, err := h.reconciler().Plan(&rbacDef)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, plan)
+}
+
+func (h *Handler) serveDefinitions(w http.ResponseWriter) {
+	listDefinitions := h.ListDefinitions
+	if listDefinitions == nil {
+		listDefinitions = kube.GetRbacDefinitions
+	}
+	rbacDefs, err := listDefinitions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	summaries := []DefinitionSummary{}
+	for i := range rbacDefs.Items {
+		summary := DefinitionSummary{Name: rbacDefs.Items[i].Name}
+		plan, err := h.reconciler().Plan(&rbacDefs.Items[i])
+		if err != nil {
+			summary.Error = err.Error()
+		} else {
+			summary.InSync = plan.InSync()
+		}
+		summaries = append(summaries, summary)
+	}
+	writeJSON(w, summaries)
+}
+
+func (h *Handler) getDefinition(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+	if h.GetDefinition != nil {
+		return h.GetDefinition(name)
+	}
+	return kube.GetRbacDefinition(name)
+}
+
+func (h *Handler) reconciler() *reconciler.Reconciler {
+	return &reconciler.Reconciler{Clientset: h.Clientset, GetDefinition: h.GetDefinition}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		logrus.Errorf("Error writing debug response: %v", err)
+	}
+}
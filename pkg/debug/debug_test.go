@@ -0,0 +1,170 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+func TestHandler(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "admins"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "admins",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "jan"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "admin",
+		}},
+	}}
+
+	h := &Handler{
+		Clientset: fake.NewSimpleClientset(),
+		GetDefinition: func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+			if name != rbacDef.Name {
+				return rbacDef, apierrors.NewNotFound(schema.GroupResource{Resource: "rbacdefinitions"}, name)
+			}
+			return rbacDef, nil
+		},
+		ListDefinitions: func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+			return rbacmanagerv1beta1.RBACDefinitionList{Items: []rbacmanagerv1beta1.RBACDefinition{rbacDef}}, nil
+		},
+	}
+	mux := http.NewServeMux()
+	h.Register(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DefinitionsPath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	summaries := []DefinitionSummary{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &summaries))
+	assert.Equal(t, []DefinitionSummary{{Name: "admins", InSync: false}}, summaries)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DefinitionsPath+"/admins", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	plan := reconciler.Plan{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &plan))
+	assert.Equal(t, "admins", plan.RBACDefinition)
+	assert.Len(t, plan.Create.ClusterRoleBindings, 1)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DefinitionsPath+"/missing", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, DefinitionsPath+"/admins", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestPreview(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "web"}}})
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "valid" || review.Spec.Token == "viewer" {
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{Username: review.Spec.Token}
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == "valid" && attributes.Resource == "rbacdefinitions" && attributes.Verb == "create"
+		return true, review, nil
+	})
+
+	h := &Handler{
+		Clientset: client,
+		GetDefinition: func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+			return rbacmanagerv1beta1.RBACDefinition{}, apierrors.NewNotFound(schema.GroupResource{Resource: "rbacdefinitions"}, name)
+		},
+	}
+	mux := http.NewServeMux()
+	h.Register(mux)
+	preview := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, PreviewPath, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	proposal := `
+apiVersion: rbacmanager.reactiveops.io/v1beta1
+kind: RBACDefinition
+metadata:
+  name: web
+rbacBindings:
+  - name: web-devs
+    subjects:
+      - kind: Group
+        name: web-devs
+    roleBindings:
+      - clusterRole: edit
+        namespaceSelector:
+          matchLabels:
+            team: web
+`
+
+	assert.Equal(t, http.StatusUnauthorized, preview("", proposal).Code)
+	assert.Equal(t, http.StatusUnauthorized, preview("expired", proposal).Code)
+	assert.Equal(t, http.StatusForbidden, preview("viewer", proposal).Code)
+	assert.Equal(t, http.StatusBadRequest, preview("valid", "rbacBindings: [").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, preview("valid", "metadata:\n  name: web\nconflictPolicy: Sometimes\n").Code)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, PreviewPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	// Namespace selectors are evaluated against the live namespaces, and
+	// nothing is created
+	rec = preview("valid", proposal)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	plan := reconciler.Plan{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &plan))
+	assert.Equal(t, "web", plan.RBACDefinition)
+	if assert.Len(t, plan.Create.RoleBindings, 1) {
+		assert.Equal(t, "web", plan.Create.RoleBindings[0].Namespace)
+	}
+	rbs, err := client.RbacV1().RoleBindings("web").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, rbs.Items)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+// PreviewPath plans an RBAC Definition that is posted as YAML or JSON
+// without creating it
+const PreviewPath = "/preview"
+
+// maxPreviewBytes limits the size of a posted RBAC Definition
+const maxPreviewBytes = 1 << 20
+
+// servePreview plans the posted RBAC Definition against the live cluster. The
+// caller authenticates with a bearer token and has to be allowed to create
+// the RBAC Definition, since the plan shows what it would grant.
+func (h *Handler) servePreview(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := h.authenticate(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxPreviewBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	err = yaml.Unmarshal(body, &rbacDef)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot decode RBAC Definition: %v", err), http.StatusBadRequest)
+		return
+	}
+	if rbacDef.Kind != "" && rbacDef.Kind != "RBACDefinition" {
+		http.Error(w, fmt.Sprintf("expected an RBACDefinition, got %v", rbacDef.Kind), http.StatusBadRequest)
+		return
+	}
+	if rbacDef.Name == "" {
+		http.Error(w, "metadata.name is required", http.StatusBadRequest)
+		return
+	}
+
+	err = h.authorize(user, rbacDef.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err = reconciler.Validate(&rbacDef)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// A proposal for an existing RBAC Definition takes its identity, so that
+	// the resources it owns are compared with the proposed ones
+	live, err := h.getDefinition(rbacDef.Name)
+	if err == nil {
+		rbacDef.UID = live.UID
+		rbacDef.Generation = live.Generation
+	} else if !apierrors.IsNotFound(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	plan, err := h.reconciler().Plan(&rbacDef)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, plan)
+}
+
+// authenticate returns the user the bearer token of req belongs to
+func (h *Handler) authenticate(req *http.Request) (authenticationv1.UserInfo, error) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		return authenticationv1.UserInfo{}, errors.New("a bearer token is required")
+	}
+
+	review, err := h.Clientset.AuthenticationV1().TokenReviews().Create(context.TODO(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authenticationv1.UserInfo{}, fmt.Errorf("cannot review token: %v", err)
+	}
+	if !review.Status.Authenticated {
+		return authenticationv1.UserInfo{}, errors.New("the bearer token is not valid")
+	}
+	return review.Status.User, nil
+}
+
+// authorize returns an error unless user may create the RBAC Definition
+// called name
+func (h *Handler) authorize(user authenticationv1.UserInfo, name string) error {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review, err := h.Clientset.AuthorizationV1().SubjectAccessReviews().Create(context.TODO(), &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Group:    rbacmanagerv1beta1.SchemeGroupVersion.Group,
+				Resource: "rbacdefinitions",
+				Verb:     "create",
+				Name:     name,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot review access: %v", err)
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("%v may not create RBACDefinition %v", user.Username, name)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2018 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// WriteDriftReport creates an RBACDriftReport or updates the existing one of
+// the same name if its content differs
+func WriteDriftReport(report *rbacmanagerv1beta1.RBACDriftReport) error {
+	client, err := getRbacDefClient()
+	if err != nil {
+		return err
+	}
+
+	existing := rbacmanagerv1beta1.RBACDriftReport{}
+	err = client.Get().Resource("rbacdriftreports").Name(report.Name).Do(context.TODO()).Into(&existing)
+	if apierrors.IsNotFound(err) {
+		return client.Post().Resource("rbacdriftreports").Body(report).Do(context.TODO()).Error()
+	} else if err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(existing.OwnerReferences, report.OwnerReferences) &&
+		existing.RBACDefinition == report.RBACDefinition &&
+		existing.ObservedGeneration == report.ObservedGeneration &&
+		existing.Summary == report.Summary &&
+		equality.Semantic.DeepEqual(existing.Repaired, report.Repaired) &&
+		equality.Semantic.DeepEqual(existing.Blocked, report.Blocked) {
+		return nil
+	}
+
+	existing.OwnerReferences = report.OwnerReferences
+	existing.RBACDefinition = report.RBACDefinition
+	existing.ObservedGeneration = report.ObservedGeneration
+	existing.Summary = report.Summary
+	existing.Repaired = report.Repaired
+	existing.Blocked = report.Blocked
+	return client.Put().Resource("rbacdriftreports").Name(report.Name).Body(&existing).Do(context.TODO()).Error()
+}
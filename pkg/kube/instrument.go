@@ -0,0 +1,110 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// Instrument returns a copy of config whose clients record the duration of
+// every request in the kube_request_duration_seconds metric. Watches are
+// left out since they last until they are closed.
+func Instrument(config *rest.Config) *rest.Config {
+	config = rest.CopyConfig(config)
+	config.Wrap(func(next http.RoundTripper) http.RoundTripper {
+		return &instrumentedTransport{next: next}
+	})
+	return config
+}
+
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	kind, verb := requestKindAndVerb(req)
+	if verb == "watch" {
+		return t.next.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.KubeRequestDuration.WithLabelValues(kind, verb, code).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// requestKindAndVerb returns the resource a request to the API server is
+// for, such as clusterrolebindings, and its verb in the terms of RBAC
+func requestKindAndVerb(req *http.Request) (string, string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	// Paths start with /api/<version> or /apis/<group>/<version>
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return "other", strings.ToLower(req.Method)
+	}
+	// Namespaced resources, but not subresources of namespaces themselves
+	if len(parts) >= 3 && parts[0] == "namespaces" && parts[2] != "status" && parts[2] != "finalize" {
+		parts = parts[2:]
+	}
+	if len(parts) == 0 {
+		return "other", strings.ToLower(req.Method)
+	}
+
+	kind := parts[0]
+	named := len(parts) > 1
+	if len(parts) > 2 {
+		kind = parts[0] + "/" + parts[2]
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		if req.URL.Query().Get("watch") == "true" || req.URL.Query().Get("watch") == "1" {
+			return kind, "watch"
+		}
+		if named {
+			return kind, "get"
+		}
+		return kind, "list"
+	case http.MethodPost:
+		return kind, "create"
+	case http.MethodPut:
+		return kind, "update"
+	case http.MethodPatch:
+		return kind, "patch"
+	case http.MethodDelete:
+		if named {
+			return kind, "delete"
+		}
+		return kind, "deletecollection"
+	}
+	return kind, strings.ToLower(req.Method)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2019 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestRequestKindAndVerb(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		kind   string
+		verb   string
+	}{
+		{"GET", "/apis/rbac.authorization.k8s.io/v1/clusterrolebindings?labelSelector=rbac-manager", "clusterrolebindings", "list"},
+		{"GET", "/apis/rbac.authorization.k8s.io/v1/clusterrolebindings?watch=true", "clusterrolebindings", "watch"},
+		{"POST", "/apis/rbac.authorization.k8s.io/v1/namespaces/web/rolebindings", "rolebindings", "create"},
+		{"DELETE", "/apis/rbac.authorization.k8s.io/v1/namespaces/web/rolebindings/devs", "rolebindings", "delete"},
+		{"PATCH", "/api/v1/namespaces/web/serviceaccounts/ci", "serviceaccounts", "patch"},
+		{"PUT", "/apis/rbacmanager.reactiveops.io/v1beta1/rbacdefinitions/devs/status", "rbacdefinitions/status", "update"},
+		{"GET", "/api/v1/namespaces/web", "namespaces", "get"},
+		{"PUT", "/api/v1/namespaces/web/finalize", "namespaces/finalize", "update"},
+		{"GET", "/version", "other", "get"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		kind, verb := requestKindAndVerb(req)
+		assert.Equal(t, test.kind, kind, test.url)
+		assert.Equal(t, test.verb, verb, test.url)
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+	}))
+	defer server.Close()
+
+	clientset, err := kubernetes.NewForConfig(Instrument(&rest.Config{Host: server.URL}))
+	assert.NoError(t, err)
+
+	before := testutil.CollectAndCount(metrics.KubeRequestDuration)
+	_, err = clientset.RbacV1().ClusterRoleBindings().Get(context.TODO(), "missing", metav1.GetOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, before+1, testutil.CollectAndCount(metrics.KubeRequestDuration))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.KubeRequestDuration.WithLabelValues("clusterrolebindings", "get", "404").(prometheus.Histogram)))
+}
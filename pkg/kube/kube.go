@@ -34,9 +34,69 @@ const LabelValue = "reactiveops"
 // Labels is the key/value pair given to all resources managed by RBAC Manager
 var Labels = map[string]string{LabelKey: LabelValue}
 
+// ManagedByAnnotation names the RBAC Definition a resource managed by RBAC Manager belongs to
+const ManagedByAnnotation = "rbacmanager.reactiveops.io/managed-by"
+
+// SpecHashAnnotation holds a hash of the desired state of a resource managed by RBAC Manager
+const SpecHashAnnotation = "rbacmanager.reactiveops.io/spec-hash"
+
+// ManagedMetadataAnnotation lists the keys of the labels and annotations an
+// RBAC Definition set on a Service Account, so that keys it no longer sets
+// can be removed without touching those of other tools
+const ManagedMetadataAnnotation = "rbacmanager.reactiveops.io/managed-metadata"
+
+// ChildMetadataAnnotation lists the keys of the controller wide labels and
+// annotations set on a resource, so that keys no longer configured can be
+// removed without touching those of other tools
+const ChildMetadataAnnotation = "rbacmanager.reactiveops.io/child-metadata"
+
+// CopiedFromAnnotation names the Role a Role managed by RBAC Manager was copied from
+const CopiedFromAnnotation = "rbacmanager.reactiveops.io/copied-from"
+
+// SyncAnnotation triggers a full reconcile of an RBAC Definition whenever its value changes
+const SyncAnnotation = "rbacmanager.reactiveops.io/sync"
+
+// AllowedNamespacesAnnotation limits the namespaces an RBAC Definition can
+// create Role Bindings in to a comma separated list of names or shell patterns
+const AllowedNamespacesAnnotation = "rbacmanager.reactiveops.io/allowed-namespaces"
+
+// ApprovePrunesAnnotation on an RBAC Definition approves deleting up to the
+// given number of resources of each kind in one reconcile, even if that is
+// more than the prune limit allows
+const ApprovePrunesAnnotation = "rbacmanager.reactiveops.io/approve-prunes"
+
+// PendingDeleteAnnotation marks a Cluster Role Binding that is no longer
+// requested with the time its deletion was planned, see the
+// crb-delete-grace-period flag
+const PendingDeleteAnnotation = "rbacmanager.reactiveops.io/pending-delete"
+
+// GrantLabelKey labels resources created for an RBAC Temporary Grant with the name of the grant
+const GrantLabelKey = "rbacmanager.reactiveops.io/grant"
+
+// ExpiresAtAnnotation holds the time at which a resource created for an RBAC Temporary Grant is revoked
+const ExpiresAtAnnotation = "rbacmanager.reactiveops.io/expires-at"
+
 // ListOptions is the default set of options to find resources managed by RBAC Manager
 var ListOptions = metav1.ListOptions{LabelSelector: LabelKey + "=" + LabelValue}
 
+// FieldManager is recorded in the managedFields of every resource RBAC
+// Manager writes, whichever binary or version writes it
+const FieldManager = "rbac-manager"
+
+// CreateOptions, UpdateOptions, and PatchOptions are the options of every
+// write RBAC Manager makes to a resource it manages
+var CreateOptions = metav1.CreateOptions{FieldManager: FieldManager}
+var UpdateOptions = metav1.UpdateOptions{FieldManager: FieldManager}
+var PatchOptions = metav1.PatchOptions{FieldManager: FieldManager}
+
+// ForceApplyOptions are the options of the server-side applies RBAC Manager
+// makes to take over fields that other field managers own
+var ForceApplyOptions = metav1.PatchOptions{FieldManager: FieldManager, Force: func() *bool { force := true; return &force }()}
+
+// LegacyFieldManagers are the field managers earlier versions of RBAC Manager
+// recorded their writes under, named after the binary that made them
+var LegacyFieldManagers = []string{"manager"}
+
 // GetClientsetOrDie returns a new Kubernetes Clientset or dies
 func GetClientsetOrDie() *kubernetes.Clientset {
 	kubeConf, err := config.GetConfig()
@@ -46,7 +106,7 @@ func GetClientsetOrDie() *kubernetes.Clientset {
 		os.Exit(1)
 	}
 
-	clientset, err := kubernetes.NewForConfig(kubeConf)
+	clientset, err := kubernetes.NewForConfig(Instrument(kubeConf))
 
 	if err != nil {
 		logrus.Error(err, "unable to get Kubernetes clientset")
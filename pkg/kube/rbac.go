@@ -0,0 +1,149 @@
+/*
+Copyright 2019 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RBACVersion is the version of the rbac.authorization.k8s.io API that RBAC
+// calls use, see DiscoverRBACVersion
+var RBACVersion = "v1"
+
+// rbacVersions are the versions of the rbac.authorization.k8s.io API RBAC
+// Manager can use, most preferred first
+var rbacVersions = []string{"v1", "v1beta1"}
+
+// DiscoverRBACVersion returns the version of the rbac.authorization.k8s.io
+// API to use with a cluster: the version the cluster prefers if RBAC
+// Manager supports it, or else the most preferred supported version it serves
+func DiscoverRBACVersion(client discovery.DiscoveryInterface) (string, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return "", err
+	}
+
+	for _, group := range groups.Groups {
+		if group.Name != rbacv1.GroupName {
+			continue
+		}
+		served := map[string]bool{}
+		for _, version := range group.Versions {
+			served[version.Version] = true
+		}
+		for _, version := range rbacVersions {
+			if version == group.PreferredVersion.Version {
+				return version, nil
+			}
+		}
+		for _, version := range rbacVersions {
+			if served[version] {
+				return version, nil
+			}
+		}
+		return "", fmt.Errorf("%v is only served in unsupported versions %v", rbacv1.GroupName, group.Versions)
+	}
+
+	return "", fmt.Errorf("%v is not served", rbacv1.GroupName)
+}
+
+// RBACInterface reads and writes RBAC resources as rbac/v1 types, whichever
+// version of the rbac.authorization.k8s.io API it calls
+type RBACInterface interface {
+	ClusterRoles() ClusterRoleInterface
+	ClusterRoleBindings() ClusterRoleBindingInterface
+	Roles(namespace string) RoleInterface
+	RoleBindings(namespace string) RoleBindingInterface
+}
+
+// ClusterRoleInterface holds the calls RBAC Manager makes for Cluster Roles
+type ClusterRoleInterface interface {
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1.ClusterRole, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*rbacv1.ClusterRoleList, error)
+}
+
+// ClusterRoleBindingInterface holds the calls RBAC Manager makes for Cluster
+// Role Bindings
+type ClusterRoleBindingInterface interface {
+	Create(ctx context.Context, clusterRoleBinding *rbacv1.ClusterRoleBinding, opts metav1.CreateOptions) (*rbacv1.ClusterRoleBinding, error)
+	Update(ctx context.Context, clusterRoleBinding *rbacv1.ClusterRoleBinding, opts metav1.UpdateOptions) (*rbacv1.ClusterRoleBinding, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1.ClusterRoleBinding, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*rbacv1.ClusterRoleBindingList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*rbacv1.ClusterRoleBinding, error)
+}
+
+// RoleInterface holds the calls RBAC Manager makes for Roles
+type RoleInterface interface {
+	Create(ctx context.Context, role *rbacv1.Role, opts metav1.CreateOptions) (*rbacv1.Role, error)
+	Update(ctx context.Context, role *rbacv1.Role, opts metav1.UpdateOptions) (*rbacv1.Role, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1.Role, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*rbacv1.RoleList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*rbacv1.Role, error)
+}
+
+// RoleBindingInterface holds the calls RBAC Manager makes for Role Bindings
+type RoleBindingInterface interface {
+	Create(ctx context.Context, roleBinding *rbacv1.RoleBinding, opts metav1.CreateOptions) (*rbacv1.RoleBinding, error)
+	Update(ctx context.Context, roleBinding *rbacv1.RoleBinding, opts metav1.UpdateOptions) (*rbacv1.RoleBinding, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1.RoleBinding, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*rbacv1.RoleBindingList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*rbacv1.RoleBinding, error)
+}
+
+// RBAC returns the RBAC calls of clientset in RBACVersion
+func RBAC(clientset kubernetes.Interface) RBACInterface {
+	if RBACVersion == "v1beta1" {
+		return rbacV1beta1{clientset: clientset}
+	}
+	return rbacV1{clientset: clientset}
+}
+
+// rbacV1 passes RBAC calls to the rbac/v1 client unchanged
+type rbacV1 struct {
+	clientset kubernetes.Interface
+}
+
+func (c rbacV1) ClusterRoles() ClusterRoleInterface {
+	return c.clientset.RbacV1().ClusterRoles()
+}
+
+func (c rbacV1) ClusterRoleBindings() ClusterRoleBindingInterface {
+	return c.clientset.RbacV1().ClusterRoleBindings()
+}
+
+func (c rbacV1) Roles(namespace string) RoleInterface {
+	return c.clientset.RbacV1().Roles(namespace)
+}
+
+func (c rbacV1) RoleBindings(namespace string) RoleBindingInterface {
+	return c.clientset.RbacV1().RoleBindings(namespace)
+}
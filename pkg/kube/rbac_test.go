@@ -0,0 +1,98 @@
+/*
+Copyright 2019 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscoverRBACVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		expected string
+		err      string
+	}{
+		{name: "v1", versions: []string{"rbac.authorization.k8s.io/v1", "rbac.authorization.k8s.io/v1beta1"}, expected: "v1"},
+		{name: "v1beta1 only", versions: []string{"rbac.authorization.k8s.io/v1beta1"}, expected: "v1beta1"},
+		{name: "future version preferred", versions: []string{"rbac.authorization.k8s.io/v2", "rbac.authorization.k8s.io/v1"}, expected: "v1"},
+		{name: "only future versions", versions: []string{"rbac.authorization.k8s.io/v2"}, err: "rbac.authorization.k8s.io is only served in unsupported versions"},
+		{name: "not served", versions: []string{"v1"}, err: "rbac.authorization.k8s.io is not served"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discovery := &fakediscovery.FakeDiscovery{Fake: &fake.NewSimpleClientset().Fake}
+			for _, version := range tt.versions {
+				discovery.Resources = append(discovery.Resources, &metav1.APIResourceList{GroupVersion: version})
+			}
+			version, err := DiscoverRBACVersion(discovery)
+			if tt.err != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, version)
+		})
+	}
+}
+
+func TestRBACV1beta1(t *testing.T) {
+	defer func() { RBACVersion = "v1" }()
+	RBACVersion = "v1beta1"
+
+	client := fake.NewSimpleClientset()
+	ctx := context.TODO()
+	rb := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "devs", Namespace: "web", Labels: Labels},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "devs"}},
+	}
+
+	watcher, err := RBAC(client).RoleBindings("web").Watch(ctx, ListOptions)
+	assert.NoError(t, err)
+	defer watcher.Stop()
+
+	created, err := RBAC(client).RoleBindings("web").Create(ctx, rb, CreateOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, rb.Subjects, created.Subjects)
+
+	// The Role Binding is stored in v1beta1 only
+	_, err = client.RbacV1beta1().RoleBindings("web").Get(ctx, "devs", metav1.GetOptions{})
+	assert.NoError(t, err)
+	v1List, err := client.RbacV1().RoleBindings("web").List(ctx, metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, v1List.Items)
+
+	list, err := RBAC(client).RoleBindings("web").List(ctx, ListOptions)
+	assert.NoError(t, err)
+	assert.Len(t, list.Items, 1)
+	assert.Equal(t, rb.RoleRef, list.Items[0].RoleRef)
+
+	event := <-watcher.ResultChan()
+	watched, ok := event.Object.(*rbacv1.RoleBinding)
+	assert.True(t, ok, "Watch events should carry rbac/v1 objects")
+	assert.Equal(t, "devs", watched.Name)
+}
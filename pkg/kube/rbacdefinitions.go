@@ -55,6 +55,16 @@ func GetRbacDefinitions() (rbacmanagerv1beta1.RBACDefinitionList, error) {
 	return list, err
 }
 
+// UpdateRbacDefinitionStatus writes the status of an RbacDefinition
+func UpdateRbacDefinitionStatus(rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
+	client, err := getRbacDefClient()
+	if err != nil {
+		return err
+	}
+
+	return client.Put().Resource("rbacdefinitions").Name(rbacDef.Name).SubResource("status").Body(rbacDef).Do(context.TODO()).Error()
+}
+
 func getRbacDefClient() (*rest.RESTClient, error) {
 	_ = rbacmanagerv1beta1.AddToScheme(scheme.Scheme)
 	clientConfig := config.GetConfigOrDie()
@@ -0,0 +1,322 @@
+/*
+Copyright 2019 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	rbacv1beta1 "k8s.io/api/rbac/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// rbacV1beta1 makes RBAC calls with the rbac/v1beta1 client, converting
+// objects from and to rbac/v1, whose fields are the same
+type rbacV1beta1 struct {
+	clientset kubernetes.Interface
+}
+
+func (c rbacV1beta1) ClusterRoles() ClusterRoleInterface {
+	return clusterRolesV1beta1{c.clientset.RbacV1beta1().ClusterRoles()}
+}
+
+func (c rbacV1beta1) ClusterRoleBindings() ClusterRoleBindingInterface {
+	return clusterRoleBindingsV1beta1{c.clientset.RbacV1beta1().ClusterRoleBindings()}
+}
+
+func (c rbacV1beta1) Roles(namespace string) RoleInterface {
+	return rolesV1beta1{c.clientset.RbacV1beta1().Roles(namespace)}
+}
+
+func (c rbacV1beta1) RoleBindings(namespace string) RoleBindingInterface {
+	return roleBindingsV1beta1{c.clientset.RbacV1beta1().RoleBindings(namespace)}
+}
+
+type clusterRolesV1beta1 struct {
+	client interface {
+		Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1beta1.ClusterRole, error)
+		List(ctx context.Context, opts metav1.ListOptions) (*rbacv1beta1.ClusterRoleList, error)
+	}
+}
+
+func (c clusterRolesV1beta1) Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1.ClusterRole, error) {
+	return clusterRoleFromV1beta1(c.client.Get(ctx, name, opts))
+}
+
+func (c clusterRolesV1beta1) List(ctx context.Context, opts metav1.ListOptions) (*rbacv1.ClusterRoleList, error) {
+	list, err := c.client.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	converted := &rbacv1.ClusterRoleList{ListMeta: list.ListMeta}
+	for i := range list.Items {
+		clusterRole, _ := clusterRoleFromV1beta1(&list.Items[i], nil)
+		converted.Items = append(converted.Items, *clusterRole)
+	}
+	return converted, nil
+}
+
+type clusterRoleBindingsV1beta1 struct {
+	client interface {
+		Create(ctx context.Context, clusterRoleBinding *rbacv1beta1.ClusterRoleBinding, opts metav1.CreateOptions) (*rbacv1beta1.ClusterRoleBinding, error)
+		Update(ctx context.Context, clusterRoleBinding *rbacv1beta1.ClusterRoleBinding, opts metav1.UpdateOptions) (*rbacv1beta1.ClusterRoleBinding, error)
+		Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+		Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1beta1.ClusterRoleBinding, error)
+		List(ctx context.Context, opts metav1.ListOptions) (*rbacv1beta1.ClusterRoleBindingList, error)
+		Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+		Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*rbacv1beta1.ClusterRoleBinding, error)
+	}
+}
+
+func (c clusterRoleBindingsV1beta1) Create(ctx context.Context, clusterRoleBinding *rbacv1.ClusterRoleBinding, opts metav1.CreateOptions) (*rbacv1.ClusterRoleBinding, error) {
+	return clusterRoleBindingFromV1beta1(c.client.Create(ctx, clusterRoleBindingToV1beta1(clusterRoleBinding), opts))
+}
+
+func (c clusterRoleBindingsV1beta1) Update(ctx context.Context, clusterRoleBinding *rbacv1.ClusterRoleBinding, opts metav1.UpdateOptions) (*rbacv1.ClusterRoleBinding, error) {
+	return clusterRoleBindingFromV1beta1(c.client.Update(ctx, clusterRoleBindingToV1beta1(clusterRoleBinding), opts))
+}
+
+func (c clusterRoleBindingsV1beta1) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.client.Delete(ctx, name, opts)
+}
+
+func (c clusterRoleBindingsV1beta1) Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1.ClusterRoleBinding, error) {
+	return clusterRoleBindingFromV1beta1(c.client.Get(ctx, name, opts))
+}
+
+func (c clusterRoleBindingsV1beta1) List(ctx context.Context, opts metav1.ListOptions) (*rbacv1.ClusterRoleBindingList, error) {
+	list, err := c.client.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	converted := &rbacv1.ClusterRoleBindingList{ListMeta: list.ListMeta}
+	for i := range list.Items {
+		crb, _ := clusterRoleBindingFromV1beta1(&list.Items[i], nil)
+		converted.Items = append(converted.Items, *crb)
+	}
+	return converted, nil
+}
+
+func (c clusterRoleBindingsV1beta1) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return watchFromV1beta1(c.client.Watch(ctx, opts))
+}
+
+func (c clusterRoleBindingsV1beta1) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*rbacv1.ClusterRoleBinding, error) {
+	return clusterRoleBindingFromV1beta1(c.client.Patch(ctx, name, pt, data, opts, subresources...))
+}
+
+type rolesV1beta1 struct {
+	client interface {
+		Create(ctx context.Context, role *rbacv1beta1.Role, opts metav1.CreateOptions) (*rbacv1beta1.Role, error)
+		Update(ctx context.Context, role *rbacv1beta1.Role, opts metav1.UpdateOptions) (*rbacv1beta1.Role, error)
+		Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+		Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1beta1.Role, error)
+		List(ctx context.Context, opts metav1.ListOptions) (*rbacv1beta1.RoleList, error)
+		Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+		Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*rbacv1beta1.Role, error)
+	}
+}
+
+func (c rolesV1beta1) Create(ctx context.Context, role *rbacv1.Role, opts metav1.CreateOptions) (*rbacv1.Role, error) {
+	return roleFromV1beta1(c.client.Create(ctx, roleToV1beta1(role), opts))
+}
+
+func (c rolesV1beta1) Update(ctx context.Context, role *rbacv1.Role, opts metav1.UpdateOptions) (*rbacv1.Role, error) {
+	return roleFromV1beta1(c.client.Update(ctx, roleToV1beta1(role), opts))
+}
+
+func (c rolesV1beta1) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.client.Delete(ctx, name, opts)
+}
+
+func (c rolesV1beta1) Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1.Role, error) {
+	return roleFromV1beta1(c.client.Get(ctx, name, opts))
+}
+
+func (c rolesV1beta1) List(ctx context.Context, opts metav1.ListOptions) (*rbacv1.RoleList, error) {
+	list, err := c.client.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	converted := &rbacv1.RoleList{ListMeta: list.ListMeta}
+	for i := range list.Items {
+		role, _ := roleFromV1beta1(&list.Items[i], nil)
+		converted.Items = append(converted.Items, *role)
+	}
+	return converted, nil
+}
+
+func (c rolesV1beta1) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return watchFromV1beta1(c.client.Watch(ctx, opts))
+}
+
+func (c rolesV1beta1) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*rbacv1.Role, error) {
+	return roleFromV1beta1(c.client.Patch(ctx, name, pt, data, opts, subresources...))
+}
+
+type roleBindingsV1beta1 struct {
+	client interface {
+		Create(ctx context.Context, roleBinding *rbacv1beta1.RoleBinding, opts metav1.CreateOptions) (*rbacv1beta1.RoleBinding, error)
+		Update(ctx context.Context, roleBinding *rbacv1beta1.RoleBinding, opts metav1.UpdateOptions) (*rbacv1beta1.RoleBinding, error)
+		Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+		DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error
+		Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1beta1.RoleBinding, error)
+		List(ctx context.Context, opts metav1.ListOptions) (*rbacv1beta1.RoleBindingList, error)
+		Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+		Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*rbacv1beta1.RoleBinding, error)
+	}
+}
+
+func (c roleBindingsV1beta1) Create(ctx context.Context, roleBinding *rbacv1.RoleBinding, opts metav1.CreateOptions) (*rbacv1.RoleBinding, error) {
+	return roleBindingFromV1beta1(c.client.Create(ctx, roleBindingToV1beta1(roleBinding), opts))
+}
+
+func (c roleBindingsV1beta1) Update(ctx context.Context, roleBinding *rbacv1.RoleBinding, opts metav1.UpdateOptions) (*rbacv1.RoleBinding, error) {
+	return roleBindingFromV1beta1(c.client.Update(ctx, roleBindingToV1beta1(roleBinding), opts))
+}
+
+func (c roleBindingsV1beta1) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.client.Delete(ctx, name, opts)
+}
+
+func (c roleBindingsV1beta1) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	return c.client.DeleteCollection(ctx, opts, listOpts)
+}
+
+func (c roleBindingsV1beta1) Get(ctx context.Context, name string, opts metav1.GetOptions) (*rbacv1.RoleBinding, error) {
+	return roleBindingFromV1beta1(c.client.Get(ctx, name, opts))
+}
+
+func (c roleBindingsV1beta1) List(ctx context.Context, opts metav1.ListOptions) (*rbacv1.RoleBindingList, error) {
+	list, err := c.client.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	converted := &rbacv1.RoleBindingList{ListMeta: list.ListMeta}
+	for i := range list.Items {
+		rb, _ := roleBindingFromV1beta1(&list.Items[i], nil)
+		converted.Items = append(converted.Items, *rb)
+	}
+	return converted, nil
+}
+
+func (c roleBindingsV1beta1) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return watchFromV1beta1(c.client.Watch(ctx, opts))
+}
+
+func (c roleBindingsV1beta1) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*rbacv1.RoleBinding, error) {
+	return roleBindingFromV1beta1(c.client.Patch(ctx, name, pt, data, opts, subresources...))
+}
+
+// watchFromV1beta1 converts the objects of watch events to rbac/v1, so that
+// watchers see the same types in every version
+func watchFromV1beta1(w watch.Interface, err error) (watch.Interface, error) {
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		switch object := event.Object.(type) {
+		case *rbacv1beta1.ClusterRoleBinding:
+			event.Object, _ = clusterRoleBindingFromV1beta1(object, nil)
+		case *rbacv1beta1.Role:
+			event.Object, _ = roleFromV1beta1(object, nil)
+		case *rbacv1beta1.RoleBinding:
+			event.Object, _ = roleBindingFromV1beta1(object, nil)
+		}
+		return event, true
+	}), nil
+}
+
+// The conversions below take and return errors so that they can wrap calls
+// directly. They copy fields by type conversion, which only compiles while
+// the fields of both versions are identical.
+
+func clusterRoleFromV1beta1(in *rbacv1beta1.ClusterRole, err error) (*rbacv1.ClusterRole, error) {
+	if err != nil {
+		return nil, err
+	}
+	out := &rbacv1.ClusterRole{ObjectMeta: in.ObjectMeta, Rules: rulesFromV1beta1(in.Rules)}
+	if in.AggregationRule != nil {
+		aggregationRule := rbacv1.AggregationRule(*in.AggregationRule)
+		out.AggregationRule = &aggregationRule
+	}
+	return out, nil
+}
+
+func clusterRoleBindingToV1beta1(in *rbacv1.ClusterRoleBinding) *rbacv1beta1.ClusterRoleBinding {
+	out := &rbacv1beta1.ClusterRoleBinding{ObjectMeta: in.ObjectMeta, RoleRef: rbacv1beta1.RoleRef(in.RoleRef)}
+	for _, subject := range in.Subjects {
+		out.Subjects = append(out.Subjects, rbacv1beta1.Subject(subject))
+	}
+	return out
+}
+
+func clusterRoleBindingFromV1beta1(in *rbacv1beta1.ClusterRoleBinding, err error) (*rbacv1.ClusterRoleBinding, error) {
+	if err != nil {
+		return nil, err
+	}
+	out := &rbacv1.ClusterRoleBinding{ObjectMeta: in.ObjectMeta, RoleRef: rbacv1.RoleRef(in.RoleRef)}
+	for _, subject := range in.Subjects {
+		out.Subjects = append(out.Subjects, rbacv1.Subject(subject))
+	}
+	return out, nil
+}
+
+func roleToV1beta1(in *rbacv1.Role) *rbacv1beta1.Role {
+	out := &rbacv1beta1.Role{ObjectMeta: in.ObjectMeta}
+	for _, rule := range in.Rules {
+		out.Rules = append(out.Rules, rbacv1beta1.PolicyRule(rule))
+	}
+	return out
+}
+
+func roleFromV1beta1(in *rbacv1beta1.Role, err error) (*rbacv1.Role, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &rbacv1.Role{ObjectMeta: in.ObjectMeta, Rules: rulesFromV1beta1(in.Rules)}, nil
+}
+
+func roleBindingToV1beta1(in *rbacv1.RoleBinding) *rbacv1beta1.RoleBinding {
+	out := &rbacv1beta1.RoleBinding{ObjectMeta: in.ObjectMeta, RoleRef: rbacv1beta1.RoleRef(in.RoleRef)}
+	for _, subject := range in.Subjects {
+		out.Subjects = append(out.Subjects, rbacv1beta1.Subject(subject))
+	}
+	return out
+}
+
+func roleBindingFromV1beta1(in *rbacv1beta1.RoleBinding, err error) (*rbacv1.RoleBinding, error) {
+	if err != nil {
+		return nil, err
+	}
+	out := &rbacv1.RoleBinding{ObjectMeta: in.ObjectMeta, RoleRef: rbacv1.RoleRef(in.RoleRef)}
+	for _, subject := range in.Subjects {
+		out.Subjects = append(out.Subjects, rbacv1.Subject(subject))
+	}
+	return out, nil
+}
+
+func rulesFromV1beta1(in []rbacv1beta1.PolicyRule) []rbacv1.PolicyRule {
+	var out []rbacv1.PolicyRule
+	for _, rule := range in {
+		out = append(out, rbacv1.PolicyRule(rule))
+	}
+	return out
+}
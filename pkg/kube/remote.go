@@ -0,0 +1,80 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// remoteClientset is a clientset built from a version of a kubeconfig Secret
+type remoteClientset struct {
+	resourceVersion string
+	clientset       kubernetes.Interface
+}
+
+var remoteClientsetsMux sync.Mutex
+var remoteClientsets = map[string]remoteClientset{}
+
+// GetRemoteClientset returns a clientset for the cluster described by the
+// kubeconfig in the Secret ref points to, reading the Secret with clientset.
+// Clientsets are reused until the Secret changes.
+func GetRemoteClientset(clientset kubernetes.Interface, ref rbacmanagerv1beta1.KubeconfigSecretReference) (kubernetes.Interface, error) {
+	key := ref.Key
+	if key == "" {
+		key = rbacmanagerv1beta1.DefaultKubeconfigKey
+	}
+
+	secret, err := clientset.CoreV1().Secrets(ref.Namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read kubeconfig Secret %v/%v: %w", ref.Namespace, ref.Name, err)
+	}
+
+	cacheKey := ref.Namespace + "/" + ref.Name + "/" + key
+	remoteClientsetsMux.Lock()
+	defer remoteClientsetsMux.Unlock()
+
+	cached, ok := remoteClientsets[cacheKey]
+	if ok && cached.resourceVersion == secret.ResourceVersion {
+		return cached.clientset, nil
+	}
+
+	kubeconfig, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig Secret %v/%v has no key %v", ref.Namespace, ref.Name, key)
+	}
+
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig in Secret %v/%v: %v", ref.Namespace, ref.Name, err)
+	}
+
+	remote, err := kubernetes.NewForConfig(Instrument(restConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	remoteClientsets[cacheKey] = remoteClientset{resourceVersion: secret.ResourceVersion, clientset: remote}
+	return remote, nil
+}
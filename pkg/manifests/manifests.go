@@ -0,0 +1,429 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package manifests generates the resources RBAC Manager is installed with.
+// RBAC Manager is granted only the permissions the features it is deployed
+// with need, as listed in permissions.
+package manifests
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+
+	"github.com/schlapzz/rbac-manager/deploy"
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+const (
+	// DefaultNamespace is the namespace RBAC Manager is deployed to
+	DefaultNamespace = "rbac-manager"
+	// DefaultImage is the image the deployment of RBAC Manager runs
+	DefaultImage = "quay.io/reactiveops/rbac-manager:v1"
+
+	name            = "rbac-manager"
+	kubeconfigsName = "rbac-manager-kubeconfigs"
+	metricsPort     = 8042
+)
+
+// Features are the settings of RBAC Manager that decide which permissions it
+// needs
+type Features struct {
+	// ManagedNamespaces runs RBAC Manager in namespaced mode, with write
+	// access to these namespaces only
+	ManagedNamespaces []string
+	// CreateNamespaces allows createIfMissing and the DeleteNamespaces
+	// deletion policy, which namespaced mode rejects
+	CreateNamespaces bool
+	// CopyRoles allows roleFrom, which creates Roles with the rules of
+	// other Roles
+	CopyRoles bool
+	// DriftReports writes an RBACDriftReport for every RBAC Definition, as
+	// --drift-reports does
+	DriftReports bool
+	// PreflightBindChecks checks whether roles may be bound before binding
+	// them, as --preflight-bind-checks does
+	PreflightBindChecks bool
+	// RemoteClusters allows clusters, which reads kubeconfigs from Secrets
+	// in the namespace of RBAC Manager
+	RemoteClusters bool
+	// CleanUpTokenSecrets deletes the token Secrets of deleted Service
+	// Accounts, as --clean-up-token-secrets does
+	CleanUpTokenSecrets bool
+	// DebugEndpoints serves the debug endpoints on the metrics port, as
+	// --enable-debug-endpoints does, which review the tokens of /preview
+	DebugEndpoints bool
+	// WatchResources are the kinds of resources watched for changes, as
+	// --watch-resources sets, or every kind if empty
+	WatchResources []string
+}
+
+// DefaultFeatures are the features of RBAC Manager with its default flags
+// and every field of RBAC Definitions allowed
+func DefaultFeatures() Features {
+	return Features{
+		CreateNamespaces:    true,
+		CopyRoles:           true,
+		DriftReports:        true,
+		PreflightBindChecks: true,
+		RemoteClusters:      true,
+		CleanUpTokenSecrets: true,
+	}
+}
+
+func (f Features) namespaced() bool {
+	return len(f.ManagedNamespaces) > 0
+}
+
+func (f Features) watches(resource string) bool {
+	if len(f.WatchResources) == 0 {
+		return true
+	}
+	for _, watched := range f.WatchResources {
+		if watched == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// permission is a rule RBAC Manager needs if needed reports true for the
+// features it is deployed with, or always if needed is nil
+type permission struct {
+	rule   rbacv1.PolicyRule
+	needed func(f Features) bool
+	// namespaced rules are granted in every managed namespace instead of
+	// cluster wide in namespaced mode
+	namespaced bool
+}
+
+// permissions maps the features of RBAC Manager to the rules they need. Rules
+// are kept separate for every feature, so that they read as documentation of
+// what each feature needs.
+var permissions = []permission{{
+	rule: rule(rbacmanagerv1beta1.SchemeGroupVersion.Group, "rbacdefinitions", "get", "list", "watch", "update", "patch"),
+}, {
+	rule: rule(rbacmanagerv1beta1.SchemeGroupVersion.Group, "rbacdefinitions/status", "get", "update", "patch"),
+}, {
+	rule: rule(rbacmanagerv1beta1.SchemeGroupVersion.Group, "rbacmanagerconfigs", "get", "list", "watch"),
+}, {
+	rule: rule(rbacmanagerv1beta1.SchemeGroupVersion.Group, "rbacmanagerconfigs/status", "get", "update", "patch"),
+}, {
+	rule: rule(rbacmanagerv1beta1.SchemeGroupVersion.Group, "rbactemporarygrants", "get", "list", "watch"),
+}, {
+	rule: rule(rbacmanagerv1beta1.SchemeGroupVersion.Group, "rbactemporarygrants/status", "get", "update", "patch"),
+}, {
+	rule:   rule(rbacmanagerv1beta1.SchemeGroupVersion.Group, "rbacdriftreports", "get", "create", "update"),
+	needed: func(f Features) bool { return f.DriftReports },
+}, {
+	rule: rule(corev1.GroupName, "events", "create", "patch"),
+}, {
+	// Namespaces are read across the cluster even in namespaced mode
+	rule: rule(corev1.GroupName, "namespaces", "get", "list"),
+}, {
+	rule:   rule(corev1.GroupName, "namespaces", "watch"),
+	needed: func(f Features) bool { return f.watches("namespaces") },
+}, {
+	rule:   rule(corev1.GroupName, "namespaces", "create", "delete"),
+	needed: func(f Features) bool { return f.CreateNamespaces && !f.namespaced() },
+}, {
+	// Anchors are watched along with namespaces, for the subnamespaces
+	// they create
+	rule:   rule("hnc.x-k8s.io", "subnamespaceanchors", "get", "list", "watch"),
+	needed: func(f Features) bool { return f.watches("namespaces") },
+}, {
+	rule:   rule(rbacv1.GroupName, "clusterrolebindings", "get", "list", "create", "update", "patch", "delete"),
+	needed: func(f Features) bool { return !f.namespaced() },
+}, {
+	rule:   rule(rbacv1.GroupName, "clusterrolebindings", "watch"),
+	needed: func(f Features) bool { return !f.namespaced() && f.watches("clusterrolebindings") },
+}, {
+	// Role Bindings of namespaces that no longer match are deleted as a
+	// collection
+	rule:       rule(rbacv1.GroupName, "rolebindings", "get", "list", "create", "update", "patch", "delete", "deletecollection"),
+	namespaced: true,
+}, {
+	rule:       rule(rbacv1.GroupName, "rolebindings", "watch"),
+	needed:     func(f Features) bool { return f.watches("rolebindings") },
+	namespaced: true,
+}, {
+	rule:       rule(rbacv1.GroupName, "roles", "get", "list"),
+	namespaced: true,
+}, {
+	rule:       rule(rbacv1.GroupName, "roles", "watch"),
+	needed:     func(f Features) bool { return f.watches("roles") },
+	namespaced: true,
+}, {
+	// Copied Roles hold rules RBAC Manager doesn't hold itself, which only
+	// escalate allows
+	rule:       rule(rbacv1.GroupName, "roles", "create", "update", "patch", "delete", "escalate"),
+	needed:     func(f Features) bool { return f.CopyRoles },
+	namespaced: true,
+}, {
+	// Roles are bound without holding their permissions
+	rule:       rule(rbacv1.GroupName, "clusterroles", "bind"),
+	namespaced: true,
+}, {
+	rule:       rule(rbacv1.GroupName, "roles", "bind"),
+	namespaced: true,
+}, {
+	rule:       rule(corev1.GroupName, "serviceaccounts", "get", "list", "create", "update", "patch", "delete"),
+	namespaced: true,
+}, {
+	rule:       rule(corev1.GroupName, "serviceaccounts", "watch"),
+	needed:     func(f Features) bool { return f.watches("serviceaccounts") },
+	namespaced: true,
+}, {
+	// Token Secrets of deleted Service Accounts are deleted with them
+	rule:       rule(corev1.GroupName, "secrets", "list", "delete"),
+	needed:     func(f Features) bool { return f.CleanUpTokenSecrets },
+	namespaced: true,
+}, {
+	rule:   rule("authorization.k8s.io", "selfsubjectaccessreviews", "create"),
+	needed: func(f Features) bool { return f.PreflightBindChecks },
+}, {
+	// Callers of /preview are authenticated by their token and authorized
+	// to create the RBAC Definition they post
+	rule:   rule("authentication.k8s.io", "tokenreviews", "create"),
+	needed: func(f Features) bool { return f.DebugEndpoints },
+}, {
+	rule:   rule("authorization.k8s.io", "subjectaccessreviews", "create"),
+	needed: func(f Features) bool { return f.DebugEndpoints },
+}}
+
+func rule(apiGroup string, resource string, verbs ...string) rbacv1.PolicyRule {
+	return rbacv1.PolicyRule{APIGroups: []string{apiGroup}, Resources: []string{resource}, Verbs: verbs}
+}
+
+// ClusterRoleRules returns the rules RBAC Manager needs cluster wide
+func ClusterRoleRules(f Features) []rbacv1.PolicyRule {
+	return rules(f, func(p permission) bool { return !f.namespaced() || !p.namespaced })
+}
+
+// NamespaceRules returns the rules RBAC Manager needs in every managed
+// namespace in namespaced mode, or nil otherwise
+func NamespaceRules(f Features) []rbacv1.PolicyRule {
+	if !f.namespaced() {
+		return nil
+	}
+	return rules(f, func(p permission) bool { return p.namespaced })
+}
+
+func rules(f Features, include func(p permission) bool) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{}
+	for _, p := range permissions {
+		if include(p) && (p.needed == nil || p.needed(f)) {
+			rules = append(rules, p.rule)
+		}
+	}
+	return rules
+}
+
+// Options configure the generated manifests
+type Options struct {
+	// Namespace RBAC Manager is deployed to
+	Namespace string
+	// Image of RBAC Manager
+	Image    string
+	Features Features
+}
+
+// Objects returns every resource RBAC Manager is installed with, except for
+// its Custom Resource Definitions, in the order they can be applied in
+func Objects(o Options) []runtime.Object {
+	labels := map[string]string{"app": name}
+	objectMeta := func(name, namespace string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}
+	}
+	serviceAccount := rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: o.Namespace}
+
+	objects := []runtime.Object{
+		&corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: objectMeta(o.Namespace, ""),
+		},
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: objectMeta(name, o.Namespace),
+		},
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+			ObjectMeta: objectMeta(name, ""),
+			Rules:      ClusterRoleRules(o.Features),
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: objectMeta(name, ""),
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+			Subjects:   []rbacv1.Subject{serviceAccount},
+		},
+	}
+
+	role := func(name, namespace string, rules []rbacv1.PolicyRule) {
+		objects = append(objects, &rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+			ObjectMeta: objectMeta(name, namespace),
+			Rules:      rules,
+		}, &rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+			ObjectMeta: objectMeta(name, namespace),
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+			Subjects:   []rbacv1.Subject{serviceAccount},
+		})
+	}
+	for _, namespace := range o.Features.ManagedNamespaces {
+		role(name, namespace, NamespaceRules(o.Features))
+	}
+	if o.Features.RemoteClusters {
+		role(kubeconfigsName, o.Namespace, []rbacv1.PolicyRule{rule(corev1.GroupName, "secrets", "get")})
+	}
+
+	return append(objects, deployment(o, objectMeta(name, o.Namespace)))
+}
+
+// args returns the flags RBAC Manager needs for the features, leaving out
+// those that keep their default
+func args(f Features) []string {
+	args := []string{}
+	if f.namespaced() {
+		args = append(args, "--managed-namespaces="+strings.Join(f.ManagedNamespaces, ","))
+	}
+	if !f.DriftReports {
+		args = append(args, "--drift-reports=false")
+	}
+	if !f.PreflightBindChecks {
+		args = append(args, "--preflight-bind-checks=false")
+	}
+	if !f.CleanUpTokenSecrets {
+		args = append(args, "--clean-up-token-secrets=false")
+	}
+	if f.DebugEndpoints {
+		args = append(args, "--enable-debug-endpoints")
+	}
+	if len(f.WatchResources) > 0 {
+		args = append(args, "--watch-resources="+strings.Join(f.WatchResources, ","))
+	}
+	return args
+}
+
+func deployment(o Options, objectMeta metav1.ObjectMeta) *appsv1.Deployment {
+	replicas := int32(1)
+	falseValue := false
+	trueValue := true
+	podLabels := map[string]string{"app": name, "release": name}
+	probe := func(periodSeconds int32) *corev1.Probe {
+		return &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Scheme: corev1.URISchemeHTTP, Path: "/metrics", Port: intstr.FromInt(metricsPort)},
+			},
+			InitialDelaySeconds: 5,
+			TimeoutSeconds:      3,
+			PeriodSeconds:       periodSeconds,
+			FailureThreshold:    3,
+		}
+	}
+	resources := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("100m"),
+		corev1.ResourceMemory: resource.MustParse("128Mi"),
+	}
+
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: appsv1.SchemeGroupVersion.String(), Kind: "Deployment"},
+		ObjectMeta: objectMeta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: podLabels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: name,
+					Containers: []corev1.Container{{
+						Name:            name,
+						Image:           o.Image,
+						ImagePullPolicy: corev1.PullAlways,
+						Args:            args(o.Features),
+						ReadinessProbe:  probe(3),
+						LivenessProbe:   probe(10),
+						SecurityContext: &corev1.SecurityContext{
+							AllowPrivilegeEscalation: &falseValue,
+							Privileged:               &falseValue,
+							ReadOnlyRootFilesystem:   &trueValue,
+							RunAsNonRoot:             &trueValue,
+							Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+						},
+						Resources: corev1.ResourceRequirements{Limits: resources, Requests: resources},
+						Ports: []corev1.ContainerPort{{
+							Name:          "http-metrics",
+							ContainerPort: metricsPort,
+							Protocol:      corev1.ProtocolTCP,
+						}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// Write writes the Custom Resource Definitions and every other resource RBAC
+// Manager is installed with to w as a stream of YAML documents
+func Write(w io.Writer, o Options) error {
+	if _, err := io.WriteString(w, strings.TrimSpace(deploy.CRDs)+"\n"); err != nil {
+		return err
+	}
+
+	for _, object := range Objects(o) {
+		data, err := toYAML(object)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toYAML marshals object without the fields only the API server sets and
+// the empty ones
+func toYAML(object runtime.Object) ([]byte, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, err
+	}
+	delete(content, "status")
+	removeEmpty(content)
+	return yaml.Marshal(content)
+}
+
+func removeEmpty(content map[string]interface{}) {
+	for key, value := range content {
+		if nested, ok := value.(map[string]interface{}); ok {
+			removeEmpty(nested)
+			if len(nested) == 0 {
+				delete(content, key)
+			}
+		} else if value == nil {
+			delete(content, key)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifests
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// allows reports whether rules allow verb on resource in apiGroup
+func allows(rules []rbacv1.PolicyRule, apiGroup, resource, verb string) bool {
+	for _, rule := range rules {
+		if contains(rule.APIGroups, apiGroup) && contains(rule.Resources, resource) && contains(rule.Verbs, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClusterRoleRules(t *testing.T) {
+	type access struct {
+		apiGroup, resource, verb string
+	}
+	escalate := access{rbacv1.GroupName, "roles", "escalate"}
+	createNamespaces := access{"", "namespaces", "create"}
+	driftReports := access{"rbacmanager.reactiveops.io", "rbacdriftreports", "create"}
+	accessReviews := access{"authorization.k8s.io", "selfsubjectaccessreviews", "create"}
+	tokenReviews := access{"authentication.k8s.io", "tokenreviews", "create"}
+	subjectAccessReviews := access{"authorization.k8s.io", "subjectaccessreviews", "create"}
+	bindClusterRoles := access{rbacv1.GroupName, "clusterroles", "bind"}
+	createCRBs := access{rbacv1.GroupName, "clusterrolebindings", "create"}
+	createRBs := access{rbacv1.GroupName, "rolebindings", "create"}
+	createSAs := access{"", "serviceaccounts", "create"}
+	listNamespaces := access{"", "namespaces", "list"}
+	updateStatus := access{"rbacmanager.reactiveops.io", "rbacdefinitions/status", "update"}
+
+	noFeatures := Features{}
+	namespaced := DefaultFeatures()
+	namespaced.ManagedNamespaces = []string{"web", "api"}
+	tests := []struct {
+		name     string
+		features Features
+		allowed  []access
+		denied   []access
+	}{{
+		name:     "every feature",
+		features: DefaultFeatures(),
+		allowed:  []access{escalate, createNamespaces, driftReports, accessReviews, bindClusterRoles, createCRBs, createRBs, createSAs, listNamespaces, updateStatus},
+	}, {
+		name:     "no optional features",
+		features: noFeatures,
+		allowed:  []access{bindClusterRoles, createCRBs, createRBs, createSAs, listNamespaces, updateStatus},
+		denied:   []access{escalate, createNamespaces, driftReports, accessReviews, tokenReviews, subjectAccessReviews},
+	}, {
+		name:     "debug endpoints",
+		features: Features{DebugEndpoints: true},
+		allowed:  []access{tokenReviews, subjectAccessReviews},
+		denied:   []access{accessReviews},
+	}, {
+		name:     "namespaced mode",
+		features: namespaced,
+		allowed:  []access{driftReports, accessReviews, listNamespaces, updateStatus},
+		denied:   []access{escalate, createNamespaces, bindClusterRoles, createCRBs, createRBs, createSAs},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := ClusterRoleRules(tt.features)
+			for _, a := range tt.allowed {
+				assert.True(t, allows(rules, a.apiGroup, a.resource, a.verb), "%v %v/%v should be allowed", a.verb, a.apiGroup, a.resource)
+			}
+			for _, a := range tt.denied {
+				assert.False(t, allows(rules, a.apiGroup, a.resource, a.verb), "%v %v/%v should be denied", a.verb, a.apiGroup, a.resource)
+			}
+			for _, rule := range rules {
+				assert.NotContains(t, rule.Verbs, "*")
+				assert.NotContains(t, rule.Resources, "*")
+			}
+		})
+	}
+}
+
+func TestNamespaceRules(t *testing.T) {
+	assert.Nil(t, NamespaceRules(DefaultFeatures()))
+
+	features := Features{ManagedNamespaces: []string{"web"}}
+	rules := NamespaceRules(features)
+	assert.True(t, allows(rules, rbacv1.GroupName, "rolebindings", "deletecollection"))
+	assert.True(t, allows(rules, rbacv1.GroupName, "clusterroles", "bind"))
+	assert.True(t, allows(rules, "", "serviceaccounts", "delete"))
+	assert.False(t, allows(rules, rbacv1.GroupName, "roles", "escalate"))
+	assert.False(t, allows(rules, rbacv1.GroupName, "clusterrolebindings", "create"))
+
+	assert.False(t, allows(rules, "", "secrets", "delete"))
+
+	features.CopyRoles = true
+	features.CleanUpTokenSecrets = true
+	assert.True(t, allows(NamespaceRules(features), rbacv1.GroupName, "roles", "escalate"))
+	assert.True(t, allows(NamespaceRules(features), "", "secrets", "delete"))
+}
+
+func TestWatchRules(t *testing.T) {
+	rules := ClusterRoleRules(DefaultFeatures())
+	for _, resource := range []string{"clusterrolebindings", "namespaces", "rolebindings", "roles", "serviceaccounts"} {
+		assert.True(t, allows(rules, "", resource, "watch") || allows(rules, rbacv1.GroupName, resource, "watch"), "%v should be watched", resource)
+	}
+
+	features := DefaultFeatures()
+	features.WatchResources = []string{"rolebindings"}
+	rules = ClusterRoleRules(features)
+	assert.True(t, allows(rules, rbacv1.GroupName, "rolebindings", "watch"))
+	assert.False(t, allows(rules, rbacv1.GroupName, "clusterrolebindings", "watch"))
+	assert.False(t, allows(rules, rbacv1.GroupName, "roles", "watch"))
+	assert.False(t, allows(rules, "", "serviceaccounts", "watch"))
+	assert.False(t, allows(rules, "", "namespaces", "watch"))
+	assert.False(t, allows(rules, "hnc.x-k8s.io", "subnamespaceanchors", "watch"))
+	// Resources that aren't watched are still listed by every reconcile
+	assert.True(t, allows(rules, rbacv1.GroupName, "clusterrolebindings", "list"))
+	assert.True(t, allows(rules, "", "namespaces", "list"))
+
+	objects := Objects(Options{Namespace: DefaultNamespace, Image: DefaultImage, Features: features})
+	deployment := objects[len(objects)-1].(*appsv1.Deployment)
+	assert.Equal(t, []string{"--watch-resources=rolebindings"}, deployment.Spec.Template.Spec.Containers[0].Args)
+}
+
+func TestObjects(t *testing.T) {
+	features := Features{ManagedNamespaces: []string{"web", "api"}, RemoteClusters: true}
+	objects := Objects(Options{Namespace: "platform", Image: DefaultImage, Features: features})
+
+	roles := []string{}
+	for _, object := range objects {
+		if role, ok := object.(*rbacv1.Role); ok {
+			roles = append(roles, role.Namespace+"/"+role.Name)
+		}
+	}
+	assert.Equal(t, []string{"web/rbac-manager", "api/rbac-manager", "platform/rbac-manager-kubeconfigs"}, roles)
+
+	deployment, ok := objects[len(objects)-1].(*appsv1.Deployment)
+	assert.True(t, ok)
+	assert.Equal(t, "platform", deployment.Namespace)
+	assert.Equal(t, []string{"--managed-namespaces=web,api", "--drift-reports=false", "--preflight-bind-checks=false", "--clean-up-token-secrets=false"}, deployment.Spec.Template.Spec.Containers[0].Args)
+
+	objects = Objects(Options{Namespace: "platform", Image: DefaultImage, Features: DefaultFeatures()})
+	deployment = objects[len(objects)-1].(*appsv1.Deployment)
+	assert.Empty(t, deployment.Spec.Template.Spec.Containers[0].Args)
+}
+
+func TestWrite(t *testing.T) {
+	out := &bytes.Buffer{}
+	assert.NoError(t, Write(out, Options{Namespace: DefaultNamespace, Image: DefaultImage, Features: DefaultFeatures()}))
+
+	written := out.String()
+	assert.Contains(t, written, "name: rbacdefinitions.rbacmanager.reactiveops.io")
+	assert.Contains(t, written, "image: quay.io/reactiveops/rbac-manager:v1")
+	generated := written[strings.Index(written, "kind: Namespace"):]
+	assert.NotContains(t, generated, "creationTimestamp")
+	assert.NotContains(t, generated, "status:")
+	assert.NotContains(t, generated, "{}")
+	assert.Equal(t, 4, strings.Count(written, "kind: CustomResourceDefinition"))
+	assert.Contains(t, written, "---\napiVersion: apps/v1\nkind: Deployment\n")
+}
@@ -41,6 +41,26 @@ var (
 		[]string{"object", "action"},
 	)
 
+	// ChangeErrors counts failed creates and deletes of Kubernetes objects by category
+	ChangeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "change_errors_total",
+			Help:      "Number of times creating or deleting a Kubernetes object failed, by kind, action, and category of error",
+		},
+		[]string{"kind", "action", "category"},
+	)
+
+	// ReconcileChanges counts changes to managed objects by RBAC Definition, cause, and trigger
+	ReconcileChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconcile_changes_total",
+			Help:      "Number of changes to Kubernetes objects made while reconciling an RBAC Definition, by kind, action, cause, and what triggered the reconcile",
+		},
+		[]string{"rbacdefinition", "kind", "action", "cause", "trigger"},
+	)
+
 	// ReconcileCounter counts controllers invocations
 	ReconcileCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -50,11 +70,219 @@ var (
 		},
 		[]string{"controller"},
 	)
+
+	// ReconcileTimeouts counts reconciles of an RBAC Definition that ran out of time
+	ReconcileTimeouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconcile_timeouts_total",
+			Help:      "Number of times reconciling an RBAC Definition took longer than the reconcile timeout",
+		},
+		[]string{"rbacdefinition"},
+	)
+
+	// DriftRepairedCounter counts managed resources that were restored after being deleted or changed by something else
+	DriftRepairedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "drift_repaired_total",
+			Help:      "Number of times a managed Kubernetes object was restored after being deleted or changed outside of the rbac-manager",
+		},
+		[]string{"kind", "rbacdefinition"},
+	)
+
+	// ForbiddenSubjectsStrippedCounter counts forbidden subjects removed from RBAC Definitions
+	ForbiddenSubjectsStrippedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "forbidden_subjects_stripped_total",
+			Help:      "Number of times a forbidden subject was removed from an RBAC Definition before generating bindings",
+		},
+		[]string{"kind", "rbacdefinition"},
+	)
+
+	// ConsecutiveFailures is the number of times in a row an RBAC Definition failed to reconcile
+	ConsecutiveFailures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "reconcile_consecutive_failures",
+			Help:      "Number of times in a row an RBAC Definition failed to reconcile",
+		},
+		[]string{"rbacdefinition"},
+	)
+
+	// BlockedByPolicy is the number of resources of an RBAC Definition whose creation an admission webhook denied
+	BlockedByPolicy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "blocked_by_policy",
+			Help:      "Number of resources of an RBAC Definition whose creation was denied by an admission webhook, by webhook",
+		},
+		[]string{"rbacdefinition", "webhook"},
+	)
+
+	// RemoteClusterSyncCounter counts attempts to apply RBAC Definitions to remote clusters
+	RemoteClusterSyncCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "remote_cluster_syncs_total",
+			Help:      "Number of times an RBAC Definition was applied to or pruned from a remote cluster, by result",
+		},
+		[]string{"cluster", "rbacdefinition", "result"},
+	)
+
+	// OrphansSweptCounter counts managed resources found whose RBAC Definition no longer exists
+	OrphansSweptCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "orphans_swept_total",
+			Help:      "Number of managed resources whose RBAC Definition no longer exists, by kind and whether they were deleted, re-homed to a recreated definition, or only reported",
+		},
+		[]string{"kind", "action"},
+	)
+
+	// LegacyOwnersMigratedCounter counts resources adopted from owner references written by earlier versions
+	LegacyOwnersMigratedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "legacy_owners_migrated_total",
+			Help:      "Number of managed resources whose legacy owner references and labels were migrated, by kind",
+		},
+		[]string{"kind"},
+	)
+
+	// StaleOwnerEvents counts events of resources owned by an RBAC Definition
+	// that no longer exists, such as those sent while they are garbage collected
+	StaleOwnerEvents = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "stale_owner_events_total",
+			Help:      "Number of events of resources whose owning RBAC Definition no longer exists",
+		})
+
+	// TokenSecretsDeleted counts token Secrets deleted with the managed Service Accounts they belong to
+	TokenSecretsDeleted = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_secrets_deleted_total",
+			Help:      "Number of service account token Secrets deleted along with the managed Service Accounts they belong to",
+		})
+
+	// QueueDepth is the number of RBAC Definitions waiting to be reconciled after watch events
+	QueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queue_depth",
+			Help:      "Number of RBAC Definitions waiting to be reconciled after watch events",
+		})
+
+	// QueueRetries counts reconciles of queued RBAC Definitions that failed and were requeued
+	QueueRetries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "queue_retries_total",
+			Help:      "Number of times a queued RBAC Definition was requeued after failing to reconcile",
+		})
+
+	// QueueWorkDuration observes how long reconciling a queued RBAC Definition takes
+	QueueWorkDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "queue_work_duration_seconds",
+			Help:      "Time taken to reconcile a queued RBAC Definition",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+		})
+
+	// KubeRequestDuration observes how long requests to the API server take
+	KubeRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "kube_request_duration_seconds",
+			Help:      "Time taken by requests to the Kubernetes API server, by resource, verb, and response code",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		},
+		[]string{"kind", "verb", "code"},
+	)
+
+	// WatcherRestarts counts restarts of watchers that exited or stopped heartbeating
+	WatcherRestarts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "watcher_restarts_total",
+			Help:      "Number of times a watcher was restarted after it exited or stopped heartbeating, by watched resource",
+		},
+		[]string{"resource"},
+	)
+
+	// WatcherHeartbeat is the time of the last heartbeat of each watcher
+	WatcherHeartbeat = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "watcher_last_heartbeat_timestamp_seconds",
+			Help:      "Unix time of the last heartbeat of a watcher, by watched resource",
+		},
+		[]string{"resource"},
+	)
+
+	// BindingsWithNoMatch is the number of roleBindings entries of each RBAC
+	// Definition whose namespace selectors match no namespace
+	BindingsWithNoMatch = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "bindings_with_no_match",
+			Help:      "Number of roleBindings entries of an RBAC Definition whose namespace selectors match no namespace",
+		},
+		[]string{"rbacdefinition"},
+	)
+
+	// BlockedPrunes is the number of resources of each kind an RBAC
+	// Definition no longer requests that are kept because deleting them
+	// would exceed the prune limit
+	BlockedPrunes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "blocked_prunes",
+			Help:      "Number of resources an RBAC Definition no longer requests whose deletion is withheld by the prune limit",
+		},
+		[]string{"rbacdefinition", "kind"},
+	)
+
+	// OverlappingGrants is the number of subjects two RBAC Definitions both
+	// bind to the same role in the same namespace, or cluster wide
+	OverlappingGrants = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "overlapping_grants",
+			Help:      "Number of subjects two RBAC Definitions both bind to the same role in the same scope",
+		},
+		[]string{"rbacdefinition", "other_rbacdefinition"},
+	)
 )
 
 // RegisterMetrics must be called exactly once and registers the prometheus counters as metrics
 func RegisterMetrics() {
 	prometheus.MustRegister(ErrorCounter)
 	prometheus.MustRegister(ChangeCounter)
+	prometheus.MustRegister(ChangeErrors)
+	prometheus.MustRegister(ReconcileChanges)
 	prometheus.MustRegister(ReconcileCounter)
+	prometheus.MustRegister(ReconcileTimeouts)
+	prometheus.MustRegister(DriftRepairedCounter)
+	prometheus.MustRegister(ForbiddenSubjectsStrippedCounter)
+	prometheus.MustRegister(ConsecutiveFailures)
+	prometheus.MustRegister(BlockedByPolicy)
+	prometheus.MustRegister(RemoteClusterSyncCounter)
+	prometheus.MustRegister(OrphansSweptCounter)
+	prometheus.MustRegister(LegacyOwnersMigratedCounter)
+	prometheus.MustRegister(StaleOwnerEvents)
+	prometheus.MustRegister(TokenSecretsDeleted)
+	prometheus.MustRegister(QueueDepth)
+	prometheus.MustRegister(QueueRetries)
+	prometheus.MustRegister(QueueWorkDuration)
+	prometheus.MustRegister(KubeRequestDuration)
+	prometheus.MustRegister(WatcherRestarts)
+	prometheus.MustRegister(WatcherHeartbeat)
+	prometheus.MustRegister(BindingsWithNoMatch)
+	prometheus.MustRegister(BlockedPrunes)
+	prometheus.MustRegister(OverlappingGrants)
 }
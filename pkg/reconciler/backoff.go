@@ -0,0 +1,108 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// FailureBackoff delays retries of RBAC Definitions that keep failing to
+// reconcile. It is shared by every queue that reconciles RBAC Definitions so
+// that they agree on how often a definition has failed in a row.
+var FailureBackoff workqueue.RateLimiter = NewFailureBackoff(time.Second, 5*time.Minute)
+
+// failureBackoff is a workqueue.RateLimiter keyed by RBAC Definition name.
+// Unlike the default controller rate limiter it has no shared token bucket,
+// so a definition that keeps failing can't slow down retries of the others.
+type failureBackoff struct {
+	base time.Duration
+	max  time.Duration
+
+	failuresMux sync.Mutex
+	failures    map[string]int
+}
+
+// NewFailureBackoff returns a rate limiter that doubles the delay after each
+// consecutive failure of an RBAC Definition, starting at base and capped at max
+func NewFailureBackoff(base time.Duration, max time.Duration) workqueue.RateLimiter {
+	return &failureBackoff{
+		base:     base,
+		max:      max,
+		failures: map[string]int{},
+	}
+}
+
+// When records a failure of the RBAC Definition item refers to and returns
+// how long to wait before retrying it
+func (b *failureBackoff) When(item interface{}) time.Duration {
+	name := definitionName(item)
+
+	b.failuresMux.Lock()
+	defer b.failuresMux.Unlock()
+
+	b.failures[name]++
+	failures := b.failures[name]
+	metrics.ConsecutiveFailures.WithLabelValues(name).Set(float64(failures))
+
+	delay := b.base
+	for i := 1; i < failures && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	return delay
+}
+
+// Forget resets the failures of the RBAC Definition item refers to
+func (b *failureBackoff) Forget(item interface{}) {
+	name := definitionName(item)
+
+	b.failuresMux.Lock()
+	defer b.failuresMux.Unlock()
+
+	if _, ok := b.failures[name]; ok {
+		delete(b.failures, name)
+		metrics.ConsecutiveFailures.DeleteLabelValues(name)
+	}
+}
+
+// NumRequeues returns how often in a row the RBAC Definition item refers to has failed
+func (b *failureBackoff) NumRequeues(item interface{}) int {
+	b.failuresMux.Lock()
+	defer b.failuresMux.Unlock()
+
+	return b.failures[definitionName(item)]
+}
+
+// definitionName returns the RBAC Definition name of a queue item, which is a
+// reconcile.Request for controllers and a plain name for watcher queues
+func definitionName(item interface{}) string {
+	switch item := item.(type) {
+	case string:
+		return item
+	case reconcile.Request:
+		return item.Name
+	default:
+		return fmt.Sprint(item)
+	}
+}
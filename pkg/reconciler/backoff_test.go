@@ -0,0 +1,54 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestFailureBackoff(t *testing.T) {
+	backoff := NewFailureBackoff(time.Second, 5*time.Minute)
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Name: "poison-pill"}}
+
+	expected := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+		32 * time.Second, 64 * time.Second, 128 * time.Second, 256 * time.Second, 5 * time.Minute, 5 * time.Minute,
+	}
+	for _, delay := range expected {
+		assert.Equal(t, delay, backoff.When(request))
+	}
+
+	// Controller requests and watcher names refer to the same definition
+	assert.Equal(t, len(expected), backoff.NumRequeues("poison-pill"))
+	assert.Equal(t, float64(len(expected)), testutil.ToFloat64(metrics.ConsecutiveFailures.WithLabelValues("poison-pill")))
+
+	// Other definitions are unaffected
+	assert.Equal(t, time.Second, backoff.When("healthy"))
+	backoff.Forget("healthy")
+	assert.Equal(t, 0, backoff.NumRequeues("healthy"))
+	assert.Equal(t, len(expected), backoff.NumRequeues(request))
+
+	backoff.Forget(request)
+	assert.Equal(t, 0, backoff.NumRequeues("poison-pill"))
+	assert.Equal(t, time.Second, backoff.When(request))
+}
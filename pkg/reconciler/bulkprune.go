@@ -0,0 +1,121 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// minBulkPrune is the number of Role Bindings a namespace needs to have
+// pruned before they are deleted with a single DeleteCollection call
+var minBulkPrune = 2
+
+// bulkPruneSelector selects the managed Role Bindings of a namespace, leaving
+// out those created for RBAC Temporary Grants
+var bulkPruneSelector = kube.LabelKey + "=" + kube.LabelValue + ",!" + kube.GrantLabelKey
+
+// bulkPrune is a namespace in which every managed Role Binding is pruned
+type bulkPrune struct {
+	namespace    string
+	roleBindings []rbacv1.RoleBinding
+}
+
+// splitBulkPrunes separates pruned Role Bindings into namespaces in which all
+// of them can be deleted at once, and those that are deleted one by one.
+// managed counts the Role Bindings bulkPruneSelector selected in each
+// namespace when they were listed. Resources don't carry the name of their
+// RBAC Definition in a label, so a namespace only qualifies if the RBAC
+// Definition prunes every one of them and requests none, so that the selector
+// can't match anything that is kept or about to be created.
+func splitBulkPrunes(pruned []rbacv1.RoleBinding, managed map[string]int, requested []rbacv1.RoleBinding) ([]bulkPrune, []rbacv1.RoleBinding) {
+	byNamespace := map[string][]rbacv1.RoleBinding{}
+	for _, rb := range pruned {
+		byNamespace[rb.Namespace] = append(byNamespace[rb.Namespace], rb)
+	}
+
+	kept := map[string]bool{}
+	for _, rb := range requested {
+		kept[rb.Namespace] = true
+	}
+
+	bulk := []bulkPrune{}
+	single := []rbacv1.RoleBinding{}
+	for namespace, rbs := range byNamespace {
+		if len(rbs) >= minBulkPrune && len(rbs) == managed[namespace] && !kept[namespace] {
+			bulk = append(bulk, bulkPrune{namespace: namespace, roleBindings: rbs})
+		} else {
+			single = append(single, rbs...)
+		}
+	}
+
+	sort.Slice(bulk, func(i, j int) bool { return bulk[i].namespace < bulk[j].namespace })
+	sort.Slice(single, func(i, j int) bool {
+		return objectKey("RoleBinding", &single[i].ObjectMeta) < objectKey("RoleBinding", &single[j].ObjectMeta)
+	})
+	return bulk, single
+}
+
+// deleteRoleBindingCollection deletes every managed Role Binding in a
+// namespace with a single call. DeleteCollection can't check the
+// preconditions of each object, so it is only used where everything the
+// selector matches is pruned anyway; when it fails the caller deletes the
+// Role Bindings one by one with preconditions instead.
+func (r *Reconciler) deleteRoleBindingCollection(prune *bulkPrune) error {
+	// The count splitBulkPrunes relied on may come from a cache that hasn't
+	// seen Role Bindings other RBAC Definitions just created, so the selector
+	// is checked against the API server right before deleting
+	err := r.checkBulkPrune(prune)
+	if err != nil {
+		logrus.Infof("Deleting Role Bindings in namespace %v one by one: %v", prune.namespace, err)
+		return err
+	}
+
+	logrus.Infof("Deleting %d Role Bindings in namespace %v", len(prune.roleBindings), prune.namespace)
+	err = kube.RBAC(r.Clientset).RoleBindings(prune.namespace).DeleteCollection(r.context(), metav1.DeleteOptions{}, metav1.ListOptions{LabelSelector: bulkPruneSelector})
+	r.noteWrite("rolebindings")
+	if err != nil {
+		metrics.ChangeErrors.WithLabelValues("RoleBinding", "delete", errorCategory(err)).Inc()
+		logrus.Warnf("Error deleting Role Bindings in namespace %v, deleting them one by one: %v", prune.namespace, err)
+	}
+	return err
+}
+
+// checkBulkPrune returns an error unless every Role Binding bulkPruneSelector
+// currently selects in the namespace of prune is one of its Role Bindings
+func (r *Reconciler) checkBulkPrune(prune *bulkPrune) error {
+	live, err := kube.RBAC(r.Clientset).RoleBindings(prune.namespace).List(r.context(), metav1.ListOptions{LabelSelector: bulkPruneSelector})
+	if err != nil {
+		return err
+	}
+
+	pruned := map[string]bool{}
+	for _, rb := range prune.roleBindings {
+		pruned[rb.Name+"/"+string(rb.UID)] = true
+	}
+	for _, rb := range live.Items {
+		if !pruned[rb.Name+"/"+string(rb.UID)] {
+			return fmt.Errorf("Role Binding %v is not pruned", rb.Name)
+		}
+	}
+	return nil
+}
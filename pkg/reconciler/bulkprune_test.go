@@ -0,0 +1,197 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestSplitBulkPrunes(t *testing.T) {
+	rb := func(namespace, name string) rbacv1.RoleBinding {
+		return rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	pruned := []rbacv1.RoleBinding{
+		rb("web", "edit"), rb("web", "view"),
+		rb("api", "edit"), rb("api", "view"),
+		rb("db", "edit"),
+		rb("ops", "edit"), rb("ops", "view"),
+	}
+
+	// api keeps a managed Role Binding, db only has one to prune, and a Role
+	// Binding is requested in ops
+	bulk, single := splitBulkPrunes(pruned, map[string]int{"web": 2, "api": 3, "db": 1, "ops": 2}, []rbacv1.RoleBinding{rb("ops", "admin")})
+	assert.Equal(t, []bulkPrune{{namespace: "web", roleBindings: []rbacv1.RoleBinding{rb("web", "edit"), rb("web", "view")}}}, bulk)
+	assert.Equal(t, []rbacv1.RoleBinding{rb("api", "edit"), rb("api", "view"), rb("db", "edit"), rb("ops", "edit"), rb("ops", "view")}, single)
+}
+
+// deleteCollectionReactor implements DeleteCollection of Role Bindings, which
+// the fake clientset doesn't support
+func deleteCollectionReactor(client *fake.Clientset, calls *[]string) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deleteAction := action.(k8stesting.DeleteCollectionAction)
+		*calls = append(*calls, deleteAction.GetNamespace())
+		selector := deleteAction.GetListRestrictions().Labels
+		gvr := rbacv1.SchemeGroupVersion.WithResource("rolebindings")
+		list, err := client.Tracker().List(gvr, rbacv1.SchemeGroupVersion.WithKind("RoleBinding"), deleteAction.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		for _, rb := range list.(*rbacv1.RoleBindingList).Items {
+			if selector.Matches(labels.Set(rb.Labels)) {
+				_ = client.Tracker().Delete(gvr, rb.Namespace, rb.Name)
+			}
+		}
+		return true, nil, nil
+	}
+}
+
+func TestReconcileBulkPrunesNamespaces(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "dev"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "api", Labels: map[string]string{"team": "dev"}}},
+	)
+
+	devs := rbacmanagerv1beta1.RBACDefinition{}
+	devs.Name = "devs"
+	devs.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "team",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:       "edit",
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "dev"}},
+		}, {
+			ClusterRole:       "view",
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "dev"}},
+		}},
+	}}
+
+	ops := rbacmanagerv1beta1.RBACDefinition{}
+	ops.Name = "ops"
+	ops.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "oncall",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "sue"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{Namespace: "api", ClusterRole: "admin"}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&devs))
+	assert.NoError(t, r.Reconcile(&ops))
+
+	calls := []string{}
+	client.PrependReactor("delete-collection", "rolebindings", deleteCollectionReactor(client, &calls))
+	deletes := testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "delete"))
+	changes := testutil.ToFloat64(metrics.ReconcileChanges.WithLabelValues("devs", "RoleBinding", "delete", causeSpecChange, string(TriggerUnknown)))
+
+	// Neither namespace is selected any more. All managed Role Bindings in web
+	// go with one call, api keeps the binding of ops.
+	devs.RBACBindings[0].RoleBindings[0].NamespaceSelector.MatchLabels["team"] = "none"
+	devs.RBACBindings[0].RoleBindings[1].NamespaceSelector.MatchLabels["team"] = "none"
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&devs))
+
+	assert.Equal(t, []string{"web"}, calls)
+	singleDeletes := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "delete" && action.GetResource().Resource == "rolebindings" {
+			assert.Equal(t, "api", action.GetNamespace())
+			singleDeletes++
+		}
+	}
+	assert.Equal(t, 2, singleDeletes)
+
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{Name: "ops-oncall-admin", Namespace: "api"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "admin"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "sue"}},
+	}})
+
+	// Every Role Binding is counted, including those deleted together
+	assert.Equal(t, float64(4), testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "delete"))-deletes)
+	assert.Equal(t, float64(4), testutil.ToFloat64(metrics.ReconcileChanges.WithLabelValues("devs", "RoleBinding", "delete", causeSpecChange, string(TriggerUnknown)))-changes)
+}
+
+func TestReconcileBulkPruneFallsBack(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}})
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "devs"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "team",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{
+			{Namespace: "web", ClusterRole: "edit"},
+			{Namespace: "web", ClusterRole: "view"},
+		},
+	}}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	client.PrependReactor("delete-collection", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection reset")
+	})
+
+	// A failed DeleteCollection leaves the Role Bindings to single deletes
+	rbacDef.RBACBindings = nil
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	rbs, err := client.RbacV1().RoleBindings("web").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, rbs.Items)
+}
+
+func TestDeleteRoleBindingCollectionChecksLiveRoleBindings(t *testing.T) {
+	managed := map[string]string{kube.LabelKey: kube.LabelValue}
+	pruned := []rbacv1.RoleBinding{
+		{ObjectMeta: metav1.ObjectMeta{Name: "devs-team-edit", Namespace: "web", Labels: managed, UID: "edit-uid"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "devs-team-view", Namespace: "web", Labels: managed, UID: "view-uid"}},
+	}
+	// Created by another RBAC Definition after the cache was last updated
+	created := &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "ops-oncall-admin", Namespace: "web", Labels: managed, UID: "admin-uid"}}
+	client := fake.NewSimpleClientset(&pruned[0], &pruned[1], created)
+	calls := []string{}
+	client.PrependReactor("delete-collection", "rolebindings", deleteCollectionReactor(client, &calls))
+
+	r := Reconciler{Clientset: client}
+	assert.Error(t, r.deleteRoleBindingCollection(&bulkPrune{namespace: "web", roleBindings: pruned}))
+	assert.Empty(t, calls)
+	_, err := client.RbacV1().RoleBindings("web").Get(context.TODO(), "ops-oncall-admin", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.RbacV1().RoleBindings("web").Delete(context.TODO(), "ops-oncall-admin", metav1.DeleteOptions{}))
+	assert.NoError(t, r.deleteRoleBindingCollection(&bulkPrune{namespace: "web", roleBindings: pruned}))
+	assert.Equal(t, []string{"web"}, calls)
+}
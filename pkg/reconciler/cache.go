@@ -0,0 +1,292 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// DefaultCache is used by Reconcilers that don't have a Cache set. When it is
+// nil, existing resources are listed from the API on every reconcile.
+var DefaultCache *Cache
+
+// CacheGracePeriod is how long after writing a kind of resource a Reconciler
+// lists that kind from the API instead of the cache, giving the informers time
+// to observe the write
+var CacheGracePeriod = 10 * time.Second
+
+// Cache serves the resources rbac-manager manages from shared informers
+type Cache struct {
+	serviceAccounts     corev1listers.ServiceAccountLister
+	clusterRoleBindings rbacv1listers.ClusterRoleBindingLister
+	roleBindings        rbacv1listers.RoleBindingLister
+
+	writesMux sync.Mutex
+	writes    map[string]time.Time
+}
+
+// NewCache starts informers for the resources rbac-manager manages and waits
+// for them to sync. The informers stop when ctx is done.
+func NewCache(ctx context.Context, clientset kubernetes.Interface) (*Cache, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = kube.ListOptions.LabelSelector
+	}))
+
+	c := newCache(
+		factory.Core().V1().ServiceAccounts().Lister(),
+		factory.Rbac().V1().ClusterRoleBindings().Lister(),
+		factory.Rbac().V1().RoleBindings().Lister(),
+	)
+
+	factory.Start(ctx.Done())
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("cache for %v did not sync", informerType)
+		}
+	}
+
+	return c, nil
+}
+
+func newCache(serviceAccounts corev1listers.ServiceAccountLister, clusterRoleBindings rbacv1listers.ClusterRoleBindingLister, roleBindings rbacv1listers.RoleBindingLister) *Cache {
+	return &Cache{
+		serviceAccounts:     serviceAccounts,
+		clusterRoleBindings: clusterRoleBindings,
+		roleBindings:        roleBindings,
+		writes:              map[string]time.Time{},
+	}
+}
+
+// noteWrite records that resources of a kind were just written
+func (c *Cache) noteWrite(resource string) {
+	c.writesMux.Lock()
+	defer c.writesMux.Unlock()
+	c.writes[resource] = time.Now()
+}
+
+// fresh returns false if resources of a kind were written too recently for
+// the cache to be trusted
+func (c *Cache) fresh(resource string) bool {
+	c.writesMux.Lock()
+	defer c.writesMux.Unlock()
+	return time.Since(c.writes[resource]) > CacheGracePeriod
+}
+
+func (r *Reconciler) cache() *Cache {
+	// Caches only hold resources of the cluster RBAC Manager runs in
+	if r.SkipCache || r.Cluster != "" {
+		return nil
+	}
+	if r.Cache != nil {
+		return r.Cache
+	}
+	return DefaultCache
+}
+
+// noteWrite tells the cache, if any, that resources of a kind were just
+// written. It is called once the write returns, so that the grace period isn't
+// used up by a slow write before the informers could have observed it.
+func (r *Reconciler) noteWrite(resource string) {
+	c := r.cache()
+	if c != nil {
+		c.noteWrite(resource)
+	}
+}
+
+// writtenResource returns the resource name writes of a kind are noted under
+func writtenResource(kind string) string {
+	return strings.ToLower(kind) + "s"
+}
+
+func (r *Reconciler) listServiceAccounts() (*v1.ServiceAccountList, error) {
+	c := r.cache()
+	if c == nil || !c.fresh("serviceaccounts") {
+		return listAllServiceAccounts(r.context(), r.Clientset, kube.ListOptions)
+	}
+
+	cached, err := c.serviceAccounts.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	list := &v1.ServiceAccountList{}
+	for _, sa := range cached {
+		list.Items = append(list.Items, *sa)
+	}
+	return list, nil
+}
+
+func (r *Reconciler) listClusterRoleBindings() (*rbacv1.ClusterRoleBindingList, error) {
+	c := r.cache()
+	if c == nil || !c.fresh("clusterrolebindings") {
+		return listAllClusterRoleBindings(r.context(), r.Clientset, kube.ListOptions)
+	}
+
+	cached, err := c.clusterRoleBindings.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	list := &rbacv1.ClusterRoleBindingList{}
+	for _, crb := range cached {
+		list.Items = append(list.Items, *crb)
+	}
+	return list, nil
+}
+
+func (r *Reconciler) listRoleBindings() (*rbacv1.RoleBindingList, error) {
+	c := r.cache()
+	if c == nil || !c.fresh("rolebindings") {
+		return listAllRoleBindings(r.context(), r.Clientset, kube.ListOptions)
+	}
+
+	cached, err := c.roleBindings.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	list := &rbacv1.RoleBindingList{}
+	for _, rb := range cached {
+		list.Items = append(list.Items, *rb)
+	}
+	return list, nil
+}
+
+// ListPageSize is the number of existing resources requested per list call
+// when reconciling. Only one page of them is held in memory at a time, so it
+// bounds the memory a reconcile needs regardless of how many resources RBAC
+// Manager manages in the cluster.
+var ListPageSize int64 = 500
+
+// eachPage lists managed resources one page of ListPageSize at a time, in
+// each listed namespace. list is called with the namespace and options of
+// each page and returns the continue token of the next one.
+func eachPage(list func(namespace string, options metav1.ListOptions) (string, error)) error {
+	for _, namespace := range ListedNamespaces() {
+		options := kube.ListOptions
+		options.Limit = ListPageSize
+		for {
+			next, err := list(namespace, options)
+			if err != nil {
+				return err
+			}
+			if next == "" {
+				break
+			}
+			options.Continue = next
+		}
+	}
+	return nil
+}
+
+// eachServiceAccount calls fn with every managed Service Account, which it
+// must not modify
+func (r *Reconciler) eachServiceAccount(fn func(sa *v1.ServiceAccount)) error {
+	c := r.cache()
+	if c == nil || !c.fresh("serviceaccounts") {
+		return eachPage(func(namespace string, options metav1.ListOptions) (string, error) {
+			list, err := r.Clientset.CoreV1().ServiceAccounts(namespace).List(r.context(), options)
+			if err != nil {
+				return "", err
+			}
+			for i := range list.Items {
+				fn(&list.Items[i])
+			}
+			return list.Continue, nil
+		})
+	}
+
+	cached, err := c.serviceAccounts.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, sa := range cached {
+		fn(sa)
+	}
+	return nil
+}
+
+// eachClusterRoleBinding calls fn with every managed Cluster Role Binding,
+// which it must not modify
+func (r *Reconciler) eachClusterRoleBinding(fn func(crb *rbacv1.ClusterRoleBinding)) error {
+	if NamespacedMode() {
+		return nil
+	}
+
+	c := r.cache()
+	if c == nil || !c.fresh("clusterrolebindings") {
+		return eachPage(func(_ string, options metav1.ListOptions) (string, error) {
+			list, err := kube.RBAC(r.Clientset).ClusterRoleBindings().List(r.context(), options)
+			if err != nil {
+				return "", err
+			}
+			for i := range list.Items {
+				fn(&list.Items[i])
+			}
+			return list.Continue, nil
+		})
+	}
+
+	cached, err := c.clusterRoleBindings.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, crb := range cached {
+		fn(crb)
+	}
+	return nil
+}
+
+// eachRoleBinding calls fn with every managed Role Binding, which it must not
+// modify
+func (r *Reconciler) eachRoleBinding(fn func(rb *rbacv1.RoleBinding)) error {
+	c := r.cache()
+	if c == nil || !c.fresh("rolebindings") {
+		return eachPage(func(namespace string, options metav1.ListOptions) (string, error) {
+			list, err := kube.RBAC(r.Clientset).RoleBindings(namespace).List(r.context(), options)
+			if err != nil {
+				return "", err
+			}
+			for i := range list.Items {
+				fn(&list.Items[i])
+			}
+			return list.Continue, nil
+		})
+	}
+
+	cached, err := c.roleBindings.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, rb := range cached {
+		fn(rb)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// Causes of the changes counted by metrics.ReconcileChanges
+const (
+	// causeSpecChange is a change that follows the requested resources, for
+	// example after the RBAC Definition or a selected namespace changed
+	causeSpecChange = "spec_change"
+	// causeDrift restores a resource deleted or modified by something else
+	causeDrift = "drift"
+	// causePruneOrphan removes a resource whose RBAC Definition is gone
+	causePruneOrphan = "prune_orphan"
+	// causeAdoption takes over an existing resource under the Adopt conflict policy
+	causeAdoption = "adoption"
+)
+
+// changeCause classifies a create or delete by the drift reason of the
+// requested resource it is for
+func changeCause(driftReason string) string {
+	if driftReason != "" {
+		return causeDrift
+	}
+	return causeSpecChange
+}
+
+// recordChange counts a change made to a resource of the RBAC Definition
+// being reconciled, along with the trigger of the reconcile
+func (r *Reconciler) recordChange(kind, action, cause string) {
+	rbacDefName := ""
+	if r.rbacDef != nil {
+		rbacDefName = r.rbacDef.Name
+	}
+	metrics.ReconcileChanges.WithLabelValues(rbacDefName, kind, action, cause, string(r.trigger())).Inc()
+}
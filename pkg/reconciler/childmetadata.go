@@ -0,0 +1,206 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// ChildLabels and ChildAnnotations are set on every Service Account, binding,
+// and Role copy RBAC Manager manages, beneath the labels and annotations
+// requested for the resource itself
+var ChildLabels map[string]string
+var ChildAnnotations map[string]string
+
+// ParseKeyValues parses key=value pairs, as given to the child-labels and
+// child-annotations flags
+func ParseKeyValues(pairs []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		values[parts[0]] = parts[1]
+	}
+	return values, nil
+}
+
+// ValidateChildMetadata checks that labels and annotations are valid and
+// don't replace the label and annotations RBAC Manager tracks resources by
+func ValidateChildMetadata(labels, annotations map[string]string) error {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("label %q is invalid: %v", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("value of label %q is invalid: %v", key, strings.Join(errs, ", "))
+		}
+		if key == kube.LabelKey {
+			return fmt.Errorf("label %q is reserved for RBAC Manager", key)
+		}
+	}
+	for key := range annotations {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("annotation %q is invalid: %v", key, strings.Join(errs, ", "))
+		}
+		if strings.HasPrefix(key, reservedAnnotationPrefix) {
+			return fmt.Errorf("annotation %q is reserved for RBAC Manager", key)
+		}
+	}
+	return nil
+}
+
+// addChildMetadata adds the controller wide labels and annotations to a
+// requested resource, unless it requests the same keys itself, and lists the
+// keys it added in the child-metadata annotation
+func addChildMetadata(objectMeta *metav1.ObjectMeta, childLabels, childAnnotations map[string]string) {
+	if len(childLabels) == 0 && len(childAnnotations) == 0 {
+		return
+	}
+
+	keys := managedMetadata{}
+	labels := map[string]string{}
+	for key, value := range childLabels {
+		if _, ok := objectMeta.Labels[key]; !ok {
+			labels[key] = value
+			keys.Labels = append(keys.Labels, key)
+		}
+	}
+	for key, value := range objectMeta.Labels {
+		labels[key] = value
+	}
+
+	annotations := map[string]string{}
+	for key, value := range childAnnotations {
+		if _, ok := objectMeta.Annotations[key]; !ok {
+			annotations[key] = value
+			keys.Annotations = append(keys.Annotations, key)
+		}
+	}
+	for key, value := range objectMeta.Annotations {
+		annotations[key] = value
+	}
+
+	if len(keys.Labels) > 0 || len(keys.Annotations) > 0 {
+		sort.Strings(keys.Labels)
+		sort.Strings(keys.Annotations)
+		value, _ := json.Marshal(keys)
+		annotations[kube.ChildMetadataAnnotation] = string(value)
+	}
+
+	objectMeta.Labels = labels
+	objectMeta.Annotations = annotations
+}
+
+// childMetadataUpdate is an existing resource whose controller wide labels
+// and annotations are out of date
+type childMetadataUpdate struct {
+	kind      string
+	existing  metav1.Object
+	requested *metav1.ObjectMeta
+	patch     []byte
+}
+
+// staleChildMetadata appends existing to updates if its controller wide
+// labels and annotations differ from those of requested
+func staleChildMetadata(updates []childMetadataUpdate, kind string, existing metav1.Object, requested *metav1.ObjectMeta) []childMetadataUpdate {
+	patch := childMetadataPatch(existing, requested)
+	if patch == nil {
+		return updates
+	}
+	return append(updates, childMetadataUpdate{
+		kind:      kind,
+		existing:  existing.(runtime.Object).DeepCopyObject().(metav1.Object),
+		requested: requested,
+		patch:     patch,
+	})
+}
+
+// childMetadataPatch returns a merge patch that gives existing the controller
+// wide labels and annotations of requested and removes those it got before
+// that are no longer configured, or nil if existing is up to date
+func childMetadataPatch(existing metav1.Object, requested *metav1.ObjectMeta) []byte {
+	previous := metadataKeys(existing.GetAnnotations(), kube.ChildMetadataAnnotation)
+	wanted := metadataKeys(requested.Annotations, kube.ChildMetadataAnnotation)
+
+	labels := metadataChanges(existing.GetLabels(), requested.Labels, previous.Labels, wanted.Labels)
+	annotations := metadataChanges(existing.GetAnnotations(), requested.Annotations, previous.Annotations, wanted.Annotations, []string{kube.ChildMetadataAnnotation})
+	if len(labels) == 0 && len(annotations) == 0 {
+		return nil
+	}
+
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labels,
+			"annotations": annotations,
+		},
+	})
+	return patch
+}
+
+// metadataChanges returns the values of keys in requested that differ from
+// existing, and nil for keys existing has and requested doesn't
+func metadataChanges(existing, requested map[string]string, keys ...[]string) map[string]interface{} {
+	changes := map[string]interface{}{}
+	for _, list := range keys {
+		for _, key := range list {
+			want, requests := requested[key]
+			have, has := existing[key]
+			if requests && (!has || have != want) {
+				changes[key] = want
+			} else if !requests && has {
+				changes[key] = nil
+			}
+		}
+	}
+	return changes
+}
+
+// updateChildMetadata patches the controller wide labels and annotations of
+// existing resources in place
+func (r *Reconciler) updateChildMetadata(updates []childMetadataUpdate) {
+	r.forEach(len(updates), func(i int) {
+		update := updates[i]
+		r.changeLog().Infof("Updating labels and annotations of %v %v", update.kind, update.existing.GetName())
+		var resource string
+		err := r.write(update.kind, "update", update.requested, func() error {
+			var err error
+			resource, err = r.mergePatch(update.kind, update.existing, update.patch)
+			return err
+		})
+		if apierrors.IsNotFound(err) {
+			logrus.Debugf("%v %v was deleted before its labels and annotations could be updated", update.kind, update.existing.GetName())
+		} else if err != nil {
+			logrus.Errorf("Error updating labels and annotations of %v %v: %v", update.kind, update.existing.GetName(), err)
+			metrics.ErrorCounter.Inc()
+		} else {
+			metrics.ChangeCounter.WithLabelValues(resource, "update").Inc()
+			r.recordChange(update.kind, "update", causeSpecChange)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+func TestReconcileChildMetadata(t *testing.T) {
+	defer currentOptions.Store((*Options)(nil))
+	options := DefaultOptions()
+	options.ChildLabels = map[string]string{"app.kubernetes.io/managed-by": "rbac-manager", "tier": "platform"}
+	options.ChildAnnotations = map[string]string{"example.com/cost-center": "1234"}
+	SetOptions(options)
+
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "children"
+	rbacDef.UID = "children-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "bots",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "bot", Namespace: "ci"},
+			Labels:  map[string]string{"tier": "ci"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "children-bots-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "rbac-manager", crb.Labels["app.kubernetes.io/managed-by"])
+	assert.Equal(t, "platform", crb.Labels["tier"])
+	assert.Equal(t, kube.LabelValue, crb.Labels[kube.LabelKey])
+	assert.Equal(t, "1234", crb.Annotations["example.com/cost-center"])
+
+	sa, err := client.CoreV1().ServiceAccounts("ci").Get(context.TODO(), "bot", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", sa.Labels["tier"], "labels requested for a resource should win")
+	assert.Equal(t, "rbac-manager", sa.Labels["app.kubernetes.io/managed-by"])
+
+	// Another tool labels the binding
+	crb.Labels["example.com/audited"] = "true"
+	_, err = client.RbacV1().ClusterRoleBindings().Update(context.TODO(), crb, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	// Changing the controller wide metadata patches existing resources
+	options.ChildLabels = map[string]string{"app.kubernetes.io/managed-by": "rbac-manager"}
+	options.ChildAnnotations = map[string]string{"example.com/cost-center": "5678"}
+	SetOptions(options)
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	for _, action := range client.Actions() {
+		assert.NotContains(t, []string{"create", "delete", "update"}, action.GetVerb(), "resources should only be patched")
+	}
+
+	crb, err = client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "children-bots-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotContains(t, crb.Labels, "tier", "labels no longer configured should be removed")
+	assert.Equal(t, "true", crb.Labels["example.com/audited"], "labels of other tools should be kept")
+	assert.Equal(t, "5678", crb.Annotations["example.com/cost-center"])
+
+	sa, err = client.CoreV1().ServiceAccounts("ci").Get(context.TODO(), "bot", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", sa.Labels["tier"], "labels requested for a resource should be kept")
+	assert.Equal(t, "5678", sa.Annotations["example.com/cost-center"])
+
+	// Nothing is patched once every resource is up to date
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	for _, action := range client.Actions() {
+		assert.NotEqual(t, "patch", action.GetVerb())
+	}
+}
+
+func TestValidateChildMetadata(t *testing.T) {
+	values, err := ParseKeyValues([]string{"team=platform", "empty="})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "platform", "empty": ""}, values)
+
+	_, err = ParseKeyValues([]string{"team"})
+	assert.EqualError(t, err, `"team" is not a key=value pair`)
+
+	assert.NoError(t, ValidateChildMetadata(values, map[string]string{"example.com/cost-center": "1234 / shared"}))
+	assert.EqualError(t, ValidateChildMetadata(map[string]string{kube.LabelKey: "other"}, nil), `label "rbac-manager" is reserved for RBAC Manager`)
+	assert.EqualError(t, ValidateChildMetadata(nil, map[string]string{kube.ManagedByAnnotation: "other"}), `annotation "rbacmanager.reactiveops.io/managed-by" is reserved for RBAC Manager`)
+	assert.Error(t, ValidateChildMetadata(map[string]string{"team": "not a label value"}, nil))
+}
@@ -0,0 +1,217 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// resolveConflict applies the conflict policy of the RBAC Definition being
+// reconciled after a create failed because an object with the same name exists
+func (r *Reconciler) resolveConflict(kind string, objectMeta *metav1.ObjectMeta, get func() (metav1.Object, error), adopt func(existing metav1.Object) error) {
+	name := objectMeta.Name
+	if objectMeta.Namespace != "" {
+		name = objectMeta.Namespace + "/" + objectMeta.Name
+	}
+
+	existing, err := get()
+	if err != nil {
+		logrus.Errorf("Error getting existing %v %v: %v", kind, name, err)
+		metrics.ErrorCounter.Inc()
+		return
+	}
+
+	// Objects that lost the label we list them by are not found when listing,
+	// so they are only noticed once creating them again fails
+	if r.strippedMetadata(existing, objectMeta) {
+		err := r.relabel(kind, existing, objectMeta)
+		if err != nil {
+			logrus.Errorf("Error restoring labels of %v %v: %v", kind, name, err)
+			metrics.ErrorCounter.Inc()
+			return
+		}
+		r.recordApplied(kind, objectMeta)
+		r.noteRepaired(kind, objectMeta, rbacmanagerv1beta1.DriftReasonRelabeled, "lost the labels or owner references of rbac-manager, which have been restored")
+		r.changeLog().Infof("Restored labels of %v %v", kind, name)
+		return
+	}
+
+	// A cache that hasn't caught up yet can hide objects we already manage
+	if r.owns(existing) {
+		r.recordApplied(kind, objectMeta)
+		logrus.Debugf("%v %v already exists and is managed by this RBACDefinition", kind, name)
+		return
+	}
+
+	reason := "it is not managed by this RBACDefinition"
+
+	switch r.conflictPolicy() {
+	case rbacmanagerv1beta1.ConflictPolicySkip:
+		logrus.Debugf("Skipping %v %v, it already exists and is not managed by this RBACDefinition", kind, name)
+		r.noteSkipped(kind, objectMeta)
+		return
+	case rbacmanagerv1beta1.ConflictPolicyAdopt:
+		err := adopt(existing)
+		if err == nil {
+			r.recordApplied(kind, objectMeta)
+			r.changeLog().Infof("Adopted existing %v %v", kind, name)
+			r.event(v1.EventTypeNormal, "Adopted", "Adopted existing %v %v", kind, name)
+			return
+		}
+		reason = err.Error()
+	}
+
+	logrus.Warnf("Cannot create %v %v: %v", kind, name, reason)
+	metrics.ErrorCounter.Inc()
+	r.event(v1.EventTypeWarning, "ResourceConflict", "Cannot create %v %v: %v", kind, name, reason)
+
+	r.conflictsMux.Lock()
+	defer r.conflictsMux.Unlock()
+	r.conflicts = append(r.conflicts, kind+" "+name)
+}
+
+func (r *Reconciler) conflictPolicy() rbacmanagerv1beta1.ConflictPolicy {
+	if r.rbacDef == nil || r.rbacDef.ConflictPolicy == "" {
+		return rbacmanagerv1beta1.ConflictPolicyFail
+	}
+	return r.rbacDef.ConflictPolicy
+}
+
+// setConflictCondition records the conflicts found during the last reconcile
+// in the status of rbacDef
+func (r *Reconciler) setConflictCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, r.ConflictCondition(rbacDef.Generation))
+}
+
+// ConflictCondition describes the conflicts found during the last reconcile
+// of an RBAC Definition with the given generation
+func (r *Reconciler) ConflictCondition(generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionResourceConflict,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             "NoConflicts",
+		Message:            "All requested resources are managed by this RBACDefinition",
+	}
+
+	if len(r.conflicts) > 0 {
+		sort.Strings(r.conflicts)
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "ResourceExists"
+		condition.Message = fmt.Sprintf("Unmanaged resources with requested names exist: %v", strings.Join(r.conflicts, ", "))
+	}
+
+	return condition
+}
+
+func (r *Reconciler) adoptServiceAccount(existing *v1.ServiceAccount, requested *v1.ServiceAccount) error {
+	err := checkAdoptable(&existing.ObjectMeta)
+	if err != nil {
+		return err
+	}
+
+	adoptObjectMeta(&existing.ObjectMeta, &requested.ObjectMeta)
+	existing.ImagePullSecrets = requested.ImagePullSecrets
+	existing.AutomountServiceAccountToken = requested.AutomountServiceAccountToken
+
+	_, err = r.Clientset.CoreV1().ServiceAccounts(existing.Namespace).Update(r.context(), existing, kube.UpdateOptions)
+	r.noteWrite("serviceaccounts")
+	if err != nil {
+		return err
+	}
+
+	metrics.ChangeCounter.WithLabelValues("serviceaccounts", "adopt").Inc()
+	r.recordChange("ServiceAccount", "adopt", causeAdoption)
+	return nil
+}
+
+func (r *Reconciler) adoptClusterRoleBinding(existing *rbacv1.ClusterRoleBinding, requested *rbacv1.ClusterRoleBinding) error {
+	err := checkAdoptable(&existing.ObjectMeta)
+	if err != nil {
+		return err
+	}
+
+	if !roleRefMatches(&existing.RoleRef, &requested.RoleRef) {
+		return fmt.Errorf("it references %v %v instead of %v %v", existing.RoleRef.Kind, existing.RoleRef.Name, requested.RoleRef.Kind, requested.RoleRef.Name)
+	}
+
+	adoptObjectMeta(&existing.ObjectMeta, &requested.ObjectMeta)
+	existing.Subjects = requested.Subjects
+
+	_, err = kube.RBAC(r.Clientset).ClusterRoleBindings().Update(r.context(), existing, kube.UpdateOptions)
+	r.noteWrite("clusterrolebindings")
+	if err != nil {
+		return err
+	}
+
+	metrics.ChangeCounter.WithLabelValues("clusterrolebindings", "adopt").Inc()
+	r.recordChange("ClusterRoleBinding", "adopt", causeAdoption)
+	return nil
+}
+
+func (r *Reconciler) adoptRoleBinding(existing *rbacv1.RoleBinding, requested *rbacv1.RoleBinding) error {
+	err := checkAdoptable(&existing.ObjectMeta)
+	if err != nil {
+		return err
+	}
+
+	if !roleRefMatches(&existing.RoleRef, &requested.RoleRef) {
+		return fmt.Errorf("it references %v %v instead of %v %v", existing.RoleRef.Kind, existing.RoleRef.Name, requested.RoleRef.Kind, requested.RoleRef.Name)
+	}
+
+	adoptObjectMeta(&existing.ObjectMeta, &requested.ObjectMeta)
+	existing.Subjects = requested.Subjects
+
+	_, err = kube.RBAC(r.Clientset).RoleBindings(existing.Namespace).Update(r.context(), existing, kube.UpdateOptions)
+	r.noteWrite("rolebindings")
+	if err != nil {
+		return err
+	}
+
+	metrics.ChangeCounter.WithLabelValues("rolebindings", "adopt").Inc()
+	r.recordChange("RoleBinding", "adopt", causeAdoption)
+	return nil
+}
+
+// checkAdoptable refuses to take over objects another controller is responsible for
+func checkAdoptable(existing *metav1.ObjectMeta) error {
+	controllerRef := metav1.GetControllerOf(existing)
+	if controllerRef != nil {
+		return fmt.Errorf("it is controlled by %v %v", controllerRef.Kind, controllerRef.Name)
+	}
+	return nil
+}
+
+// adoptObjectMeta adds the labels, annotations, and owner references rbac-manager uses to
+// track the requested object to an existing one. Existing owner references are
+// kept, checkAdoptable makes sure none of them is a controller.
+func adoptObjectMeta(existing *metav1.ObjectMeta, requested *metav1.ObjectMeta) {
+	existing.Labels = labels.Merge(existing.Labels, requested.Labels)
+	existing.Annotations = labels.Merge(existing.Annotations, requested.Annotations)
+	existing.OwnerReferences = withOwnerRefs(existing.OwnerReferences, requested.OwnerReferences)
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// DefinitionDependencies returns the names of the RBAC Definitions rbacDef
+// reads while it is parsed, those it imports and those whose Service Accounts
+// it binds through RBACDefinitionServiceAccounts subjects, so that it can be
+// reconciled whenever one of them changes
+func DefinitionDependencies(rbacDef *rbacmanagerv1beta1.RBACDefinition) []string {
+	names := append([]string{}, rbacDef.Imports...)
+	for _, rbacBinding := range rbacDef.RBACBindings {
+		for _, subject := range rbacBinding.Subjects {
+			if subject.Kind == rbacmanagerv1beta1.RBACDefinitionServiceAccountsKind && !stringInSlice(subject.Name, names) {
+				names = append(names, subject.Name)
+			}
+		}
+	}
+	return names
+}
+
+// referencesServiceAccounts reports whether any subject of rbacDef is an
+// RBACDefinitionServiceAccounts subject
+func referencesServiceAccounts(rbacDef *rbacmanagerv1beta1.RBACDefinition) bool {
+	for _, rbacBinding := range rbacDef.RBACBindings {
+		for _, subject := range rbacBinding.Subjects {
+			if subject.Kind == rbacmanagerv1beta1.RBACDefinitionServiceAccountsKind {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expandDefinitionServiceAccounts replaces RBACDefinitionServiceAccounts
+// subjects of bindings with a ServiceAccount subject for every Service Account
+// the named RBAC Definition manages. chain holds the definitions being
+// resolved, which a definition may not refer back to.
+func (p *Parser) expandDefinitionServiceAccounts(bindings []rbacmanagerv1beta1.RBACBinding, chain []string) ([]rbacmanagerv1beta1.RBACBinding, error) {
+	expanded := []rbacmanagerv1beta1.RBACBinding{}
+	for i, rbacBinding := range bindings {
+		subjects := []rbacmanagerv1beta1.Subject{}
+		for j, subject := range rbacBinding.Subjects {
+			if subject.Kind != rbacmanagerv1beta1.RBACDefinitionServiceAccountsKind {
+				subjects = append(subjects, subject)
+				continue
+			}
+
+			managed, err := p.managedServiceAccounts(subject.Name, chain)
+			if err != nil {
+				return nil, newParseError(fmt.Sprintf("rbacBindings[%d]", i), rbacBinding.Name, newParseError(fmt.Sprintf("subjects[%d]", j), "", err))
+			}
+			for _, sa := range managed {
+				if !containsSubject(subjects, &sa) {
+					subjects = append(subjects, sa)
+				}
+			}
+		}
+		rbacBinding.Subjects = subjects
+		expanded = append(expanded, rbacBinding)
+	}
+	return expanded, nil
+}
+
+// managedServiceAccounts returns a ServiceAccount subject for every Service
+// Account the RBAC Definition name creates, sorted by namespace and name. The
+// subjects don't create the Service Accounts again, so they stay owned by
+// that definition.
+func (p *Parser) managedServiceAccounts(name string, chain []string) ([]rbacmanagerv1beta1.Subject, error) {
+	if stringInSlice(name, chain) {
+		return nil, &ParseError{Path: "name", Reason: fmt.Sprintf("RBACDefinition cycle %v -> %v", strings.Join(chain, " -> "), name)}
+	}
+	if maxDepth := CurrentOptions().MaxImportDepth; len(chain) > maxDepth {
+		return nil, &ParseError{Path: "name", Reason: fmt.Sprintf("RBACDefinitions are nested more than %d levels deep", maxDepth)}
+	}
+
+	referenced, err := p.getDefinition(name)
+	if err != nil {
+		return nil, &ParseError{Path: "name", Reason: fmt.Sprintf("cannot read RBACDefinition %v: %v", name, err)}
+	}
+
+	referencedChain := append(append([]string{}, chain...), name)
+	bindings, err := p.importBindings(&referenced, referencedChain)
+	if err != nil {
+		return nil, newParseError("name", "", err)
+	}
+	bindings, err = p.expandDefinitionServiceAccounts(bindings, referencedChain)
+	if err != nil {
+		return nil, newParseError("name", "", err)
+	}
+
+	create := false
+	subjects := []rbacmanagerv1beta1.Subject{}
+	seen := map[string]bool{}
+	for _, rbacBinding := range bindings {
+		for _, subject := range defaultSubjects(rbacBinding.Subjects, &referenced.Defaults) {
+			if subject.Kind != rbacv1.ServiceAccountKind || (subject.CreateServiceAccount != nil && !*subject.CreateServiceAccount) {
+				continue
+			}
+			key := subject.Namespace + "/" + subject.Name
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			subjects = append(subjects, rbacmanagerv1beta1.Subject{
+				Subject:              rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: subject.Name, Namespace: subject.Namespace},
+				CreateServiceAccount: &create,
+			})
+		}
+	}
+
+	sort.SliceStable(subjects, func(i, j int) bool {
+		if subjects[i].Namespace != subjects[j].Namespace {
+			return subjects[i].Namespace < subjects[j].Namespace
+		}
+		return subjects[i].Name < subjects[j].Name
+	})
+	return subjects, nil
+}
+
+func validateDefinitionServiceAccounts(subject *rbacmanagerv1beta1.Subject) error {
+	if subject.Kind != rbacmanagerv1beta1.RBACDefinitionServiceAccountsKind {
+		return nil
+	}
+
+	if subject.Name == "" {
+		return &ParseError{Path: "name", Reason: "RBACDefinition name required"}
+	}
+	if isTemplate(subject.Name) {
+		return &ParseError{Path: "name", Reason: "RBACDefinitionServiceAccounts subjects can't use templates"}
+	}
+	if subject.Namespace != "" || len(subject.ImagePullSecrets) > 0 || subject.RawName {
+		return errors.New("RBACDefinitionServiceAccounts subjects take the name of an RBACDefinition and nothing else")
+	}
+	return nil
+}
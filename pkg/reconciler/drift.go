@@ -0,0 +1,106 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// appliedSpecs maps managed resources to the spec hash they were last known
+// to have in the cluster
+var appliedSpecs = sync.Map{}
+
+func objectKey(kind string, objectMeta *metav1.ObjectMeta) string {
+	return kind + "/" + objectMeta.Namespace + "/" + objectMeta.Name
+}
+
+// appliedKey is the key of a managed resource in appliedSpecs, which tells
+// apart resources with the same name in different clusters
+func (r *Reconciler) appliedKey(kind string, objectMeta *metav1.ObjectMeta) string {
+	key := objectKey(kind, objectMeta)
+	if r.Cluster != "" {
+		key = r.Cluster + ":" + key
+	}
+	return key
+}
+
+// recordApplied remembers that a managed resource is in its requested state
+func (r *Reconciler) recordApplied(kind string, objectMeta *metav1.ObjectMeta) {
+	appliedSpecs.Store(r.appliedKey(kind, objectMeta), objectMeta.Annotations[kube.SpecHashAnnotation])
+	r.noteInPlace(kind, objectMeta)
+}
+
+// ServiceAccountApplied reports whether a Service Account is in the state RBAC
+// Manager last applied to it, so watchers can ignore events for resources it
+// just created or verified. Service Accounts that were created by something
+// else, such as a restore from a backup, or whose content no longer hashes to
+// their spec hash annotation are not.
+func ServiceAccountApplied(sa *v1.ServiceAccount) bool {
+	hash := sa.Annotations[kube.SpecHashAnnotation]
+	appliedHash, ok := appliedSpecs.Load(objectKey("ServiceAccount", &sa.ObjectMeta))
+	return ok && appliedHash == hash && specHash(&sa.ObjectMeta, serviceAccountSpec(sa)) == hash
+}
+
+// forgetApplied stops tracking a managed resource that was deleted on purpose
+func (r *Reconciler) forgetApplied(kind string, objectMeta *metav1.ObjectMeta) {
+	appliedSpecs.Delete(r.appliedKey(kind, objectMeta))
+}
+
+// driftReason explains why a requested resource has to be created even though
+// its spec didn't change, or returns an empty string if it did change or is
+// new. ownedHashes maps the keys of existing resources owned by the RBAC
+// Definition to their spec hashes.
+func (r *Reconciler) driftReason(kind string, requested *metav1.ObjectMeta, ownedHashes map[string]string) string {
+	requestedHash := requested.Annotations[kube.SpecHashAnnotation]
+
+	if existingHash, ok := ownedHashes[objectKey(kind, requested)]; ok {
+		if existingHash == requestedHash {
+			return "modified"
+		}
+		return ""
+	}
+
+	if appliedHash, ok := appliedSpecs.Load(r.appliedKey(kind, requested)); ok && appliedHash == requestedHash {
+		return "deleted"
+	}
+	return ""
+}
+
+// repairedDrift reports that a managed resource was restored after being
+// deleted or modified by something other than rbac-manager
+func (r *Reconciler) repairedDrift(kind string, objectMeta *metav1.ObjectMeta, reason string) {
+	name := objectMeta.Name
+	if objectMeta.Namespace != "" {
+		name = objectMeta.Namespace + "/" + objectMeta.Name
+	}
+
+	rbacDefName := ""
+	if r.rbacDef != nil {
+		rbacDefName = r.rbacDef.Name
+	}
+
+	logrus.Warnf("%v %v was %v outside of rbac-manager and has been restored", kind, name, reason)
+	metrics.DriftRepairedCounter.WithLabelValues(kind, rbacDefName).Inc()
+	r.event(v1.EventTypeWarning, "DriftRepaired", "%v %v was %v outside of rbac-manager and has been restored", kind, name, reason)
+	r.noteRepaired(kind, objectMeta, driftReasons[reason], fmt.Sprintf("was %v outside of rbac-manager and has been restored", reason))
+}
@@ -0,0 +1,165 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// DriftReports writes an RBACDriftReport for every RBAC Definition after it
+// is reconciled
+var DriftReports bool
+
+// WriteDriftReport writes the drift report of an RBAC Definition after it was
+// reconciled if DriftReports is enabled. Reports are informational, so
+// failing to write one doesn't fail the reconcile.
+func (r *Reconciler) WriteDriftReport(rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	if !DriftReports {
+		return
+	}
+
+	err := kube.WriteDriftReport(r.DriftReport(rbacDef))
+	if err != nil {
+		logrus.Errorf("Error writing drift report of RBACDefinition %v: %v", rbacDef.Name, err)
+		metrics.ErrorCounter.Inc()
+	}
+}
+
+// driftReasons maps the reasons of driftReason to those of drift reports
+var driftReasons = map[string]string{
+	"modified": rbacmanagerv1beta1.DriftReasonModified,
+	"deleted":  rbacmanagerv1beta1.DriftReasonDeleted,
+}
+
+// noteRepaired adds a managed resource RBAC Manager restored during the
+// current reconcile to its drift report
+func (r *Reconciler) noteRepaired(kind string, objectMeta *metav1.ObjectMeta, reason, message string) {
+	r.repairedMux.Lock()
+	defer r.repairedMux.Unlock()
+
+	r.repaired = append(r.repaired, rbacmanagerv1beta1.DriftResult{
+		Action:    "create",
+		Kind:      kind,
+		Namespace: objectMeta.Namespace,
+		Name:      objectMeta.Name,
+		Reason:    reason,
+		Message:   message,
+	})
+}
+
+// DriftReport returns the drift report of an RBAC Definition after Reconcile
+// or ReportPlan was called for it. It lists the resources repaired during that
+// reconcile, the planned changes of definitions in report only mode, and the
+// resources admission webhooks keep denying.
+func (r *Reconciler) DriftReport(rbacDef *rbacmanagerv1beta1.RBACDefinition) *rbacmanagerv1beta1.RBACDriftReport {
+	report := &rbacmanagerv1beta1.RBACDriftReport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            rbacDef.Name,
+			OwnerReferences: rbacDefOwnerRefs(rbacDef),
+		},
+		RBACDefinition:     rbacDef.Name,
+		ObservedGeneration: rbacDef.Generation,
+	}
+
+	r.repairedMux.Lock()
+	repaired := append([]rbacmanagerv1beta1.DriftResult{}, r.repaired...)
+	r.repairedMux.Unlock()
+	sortDriftResults(repaired)
+	report.Summary.Repaired = len(repaired)
+	report.Repaired = limitDriftResults(repaired)
+
+	blocked := []rbacmanagerv1beta1.DriftResult{}
+	if rbacDef.SyncMode == rbacmanagerv1beta1.SyncModeReportOnly {
+		for _, change := range rbacDef.Status.PlannedChanges {
+			blocked = append(blocked, rbacmanagerv1beta1.DriftResult{
+				Action:    change.Action,
+				Kind:      change.Kind,
+				Namespace: change.Namespace,
+				Name:      change.Name,
+				Reason:    rbacmanagerv1beta1.DriftReasonReportOnly,
+				Message:   "not applied in report only mode",
+			})
+		}
+		report.Summary.Blocked = rbacDef.Status.PlannedChangesOmitted
+	} else {
+		blocked = append(blocked, r.policyBlocked(rbacDef)...)
+	}
+	sortDriftResults(blocked)
+	report.Summary.Blocked += len(blocked)
+	report.Blocked = limitDriftResults(blocked)
+
+	return report
+}
+
+// policyBlocked returns the resources of rbacDef in the cluster of the
+// Reconciler whose creation admission webhooks denied
+func (r *Reconciler) policyBlocked(rbacDef *rbacmanagerv1beta1.RBACDefinition) []rbacmanagerv1beta1.DriftResult {
+	b := PolicyBlocks
+	b.blockedMux.Lock()
+	defer b.blockedMux.Unlock()
+
+	blocked := []rbacmanagerv1beta1.DriftResult{}
+	for _, block := range b.blocked {
+		if block.definition == rbacDef.Name && block.cluster == r.Cluster {
+			blocked = append(blocked, rbacmanagerv1beta1.DriftResult{
+				Action:    "create",
+				Kind:      block.kind,
+				Namespace: block.namespace,
+				Name:      block.name,
+				Reason:    rbacmanagerv1beta1.DriftReasonAdmissionDenied,
+				Message:   fmt.Sprintf("admission webhook %q denied the request: %v", block.denial.webhook, block.denial.message),
+			})
+		}
+	}
+	return blocked
+}
+
+// sortDriftResults orders results by kind, namespace, name, and action so that
+// reports only change when their results do
+func sortDriftResults(results []rbacmanagerv1beta1.DriftResult) {
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Action < b.Action
+	})
+}
+
+// limitDriftResults returns at most MaxDriftResults of results, or nil if
+// there are none
+func limitDriftResults(results []rbacmanagerv1beta1.DriftResult) []rbacmanagerv1beta1.DriftResult {
+	if len(results) == 0 {
+		return nil
+	}
+	if len(results) > rbacmanagerv1beta1.MaxDriftResults {
+		return results[:rbacmanagerv1beta1.MaxDriftResults]
+	}
+	return results
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestDriftReport(t *testing.T) {
+	PolicyBlocks = NewPolicyBlocks(time.Hour, time.Hour)
+	defer func() {
+		PolicyBlocks.Forget("report")
+		PolicyBlocks = NewPolicyBlocks(time.Minute, time.Hour)
+	}()
+
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "web", map[string]string{})
+	createNamespace(t, client, "api", map[string]string{})
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "report"
+	rbacDef.UID = "report-uid"
+	rbacDef.Generation = 3
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole: "edit",
+			Namespaces:  []string{"web", "api"},
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	report := r.DriftReport(&rbacDef)
+	assert.Equal(t, "report", report.Name)
+	assert.Equal(t, rbacDefOwnerRefs(&rbacDef), report.OwnerReferences)
+	assert.Equal(t, int64(3), report.ObservedGeneration)
+	assert.Equal(t, rbacmanagerv1beta1.DriftSummary{}, report.Summary, "creating new bindings is not drift")
+	assert.Nil(t, report.Repaired)
+	assert.Nil(t, report.Blocked)
+
+	// A binding deleted by something else is repaired while a policy engine
+	// denies recreating the other one
+	for _, namespace := range []string{"web", "api"} {
+		err := client.RbacV1().RoleBindings(namespace).Delete(context.TODO(), "report-devs-edit", metav1.DeleteOptions{})
+		assert.NoError(t, err)
+	}
+	client.PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() != "api" {
+			return false, nil, nil
+		}
+		return true, nil, &apierrors.StatusError{ErrStatus: metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    400,
+			Reason:  metav1.StatusReasonBadRequest,
+			Message: "admission webhook \"validate.kyverno.svc-fail\" denied the request: no edit in api",
+		}}
+	})
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	report = r.DriftReport(&rbacDef)
+	assert.Equal(t, rbacmanagerv1beta1.DriftSummary{Repaired: 1, Blocked: 1}, report.Summary)
+	assert.Equal(t, []rbacmanagerv1beta1.DriftResult{{
+		Action:    "create",
+		Kind:      "RoleBinding",
+		Namespace: "web",
+		Name:      "report-devs-edit",
+		Reason:    rbacmanagerv1beta1.DriftReasonDeleted,
+		Message:   "was deleted outside of rbac-manager and has been restored",
+	}}, report.Repaired)
+	assert.Equal(t, []rbacmanagerv1beta1.DriftResult{{
+		Action:    "create",
+		Kind:      "RoleBinding",
+		Namespace: "api",
+		Name:      "report-devs-edit",
+		Reason:    rbacmanagerv1beta1.DriftReasonAdmissionDenied,
+		Message:   "admission webhook \"validate.kyverno.svc-fail\" denied the request: no edit in api",
+	}}, report.Blocked)
+
+	// Repairs are only reported for the reconcile that made them
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	report = r.DriftReport(&rbacDef)
+	assert.Equal(t, rbacmanagerv1beta1.DriftSummary{Blocked: 1}, report.Summary)
+	assert.Nil(t, report.Repaired)
+
+	// Definitions in report only mode report their planned changes instead
+	rbacDef.SyncMode = rbacmanagerv1beta1.SyncModeReportOnly
+	rbacDef.RBACBindings[0].RoleBindings[0].Namespaces = []string{"web"}
+	rbacDef.RBACBindings[0].Subjects[0].Name = "jane"
+	assert.NoError(t, r.ReportPlan(&rbacDef))
+	report = r.DriftReport(&rbacDef)
+	assert.Equal(t, rbacmanagerv1beta1.DriftSummary{Blocked: 2}, report.Summary)
+	for _, result := range report.Blocked {
+		assert.Equal(t, rbacmanagerv1beta1.DriftReasonReportOnly, result.Reason)
+		assert.Equal(t, "web", result.Namespace)
+	}
+	assert.Equal(t, "create", report.Blocked[0].Action)
+	assert.Equal(t, "delete", report.Blocked[1].Action)
+}
+
+func TestDriftReportLimitsResults(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "many"
+	rbacDef.SyncMode = rbacmanagerv1beta1.SyncModeReportOnly
+	for i := 0; i < rbacmanagerv1beta1.MaxPlannedChanges; i++ {
+		rbacDef.Status.PlannedChanges = append(rbacDef.Status.PlannedChanges, rbacmanagerv1beta1.PlannedChange{
+			Action: "create",
+			Kind:   "RoleBinding",
+			Name:   "binding",
+		})
+	}
+	rbacDef.Status.PlannedChangesOmitted = 20
+
+	r := Reconciler{}
+	report := r.DriftReport(&rbacDef)
+	assert.Equal(t, rbacmanagerv1beta1.MaxPlannedChanges+20, report.Summary.Blocked)
+	assert.Len(t, report.Blocked, rbacmanagerv1beta1.MaxDriftResults)
+}
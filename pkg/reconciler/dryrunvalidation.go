@@ -0,0 +1,119 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// ValidateBeforeApply dry runs every create of a kind of resource before
+// making any of them. Resources the API server rejects are reported and
+// skipped, and existing resources they would replace are kept, instead of
+// applying part of a broken batch. It doubles the calls for creates.
+var ValidateBeforeApply bool
+
+// rejection is a requested resource the API server rejected in a dry run
+type rejection struct {
+	object string
+	reason string
+}
+
+// validateCreates dry runs the create of count resources of kind, whose
+// metadata objectMeta returns, and records those the API server rejects so
+// that they aren't written
+func (r *Reconciler) validateCreates(kind string, count int, objectMeta func(i int) *metav1.ObjectMeta, dryRun func(i int) error) {
+	if !ValidateBeforeApply {
+		return
+	}
+
+	r.forEach(count, func(i int) {
+		requested := objectMeta(i)
+		err := dryRun(i)
+		if err == nil {
+			return
+		}
+
+		category := errorCategory(err)
+		if category != categoryPolicy && category != categoryForbidden && category != categoryInvalid {
+			// Leave it to the create to report the problem
+			logrus.Debugf("Error dry running create of %v %v: %v", kind, requested.Name, err)
+			return
+		}
+
+		object := kind + " " + requested.Name
+		if requested.Namespace != "" {
+			object = kind + " " + requested.Namespace + "/" + requested.Name
+		}
+		metrics.ChangeErrors.WithLabelValues(kind, "validate", category).Inc()
+		logrus.Warnf("Not creating %v, the API server rejected it in a dry run: %v", object, err)
+		r.event(v1.EventTypeWarning, "ValidationFailed", "Not creating %v, the API server rejected it in a dry run: %v", object, err)
+
+		r.rejectionsMux.Lock()
+		defer r.rejectionsMux.Unlock()
+		if r.rejections == nil {
+			r.rejections = map[string]rejection{}
+		}
+		r.rejections[objectKey(kind, requested)] = rejection{object: object, reason: err.Error()}
+	})
+}
+
+// rejectedByDryRun reports whether the create of a resource was rejected in
+// a dry run during the current reconcile
+func (r *Reconciler) rejectedByDryRun(kind string, objectMeta *metav1.ObjectMeta) bool {
+	r.rejectionsMux.Lock()
+	defer r.rejectionsMux.Unlock()
+	_, ok := r.rejections[objectKey(kind, objectMeta)]
+	return ok
+}
+
+// setValidationFailedCondition records the resources dry runs rejected
+// during the last reconcile in the status of rbacDef
+func (r *Reconciler) setValidationFailedCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	if !ValidateBeforeApply {
+		meta.RemoveStatusCondition(&rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionValidationFailed)
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionValidationFailed,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rbacDef.Generation,
+		Reason:             "ValidationPassed",
+		Message:            "The API server accepted every resource to create",
+	}
+
+	rejected := []string{}
+	for _, rejection := range r.rejections {
+		rejected = append(rejected, fmt.Sprintf("%v (%v)", rejection.object, rejection.reason))
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "DryRunRejected"
+		condition.Message = fmt.Sprintf("The API server rejected: %v", strings.Join(rejected, "; "))
+	}
+
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, condition)
+}
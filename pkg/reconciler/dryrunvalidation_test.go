@@ -0,0 +1,108 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestReconcileValidateBeforeApply(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "validated"
+	rbacDef.UID = "validated-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "ops",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "edit"}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	// Someone changes the binding to edit, so it is replaced
+	crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "validated-ops-edit", metav1.GetOptions{})
+	assert.NoError(t, err)
+	crb.Subjects = append(crb.Subjects, rbacv1.Subject{Kind: rbacv1.UserKind, Name: "mallory"})
+	_, err = client.RbacV1().ClusterRoleBindings().Update(context.TODO(), crb, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	ValidateBeforeApply = true
+	defer func() { ValidateBeforeApply = false }()
+	rbacDef.RBACBindings[0].ClusterRoleBindings = append(rbacDef.RBACBindings[0].ClusterRoleBindings,
+		rbacmanagerv1beta1.ClusterRoleBinding{ClusterRole: "view"},
+		rbacmanagerv1beta1.ClusterRoleBinding{ClusterRole: "broken"})
+
+	// The fake clientset ignores dry runs, so the first create of each
+	// binding is treated as one. Bindings to edit and broken are rejected.
+	creates := map[string]int{}
+	client.PrependReactor("create", "clusterrolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		crb := action.(k8stesting.CreateAction).GetObject().(*rbacv1.ClusterRoleBinding)
+		creates[crb.Name]++
+		if creates[crb.Name] > 1 {
+			return false, nil, nil
+		}
+		if crb.RoleRef.Name == "view" {
+			return true, crb, nil
+		}
+		return true, nil, apierrors.NewInvalid(schema.GroupKind{Group: rbacv1.GroupName, Kind: "ClusterRoleBinding"}, crb.Name, field.ErrorList{field.Invalid(field.NewPath("roleRef"), crb.RoleRef.Name, "denied")})
+	})
+
+	recorder := record.NewFakeRecorder(10)
+	client.ClearActions()
+	r = Reconciler{Clientset: client, Recorder: recorder}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, map[string]int{"validated-ops-view": 2, "validated-ops-edit": 1, "validated-ops-broken": 1}, creates)
+	for _, action := range client.Actions() {
+		assert.NotEqual(t, "delete", action.GetVerb(), "the binding a rejected binding replaces should be kept")
+	}
+
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	names := []string{}
+	for _, crb := range crbs.Items {
+		names = append(names, crb.Name)
+	}
+	assert.ElementsMatch(t, []string{"validated-ops-edit", "validated-ops-view"}, names)
+
+	assert.Len(t, recorder.Events, 2)
+	assert.Contains(t, <-recorder.Events, "Warning ValidationFailed Not creating ClusterRoleBinding validated-ops-")
+
+	condition := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionValidationFailed)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Contains(t, condition.Message, "The API server rejected: ClusterRoleBinding validated-ops-broken (")
+		assert.Contains(t, condition.Message, "; ClusterRoleBinding validated-ops-edit (")
+	}
+	SetReadyCondition(&rbacDef, nil)
+	assert.Equal(t, "ValidationFailed", meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionReady).Reason)
+}
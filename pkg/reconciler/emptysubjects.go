@@ -0,0 +1,74 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+const emptySubjectsPrefix = "Subjects are empty: "
+
+// setEmptySubjectsCondition records the rbacBindings entries whose subjects
+// resolved to none when rbacDef was last parsed in the EmptySubjects
+// condition. These entries create no bindings and their existing bindings
+// are pruned until subjects resolve again. An event is recorded for each
+// entry that wasn't reported before.
+func (r *Reconciler) setEmptySubjectsCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition, entries []parsedEntry) {
+	previous := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionEmptySubjects)
+
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionEmptySubjects,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rbacDef.Generation,
+		Reason:             "SubjectsResolved",
+		Message:            "Every rbacBindings entry has subjects",
+	}
+
+	reported := map[string]bool{}
+	if previous != nil && previous.Status == metav1.ConditionTrue {
+		for _, description := range strings.Split(strings.TrimPrefix(previous.Message, emptySubjectsPrefix), "; ") {
+			reported[description] = true
+		}
+	}
+
+	empty := []string{}
+	for _, entry := range entries {
+		if !entry.empty {
+			continue
+		}
+		description := fmt.Sprintf("rbacBindings entry %v", entry.name)
+		empty = append(empty, description)
+		if reported[description] {
+			continue
+		}
+		logrus.Warnf("Subjects of %v of RBACDefinition %v are empty, not creating its bindings", description, rbacDef.Name)
+		r.event(v1.EventTypeWarning, "EmptySubjects", "Subjects of %v are empty, not creating its bindings", description)
+	}
+	if len(empty) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "EmptySubjects"
+		condition.Message = truncateMessage(emptySubjectsPrefix+strings.Join(empty, "; "), maxReadyMessageLength)
+	}
+
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, condition)
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestEmptySubjects(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ci"}})
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "empty"
+	rbacDef.UID = "empty-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "bots",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject:  rbacv1.Subject{Kind: rbacmanagerv1beta1.ServiceAccountSelectorKind},
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"bot": "true"}},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+
+	recorder := record.NewFakeRecorder(20)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	getCRB := func() error {
+		_, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "empty-bots-view", metav1.GetOptions{})
+		return err
+	}
+	emptySubjects := func() *metav1.Condition {
+		return meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionEmptySubjects)
+	}
+	events := func() string {
+		all := []string{}
+		for len(recorder.Events) > 0 {
+			all = append(all, <-recorder.Events)
+		}
+		return strings.Join(all, "\n")
+	}
+
+	// Nothing is selected, so no binding is created
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.True(t, apierrors.IsNotFound(getCRB()))
+	if condition := emptySubjects(); assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Subjects are empty: rbacBindings entry bots", condition.Message)
+	}
+	assert.Contains(t, events(), "Warning EmptySubjects Subjects of rbacBindings entry bots are empty")
+
+	// The event is only recorded once
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.NotContains(t, events(), "EmptySubjects")
+
+	// The binding is created once subjects appear
+	_, err := client.CoreV1().ServiceAccounts("ci").Create(context.TODO(), &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: "ci", Labels: map[string]string{"bot": "true"}},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.NoError(t, getCRB())
+	if condition := emptySubjects(); assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	}
+
+	// and deleted again once they are gone
+	assert.NoError(t, client.CoreV1().ServiceAccounts("ci").Delete(context.TODO(), "builder", metav1.DeleteOptions{}))
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.True(t, apierrors.IsNotFound(getCRB()))
+	if condition := emptySubjects(); assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseError describes a problem with a specific entry in an RBAC Definition
+type ParseError struct {
+	// Path locates the failing entry, e.g. rbacBindings[3].roleBindings[1]
+	Path string
+	// Binding is the name of the rbacBindings entry Path points into, if known
+	Binding string
+	// Reason describes what is wrong with the entry
+	Reason string
+}
+
+// Error formats the error as the path to the failing entry followed by the reason,
+// e.g. "rbacBindings[3] 'ci-deployers': roleBindings[1]: role or clusterRole required"
+func (e *ParseError) Error() string {
+	segments := strings.Split(e.Path, ".")
+	if e.Binding != "" {
+		segments[0] = fmt.Sprintf("%s '%s'", segments[0], e.Binding)
+	}
+	return strings.Join(append(segments, e.Reason), ": ")
+}
+
+// newParseError prefixes the path of err with path, converting it to a
+// ParseError if it isn't one already
+func newParseError(path string, binding string, err error) *ParseError {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		if binding == "" {
+			binding = parseErr.Binding
+		}
+		return &ParseError{
+			Path:    path + "." + parseErr.Path,
+			Binding: binding,
+			Reason:  parseErr.Reason,
+		}
+	}
+
+	return &ParseError{Path: path, Binding: binding, Reason: err.Error()}
+}
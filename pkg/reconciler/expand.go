@@ -0,0 +1,87 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// ExpandedBinding holds the bindings a single rbacBindings entry produces
+type ExpandedBinding struct {
+	RBACBinding         string
+	ClusterRoleBindings []rbacv1.ClusterRoleBinding
+	RoleBindings        []rbacv1.RoleBinding
+}
+
+// Expand determines the bindings an RBAC Definition refers to like Parse does,
+// but keeps the bindings of each rbacBindings entry apart
+func (p *Parser) Expand(rbacDef rbacmanagerv1beta1.RBACDefinition) ([]ExpandedBinding, error) {
+	rbacDef, err := p.resolveImports(rbacDef)
+	if err != nil {
+		return nil, err
+	}
+
+	err = Validate(&rbacDef)
+	if err != nil {
+		return nil, err
+	}
+
+	allowance, err := namespaceAllowanceFor(&rbacDef)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := listNamespaces(p.context(), p.Clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.loadServiceAccounts(&rbacDef)
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := []ExpandedBinding{}
+	for index, rbacBinding := range rbacDef.RBACBindings {
+		subjects, ok := p.bindingSubjects(&rbacBinding, &rbacDef.Defaults)
+		if !ok {
+			continue
+		}
+		rbacBinding.Subjects = subjects
+
+		entryParser := Parser{Clientset: p.Clientset, ctx: p.ctx, ownerRefs: p.ownerRefs, definitionName: rbacDef.Name, allowance: allowance}
+		err := entryParser.parseRBACBinding(rbacBinding, rdNamePrefix(&rbacDef, &rbacBinding), namespaces)
+		if err != nil {
+			return nil, newParseError(fmt.Sprintf("rbacBindings[%d]", index), rbacBinding.Name, err)
+		}
+		entryParser.sortParsed()
+
+		expanded = append(expanded, ExpandedBinding{
+			RBACBinding:         rbacBinding.Name,
+			ClusterRoleBindings: entryParser.parsedClusterRoleBindings,
+			RoleBindings:        entryParser.parsedRoleBindings,
+		})
+	}
+
+	if rbacDef.MergeBindings {
+		renameMergedBindings(expanded, rbacDef.Name)
+	}
+
+	return expanded, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// fieldClaim is a binding RBAC Manager owns whose fields are also owned by
+// other field managers
+type fieldClaim struct {
+	kind     string
+	existing metav1.Object
+	// legacy are earlier field managers of RBAC Manager, whose fields are
+	// taken over with a forced apply
+	legacy []string
+	// others are field managers of other tools, which are reported
+	others []string
+}
+
+// contestedFields appends existing to claims if other field managers own
+// fields of it that RBAC Manager writes
+func (r *Reconciler) contestedFields(claims []fieldClaim, kind string, existing metav1.Object) []fieldClaim {
+	claim := fieldClaim{kind: kind, existing: existing}
+	for _, entry := range existing.GetManagedFields() {
+		if entry.Manager == kube.FieldManager || !ownsManagedFields(entry) {
+			continue
+		}
+		if legacyFieldManager(entry.Manager) {
+			claim.legacy = appendManager(claim.legacy, entry.Manager)
+		} else {
+			claim.others = appendManager(claim.others, entry.Manager)
+		}
+	}
+	if len(claim.legacy) == 0 && len(claim.others) == 0 {
+		return claims
+	}
+
+	switch existing := existing.(type) {
+	case *rbacv1.ClusterRoleBinding:
+		claim.existing = existing.DeepCopy()
+	case *rbacv1.RoleBinding:
+		claim.existing = existing.DeepCopy()
+	}
+	return append(claims, claim)
+}
+
+// claimFields takes over the fields earlier versions of RBAC Manager own with
+// a forced apply, so only one field manager is left for them, and records an
+// event for fields that other tools own, which RBAC Manager leaves alone
+func (r *Reconciler) claimFields(claims []fieldClaim) {
+	r.forEach(len(claims), func(i int) {
+		claim := claims[i]
+		name := claim.existing.GetName()
+		if claim.existing.GetNamespace() != "" {
+			name = claim.existing.GetNamespace() + "/" + name
+		}
+
+		if len(claim.others) > 0 {
+			logrus.Warnf("Fields of %v %v that rbac-manager manages are also managed by %v", claim.kind, name, strings.Join(claim.others, ", "))
+			r.event(v1.EventTypeWarning, "FieldManagerConflict", "Fields of %v %v that rbac-manager manages are also managed by %v, they are left to them", claim.kind, name, strings.Join(claim.others, ", "))
+		}
+		if len(claim.legacy) == 0 {
+			return
+		}
+
+		resource, err := r.forceApply(claim.kind, claim.existing)
+		if resource != "" {
+			r.noteWrite(resource)
+		}
+		if apierrors.IsNotFound(err) {
+			logrus.Debugf("%v %v was deleted before its fields could be taken over", claim.kind, name)
+			return
+		} else if err != nil {
+			logrus.Errorf("Error taking over fields of %v %v: %v", claim.kind, name, err)
+			metrics.ErrorCounter.Inc()
+			return
+		}
+		logrus.Infof("Took over fields of %v %v from earlier field managers %v", claim.kind, name, strings.Join(claim.legacy, ", "))
+		r.event(v1.EventTypeNormal, "FieldManagersCleanedUp", "Took over fields of %v %v from earlier field managers %v", claim.kind, name, strings.Join(claim.legacy, ", "))
+	})
+}
+
+// forceApply applies the fields RBAC Manager writes to an existing binding
+// as they are, taking them over from any other field manager
+func (r *Reconciler) forceApply(kind string, existing metav1.Object) (string, error) {
+	metadata := map[string]interface{}{
+		"name":   existing.GetName(),
+		"labels": map[string]string{kube.LabelKey: kube.LabelValue},
+	}
+	apply := map[string]interface{}{
+		"apiVersion": rbacv1.SchemeGroupVersion.String(),
+		"kind":       kind,
+		"metadata":   metadata,
+	}
+
+	switch existing := existing.(type) {
+	case *rbacv1.ClusterRoleBinding:
+		apply["roleRef"] = existing.RoleRef
+		apply["subjects"] = existing.Subjects
+		patch, err := json.Marshal(apply)
+		if err != nil {
+			return "", err
+		}
+		_, err = kube.RBAC(r.Clientset).ClusterRoleBindings().Patch(r.context(), existing.Name, types.ApplyPatchType, patch, kube.ForceApplyOptions)
+		return "clusterrolebindings", err
+	case *rbacv1.RoleBinding:
+		metadata["namespace"] = existing.Namespace
+		apply["roleRef"] = existing.RoleRef
+		apply["subjects"] = existing.Subjects
+		patch, err := json.Marshal(apply)
+		if err != nil {
+			return "", err
+		}
+		_, err = kube.RBAC(r.Clientset).RoleBindings(existing.Namespace).Patch(r.context(), existing.Name, types.ApplyPatchType, patch, kube.ForceApplyOptions)
+		return "rolebindings", err
+	}
+	return "", fmt.Errorf("cannot apply %v", kind)
+}
+
+// ownsManagedFields reports whether a managedFields entry owns the role
+// reference, the subjects, or the label of a binding, which RBAC Manager writes
+func ownsManagedFields(entry metav1.ManagedFieldsEntry) bool {
+	if entry.FieldsV1 == nil {
+		return false
+	}
+	fields := map[string]interface{}{}
+	if json.Unmarshal(entry.FieldsV1.Raw, &fields) != nil {
+		return false
+	}
+
+	if _, ok := fields["f:roleRef"]; ok {
+		return true
+	}
+	if _, ok := fields["f:subjects"]; ok {
+		return true
+	}
+	metadata, _ := fields["f:metadata"].(map[string]interface{})
+	labels, _ := metadata["f:labels"].(map[string]interface{})
+	_, ok := labels["f:"+kube.LabelKey]
+	return ok
+}
+
+func legacyFieldManager(manager string) bool {
+	for _, legacy := range kube.LegacyFieldManagers {
+		if manager == legacy {
+			return true
+		}
+	}
+	return false
+}
+
+// appendManager adds manager to managers once, keeping them sorted
+func appendManager(managers []string, manager string) []string {
+	for _, existing := range managers {
+		if existing == manager {
+			return managers
+		}
+	}
+	managers = append(managers, manager)
+	sort.Strings(managers)
+	return managers
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+func TestReconcileClaimsFields(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "field-managers"
+	rbacDef.UID = "field-managers-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "devs",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "devs"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+		RoleBindings:        []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "dev"}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, crbs.Items, 1)
+	crb := crbs.Items[0]
+	rbs, err := client.RbacV1().RoleBindings("dev").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 1)
+	rb := rbs.Items[0]
+
+	managedFields := func(manager, fields string) metav1.ManagedFieldsEntry {
+		return metav1.ManagedFieldsEntry{
+			Manager:    manager,
+			Operation:  metav1.ManagedFieldsOperationUpdate,
+			FieldsType: "FieldsV1",
+			FieldsV1:   &metav1.FieldsV1{Raw: []byte(fields)},
+		}
+	}
+
+	// An earlier version of rbac-manager and kubectl both own fields of the
+	// Cluster Role Binding that rbac-manager writes
+	crb.ManagedFields = []metav1.ManagedFieldsEntry{
+		managedFields(kube.FieldManager, `{"f:roleRef":{},"f:subjects":{}}`),
+		managedFields("manager", `{"f:roleRef":{},"f:subjects":{}}`),
+		managedFields("kubectl-label", `{"f:metadata":{"f:labels":{"f:rbac-manager":{}}}}`),
+		managedFields("kubectl-annotate", `{"f:metadata":{"f:annotations":{"f:note":{}}}}`),
+	}
+	_, err = client.RbacV1().ClusterRoleBindings().Update(context.TODO(), &crb, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	// Only other tools own fields of the Role Binding
+	rb.ManagedFields = []metav1.ManagedFieldsEntry{
+		managedFields(kube.FieldManager, `{"f:roleRef":{},"f:subjects":{}}`),
+		managedFields("kubectl-edit", `{"f:subjects":{}}`),
+	}
+	_, err = client.RbacV1().RoleBindings("dev").Update(context.TODO(), &rb, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	// The fake clientset doesn't support server-side apply
+	applies := []k8stesting.PatchAction{}
+	client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		applies = append(applies, patch)
+		return true, nil, nil
+	})
+
+	recorder := record.NewFakeRecorder(10)
+	r = Reconciler{Clientset: client, Recorder: recorder}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	assert.Len(t, applies, 1, "only fields of earlier versions of rbac-manager should be taken over")
+	assert.Equal(t, "clusterrolebindings", applies[0].GetResource().Resource)
+	assert.Equal(t, crb.Name, applies[0].GetName())
+	applied := rbacv1.ClusterRoleBinding{}
+	assert.NoError(t, json.Unmarshal(applies[0].GetPatch(), &applied))
+	assert.Equal(t, crb.RoleRef, applied.RoleRef)
+	assert.Equal(t, crb.Subjects, applied.Subjects)
+	assert.Equal(t, kube.LabelValue, applied.Labels[kube.LabelKey])
+
+	events := []string{}
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	assert.ElementsMatch(t, []string{
+		"Normal FieldManagersCleanedUp Took over fields of ClusterRoleBinding " + crb.Name + " from earlier field managers manager",
+		"Warning FieldManagerConflict Fields of ClusterRoleBinding " + crb.Name + " that rbac-manager manages are also managed by kubectl-label, they are left to them",
+		"Warning FieldManagerConflict Fields of RoleBinding dev/" + rb.Name + " that rbac-manager manages are also managed by kubectl-edit, they are left to them",
+	}, events)
+}
+
+func TestOwnsManagedFields(t *testing.T) {
+	tests := []struct {
+		fields string
+		owns   bool
+	}{
+		{fields: `{"f:roleRef":{}}`, owns: true},
+		{fields: `{"f:subjects":{}}`, owns: true},
+		{fields: `{"f:metadata":{"f:labels":{"f:rbac-manager":{}}}}`, owns: true},
+		{fields: `{"f:metadata":{"f:labels":{"f:team":{}}}}`},
+		{fields: `{"f:metadata":{"f:annotations":{}}}`},
+		{fields: `not json`},
+	}
+
+	for _, tt := range tests {
+		entry := metav1.ManagedFieldsEntry{FieldsV1: &metav1.FieldsV1{Raw: []byte(tt.fields)}}
+		assert.Equal(t, tt.owns, ownsManagedFields(entry), tt.fields)
+	}
+	assert.False(t, ownsManagedFields(metav1.ManagedFieldsEntry{}))
+}
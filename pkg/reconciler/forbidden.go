@@ -0,0 +1,132 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// ForbiddenSubjects lists subjects that are removed from every RBAC
+// Definition before any bindings are generated, unless SetOptions replaced it
+var ForbiddenSubjects []SubjectPattern
+
+// SubjectPattern matches subjects of one kind by name. Namespace and Name
+// may contain shell patterns, Namespace is only used for Service Accounts.
+type SubjectPattern struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// ParseSubjectPatterns parses a comma separated list of subject patterns in
+// the form Kind:name, with ServiceAccount:namespace/name for Service Accounts
+func ParseSubjectPatterns(value string) ([]SubjectPattern, error) {
+	patterns := []SubjectPattern{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("subject pattern %s must be in the form Kind:name", entry)
+		}
+
+		pattern := SubjectPattern{Kind: parts[0], Name: parts[1]}
+		switch pattern.Kind {
+		case rbacv1.UserKind, rbacv1.GroupKind:
+		case rbacv1.ServiceAccountKind:
+			pattern.Namespace = "*"
+			if nameParts := strings.SplitN(pattern.Name, "/", 2); len(nameParts) == 2 {
+				pattern.Namespace, pattern.Name = nameParts[0], nameParts[1]
+			}
+		default:
+			return nil, fmt.Errorf("subject pattern %s must have kind User, Group, or ServiceAccount", entry)
+		}
+
+		// Reject malformed patterns now instead of never matching them later
+		for _, p := range []string{pattern.Namespace, pattern.Name} {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("subject pattern %s is invalid: %v", entry, err)
+			}
+		}
+
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// String formats the pattern the way ParseSubjectPatterns accepts it
+func (sp SubjectPattern) String() string {
+	if sp.Kind == rbacv1.ServiceAccountKind {
+		return fmt.Sprintf("%s:%s/%s", sp.Kind, sp.Namespace, sp.Name)
+	}
+	return fmt.Sprintf("%s:%s", sp.Kind, sp.Name)
+}
+
+// Matches reports whether subject matches the pattern
+func (sp SubjectPattern) Matches(subject *rbacv1.Subject) bool {
+	if subject.Kind != sp.Kind {
+		return false
+	}
+
+	if sp.Kind == rbacv1.ServiceAccountKind {
+		if matched, _ := path.Match(sp.Namespace, subject.Namespace); !matched {
+			return false
+		}
+	}
+
+	matched, _ := path.Match(sp.Name, subject.Name)
+	return matched
+}
+
+func isForbiddenSubject(subject *rbacv1.Subject) bool {
+	for _, pattern := range CurrentOptions().ForbiddenSubjects {
+		if pattern.Matches(subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// strippedSubject is a forbidden subject the parser removed from an entry
+type strippedSubject struct {
+	rbacBinding string
+	subject     rbacv1.Subject
+}
+
+// reportStrippedSubjects records every forbidden subject that was removed
+// from the RBAC Definition being reconciled
+func (r *Reconciler) reportStrippedSubjects(stripped []strippedSubject) {
+	for _, s := range stripped {
+		name := s.subject.Name
+		if s.subject.Namespace != "" {
+			name = s.subject.Namespace + "/" + s.subject.Name
+		}
+
+		logrus.Warnf("Removed forbidden %v %v from rbacBindings entry %v of RBACDefinition %v", s.subject.Kind, name, s.rbacBinding, r.rbacDef.Name)
+		metrics.ForbiddenSubjectsStrippedCounter.WithLabelValues(s.subject.Kind, r.rbacDef.Name).Inc()
+		r.event(v1.EventTypeWarning, "ForbiddenSubject", "Removed forbidden %v %v from rbacBindings entry %v", s.subject.Kind, name, s.rbacBinding)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestParseSubjectPatterns(t *testing.T) {
+	patterns, err := ParseSubjectPatterns("User:mallory@example.com, Group:contractors-*,ServiceAccount:ci/*,ServiceAccount:deployer,")
+	assert.NoError(t, err)
+	assert.Equal(t, []SubjectPattern{
+		{Kind: "User", Name: "mallory@example.com"},
+		{Kind: "Group", Name: "contractors-*"},
+		{Kind: "ServiceAccount", Namespace: "ci", Name: "*"},
+		{Kind: "ServiceAccount", Namespace: "*", Name: "deployer"},
+	}, patterns)
+
+	tests := []struct {
+		subject rbacv1.Subject
+		matches bool
+	}{
+		{rbacv1.Subject{Kind: "User", Name: "mallory@example.com"}, true},
+		{rbacv1.Subject{Kind: "Group", Name: "mallory@example.com"}, false},
+		{rbacv1.Subject{Kind: "Group", Name: "contractors-acme"}, true},
+		{rbacv1.Subject{Kind: "ServiceAccount", Namespace: "ci", Name: "builder"}, true},
+		{rbacv1.Subject{Kind: "ServiceAccount", Namespace: "web", Name: "builder"}, false},
+		{rbacv1.Subject{Kind: "ServiceAccount", Namespace: "web", Name: "deployer"}, true},
+	}
+	for _, tc := range tests {
+		matches := false
+		for _, pattern := range patterns {
+			matches = matches || pattern.Matches(&tc.subject)
+		}
+		assert.Equal(t, tc.matches, matches, "%v %v/%v", tc.subject.Kind, tc.subject.Namespace, tc.subject.Name)
+	}
+
+	for _, invalid := range []string{"mallory", "Robot:r2d2", "User:", "Group:[contractors"} {
+		_, err := ParseSubjectPatterns(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestReconcileStripsForbiddenSubjects(t *testing.T) {
+	defer func() { ForbiddenSubjects = nil }()
+
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "offboarding"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}, {
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "mallory"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}},
+	}, {
+		Name: "mallory",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "mallory"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	expectClusterRoleBindings(t, client, []rbacv1.ClusterRoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{Name: "offboarding-mallory-view"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "mallory"}},
+	}})
+
+	ForbiddenSubjects, err = ParseSubjectPatterns("User:mallory")
+	assert.NoError(t, err)
+
+	stripped := metrics.ForbiddenSubjectsStrippedCounter.WithLabelValues("User", "offboarding")
+	count := testutil.ToFloat64(stripped)
+	recorder := record.NewFakeRecorder(10)
+	r = Reconciler{Clientset: client, Recorder: recorder}
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+
+	// Existing bindings that granted the forbidden subject are pruned
+	expectClusterRoleBindings(t, client, []rbacv1.ClusterRoleBinding{})
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{Name: "offboarding-devs-edit", Namespace: "web"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "joe"}},
+	}})
+	expectServiceAccounts(t, client, []corev1.ServiceAccount{})
+
+	assert.Equal(t, count+2, testutil.ToFloat64(stripped))
+	assert.Equal(t, "Warning ForbiddenSubject Removed forbidden User mallory from rbacBindings entry devs", <-recorder.Events)
+	assert.Equal(t, "Warning ForbiddenSubject Removed forbidden User mallory from rbacBindings entry mallory", <-recorder.Events)
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// seenGeneration is the newest generation of an RBAC Definition a reconcile
+// has started on
+type seenGeneration struct {
+	uid        types.UID
+	generation int64
+}
+
+// latestGenerations holds the newest generation of every RBAC Definition by
+// name. Watchers read definitions from the API server while the controller
+// reads them from its cache, so one may still hand out a revision the other
+// has already moved past.
+var latestGenerations = struct {
+	sync.Mutex
+	byName map[string]seenGeneration
+}{byName: map[string]seenGeneration{}}
+
+// SupersededGeneration reports whether a reconcile has already started on a
+// newer generation of rbacDef. Reconciling the older one would only create
+// resources the newer one deletes again.
+func SupersededGeneration(rbacDef *rbacmanagerv1beta1.RBACDefinition) bool {
+	latestGenerations.Lock()
+	defer latestGenerations.Unlock()
+
+	return superseded(rbacDef)
+}
+
+// startGeneration records that a reconcile of rbacDef is starting and
+// reports whether it has been superseded
+func startGeneration(rbacDef *rbacmanagerv1beta1.RBACDefinition) bool {
+	latestGenerations.Lock()
+	defer latestGenerations.Unlock()
+
+	// Definitions that were never stored, such as the empty one that
+	// releases remote clusters, have no generation
+	if rbacDef.Generation == 0 {
+		return false
+	}
+	if superseded(rbacDef) {
+		return true
+	}
+	latestGenerations.byName[rbacDef.Name] = seenGeneration{uid: rbacDef.UID, generation: rbacDef.Generation}
+	return false
+}
+
+// superseded must be called with latestGenerations locked
+func superseded(rbacDef *rbacmanagerv1beta1.RBACDefinition) bool {
+	seen, ok := latestGenerations.byName[rbacDef.Name]
+	return ok && seen.uid == rbacDef.UID && seen.generation > rbacDef.Generation
+}
+
+// ForgetGeneration drops the newest generation of a deleted RBAC Definition
+func ForgetGeneration(name string) {
+	latestGenerations.Lock()
+	defer latestGenerations.Unlock()
+	delete(latestGenerations.byName, name)
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestReconcileSkipsSupersededGeneration(t *testing.T) {
+	defer ForgetGeneration("stale")
+	client := fake.NewSimpleClientset()
+	revision := func(generation int64, role string) *rbacmanagerv1beta1.RBACDefinition {
+		rbacDef := &rbacmanagerv1beta1.RBACDefinition{}
+		rbacDef.Name = "stale"
+		rbacDef.UID = "stale-uid"
+		rbacDef.Generation = generation
+		rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+			Name:                "devs",
+			Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "devs"}}},
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: role}},
+		}}
+		return rbacDef
+	}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(revision(3, "admin")))
+
+	// A cache that hasn't caught up hands out an older revision
+	stale := revision(2, "edit")
+	assert.True(t, SupersededGeneration(stale))
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(stale))
+	assert.Empty(t, client.Actions(), "an older generation should not be reconciled")
+
+	// A definition deleted and created again starts over
+	recreated := revision(1, "view")
+	recreated.UID = "recreated-uid"
+	assert.False(t, SupersededGeneration(recreated))
+	assert.NoError(t, r.Reconcile(recreated))
+	assert.NotEmpty(t, client.Actions())
+}
@@ -0,0 +1,285 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// GrantApprovalsVerified is set when the grant webhook checks who approves
+// RBAC Temporary Grants. Without it anyone who may create a grant could also
+// approve it, so no grant is granted.
+var GrantApprovalsVerified bool
+
+// unverifiedGrantMessage is the status message of approved grants that are
+// not granted because GrantApprovalsVerified is not set
+const unverifiedGrantMessage = "Approvals are not verified, RBAC Manager needs to run with --enable-grant-webhook to grant access"
+
+// errGrantTemplate is returned when the rbacBindings entry a grant refers to can't be used
+type errGrantTemplate struct {
+	reason string
+}
+
+func (e *errGrantTemplate) Error() string {
+	return e.reason
+}
+
+// ReconcileGrant creates the Role Bindings of an approved RBAC Temporary Grant,
+// revokes them once the grant expires, and updates the status of the grant.
+// It returns how long to wait before the grant has to be reconciled again to
+// revoke it, or zero if nothing is pending.
+func (r *Reconciler) ReconcileGrant(grant *rbacmanagerv1beta1.RBACTemporaryGrant, now time.Time) (time.Duration, error) {
+	status := &grant.Status
+
+	if status.Phase == rbacmanagerv1beta1.GrantPhaseExpired {
+		return 0, nil
+	}
+
+	if !grant.Spec.Approved {
+		if status.GrantedAt != nil {
+			return 0, r.revokeGrant(grant, "approval was withdrawn")
+		}
+		status.Phase = rbacmanagerv1beta1.GrantPhasePending
+		status.Message = "Waiting for approval"
+		return 0, nil
+	}
+
+	if !GrantApprovalsVerified {
+		if status.GrantedAt != nil {
+			return 0, r.revokeGrant(grant, "its approval can no longer be verified")
+		}
+		if status.Message != unverifiedGrantMessage {
+			r.grantEvent(grant, v1.EventTypeWarning, "ApprovalUnverified", "Not granting access to %v: %v", grant.Spec.Requester, unverifiedGrantMessage)
+		}
+		status.Phase = rbacmanagerv1beta1.GrantPhasePending
+		status.Message = unverifiedGrantMessage
+		return 0, nil
+	}
+
+	if status.ExpiresAt != nil && !now.Before(status.ExpiresAt.Time) {
+		return 0, r.revokeGrant(grant, fmt.Sprintf("it expired (expiresAt %v passed)", status.ExpiresAt.Format(time.RFC3339)))
+	}
+
+	requested, err := r.grantRoleBindings(grant)
+	if err != nil {
+		if _, ok := err.(*errGrantTemplate); ok {
+			status.Phase = rbacmanagerv1beta1.GrantPhaseFailed
+			status.Message = err.Error()
+			r.grantEvent(grant, v1.EventTypeWarning, "GrantFailed", "Cannot grant access: %v", err)
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	if status.GrantedAt == nil {
+		status.GrantedAt = &metav1.Time{Time: now}
+		status.ExpiresAt = &metav1.Time{Time: now.Add(grant.Spec.Duration.Duration)}
+		auditGrant("grant", grant).Infof("Granted temporary access to %v until %v", grant.Spec.Requester, status.ExpiresAt.Format(time.RFC3339))
+		r.grantEvent(grant, v1.EventTypeNormal, "Granted", "Granted %v temporary access until %v", grant.Spec.Requester, status.ExpiresAt.Format(time.RFC3339))
+	}
+
+	for i := range requested {
+		rb := &requested[i]
+		rb.Annotations = map[string]string{kube.ExpiresAtAnnotation: status.ExpiresAt.Format(time.RFC3339)}
+		_, err := kube.RBAC(r.Clientset).RoleBindings(rb.Namespace).Create(r.context(), rb, kube.CreateOptions)
+		if apierrors.IsAlreadyExists(err) {
+			conflict, err := r.grantRoleBindingConflict(grant, rb)
+			if err != nil {
+				metrics.ErrorCounter.Inc()
+				return 0, err
+			}
+			if conflict != "" {
+				// Role Bindings created so far are still revoked once the
+				// grant expires
+				status.Phase = rbacmanagerv1beta1.GrantPhaseFailed
+				status.Message = conflict
+				r.grantEvent(grant, v1.EventTypeWarning, "GrantFailed", "Cannot grant access: %v", conflict)
+				return status.ExpiresAt.Sub(now), nil
+			}
+			continue
+		} else if err != nil {
+			metrics.ErrorCounter.Inc()
+			return 0, err
+		}
+		logrus.Infof("Creating Role Binding %v for temporary grant %v/%v", rb.Name, grant.Namespace, grant.Name)
+		metrics.ChangeCounter.WithLabelValues("rolebindings", "create").Inc()
+	}
+
+	status.Phase = rbacmanagerv1beta1.GrantPhaseActive
+	status.Message = fmt.Sprintf("Access granted until %v", status.ExpiresAt.Format(time.RFC3339))
+	return status.ExpiresAt.Sub(now), nil
+}
+
+// grantRoleBindingConflict explains why the existing Role Binding with the
+// name of a requested one can't be used for the grant, if it was created by
+// someone else or has been changed to bind something else
+func (r *Reconciler) grantRoleBindingConflict(grant *rbacmanagerv1beta1.RBACTemporaryGrant, requested *rbacv1.RoleBinding) (string, error) {
+	existing, err := kube.RBAC(r.Clientset).RoleBindings(requested.Namespace).Get(r.context(), requested.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	if !metav1.IsControlledBy(existing, grant) {
+		return fmt.Sprintf("Role Binding %v already exists and is not controlled by the grant", requested.Name), nil
+	}
+	if !roleRefMatches(&existing.RoleRef, &requested.RoleRef) || !subjectsMatch(&existing.Subjects, &requested.Subjects) {
+		return fmt.Sprintf("Role Binding %v no longer binds %v %v to %v", requested.Name, requested.RoleRef.Kind, requested.RoleRef.Name, grant.Spec.Requester), nil
+	}
+	return "", nil
+}
+
+// revokeGrant deletes the Role Bindings of a grant and marks it expired
+func (r *Reconciler) revokeGrant(grant *rbacmanagerv1beta1.RBACTemporaryGrant, reason string) error {
+	selector := labels.Set{kube.LabelKey: kube.LabelValue, kube.GrantLabelKey: grant.Name}.String()
+	existing, err := kube.RBAC(r.Clientset).RoleBindings(grant.Namespace).List(r.context(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+
+	for _, rb := range existing.Items {
+		if !metav1.IsControlledBy(&rb, grant) {
+			continue
+		}
+		logrus.Infof("Deleting Role Binding %v of temporary grant %v/%v: %v", rb.Name, grant.Namespace, grant.Name, reason)
+		err := kube.RBAC(r.Clientset).RoleBindings(rb.Namespace).Delete(r.context(), rb.Name, deleteOptions(&rb.ObjectMeta))
+		if err != nil && !apierrors.IsNotFound(err) {
+			metrics.ErrorCounter.Inc()
+			return err
+		}
+		metrics.ChangeCounter.WithLabelValues("rolebindings", "delete").Inc()
+	}
+
+	grant.Status.Phase = rbacmanagerv1beta1.GrantPhaseExpired
+	grant.Status.Message = fmt.Sprintf("Access was revoked because %v", reason)
+	auditGrant("revoke", grant).Infof("Revoked temporary access of %v because %v", grant.Spec.Requester, reason)
+	r.grantEvent(grant, v1.EventTypeNormal, "Revoked", "Revoked temporary access of %v because %v", grant.Spec.Requester, reason)
+	return nil
+}
+
+// grantRoleBindings returns a Role Binding in the namespace of the grant for
+// every role the rbacBindings entry it refers to binds
+func (r *Reconciler) grantRoleBindings(grant *rbacmanagerv1beta1.RBACTemporaryGrant) ([]rbacv1.RoleBinding, error) {
+	p := Parser{Clientset: r.Clientset, GetDefinition: r.GetDefinition, ctx: r.ctx}
+	rbacDef, err := p.getDefinition(grant.Spec.RBACDefinition)
+	if apierrors.IsNotFound(err) {
+		return nil, &errGrantTemplate{reason: fmt.Sprintf("RBACDefinition %v does not exist", grant.Spec.RBACDefinition)}
+	} else if err != nil {
+		return nil, err
+	}
+
+	rbacDef, err = p.resolveImports(rbacDef)
+	if err != nil {
+		return nil, &errGrantTemplate{reason: err.Error()}
+	}
+
+	var template *rbacmanagerv1beta1.RBACBinding
+	for i := range rbacDef.RBACBindings {
+		if rbacDef.RBACBindings[i].Name == grant.Spec.RBACBinding {
+			template = &rbacDef.RBACBindings[i]
+			break
+		}
+	}
+	if template == nil {
+		return nil, &errGrantTemplate{reason: fmt.Sprintf("RBACDefinition %v has no rbacBindings entry %v", grant.Spec.RBACDefinition, grant.Spec.RBACBinding)}
+	}
+
+	roleRefs := []rbacv1.RoleRef{}
+	addRoleRef := func(roleRef rbacv1.RoleRef) {
+		for _, existing := range roleRefs {
+			if existing == roleRef {
+				return
+			}
+		}
+		roleRefs = append(roleRefs, roleRef)
+	}
+	for _, crb := range template.ClusterRoleBindings {
+		addRoleRef(rbacv1.RoleRef{Kind: "ClusterRole", Name: crb.ClusterRole})
+	}
+	for _, roleBinding := range template.RoleBindings {
+		for _, rb := range roleBindingEntries(roleBinding) {
+			if rb.ClusterRole != "" {
+				addRoleRef(rbacv1.RoleRef{Kind: "ClusterRole", Name: rb.ClusterRole})
+			} else {
+				addRoleRef(rbacv1.RoleRef{Kind: "Role", Name: rb.Role})
+			}
+		}
+	}
+	if len(roleRefs) == 0 {
+		return nil, &errGrantTemplate{reason: fmt.Sprintf("rbacBindings entry %v of RBACDefinition %v binds no roles", grant.Spec.RBACBinding, grant.Spec.RBACDefinition)}
+	}
+
+	grantLabels := labels.Merge(kube.Labels, labels.Set{kube.GrantLabelKey: grant.Name})
+	ownerRefs := []metav1.OwnerReference{
+		*metav1.NewControllerRef(grant, schema.GroupVersionKind{
+			Group:   rbacmanagerv1beta1.SchemeGroupVersion.Group,
+			Version: rbacmanagerv1beta1.SchemeGroupVersion.Version,
+			Kind:    "RBACTemporaryGrant",
+		}),
+	}
+
+	roleBindings := []rbacv1.RoleBinding{}
+	for _, roleRef := range roleRefs {
+		roleBindings = append(roleBindings, rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            fmt.Sprintf("%v-%v-%v", grant.Name, strings.ToLower(roleRef.Kind), roleRef.Name),
+				Namespace:       grant.Namespace,
+				Labels:          grantLabels,
+				OwnerReferences: ownerRefs,
+			},
+			RoleRef: roleRef,
+			Subjects: []rbacv1.Subject{{
+				Kind:     rbacv1.UserKind,
+				APIGroup: rbacv1.GroupName,
+				Name:     grant.Spec.Requester,
+			}},
+		})
+	}
+
+	return roleBindings, nil
+}
+
+// grantEvent records an event on a grant if the Reconciler has a Recorder
+func (r *Reconciler) grantEvent(grant *rbacmanagerv1beta1.RBACTemporaryGrant, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(grant, eventType, reason, messageFmt, args...)
+}
+
+// auditGrant returns a logger for audit log entries about a grant
+func auditGrant(action string, grant *rbacmanagerv1beta1.RBACTemporaryGrant) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"audit":          true,
+		"action":         action,
+		"grant":          grant.Namespace + "/" + grant.Name,
+		"requester":      grant.Spec.Requester,
+		"rbacDefinition": grant.Spec.RBACDefinition,
+		"rbacBinding":    grant.Spec.RBACBinding,
+	})
+}
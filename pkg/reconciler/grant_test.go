@@ -0,0 +1,300 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+func TestReconcileGrant(t *testing.T) {
+	GrantApprovalsVerified = true
+	defer func() { GrantApprovalsVerified = false }()
+
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "oncall"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "debuggers",
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "checkout",
+			ClusterRole: "edit",
+		}, {
+			Namespace:   "payments",
+			ClusterRole: "edit",
+		}},
+	}}
+	getDefinition := func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+		if name != rbacDef.Name {
+			return rbacDef, apierrors.NewNotFound(schema.GroupResource{Resource: "rbacdefinitions"}, name)
+		}
+		return rbacDef, nil
+	}
+	r := Reconciler{Clientset: client, GetDefinition: getDefinition}
+
+	grant := &rbacmanagerv1beta1.RBACTemporaryGrant{
+		ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "checkout", UID: "grant-uid"},
+		Spec: rbacmanagerv1beta1.RBACTemporaryGrantSpec{
+			RBACDefinition: "oncall",
+			RBACBinding:    "debuggers",
+			Requester:      "jane",
+			Duration:       metav1.Duration{Duration: time.Hour},
+		},
+	}
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Unapproved grants wait without creating anything
+	requeueAfter, err := r.ReconcileGrant(grant, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), requeueAfter)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhasePending, grant.Status.Phase)
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{})
+
+	// Approved grants bind each role once and requeue for expiry
+	grant.Spec.Approved = true
+	requeueAfter, err = r.ReconcileGrant(grant, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, requeueAfter)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhaseActive, grant.Status.Phase)
+	assert.Equal(t, now.Add(time.Hour), grant.Status.ExpiresAt.Time)
+
+	rbs, err := client.RbacV1().RoleBindings("checkout").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, rbs.Items, 2) {
+		names := []string{rbs.Items[0].Name, rbs.Items[1].Name}
+		assert.ElementsMatch(t, []string{"debug-clusterrole-view", "debug-clusterrole-edit"}, names)
+		for _, rb := range rbs.Items {
+			assert.Equal(t, "grant-uid", string(rb.OwnerReferences[0].UID))
+			assert.Equal(t, "debug", rb.Labels[kube.GrantLabelKey])
+			assert.Equal(t, "2022-03-01T13:00:00Z", rb.Annotations[kube.ExpiresAtAnnotation])
+			assert.Equal(t, []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "jane"}}, rb.Subjects)
+		}
+	}
+
+	// Reconciling again keeps the original expiry
+	requeueAfter, err = r.ReconcileGrant(grant, now.Add(45*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, requeueAfter)
+
+	// Expired grants are revoked for good
+	_, err = r.ReconcileGrant(grant, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhaseExpired, grant.Status.Phase)
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{})
+
+	_, err = r.ReconcileGrant(grant, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{})
+}
+
+func TestReconcileGrantWithdrawn(t *testing.T) {
+	GrantApprovalsVerified = true
+	defer func() { GrantApprovalsVerified = false }()
+
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "oncall"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "debuggers",
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+	getDefinition := func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+		return rbacDef, nil
+	}
+	r := Reconciler{Clientset: client, GetDefinition: getDefinition}
+
+	grant := &rbacmanagerv1beta1.RBACTemporaryGrant{
+		ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "checkout", UID: "grant-uid"},
+		Spec: rbacmanagerv1beta1.RBACTemporaryGrantSpec{
+			RBACDefinition: "oncall",
+			RBACBinding:    "debuggers",
+			Requester:      "jane",
+			Duration:       metav1.Duration{Duration: time.Hour},
+			Approved:       true,
+		},
+	}
+	now := time.Now()
+
+	_, err := r.ReconcileGrant(grant, now)
+	assert.NoError(t, err)
+	rbs, err := client.RbacV1().RoleBindings("checkout").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 1)
+
+	grant.Spec.Approved = false
+	_, err = r.ReconcileGrant(grant, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhaseExpired, grant.Status.Phase)
+	assert.Equal(t, "Access was revoked because approval was withdrawn", grant.Status.Message)
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{})
+
+	// Grants for missing rbacBindings entries fail without an error
+	failing := grant.DeepCopy()
+	failing.Status = rbacmanagerv1beta1.RBACTemporaryGrantStatus{}
+	failing.Spec.Approved = true
+	failing.Spec.RBACBinding = "missing"
+	_, err = r.ReconcileGrant(failing, now)
+	assert.NoError(t, err)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhaseFailed, failing.Status.Phase)
+	assert.Equal(t, "RBACDefinition oncall has no rbacBindings entry missing", failing.Status.Message)
+}
+
+func TestReconcileGrantConflict(t *testing.T) {
+	GrantApprovalsVerified = true
+	defer func() { GrantApprovalsVerified = false }()
+
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "oncall"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "debuggers",
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+		RoleBindings:        []rbacmanagerv1beta1.RoleBinding{{Namespace: "checkout", Role: "view"}},
+	}}
+	getDefinition := func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+		return rbacDef, nil
+	}
+	r := Reconciler{Clientset: client, GetDefinition: getDefinition}
+
+	grant := &rbacmanagerv1beta1.RBACTemporaryGrant{
+		ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "checkout", UID: "grant-uid"},
+		Spec: rbacmanagerv1beta1.RBACTemporaryGrantSpec{
+			RBACDefinition: "oncall",
+			RBACBinding:    "debuggers",
+			Requester:      "jane",
+			Duration:       metav1.Duration{Duration: time.Hour},
+			Approved:       true,
+		},
+	}
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// A Role and a ClusterRole of the same name get separate Role Bindings
+	_, err := r.ReconcileGrant(grant, now)
+	assert.NoError(t, err)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhaseActive, grant.Status.Phase)
+	rbs, err := client.RbacV1().RoleBindings("checkout").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, rbs.Items, 2) {
+		names := []string{rbs.Items[0].Name, rbs.Items[1].Name}
+		assert.ElementsMatch(t, []string{"debug-clusterrole-view", "debug-role-view"}, names)
+	}
+
+	// Role Bindings of the grant that were changed aren't trusted
+	rb, err := client.RbacV1().RoleBindings("checkout").Get(context.TODO(), "debug-role-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+	rb.Subjects[0].Name = "mallory"
+	_, err = client.RbacV1().RoleBindings("checkout").Update(context.TODO(), rb, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	requeueAfter, err := r.ReconcileGrant(grant, now.Add(15*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhaseFailed, grant.Status.Phase)
+	assert.Equal(t, "Role Binding debug-role-view no longer binds Role view to jane", grant.Status.Message)
+	// so that the Role Bindings of the grant are still revoked when it expires
+	assert.Equal(t, 45*time.Minute, requeueAfter)
+
+	// Neither are Role Bindings of the same name created by someone else
+	assert.NoError(t, client.RbacV1().RoleBindings("checkout").Delete(context.TODO(), "debug-role-view", metav1.DeleteOptions{}))
+	rb.ResourceVersion = ""
+	rb.OwnerReferences = nil
+	_, err = client.RbacV1().RoleBindings("checkout").Create(context.TODO(), rb, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	_, err = r.ReconcileGrant(grant, now.Add(15*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhaseFailed, grant.Status.Phase)
+	assert.Equal(t, "Role Binding debug-role-view already exists and is not controlled by the grant", grant.Status.Message)
+
+	// Only the Role Bindings of the grant are revoked
+	_, err = r.ReconcileGrant(grant, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhaseExpired, grant.Status.Phase)
+	rbs, err = client.RbacV1().RoleBindings("checkout").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, rbs.Items, 1) {
+		assert.Equal(t, "mallory", rbs.Items[0].Subjects[0].Name)
+	}
+}
+
+func TestReconcileGrantUnverified(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "oncall"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "debuggers",
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "admin"}},
+	}}
+	getDefinition := func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+		return rbacDef, nil
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, GetDefinition: getDefinition, Recorder: recorder}
+
+	// Without the webhook nothing stops the requester from approving their
+	// own grant, so it isn't granted
+	grant := &rbacmanagerv1beta1.RBACTemporaryGrant{
+		ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "checkout", UID: "grant-uid"},
+		Spec: rbacmanagerv1beta1.RBACTemporaryGrantSpec{
+			RBACDefinition: "oncall",
+			RBACBinding:    "debuggers",
+			Requester:      "mallory",
+			Duration:       metav1.Duration{Duration: time.Hour},
+			Approved:       true,
+		},
+	}
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	requeueAfter, err := r.ReconcileGrant(grant, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), requeueAfter)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhasePending, grant.Status.Phase)
+	assert.Equal(t, unverifiedGrantMessage, grant.Status.Message)
+	assert.Nil(t, grant.Status.GrantedAt)
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{})
+	assert.Equal(t, "Warning ApprovalUnverified Not granting access to mallory: "+unverifiedGrantMessage, <-recorder.Events)
+
+	// The warning is only recorded once
+	_, err = r.ReconcileGrant(grant, now)
+	assert.NoError(t, err)
+	assert.Empty(t, recorder.Events)
+
+	// Grants made while approvals were verified are revoked
+	GrantApprovalsVerified = true
+	grant.Status = rbacmanagerv1beta1.RBACTemporaryGrantStatus{}
+	_, err = r.ReconcileGrant(grant, now)
+	GrantApprovalsVerified = false
+	assert.NoError(t, err)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhaseActive, grant.Status.Phase)
+
+	_, err = r.ReconcileGrant(grant, now)
+	assert.NoError(t, err)
+	assert.Equal(t, rbacmanagerv1beta1.GrantPhaseExpired, grant.Status.Phase)
+	assert.Equal(t, "Access was revoked because its approval can no longer be verified", grant.Status.Message)
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{})
+}
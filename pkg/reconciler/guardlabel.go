@@ -0,0 +1,111 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// missingGuardLabel is a roleBindings entry whose required namespace label
+// is missing on some of the namespaces it applies to, which are skipped
+// until they carry it
+type missingGuardLabel struct {
+	rbacBinding string
+	roleRef     string
+	label       string
+	namespaces  []string
+}
+
+func newMissingGuardLabel(rbacBinding string, roleRef *rbacv1.RoleRef, requirement *rbacmanagerv1beta1.NamespaceLabelRequirement, namespaces []string) missingGuardLabel {
+	return missingGuardLabel{rbacBinding: rbacBinding, roleRef: roleRef.Kind + " " + roleRef.Name, label: guardLabel(requirement), namespaces: namespaces}
+}
+
+// guardLabel describes the label requirement asks for, as key or key=value
+func guardLabel(requirement *rbacmanagerv1beta1.NamespaceLabelRequirement) string {
+	if requirement.Value == "" {
+		return requirement.Key
+	}
+	return requirement.Key + "=" + requirement.Value
+}
+
+func (m missingGuardLabel) String() string {
+	return fmt.Sprintf("rbacBindings entry %v: %v requires label %v in %v", m.rbacBinding, m.roleRef, m.label, strings.Join(m.namespaces, ", "))
+}
+
+// hasGuardLabel reports whether namespace carries the label requirement asks for
+func hasGuardLabel(requirement *rbacmanagerv1beta1.NamespaceLabelRequirement, namespace *v1.Namespace) bool {
+	value, ok := namespace.Labels[requirement.Key]
+	return ok && (requirement.Value == "" || value == requirement.Value)
+}
+
+// lacksGuardLabel reports whether rb requires a label namespace doesn't carry
+func lacksGuardLabel(rb *rbacmanagerv1beta1.RoleBinding, namespace *v1.Namespace) bool {
+	return rb.RequireNamespaceLabel != nil && !hasGuardLabel(rb.RequireNamespaceLabel, namespace)
+}
+
+// setGuardLabelMissingCondition records the roleBindings entries that left
+// out namespaces without their required label when rbacDef was last parsed
+// in the GuardLabelMissing condition, and records an event for each entry
+// that wasn't reported before. It returns whether the condition changed.
+func (r *Reconciler) setGuardLabelMissingCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition, missing []missingGuardLabel) bool {
+	previous := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionGuardLabelMissing)
+
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionGuardLabelMissing,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rbacDef.Generation,
+		Reason:             "GuardLabelsPresent",
+		Message:            "Every namespace carries the labels its Role Bindings require",
+	}
+
+	entries := []string{}
+	for _, m := range missing {
+		entries = append(entries, m.String())
+		if previous != nil && previous.Status == metav1.ConditionTrue && strings.Contains(previous.Message, m.String()) {
+			continue
+		}
+		logrus.Infof("%v of RBACDefinition %v, not creating Role Bindings there", m, rbacDef.Name)
+		r.event(v1.EventTypeNormal, "GuardLabelMissing", "%v, not creating Role Bindings there", m)
+	}
+	if len(entries) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "GuardLabelMissing"
+		condition.Message = truncateMessage("Namespaces skipped for missing labels: "+strings.Join(entries, "; "), maxReadyMessageLength)
+	}
+
+	changed := previous == nil || previous.Status != condition.Status || previous.Message != condition.Message || previous.ObservedGeneration != condition.ObservedGeneration
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, condition)
+	return changed
+}
+
+// UpdateGuardLabelMissing updates the GuardLabelMissing condition of an RBAC
+// Definition after ReconcileNamespaceChange, so that it follows guard labels
+// being added to and removed from namespaces. It returns whether the status
+// of rbacDef changed and has to be written.
+func (r *Reconciler) UpdateGuardLabelMissing(rbacDef *rbacmanagerv1beta1.RBACDefinition) bool {
+	if r.missingGuardLabels == nil || r.Cluster != "" {
+		return false
+	}
+	return r.setGuardLabelMissingCondition(rbacDef, *r.missingGuardLabels)
+}
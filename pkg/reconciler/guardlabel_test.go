@@ -0,0 +1,94 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestGuardLabelMissing(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "payments", "rbac.example.com/allow-elevated": "true"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "api", Labels: map[string]string{"team": "payments"}}},
+	)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "elevated"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "platform",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "platform"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:           "admin",
+			NamespaceSelector:     metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			RequireNamespaceLabel: &rbacmanagerv1beta1.NamespaceLabelRequirement{Key: "rbac.example.com/allow-elevated", Value: "true"},
+		}},
+	}}
+	defer ForgetNamespaceSelectors(rbacDef.Name)
+
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	roleBindings := func() []string {
+		rbs, err := client.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+		assert.NoError(t, err)
+		namespaces := []string{}
+		for _, rb := range rbs.Items {
+			namespaces = append(namespaces, rb.Namespace)
+		}
+		return namespaces
+	}
+	guardLabelMissing := func() *metav1.Condition {
+		return meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionGuardLabelMissing)
+	}
+
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, []string{"web"}, roleBindings())
+	if condition := guardLabelMissing(); assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Namespaces skipped for missing labels: rbacBindings entry platform: ClusterRole admin requires label rbac.example.com/allow-elevated=true in api", condition.Message)
+	}
+	assert.Equal(t, "Normal GuardLabelMissing rbacBindings entry platform: ClusterRole admin requires label rbac.example.com/allow-elevated=true in api, not creating Role Bindings there", <-recorder.Events)
+
+	// Labeling the namespace creates the Role Binding
+	api, err := client.CoreV1().Namespaces().Get(context.TODO(), "api", metav1.GetOptions{})
+	assert.NoError(t, err)
+	api.Labels["rbac.example.com/allow-elevated"] = "true"
+	_, err = client.CoreV1().Namespaces().Update(context.TODO(), api, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, r.ReconcileNamespaceChange(&rbacDef, api))
+	assert.True(t, r.UpdateGuardLabelMissing(&rbacDef))
+	assert.ElementsMatch(t, []string{"web", "api"}, roleBindings())
+	if condition := guardLabelMissing(); assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	}
+
+	// and removing the label deletes it again
+	delete(api.Labels, "rbac.example.com/allow-elevated")
+	_, err = client.CoreV1().Namespaces().Update(context.TODO(), api, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, r.ReconcileNamespaceChange(&rbacDef, api))
+	assert.True(t, r.UpdateGuardLabelMissing(&rbacDef))
+	assert.Equal(t, []string{"web"}, roleBindings())
+}
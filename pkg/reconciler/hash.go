@@ -0,0 +1,135 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// specHashVersion prefixes every spec hash. It must change whenever the
+// hashed content changes so that hashes written by older versions are ignored
+// instead of causing every resource to be replaced.
+const specHashVersion = "v2"
+
+// hashedOwnerRef holds the owner reference fields compared by ownerRefMatches
+type hashedOwnerRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+// specHash hashes the name, owners, and spec of a requested resource. Only
+// RBAC Definition owner references are hashed, so owners added by others
+// don't make an adopted resource look changed.
+func specHash(objectMeta *metav1.ObjectMeta, spec interface{}) string {
+	ownerRefs := []hashedOwnerRef{}
+	for _, ownerRef := range objectMeta.OwnerReferences {
+		if !isDefinitionOwnerRef(&ownerRef) {
+			continue
+		}
+		ownerRefs = append(ownerRefs, hashedOwnerRef{
+			APIVersion: ownerRef.APIVersion,
+			Kind:       ownerRef.Kind,
+			Name:       ownerRef.Name,
+		})
+	}
+
+	// Marshaling structs and slices is deterministic
+	data, _ := json.Marshal(struct {
+		Name            string           `json:"name"`
+		Namespace       string           `json:"namespace"`
+		OwnerReferences []hashedOwnerRef `json:"ownerReferences"`
+		Spec            interface{}      `json:"spec"`
+	}{objectMeta.Name, objectMeta.Namespace, ownerRefs, spec})
+
+	sum := sha256.Sum256(data)
+	return specHashVersion + "-" + hex.EncodeToString(sum[:])
+}
+
+// bindingSpec is the part of a Role Binding or Cluster Role Binding that is
+// hashed, normalized so that neither server side defaulting nor the order of
+// subjects changes the hash
+func bindingSpec(roleRef rbacv1.RoleRef, subjects []rbacv1.Subject) interface{} {
+	normalized := sortedSubjects(subjects)
+
+	return struct {
+		RoleRef  rbacv1.RoleRef   `json:"roleRef"`
+		Subjects []rbacv1.Subject `json:"subjects"`
+	}{rbacv1.RoleRef{Kind: roleRef.Kind, Name: roleRef.Name}, normalized}
+}
+
+// serviceAccountSpec is the part of a Service Account that is hashed: its
+// image pull secrets, whether tokens are mounted, and the labels and
+// annotations its managed-metadata annotation lists
+func serviceAccountSpec(sa *v1.ServiceAccount) interface{} {
+	keys := managedMetadataKeys(&sa.ObjectMeta)
+	if len(keys.Labels) == 0 && len(keys.Annotations) == 0 && sa.AutomountServiceAccountToken == nil {
+		// Service Accounts without metadata hash as they did before metadata
+		// could be set, so they aren't replaced after an upgrade
+		if len(sa.ImagePullSecrets) == 0 {
+			return nil
+		}
+		return sa.ImagePullSecrets
+	}
+
+	return struct {
+		ImagePullSecrets             []v1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+		AutomountServiceAccountToken *bool                     `json:"automountServiceAccountToken,omitempty"`
+		Labels                       map[string]string         `json:"labels,omitempty"`
+		Annotations                  map[string]string         `json:"annotations,omitempty"`
+	}{sa.ImagePullSecrets, sa.AutomountServiceAccountToken, pickKeys(sa.Labels, keys.Labels), pickKeys(sa.Annotations, keys.Annotations)}
+}
+
+// annotate records which RBAC Definition a requested resource belongs to and
+// the hash of its spec, and adds the controller wide labels and annotations
+func (r *Reconciler) annotate(objectMeta *metav1.ObjectMeta, spec interface{}) {
+	options := CurrentOptions()
+	addChildMetadata(objectMeta, options.ChildLabels, options.ChildAnnotations)
+
+	annotations := map[string]string{}
+	for key, value := range objectMeta.Annotations {
+		annotations[key] = value
+	}
+	if r.rbacDef != nil {
+		annotations[kube.ManagedByAnnotation] = r.rbacDef.Name
+	}
+	annotations[kube.SpecHashAnnotation] = specHash(objectMeta, spec)
+	objectMeta.Annotations = annotations
+}
+
+// specHashesMatch compares the spec hashes of an existing and a requested
+// resource. The result is only usable if ok is true, which requires both
+// hashes to come from the current hashing version. An existing resource whose
+// content no longer hashes to its own annotation was changed by someone else
+// and never matches.
+func specHashesMatch(existingMeta *metav1.ObjectMeta, existingSpec interface{}, requestedMeta *metav1.ObjectMeta) (matches bool, ok bool) {
+	existingHash := existingMeta.Annotations[kube.SpecHashAnnotation]
+	requestedHash := requestedMeta.Annotations[kube.SpecHashAnnotation]
+
+	if !strings.HasPrefix(existingHash, specHashVersion+"-") || !strings.HasPrefix(requestedHash, specHashVersion+"-") {
+		return false, false
+	}
+
+	return existingHash == requestedHash && specHash(existingMeta, existingSpec) == existingHash, true
+}
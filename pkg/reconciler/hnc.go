@@ -0,0 +1,44 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// hncTreeLabelSuffix is appended to the name of every ancestor of a namespace,
+// and the namespace itself, to form the tree labels the Hierarchical
+// Namespace Controller maintains
+const hncTreeLabelSuffix = ".tree.hnc.x-k8s.io/depth"
+
+// descendantNamespaces returns the namespaces below any of roots in the
+// Hierarchical Namespace Controller tree that aren't roots themselves. Every
+// descendant carries the tree label of each of its ancestors, so the whole
+// subtree is found without following parents.
+func descendantNamespaces(roots []string, namespaces *v1.NamespaceList) []string {
+	descendants := []string{}
+	for _, namespace := range namespaces.Items {
+		if stringInSlice(namespace.Name, roots) {
+			continue
+		}
+		for _, root := range roots {
+			if _, ok := namespace.Labels[root+hncTreeLabelSuffix]; ok {
+				descendants = append(descendants, namespace.Name)
+				break
+			}
+		}
+	}
+	return descendants
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// MaxImportDepth limits how many levels of RBAC Definitions can import each
+// other, unless SetOptions replaced it
+var MaxImportDepth = 5
+
+// resolveImports returns rbacDef with the rbacBindings of every definition it
+// imports placed before its own. Entries with the same name are merged, so a
+// definition can add subjects and bindings to an entry it imports.
+// RBACDefinitionServiceAccounts subjects are replaced by the Service Accounts
+// they stand for.
+func (p *Parser) resolveImports(rbacDef rbacmanagerv1beta1.RBACDefinition) (rbacmanagerv1beta1.RBACDefinition, error) {
+	if len(rbacDef.Imports) == 0 && !referencesServiceAccounts(&rbacDef) {
+		return rbacDef, nil
+	}
+
+	chain := []string{rbacDef.Name}
+	bindings, err := p.importBindings(&rbacDef, chain)
+	if err != nil {
+		return rbacDef, err
+	}
+	bindings, err = p.expandDefinitionServiceAccounts(bindings, chain)
+	if err != nil {
+		return rbacDef, err
+	}
+
+	rbacDef.RBACBindings = bindings
+	rbacDef.Imports = nil
+	return rbacDef, nil
+}
+
+func (p *Parser) importBindings(rbacDef *rbacmanagerv1beta1.RBACDefinition, chain []string) ([]rbacmanagerv1beta1.RBACBinding, error) {
+	bindings := []rbacmanagerv1beta1.RBACBinding{}
+
+	for index, name := range rbacDef.Imports {
+		path := fmt.Sprintf("imports[%d]", index)
+
+		if stringInSlice(name, chain) {
+			return nil, &ParseError{Path: path, Reason: fmt.Sprintf("import cycle %v -> %v", strings.Join(chain, " -> "), name)}
+		}
+		if maxDepth := CurrentOptions().MaxImportDepth; len(chain) > maxDepth {
+			return nil, &ParseError{Path: path, Reason: fmt.Sprintf("imports are nested more than %d levels deep", maxDepth)}
+		}
+
+		imported, err := p.getDefinition(name)
+		if err != nil {
+			return nil, &ParseError{Path: path, Reason: fmt.Sprintf("cannot import RBACDefinition %v: %v", name, err)}
+		}
+
+		importedChain := append(append([]string{}, chain...), name)
+		importedBindings, err := p.importBindings(&imported, importedChain)
+		if err != nil {
+			return nil, newParseError(path, "", err)
+		}
+
+		bindings = mergeBindings(bindings, importedBindings)
+	}
+
+	return mergeBindings(bindings, rbacDef.RBACBindings), nil
+}
+
+func (p *Parser) getDefinition(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+	if p.GetDefinition != nil {
+		return p.GetDefinition(name)
+	}
+	return kube.GetRbacDefinition(name)
+}
+
+// mergeBindings appends overlay to base. An overlay entry named like an entry
+// in base adds its subjects and bindings to that entry instead.
+func mergeBindings(base []rbacmanagerv1beta1.RBACBinding, overlay []rbacmanagerv1beta1.RBACBinding) []rbacmanagerv1beta1.RBACBinding {
+	merged := []rbacmanagerv1beta1.RBACBinding{}
+	for _, rbacBinding := range base {
+		merged = append(merged, *rbacBinding.DeepCopy())
+	}
+
+	for _, rbacBinding := range overlay {
+		existing := -1
+		for index := range merged {
+			if merged[index].Name == rbacBinding.Name {
+				existing = index
+				break
+			}
+		}
+
+		if existing < 0 {
+			merged = append(merged, *rbacBinding.DeepCopy())
+			continue
+		}
+
+		target := &merged[existing]
+		for _, subject := range rbacBinding.Subjects {
+			if !containsSubject(target.Subjects, &subject) {
+				target.Subjects = append(target.Subjects, subject)
+			}
+		}
+		for _, clusterRoleBinding := range rbacBinding.ClusterRoleBindings {
+			target.ClusterRoleBindings = append(target.ClusterRoleBindings, *clusterRoleBinding.DeepCopy())
+		}
+		for _, roleBinding := range rbacBinding.RoleBindings {
+			target.RoleBindings = append(target.RoleBindings, *roleBinding.DeepCopy())
+		}
+	}
+
+	return merged
+}
+
+func containsSubject(subjects []rbacmanagerv1beta1.Subject, subject *rbacmanagerv1beta1.Subject) bool {
+	for _, s := range subjects {
+		if reflect.DeepEqual(s, *subject) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// manifestHeader starts every file ManifestWriter writes, which is how files
+// of RBAC Definitions that no longer exist are told apart from others
+const manifestHeader = "# Generated by rbac-manager, do not edit.\n"
+
+// DeletionsFile is the file ManifestWriter lists the resources to delete in
+const DeletionsFile = "deletions.yaml"
+
+// ManifestWriter writes the resources RBAC Definitions request to files
+// instead of creating them, so that they can be reviewed and applied by
+// something else. It only needs read access to the cluster.
+type ManifestWriter struct {
+	Clientset       kubernetes.Interface
+	ListDefinitions func() (rbacmanagerv1beta1.RBACDefinitionList, error)
+	GetDefinition   func(name string) (rbacmanagerv1beta1.RBACDefinition, error)
+	// Dir is the directory manifests are written to
+	Dir string
+}
+
+// Write plans every RBAC Definition and writes the resources it requests to
+// <definition>.yaml in Dir, and the resources that should be deleted to
+// DeletionsFile. Resources are sorted by kind, namespace, and name, so the
+// files only change when the planned resources do. Files of RBAC Definitions
+// that no longer exist are removed. The file of a definition that can't be
+// planned is left as it is.
+func (w *ManifestWriter) Write() error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	rbacDefs, err := w.ListDefinitions()
+	if err != nil {
+		return err
+	}
+	sort.Slice(rbacDefs.Items, func(i, j int) bool {
+		return rbacDefs.Items[i].Name < rbacDefs.Items[j].Name
+	})
+
+	r := &Reconciler{Clientset: w.Clientset, GetDefinition: w.GetDefinition}
+	errs := []error{}
+	files := map[string]bool{DeletionsFile: true}
+	deletions := []runtime.Object{}
+	for i := range rbacDefs.Items {
+		rbacDef := &rbacDefs.Items[i]
+		file := rbacDef.Name + ".yaml"
+		files[file] = true
+		if !rbacDef.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		plan, err := r.Plan(rbacDef)
+		if err != nil {
+			logrus.Errorf("Error planning RBACDefinition %v: %v", rbacDef.Name, err)
+			errs = append(errs, err)
+			continue
+		}
+
+		content, err := manifestContent(planObjects(&plan.Desired, false))
+		if err == nil {
+			err = writeFileIfChanged(filepath.Join(w.Dir, file), content)
+		}
+		if err != nil {
+			logrus.Errorf("Error writing manifests of RBACDefinition %v: %v", rbacDef.Name, err)
+			errs = append(errs, err)
+		}
+		deletions = append(deletions, planObjects(&plan.Delete, true)...)
+	}
+
+	sortObjects(deletions)
+	content, err := manifestContent(deletions)
+	if err == nil {
+		err = writeFileIfChanged(filepath.Join(w.Dir, DeletionsFile), content)
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := removeStaleManifests(w.Dir, files); err != nil {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// WritePeriodically calls Write right away and then every interval until ctx
+// is done
+func (w *ManifestWriter) WritePeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		logrus.Debugf("Writing manifests of RBAC Definitions to %v", w.Dir)
+		if err := w.Write(); err != nil {
+			logrus.Errorf("Error writing manifests of RBAC Definitions: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// planObjects returns the resources of a plan with their kind set, sorted.
+// Only the name and namespace of resources to delete are kept.
+func planObjects(resources *PlanResources, identityOnly bool) []runtime.Object {
+	objects := []runtime.Object{}
+	add := func(object runtime.Object, objectMeta *metav1.ObjectMeta, typeMeta metav1.TypeMeta) {
+		if identityOnly {
+			objects = append(objects, &metav1.PartialObjectMetadata{
+				TypeMeta:   typeMeta,
+				ObjectMeta: metav1.ObjectMeta{Name: objectMeta.Name, Namespace: objectMeta.Namespace},
+			})
+			return
+		}
+		objects = append(objects, object)
+	}
+
+	for i := range resources.ServiceAccounts {
+		sa := resources.ServiceAccounts[i].DeepCopy()
+		sa.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"}
+		add(sa, &sa.ObjectMeta, sa.TypeMeta)
+	}
+	for i := range resources.ClusterRoleBindings {
+		crb := resources.ClusterRoleBindings[i].DeepCopy()
+		crb.TypeMeta = metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"}
+		add(crb, &crb.ObjectMeta, crb.TypeMeta)
+	}
+	for i := range resources.RoleBindings {
+		rb := resources.RoleBindings[i].DeepCopy()
+		rb.TypeMeta = metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"}
+		add(rb, &rb.ObjectMeta, rb.TypeMeta)
+	}
+	for i := range resources.Roles {
+		role := resources.Roles[i].DeepCopy()
+		role.TypeMeta = metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"}
+		add(role, &role.ObjectMeta, role.TypeMeta)
+	}
+	for i := range resources.Namespaces {
+		namespace := resources.Namespaces[i].DeepCopy()
+		namespace.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"}
+		add(namespace, &namespace.ObjectMeta, namespace.TypeMeta)
+	}
+	sortObjects(objects)
+	return objects
+}
+
+// sortObjects orders objects by kind, namespace, and name
+func sortObjects(objects []runtime.Object) {
+	key := func(object runtime.Object) string {
+		accessor := object.(metav1.ObjectMetaAccessor).GetObjectMeta()
+		return object.GetObjectKind().GroupVersionKind().Kind + "/" + accessor.GetNamespace() + "/" + accessor.GetName()
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return key(objects[i]) < key(objects[j])
+	})
+}
+
+// manifestContent renders objects as a stream of YAML documents without the
+// fields only the API server sets
+func manifestContent(objects []runtime.Object) ([]byte, error) {
+	content := bytes.NewBufferString(manifestHeader)
+	for _, object := range objects {
+		fields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			return nil, err
+		}
+		delete(fields, "status")
+		if metadata, ok := fields["metadata"].(map[string]interface{}); ok {
+			for _, field := range []string{"creationTimestamp", "resourceVersion", "uid", "generation", "managedFields", "selfLink"} {
+				delete(metadata, field)
+			}
+		}
+		data, err := yaml.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(content, "---\n%s", data)
+	}
+	return content.Bytes(), nil
+}
+
+// writeFileIfChanged replaces the file at path with content unless it
+// already has that content. The file is replaced in one step, so readers
+// never see part of it.
+func writeFileIfChanged(path string, content []byte) error {
+	if existing, err := ioutil.ReadFile(path); err == nil && bytes.Equal(existing, content) {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	logrus.Infof("Writing %v", path)
+	return os.Rename(tmp, path)
+}
+
+// removeStaleManifests removes the files in dir written by ManifestWriter
+// that aren't in keep
+func removeStaleManifests(dir string, keep map[string]bool) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yaml") || keep[entry.Name()] {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		content, err := ioutil.ReadFile(path)
+		if err != nil || !bytes.HasPrefix(content, []byte(manifestHeader)) {
+			continue
+		}
+		logrus.Infof("Removing %v, its RBACDefinition no longer exists", path)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestManifestWriter(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "manifests"
+	rbacDef.UID = types.UID("manifests-uid")
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{
+			{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}},
+			{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "ci", Namespace: "web"}},
+		},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{
+			{ClusterRole: "edit", Namespace: "web"},
+			{ClusterRole: "edit", Namespace: "api"},
+		},
+	}}
+
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "removed.yaml")
+	assert.NoError(t, ioutil.WriteFile(stale, []byte(manifestHeader), 0644))
+	other := filepath.Join(dir, "other.yaml")
+	assert.NoError(t, ioutil.WriteFile(other, []byte("kind: ConfigMap\n"), 0644))
+
+	writer := &ManifestWriter{
+		Clientset: client,
+		ListDefinitions: func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+			return rbacmanagerv1beta1.RBACDefinitionList{Items: []rbacmanagerv1beta1.RBACDefinition{rbacDef}}, nil
+		},
+		Dir: dir,
+	}
+	assert.NoError(t, writer.Write())
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "manifests.yaml"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), manifestHeader+"---\napiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRoleBinding\n"))
+	assert.NotContains(t, string(content), "creationTimestamp")
+
+	// Resources are sorted by kind, namespace, and name
+	order := []string{"name: manifests-devs-view", "namespace: api", "namespace: web\n  ownerReferences", "\nkind: ServiceAccount"}
+	last := -1
+	for _, s := range order {
+		index := strings.Index(string(content), s)
+		assert.Greater(t, index, last, "%v should come later", s)
+		last = index
+	}
+
+	// Files of definitions that no longer exist are removed, others are kept
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, other)
+	deletions, err := ioutil.ReadFile(filepath.Join(dir, DeletionsFile))
+	assert.NoError(t, err)
+	assert.Equal(t, manifestHeader, string(deletions))
+
+	// Writing again produces the same bytes
+	assert.NoError(t, writer.Write())
+	again, err := ioutil.ReadFile(filepath.Join(dir, "manifests.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(content), string(again))
+
+	// Resources the definition no longer requests are listed for deletion
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	writer.ListDefinitions = func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+		changed := rbacDef.DeepCopy()
+		changed.RBACBindings[0].RoleBindings = changed.RBACBindings[0].RoleBindings[:1]
+		return rbacmanagerv1beta1.RBACDefinitionList{Items: []rbacmanagerv1beta1.RBACDefinition{*changed}}, nil
+	}
+	assert.NoError(t, writer.Write())
+	deletions, err = ioutil.ReadFile(filepath.Join(dir, DeletionsFile))
+	assert.NoError(t, err)
+	assert.Equal(t, manifestHeader+`---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: manifests-devs-edit
+  namespace: api
+`, string(deletions))
+}
+
+func TestManifestWriterRolesAndNamespaces(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "golden", nil)
+	source := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "developer", Namespace: "golden"},
+		Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+	}
+	_, err := client.RbacV1().Roles("golden").Create(context.TODO(), source, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "golden-roles"
+	rbacDef.UID = types.UID("golden-roles-uid")
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			RoleFrom:        &rbacmanagerv1beta1.RoleSource{Namespace: "golden", Name: "developer"},
+			Namespace:       "web",
+			CreateIfMissing: true,
+		}},
+	}}
+
+	dir := t.TempDir()
+	writer := &ManifestWriter{
+		Clientset: client,
+		ListDefinitions: func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+			return rbacmanagerv1beta1.RBACDefinitionList{Items: []rbacmanagerv1beta1.RBACDefinition{rbacDef}}, nil
+		},
+		Dir: dir,
+	}
+	assert.NoError(t, writer.Write())
+
+	// The namespace to create comes before the Role copy and its binding
+	content, err := ioutil.ReadFile(filepath.Join(dir, "golden-roles.yaml"))
+	assert.NoError(t, err)
+	order := []string{"\nkind: Namespace\nmetadata:", "name: web", "\nkind: Role\nmetadata:", "\nkind: RoleBinding\n"}
+	last := -1
+	for _, s := range order {
+		index := strings.Index(string(content), s)
+		assert.Greater(t, index, last, "%v should come later", s)
+		last = index
+	}
+	assert.Contains(t, string(content), "- pods")
+
+	// Role copies no longer requested are listed for deletion
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	writer.ListDefinitions = func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+		changed := rbacDef.DeepCopy()
+		changed.RBACBindings[0].RoleBindings[0].RoleFrom = nil
+		changed.RBACBindings[0].RoleBindings[0].ClusterRole = "view"
+		return rbacmanagerv1beta1.RBACDefinitionList{Items: []rbacmanagerv1beta1.RBACDefinition{*changed}}, nil
+	}
+	assert.NoError(t, writer.Write())
+	content, err = ioutil.ReadFile(filepath.Join(dir, "golden-roles.yaml"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "kind: Namespace")
+	assert.NotContains(t, string(content), "\nkind: Role\n")
+	deletions, err := ioutil.ReadFile(filepath.Join(dir, DeletionsFile))
+	assert.NoError(t, err)
+	assert.Contains(t, string(deletions), `---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: developer
+  namespace: web
+`)
+}
@@ -23,6 +23,10 @@ import (
 )
 
 func crbMatches(existingCRB *rbacv1.ClusterRoleBinding, requestedCRB *rbacv1.ClusterRoleBinding) bool {
+	if matches, ok := specHashesMatch(&existingCRB.ObjectMeta, bindingSpec(existingCRB.RoleRef, existingCRB.Subjects), &requestedCRB.ObjectMeta); ok {
+		return matches
+	}
+
 	if !metaMatches(&existingCRB.ObjectMeta, &requestedCRB.ObjectMeta) {
 		return false
 	}
@@ -38,6 +42,10 @@ func crbMatches(existingCRB *rbacv1.ClusterRoleBinding, requestedCRB *rbacv1.Clu
 }
 
 func rbMatches(existingRB *rbacv1.RoleBinding, requestedRB *rbacv1.RoleBinding) bool {
+	if matches, ok := specHashesMatch(&existingRB.ObjectMeta, bindingSpec(existingRB.RoleRef, existingRB.Subjects), &requestedRB.ObjectMeta); ok {
+		return matches
+	}
+
 	if !metaMatches(&existingRB.ObjectMeta, &requestedRB.ObjectMeta) {
 		return false
 	}
@@ -53,6 +61,10 @@ func rbMatches(existingRB *rbacv1.RoleBinding, requestedRB *rbacv1.RoleBinding)
 }
 
 func saMatches(existingSA *v1.ServiceAccount, requestedSA *v1.ServiceAccount) bool {
+	if matches, ok := specHashesMatch(&existingSA.ObjectMeta, serviceAccountSpec(existingSA), &requestedSA.ObjectMeta); ok {
+		return matches
+	}
+
 	if metaMatches(&existingSA.ObjectMeta, &requestedSA.ObjectMeta) {
 		if len(requestedSA.ImagePullSecrets) < 1 && existingSA.ImagePullSecrets == nil {
 			return true
@@ -78,16 +90,26 @@ func metaMatches(existingMeta *metav1.ObjectMeta, requestedMeta *metav1.ObjectMe
 	return true
 }
 
+// ownerRefsMatch reports whether the existing owner references include every
+// requested one. Existing objects may have other owners, such as those of an
+// adopted object, but objects requested without owners must have none.
 func ownerRefsMatch(existingOwnerRefs *[]metav1.OwnerReference, requestedOwnerRefs *[]metav1.OwnerReference) bool {
 	requested := *requestedOwnerRefs
 	existing := *existingOwnerRefs
 
-	if len(requested) != len(existing) {
-		return false
+	if len(requested) == 0 {
+		return len(existing) == 0
 	}
 
-	for index, existingOwnerRef := range existing {
-		if !ownerRefMatches(&existingOwnerRef, &requested[index]) {
+	for index := range requested {
+		found := false
+		for _, existingOwnerRef := range existing {
+			if ownerRefMatches(&existingOwnerRef, &requested[index]) {
+				found = true
+				break
+			}
+		}
+		if !found {
 			return false
 		}
 	}
@@ -112,15 +134,15 @@ func ownerRefMatches(existingOwnerRef *metav1.OwnerReference, requestedOwnerRef
 }
 
 func subjectsMatch(existingSubjects *[]rbacv1.Subject, requestedSubjects *[]rbacv1.Subject) bool {
-	rSubjects := *requestedSubjects
-	eSubjects := *existingSubjects
-
-	if len(eSubjects) != len(rSubjects) {
+	if len(*existingSubjects) != len(*requestedSubjects) {
 		return false
 	}
 
-	for index, existingSubject := range eSubjects {
-		if !subjectMatches(&existingSubject, &rSubjects[index]) {
+	// The order of subjects doesn't change the access a binding grants
+	eSubjects := sortedSubjects(*existingSubjects)
+	rSubjects := sortedSubjects(*requestedSubjects)
+	for index := range eSubjects {
+		if !subjectMatches(&eSubjects[index], &rSubjects[index]) {
 			return false
 		}
 	}
@@ -133,6 +155,10 @@ func subjectMatches(existingSubject *rbacv1.Subject, requestedSubject *rbacv1.Su
 		return false
 	}
 
+	if normalizeSubject(*existingSubject).APIGroup != normalizeSubject(*requestedSubject).APIGroup {
+		return false
+	}
+
 	if existingSubject.Name != requestedSubject.Name {
 		return false
 	}
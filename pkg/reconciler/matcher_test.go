@@ -22,6 +22,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
 )
 
 func generateOwnerReferences(name string) []metav1.OwnerReference {
@@ -182,3 +183,65 @@ func TestRbMatches(t *testing.T) {
 		t.Fatal("RB 3 should match RB 3")
 	}
 }
+
+func TestSubjectMatchesNormalizedAPIGroup(t *testing.T) {
+	existing := rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "devs", APIGroup: rbacv1.GroupName}
+	requested := rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "devs"}
+	if !subjectMatches(&existing, &requested) {
+		t.Fatal("Group with server defaulted apiGroup should match requested Group")
+	}
+
+	existing = rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "robot", Namespace: "bots"}
+	requested = rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "robot", Namespace: "bots", APIGroup: rbacv1.GroupName}
+	if !subjectMatches(&existing, &requested) {
+		t.Fatal("ServiceAccount should match regardless of requested apiGroup")
+	}
+}
+
+func TestRbMatchesSpecHash(t *testing.T) {
+	r := Reconciler{}
+	requested := rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "example",
+			Namespace:       "web",
+			OwnerReferences: generateOwnerReferences("ownerrefs"),
+		},
+		RoleRef:  rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"},
+		Subjects: []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "joe"}},
+	}
+	r.annotate(&requested.ObjectMeta, bindingSpec(requested.RoleRef, requested.Subjects))
+
+	// Server side defaulting doesn't change the hash
+	existing := *requested.DeepCopy()
+	existing.RoleRef.APIGroup = rbacv1.GroupName
+	if !rbMatches(&existing, &requested) {
+		t.Fatal("Role Bindings with matching spec hashes should match")
+	}
+
+	// Changes made by someone else no longer match the hash they carry
+	existing.Subjects = append(existing.Subjects, rbacv1.Subject{Kind: rbacv1.UserKind, Name: "intruder"})
+	if rbMatches(&existing, &requested) {
+		t.Fatal("Role Bindings changed after being annotated should not match")
+	}
+
+	// A different hash from the current version means the spec changed
+	existing = *requested.DeepCopy()
+	existing.Annotations[kube.SpecHashAnnotation] = specHashVersion + "-0000"
+	if rbMatches(&existing, &requested) {
+		t.Fatal("Role Bindings with different spec hashes should not match")
+	}
+
+	// Hashes from other versions fall back to comparing the objects
+	existing.Annotations[kube.SpecHashAnnotation] = "v0-0000"
+	if !rbMatches(&existing, &requested) {
+		t.Fatal("Role Bindings with an outdated spec hash should be compared")
+	}
+	delete(existing.Annotations, kube.SpecHashAnnotation)
+	if !rbMatches(&existing, &requested) {
+		t.Fatal("Role Bindings without a spec hash should be compared")
+	}
+	existing.Subjects = nil
+	if rbMatches(&existing, &requested) {
+		t.Fatal("Role Bindings without a spec hash and different subjects should not match")
+	}
+}
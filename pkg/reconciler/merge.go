@@ -0,0 +1,142 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// mergedBindingName names the binding that replaces all bindings of an RBAC
+// Definition granting roleRef in the same namespace
+func mergedBindingName(rbacDefName string, roleRef rbacv1.RoleRef) string {
+	return fmt.Sprintf("%v-%v-%v", rbacDefName, strings.ToLower(roleRef.Kind), roleRef.Name)
+}
+
+func roleBindingKey(rb *rbacv1.RoleBinding) string {
+	return rb.Namespace + "/" + rb.RoleRef.Kind + "/" + rb.RoleRef.Name
+}
+
+// mergeParsedBindings replaces generated bindings that share a namespace and
+// roleRef with a single binding holding all of their subjects. Bindings that
+// don't share them with another keep their names.
+func (p *Parser) mergeParsedBindings() {
+	p.parsedClusterRoleBindings = mergeClusterRoleBindings(p.parsedClusterRoleBindings, p.definitionName)
+	p.parsedRoleBindings = mergeRoleBindings(p.parsedRoleBindings, p.definitionName)
+}
+
+func mergeClusterRoleBindings(crbs []rbacv1.ClusterRoleBinding, rbacDefName string) []rbacv1.ClusterRoleBinding {
+	counts := map[string]int{}
+	for _, crb := range crbs {
+		counts[crb.RoleRef.Kind+"/"+crb.RoleRef.Name]++
+	}
+
+	merged := []rbacv1.ClusterRoleBinding{}
+	index := map[string]int{}
+	for _, crb := range crbs {
+		key := crb.RoleRef.Kind + "/" + crb.RoleRef.Name
+		if counts[key] < 2 {
+			merged = append(merged, crb)
+			continue
+		}
+
+		if i, ok := index[key]; ok {
+			merged[i].Subjects = mergeSubjects(merged[i].Subjects, crb.Subjects)
+			continue
+		}
+		crb.Name = mergedBindingName(rbacDefName, crb.RoleRef)
+		crb.Subjects = mergeSubjects(nil, crb.Subjects)
+		index[key] = len(merged)
+		merged = append(merged, crb)
+	}
+	return merged
+}
+
+func mergeRoleBindings(rbs []rbacv1.RoleBinding, rbacDefName string) []rbacv1.RoleBinding {
+	counts := map[string]int{}
+	for i := range rbs {
+		counts[roleBindingKey(&rbs[i])]++
+	}
+
+	merged := []rbacv1.RoleBinding{}
+	index := map[string]int{}
+	for _, rb := range rbs {
+		key := roleBindingKey(&rb)
+		if counts[key] < 2 {
+			merged = append(merged, rb)
+			continue
+		}
+
+		if i, ok := index[key]; ok {
+			merged[i].Subjects = mergeSubjects(merged[i].Subjects, rb.Subjects)
+			continue
+		}
+		rb.Name = mergedBindingName(rbacDefName, rb.RoleRef)
+		rb.Subjects = mergeSubjects(nil, rb.Subjects)
+		index[key] = len(merged)
+		merged = append(merged, rb)
+	}
+	return merged
+}
+
+// mergeSubjects returns the union of two lists of subjects without
+// duplicates, sorted by kind, namespace, and name
+func mergeSubjects(subjects []rbacv1.Subject, more []rbacv1.Subject) []rbacv1.Subject {
+	seen := map[rbacv1.Subject]bool{}
+	union := []rbacv1.Subject{}
+	for _, list := range [][]rbacv1.Subject{subjects, more} {
+		for _, subject := range list {
+			if !seen[subject] {
+				seen[subject] = true
+				union = append(union, subject)
+			}
+		}
+	}
+
+	sortSubjects(union)
+	return union
+}
+
+// renameMergedBindings gives the bindings of expanded entries the name of the
+// merged binding that grants their subjects access, keeping their subjects
+// attributed to the entry they came from
+func renameMergedBindings(expanded []ExpandedBinding, rbacDefName string) {
+	counts := map[string]int{}
+	for _, entry := range expanded {
+		for _, crb := range entry.ClusterRoleBindings {
+			counts[crb.RoleRef.Kind+"/"+crb.RoleRef.Name]++
+		}
+		for i := range entry.RoleBindings {
+			counts[roleBindingKey(&entry.RoleBindings[i])]++
+		}
+	}
+
+	for _, entry := range expanded {
+		for i := range entry.ClusterRoleBindings {
+			crb := &entry.ClusterRoleBindings[i]
+			if counts[crb.RoleRef.Kind+"/"+crb.RoleRef.Name] > 1 {
+				crb.Name = mergedBindingName(rbacDefName, crb.RoleRef)
+			}
+		}
+		for i := range entry.RoleBindings {
+			rb := &entry.RoleBindings[i]
+			if counts[roleBindingKey(rb)] > 1 {
+				rb.Name = mergedBindingName(rbacDefName, rb.RoleRef)
+			}
+		}
+	}
+}
@@ -0,0 +1,415 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// LegacyOwner identifies owner references to RBAC Definitions written by
+// earlier versions of RBAC Manager that used another API group or version
+type LegacyOwner struct {
+	APIVersion string
+	Kind       string
+}
+
+// ParseLegacyOwners parses a comma separated list of owners in the form
+// group/version, or group/version:Kind if the Kind was not RBACDefinition
+func ParseLegacyOwners(value string) ([]LegacyOwner, error) {
+	owners := []LegacyOwner{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		owner := LegacyOwner{APIVersion: entry, Kind: "RBACDefinition"}
+		if parts := strings.SplitN(entry, ":", 2); len(parts) == 2 {
+			owner.APIVersion, owner.Kind = parts[0], parts[1]
+		}
+
+		gv, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err != nil || gv.Group == "" || gv.Version == "" || owner.Kind == "" {
+			return nil, fmt.Errorf("legacy owner %s must be in the form group/version or group/version:Kind", entry)
+		}
+		owners = append(owners, owner)
+	}
+	return owners, nil
+}
+
+// Migrator adopts resources created by an earlier version of RBAC Manager
+// whose owner references no longer match the ones the current version
+// computes. Without migration those resources would never be matched or
+// pruned, while duplicates get created next to them.
+type Migrator struct {
+	Clientset       kubernetes.Interface
+	ListDefinitions func() (rbacmanagerv1beta1.RBACDefinitionList, error)
+	LegacyOwners    []LegacyOwner
+	// LegacyLabels are labels earlier versions marked managed resources
+	// with, which are replaced by the current ones
+	LegacyLabels map[string]string
+	// Selector limits migration to resources with matching labels, such as
+	// those of another deployment of RBAC Manager. Selected resources are
+	// only migrated if their RBAC Definition still requests them, and
+	// pruned otherwise. When it is nil every resource with a legacy owner
+	// reference is migrated as it is.
+	Selector labels.Selector
+	// Recorder receives a summary event for every RBAC Definition whose
+	// resources were migrated or pruned, it may be nil
+	Recorder record.EventRecorder
+}
+
+// migrationSummary counts what happened to the resources of one RBAC Definition
+type migrationSummary struct {
+	migrated int
+	pruned   int
+}
+
+// Migrate rewrites the owner references, labels, and managed-by annotation
+// of every resource owned by a legacy owner reference to an RBAC Definition
+// that exists under the same name, and returns them as
+// Kind/namespace/name. Resources of definitions that no longer exist are left
+// alone.
+func (m *Migrator) Migrate() ([]string, error) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	rbacDefs, err := m.ListDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	definitions := map[string]*rbacmanagerv1beta1.RBACDefinition{}
+	for i := range rbacDefs.Items {
+		definitions[rbacDefs.Items[i].Name] = &rbacDefs.Items[i]
+	}
+
+	// Legacy resources may not carry the current label, so everything the
+	// selector allows is listed
+	listOptions := metav1.ListOptions{}
+	if m.Selector != nil {
+		listOptions.LabelSelector = m.Selector.String()
+	}
+	serviceAccounts, err := listAllServiceAccounts(context.TODO(), m.Clientset, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	clusterRoleBindings, err := listAllClusterRoleBindings(context.TODO(), m.Clientset, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	roleBindings, err := listAllRoleBindings(context.TODO(), m.Clientset, listOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := []string{}
+	errs := []error{}
+	summaries := map[string]*migrationSummary{}
+	summary := func(rbacDef *rbacmanagerv1beta1.RBACDefinition) *migrationSummary {
+		if summaries[rbacDef.Name] == nil {
+			summaries[rbacDef.Name] = &migrationSummary{}
+		}
+		return summaries[rbacDef.Name]
+	}
+	record := func(kind string, objectMeta *metav1.ObjectMeta, rbacDef *rbacmanagerv1beta1.RBACDefinition, err error) {
+		if err != nil {
+			logrus.Errorf("Error migrating %v: %v", objectKey(kind, objectMeta), err)
+			metrics.ErrorCounter.Inc()
+			errs = append(errs, err)
+			return
+		}
+		logrus.Infof("Migrated %v to RBACDefinition %v", objectKey(kind, objectMeta), rbacDef.Name)
+		metrics.LegacyOwnersMigratedCounter.WithLabelValues(kind).Inc()
+		migrated = append(migrated, objectKey(kind, objectMeta))
+		summary(rbacDef).migrated++
+	}
+	prune := func(kind string, objectMeta *metav1.ObjectMeta, rbacDef *rbacmanagerv1beta1.RBACDefinition, err error) {
+		if apierrors.IsNotFound(err) {
+			return
+		} else if err != nil {
+			logrus.Errorf("Error pruning %v: %v", objectKey(kind, objectMeta), err)
+			metrics.ErrorCounter.Inc()
+			errs = append(errs, err)
+			return
+		}
+		logrus.Infof("Pruned %v, RBACDefinition %v no longer requests it", objectKey(kind, objectMeta), rbacDef.Name)
+		summary(rbacDef).pruned++
+	}
+
+	desired := m.desiredResources(definitions)
+
+	for i := range serviceAccounts.Items {
+		sa := &serviceAccounts.Items[i]
+		rbacDef, ok := m.legacyDefinition(&sa.ObjectMeta, definitions)
+		if !ok {
+			continue
+		}
+		p, ok := desired(rbacDef)
+		if !ok {
+			continue
+		}
+		if p != nil && !p.requestsServiceAccount(sa) {
+			err := m.Clientset.CoreV1().ServiceAccounts(sa.Namespace).Delete(context.TODO(), sa.Name, deleteOptions(&sa.ObjectMeta))
+			if err == nil {
+				deleteTokenSecrets(context.TODO(), m.Clientset, sa)
+			}
+			prune("ServiceAccount", &sa.ObjectMeta, rbacDef, err)
+			continue
+		}
+		m.migrateObjectMeta(&sa.ObjectMeta, rbacDef)
+		_, err := m.Clientset.CoreV1().ServiceAccounts(sa.Namespace).Update(context.TODO(), sa, kube.UpdateOptions)
+		record("ServiceAccount", &sa.ObjectMeta, rbacDef, err)
+	}
+	for i := range clusterRoleBindings.Items {
+		crb := &clusterRoleBindings.Items[i]
+		rbacDef, ok := m.legacyDefinition(&crb.ObjectMeta, definitions)
+		if !ok {
+			continue
+		}
+		p, ok := desired(rbacDef)
+		if !ok {
+			continue
+		}
+		if p != nil && !p.requestsClusterRoleBinding(crb) {
+			err := kube.RBAC(m.Clientset).ClusterRoleBindings().Delete(context.TODO(), crb.Name, deleteOptions(&crb.ObjectMeta))
+			prune("ClusterRoleBinding", &crb.ObjectMeta, rbacDef, err)
+			continue
+		}
+		m.migrateObjectMeta(&crb.ObjectMeta, rbacDef)
+		_, err := kube.RBAC(m.Clientset).ClusterRoleBindings().Update(context.TODO(), crb, kube.UpdateOptions)
+		record("ClusterRoleBinding", &crb.ObjectMeta, rbacDef, err)
+	}
+	for i := range roleBindings.Items {
+		rb := &roleBindings.Items[i]
+		rbacDef, ok := m.legacyDefinition(&rb.ObjectMeta, definitions)
+		if !ok {
+			continue
+		}
+		p, ok := desired(rbacDef)
+		if !ok {
+			continue
+		}
+		if p != nil && !p.requestsRoleBinding(rb) {
+			err := kube.RBAC(m.Clientset).RoleBindings(rb.Namespace).Delete(context.TODO(), rb.Name, deleteOptions(&rb.ObjectMeta))
+			prune("RoleBinding", &rb.ObjectMeta, rbacDef, err)
+			continue
+		}
+		m.migrateObjectMeta(&rb.ObjectMeta, rbacDef)
+		_, err := kube.RBAC(m.Clientset).RoleBindings(rb.Namespace).Update(context.TODO(), rb, kube.UpdateOptions)
+		record("RoleBinding", &rb.ObjectMeta, rbacDef, err)
+	}
+
+	for name, s := range summaries {
+		m.summarize(definitions[name], s)
+	}
+
+	return migrated, utilerrors.NewAggregate(errs)
+}
+
+// desiredResources returns a function that parses an RBAC Definition once
+// and returns its parser, or nil if every legacy resource is migrated
+// regardless of content. It reports false for definitions that can't be
+// parsed, whose resources are neither migrated nor pruned.
+func (m *Migrator) desiredResources(definitions map[string]*rbacmanagerv1beta1.RBACDefinition) func(rbacDef *rbacmanagerv1beta1.RBACDefinition) (*Parser, bool) {
+	parse := definitionParsers(m.Clientset, definitions, func(rbacDef *rbacmanagerv1beta1.RBACDefinition, err error) {
+		logrus.Errorf("Not migrating resources of RBACDefinition %v, it can't be parsed: %v", rbacDef.Name, err)
+	})
+	return func(rbacDef *rbacmanagerv1beta1.RBACDefinition) (*Parser, bool) {
+		if m.Selector == nil {
+			return nil, true
+		}
+		return parse(rbacDef)
+	}
+}
+
+// definitionParsers returns a function that parses an RBAC Definition once,
+// resolving imports from definitions, and returns its parser. It reports
+// false for definitions that can't be parsed, calling failed the first time.
+func definitionParsers(clientset kubernetes.Interface, definitions map[string]*rbacmanagerv1beta1.RBACDefinition, failed func(rbacDef *rbacmanagerv1beta1.RBACDefinition, err error)) func(rbacDef *rbacmanagerv1beta1.RBACDefinition) (*Parser, bool) {
+	parsed := map[string]*Parser{}
+	return func(rbacDef *rbacmanagerv1beta1.RBACDefinition) (*Parser, bool) {
+		if p, ok := parsed[rbacDef.Name]; ok {
+			return p, p != nil
+		}
+
+		p := &Parser{
+			Clientset: clientset,
+			GetDefinition: func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+				if imported, ok := definitions[name]; ok {
+					return *imported, nil
+				}
+				return rbacmanagerv1beta1.RBACDefinition{}, apierrors.NewNotFound(rbacmanagerv1beta1.SchemeGroupVersion.WithResource("rbacdefinitions").GroupResource(), name)
+			},
+			ownerRefs: rbacDefOwnerRefs(rbacDef),
+		}
+		if err := p.Parse(*rbacDef); err != nil {
+			failed(rbacDef, err)
+			parsed[rbacDef.Name] = nil
+			return nil, false
+		}
+		parsed[rbacDef.Name] = p
+		return p, true
+	}
+}
+
+// summarize records an event on an RBAC Definition about the resources that
+// were migrated to it or pruned
+func (m *Migrator) summarize(rbacDef *rbacmanagerv1beta1.RBACDefinition, s *migrationSummary) {
+	if m.Recorder == nil {
+		return
+	}
+	if m.Selector == nil {
+		m.Recorder.Eventf(rbacDef, v1.EventTypeNormal, "Migrated", "Migrated %d resources with legacy owner references", s.migrated)
+		return
+	}
+	m.Recorder.Eventf(rbacDef, v1.EventTypeNormal, "Migrated", "Migrated %d resources labeled %v and pruned %d that are no longer requested", s.migrated, m.Selector, s.pruned)
+}
+
+// legacyDefinition returns the RBAC Definition the legacy owner reference of
+// objectMeta refers to. Objects with more than one legacy owner reference, a
+// current one, or another controller are left alone.
+func (m *Migrator) legacyDefinition(objectMeta *metav1.ObjectMeta, definitions map[string]*rbacmanagerv1beta1.RBACDefinition) (*rbacmanagerv1beta1.RBACDefinition, bool) {
+	var legacyRef *metav1.OwnerReference
+	for i := range objectMeta.OwnerReferences {
+		ownerRef := &objectMeta.OwnerReferences[i]
+		if m.isLegacy(ownerRef) {
+			if legacyRef != nil {
+				return nil, false
+			}
+			legacyRef = ownerRef
+		} else if isDefinitionOwnerRef(ownerRef) || (ownerRef.Controller != nil && *ownerRef.Controller) {
+			return nil, false
+		}
+	}
+	if legacyRef == nil {
+		return nil, false
+	}
+
+	rbacDef, ok := definitions[legacyRef.Name]
+	if !ok {
+		logrus.Debugf("Not migrating %v, RBACDefinition %v does not exist", objectMeta.Name, legacyRef.Name)
+		return nil, false
+	}
+	return rbacDef, true
+}
+
+// migrateObjectMeta updates objectMeta to be owned by rbacDef in place of its
+// legacy owner, keeping other owners, and replaces LegacyLabels with the
+// current ones
+func (m *Migrator) migrateObjectMeta(objectMeta *metav1.ObjectMeta, rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	labels := map[string]string{}
+	for key, value := range objectMeta.Labels {
+		if legacy, ok := m.LegacyLabels[key]; !ok || legacy != value {
+			labels[key] = value
+		}
+	}
+	for key, value := range kube.Labels {
+		labels[key] = value
+	}
+
+	annotations := map[string]string{}
+	for key, value := range objectMeta.Annotations {
+		annotations[key] = value
+	}
+	annotations[kube.ManagedByAnnotation] = rbacDef.Name
+
+	ownerRefs := []metav1.OwnerReference{}
+	for _, ownerRef := range objectMeta.OwnerReferences {
+		if !m.isLegacy(&ownerRef) {
+			ownerRefs = append(ownerRefs, ownerRef)
+		}
+	}
+	objectMeta.OwnerReferences = append(ownerRefs, rbacDefOwnerRefs(rbacDef)...)
+	objectMeta.Labels = labels
+	objectMeta.Annotations = annotations
+}
+
+func (m *Migrator) isLegacy(ownerRef *metav1.OwnerReference) bool {
+	for _, owner := range m.LegacyOwners {
+		if ownerRef.APIVersion == owner.APIVersion && ownerRef.Kind == owner.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+// requestsServiceAccount reports whether the parsed RBAC Definition requests
+// a Service Account like sa, ignoring its metadata
+func (p *Parser) requestsServiceAccount(sa *v1.ServiceAccount) bool {
+	for i := range p.parsedServiceAccounts {
+		requested := &p.parsedServiceAccounts[i]
+		if requested.Namespace != sa.Namespace || requested.Name != sa.Name {
+			continue
+		}
+		if len(requested.ImagePullSecrets) == 0 && len(sa.ImagePullSecrets) == 0 {
+			return true
+		}
+		if reflect.DeepEqual(requested.ImagePullSecrets, sa.ImagePullSecrets) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestsClusterRoleBinding is requestsServiceAccount for Cluster Role Bindings
+func (p *Parser) requestsClusterRoleBinding(crb *rbacv1.ClusterRoleBinding) bool {
+	for i := range p.parsedClusterRoleBindings {
+		requested := &p.parsedClusterRoleBindings[i]
+		if requested.Name == crb.Name && roleRefMatches(&crb.RoleRef, &requested.RoleRef) && subjectsMatch(&crb.Subjects, &requested.Subjects) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestsRole is requestsServiceAccount for copied Roles
+func (p *Parser) requestsRole(role *rbacv1.Role) bool {
+	for i := range p.parsedRoles {
+		requested := &p.parsedRoles[i]
+		if requested.Namespace == role.Namespace && requested.Name == role.Name && reflect.DeepEqual(roleSpec(requested.Rules), roleSpec(role.Rules)) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestsRoleBinding is requestsServiceAccount for Role Bindings
+func (p *Parser) requestsRoleBinding(rb *rbacv1.RoleBinding) bool {
+	for i := range p.parsedRoleBindings {
+		requested := &p.parsedRoleBindings[i]
+		if requested.Namespace == rb.Namespace && requested.Name == rb.Name && roleRefMatches(&rb.RoleRef, &requested.RoleRef) && subjectsMatch(&rb.Subjects, &requested.Subjects) {
+			return true
+		}
+	}
+	return false
+}
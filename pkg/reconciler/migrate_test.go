@@ -0,0 +1,166 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+func TestParseLegacyOwners(t *testing.T) {
+	owners, err := ParseLegacyOwners("rbacmanager.fairwinds.com/v1beta1, rbacmanager.reactiveops.io/v1alpha1:RbacDefinition")
+	assert.NoError(t, err)
+	assert.Equal(t, []LegacyOwner{
+		{APIVersion: "rbacmanager.fairwinds.com/v1beta1", Kind: "RBACDefinition"},
+		{APIVersion: "rbacmanager.reactiveops.io/v1alpha1", Kind: "RbacDefinition"},
+	}, owners)
+
+	_, err = ParseLegacyOwners("v1")
+	assert.EqualError(t, err, "legacy owner v1 must be in the form group/version or group/version:Kind")
+}
+
+func TestMigrateLegacyOwners(t *testing.T) {
+	legacyOwner := func(name string) metav1.OwnerReference {
+		return metav1.OwnerReference{APIVersion: "rbacmanager.fairwinds.com/v1beta1", Kind: "RBACDefinition", Name: name, UID: "old-uid"}
+	}
+	newBinding := func(name string, ownerRefs ...metav1.OwnerReference) *rbacv1.ClusterRoleBinding {
+		return &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Labels:          map[string]string{"rbac-manager": "fairwinds", "team": "web"},
+				OwnerReferences: ownerRefs,
+			},
+			RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+			Subjects: []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "joe"}},
+		}
+	}
+	deployment := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"}
+	controller := true
+	client := fake.NewSimpleClientset(
+		newBinding("web-devs-view", legacyOwner("web"), deployment),
+		newBinding("gone-devs-view", legacyOwner("gone")),
+		newBinding("shared-devs-view", legacyOwner("web"), metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Controller: &controller}),
+	)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "web"
+	rbacDef.UID = "new-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "devs",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+
+	m := Migrator{
+		Clientset: client,
+		ListDefinitions: func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+			return rbacmanagerv1beta1.RBACDefinitionList{Items: []rbacmanagerv1beta1.RBACDefinition{rbacDef}}, nil
+		},
+		LegacyOwners: []LegacyOwner{{APIVersion: "rbacmanager.fairwinds.com/v1beta1", Kind: "RBACDefinition"}},
+		LegacyLabels: map[string]string{"rbac-manager": "fairwinds"},
+	}
+	migrated, err := m.Migrate()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ClusterRoleBinding//web-devs-view"}, migrated)
+
+	crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "web-devs-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, append([]metav1.OwnerReference{deployment}, rbacDefOwnerRefs(&rbacDef)...), crb.OwnerReferences)
+	assert.Equal(t, map[string]string{kube.LabelKey: kube.LabelValue, "team": "web"}, crb.Labels)
+	assert.Equal(t, "web", crb.Annotations[kube.ManagedByAnnotation])
+
+	// The migrated binding is matched instead of being duplicated
+	client.ClearActions()
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	for _, action := range client.Actions() {
+		assert.NotEqual(t, "delete", action.GetVerb(), "migrated resources should not be replaced")
+	}
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, crbs.Items, 3)
+
+	migrated, err = m.Migrate()
+	assert.NoError(t, err)
+	assert.Empty(t, migrated)
+}
+
+func TestMigrateFromLabels(t *testing.T) {
+	legacyOwner := []metav1.OwnerReference{{APIVersion: "rbacmanager.fairwinds.com/v1beta1", Kind: "RBACDefinition", Name: "web", UID: "old-uid"}}
+	newBinding := func(name, clusterRole string, upstream bool) *rbacv1.ClusterRoleBinding {
+		crb := &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: name, OwnerReferences: legacyOwner},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: clusterRole},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "joe"}},
+		}
+		if upstream {
+			crb.Labels = map[string]string{"rbac-manager": "fairwinds"}
+		}
+		return crb
+	}
+	client := fake.NewSimpleClientset(
+		newBinding("web-devs-view", "view", true),
+		// Not requested any more
+		newBinding("web-devs-edit", "edit", true),
+		// Requested, but with another role
+		newBinding("web-devs-admin", "view", true),
+		// Not selected
+		newBinding("web-ops-edit", "edit", false),
+	)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "web"
+	rbacDef.UID = "new-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "devs",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}, {ClusterRole: "admin"}},
+	}}
+
+	recorder := record.NewFakeRecorder(10)
+	m := Migrator{
+		Clientset: client,
+		ListDefinitions: func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+			return rbacmanagerv1beta1.RBACDefinitionList{Items: []rbacmanagerv1beta1.RBACDefinition{rbacDef}}, nil
+		},
+		LegacyOwners: []LegacyOwner{{APIVersion: "rbacmanager.fairwinds.com/v1beta1", Kind: "RBACDefinition"}},
+		LegacyLabels: map[string]string{"rbac-manager": "fairwinds"},
+		Selector:     labels.SelectorFromSet(labels.Set{"rbac-manager": "fairwinds"}),
+		Recorder:     recorder,
+	}
+	migrated, err := m.Migrate()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ClusterRoleBinding//web-devs-view"}, migrated)
+
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	names := []string{}
+	for _, crb := range crbs.Items {
+		names = append(names, crb.Name)
+	}
+	assert.ElementsMatch(t, []string{"web-devs-view", "web-ops-edit"}, names)
+
+	assert.Equal(t, `Normal Migrated Migrated 1 resources labeled rbac-manager=fairwinds and pruned 2 that are no longer requested`, <-recorder.Events)
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// missingRole is a roleBindings entry referencing a Role that doesn't exist
+// in some of the namespaces it applies to, which are skipped until it does
+type missingRole struct {
+	rbacBinding string
+	role        string
+	namespaces  []string
+}
+
+func (m missingRole) String() string {
+	return fmt.Sprintf("rbacBindings entry %v: Role %v in %v", m.rbacBinding, m.role, strings.Join(m.namespaces, ", "))
+}
+
+// roleExists reports whether the Role name exists in namespace, fetching
+// each Role only once per parse
+func (p *Parser) roleExists(namespace string, name string) (bool, error) {
+	key := namespace + "/" + name
+	if exists, ok := p.existingRoles[key]; ok {
+		return exists, nil
+	}
+
+	_, err := kube.RBAC(p.Clientset).Roles(namespace).Get(p.context(), name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	if p.existingRoles == nil {
+		p.existingRoles = map[string]bool{}
+	}
+	p.existingRoles[key] = err == nil
+	return err == nil, nil
+}
+
+// BindsRole reports whether a roleBindings entry of rbacDef binds a Role
+// called name, in whichever namespaces it applies to. Imported definitions
+// are not considered.
+func BindsRole(rbacDef *rbacmanagerv1beta1.RBACDefinition, name string) bool {
+	for _, rbacBinding := range rbacDef.RBACBindings {
+		for _, rb := range rbacBinding.RoleBindings {
+			for _, entry := range roleBindingEntries(rb) {
+				if entry.Role == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// setRoleMissingCondition records the roleBindings entries whose Role was
+// missing in some namespaces when rbacDef was last parsed in the
+// RoleMissingInNamespaces condition, and records an event for each entry
+// that wasn't reported before. It returns whether the condition changed.
+func (r *Reconciler) setRoleMissingCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition, missing []missingRole) bool {
+	previous := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionRoleMissingInNamespaces)
+
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionRoleMissingInNamespaces,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rbacDef.Generation,
+		Reason:             "RolesFound",
+		Message:            "Every referenced Role exists",
+	}
+
+	entries := []string{}
+	for _, m := range missing {
+		entries = append(entries, m.String())
+		if previous != nil && previous.Status == metav1.ConditionTrue && strings.Contains(previous.Message, m.String()) {
+			continue
+		}
+		logrus.Warnf("%v of RBACDefinition %v does not exist, waiting for it", m, rbacDef.Name)
+		r.event(v1.EventTypeWarning, "RoleMissing", "%v does not exist, waiting for it", m)
+	}
+	if len(entries) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "RoleMissing"
+		condition.Message = truncateMessage("Roles missing in namespaces: "+strings.Join(entries, "; "), maxReadyMessageLength)
+	}
+
+	changed := previous == nil || previous.Status != condition.Status || previous.Message != condition.Message || previous.ObservedGeneration != condition.ObservedGeneration
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, condition)
+	return changed
+}
+
+// RoleMissingChanged reports whether the last Reconcile changed the
+// RoleMissingInNamespaces condition, so that reconciles started by watchers
+// write the status once a missing Role appears
+func (r *Reconciler) RoleMissingChanged() bool {
+	return r.roleMissingChanged
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestRoleMissingInNamespaces(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "api"}},
+		&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "deployer", Namespace: "web"}},
+	)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "deploy"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:         "ci",
+		Subjects:     []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "ci"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{Role: "deployer", Namespaces: []string{"web", "api"}}},
+	}}
+
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.True(t, r.RoleMissingChanged())
+
+	rbs, err := client.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 1)
+	assert.Equal(t, "web", rbs.Items[0].Namespace)
+
+	condition := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionRoleMissingInNamespaces)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "Roles missing in namespaces: rbacBindings entry ci: Role deployer in api", condition.Message)
+	assert.Equal(t, "Warning RoleMissing rbacBindings entry ci: Role deployer in api does not exist, waiting for it", <-recorder.Events)
+
+	// Nothing changes until the Role is created
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.False(t, r.RoleMissingChanged())
+	assert.Empty(t, recorder.Events)
+
+	_, err = client.RbacV1().Roles("api").Create(context.TODO(), &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "deployer", Namespace: "api"}}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.True(t, r.RoleMissingChanged())
+
+	rbs, err = client.RbacV1().RoleBindings("api").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 1)
+	condition = meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionRoleMissingInNamespaces)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+}
+
+func TestRequireRole(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "api"}})
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "deploy"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:         "ci",
+		Subjects:     []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "ci"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{Role: "deployer", Namespace: "api", RequireRole: true}},
+	}}
+
+	p := Parser{Clientset: client}
+	err := p.Parse(rbacDef)
+	assert.EqualError(t, err, "rbacBindings[0] 'ci': roleBindings[0]: role: Role deployer does not exist in namespaces api")
+	assert.Empty(t, p.parsedRoleBindings)
+
+	// Entries that also set clusterRole bind the ClusterRole, so the Role
+	// isn't needed
+	rbacDef.RBACBindings[0].RoleBindings[0].ClusterRole = "edit"
+	p = Parser{Clientset: client}
+	assert.NoError(t, p.Parse(rbacDef))
+	if assert.Len(t, p.parsedRoleBindings, 1) {
+		assert.Equal(t, rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"}, p.parsedRoleBindings[0].RoleRef)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// ManagedNamespaces switches RBAC Manager to namespaced mode when it is set.
+// Role Bindings, Roles, and Service Accounts are then only listed, watched,
+// and written in these namespaces, so that RBAC Manager only needs Roles
+// granted in each of them, and RBAC Definitions that need cluster wide access
+// are rejected.
+var ManagedNamespaces []string
+
+// NamespacedMode reports whether RBAC Manager only manages ManagedNamespaces
+func NamespacedMode() bool {
+	return len(ManagedNamespaces) > 0
+}
+
+// ListedNamespaces returns the namespaces namespaced resources are listed and
+// watched in, which is every namespace at once unless RBAC Manager runs in
+// namespaced mode
+func ListedNamespaces() []string {
+	if !NamespacedMode() {
+		return []string{metav1.NamespaceAll}
+	}
+	return ManagedNamespaces
+}
+
+// ParseManagedNamespaces parses a comma separated list of namespace names
+func ParseManagedNamespaces(value string) ([]string, error) {
+	namespaces := []string{}
+	seen := map[string]bool{}
+	for _, namespace := range strings.Split(value, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" || seen[namespace] {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return nil, fmt.Errorf("%s is not a valid namespace name: %s", namespace, strings.Join(errs, ", "))
+		}
+		seen[namespace] = true
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, nil
+}
+
+// managesNamespace reports whether RBAC Manager may write resources in namespace
+func managesNamespace(namespace string) bool {
+	return !NamespacedMode() || stringInSlice(namespace, ManagedNamespaces)
+}
+
+// validateNamespaced rejects everything an RBAC Definition requests that
+// can't be done with Roles in ManagedNamespaces. Namespace selectors are
+// allowed, they only match managed namespaces in namespaced mode.
+func validateNamespaced(rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
+	if !NamespacedMode() {
+		return nil
+	}
+
+	if rbacDef.DeletionPolicy == rbacmanagerv1beta1.DeletionPolicyDeleteNamespaces {
+		return &ParseError{Path: "deletionPolicy", Reason: "DeleteNamespaces requires cluster wide access, RBAC Manager runs in namespaced mode"}
+	}
+
+	unmanaged := func(namespace string) string {
+		return fmt.Sprintf("namespace %s is not managed by RBAC Manager, which runs in namespaced mode for %s", namespace, strings.Join(ManagedNamespaces, ", "))
+	}
+
+	for i, rbacBinding := range rbacDef.RBACBindings {
+		path := fmt.Sprintf("rbacBindings[%d]", i)
+
+		if len(rbacBinding.ClusterRoleBindings) > 0 {
+			return newParseError(path, rbacBinding.Name, &ParseError{
+				Path:   "clusterRoleBindings",
+				Reason: "clusterRoleBindings require cluster wide access, RBAC Manager runs in namespaced mode",
+			})
+		}
+
+		for j, subject := range rbacBinding.Subjects {
+			namespace := subject.Namespace
+			if namespace == "" {
+				namespace = rbacDef.Defaults.ServiceAccountNamespace
+			}
+			// Service Account subjects are created in their namespace
+			if subject.Kind == rbacv1.ServiceAccountKind && namespace != "" && !managesNamespace(namespace) {
+				return newParseError(path, rbacBinding.Name, &ParseError{Path: fmt.Sprintf("subjects[%d]", j), Reason: unmanaged(namespace)})
+			}
+		}
+
+		for j, rb := range rbacBinding.RoleBindings {
+			rbPath := fmt.Sprintf("roleBindings[%d]", j)
+			if rb.CreateIfMissing {
+				return newParseError(path, rbacBinding.Name, &ParseError{
+					Path:   rbPath + ".createIfMissing",
+					Reason: "creating namespaces requires cluster wide access, RBAC Manager runs in namespaced mode",
+				})
+			}
+			for _, namespace := range append([]string{rb.Namespace}, rb.Namespaces...) {
+				if namespace != "" && !managesNamespace(namespace) {
+					return newParseError(path, rbacBinding.Name, &ParseError{Path: rbPath, Reason: unmanaged(namespace)})
+				}
+			}
+			if rb.RoleFrom != nil && !managesNamespace(rb.RoleFrom.Namespace) {
+				return newParseError(path, rbacBinding.Name, &ParseError{Path: rbPath + ".roleFrom", Reason: unmanaged(rb.RoleFrom.Namespace)})
+			}
+		}
+	}
+
+	return nil
+}
+
+// listNamespaces lists every namespace, leaving out those RBAC Manager
+// doesn't manage in namespaced mode so that selectors can't match them
+func listNamespaces(ctx context.Context, clientset kubernetes.Interface) (*v1.NamespaceList, error) {
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil || !NamespacedMode() {
+		return namespaces, err
+	}
+
+	managed := &v1.NamespaceList{ListMeta: namespaces.ListMeta}
+	for _, namespace := range namespaces.Items {
+		if managesNamespace(namespace.Name) {
+			managed.Items = append(managed.Items, namespace)
+		}
+	}
+	return managed, nil
+}
+
+// listAllServiceAccounts lists Service Accounts in every listed namespace
+func listAllServiceAccounts(ctx context.Context, clientset kubernetes.Interface, options metav1.ListOptions) (*v1.ServiceAccountList, error) {
+	list := &v1.ServiceAccountList{}
+	for _, namespace := range ListedNamespaces() {
+		page, err := clientset.CoreV1().ServiceAccounts(namespace).List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, page.Items...)
+	}
+	return list, nil
+}
+
+// listAllClusterRoleBindings lists Cluster Role Bindings, of which there are
+// none to manage in namespaced mode
+func listAllClusterRoleBindings(ctx context.Context, clientset kubernetes.Interface, options metav1.ListOptions) (*rbacv1.ClusterRoleBindingList, error) {
+	if NamespacedMode() {
+		return &rbacv1.ClusterRoleBindingList{}, nil
+	}
+	return kube.RBAC(clientset).ClusterRoleBindings().List(ctx, options)
+}
+
+// listAllRoleBindings lists Role Bindings in every listed namespace
+func listAllRoleBindings(ctx context.Context, clientset kubernetes.Interface, options metav1.ListOptions) (*rbacv1.RoleBindingList, error) {
+	list := &rbacv1.RoleBindingList{}
+	for _, namespace := range ListedNamespaces() {
+		page, err := kube.RBAC(clientset).RoleBindings(namespace).List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, page.Items...)
+	}
+	return list, nil
+}
+
+// listAllRoles lists Roles in every listed namespace
+func listAllRoles(ctx context.Context, clientset kubernetes.Interface, options metav1.ListOptions) (*rbacv1.RoleList, error) {
+	list := &rbacv1.RoleList{}
+	for _, namespace := range ListedNamespaces() {
+		page, err := kube.RBAC(clientset).Roles(namespace).List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, page.Items...)
+	}
+	return list, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestParseManagedNamespaces(t *testing.T) {
+	namespaces, err := ParseManagedNamespaces(" web, api,,web ")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web", "api"}, namespaces)
+
+	_, err = ParseManagedNamespaces("web,Api")
+	assert.Error(t, err)
+}
+
+func TestValidateNamespacedMode(t *testing.T) {
+	ManagedNamespaces = []string{"web", "api"}
+	defer func() { ManagedNamespaces = nil }()
+
+	subjects := []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}}
+	tests := []struct {
+		name    string
+		binding rbacmanagerv1beta1.RBACBinding
+		err     string
+	}{{
+		name: "managed namespaces",
+		binding: rbacmanagerv1beta1.RBACBinding{Name: "devs", Subjects: subjects, RoleBindings: []rbacmanagerv1beta1.RoleBinding{
+			{ClusterRole: "edit", Namespaces: []string{"web", "api"}},
+			{ClusterRole: "view", NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "dev"}}},
+		}},
+	}, {
+		name:    "cluster role binding",
+		binding: rbacmanagerv1beta1.RBACBinding{Name: "devs", Subjects: subjects, ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}}},
+		err:     "rbacBindings[0] 'devs': clusterRoleBindings: clusterRoleBindings require cluster wide access, RBAC Manager runs in namespaced mode",
+	}, {
+		name:    "unmanaged namespace",
+		binding: rbacmanagerv1beta1.RBACBinding{Name: "devs", Subjects: subjects, RoleBindings: []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "kube-system"}}},
+		err:     "rbacBindings[0] 'devs': roleBindings[0]: namespace kube-system is not managed by RBAC Manager, which runs in namespaced mode for web, api",
+	}, {
+		name: "service account in unmanaged namespace",
+		binding: rbacmanagerv1beta1.RBACBinding{
+			Name:         "ci",
+			Subjects:     []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "ci", Namespace: "ci"}}},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "web"}},
+		},
+		err: "rbacBindings[0] 'ci': subjects[0]: namespace ci is not managed by RBAC Manager, which runs in namespaced mode for web, api",
+	}, {
+		name:    "namespace creation",
+		binding: rbacmanagerv1beta1.RBACBinding{Name: "devs", Subjects: subjects, RoleBindings: []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "web", CreateIfMissing: true}}},
+		err:     "rbacBindings[0] 'devs': roleBindings[0]: createIfMissing: creating namespaces requires cluster wide access, RBAC Manager runs in namespaced mode",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rbacDef := rbacmanagerv1beta1.RBACDefinition{RBACBindings: []rbacmanagerv1beta1.RBACBinding{tt.binding}}
+			err := Validate(&rbacDef)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReconcileNamespacedMode(t *testing.T) {
+	ManagedNamespaces = []string{"web", "api"}
+	defer func() { ManagedNamespaces = nil }()
+
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "dev"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "api", Labels: map[string]string{"team": "dev"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "dev"}}},
+	)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "devs"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "team",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:       "edit",
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "dev"}},
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	// The selector only matches managed namespaces, and nothing is listed
+	// across the cluster except namespaces
+	rbs, err := listAllRoleBindings(r.context(), client, metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 2)
+	for _, rb := range rbs.Items {
+		assert.NotEqual(t, "payments", rb.Namespace)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource != "namespaces" {
+			assert.NotEmpty(t, action.GetNamespace(), "%s listed across the cluster", action.GetResource().Resource)
+		}
+		assert.NotEqual(t, "clusterrolebindings", action.GetResource().Resource)
+	}
+}
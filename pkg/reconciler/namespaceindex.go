@@ -0,0 +1,281 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// namespaceIndex maps namespace names and label keys to the RBAC Definitions
+// that depend on namespaces with that name or key, so that a namespace event
+// only has to be handled for the definitions that could care about it
+var namespaceIndex = struct {
+	sync.Mutex
+	byName map[string]map[string]bool
+	byKey  map[string]map[string]bool
+	// byOwner holds the definitions depending on namespaces created by an
+	// owner, keyed by kind and name, or by kind alone for any name
+	byOwner map[string]map[string]bool
+	// unkeyed holds the definitions that may depend on any namespace, such
+	// as those with templates, imports, or selectors that require no key
+	unkeyed map[string]bool
+	// names, keys, and owners hold the names, keys, and owners indexed for
+	// every definition
+	names  map[string][]string
+	keys   map[string][]string
+	owners map[string][]string
+	// revisions holds the UID and generation every definition was indexed at
+	revisions map[string]string
+	// labels holds the labels every namespace had when it was last seen, the
+	// labels before the next change to it
+	labels map[string]map[string]string
+	// namespaceOwners holds the owners of every namespace seen, which are set
+	// when it is created
+	namespaceOwners map[string][]string
+}{
+	byName:          map[string]map[string]bool{},
+	byKey:           map[string]map[string]bool{},
+	byOwner:         map[string]map[string]bool{},
+	unkeyed:         map[string]bool{},
+	names:           map[string][]string{},
+	keys:            map[string][]string{},
+	owners:          map[string][]string{},
+	revisions:       map[string]string{},
+	labels:          map[string]map[string]string{},
+	namespaceOwners: map[string][]string{},
+}
+
+// namespaceInterest is what an RBAC Definition depends on in namespaces
+type namespaceInterest struct {
+	names  []string
+	keys   []string
+	owners []string
+	any    bool
+}
+
+func (i *namespaceInterest) selector(selector *metav1.LabelSelector) {
+	keys, ok := requiredKeys(selector)
+	i.keys = append(i.keys, keys...)
+	i.any = i.any || !ok
+}
+
+func (i *namespaceInterest) owner(owner *rbacmanagerv1beta1.NamespaceOwner) {
+	if owner.Label != "" {
+		i.keys = append(i.keys, owner.Label)
+		return
+	}
+	i.owners = append(i.owners, ownerKey(owner.Kind, owner.Name))
+}
+
+func ownerKey(kind, name string) string {
+	return kind + "/" + name
+}
+
+// namespaceOwnerKeys returns the owner keys namespace can be indexed by, for
+// its owners both by kind and name and by kind alone
+func namespaceOwnerKeys(namespace *v1.Namespace) []string {
+	keys := []string{}
+	for _, ownerRef := range namespace.OwnerReferences {
+		keys = append(keys, ownerKey(ownerRef.Kind, ownerRef.Name), ownerKey(ownerRef.Kind, ""))
+	}
+	return keys
+}
+
+// namespaceInterestOf returns the namespaces rbacDef could depend on. It errs
+// on the side of depending on every namespace, since definitions that don't
+// are only skipped.
+func namespaceInterestOf(rbacDef *rbacmanagerv1beta1.RBACDefinition) namespaceInterest {
+	// Imported and referenced definitions may select namespaces themselves
+	interest := namespaceInterest{any: len(DefinitionDependencies(rbacDef)) > 0}
+	for _, rbacBinding := range rbacDef.RBACBindings {
+		for _, subject := range defaultSubjects(rbacBinding.Subjects, &rbacDef.Defaults) {
+			if isTemplate(subject.Name) || isTemplate(subject.Namespace) {
+				interest.any = true
+			}
+			if subject.NamespaceSelector != nil {
+				interest.selector(subject.NamespaceSelector)
+			}
+			if subject.Kind == rbacv1.ServiceAccountKind && subject.Namespace != "" {
+				interest.names = append(interest.names, subject.Namespace)
+			}
+		}
+		for _, clusterRoleBinding := range rbacBinding.ClusterRoleBindings {
+			if clusterRoleBinding.LimitToNamespaceSelector != nil {
+				interest.selector(clusterRoleBinding.LimitToNamespaceSelector)
+			}
+		}
+		for _, roleBinding := range rbacBinding.RoleBindings {
+			if roleBinding.NamespaceAnnotationSelector != nil || roleBinding.PropagateToChildren ||
+				isTemplate(roleBinding.Name) || isTemplate(roleBinding.Namespace) {
+				interest.any = true
+			}
+			if roleBinding.NamespaceOwnedBy != nil {
+				interest.owner(roleBinding.NamespaceOwnedBy)
+			}
+			if roleBinding.RequireNamespaceLabel != nil {
+				interest.keys = append(interest.keys, roleBinding.RequireNamespaceLabel.Key)
+			}
+			if roleBinding.Namespace != "" {
+				interest.names = append(interest.names, roleBinding.Namespace)
+			} else if roleBinding.NamespaceSelector.MatchLabels != nil || roleBinding.NamespaceSelector.MatchExpressions != nil {
+				interest.selector(&roleBinding.NamespaceSelector)
+			}
+			interest.names = append(interest.names, roleBinding.Namespaces...)
+		}
+	}
+	return interest
+}
+
+func definitionRevision(rbacDef *rbacmanagerv1beta1.RBACDefinition) string {
+	return fmt.Sprintf("%v/%v", rbacDef.UID, rbacDef.Generation)
+}
+
+// IndexNamespaceSelectors records the namespace names and label keys rbacDef
+// depends on, replacing those recorded before
+func IndexNamespaceSelectors(rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	namespaceIndex.Lock()
+	defer namespaceIndex.Unlock()
+	indexNamespaceSelectors(rbacDef)
+}
+
+func indexNamespaceSelectors(rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	forgetNamespaceSelectors(rbacDef.Name)
+	add := func(index map[string]map[string]bool, value string) {
+		if index[value] == nil {
+			index[value] = map[string]bool{}
+		}
+		index[value][rbacDef.Name] = true
+	}
+
+	interest := namespaceInterestOf(rbacDef)
+	if interest.any {
+		namespaceIndex.unkeyed[rbacDef.Name] = true
+	}
+	for _, name := range interest.names {
+		add(namespaceIndex.byName, name)
+	}
+	for _, key := range interest.keys {
+		add(namespaceIndex.byKey, key)
+	}
+	for _, owner := range interest.owners {
+		add(namespaceIndex.byOwner, owner)
+	}
+	namespaceIndex.names[rbacDef.Name] = interest.names
+	namespaceIndex.keys[rbacDef.Name] = interest.keys
+	namespaceIndex.owners[rbacDef.Name] = interest.owners
+	namespaceIndex.revisions[rbacDef.Name] = definitionRevision(rbacDef)
+}
+
+// ForgetNamespaceSelectors drops the indexed namespaces of a deleted RBAC
+// Definition
+func ForgetNamespaceSelectors(name string) {
+	namespaceIndex.Lock()
+	defer namespaceIndex.Unlock()
+	forgetNamespaceSelectors(name)
+}
+
+func forgetNamespaceSelectors(name string) {
+	remove := func(index map[string]map[string]bool, values []string) {
+		for _, value := range values {
+			delete(index[value], name)
+			if len(index[value]) == 0 {
+				delete(index, value)
+			}
+		}
+	}
+	remove(namespaceIndex.byName, namespaceIndex.names[name])
+	remove(namespaceIndex.byKey, namespaceIndex.keys[name])
+	remove(namespaceIndex.byOwner, namespaceIndex.owners[name])
+	delete(namespaceIndex.names, name)
+	delete(namespaceIndex.keys, name)
+	delete(namespaceIndex.owners, name)
+	delete(namespaceIndex.revisions, name)
+	delete(namespaceIndex.unkeyed, name)
+}
+
+// DefinitionsForNamespace returns the RBAC Definitions of rbacDefs that could
+// depend on the namespace called name, either with the labels of namespace or
+// with those it had when it was last seen. namespace is nil if it was deleted.
+// Definitions that changed since they were indexed are indexed again first.
+//
+// Owner references are set when a namespace is created and don't change, so
+// definitions selecting namespaces by owner only depend on the namespace when
+// it is first seen, deleted, or, unusually, its owners change.
+func DefinitionsForNamespace(rbacDefs []rbacmanagerv1beta1.RBACDefinition, name string, namespace *v1.Namespace) []rbacmanagerv1beta1.RBACDefinition {
+	namespaceIndex.Lock()
+	defer namespaceIndex.Unlock()
+
+	previous := namespaceIndex.labels[name]
+	previousOwners, seen := namespaceIndex.namespaceOwners[name]
+	var namespaceLabels map[string]string
+	var owners []string
+	if namespace == nil {
+		delete(namespaceIndex.labels, name)
+		delete(namespaceIndex.namespaceOwners, name)
+	} else {
+		namespaceLabels = namespace.Labels
+		namespaceIndex.labels[name] = namespaceLabels
+		owners = namespaceOwnerKeys(namespace)
+		namespaceIndex.namespaceOwners[name] = owners
+	}
+	// Owners only matter when they may have changed
+	if seen && namespace != nil && reflect.DeepEqual(owners, previousOwners) {
+		owners, previousOwners = nil, nil
+	}
+
+	affected := []rbacmanagerv1beta1.RBACDefinition{}
+	for i := range rbacDefs {
+		rbacDef := &rbacDefs[i]
+		if namespaceIndex.revisions[rbacDef.Name] != definitionRevision(rbacDef) {
+			indexNamespaceSelectors(rbacDef)
+		}
+		if namespaceIndex.unkeyed[rbacDef.Name] || namespaceIndex.byName[name][rbacDef.Name] ||
+			keyIndexed(rbacDef.Name, namespaceLabels) || keyIndexed(rbacDef.Name, previous) ||
+			ownerIndexed(rbacDef.Name, owners) || ownerIndexed(rbacDef.Name, previousOwners) {
+			affected = append(affected, *rbacDef)
+		}
+	}
+	return affected
+}
+
+// keyIndexed reports whether the definition called name depends on any of
+// the keys of namespaceLabels. namespaceIndex must be locked.
+func keyIndexed(name string, namespaceLabels map[string]string) bool {
+	for key := range namespaceLabels {
+		if namespaceIndex.byKey[key][name] {
+			return true
+		}
+	}
+	return false
+}
+
+// ownerIndexed reports whether the definition called name depends on any of
+// the owner keys owners. namespaceIndex must be locked.
+func ownerIndexed(name string, owners []string) bool {
+	for _, owner := range owners {
+		if namespaceIndex.byOwner[owner][name] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestDefinitionsForNamespace(t *testing.T) {
+	definition := func(name string, roleBinding rbacmanagerv1beta1.RoleBinding) rbacmanagerv1beta1.RBACDefinition {
+		rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+		rbacDef.Name = name
+		roleBinding.ClusterRole = "view"
+		rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+			Name:         "devs",
+			Subjects:     []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{roleBinding},
+		}}
+		return rbacDef
+	}
+	rbacDefs := []rbacmanagerv1beta1.RBACDefinition{
+		definition("web", rbacmanagerv1beta1.RoleBinding{Namespace: "web"}),
+		definition("listed", rbacmanagerv1beta1.RoleBinding{Namespaces: []string{"api", "web"}}),
+		definition("teams", rbacmanagerv1beta1.RoleBinding{NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "dev"}}}),
+		definition("templated", rbacmanagerv1beta1.RoleBinding{Name: "{{ .Namespace }}-view", NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "x"}}}),
+		definition("untiered", rbacmanagerv1beta1.RoleBinding{NamespaceSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "tier", Operator: metav1.LabelSelectorOpDoesNotExist},
+		}}}),
+	}
+	for _, rbacDef := range rbacDefs {
+		defer ForgetNamespaceSelectors(rbacDef.Name)
+	}
+	defer delete(namespaceIndex.labels, "web")
+	defer delete(namespaceIndex.labels, "db")
+	defer delete(namespaceIndex.namespaceOwners, "web")
+	defer delete(namespaceIndex.namespaceOwners, "db")
+
+	names := func(rbacDefs []rbacmanagerv1beta1.RBACDefinition) []string {
+		names := []string{}
+		for _, rbacDef := range rbacDefs {
+			names = append(names, rbacDef.Name)
+		}
+		return names
+	}
+	namespace := func(name string, namespaceLabels map[string]string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: namespaceLabels}}
+	}
+
+	assert.Equal(t, []string{"web", "listed", "templated", "untiered"}, names(DefinitionsForNamespace(rbacDefs, "web", namespace("web", nil))))
+	assert.Equal(t, []string{"templated", "untiered"}, names(DefinitionsForNamespace(rbacDefs, "db", namespace("db", nil))))
+	assert.Equal(t, []string{"teams", "templated", "untiered"}, names(DefinitionsForNamespace(rbacDefs, "db", namespace("db", map[string]string{"team": "dev"}))))
+	// The labels the namespace had before count too
+	assert.Equal(t, []string{"teams", "templated", "untiered"}, names(DefinitionsForNamespace(rbacDefs, "db", namespace("db", map[string]string{"team": "ops"}))))
+	assert.Equal(t, []string{"teams", "templated", "untiered"}, names(DefinitionsForNamespace(rbacDefs, "db", namespace("db", nil))))
+	assert.Equal(t, []string{"templated", "untiered"}, names(DefinitionsForNamespace(rbacDefs, "db", nil)))
+
+	// Changed definitions are indexed again
+	rbacDefs[0].RBACBindings[0].RoleBindings[0].Namespace = "api"
+	rbacDefs[0].Generation = 2
+	assert.Equal(t, []string{"listed", "templated", "untiered"}, names(DefinitionsForNamespace(rbacDefs, "web", namespace("web", nil))))
+}
+
+func TestDefinitionsForNamespaceOwners(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "tenants"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:      "view",
+			NamespaceOwnedBy: &rbacmanagerv1beta1.NamespaceOwner{Kind: "Tenant", Name: "team-x"},
+		}},
+	}}
+	rbacDefs := []rbacmanagerv1beta1.RBACDefinition{rbacDef}
+	defer ForgetNamespaceSelectors(rbacDef.Name)
+	defer delete(namespaceIndex.labels, "web")
+	defer delete(namespaceIndex.namespaceOwners, "web")
+
+	namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", OwnerReferences: []metav1.OwnerReference{
+		{Kind: "Tenant", Name: "team-x"},
+	}}}
+
+	// Owners are looked at when the namespace is created
+	assert.Len(t, DefinitionsForNamespace(rbacDefs, "web", namespace), 1)
+	// but not on updates that leave them as they were
+	namespace.Labels = map[string]string{"team": "dev"}
+	assert.Len(t, DefinitionsForNamespace(rbacDefs, "web", namespace), 0)
+
+	namespace.OwnerReferences = nil
+	assert.Len(t, DefinitionsForNamespace(rbacDefs, "web", namespace), 1)
+	assert.Len(t, DefinitionsForNamespace(rbacDefs, "web", namespace), 0)
+
+	namespace.OwnerReferences = []metav1.OwnerReference{{Kind: "Tenant", Name: "team-x"}}
+	assert.Len(t, DefinitionsForNamespace(rbacDefs, "web", namespace), 1)
+	assert.Len(t, DefinitionsForNamespace(rbacDefs, "web", nil), 1)
+}
@@ -0,0 +1,234 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// EntryNamespaces are the namespaces a roleBindings entry, or a
+// clusterRoleBindings entry limited to a namespace selector, binds in
+type EntryNamespaces struct {
+	RBACBinding string   `json:"rbacBinding"`
+	Role        string   `json:"role"`
+	Namespaces  []string `json:"namespaces"`
+	// Explanations tell why each of Namespaces matched, and why namespaces
+	// that nearly matched didn't
+	Explanations []NamespaceExplanation `json:"explanations,omitempty"`
+}
+
+// NamespaceExplanation tells why a namespace matched an entry or didn't
+type NamespaceExplanation struct {
+	Namespace string   `json:"namespace"`
+	Matched   bool     `json:"matched"`
+	Reasons   []string `json:"reasons"`
+}
+
+// MatchNamespaces evaluates the namespaces of every entry of rbacDef that
+// binds in namespaces against the namespaces in the cluster, the same way
+// Parse does. Subjects and roles are not looked at. If explain is set,
+// every entry also explains its matches and close misses: namespaces that
+// meet part of its selectors, or that it selects but may not bind in.
+func (p *Parser) MatchNamespaces(rbacDef rbacmanagerv1beta1.RBACDefinition, explain bool) ([]EntryNamespaces, error) {
+	rbacDef, err := p.resolveImports(rbacDef)
+	if err != nil {
+		return nil, err
+	}
+	p.definitionName = rbacDef.Name
+
+	err = Validate(&rbacDef)
+	if err != nil {
+		return nil, err
+	}
+
+	p.allowance, err = namespaceAllowanceFor(&rbacDef)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := listNamespaces(p.context(), p.Clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []EntryNamespaces{}
+	for index, rbacBinding := range rbacDef.RBACBindings {
+		entries := []rbacmanagerv1beta1.RoleBinding{}
+		for _, roleBinding := range rbacBinding.RoleBindings {
+			entries = append(entries, roleBindingEntries(roleBinding)...)
+		}
+		for _, clusterRoleBinding := range rbacBinding.ClusterRoleBindings {
+			if clusterRoleBinding.LimitToNamespaceSelector != nil {
+				entries = append(entries, limitedRoleBinding(&clusterRoleBinding))
+			}
+		}
+
+		for _, entry := range entries {
+			match, err := p.matchEntry(rbacBinding.Name, &entry, namespaces, explain)
+			if err != nil {
+				return nil, newParseError(fmt.Sprintf("rbacBindings[%d]", index), rbacBinding.Name, err)
+			}
+			matches = append(matches, match)
+		}
+	}
+	return matches, nil
+}
+
+// matchEntry finds the namespaces rb binds in with the steps of
+// parseRoleBinding that choose them
+func (p *Parser) matchEntry(rbacBindingName string, rb *rbacmanagerv1beta1.RoleBinding, namespaces *v1.NamespaceList, explain bool) (EntryNamespaces, error) {
+	roleRef := entryRoleRef(rb)
+	match := EntryNamespaces{RBACBinding: rbacBindingName, Role: roleRef.Kind + " " + roleRef.Name, Namespaces: []string{}}
+	if rb.RoleFrom != nil {
+		match.Role = fmt.Sprintf("Role %v copied from %v", rb.RoleFrom.Name, rb.RoleFrom.Namespace)
+	}
+
+	selected, selector, err := p.selectedNamespaces(rb, namespaces)
+	if err != nil {
+		return match, err
+	}
+	allowed := p.allowedNamespaces(rbacBindingName, &roleRef, selected, namespaces)
+	for _, namespace := range allowed {
+		if lacksGuardLabel(rb, namespaceNamed(namespaces, namespace)) {
+			continue
+		}
+		match.Namespaces = append(match.Namespaces, namespace)
+	}
+	sort.Strings(match.Namespaces)
+
+	if !explain {
+		return match, nil
+	}
+	for _, namespace := range namespaces.Items {
+		explanation := NamespaceExplanation{Namespace: namespace.Name, Matched: stringInSlice(namespace.Name, match.Namespaces)}
+		met := 0
+		if namespace.Name == rb.Namespace {
+			met++
+			explanation.Reasons = append(explanation.Reasons, "is the namespace of the entry")
+		}
+		if stringInSlice(namespace.Name, rb.Namespaces) {
+			met++
+			explanation.Reasons = append(explanation.Reasons, "is listed in namespaces")
+		}
+		for _, reason := range selectorReasons(selector, rb, &namespace) {
+			if reason.met {
+				met++
+			}
+			explanation.Reasons = append(explanation.Reasons, reason.description)
+		}
+		direct := namespace.Name == rb.Namespace || stringInSlice(namespace.Name, rb.Namespaces) || namespaceSelected(selector, rb, &namespace)
+		if stringInSlice(namespace.Name, selected) && !direct {
+			met++
+			explanation.Reasons = append(explanation.Reasons, "is a child of a matched namespace")
+		}
+		if stringInSlice(namespace.Name, selected) && !stringInSlice(namespace.Name, allowed) {
+			explanation.Reasons = append(explanation.Reasons, "is not a namespace this RBAC Definition may bind in")
+		} else if stringInSlice(namespace.Name, allowed) && lacksGuardLabel(rb, &namespace) {
+			explanation.Reasons = append(explanation.Reasons, "lacks the required label "+guardLabel(rb.RequireNamespaceLabel))
+		}
+		if explanation.Matched || met > 0 {
+			match.Explanations = append(match.Explanations, explanation)
+		}
+	}
+	for _, namespace := range match.Namespaces {
+		if !namespaceExists(namespaces, namespace) {
+			match.Explanations = append(match.Explanations, NamespaceExplanation{Namespace: namespace, Matched: true, Reasons: []string{"is created by createIfMissing"}})
+		}
+	}
+	return match, nil
+}
+
+// entryRoleRef returns the role a roleBindings entry binds
+func entryRoleRef(rb *rbacmanagerv1beta1.RoleBinding) rbacv1.RoleRef {
+	switch {
+	case rb.ClusterRole != "":
+		return rbacv1.RoleRef{Kind: "ClusterRole", Name: rb.ClusterRole}
+	case rb.RoleFrom != nil:
+		return rbacv1.RoleRef{Kind: "Role", Name: rb.RoleFrom.Name}
+	default:
+		return rbacv1.RoleRef{Kind: "Role", Name: rb.Role}
+	}
+}
+
+// selectorReason is whether a namespace meets one requirement of the
+// selectors of a roleBindings entry
+type selectorReason struct {
+	met         bool
+	description string
+}
+
+// selectorReasons explains which requirements of the label selector,
+// annotation selector, and owner of rb namespace meets, which
+// namespaceSelected requires all of
+func selectorReasons(selector labels.Selector, rb *rbacmanagerv1beta1.RoleBinding, namespace *v1.Namespace) []selectorReason {
+	reasons := []selectorReason{}
+	if selector != nil {
+		requirements, _ := selector.Requirements()
+		for _, requirement := range requirements {
+			if requirement.Matches(labels.Set(namespace.Labels)) {
+				reasons = append(reasons, selectorReason{met: true, description: fmt.Sprintf("labels match %v", requirement.String())})
+			} else {
+				reasons = append(reasons, selectorReason{description: fmt.Sprintf("labels don't match %v", requirement.String())})
+			}
+		}
+	}
+
+	if rb.NamespaceAnnotationSelector != nil {
+		keys := []string{}
+		for key := range rb.NamespaceAnnotationSelector.MatchAnnotations {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := rb.NamespaceAnnotationSelector.MatchAnnotations[key]
+			actual, ok := namespace.Annotations[key]
+			switch {
+			case ok && actual == value:
+				reasons = append(reasons, selectorReason{met: true, description: fmt.Sprintf("annotation %v is %v", key, value)})
+			case ok:
+				reasons = append(reasons, selectorReason{description: fmt.Sprintf("annotation %v is %v, not %v", key, actual, value)})
+			default:
+				reasons = append(reasons, selectorReason{description: fmt.Sprintf("annotation %v is missing", key)})
+			}
+		}
+		for _, key := range rb.NamespaceAnnotationSelector.Exists {
+			if _, ok := namespace.Annotations[key]; ok {
+				reasons = append(reasons, selectorReason{met: true, description: fmt.Sprintf("annotation %v exists", key)})
+			} else {
+				reasons = append(reasons, selectorReason{description: fmt.Sprintf("annotation %v is missing", key)})
+			}
+		}
+	}
+
+	if owner := rb.NamespaceOwnedBy; owner != nil {
+		description := fmt.Sprintf("%v %v", owner.Kind, owner.Name)
+		if owner.Label != "" {
+			description = fmt.Sprintf("%v through label %v", owner.Name, owner.Label)
+		}
+		if ownedBy(owner, namespace) {
+			reasons = append(reasons, selectorReason{met: true, description: "is owned by " + description})
+		} else {
+			reasons = append(reasons, selectorReason{description: "is not owned by " + description})
+		}
+	}
+	return reasons
+}
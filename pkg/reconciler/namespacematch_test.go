@@ -0,0 +1,87 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestMatchNamespaces(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "web", "tier": "prod"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web-staging", Labels: map[string]string{"team": "web", "tier": "staging"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "api", Labels: map[string]string{"team": "api"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments"}}},
+	)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "teams"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "web-devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "web-devs"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:       "edit",
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "web", "tier": "prod"}},
+		}, {
+			ClusterRole:           "view",
+			Namespaces:            []string{"api", "payments", "search"},
+			RequireNamespaceLabel: &rbacmanagerv1beta1.NamespaceLabelRequirement{Key: "team", Value: "api"},
+		}},
+	}}
+
+	p := Parser{Clientset: client}
+	matches, err := p.MatchNamespaces(rbacDef, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []EntryNamespaces{
+		{RBACBinding: "web-devs", Role: "ClusterRole edit", Namespaces: []string{"web"}},
+		{RBACBinding: "web-devs", Role: "ClusterRole view", Namespaces: []string{"api"}},
+	}, matches)
+
+	// The namespaces agree with those Parse binds in
+	parsed := Parser{Clientset: client}
+	assert.NoError(t, parsed.Parse(rbacDef))
+	bound := []string{}
+	for _, rb := range parsed.parsedRoleBindings {
+		bound = append(bound, rb.RoleRef.Name+"/"+rb.Namespace)
+	}
+	assert.ElementsMatch(t, []string{"edit/web", "view/api"}, bound)
+
+	matches, err = (&Parser{Clientset: client}).MatchNamespaces(rbacDef, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []NamespaceExplanation{{
+		Namespace: "web",
+		Matched:   true,
+		Reasons:   []string{"labels match team=web", "labels match tier=prod"},
+	}, {
+		Namespace: "web-staging",
+		Reasons:   []string{"labels match team=web", "labels don't match tier=prod"},
+	}}, matches[0].Explanations)
+	assert.Equal(t, []NamespaceExplanation{{
+		Namespace: "api",
+		Matched:   true,
+		Reasons:   []string{"is listed in namespaces"},
+	}, {
+		Namespace: "payments",
+		Reasons:   []string{"is listed in namespaces", "lacks the required label team=api"},
+	}}, matches[1].Explanations)
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// missingNamespaces returns the namespaces a roleBindings entry with
+// createIfMissing names that don't exist yet, and requests their creation
+func (p *Parser) missingNamespaces(rb *rbacmanagerv1beta1.RoleBinding, namespaces *v1.NamespaceList) []string {
+	if !rb.CreateIfMissing {
+		return nil
+	}
+
+	names := []string{}
+	if rb.Namespace != "" {
+		names = append(names, rb.Namespace)
+	}
+	names = append(names, rb.Namespaces...)
+
+	missing := []string{}
+	for _, name := range names {
+		if namespaceExists(namespaces, name) || stringInSlice(name, missing) {
+			continue
+		}
+		missing = append(missing, name)
+		// Namespaces a definition may not use are reported along with the
+		// bindings in them instead of being created
+		if p.allowance.allows(name, nil) {
+			p.requestNamespace(name, rb.NamespaceLabels)
+		}
+	}
+	return missing
+}
+
+// requestNamespace adds a namespace to create, merging the labels of every
+// entry that requests it. Namespaces don't get owner references so that they
+// aren't garbage collected along with the RBAC Definition.
+func (p *Parser) requestNamespace(name string, namespaceLabels map[string]string) {
+	for i := range p.parsedNamespaces {
+		if p.parsedNamespaces[i].Name == name {
+			p.parsedNamespaces[i].Labels = labels.Merge(namespaceLabels, p.parsedNamespaces[i].Labels)
+			return
+		}
+	}
+
+	p.parsedNamespaces = append(p.parsedNamespaces, v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      labels.Merge(namespaceLabels, kube.Labels),
+			Annotations: map[string]string{kube.ManagedByAnnotation: p.definitionName},
+		},
+	})
+}
+
+// createNamespaces creates the requested namespaces. Existing namespaces are
+// never updated, and namespaces that are no longer requested are left alone.
+func (r *Reconciler) createNamespaces(requested *[]v1.Namespace) {
+	r.forEach(len(*requested), func(i int) {
+		namespace := &(*requested)[i]
+		if r.heldByPolicy("Namespace", &namespace.ObjectMeta) {
+			return
+		}
+		r.changeLog().Infof("Creating Namespace %v", namespace.Name)
+		err := r.write("Namespace", "create", &namespace.ObjectMeta, func() error {
+			_, err := r.Clientset.CoreV1().Namespaces().Create(r.context(), namespace, kube.CreateOptions)
+			return err
+		})
+		if apierrors.IsAlreadyExists(err) {
+			logrus.Debugf("Namespace %v already exists", namespace.Name)
+		} else if r.deniedByPolicy("Namespace", &namespace.ObjectMeta, err) {
+			return
+		} else if err != nil {
+			logrus.Errorf("Error creating Namespace: %v", err)
+			metrics.ErrorCounter.Inc()
+		} else {
+			metrics.ChangeCounter.WithLabelValues("namespaces", "create").Inc()
+			r.recordChange("Namespace", "create", causeSpecChange)
+			r.event(v1.EventTypeNormal, "NamespaceCreated", "Created Namespace %v", namespace.Name)
+		}
+	})
+}
+
+// DeleteNamespaces deletes the namespaces created for an RBAC Definition,
+// which is done for the DeleteNamespaces deletion policy
+func (r *Reconciler) DeleteNamespaces(rbacDef *rbacmanagerv1beta1.RBACDefinition) (err error) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	cancel := r.startTimeout()
+	defer func() { err = r.stopTimeout(cancel, err) }()
+
+	r.setDefinition(rbacDef)
+
+	namespaces, err := r.Clientset.CoreV1().Namespaces().List(r.context(), kube.ListOptions)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, namespace := range namespaces.Items {
+		if namespace.Annotations[kube.ManagedByAnnotation] != rbacDef.Name || !namespace.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		r.changeLog().Infof("Deleting Namespace %v created for RBACDefinition %v", namespace.Name, rbacDef.Name)
+		err := r.write("Namespace", "delete", &namespace.ObjectMeta, func() error {
+			return r.Clientset.CoreV1().Namespaces().Delete(r.context(), namespace.Name, deleteOptions(&namespace.ObjectMeta))
+		})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			logrus.Errorf("Error deleting Namespace %v: %v", namespace.Name, err)
+			metrics.ErrorCounter.Inc()
+			errs = append(errs, err)
+			continue
+		}
+		metrics.ChangeCounter.WithLabelValues("namespaces", "delete").Inc()
+		r.recordChange("Namespace", "delete", causePruneOrphan)
+		r.event(v1.EventTypeNormal, "NamespaceDeleted", "Deleted Namespace %v", namespace.Name)
+	}
+	return utilerrors.NewAggregate(errs)
+}
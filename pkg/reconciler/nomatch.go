@@ -0,0 +1,106 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// unmatchedSelector is a roleBindings entry whose namespace selectors match
+// no namespace, which is usually a typo in a label
+type unmatchedSelector struct {
+	rbacBinding string
+	description string
+}
+
+func newUnmatchedSelector(rbacBinding string, roleRef *rbacv1.RoleRef, selector labels.Selector, rb *rbacmanagerv1beta1.RoleBinding) unmatchedSelector {
+	selectors := []string{}
+	if selector != nil {
+		selectors = append(selectors, "namespaceSelector "+selector.String())
+	}
+	if rb.NamespaceAnnotationSelector != nil {
+		selectors = append(selectors, "namespaceAnnotationSelector")
+	}
+	if rb.NamespaceOwnedBy != nil {
+		selectors = append(selectors, "namespaceOwnedBy")
+	}
+	return unmatchedSelector{
+		rbacBinding: rbacBinding,
+		description: fmt.Sprintf("%v %v with %v", roleRef.Kind, roleRef.Name, strings.Join(selectors, " and ")),
+	}
+}
+
+func (u unmatchedSelector) String() string {
+	return fmt.Sprintf("rbacBindings entry %v: %v", u.rbacBinding, u.description)
+}
+
+// setNoNamespacesMatchedCondition records the roleBindings entries whose
+// selectors matched no namespace when rbacDef was last parsed in the
+// NoNamespacesMatched condition and the bindings_with_no_match metric, and
+// records an event for each entry that wasn't reported before. It returns
+// whether the condition changed.
+func (r *Reconciler) setNoNamespacesMatchedCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition, unmatched []unmatchedSelector) bool {
+	previous := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionNoNamespacesMatched)
+
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionNoNamespacesMatched,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rbacDef.Generation,
+		Reason:             "NamespacesMatched",
+		Message:            "Every namespace selector matches at least one namespace",
+	}
+
+	entries := []string{}
+	for _, u := range unmatched {
+		entries = append(entries, u.String())
+		if previous != nil && previous.Status == metav1.ConditionTrue && strings.Contains(previous.Message, u.String()) {
+			continue
+		}
+		logrus.Warnf("%v of RBACDefinition %v matches no namespaces", u, rbacDef.Name)
+		r.event(v1.EventTypeWarning, "NoNamespacesMatched", "%v matches no namespaces", u)
+	}
+	if len(entries) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "NoNamespacesMatched"
+		condition.Message = truncateMessage("Selectors match no namespaces: "+strings.Join(entries, "; "), maxReadyMessageLength)
+	}
+	metrics.BindingsWithNoMatch.WithLabelValues(rbacDef.Name).Set(float64(len(entries)))
+
+	changed := previous == nil || previous.Status != condition.Status || previous.Message != condition.Message || previous.ObservedGeneration != condition.ObservedGeneration
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, condition)
+	return changed
+}
+
+// UpdateNoNamespacesMatched updates the NoNamespacesMatched condition of an
+// RBAC Definition after ReconcileNamespaceChange, so that it clears once a
+// namespace is created or labeled to match. It returns whether the status
+// of rbacDef changed and has to be written.
+func (r *Reconciler) UpdateNoNamespacesMatched(rbacDef *rbacmanagerv1beta1.RBACDefinition) bool {
+	if r.unmatchedSelectors == nil || r.Cluster != "" {
+		return false
+	}
+	return r.setNoNamespacesMatchedCondition(rbacDef, *r.unmatchedSelectors)
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestNoNamespacesMatched(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "payments"}}})
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "payments"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:       "edit",
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "payment"}},
+		}, {
+			ClusterRole:       "view",
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+		}},
+	}}
+
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	condition := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionNoNamespacesMatched)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "Selectors match no namespaces: rbacBindings entry devs: ClusterRole edit with namespaceSelector team=payment", condition.Message)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.BindingsWithNoMatch.WithLabelValues("payments")))
+	assert.Equal(t, "Warning NoNamespacesMatched rbacBindings entry devs: ClusterRole edit with namespaceSelector team=payment matches no namespaces", <-recorder.Events)
+
+	// Entries are only reported once
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Empty(t, recorder.Events)
+
+	// A namespace that starts matching clears the condition
+	payment := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "api", Labels: map[string]string{"team": "payment"}}}
+	_, err := client.CoreV1().Namespaces().Create(context.TODO(), payment, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, r.ReconcileNamespaceChange(&rbacDef, payment))
+	assert.True(t, r.UpdateNoNamespacesMatched(&rbacDef))
+
+	condition = meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionNoNamespacesMatched)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.BindingsWithNoMatch.WithLabelValues("payments")))
+	assert.False(t, r.UpdateNoNamespacesMatched(&rbacDef))
+}
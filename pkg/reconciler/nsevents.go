@@ -0,0 +1,105 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// NamespaceEvents records events on namespaces when Role Bindings are
+// created or deleted in them for every RBAC Definition, not only those that
+// set namespaceEvents
+var NamespaceEvents bool
+
+// Namespace events are limited to a burst of namespaceEventBurst per
+// namespace, refilled at namespaceEventQPS
+const (
+	namespaceEventBurst = 20
+	namespaceEventQPS   = 0.1
+)
+
+// maxEventSubjects is how many subjects a namespace event lists by name
+const maxEventSubjects = 5
+
+var namespaceEventLimiters = struct {
+	sync.Mutex
+	limiters map[string]flowcontrol.RateLimiter
+}{limiters: map[string]flowcontrol.RateLimiter{}}
+
+// allowNamespaceEvent reports whether another event may be recorded on
+// namespace without exceeding its rate limit
+func allowNamespaceEvent(namespace string) bool {
+	namespaceEventLimiters.Lock()
+	defer namespaceEventLimiters.Unlock()
+
+	limiter, ok := namespaceEventLimiters.limiters[namespace]
+	if !ok {
+		limiter = flowcontrol.NewTokenBucketRateLimiter(namespaceEventQPS, namespaceEventBurst)
+		namespaceEventLimiters.limiters[namespace] = limiter
+	}
+	return limiter.TryAccept()
+}
+
+// namespaceEvent records that access was granted or revoked through rb on
+// the namespace of rb, if namespace events are enabled
+func (r *Reconciler) namespaceEvent(reason string, rb *rbacv1.RoleBinding) {
+	if r.Recorder == nil || r.rbacDef == nil || r.Cluster != "" {
+		return
+	}
+	if !NamespaceEvents && !r.rbacDef.NamespaceEvents {
+		return
+	}
+	if !allowNamespaceEvent(rb.Namespace) {
+		return
+	}
+
+	action := "granted"
+	preposition := "to"
+	if reason == "AccessRevoked" {
+		action = "revoked"
+		preposition = "from"
+	}
+	namespace := &v1.ObjectReference{Kind: "Namespace", APIVersion: "v1", Name: rb.Namespace}
+	r.Recorder.Eventf(namespace, v1.EventTypeNormal, reason, "RBACDefinition %v %v %v %v %v %v through RoleBinding %v",
+		r.rbacDef.Name, action, rb.RoleRef.Kind, rb.RoleRef.Name, preposition, summarizeSubjects(rb.Subjects), rb.Name)
+}
+
+// summarizeSubjects lists subjects as Kind name, naming at most
+// maxEventSubjects of them
+func summarizeSubjects(subjects []rbacv1.Subject) string {
+	if len(subjects) == 0 {
+		return "no subjects"
+	}
+
+	names := []string{}
+	for i, subject := range subjects {
+		if i == maxEventSubjects {
+			names = append(names, fmt.Sprintf("%d more", len(subjects)-maxEventSubjects))
+			break
+		}
+		name := subject.Name
+		if subject.Namespace != "" {
+			name = subject.Namespace + "/" + subject.Name
+		}
+		names = append(names, subject.Kind+" "+name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestNamespaceEvents(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "payments"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "payments-devs"},
+		}, {
+			Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "ci", Namespace: "build"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "events-payments"}},
+	}}
+
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	for len(recorder.Events) > 0 {
+		assert.NotContains(t, <-recorder.Events, "AccessGranted", "namespace events should be off by default")
+	}
+
+	rbacDef.NamespaceEvents = true
+	rbacDef.RBACBindings[0].Subjects = rbacDef.RBACBindings[0].Subjects[:1]
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, "Normal Pruned Deleted ServiceAccount build/ci: no longer in spec (no longer a subject of any rbacBindings entry)", <-recorder.Events)
+	assert.Equal(t, "Normal AccessRevoked RBACDefinition payments revoked ClusterRole edit from Group payments-devs, ServiceAccount build/ci through RoleBinding payments-devs-edit", <-recorder.Events)
+	assert.Equal(t, "Normal AccessGranted RBACDefinition payments granted ClusterRole edit to Group payments-devs through RoleBinding payments-devs-edit", <-recorder.Events)
+}
+
+func TestNamespaceEventRateLimit(t *testing.T) {
+	allowed := 0
+	for i := 0; i < namespaceEventBurst+5; i++ {
+		if allowNamespaceEvent("events-limited") {
+			allowed++
+		}
+	}
+	assert.Equal(t, namespaceEventBurst, allowed)
+	assert.True(t, allowNamespaceEvent("events-other"), "namespaces should be limited separately")
+}
+
+func TestSummarizeSubjects(t *testing.T) {
+	subjects := []rbacv1.Subject{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		subjects = append(subjects, rbacv1.Subject{Kind: rbacv1.UserKind, Name: name})
+	}
+	assert.Equal(t, "User a, User b, User c, User d, User e, 2 more", summarizeSubjects(subjects))
+	assert.Equal(t, "no subjects", summarizeSubjects(nil))
+}
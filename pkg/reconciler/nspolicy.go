@@ -0,0 +1,225 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// namespaceRestriction allows the namespaces whose name matches one of the
+// shell patterns in names or whose labels match selector
+type namespaceRestriction struct {
+	names    []string
+	selector labels.Selector
+}
+
+func (n namespaceRestriction) allows(name string, namespaceLabels map[string]string) bool {
+	for _, pattern := range n.names {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return n.selector != nil && n.selector.Matches(labels.Set(namespaceLabels))
+}
+
+// NamespacePolicy is a parsed rbacmanagerv1beta1.NamespacePolicy
+type NamespacePolicy struct {
+	definitions labels.Selector
+	allowed     namespaceRestriction
+}
+
+// ParseNamespacePolicies parses the namespace policies of an RBACManagerConfig
+func ParseNamespacePolicies(specs []rbacmanagerv1beta1.NamespacePolicy) ([]NamespacePolicy, error) {
+	policies := []NamespacePolicy{}
+	for i, spec := range specs {
+		definitions, err := metav1.LabelSelectorAsSelector(&spec.DefinitionSelector)
+		if err != nil {
+			return nil, fmt.Errorf("namespacePolicies[%d].definitionSelector: %v", i, err)
+		}
+
+		allowed, err := parseNamespacePatterns(spec.AllowedNamespaces)
+		if err != nil {
+			return nil, fmt.Errorf("namespacePolicies[%d].allowedNamespaces: %v", i, err)
+		}
+		if spec.AllowedNamespaceSelector != nil {
+			allowed.selector, err = metav1.LabelSelectorAsSelector(spec.AllowedNamespaceSelector)
+			if err != nil {
+				return nil, fmt.Errorf("namespacePolicies[%d].allowedNamespaceSelector: %v", i, err)
+			}
+		}
+
+		policies = append(policies, NamespacePolicy{definitions: definitions, allowed: allowed})
+	}
+	return policies, nil
+}
+
+// parseNamespacePatterns rejects malformed patterns now instead of never
+// matching them later
+func parseNamespacePatterns(patterns []string) (namespaceRestriction, error) {
+	restriction := namespaceRestriction{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return restriction, fmt.Errorf("namespace pattern %s is invalid: %v", pattern, err)
+		}
+		restriction.names = append(restriction.names, pattern)
+	}
+	return restriction, nil
+}
+
+// namespaceAllowance holds the namespaces an RBAC Definition may create Role
+// Bindings in. A nil namespaceAllowance allows every namespace.
+type namespaceAllowance struct {
+	// policies are those selecting the definition, any of which may allow
+	// a namespace
+	policies []namespaceRestriction
+	// annotation narrows the namespaces further if it is set
+	annotation *namespaceRestriction
+}
+
+// namespaceAllowanceFor determines the namespaces rbacDef may use from the
+// current namespace policies and its allowed namespaces annotation
+func namespaceAllowanceFor(rbacDef *rbacmanagerv1beta1.RBACDefinition) (*namespaceAllowance, error) {
+	allowance := &namespaceAllowance{}
+	for _, policy := range CurrentOptions().NamespacePolicies {
+		if policy.definitions.Matches(labels.Set(rbacDef.Labels)) {
+			allowance.policies = append(allowance.policies, policy.allowed)
+		}
+	}
+
+	if value, ok := rbacDef.Annotations[kube.AllowedNamespacesAnnotation]; ok {
+		restriction, err := parseNamespacePatterns(strings.Split(value, ","))
+		if err != nil {
+			return nil, &ParseError{Path: "metadata.annotations", Reason: fmt.Sprintf("%s: %v", kube.AllowedNamespacesAnnotation, err)}
+		}
+		allowance.annotation = &restriction
+	}
+
+	if len(allowance.policies) == 0 && allowance.annotation == nil {
+		return nil, nil
+	}
+	return allowance, nil
+}
+
+// allows reports whether a namespace with the given name and labels may be
+// used, which never have labels if the namespace doesn't exist yet
+func (a *namespaceAllowance) allows(name string, namespaceLabels map[string]string) bool {
+	if a == nil {
+		return true
+	}
+	if a.annotation != nil && !a.annotation.allows(name, namespaceLabels) {
+		return false
+	}
+	if len(a.policies) == 0 {
+		return true
+	}
+	for _, policy := range a.policies {
+		if policy.allows(name, namespaceLabels) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimmedNamespaces is a binding request of a roleBindings or
+// clusterRoleBindings entry left out because the RBAC Definition may not use
+// the namespaces it targets
+type trimmedNamespaces struct {
+	rbacBinding string
+	roleRef     string
+	// namespaces is empty for Cluster Role Bindings, which apply to all
+	namespaces []string
+}
+
+func (t trimmedNamespaces) String() string {
+	if len(t.namespaces) == 0 {
+		return fmt.Sprintf("rbacBindings entry %v: %v cluster wide", t.rbacBinding, t.roleRef)
+	}
+	return fmt.Sprintf("rbacBindings entry %v: %v in %v", t.rbacBinding, t.roleRef, strings.Join(t.namespaces, ", "))
+}
+
+// allowedNamespaces returns the namespaces of targets the RBAC Definition
+// being parsed may use, and records the others
+func (p *Parser) allowedNamespaces(rbacBindingName string, roleRef *rbacv1.RoleRef, targets []string, namespaces *v1.NamespaceList) []string {
+	if p.allowance == nil {
+		return targets
+	}
+
+	allowed := []string{}
+	trimmed := []string{}
+	for _, name := range targets {
+		var namespaceLabels map[string]string
+		if namespace := namespaceNamed(namespaces, name); namespace != nil {
+			namespaceLabels = namespace.Labels
+		}
+		if p.allowance.allows(name, namespaceLabels) {
+			allowed = append(allowed, name)
+		} else if !stringInSlice(name, trimmed) {
+			trimmed = append(trimmed, name)
+		}
+	}
+
+	if len(trimmed) > 0 {
+		p.trimmedNamespaces = append(p.trimmedNamespaces, trimmedNamespaces{rbacBinding: rbacBindingName, roleRef: roleRef.Kind + " " + roleRef.Name, namespaces: trimmed})
+	}
+	return allowed
+}
+
+// setNamespacesTrimmedCondition records the bindings left out because the
+// RBAC Definition may not use their namespaces in the NamespacesTrimmed
+// condition, and records an event for each that wasn't reported before
+func (r *Reconciler) setNamespacesTrimmedCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition, trimmed []trimmedNamespaces) {
+	previous := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionNamespacesTrimmed)
+
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionNamespacesTrimmed,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rbacDef.Generation,
+		Reason:             "NamespacesAllowed",
+		Message:            "Every targeted namespace is allowed",
+	}
+
+	entries := []string{}
+	for _, t := range trimmed {
+		entries = append(entries, t.String())
+		if previous != nil && previous.Status == metav1.ConditionTrue && strings.Contains(previous.Message, t.String()) {
+			continue
+		}
+		logrus.Warnf("%v of RBACDefinition %v is not allowed by namespace policies", t, rbacDef.Name)
+		r.event(v1.EventTypeWarning, "NamespacesTrimmed", "%v is not allowed by namespace policies", t)
+	}
+	if len(entries) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "NamespacesTrimmed"
+		condition.Message = truncateMessage("Bindings not allowed by namespace policies: "+strings.Join(entries, "; "), maxReadyMessageLength)
+	}
+
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, condition)
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+func TestNamespacePolicies(t *testing.T) {
+	defer currentOptions.Store((*Options)(nil))
+	options, err := OptionsFromConfig(&rbacmanagerv1beta1.RBACManagerConfigSpec{
+		NamespacePolicies: []rbacmanagerv1beta1.NamespacePolicy{{
+			// Definitions without a tenant label may not bind anything
+			DefinitionSelector: metav1.LabelSelector{},
+		}, {
+			DefinitionSelector:       metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "a"}},
+			AllowedNamespaces:        []string{"team-a-*"},
+			AllowedNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "a"}},
+		}},
+	})
+	assert.NoError(t, err)
+	SetOptions(options)
+
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a-web"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shared", Labels: map[string]string{"tenant": "a"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b-web"}},
+	)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "tenant-a"
+	rbacDef.Labels = map[string]string{"tenant": "a"}
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "devs",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+		RoleBindings:        []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespaces: []string{"team-a-web", "shared", "team-b-web"}}},
+	}}
+
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	rbs, err := client.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	namespaces := []string{}
+	for _, rb := range rbs.Items {
+		namespaces = append(namespaces, rb.Namespace)
+	}
+	assert.ElementsMatch(t, []string{"team-a-web", "shared"}, namespaces)
+
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, crbs.Items, "restricted definitions may not bind cluster wide")
+
+	condition := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionNamespacesTrimmed)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "Bindings not allowed by namespace policies: rbacBindings entry devs: ClusterRole view cluster wide; rbacBindings entry devs: ClusterRole edit in team-b-web", condition.Message)
+	assert.Len(t, recorder.Events, 2)
+
+	// The annotation narrows the namespaces further
+	rbacDef.Annotations = map[string]string{kube.AllowedNamespacesAnnotation: "team-a-web"}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	rbs, err = client.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, rbs.Items, 1) {
+		assert.Equal(t, "team-a-web", rbs.Items[0].Namespace)
+	}
+
+	// Removing the tenant label leaves only the policy that allows nothing
+	rbacDef.Labels = nil
+	rbacDef.Annotations = nil
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	rbs, err = client.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, rbs.Items)
+}
+
+func TestParseNamespacePolicies(t *testing.T) {
+	_, err := ParseNamespacePolicies([]rbacmanagerv1beta1.NamespacePolicy{{AllowedNamespaces: []string{"team-["}}})
+	assert.EqualError(t, err, "namespacePolicies[0].allowedNamespaces: namespace pattern team-[ is invalid: syntax error in pattern")
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Annotations = map[string]string{kube.AllowedNamespacesAnnotation: "team-["}
+	_, err = namespaceAllowanceFor(&rbacDef)
+	assert.EqualError(t, err, "metadata: annotations: rbacmanager.reactiveops.io/allowed-namespaces: namespace pattern team-[ is invalid: syntax error in pattern")
+}
@@ -0,0 +1,164 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// DefaultSyncInterval is how often every RBAC Definition is reconciled even
+// if nothing changed. Zero disables periodic resyncs.
+var DefaultSyncInterval time.Duration
+
+// Options are the settings that can be replaced while RBAC Manager runs
+type Options struct {
+	Parallelism       int
+	ForbiddenSubjects []SubjectPattern
+	MaxImportDepth    int
+	MaxPrunes         int
+	SubjectPatchLimit int
+	SyncInterval      time.Duration
+	NamespacePolicies []NamespacePolicy
+	ChildLabels       map[string]string
+	ChildAnnotations  map[string]string
+}
+
+var currentOptions atomic.Value
+
+// DefaultOptions returns the options set by flags through the package
+// variables DefaultParallelism, ForbiddenSubjects, MaxImportDepth,
+// MaxPrunes, SubjectPatchLimit, DefaultSyncInterval, ChildLabels, and
+// ChildAnnotations
+func DefaultOptions() Options {
+	return Options{
+		Parallelism:       DefaultParallelism,
+		ForbiddenSubjects: ForbiddenSubjects,
+		MaxImportDepth:    MaxImportDepth,
+		MaxPrunes:         MaxPrunes,
+		SubjectPatchLimit: SubjectPatchLimit,
+		SyncInterval:      DefaultSyncInterval,
+		ChildLabels:       ChildLabels,
+		ChildAnnotations:  ChildAnnotations,
+	}
+}
+
+// CurrentOptions returns the options last passed to SetOptions, or
+// DefaultOptions if SetOptions was never called
+func CurrentOptions() Options {
+	if options, _ := currentOptions.Load().(*Options); options != nil {
+		return *options
+	}
+	return DefaultOptions()
+}
+
+// SetOptions replaces the options used by reconciles that start afterwards
+func SetOptions(options Options) {
+	currentOptions.Store(&options)
+}
+
+// OptionsFromConfig returns DefaultOptions with the fields set in spec replaced
+func OptionsFromConfig(spec *rbacmanagerv1beta1.RBACManagerConfigSpec) (Options, error) {
+	options := DefaultOptions()
+
+	if spec.SyncInterval != nil {
+		if spec.SyncInterval.Duration < 0 {
+			return options, errors.New("syncInterval must not be negative")
+		}
+		options.SyncInterval = spec.SyncInterval.Duration
+	}
+
+	if spec.Parallelism != nil {
+		if *spec.Parallelism < 1 {
+			return options, fmt.Errorf("parallelism must be at least 1, got %d", *spec.Parallelism)
+		}
+		options.Parallelism = *spec.Parallelism
+	}
+
+	if spec.ForbiddenSubjects != nil {
+		patterns, err := ParseSubjectPatterns(strings.Join(spec.ForbiddenSubjects, ","))
+		if err != nil {
+			return options, fmt.Errorf("forbiddenSubjects: %v", err)
+		}
+		options.ForbiddenSubjects = patterns
+	}
+
+	if spec.MaxImportDepth != nil {
+		if *spec.MaxImportDepth < 1 {
+			return options, fmt.Errorf("maxImportDepth must be at least 1, got %d", *spec.MaxImportDepth)
+		}
+		options.MaxImportDepth = *spec.MaxImportDepth
+	}
+
+	if spec.MaxPrunes != nil {
+		if *spec.MaxPrunes < 0 {
+			return options, fmt.Errorf("maxPrunes must not be negative, got %d", *spec.MaxPrunes)
+		}
+		options.MaxPrunes = *spec.MaxPrunes
+	}
+
+	if spec.SubjectPatchLimit != nil {
+		if *spec.SubjectPatchLimit < 0 {
+			return options, fmt.Errorf("subjectPatchLimit must not be negative, got %d", *spec.SubjectPatchLimit)
+		}
+		options.SubjectPatchLimit = *spec.SubjectPatchLimit
+	}
+
+	if spec.NamespacePolicies != nil {
+		policies, err := ParseNamespacePolicies(spec.NamespacePolicies)
+		if err != nil {
+			return options, err
+		}
+		options.NamespacePolicies = policies
+	}
+
+	if spec.ChildLabels != nil || spec.ChildAnnotations != nil {
+		if spec.ChildLabels != nil {
+			options.ChildLabels = spec.ChildLabels
+		}
+		if spec.ChildAnnotations != nil {
+			options.ChildAnnotations = spec.ChildAnnotations
+		}
+		err := ValidateChildMetadata(options.ChildLabels, options.ChildAnnotations)
+		if err != nil {
+			return options, err
+		}
+	}
+
+	return options, nil
+}
+
+// EffectiveConfig describes options for the status of an RBACManagerConfig
+func (o Options) EffectiveConfig() *rbacmanagerv1beta1.EffectiveConfig {
+	effective := &rbacmanagerv1beta1.EffectiveConfig{
+		SyncInterval:      o.SyncInterval.String(),
+		Parallelism:       o.Parallelism,
+		MaxImportDepth:    o.MaxImportDepth,
+		MaxPrunes:         o.MaxPrunes,
+		SubjectPatchLimit: o.SubjectPatchLimit,
+		NamespacePolicies: len(o.NamespacePolicies),
+		ChildLabels:       o.ChildLabels,
+		ChildAnnotations:  o.ChildAnnotations,
+	}
+	for _, pattern := range o.ForbiddenSubjects {
+		effective.ForbiddenSubjects = append(effective.ForbiddenSubjects, pattern.String())
+	}
+	return effective
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestOptionsFromConfig(t *testing.T) {
+	defaults := DefaultOptions()
+
+	options, err := OptionsFromConfig(&rbacmanagerv1beta1.RBACManagerConfigSpec{})
+	assert.NoError(t, err)
+	assert.Equal(t, defaults, options, "Unset fields should keep the flag values")
+
+	parallelism := 3
+	options, err = OptionsFromConfig(&rbacmanagerv1beta1.RBACManagerConfigSpec{
+		SyncInterval:      &metav1.Duration{Duration: time.Hour},
+		Parallelism:       &parallelism,
+		ForbiddenSubjects: []string{"User:mallory", "ServiceAccount:ci/*"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, options.SyncInterval)
+	assert.Equal(t, 3, options.Parallelism)
+	assert.Equal(t, defaults.MaxImportDepth, options.MaxImportDepth)
+	assert.Equal(t, &rbacmanagerv1beta1.EffectiveConfig{
+		SyncInterval:      "1h0m0s",
+		Parallelism:       3,
+		ForbiddenSubjects: []string{"User:mallory", "ServiceAccount:ci/*"},
+		MaxImportDepth:    defaults.MaxImportDepth,
+		SubjectPatchLimit: defaults.SubjectPatchLimit,
+	}, options.EffectiveConfig())
+
+	zero := 0
+	_, err = OptionsFromConfig(&rbacmanagerv1beta1.RBACManagerConfigSpec{Parallelism: &zero})
+	assert.EqualError(t, err, "parallelism must be at least 1, got 0")
+	_, err = OptionsFromConfig(&rbacmanagerv1beta1.RBACManagerConfigSpec{MaxImportDepth: &zero})
+	assert.EqualError(t, err, "maxImportDepth must be at least 1, got 0")
+	_, err = OptionsFromConfig(&rbacmanagerv1beta1.RBACManagerConfigSpec{ForbiddenSubjects: []string{"Robot:r2d2"}})
+	assert.EqualError(t, err, "forbiddenSubjects: subject pattern Robot:r2d2 must have kind User, Group, or ServiceAccount")
+}
+
+func TestSetOptions(t *testing.T) {
+	defer currentOptions.Store((*Options)(nil))
+
+	mallory := rbacv1.Subject{Kind: rbacv1.UserKind, Name: "mallory"}
+	assert.False(t, isForbiddenSubject(&mallory))
+
+	options := DefaultOptions()
+	options.ForbiddenSubjects, _ = ParseSubjectPatterns("User:mallory")
+	SetOptions(options)
+	assert.True(t, isForbiddenSubject(&mallory), "Reconciles should pick up replaced options")
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"sort"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// subjectLess orders subjects by kind, namespace, name, and apiGroup
+func subjectLess(a, b *rbacv1.Subject) bool {
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.APIGroup < b.APIGroup
+}
+
+// sortSubjects sorts subjects in place with subjectLess
+func sortSubjects(subjects []rbacv1.Subject) {
+	sort.SliceStable(subjects, func(i, j int) bool { return subjectLess(&subjects[i], &subjects[j]) })
+}
+
+// sortedSubjects returns a sorted copy of subjects with the apiGroups the API
+// server defaults, for comparisons that ignore the order of subjects
+func sortedSubjects(subjects []rbacv1.Subject) []rbacv1.Subject {
+	sorted := make([]rbacv1.Subject, 0, len(subjects))
+	for _, subject := range subjects {
+		sorted = append(sorted, normalizeSubject(subject))
+	}
+	sortSubjects(sorted)
+	return sorted
+}
+
+// sortParsed sorts the subjects of every generated binding and the generated
+// resources themselves, so that the result of parsing an RBAC Definition
+// doesn't depend on the order of its entries
+func (p *Parser) sortParsed() {
+	for i := range p.parsedClusterRoleBindings {
+		sortSubjects(p.parsedClusterRoleBindings[i].Subjects)
+	}
+	for i := range p.parsedRoleBindings {
+		sortSubjects(p.parsedRoleBindings[i].Subjects)
+	}
+
+	sort.SliceStable(p.parsedServiceAccounts, func(i, j int) bool {
+		return objectKey("ServiceAccount", &p.parsedServiceAccounts[i].ObjectMeta) < objectKey("ServiceAccount", &p.parsedServiceAccounts[j].ObjectMeta)
+	})
+	sort.SliceStable(p.parsedClusterRoleBindings, func(i, j int) bool {
+		return p.parsedClusterRoleBindings[i].Name < p.parsedClusterRoleBindings[j].Name
+	})
+	sort.SliceStable(p.parsedRoleBindings, func(i, j int) bool {
+		return objectKey("RoleBinding", &p.parsedRoleBindings[i].ObjectMeta) < objectKey("RoleBinding", &p.parsedRoleBindings[j].ObjectMeta)
+	})
+	sort.SliceStable(p.parsedRoles, func(i, j int) bool {
+		return objectKey("Role", &p.parsedRoles[i].ObjectMeta) < objectKey("Role", &p.parsedRoles[j].ObjectMeta)
+	})
+	sort.SliceStable(p.parsedNamespaces, func(i, j int) bool {
+		return p.parsedNamespaces[i].Name < p.parsedNamespaces[j].Name
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// shuffleEntries returns a copy of rbacDef with its rbacBindings, subjects,
+// role binding entries, and namespace lists in random order
+func shuffleEntries(rng *rand.Rand, rbacDef rbacmanagerv1beta1.RBACDefinition) rbacmanagerv1beta1.RBACDefinition {
+	shuffled := *rbacDef.DeepCopy()
+	rng.Shuffle(len(shuffled.RBACBindings), func(i, j int) {
+		shuffled.RBACBindings[i], shuffled.RBACBindings[j] = shuffled.RBACBindings[j], shuffled.RBACBindings[i]
+	})
+	for i := range shuffled.RBACBindings {
+		rbacBinding := &shuffled.RBACBindings[i]
+		rng.Shuffle(len(rbacBinding.Subjects), func(i, j int) {
+			rbacBinding.Subjects[i], rbacBinding.Subjects[j] = rbacBinding.Subjects[j], rbacBinding.Subjects[i]
+		})
+		rng.Shuffle(len(rbacBinding.ClusterRoleBindings), func(i, j int) {
+			rbacBinding.ClusterRoleBindings[i], rbacBinding.ClusterRoleBindings[j] = rbacBinding.ClusterRoleBindings[j], rbacBinding.ClusterRoleBindings[i]
+		})
+		rng.Shuffle(len(rbacBinding.RoleBindings), func(i, j int) {
+			rbacBinding.RoleBindings[i], rbacBinding.RoleBindings[j] = rbacBinding.RoleBindings[j], rbacBinding.RoleBindings[i]
+		})
+		for j := range rbacBinding.RoleBindings {
+			namespaces := rbacBinding.RoleBindings[j].Namespaces
+			rng.Shuffle(len(namespaces), func(i, j int) { namespaces[i], namespaces[j] = namespaces[j], namespaces[i] })
+		}
+	}
+	return shuffled
+}
+
+func TestParseIgnoresEntryOrder(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "dev"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "api", Labels: map[string]string{"team": "dev"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "db"}},
+	)
+
+	subjects := []rbacmanagerv1beta1.Subject{
+		{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}},
+		{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "ann"}},
+		{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "devs"}},
+		{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "ci", Namespace: "web"}},
+		{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "ci", Namespace: "api"}},
+	}
+	for _, merge := range []bool{false, true} {
+		rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+		rbacDef.Name = "devs"
+		rbacDef.MergeBindings = merge
+		rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+			Name:                "team",
+			Subjects:            subjects,
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}, {ClusterRole: "monitoring"}},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{
+				{ClusterRole: "edit", NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "dev"}}},
+				{ClusterRole: "view", Namespaces: []string{"db", "web", "api"}},
+			},
+		}, {
+			Name:                "oncall",
+			Subjects:            subjects[:3],
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+			RoleBindings:        []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "admin", Namespaces: []string{"api", "db"}}},
+		}}
+
+		parse := func(rbacDef rbacmanagerv1beta1.RBACDefinition) Parser {
+			p := Parser{Clientset: client, ownerRefs: rbacDefOwnerRefs(&rbacDef)}
+			assert.NoError(t, p.Parse(rbacDef))
+			return p
+		}
+		expected := parse(rbacDef)
+
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < 50; i++ {
+			p := parse(shuffleEntries(rng, rbacDef))
+			assert.Equal(t, expected.parsedServiceAccounts, p.parsedServiceAccounts)
+			assert.Equal(t, expected.parsedClusterRoleBindings, p.parsedClusterRoleBindings)
+			assert.Equal(t, expected.parsedRoleBindings, p.parsedRoleBindings)
+		}
+	}
+}
+
+func TestSubjectsMatchIgnoresOrder(t *testing.T) {
+	existing := []rbacv1.Subject{
+		{Kind: rbacv1.UserKind, Name: "joe"},
+		{Kind: rbacv1.ServiceAccountKind, Name: "ci", Namespace: "web"},
+	}
+	requested := []rbacv1.Subject{
+		{Kind: rbacv1.ServiceAccountKind, Name: "ci", Namespace: "web"},
+		{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "joe"},
+	}
+	assert.True(t, subjectsMatch(&existing, &requested))
+	assert.Equal(t, bindingSpec(rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"}, existing), bindingSpec(rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"}, requested))
+
+	requested[1].Name = "ann"
+	assert.False(t, subjectsMatch(&existing, &requested))
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// Orphan releases every resource owned by an RBAC Definition by removing the
+// owner references and labels rbac-manager uses to track them, leaving the
+// resources in place when the RBAC Definition is deleted
+func (r *Reconciler) Orphan(rbacDef *rbacmanagerv1beta1.RBACDefinition) (err error) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	cancel := r.startTimeout()
+	defer func() { err = r.stopTimeout(cancel, err) }()
+
+	logrus.Infof("Orphaning resources owned by RBACDefinition %v", rbacDef.Name)
+
+	r.setDefinition(rbacDef)
+
+	errs := []error{}
+
+	serviceAccounts, err := listAllServiceAccounts(r.context(), r.Clientset, kube.ListOptions)
+	if err != nil {
+		return err
+	}
+	for _, sa := range serviceAccounts.Items {
+		if !r.orphanObjectMeta(&sa.ObjectMeta) {
+			continue
+		}
+		_, err := r.Clientset.CoreV1().ServiceAccounts(sa.Namespace).Update(r.context(), &sa, kube.UpdateOptions)
+		errs = append(errs, r.recordOrphan("ServiceAccount", "serviceaccounts", &sa.ObjectMeta, err))
+	}
+
+	clusterRoleBindings, err := listAllClusterRoleBindings(r.context(), r.Clientset, kube.ListOptions)
+	if err != nil {
+		return err
+	}
+	for _, crb := range clusterRoleBindings.Items {
+		if !r.orphanObjectMeta(&crb.ObjectMeta) {
+			continue
+		}
+		_, err := kube.RBAC(r.Clientset).ClusterRoleBindings().Update(r.context(), &crb, kube.UpdateOptions)
+		errs = append(errs, r.recordOrphan("ClusterRoleBinding", "clusterrolebindings", &crb.ObjectMeta, err))
+	}
+
+	roleBindings, err := listAllRoleBindings(r.context(), r.Clientset, kube.ListOptions)
+	if err != nil {
+		return err
+	}
+	for _, rb := range roleBindings.Items {
+		if !r.orphanObjectMeta(&rb.ObjectMeta) {
+			continue
+		}
+		_, err := kube.RBAC(r.Clientset).RoleBindings(rb.Namespace).Update(r.context(), &rb, kube.UpdateOptions)
+		errs = append(errs, r.recordOrphan("RoleBinding", "rolebindings", &rb.ObjectMeta, err))
+	}
+
+	roles, err := listAllRoles(r.context(), r.Clientset, kube.ListOptions)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles.Items {
+		if !r.orphanObjectMeta(&role.ObjectMeta) {
+			continue
+		}
+		_, err := kube.RBAC(r.Clientset).Roles(role.Namespace).Update(r.context(), &role, kube.UpdateOptions)
+		errs = append(errs, r.recordOrphan("Role", "roles", &role.ObjectMeta, err))
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// orphanObjectMeta strips the owner reference and labels of the RBAC
+// Definition being reconciled from objectMeta, keeping references to other
+// owners. It returns false if the object isn't owned by it.
+func (r *Reconciler) orphanObjectMeta(objectMeta *metav1.ObjectMeta) bool {
+	if !r.owns(objectMeta) {
+		return false
+	}
+
+	objectMeta.OwnerReferences = withoutOwnerRefs(objectMeta.OwnerReferences, r.definitionOwnerRefs())
+	delete(objectMeta.Labels, kube.LabelKey)
+	return true
+}
+
+func (r *Reconciler) recordOrphan(kind string, resource string, objectMeta *metav1.ObjectMeta, err error) error {
+	name := objectMeta.Name
+	if objectMeta.Namespace != "" {
+		name = objectMeta.Namespace + "/" + objectMeta.Name
+	}
+
+	if err != nil {
+		logrus.Errorf("Error orphaning %v %v: %v", kind, name, err)
+		metrics.ErrorCounter.Inc()
+		return err
+	}
+
+	logrus.Infof("Orphaned %v %v", kind, name)
+	metrics.ChangeCounter.WithLabelValues(resource, "orphan").Inc()
+	r.event(v1.EventTypeNormal, "Orphaned", "Orphaned %v %v", kind, name)
+	return nil
+}
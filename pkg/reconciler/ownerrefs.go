@@ -0,0 +1,140 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// hasOwners reports whether existing points to every owner requested does.
+// Other owners, such as those of an adopted resource, are allowed. The
+// controller and blockOwnerDeletion flags are ignored so that a child whose
+// flags another tool changed is still owned.
+func hasOwners(existing, requested []metav1.OwnerReference) bool {
+	for i := range requested {
+		if ownerRefIndex(existing, &requested[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ownerRefIndex returns the index of the owner reference in ownerRefs that
+// points to the same owner as ownerRef, or -1 if there is none
+func ownerRefIndex(ownerRefs []metav1.OwnerReference, ownerRef *metav1.OwnerReference) int {
+	for i := range ownerRefs {
+		if ownerRefs[i].APIVersion == ownerRef.APIVersion &&
+			ownerRefs[i].Kind == ownerRef.Kind &&
+			ownerRefs[i].Name == ownerRef.Name &&
+			ownerRefs[i].UID == ownerRef.UID {
+			return i
+		}
+	}
+	return -1
+}
+
+// withOwnerRefs returns existing with the owner references of requested in
+// place of those to the same owners, and appended where there are none.
+// References to other owners are kept.
+func withOwnerRefs(existing, requested []metav1.OwnerReference) []metav1.OwnerReference {
+	merged := append([]metav1.OwnerReference{}, existing...)
+	for _, ownerRef := range requested {
+		if i := ownerRefIndex(merged, &ownerRef); i >= 0 {
+			merged[i] = ownerRef
+		} else {
+			merged = append(merged, ownerRef)
+		}
+	}
+	return merged
+}
+
+// withoutOwnerRefs returns existing without the references to the owners of
+// removed
+func withoutOwnerRefs(existing, removed []metav1.OwnerReference) []metav1.OwnerReference {
+	var kept []metav1.OwnerReference
+	for _, ownerRef := range existing {
+		if ownerRefIndex(removed, &ownerRef) < 0 {
+			kept = append(kept, ownerRef)
+		}
+	}
+	return kept
+}
+
+// ownerRefRepair is an owned resource whose owner references lost the
+// controller or blockOwnerDeletion flags RBAC Manager sets
+type ownerRefRepair struct {
+	kind     string
+	existing metav1.Object
+}
+
+// driftedOwnerRefs appends existing to repairs if it is owned by the RBAC
+// Definition being reconciled but its owner reference to it differs from the
+// one RBAC Manager writes
+func (r *Reconciler) driftedOwnerRefs(repairs []ownerRefRepair, kind string, existing metav1.Object) []ownerRefRepair {
+	ownerRefs := existing.GetOwnerReferences()
+	if r.Cluster != "" || !r.owns(existing) || reflect.DeepEqual(withOwnerRefs(ownerRefs, r.definitionOwnerRefs()), ownerRefs) {
+		return repairs
+	}
+	return append(repairs, ownerRefRepair{
+		kind:     kind,
+		existing: existing.(runtime.Object).DeepCopyObject().(metav1.Object),
+	})
+}
+
+// repairOwnerRefs patches the owner reference to the RBAC Definition of
+// existing resources back to the one RBAC Manager writes, so that garbage
+// collection treats them the same as the resources it creates. References to
+// other owners are kept. The patch is conditional on the resource version of
+// existing, so owners added in the meantime aren't lost.
+func (r *Reconciler) repairOwnerRefs(repairs []ownerRefRepair) {
+	r.forEach(len(repairs), func(i int) {
+		repair := repairs[i]
+		name := repair.existing.GetName()
+		if repair.existing.GetNamespace() != "" {
+			name = repair.existing.GetNamespace() + "/" + name
+		}
+		patch, _ := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"resourceVersion": repair.existing.GetResourceVersion(),
+				"ownerReferences": withOwnerRefs(repair.existing.GetOwnerReferences(), r.definitionOwnerRefs()),
+			},
+		})
+		logrus.Warnf("Owner references of %v %v were changed outside of rbac-manager, restoring them", repair.kind, name)
+		resource, err := r.mergePatch(repair.kind, repair.existing, patch)
+		if apierrors.IsNotFound(err) {
+			logrus.Debugf("%v %v was deleted before its owner references could be restored", repair.kind, name)
+			return
+		} else if apierrors.IsConflict(err) {
+			logrus.Debugf("%v %v was changed before its owner references could be restored, the next reconcile retries", repair.kind, name)
+			return
+		} else if err != nil {
+			logrus.Errorf("Error restoring owner references of %v %v: %v", repair.kind, name, err)
+			metrics.ErrorCounter.Inc()
+			return
+		}
+		metrics.ChangeCounter.WithLabelValues(resource, "relabeled").Inc()
+		r.recordChange(repair.kind, "relabeled", causeDrift)
+		r.event(v1.EventTypeWarning, "OwnerReferencesRepaired", "Owner references of %v %v were changed outside of rbac-manager and have been restored", repair.kind, name)
+	})
+}
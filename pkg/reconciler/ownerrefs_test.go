@@ -0,0 +1,165 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestReconcileRepairsOwnerReferenceFlags(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "admins"
+	rbacDef.UID = "admins-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "admins",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "admin"}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, crbs.Items, 1)
+	name := crbs.Items[0].Name
+
+	// Another tool turns off blockOwnerDeletion, which made the binding look
+	// unowned when owner references were compared with DeepEqual
+	unblock := func() {
+		crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		block := false
+		crb.OwnerReferences[0].BlockOwnerDeletion = &block
+		_, err = client.RbacV1().ClusterRoleBindings().Update(context.TODO(), crb, metav1.UpdateOptions{})
+		assert.NoError(t, err)
+	}
+	unblock()
+
+	repairs := testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("clusterrolebindings", "relabeled"))
+	client.ClearActions()
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "clusterrolebindings" {
+			assert.NotContains(t, []string{"create", "delete"}, action.GetVerb(), "the binding should be repaired in place")
+		}
+	}
+
+	crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, rbacDefOwnerRefs(&rbacDef), crb.OwnerReferences)
+	assert.Equal(t, repairs+1, testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("clusterrolebindings", "relabeled")))
+
+	// A binding with changed flags is still pruned with its definition entry
+	unblock()
+	rbacDef.RBACBindings = nil
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	_, err = client.RbacV1().ClusterRoleBindings().Get(context.TODO(), name, metav1.GetOptions{})
+	assert.Error(t, err, "the binding should be deleted")
+}
+
+func TestReconcileKeepsOtherOwners(t *testing.T) {
+	// A Role Binding of another tool, which a ConfigMap owns without
+	// controlling it
+	other := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "team-config", UID: "team-config-uid"}
+	client := fake.NewSimpleClientset(&rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "adopt-devs-edit", Namespace: "web", OwnerReferences: []metav1.OwnerReference{other}},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"},
+	})
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "adopt"
+	rbacDef.UID = "adopt-uid"
+	rbacDef.ConflictPolicy = rbacmanagerv1beta1.ConflictPolicyAdopt
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:         "devs",
+		Subjects:     []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{Namespace: "web", ClusterRole: "edit"}},
+	}}
+
+	ownerRefs := func() []metav1.OwnerReference {
+		rb, err := client.RbacV1().RoleBindings("web").Get(context.TODO(), "adopt-devs-edit", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return rb.OwnerReferences
+	}
+
+	// Adopting adds the owner reference of the RBAC Definition
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	expected := append([]metav1.OwnerReference{other}, rbacDefOwnerRefs(&rbacDef)...)
+	assert.Equal(t, expected, ownerRefs())
+
+	// and the adopted binding is managed from then on
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "rolebindings" {
+			assert.Equal(t, "list", action.GetVerb())
+		}
+	}
+
+	// Repairing the flags of its owner reference keeps the other owner
+	rb, err := client.RbacV1().RoleBindings("web").Get(context.TODO(), "adopt-devs-edit", metav1.GetOptions{})
+	assert.NoError(t, err)
+	block := false
+	rb.OwnerReferences[1].BlockOwnerDeletion = &block
+	_, err = client.RbacV1().RoleBindings("web").Update(context.TODO(), rb, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, expected, ownerRefs())
+
+	// and so does orphaning it
+	assert.NoError(t, r.Orphan(&rbacDef))
+	assert.Equal(t, []metav1.OwnerReference{other}, ownerRefs())
+}
+
+func TestOwnerRefLists(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "admins"
+	rbacDef.UID = "admins-uid"
+	canonical := rbacDefOwnerRefs(&rbacDef)
+	other := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "team-config", UID: "team-config-uid"}
+
+	flipped := rbacDefOwnerRefs(&rbacDef)
+	flipped[0].Controller = nil
+	flipped[0].BlockOwnerDeletion = nil
+	assert.True(t, hasOwners(flipped, canonical))
+	assert.True(t, hasOwners([]metav1.OwnerReference{other, flipped[0]}, canonical))
+
+	recreated := rbacDefOwnerRefs(&rbacDef)
+	recreated[0].UID = "other-uid"
+	assert.False(t, hasOwners(recreated, canonical))
+	assert.False(t, hasOwners([]metav1.OwnerReference{other}, canonical))
+	assert.False(t, hasOwners(nil, canonical))
+
+	// Owner references to the same owner are replaced in place
+	assert.Equal(t, []metav1.OwnerReference{other, canonical[0]}, withOwnerRefs([]metav1.OwnerReference{other, flipped[0]}, canonical))
+	assert.Equal(t, []metav1.OwnerReference{other, canonical[0]}, withOwnerRefs([]metav1.OwnerReference{other}, canonical))
+	assert.Equal(t, []metav1.OwnerReference{other}, withoutOwnerRefs([]metav1.OwnerReference{flipped[0], other}, canonical))
+	assert.Empty(t, withoutOwnerRefs(flipped, canonical))
+}
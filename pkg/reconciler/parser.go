@@ -18,12 +18,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 
 	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
@@ -32,74 +34,142 @@ import (
 
 // Parser parses RBAC Definitions and determines the Kubernetes resources that it specifies
 type Parser struct {
-	Clientset                 kubernetes.Interface
+	Clientset kubernetes.Interface
+	// GetDefinition fetches imported RBAC Definitions, kube.GetRbacDefinition is used when it is nil
+	GetDefinition             func(name string) (rbacmanagerv1beta1.RBACDefinition, error)
+	ctx                       context.Context
 	ownerRefs                 []metav1.OwnerReference
+	definitionName            string
 	parsedClusterRoleBindings []rbacv1.ClusterRoleBinding
 	parsedRoleBindings        []rbacv1.RoleBinding
+	parsedRoles               []rbacv1.Role
+	parsedNamespaces          []v1.Namespace
 	parsedServiceAccounts     []v1.ServiceAccount
+	strippedSubjects          []strippedSubject
+	unknownNamespaceGroups    []unknownNamespaceGroup
+	clusterServiceAccounts    []clusterServiceAccount
+	unmatchedSelectors        []unmatchedSelector
+	missingRoles              []missingRole
+	missingGuardLabels        []missingGuardLabel
+	trimmedNamespaces         []trimmedNamespaces
+	allowance                 *namespaceAllowance
+	serviceAccounts           *v1.ServiceAccountList
+	selectorNamespaces        *v1.NamespaceList
+	selectedServiceAccounts   map[string]bool
+	boundServiceAccounts      map[string]bool
+	entries                   []parsedEntry
+	sourceRoles               map[string]*rbacv1.Role
+	existingRoles             map[string]bool
 }
 
 // Parse determines the desired Kubernetes resources an RBAC Definition refers to
 func (p *Parser) Parse(rbacDef rbacmanagerv1beta1.RBACDefinition) error {
+	rbacDef, err := p.resolveImports(rbacDef)
+	if err != nil {
+		return err
+	}
+
 	if rbacDef.RBACBindings == nil {
 		logrus.Warn("No RBACBindings defined")
 		return nil
 	}
+	p.definitionName = rbacDef.Name
+
+	err = Validate(&rbacDef)
+	if err != nil {
+		return err
+	}
+
+	p.allowance, err = namespaceAllowanceFor(&rbacDef)
+	if err != nil {
+		return err
+	}
 
-	namespaces, err := p.Clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	namespaces, err := listNamespaces(p.context(), p.Clientset)
 	if err != nil {
 		logrus.Debug("Error listing namespaces")
 		return err
 	}
 
-	for _, rbacBinding := range rbacDef.RBACBindings {
+	err = p.loadServiceAccounts(&rbacDef)
+	if err != nil {
+		logrus.Debug("Error listing Service Accounts")
+		return err
+	}
+
+	for index, rbacBinding := range rbacDef.RBACBindings {
 		namePrefix := rdNamePrefix(&rbacDef, &rbacBinding)
+		p.checkServiceAccountGroups(&rbacBinding, namespaces)
+		subjects, ok := p.bindingSubjects(&rbacBinding, &rbacDef.Defaults)
+		p.noteEntry(rbacBinding.Name, namePrefix, ok)
+		if !ok {
+			continue
+		}
+		rbacBinding.Subjects = subjects
 		err := p.parseRBACBinding(rbacBinding, namePrefix, namespaces)
 		if err != nil {
-			return err
+			return newParseError(fmt.Sprintf("rbacBindings[%d]", index), rbacBinding.Name, err)
 		}
 	}
 
+	if rbacDef.MergeBindings {
+		p.mergeParsedBindings()
+	}
+
+	p.sortParsed()
 	return nil
 }
 
 func (p *Parser) parseRBACBinding(rbacBinding rbacmanagerv1beta1.RBACBinding, namePrefix string, namespaces *v1.NamespaceList) error {
-	if len(rbacBinding.Subjects) < 1 {
-		return errors.New("No subjects specified for RBAC Binding: " + namePrefix)
-	}
-
 	for _, requestedSubject := range rbacBinding.Subjects {
-		if requestedSubject.Kind == "ServiceAccount" {
+		if requestedSubject.Kind == "ServiceAccount" && !createsServiceAccount(&requestedSubject) {
+			if p.boundServiceAccounts == nil {
+				p.boundServiceAccounts = map[string]bool{}
+			}
+			p.boundServiceAccounts[objectKey("ServiceAccount", &metav1.ObjectMeta{Name: requestedSubject.Name, Namespace: requestedSubject.Namespace})] = true
+			continue
+		}
+		if requestedSubject.Kind == "ServiceAccount" && !p.selectedServiceAccounts[requestedSubject.Namespace+"/"+requestedSubject.Name] {
 			pullsecrets := []v1.LocalObjectReference{}
 			for _, secret := range requestedSubject.ImagePullSecrets {
 				pullsecrets = append(pullsecrets, v1.LocalObjectReference{Name: secret})
 			}
+			labels, annotations := serviceAccountMetadata(requestedSubject)
 			p.parsedServiceAccounts = append(p.parsedServiceAccounts, v1.ServiceAccount{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:            requestedSubject.Name,
 					Namespace:       requestedSubject.Namespace,
-					OwnerReferences: p.ownerRefs,
-					Labels:          kube.Labels,
+					OwnerReferences: p.objectOwnerRefs(),
+					Labels:          labels,
+					Annotations:     annotations,
 				},
-				ImagePullSecrets: pullsecrets,
+				ImagePullSecrets:             pullsecrets,
+				AutomountServiceAccountToken: requestedSubject.AutomountServiceAccountToken,
 			})
 		}
 	}
 
 	if rbacBinding.ClusterRoleBindings != nil {
-		for _, requestedCRB := range rbacBinding.ClusterRoleBindings {
-			err := p.parseClusterRoleBinding(requestedCRB, rbacBinding.Subjects, namePrefix)
+		for index, requestedCRB := range rbacBinding.ClusterRoleBindings {
+			var err error
+			if requestedCRB.LimitToNamespaceSelector != nil {
+				err = p.parseRoleBinding(limitedRoleBinding(&requestedCRB), rbacBinding.Name, rbacBinding.Subjects, namePrefix, namespaces)
+			} else {
+				err = p.parseClusterRoleBinding(requestedCRB, rbacBinding.Name, rbacBinding.Subjects, namePrefix)
+			}
 			if err != nil {
-				return err
+				return newParseError(fmt.Sprintf("clusterRoleBindings[%d]", index), "", err)
 			}
 		}
 	}
 
 	if rbacBinding.RoleBindings != nil {
-		for _, requestedRB := range rbacBinding.RoleBindings {
-			err := p.parseRoleBinding(requestedRB, rbacBinding.Subjects, namePrefix, namespaces)
-			if err != nil {
-				return err
+		for index, requestedRB := range rbacBinding.RoleBindings {
+			for _, entry := range roleBindingEntries(requestedRB) {
+				err := p.parseRoleBinding(entry, rbacBinding.Name, rbacBinding.Subjects, namePrefix, namespaces)
+				if err != nil {
+					return newParseError(fmt.Sprintf("roleBindings[%d]", index), "", err)
+				}
 			}
 		}
 	}
@@ -107,14 +177,29 @@ func (p *Parser) parseRBACBinding(rbacBinding rbacmanagerv1beta1.RBACBinding, na
 }
 
 func (p *Parser) parseClusterRoleBinding(
-	crb rbacmanagerv1beta1.ClusterRoleBinding, subjects []rbacmanagerv1beta1.Subject, prefix string) error {
+	crb rbacmanagerv1beta1.ClusterRoleBinding, rbacBindingName string, subjects []rbacmanagerv1beta1.Subject, prefix string) error {
 	crbName := fmt.Sprintf("%v-%v", prefix, crb.ClusterRole)
+	if crb.Name != "" {
+		crbName = crb.Name
+	}
 	subs := managerSubjectsToRbacSubjects(subjects)
 
+	// Cluster Role Bindings reach every namespace, including those a
+	// restricted definition may not use
+	if p.allowance != nil {
+		p.trimmedNamespaces = append(p.trimmedNamespaces, trimmedNamespaces{rbacBinding: rbacBindingName, roleRef: "ClusterRole " + crb.ClusterRole})
+		return nil
+	}
+
+	err := p.checkClusterServiceAccounts(rbacBindingName, crb.ClusterRole, subjects)
+	if err != nil {
+		return err
+	}
+
 	p.parsedClusterRoleBindings = append(p.parsedClusterRoleBindings, rbacv1.ClusterRoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            crbName,
-			OwnerReferences: p.ownerRefs,
+			OwnerReferences: p.objectOwnerRefs(),
 			Labels:          kube.Labels,
 		},
 		RoleRef: rbacv1.RoleRef{
@@ -128,15 +213,16 @@ func (p *Parser) parseClusterRoleBinding(
 }
 
 func (p *Parser) parseRoleBinding(
-	rb rbacmanagerv1beta1.RoleBinding, subjects []rbacmanagerv1beta1.Subject, prefix string, namespaces *v1.NamespaceList) error {
+	rb rbacmanagerv1beta1.RoleBinding, rbacBindingName string, subjects []rbacmanagerv1beta1.Subject, prefix string, namespaces *v1.NamespaceList) error {
 
 	objectMeta := metav1.ObjectMeta{
-		OwnerReferences: p.ownerRefs,
+		OwnerReferences: p.objectOwnerRefs(),
 		Labels:          kube.Labels,
 	}
 
 	var requestedRoleName string
 	var roleRef rbacv1.RoleRef
+	var sourceRole *rbacv1.Role
 
 	if rb.ClusterRole != "" {
 		logrus.Debugf("Processing Requested ClusterRole %v <> %v <> %v", rb.ClusterRole, rb.Namespace, rb)
@@ -152,58 +238,240 @@ func (p *Parser) parseRoleBinding(
 			Kind: "Role",
 			Name: rb.Role,
 		}
+	} else if rb.RoleFrom != nil {
+		logrus.Debugf("Processing Requested Role copied from %v/%v <> %v", rb.RoleFrom.Namespace, rb.RoleFrom.Name, rb)
+		var err error
+		sourceRole, err = p.sourceRole(rb.RoleFrom)
+		if err != nil {
+			return &ParseError{Path: "roleFrom", Reason: err.Error()}
+		}
+		requestedRoleName = rb.RoleFrom.Name
+		roleRef = rbacv1.RoleRef{
+			Kind: "Role",
+			Name: rb.RoleFrom.Name,
+		}
 	} else {
-		return errors.New("Invalid role binding, role or clusterRole required")
+		return errors.New("role or clusterRole required")
 	}
 
 	objectMeta.Name = fmt.Sprintf("%v-%v", prefix, requestedRoleName)
+	if rb.Name != "" {
+		objectMeta.Name = rb.Name
+	}
 
-	if rb.NamespaceSelector.MatchLabels != nil || len(rb.NamespaceSelector.MatchExpressions) > 0 {
-		logrus.Debugf("Processing Namespace Selector %v", rb.NamespaceSelector)
+	if rb.Namespace == "" && len(rb.Namespaces) == 0 && isEmptySelector(&rb.NamespaceSelector) && rb.NamespaceAnnotationSelector == nil && rb.NamespaceOwnedBy == nil {
+		return errors.New("namespace, namespaces, namespaceSelector, namespaceAnnotationSelector, or namespaceOwnedBy required")
+	}
 
-		selector, err := metav1.LabelSelectorAsSelector(&rb.NamespaceSelector)
-		if err != nil {
-			logrus.Infof("Error parsing label selector: %s", err.Error())
-			return err
-		}
+	targetNamespaces, selector, err := p.selectedNamespaces(&rb, namespaces)
+	if err != nil {
+		return err
+	}
+
+	if len(targetNamespaces) == 0 && (selector != nil || rb.NamespaceAnnotationSelector != nil || rb.NamespaceOwnedBy != nil) {
+		p.unmatchedSelectors = append(p.unmatchedSelectors, newUnmatchedSelector(rbacBindingName, &roleRef, selector, &rb))
+	}
+	targetNamespaces = p.allowedNamespaces(rbacBindingName, &roleRef, targetNamespaces, namespaces)
 
-		for _, namespace := range namespaces.Items {
-			// Lazy way to marshal map[] of labels in to a Set, which we can then match on.
-			if selector.Matches(labels.Merge(namespace.Labels, namespace.Labels)) {
-				logrus.Debugf("Adding Role Binding With Dynamic Namespace %v", namespace.Name)
+	templated := isTemplate(objectMeta.Name)
+	for _, subject := range subjects {
+		templated = templated || isTemplate(subject.Name)
+	}
 
-				om := objectMeta
-				om.Namespace = namespace.Name
-				subs := managerSubjectsToRbacSubjects(subjects)
+	missingIn := []string{}
+	unguarded := []string{}
+	for _, namespace := range targetNamespaces {
+		// Sensitive bindings also need the consent of the namespace
+		if lacksGuardLabel(&rb, namespaceNamed(namespaces, namespace)) {
+			unguarded = append(unguarded, namespace)
+			continue
+		}
 
-				p.parsedRoleBindings = append(p.parsedRoleBindings, rbacv1.RoleBinding{
-					ObjectMeta: om,
-					RoleRef:    roleRef,
-					Subjects:   subs,
-				})
+		// Bindings to a Role that doesn't exist would grant nothing, so they
+		// are only created once it does
+		if rb.ClusterRole == "" && rb.Role != "" {
+			exists, err := p.roleExists(namespace, rb.Role)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				missingIn = append(missingIn, namespace)
+				continue
 			}
 		}
 
-	} else if rb.Namespace != "" {
-		objectMeta.Namespace = rb.Namespace
+		om := objectMeta
+		om.Namespace = namespace
 		subs := managerSubjectsToRbacSubjects(subjects)
 
+		if templated {
+			ctx := newTemplateContext(namespaceNamed(namespaces, namespace), p.definitionName)
+			var err error
+			om.Name, subs, err = p.renderTemplates(om.Name, subs, rbacBindingName, ctx)
+			if err != nil {
+				return err
+			}
+			if len(subs) == 0 {
+				continue
+			}
+		}
+
 		p.parsedRoleBindings = append(p.parsedRoleBindings, rbacv1.RoleBinding{
-			ObjectMeta: objectMeta,
+			ObjectMeta: om,
 			RoleRef:    roleRef,
 			Subjects:   subs,
 		})
 
-	} else {
-		return errors.New("Invalid role binding, namespace or namespace selector required")
+		if sourceRole != nil {
+			p.copyRole(sourceRole, namespace)
+		}
+	}
+
+	if len(unguarded) > 0 {
+		p.missingGuardLabels = append(p.missingGuardLabels, newMissingGuardLabel(rbacBindingName, &roleRef, rb.RequireNamespaceLabel, unguarded))
+	}
+
+	if len(missingIn) > 0 {
+		if rb.RequireRole {
+			return &ParseError{Path: "role", Reason: fmt.Sprintf("Role %s does not exist in namespaces %s", rb.Role, strings.Join(missingIn, ", "))}
+		}
+		p.missingRoles = append(p.missingRoles, missingRole{rbacBinding: rbacBindingName, role: rb.Role, namespaces: missingIn})
 	}
 
 	return nil
 }
 
+// selectedNamespaces returns the namespaces a roleBindings entry names or
+// selects, including those it creates and their children if it propagates,
+// before they are checked against the namespaces the RBAC Definition may use.
+// It also returns the label selector of the entry, which is nil if it has
+// none.
+func (p *Parser) selectedNamespaces(rb *rbacmanagerv1beta1.RoleBinding, namespaces *v1.NamespaceList) ([]string, labels.Selector, error) {
+	var selector labels.Selector
+	if !isEmptySelector(&rb.NamespaceSelector) {
+		logrus.Debugf("Processing Namespace Selector %v", rb.NamespaceSelector)
+
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(&rb.NamespaceSelector)
+		if err != nil {
+			logrus.Infof("Error parsing label selector: %s", err.Error())
+			return nil, nil, &ParseError{Path: "namespaceSelector", Reason: err.Error()}
+		}
+	}
+
+	targetNamespaces := []string{}
+	if rb.Namespace != "" {
+		targetNamespaces = append(targetNamespaces, rb.Namespace)
+	}
+
+	// Listed namespaces that don't exist yet are skipped here and picked up
+	// by the namespace controller once they are created
+	for _, namespace := range namespaces.Items {
+		if namespace.Name == rb.Namespace {
+			continue
+		}
+		if namespaceSelected(selector, rb, &namespace) || stringInSlice(namespace.Name, rb.Namespaces) {
+			logrus.Debugf("Adding Role Binding With Dynamic Namespace %v", namespace.Name)
+			targetNamespaces = append(targetNamespaces, namespace.Name)
+		}
+	}
+
+	// Namespaces created by the reconciler get their bindings right away
+	for _, namespace := range p.missingNamespaces(rb, namespaces) {
+		if namespace != rb.Namespace {
+			targetNamespaces = append(targetNamespaces, namespace)
+		}
+	}
+
+	if rb.PropagateToChildren {
+		targetNamespaces = append(targetNamespaces, descendantNamespaces(targetNamespaces, namespaces)...)
+	}
+	return targetNamespaces, selector, nil
+}
+
+// renderTemplates renders the name and subjects of a Role Binding for one
+// namespace. Rendered subjects that are forbidden are removed.
+func (p *Parser) renderTemplates(name string, subjects []rbacv1.Subject, rbacBindingName string, ctx *templateContext) (string, []rbacv1.Subject, error) {
+	renderedName, err := renderTemplate(name, ctx)
+	if err != nil {
+		return "", nil, &ParseError{Path: "name", Reason: err.Error()}
+	}
+	if errs := validation.IsDNS1123Subdomain(renderedName); len(errs) > 0 {
+		return "", nil, &ParseError{
+			Path:   "name",
+			Reason: fmt.Sprintf("%s rendered for namespace %s is not a valid name: %s", renderedName, ctx.Namespace, strings.Join(errs, ", ")),
+		}
+	}
+
+	rendered := []rbacv1.Subject{}
+	for _, subject := range subjects {
+		if !isTemplate(subject.Name) {
+			rendered = append(rendered, subject)
+			continue
+		}
+
+		template := subject.Name
+		subject.Name, err = renderTemplate(template, ctx)
+		if err != nil {
+			return "", nil, &ParseError{Path: "subjects", Reason: err.Error()}
+		}
+		if subject.Name == "" {
+			return "", nil, &ParseError{Path: "subjects", Reason: fmt.Sprintf("%s rendered an empty name for namespace %s", template, ctx.Namespace)}
+		}
+
+		// Templates can produce any name, so forbidden subjects are only
+		// known once rendered
+		if isForbiddenSubject(&subject) {
+			p.stripSubject(rbacBindingName, subject)
+			continue
+		}
+		rendered = append(rendered, subject)
+	}
+
+	return renderedName, rendered, nil
+}
+
+// stripSubject records a forbidden subject removed from an rbacBindings
+// entry, once even if it was rendered for several namespaces
+func (p *Parser) stripSubject(rbacBindingName string, subject rbacv1.Subject) {
+	stripped := strippedSubject{rbacBinding: rbacBindingName, subject: subject}
+	for _, s := range p.strippedSubjects {
+		if s == stripped {
+			return
+		}
+	}
+	p.strippedSubjects = append(p.strippedSubjects, stripped)
+}
+
+// namespaceNamed returns the namespace with the given name, or one without
+// labels or annotations if it doesn't exist
+func namespaceNamed(namespaces *v1.NamespaceList, name string) *v1.Namespace {
+	for i := range namespaces.Items {
+		if namespaces.Items[i].Name == name {
+			return &namespaces.Items[i]
+		}
+	}
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+// hasNamespaceSelectors reports whether the resources of rbacDef depend on
+// the namespaces in the cluster or their labels and annotations
 func (p *Parser) hasNamespaceSelectors(rbacDef *rbacmanagerv1beta1.RBACDefinition) bool {
 	for _, rbacBinding := range rbacDef.RBACBindings {
+		for _, subject := range rbacBinding.Subjects {
+			if isTemplate(subject.Name) || isTemplate(subject.Namespace) || subject.NamespaceSelector != nil {
+				return true
+			}
+		}
+		for _, clusterRoleBinding := range rbacBinding.ClusterRoleBindings {
+			if clusterRoleBinding.LimitToNamespaceSelector != nil {
+				return true
+			}
+		}
 		for _, roleBinding := range rbacBinding.RoleBindings {
+			if len(roleBinding.Namespaces) > 0 || roleBinding.NamespaceAnnotationSelector != nil || roleBinding.NamespaceOwnedBy != nil || isTemplate(roleBinding.Name) || roleBinding.PropagateToChildren {
+				return true
+			}
 			if roleBinding.Namespace == "" {
 				// Split these up instead of using || so we can test both paths.
 				if roleBinding.NamespaceSelector.MatchLabels != nil {
@@ -218,37 +486,218 @@ func (p *Parser) hasNamespaceSelectors(rbacDef *rbacmanagerv1beta1.RBACDefinitio
 	return false
 }
 
-func (p *Parser) parseClusterRoleBindings(rbacDef *rbacmanagerv1beta1.RBACDefinition) {
-	for _, rbacBinding := range rbacDef.RBACBindings {
-		for _, clusterRoleBinding := range rbacBinding.ClusterRoleBindings {
-			namePrefix := rdNamePrefix(rbacDef, &rbacBinding)
-			_ = p.parseClusterRoleBinding(clusterRoleBinding, rbacBinding.Subjects, namePrefix)
-		}
+// objectOwnerRefs returns a copy of the owner references of the RBAC
+// Definition being parsed, so that no requested object shares them with
+// another or with the Reconciler
+func (p *Parser) objectOwnerRefs() []metav1.OwnerReference {
+	if len(p.ownerRefs) == 0 {
+		return nil
 	}
+	return append([]metav1.OwnerReference{}, p.ownerRefs...)
 }
 
-func (p *Parser) parseRoleBindings(rbacDef *rbacmanagerv1beta1.RBACDefinition, namespaces *v1.NamespaceList) {
+// affectedByNamespace reports which resources of rbacDef may change when
+// namespace is added, changed, or deleted. Service Accounts only do if one is
+// requested in that namespace or through a template. Roles and Role Bindings
+// do if one is requested in that namespace or rbacDef depends on the
+// namespaces in the cluster.
+func (p *Parser) affectedByNamespace(rbacDef *rbacmanagerv1beta1.RBACDefinition, namespace *v1.Namespace) (serviceAccounts bool, roleBindings bool) {
+	roleBindings = p.hasNamespaceSelectors(rbacDef)
+
 	for _, rbacBinding := range rbacDef.RBACBindings {
+		for _, subject := range defaultSubjects(rbacBinding.Subjects, &rbacDef.Defaults) {
+			if subject.Kind != rbacv1.ServiceAccountKind {
+				continue
+			}
+			if subject.Namespace == namespace.Name || isTemplate(subject.Name) || isTemplate(subject.Namespace) {
+				serviceAccounts = true
+			}
+		}
 		for _, roleBinding := range rbacBinding.RoleBindings {
-			namePrefix := rdNamePrefix(rbacDef, &rbacBinding)
-			_ = p.parseRoleBinding(roleBinding, rbacBinding.Subjects, namePrefix, namespaces)
+			if roleBinding.Namespace == namespace.Name {
+				roleBindings = true
+			}
 		}
 	}
+	return serviceAccounts, roleBindings
+}
+
+// limitedRoleBinding converts a clusterRoleBindings entry restricted by a
+// namespace selector to the equivalent roleBindings entry
+func limitedRoleBinding(crb *rbacmanagerv1beta1.ClusterRoleBinding) rbacmanagerv1beta1.RoleBinding {
+	return rbacmanagerv1beta1.RoleBinding{
+		Name:              crb.Name,
+		ClusterRole:       crb.ClusterRole,
+		NamespaceSelector: *crb.LimitToNamespaceSelector,
+	}
+}
+
+// namespaceSelected reports whether namespace matches the label selector,
+// annotation selector, and owner of a Role Binding entry. All of them must
+// match when several are set.
+func namespaceSelected(selector labels.Selector, rb *rbacmanagerv1beta1.RoleBinding, namespace *v1.Namespace) bool {
+	if selector == nil && rb.NamespaceAnnotationSelector == nil && rb.NamespaceOwnedBy == nil {
+		return false
+	}
+
+	// Lazy way to marshal map[] of labels in to a Set, which we can then match on.
+	if selector != nil && !selector.Matches(labels.Merge(namespace.Labels, namespace.Labels)) {
+		return false
+	}
+
+	if rb.NamespaceAnnotationSelector != nil && !annotationsMatch(rb.NamespaceAnnotationSelector, namespace.Annotations) {
+		return false
+	}
+
+	return rb.NamespaceOwnedBy == nil || ownedBy(rb.NamespaceOwnedBy, namespace)
+}
+
+// ownedBy reports whether namespace was created by owner
+func ownedBy(owner *rbacmanagerv1beta1.NamespaceOwner, namespace *v1.Namespace) bool {
+	if owner.Label != "" {
+		value, ok := namespace.Labels[owner.Label]
+		return ok && value == owner.Name
+	}
+
+	for _, ownerRef := range namespace.OwnerReferences {
+		if ownerRef.Kind == owner.Kind && (owner.Name == "" || ownerRef.Name == owner.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func annotationsMatch(selector *rbacmanagerv1beta1.NamespaceAnnotationSelector, annotations map[string]string) bool {
+	for key, value := range selector.MatchAnnotations {
+		actual, ok := annotations[key]
+		if !ok || actual != value {
+			return false
+		}
+	}
+
+	for _, key := range selector.Exists {
+		if _, ok := annotations[key]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isEmptySelector(selector *metav1.LabelSelector) bool {
+	return selector.MatchLabels == nil && len(selector.MatchExpressions) == 0
 }
 
 func rdNamePrefix(rbacDef *rbacmanagerv1beta1.RBACDefinition, rbacBinding *rbacmanagerv1beta1.RBACBinding) string {
 	return fmt.Sprintf("%v-%v", rbacDef.Name, rbacBinding.Name)
 }
 
+// bindingSubjects returns the subjects of an rbacBindings entry with defaults
+// applied and forbidden subjects removed. It returns false if every subject
+// was forbidden, in which case the entry must not produce any resources.
+func (p *Parser) bindingSubjects(rbacBinding *rbacmanagerv1beta1.RBACBinding, defaults *rbacmanagerv1beta1.Defaults) ([]rbacmanagerv1beta1.Subject, bool) {
+	subjects := []rbacmanagerv1beta1.Subject{}
+	for _, subject := range p.expandSubjects(defaultSubjects(rbacBinding.Subjects, defaults)) {
+		if isForbiddenSubject(&subject.Subject) {
+			p.strippedSubjects = append(p.strippedSubjects, strippedSubject{rbacBinding: rbacBinding.Name, subject: subject.Subject})
+			continue
+		}
+		subjects = append(subjects, subject)
+	}
+
+	return subjects, len(subjects) > 0 || len(rbacBinding.Subjects) == 0
+}
+
+// expandSubjects replaces ServiceAccountSelector subjects with the Service
+// Accounts they select
+func (p *Parser) expandSubjects(subjects []rbacmanagerv1beta1.Subject) []rbacmanagerv1beta1.Subject {
+	var expanded []rbacmanagerv1beta1.Subject
+	for _, subject := range subjects {
+		if subject.Kind == rbacmanagerv1beta1.ServiceAccountSelectorKind {
+			expanded = append(expanded, p.selectServiceAccounts(&subject)...)
+			continue
+		}
+		expanded = append(expanded, subject)
+	}
+	return expanded
+}
+
+// defaultSubjects returns a copy of subjects with any unset fields filled in
+// from defaults and ServiceAccountsInNamespace subjects replaced by their group
+func defaultSubjects(subjects []rbacmanagerv1beta1.Subject, defaults *rbacmanagerv1beta1.Defaults) []rbacmanagerv1beta1.Subject {
+	var defaulted []rbacmanagerv1beta1.Subject
+	for _, sub := range subjects {
+		if sub.Kind == rbacv1.ServiceAccountKind && sub.Namespace == "" {
+			sub.Namespace = defaults.ServiceAccountNamespace
+		}
+		if sub.Kind == rbacv1.ServiceAccountKind && sub.CreateServiceAccount == nil {
+			sub.CreateServiceAccount = defaults.CreateServiceAccount
+		}
+		if sub.Kind == rbacmanagerv1beta1.ServiceAccountsInNamespaceKind {
+			sub = serviceAccountsGroup(sub)
+		}
+		if !sub.RawName {
+			sub.Name = prefixedName(sub.Name, subjectPrefix(sub.Kind, defaults))
+		}
+		defaulted = append(defaulted, sub)
+	}
+	return defaulted
+}
+
+// DefaultUserPrefix and DefaultGroupPrefix are prepended to the names of User
+// and Group subjects of RBAC Definitions that don't set a prefix in their
+// defaults
+var DefaultUserPrefix string
+var DefaultGroupPrefix string
+
+// subjectPrefix returns the prefix of the names of subjects of kind, the one
+// from defaults if it sets one
+func subjectPrefix(kind string, defaults *rbacmanagerv1beta1.Defaults) string {
+	switch kind {
+	case rbacv1.UserKind:
+		if defaults.UserPrefix != "" {
+			return defaults.UserPrefix
+		}
+		return DefaultUserPrefix
+	case rbacv1.GroupKind:
+		if defaults.GroupPrefix != "" {
+			return defaults.GroupPrefix
+		}
+		return DefaultGroupPrefix
+	}
+	return ""
+}
+
+// prefixedName prepends prefix to name unless name already carries it or is
+// one of the system: names Kubernetes reserves for its own identities
+func prefixedName(name string, prefix string) string {
+	if prefix == "" || strings.HasPrefix(name, prefix) || strings.HasPrefix(name, "system:") {
+		return name
+	}
+	return prefix + name
+}
+
 func managerSubjectsToRbacSubjects(subjects []rbacmanagerv1beta1.Subject) []rbacv1.Subject {
 	var subs []rbacv1.Subject
 	for _, sub := range subjects {
-		subs = append(subs, rbacv1.Subject{
+		subs = append(subs, normalizeSubject(rbacv1.Subject{
 			Kind:      sub.Kind,
 			APIGroup:  sub.APIGroup,
 			Name:      sub.Name,
 			Namespace: sub.Namespace,
-		})
+		}))
 	}
 	return subs
 }
+
+// normalizeSubject returns subject with the apiGroup the API server defaults for its kind,
+// rbac.authorization.k8s.io for Users and Groups and none for ServiceAccounts
+func normalizeSubject(subject rbacv1.Subject) rbacv1.Subject {
+	switch subject.Kind {
+	case rbacv1.UserKind, rbacv1.GroupKind:
+		subject.APIGroup = rbacv1.GroupName
+	case rbacv1.ServiceAccountKind:
+		subject.APIGroup = ""
+	}
+	return subject
+}
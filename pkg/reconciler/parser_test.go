@@ -16,6 +16,8 @@ package reconciler
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,6 +27,7 @@ import (
 	"k8s.io/client-go/kubernetes/fake"
 
 	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
 )
 
 func TestParseEmpty(t *testing.T) {
@@ -33,7 +36,7 @@ func TestParseEmpty(t *testing.T) {
 }
 
 func TestParseStandard(t *testing.T) {
-	client := fake.NewSimpleClientset()
+	client := fake.NewSimpleClientset(&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "custom", Namespace: "bots"}})
 	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
 	rbacDef.Name = "rbac-config"
 
@@ -99,11 +102,13 @@ func TestParseStandard(t *testing.T) {
 			Name: "edit",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "joe",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "joe",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "sue",
 		}},
 	}, {
 		ObjectMeta: metav1.ObjectMeta{
@@ -115,11 +120,13 @@ func TestParseStandard(t *testing.T) {
 			Name: "edit",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "joe",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "joe",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "sue",
 		}},
 	}}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{{
 		ObjectMeta: metav1.ObjectMeta{
@@ -164,8 +171,9 @@ func TestParseLabels(t *testing.T) {
 			Name: "edit",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "sue",
 		}},
 	}, {
 		ObjectMeta: metav1.ObjectMeta{
@@ -177,8 +185,9 @@ func TestParseLabels(t *testing.T) {
 			Name: "edit",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "sue",
 		}},
 	}}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
 
@@ -202,8 +211,9 @@ func TestParseLabels(t *testing.T) {
 			Name: "edit",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "sue",
 		}},
 	}}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
 
@@ -226,8 +236,9 @@ func TestParseLabels(t *testing.T) {
 			Name: "edit",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "sue",
 		}},
 	}}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
 
@@ -290,6 +301,890 @@ func TestParseMissingSubjects(t *testing.T) {
 	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
 }
 
+func TestParseServiceAccountNamespaceDefault(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.Defaults.ServiceAccountNamespace = "bots"
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "ci-bot",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.ServiceAccountKind,
+				Name: "ci-bot",
+			},
+		}, {
+			Subject: rbacv1.Subject{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      "deployer",
+				Namespace: "deploy",
+			},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+	}}
+
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{}, []rbacv1.ClusterRoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "rbac-config-ci-bot-view",
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind: "ClusterRole",
+			Name: "view",
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      "ci-bot",
+			Namespace: "bots",
+		}, {
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      "deployer",
+			Namespace: "deploy",
+		}},
+	}}, []corev1.ServiceAccount{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ci-bot",
+			Namespace: "bots",
+		},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "deployer",
+			Namespace: "deploy",
+		},
+	}})
+
+	// The definition itself must not be modified by defaulting
+	assert.Empty(t, rbacDef.RBACBindings[0].Subjects[0].Namespace)
+}
+
+func TestParseSubjectPrefixes(t *testing.T) {
+	defer func(prefix string) { DefaultGroupPrefix = prefix }(DefaultGroupPrefix)
+	DefaultGroupPrefix = "oidc-groups:"
+
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.Defaults.UserPrefix = "oidc:"
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}, {
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "oidc:jane"},
+		}, {
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "break-glass"},
+			RawName: true,
+		}, {
+			Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "devs"},
+		}, {
+			Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "system:authenticated"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+	}}
+
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{}, []rbacv1.ClusterRoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "rbac-config-devs-view",
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind: "ClusterRole",
+			Name: "view",
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "oidc:joe",
+		}, {
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "oidc:jane",
+		}, {
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "break-glass",
+		}, {
+			Kind:     rbacv1.GroupKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "oidc-groups:devs",
+		}, {
+			Kind:     rbacv1.GroupKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "system:authenticated",
+		}},
+	}}, []corev1.ServiceAccount{})
+
+	// The definition itself must not be modified by defaulting
+	assert.Equal(t, "joe", rbacDef.RBACBindings[0].Subjects[0].Name)
+}
+
+func TestParseServiceAccountsInNamespace(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "build", nil)
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "builders",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind:      rbacmanagerv1beta1.ServiceAccountsInNamespaceKind,
+				Namespace: "build",
+			},
+		}, {
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.GroupKind,
+				Name: "system:serviceaccounts:biuld",
+			},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+	}}
+
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{}, []rbacv1.ClusterRoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "rbac-config-builders-view",
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind: "ClusterRole",
+			Name: "view",
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:     rbacv1.GroupKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "system:serviceaccounts:build",
+		}, {
+			Kind:     rbacv1.GroupKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "system:serviceaccounts:biuld",
+		}},
+	}}, []corev1.ServiceAccount{})
+
+	p := Parser{Clientset: client}
+	assert.NoError(t, p.Parse(rbacDef))
+	assert.Equal(t, []unknownNamespaceGroup{{rbacBinding: "builders", group: "system:serviceaccounts:biuld"}}, p.unknownNamespaceGroups,
+		"only groups of missing namespaces should be reported")
+
+	// The namespace of a ServiceAccountsInNamespace subject is checked too
+	rbacDef.RBACBindings[0].Subjects = rbacDef.RBACBindings[0].Subjects[:1]
+	rbacDef.RBACBindings[0].Subjects[0].Namespace = "biuld"
+	p = Parser{Clientset: client}
+	assert.NoError(t, p.Parse(rbacDef))
+	assert.Equal(t, []unknownNamespaceGroup{{rbacBinding: "builders", group: "system:serviceaccounts:biuld", generated: true}}, p.unknownNamespaceGroups)
+}
+
+func TestParseClusterServiceAccounts(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "ci", map[string]string{"ci": "true"})
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "ci",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "deployer", Namespace: "ci"},
+		}, {
+			Subject: rbacv1.Subject{Kind: rbacmanagerv1beta1.ServiceAccountsInNamespaceKind, Namespace: "ci"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}, {
+			ClusterRole:              "edit",
+			LimitToNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"ci": "true"}},
+		}},
+	}}
+
+	p := Parser{Clientset: client}
+	assert.NoError(t, p.Parse(rbacDef))
+	assert.Equal(t, []clusterServiceAccount{{rbacBinding: "ci", serviceAccount: "ci/deployer", clusterRole: "view"}}, p.clusterServiceAccounts,
+		"only Service Accounts bound in every namespace should be reported")
+
+	StrictClusterServiceAccounts = true
+	defer func() { StrictClusterServiceAccounts = false }()
+	p = Parser{Clientset: client}
+	err := p.Parse(rbacDef)
+	var parseErr *ParseError
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "rbacBindings[0].clusterRoleBindings[0]", parseErr.Path)
+	assert.Equal(t, "ServiceAccount ci/deployer may not be bound to ClusterRole view in every namespace, use limitToNamespaceSelector or roleBindings", parseErr.Reason)
+
+	rbacDef.RBACBindings[0].ClusterRoleBindings = rbacDef.RBACBindings[0].ClusterRoleBindings[1:]
+	p = Parser{Clientset: client}
+	assert.NoError(t, p.Parse(rbacDef))
+}
+
+func TestParseServiceAccountSelector(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "tenant-b", map[string]string{"tenants": "true"})
+	createNamespace(t, client, "tenant-a", map[string]string{"tenants": "true"})
+	createNamespace(t, client, "kube-system", nil)
+	for _, sa := range []corev1.ServiceAccount{
+		{ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: "tenant-b", Labels: map[string]string{"tenant-sa": "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: "tenant-a", Labels: map[string]string{"tenant-sa": "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "tenant-a"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: "kube-system", Labels: map[string]string{"tenant-sa": "true"}}},
+	} {
+		_, err := client.CoreV1().ServiceAccounts(sa.Namespace).Create(context.TODO(), &sa, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "tenants",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject:           rbacv1.Subject{Kind: rbacmanagerv1beta1.ServiceAccountSelectorKind},
+			Selector:          &metav1.LabelSelector{MatchLabels: map[string]string{"tenant-sa": "true"}},
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenants": "true"}},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+	}}
+
+	expectedClusterRoleBinding := rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "rbac-config-tenants-view"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.ServiceAccountKind, Name: "tenant", Namespace: "tenant-a"},
+			{Kind: rbacv1.ServiceAccountKind, Name: "tenant", Namespace: "tenant-b"},
+		},
+	}
+	// Selected Service Accounts already exist and must not be managed
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{}, []rbacv1.ClusterRoleBinding{expectedClusterRoleBinding}, []corev1.ServiceAccount{})
+
+	p := Parser{Clientset: client}
+	assert.True(t, p.hasNamespaceSelectors(&rbacDef), "namespace selectors depend on namespace labels")
+
+	assert.NoError(t, client.CoreV1().ServiceAccounts("tenant-b").Delete(context.TODO(), "tenant", metav1.DeleteOptions{}))
+	expectedClusterRoleBinding.Subjects = expectedClusterRoleBinding.Subjects[:1]
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{}, []rbacv1.ClusterRoleBinding{expectedClusterRoleBinding}, []corev1.ServiceAccount{})
+
+	assert.NoError(t, client.CoreV1().ServiceAccounts("tenant-a").Delete(context.TODO(), "tenant", metav1.DeleteOptions{}))
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
+}
+
+func TestParseLimitToNamespaceSelector(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+
+	createNamespace(t, client, "web", map[string]string{"app": "web", "team": "devs"})
+	createNamespace(t, client, "db", map[string]string{"app": "db", "team": "db"})
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.GroupKind,
+				Name: "devs",
+			},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}, {
+			ClusterRole: "edit",
+			LimitToNamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"team": "devs"},
+			},
+		}},
+	}}
+
+	devs := []rbacv1.Subject{{
+		Kind:     rbacv1.GroupKind,
+		APIGroup: rbacv1.GroupName,
+		Name:     "devs",
+	}}
+
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rbac-config-devs-edit",
+			Namespace: "web",
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind: "ClusterRole",
+			Name: "edit",
+		},
+		Subjects: devs,
+	}}, []rbacv1.ClusterRoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "rbac-config-devs-view",
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind: "ClusterRole",
+			Name: "view",
+		},
+		Subjects: devs,
+	}}, []corev1.ServiceAccount{})
+}
+
+func TestParseNamespaceList(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+
+	createNamespace(t, client, "web", map[string]string{"app": "web", "team": "devs"})
+	createNamespace(t, client, "api", map[string]string{"app": "api", "team": "devs"})
+	createNamespace(t, client, "db", map[string]string{"app": "db", "team": "db"})
+	createNamespace(t, client, "queue", map[string]string{"app": "queue", "team": "ops"})
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole: "edit",
+			// web is also matched by the selector and cache doesn't exist yet
+			Namespaces: []string{"web", "db", "cache"},
+			NamespaceSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"team": "devs"},
+			},
+		}},
+	}}
+
+	expectedRoleBinding := func(namespace string) rbacv1.RoleBinding {
+		return rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "rbac-config-devs-edit",
+				Namespace: namespace,
+			},
+			RoleRef: rbacv1.RoleRef{
+				Kind: "ClusterRole",
+				Name: "edit",
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:     rbacv1.UserKind,
+				APIGroup: rbacv1.GroupName,
+				Name:     "joe",
+			}},
+		}
+	}
+
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{
+		expectedRoleBinding("web"),
+		expectedRoleBinding("api"),
+		expectedRoleBinding("db"),
+	}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
+
+	createNamespace(t, client, "cache", map[string]string{})
+
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{
+		expectedRoleBinding("web"),
+		expectedRoleBinding("api"),
+		expectedRoleBinding("db"),
+		expectedRoleBinding("cache"),
+	}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
+
+	p := Parser{Clientset: client}
+	assert.True(t, p.hasNamespaceSelectors(&rbacDef))
+}
+
+func TestParseNamespaceAnnotationSelector(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+
+	createNamespace(t, client, "web", map[string]string{"app": "web"})
+	createNamespace(t, client, "api", map[string]string{"app": "api"})
+	createNamespace(t, client, "db", map[string]string{"app": "db"})
+	annotateNamespace(t, client, "web", map[string]string{"owner": "team-x"})
+	annotateNamespace(t, client, "api", map[string]string{"owner": "team-y"})
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "team-x",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.GroupKind,
+				Name: "team-x",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole: "edit",
+			NamespaceAnnotationSelector: &rbacmanagerv1beta1.NamespaceAnnotationSelector{
+				MatchAnnotations: map[string]string{"owner": "team-x"},
+			},
+		}, {
+			ClusterRole: "view",
+			NamespaceAnnotationSelector: &rbacmanagerv1beta1.NamespaceAnnotationSelector{
+				Exists: []string{"owner"},
+			},
+			NamespaceSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "api"},
+			},
+		}},
+	}}
+
+	expectedRoleBinding := func(clusterRole string, namespace string) rbacv1.RoleBinding {
+		return rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "rbac-config-team-x-" + clusterRole,
+				Namespace: namespace,
+			},
+			RoleRef: rbacv1.RoleRef{
+				Kind: "ClusterRole",
+				Name: clusterRole,
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:     rbacv1.GroupKind,
+				APIGroup: rbacv1.GroupName,
+				Name:     "team-x",
+			}},
+		}
+	}
+
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{
+		expectedRoleBinding("edit", "web"),
+		expectedRoleBinding("view", "api"),
+	}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
+
+	// Changing only annotations moves bindings between namespaces
+	annotateNamespace(t, client, "db", map[string]string{"owner": "team-x"})
+	annotateNamespace(t, client, "api", map[string]string{})
+
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{
+		expectedRoleBinding("edit", "web"),
+		expectedRoleBinding("edit", "db"),
+	}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
+
+	p := Parser{Clientset: client}
+	assert.True(t, p.hasNamespaceSelectors(&rbacDef))
+}
+
+func TestParseNamespaceOwnedBy(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+
+	createNamespace(t, client, "db", map[string]string{})
+	createNamespace(t, client, "vc-ns", map[string]string{"vcluster.loft.sh/managed-by": "team-x"})
+	for name, owner := range map[string]metav1.OwnerReference{
+		"web": {APIVersion: "capsule.clastix.io/v1beta2", Kind: "Tenant", Name: "team-x", UID: "1"},
+		"api": {APIVersion: "capsule.clastix.io/v1beta2", Kind: "Tenant", Name: "team-y", UID: "2"},
+	} {
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, OwnerReferences: []metav1.OwnerReference{owner}}}
+		_, err := client.CoreV1().Namespaces().Create(context.TODO(), namespace, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "team-x",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.GroupKind,
+				Name: "team-x",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:      "edit",
+			NamespaceOwnedBy: &rbacmanagerv1beta1.NamespaceOwner{Kind: "Tenant", Name: "team-x"},
+		}, {
+			ClusterRole:      "view",
+			NamespaceOwnedBy: &rbacmanagerv1beta1.NamespaceOwner{Kind: "Tenant"},
+		}, {
+			ClusterRole:      "admin",
+			NamespaceOwnedBy: &rbacmanagerv1beta1.NamespaceOwner{Label: "vcluster.loft.sh/managed-by", Name: "team-x"},
+		}},
+	}}
+
+	expectedRoleBinding := func(clusterRole string, namespace string) rbacv1.RoleBinding {
+		return rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "rbac-config-team-x-" + clusterRole,
+				Namespace: namespace,
+			},
+			RoleRef: rbacv1.RoleRef{
+				Kind: "ClusterRole",
+				Name: clusterRole,
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:     rbacv1.GroupKind,
+				APIGroup: rbacv1.GroupName,
+				Name:     "team-x",
+			}},
+		}
+	}
+
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{
+		expectedRoleBinding("edit", "web"),
+		expectedRoleBinding("view", "api"),
+		expectedRoleBinding("view", "web"),
+		expectedRoleBinding("admin", "vc-ns"),
+	}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
+
+	p := Parser{Clientset: client}
+	assert.True(t, p.hasNamespaceSelectors(&rbacDef))
+}
+
+func TestParseTemplates(t *testing.T) {
+	defer currentOptions.Store((*Options)(nil))
+
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+
+	createNamespace(t, client, "web", map[string]string{"tier": "frontend", "env": "prod"})
+	createNamespace(t, client, "api", map[string]string{"tier": "backend", "env": "prod"})
+	createNamespace(t, client, "admin", map[string]string{"tier": "backend", "env": "prod"})
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "teams",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "oidc:team-{{ .Namespace }}"},
+		}, {
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Name:        "{{ .RBACDefinition }}-{{ .NamespaceLabels.tier }}-edit",
+			ClusterRole: "edit",
+			NamespaceSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"env": "prod"},
+			},
+		}},
+	}}
+
+	options := DefaultOptions()
+	options.ForbiddenSubjects, _ = ParseSubjectPatterns("Group:oidc:team-admin")
+	SetOptions(options)
+
+	expectedRoleBinding := func(name, namespace string, subjects ...string) rbacv1.RoleBinding {
+		rb := rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"},
+		}
+		for _, subject := range subjects {
+			kind := rbacv1.GroupKind
+			if subject == "joe" {
+				kind = rbacv1.UserKind
+			}
+			rb.Subjects = append(rb.Subjects, rbacv1.Subject{Kind: kind, APIGroup: rbacv1.GroupName, Name: subject})
+		}
+		return rb
+	}
+
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{
+		expectedRoleBinding("rbac-config-frontend-edit", "web", "oidc:team-web", "joe"),
+		expectedRoleBinding("rbac-config-backend-edit", "api", "oidc:team-api", "joe"),
+		expectedRoleBinding("rbac-config-backend-edit", "admin", "joe"),
+	}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
+
+	p := Parser{Clientset: client}
+	assert.True(t, p.hasNamespaceSelectors(&rbacDef), "templates should be re-rendered when namespaces change")
+	assert.NoError(t, p.Parse(rbacDef))
+	assert.Len(t, p.strippedSubjects, 1, "rendered subjects should be checked against forbidden subjects")
+
+	createNamespace(t, client, "jobs", map[string]string{"env": "prod"})
+	p = Parser{Clientset: client}
+	err := p.Parse(rbacDef)
+	if assert.Error(t, err, "namespaces without a label used by a template should fail") {
+		assert.Contains(t, err.Error(), "rbacBindings[0] 'teams': roleBindings[0]: name: ")
+		assert.Contains(t, err.Error(), `map has no entry for key "tier"`)
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	assert.NoError(t, validateTemplate("team-{{ .Namespace }}-{{ .NamespaceAnnotations.owner }}"))
+	assert.EqualError(t, validateTemplate("{{ .Cluster }}"), `template: template:1:3: executing "template" at <.Cluster>: can't evaluate field Cluster in type *reconciler.templateContext`)
+	assert.EqualError(t, validateTemplate("{{ upper .Namespace }}"), `template: template:1: function "upper" not defined`)
+
+	subject := rbacmanagerv1beta1.Subject{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Namespace: "{{ .Namespace }}", Name: "ci"}}
+	assert.EqualError(t, validateSubjectTemplate(&subject), "templates are only supported in the names of User and Group subjects")
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "teams",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "team-{{ .Namespace }}"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0] 'teams': clusterRoleBindings: templated subjects are rendered per namespace and can't be bound cluster wide, use limitToNamespaceSelector")
+
+	rbacDef.RBACBindings[0].ClusterRoleBindings = nil
+	rbacDef.RBACBindings[0].RoleBindings = []rbacmanagerv1beta1.RoleBinding{{Name: "Not_Valid", ClusterRole: "view", Namespace: "web"}}
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].roleBindings[0].name", parseErr.Path)
+}
+
+func TestParseImports(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	definitions := map[string]rbacmanagerv1beta1.RBACDefinition{}
+	getDefinition := func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+		rbacDef, ok := definitions[name]
+		if !ok {
+			return rbacDef, fmt.Errorf("rbacdefinitions %q not found", name)
+		}
+		return rbacDef, nil
+	}
+
+	base := rbacmanagerv1beta1.RBACDefinition{}
+	base.Name = "web-base"
+	base.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "web-developers",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}},
+	}}
+	definitions[base.Name] = base
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "web-prod"
+	rbacDef.Imports = []string{"web-base"}
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "web-developers",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}, {
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "sue"},
+		}},
+	}}
+
+	p := Parser{Clientset: client, GetDefinition: getDefinition}
+	err := p.Parse(rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, []rbacv1.RoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-prod-web-developers-edit",
+			Namespace: "web",
+			Labels:    kube.Labels,
+		},
+		RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "joe"},
+			{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "sue"},
+		},
+	}}, p.parsedRoleBindings)
+
+	// The imported definition is left untouched
+	assert.Len(t, definitions["web-base"].RBACBindings[0].Subjects, 1)
+
+	// Cycles are rejected, even through other imports
+	middle := rbacmanagerv1beta1.RBACDefinition{}
+	middle.Name = "middle"
+	middle.Imports = []string{"web-prod"}
+	definitions[middle.Name] = middle
+	rbacDef.Imports = []string{"web-base", "middle"}
+	definitions[rbacDef.Name] = rbacDef
+
+	p = Parser{Clientset: client, GetDefinition: getDefinition}
+	err = p.Parse(rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "imports[1].imports[0]", parseErr.Path)
+	assert.Equal(t, "import cycle web-prod -> middle -> web-prod", parseErr.Reason)
+
+	rbacDef.Imports = []string{"missing"}
+	err = p.Parse(rbacDef)
+	assert.EqualError(t, err, "imports[0]: cannot import RBACDefinition missing: rbacdefinitions \"missing\" not found")
+
+	// Chains longer than MaxImportDepth are rejected
+	for i := 0; i <= MaxImportDepth; i++ {
+		link := rbacmanagerv1beta1.RBACDefinition{}
+		link.Name = fmt.Sprintf("link-%d", i)
+		link.Imports = []string{fmt.Sprintf("link-%d", i+1)}
+		definitions[link.Name] = link
+	}
+	definitions[fmt.Sprintf("link-%d", MaxImportDepth+1)] = base
+	rbacDef.Imports = []string{"link-0"}
+	err = p.Parse(rbacDef)
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Contains(t, parseErr.Reason, "nested more than")
+}
+
+func TestParseDefinitionServiceAccounts(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	definitions := map[string]rbacmanagerv1beta1.RBACDefinition{}
+	getDefinition := func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+		rbacDef, ok := definitions[name]
+		if !ok {
+			return rbacDef, fmt.Errorf("rbacdefinitions %q not found", name)
+		}
+		return rbacDef, nil
+	}
+
+	bound := false
+	platform := rbacmanagerv1beta1.RBACDefinition{}
+	platform.Name = "platform-services"
+	platform.Defaults.ServiceAccountNamespace = "platform"
+	platform.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "services",
+		Subjects: []rbacmanagerv1beta1.Subject{
+			{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "metrics", Namespace: "monitoring"}},
+			{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "ingress"}},
+			{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "external", Namespace: "platform"}, CreateServiceAccount: &bound},
+			{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}},
+		},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+	definitions[platform.Name] = platform
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "platform-extra"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "secrets",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacmanagerv1beta1.RBACDefinitionServiceAccountsKind, Name: "platform-services"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{Namespace: "vault", ClusterRole: "secret-reader"}},
+	}}
+
+	// Only the Service Accounts the referenced definition creates are bound,
+	// and they aren't created again
+	p := Parser{Clientset: client, GetDefinition: getDefinition}
+	assert.NoError(t, p.Parse(rbacDef))
+	assert.Empty(t, p.parsedServiceAccounts)
+	if assert.Len(t, p.parsedRoleBindings, 1) {
+		assert.Equal(t, []rbacv1.Subject{
+			{Kind: rbacv1.ServiceAccountKind, Name: "metrics", Namespace: "monitoring"},
+			{Kind: rbacv1.ServiceAccountKind, Name: "ingress", Namespace: "platform"},
+		}, p.parsedRoleBindings[0].Subjects)
+	}
+	assert.Equal(t, []string{"platform-services"}, DefinitionDependencies(&rbacDef))
+
+	// Cycles are rejected, even through imports
+	cycle := platform
+	cycle.Imports = []string{"platform-extra"}
+	definitions[cycle.Name] = cycle
+	definitions[rbacDef.Name] = rbacDef
+	p = Parser{Clientset: client, GetDefinition: getDefinition}
+	err := p.Parse(rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].subjects[0].name.imports[0]", parseErr.Path)
+	assert.Equal(t, "import cycle platform-extra -> platform-services -> platform-extra", parseErr.Reason)
+
+	rbacDef.RBACBindings[0].Subjects[0].Name = "missing"
+	err = p.Parse(rbacDef)
+	assert.EqualError(t, err, "rbacBindings[0] 'secrets': subjects[0]: name: cannot read RBACDefinition missing: rbacdefinitions \"missing\" not found")
+
+	rbacDef.RBACBindings[0].Subjects[0].Namespace = "platform"
+	assert.Error(t, Validate(&rbacDef))
+}
+
+func TestParseErrors(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	joe := rbacmanagerv1beta1.Subject{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}
+
+	tests := []struct {
+		name     string
+		binding  rbacmanagerv1beta1.RBACBinding
+		path     string
+		reason   string
+		expected string
+	}{{
+		name:     "missing subjects",
+		binding:  rbacmanagerv1beta1.RBACBinding{Name: "devs"},
+		path:     "rbacBindings[1].subjects",
+		reason:   "no subjects specified",
+		expected: "rbacBindings[1] 'devs': subjects: no subjects specified",
+	}, {
+		name: "namespace and selector",
+		binding: rbacmanagerv1beta1.RBACBinding{
+			Name:     "ci-deployers",
+			Subjects: []rbacmanagerv1beta1.Subject{joe},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+				ClusterRole: "view",
+				Namespace:   "web",
+			}, {
+				ClusterRole:       "edit",
+				Namespace:         "web",
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "devs"}},
+			}},
+		},
+		path:     "rbacBindings[1].roleBindings[1]",
+		reason:   "namespaceSelector and namespace are mutually exclusive",
+		expected: "rbacBindings[1] 'ci-deployers': roleBindings[1]: namespaceSelector and namespace are mutually exclusive",
+	}, {
+		name: "missing role",
+		binding: rbacmanagerv1beta1.RBACBinding{
+			Name:         "devs",
+			Subjects:     []rbacmanagerv1beta1.Subject{joe},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{Namespace: "web"}},
+		},
+		path:     "rbacBindings[1].roleBindings[0]",
+		reason:   "role or clusterRole required",
+		expected: "rbacBindings[1] 'devs': roleBindings[0]: role or clusterRole required",
+	}, {
+		name: "invalid selector",
+		binding: rbacmanagerv1beta1.RBACBinding{
+			Name:     "devs",
+			Subjects: []rbacmanagerv1beta1.Subject{joe},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+				ClusterRole: "edit",
+				NamespaceSelector: metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{{
+						Key:      "team",
+						Operator: metav1.LabelSelectorOpIn,
+					}},
+				},
+			}},
+		},
+		path: "rbacBindings[1].roleBindings[0].namespaceSelector",
+	}, {
+		name: "raw service account name",
+		binding: rbacmanagerv1beta1.RBACBinding{
+			Name: "bots",
+			Subjects: []rbacmanagerv1beta1.Subject{{
+				Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "ci-bot", Namespace: "bots"},
+				RawName: true,
+			}},
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+		},
+		path:     "rbacBindings[1].subjects[0].rawName",
+		expected: "rbacBindings[1] 'bots': subjects[0]: rawName: rawName only applies to User and Group subjects",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+			rbacDef.Name = "rbac-config"
+			rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+				Name:                "admins",
+				Subjects:            []rbacmanagerv1beta1.Subject{joe},
+				ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "admin"}},
+			}, tt.binding}
+
+			p := Parser{Clientset: client}
+			err := p.Parse(rbacDef)
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Expected a ParseError, got %v", err)
+			}
+			assert.Equal(t, tt.path, parseErr.Path)
+			assert.Equal(t, tt.binding.Name, parseErr.Binding)
+			if tt.reason != "" {
+				assert.Equal(t, tt.reason, parseErr.Reason)
+			}
+			if tt.expected != "" {
+				assert.Equal(t, tt.expected, parseErr.Error())
+			}
+		})
+	}
+}
+
 func TestManagerToRbacSubjects(t *testing.T) {
 	expected := []rbacv1.Subject{
 		{
@@ -307,6 +1202,24 @@ func TestManagerToRbacSubjects(t *testing.T) {
 	assert.ElementsMatch(t, expected, actual, "expected subjects to match")
 }
 
+func TestNormalizeSubjectAPIGroups(t *testing.T) {
+	subjects := []rbacmanagerv1beta1.Subject{{
+		Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+	}, {
+		Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "devs", APIGroup: rbacv1.GroupName},
+	}, {
+		Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "robot", Namespace: "default", APIGroup: rbacv1.GroupName},
+	}}
+
+	expected := []rbacv1.Subject{
+		{Kind: rbacv1.UserKind, Name: "joe", APIGroup: rbacv1.GroupName},
+		{Kind: rbacv1.GroupKind, Name: "devs", APIGroup: rbacv1.GroupName},
+		{Kind: rbacv1.ServiceAccountKind, Name: "robot", Namespace: "default"},
+	}
+
+	assert.Equal(t, expected, managerSubjectsToRbacSubjects(subjects))
+}
+
 func newParseTest(t *testing.T, client *fake.Clientset, rbacDef rbacmanagerv1beta1.RBACDefinition, expectedRb []rbacv1.RoleBinding, expectedCrb []rbacv1.ClusterRoleBinding, expectedSa []corev1.ServiceAccount) {
 	p := Parser{Clientset: client}
 
@@ -386,6 +1299,19 @@ func expectParsedSA(t *testing.T, p Parser, expected []corev1.ServiceAccount) {
 	}
 }
 
+func annotateNamespace(t *testing.T, client *fake.Clientset, name string, annotations map[string]string) {
+	namespace, err := client.CoreV1().Namespaces().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespace.Annotations = annotations
+	_, err = client.CoreV1().Namespaces().Update(context.TODO(), namespace, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func createNamespace(t *testing.T, client *fake.Clientset, name string, labels map[string]string) {
 	_, err := client.CoreV1().Namespaces().Create(
 		context.TODO(),
@@ -402,3 +1328,206 @@ func createNamespace(t *testing.T, client *fake.Clientset, name string, labels m
 		t.Fatalf("Error creating namespace %v", err)
 	}
 }
+
+func TestParsePropagateToChildren(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+
+	createNamespace(t, client, "team-a", map[string]string{"team-a" + hncTreeLabelSuffix: "0"})
+	createNamespace(t, client, "team-a-web", map[string]string{"team-a" + hncTreeLabelSuffix: "1", "team-a-web" + hncTreeLabelSuffix: "0"})
+	createNamespace(t, client, "team-a-web-canary", map[string]string{
+		"team-a" + hncTreeLabelSuffix:            "2",
+		"team-a-web" + hncTreeLabelSuffix:        "1",
+		"team-a-web-canary" + hncTreeLabelSuffix: "0",
+	})
+	createNamespace(t, client, "team-b", map[string]string{"team-b" + hncTreeLabelSuffix: "0"})
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:         "edit",
+			Namespace:           "team-a",
+			PropagateToChildren: true,
+		}},
+	}}
+
+	expectedRoleBinding := func(namespace string) rbacv1.RoleBinding {
+		return rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "rbac-config-devs-edit",
+				Namespace: namespace,
+			},
+			RoleRef: rbacv1.RoleRef{
+				Kind: "ClusterRole",
+				Name: "edit",
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:     rbacv1.UserKind,
+				APIGroup: rbacv1.GroupName,
+				Name:     "joe",
+			}},
+		}
+	}
+
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{
+		expectedRoleBinding("team-a"),
+		expectedRoleBinding("team-a-web"),
+		expectedRoleBinding("team-a-web-canary"),
+	}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
+
+	p := Parser{Clientset: client}
+	assert.True(t, p.hasNamespaceSelectors(&rbacDef))
+
+	rbacDef.RBACBindings[0].RoleBindings[0].PropagateToChildren = false
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{
+		expectedRoleBinding("team-a"),
+	}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
+}
+
+func TestAffectedByNamespace(t *testing.T) {
+	web := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}}
+	user := rbacmanagerv1beta1.Subject{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}
+	serviceAccount := func(name, namespace string) rbacmanagerv1beta1.Subject {
+		return rbacmanagerv1beta1.Subject{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: namespace}}
+	}
+
+	tests := []struct {
+		name            string
+		rbacBinding     rbacmanagerv1beta1.RBACBinding
+		defaults        rbacmanagerv1beta1.Defaults
+		serviceAccounts bool
+		roleBindings    bool
+	}{{
+		name: "cluster role bindings don't depend on namespaces",
+		rbacBinding: rbacmanagerv1beta1.RBACBinding{
+			Subjects:            []rbacmanagerv1beta1.Subject{user},
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+		},
+	}, {
+		name: "role bindings in other namespaces",
+		rbacBinding: rbacmanagerv1beta1.RBACBinding{
+			Subjects:     []rbacmanagerv1beta1.Subject{user},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "api"}},
+		},
+	}, {
+		name: "role bindings in the namespace",
+		rbacBinding: rbacmanagerv1beta1.RBACBinding{
+			Subjects:     []rbacmanagerv1beta1.Subject{user},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "web"}},
+		},
+		roleBindings: true,
+	}, {
+		name: "namespace lists",
+		rbacBinding: rbacmanagerv1beta1.RBACBinding{
+			Subjects:     []rbacmanagerv1beta1.Subject{user},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespaces: []string{"api", "db"}}},
+		},
+		roleBindings: true,
+	}, {
+		name: "namespace selectors",
+		rbacBinding: rbacmanagerv1beta1.RBACBinding{
+			Subjects: []rbacmanagerv1beta1.Subject{user},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+				ClusterRole:       "edit",
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "dev"}},
+			}},
+		},
+		roleBindings: true,
+	}, {
+		name: "service accounts in other namespaces",
+		rbacBinding: rbacmanagerv1beta1.RBACBinding{
+			Subjects:            []rbacmanagerv1beta1.Subject{serviceAccount("ci", "api")},
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+		},
+	}, {
+		name: "service accounts in the namespace",
+		rbacBinding: rbacmanagerv1beta1.RBACBinding{
+			Subjects:            []rbacmanagerv1beta1.Subject{serviceAccount("ci", "web")},
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+		},
+		serviceAccounts: true,
+	}, {
+		name: "service accounts in the default namespace",
+		rbacBinding: rbacmanagerv1beta1.RBACBinding{
+			Subjects:            []rbacmanagerv1beta1.Subject{serviceAccount("ci", "")},
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+		},
+		defaults:        rbacmanagerv1beta1.Defaults{ServiceAccountNamespace: "web"},
+		serviceAccounts: true,
+	}, {
+		name: "templated service accounts",
+		rbacBinding: rbacmanagerv1beta1.RBACBinding{
+			Subjects: []rbacmanagerv1beta1.Subject{serviceAccount("ci", "{{ .Namespace }}")},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+				ClusterRole:       "edit",
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "dev"}},
+			}},
+		},
+		serviceAccounts: true,
+		roleBindings:    true,
+	}}
+
+	for _, tt := range tests {
+		rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+		rbacDef.Name = "rbac-config"
+		rbacDef.Defaults = tt.defaults
+		rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{tt.rbacBinding}
+
+		p := Parser{}
+		serviceAccounts, roleBindings := p.affectedByNamespace(&rbacDef, web)
+		assert.Equal(t, tt.serviceAccounts, serviceAccounts, "%v: Service Accounts", tt.name)
+		assert.Equal(t, tt.roleBindings, roleBindings, "%v: Role Bindings", tt.name)
+	}
+}
+
+func TestParseRoleLists(t *testing.T) {
+	client := fake.NewSimpleClientset(&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "deployer", Namespace: "web"}})
+	createNamespace(t, client, "web", nil)
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRoles: []string{"view", "pod-exec"},
+			Roles:        []string{"deployer"},
+			Namespace:    "web",
+		}, {
+			Name:         "oncall",
+			ClusterRoles: []string{"view", "pod-exec"},
+			Namespace:    "web",
+		}},
+	}}
+
+	subjects := []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "joe"}}
+	newParseTest(t, client, rbacDef, []rbacv1.RoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{Name: "rbac-config-devs-view", Namespace: "web"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"},
+		Subjects:   subjects,
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "rbac-config-devs-pod-exec", Namespace: "web"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "pod-exec"},
+		Subjects:   subjects,
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "rbac-config-devs-deployer-web", Namespace: "web"},
+		RoleRef:    rbacv1.RoleRef{Kind: "Role", Name: "deployer"},
+		Subjects:   subjects,
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "oncall-view", Namespace: "web"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"},
+		Subjects:   subjects,
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "oncall-pod-exec", Namespace: "web"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "pod-exec"},
+		Subjects:   subjects,
+	}}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// CRBDeleteGracePeriod is how long a Cluster Role Binding its RBAC Definition
+// no longer requests is kept, marked with the pending-delete annotation,
+// before it is deleted. Zero deletes it right away.
+var CRBDeleteGracePeriod time.Duration
+
+// now returns the current time, replaced in tests
+var now = time.Now
+
+// expiredPendingDeletes returns the Cluster Role Bindings of pruned whose
+// grace period has passed. The others are kept, and marked with the
+// pending-delete annotation unless they already are.
+func (r *Reconciler) expiredPendingDeletes(pruned []rbacv1.ClusterRoleBinding) []rbacv1.ClusterRoleBinding {
+	grace := CRBDeleteGracePeriod
+	if grace <= 0 {
+		return pruned
+	}
+
+	expired := []rbacv1.ClusterRoleBinding{}
+	unmarked := []*rbacv1.ClusterRoleBinding{}
+	for i := range pruned {
+		crb := &pruned[i]
+		markedAt, err := time.Parse(time.RFC3339, crb.Annotations[kube.PendingDeleteAnnotation])
+		if err != nil {
+			unmarked = append(unmarked, crb)
+			continue
+		}
+		if remaining := grace - now().Sub(markedAt); remaining > 0 {
+			logrus.Infof("Cluster Role Binding %v is pending deletion for another %v", crb.Name, remaining.Round(time.Second))
+			continue
+		}
+		expired = append(expired, *crb)
+	}
+
+	r.forEach(len(unmarked), func(i int) {
+		crb := unmarked[i]
+		logrus.Warnf("Cluster Role Binding %v is no longer requested, deleting it in %v", crb.Name, grace)
+		if !r.patchPendingDelete(crb, now().UTC().Format(time.RFC3339)) {
+			return
+		}
+		r.event(v1.EventTypeWarning, "DeletionPending", "Cluster Role Binding %v is no longer requested and will be deleted in %v unless it is requested again", crb.Name, grace)
+	})
+	return expired
+}
+
+// cancelPendingDeletes removes the pending-delete annotation from Cluster
+// Role Bindings that are requested again
+func (r *Reconciler) cancelPendingDeletes(requested []*rbacv1.ClusterRoleBinding) {
+	r.forEach(len(requested), func(i int) {
+		crb := requested[i]
+		logrus.Infof("Cluster Role Binding %v is requested again, canceling its deletion", crb.Name)
+		if !r.patchPendingDelete(crb, nil) {
+			return
+		}
+		r.event(v1.EventTypeNormal, "DeletionCanceled", "Cluster Role Binding %v is requested again and will not be deleted", crb.Name)
+	})
+}
+
+// pendingDelete appends crb to pending if it carries the pending-delete
+// annotation
+func pendingDelete(pending []*rbacv1.ClusterRoleBinding, crb *rbacv1.ClusterRoleBinding) []*rbacv1.ClusterRoleBinding {
+	if _, ok := crb.Annotations[kube.PendingDeleteAnnotation]; !ok {
+		return pending
+	}
+	return append(pending, crb.DeepCopy())
+}
+
+// patchPendingDelete sets the pending-delete annotation of crb to value, or
+// removes it if value is nil, and reports whether that succeeded
+func (r *Reconciler) patchPendingDelete(crb *rbacv1.ClusterRoleBinding, value interface{}) bool {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{kube.PendingDeleteAnnotation: value},
+		},
+	})
+	_, err := r.mergePatch("ClusterRoleBinding", crb, patch)
+	if apierrors.IsNotFound(err) {
+		logrus.Debugf("Cluster Role Binding %v was already deleted", crb.Name)
+		return false
+	} else if err != nil {
+		logrus.Errorf("Error updating %v annotation of Cluster Role Binding %v: %v", kube.PendingDeleteAnnotation, crb.Name, err)
+		metrics.ErrorCounter.Inc()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+func TestCRBDeleteGracePeriod(t *testing.T) {
+	defer func(grace time.Duration) { CRBDeleteGracePeriod = grace }(CRBDeleteGracePeriod)
+	defer func() { now = time.Now }()
+	CRBDeleteGracePeriod = time.Hour
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return start }
+
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "grace"
+	rbacDef.UID = types.UID("grace-uid")
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "admins",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}, {ClusterRole: "cluster-admin"}},
+	}}
+	requested := rbacDef.RBACBindings[0].ClusterRoleBindings
+
+	recorder := record.NewFakeRecorder(20)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	getAdmin := func() (*rbacv1.ClusterRoleBinding, error) {
+		return client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "grace-admins-cluster-admin", metav1.GetOptions{})
+	}
+	events := func() string {
+		all := []string{}
+		for len(recorder.Events) > 0 {
+			all = append(all, <-recorder.Events)
+		}
+		return strings.Join(all, "\n")
+	}
+
+	// A binding that is no longer requested is marked first
+	rbacDef.RBACBindings[0].ClusterRoleBindings = requested[:1]
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	admin, err := getAdmin()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2022-05-01T12:00:00Z", admin.Annotations[kube.PendingDeleteAnnotation])
+	}
+	assert.Contains(t, events(), "DeletionPending")
+
+	// and kept within the grace period
+	now = func() time.Time { return start.Add(30 * time.Minute) }
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	_, err = getAdmin()
+	assert.NoError(t, err)
+
+	// Requesting it again clears the mark without recreating it
+	rbacDef.RBACBindings[0].ClusterRoleBindings = requested
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	for _, action := range client.Actions() {
+		assert.NotContains(t, []string{"create", "delete"}, action.GetVerb())
+	}
+	admin, err = getAdmin()
+	if assert.NoError(t, err) {
+		assert.NotContains(t, admin.Annotations, kube.PendingDeleteAnnotation)
+	}
+	assert.Contains(t, events(), "DeletionCanceled")
+
+	// The grace period starts over when it is dropped again
+	rbacDef.RBACBindings[0].ClusterRoleBindings = requested[:1]
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	now = func() time.Time { return start.Add(89 * time.Minute) }
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	_, err = getAdmin()
+	assert.NoError(t, err)
+
+	now = func() time.Time { return start.Add(90 * time.Minute) }
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	_, err = getAdmin()
+	assert.True(t, apierrors.IsNotFound(err), "the binding should be deleted once the grace period passed, got %v", err)
+	_, err = client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "grace-admins-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,390 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"sort"
+
+	"github.com/sirupsen/logrus"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// Plan describes the difference between the desired state of an RBAC
+// Definition and the resources that currently exist for it
+type Plan struct {
+	RBACDefinition string `json:"rbacDefinition"`
+	// Desired holds every resource the RBAC Definition specifies
+	Desired PlanResources `json:"desired"`
+	// Existing holds the resources that match the desired ones or are owned by
+	// the RBAC Definition
+	Existing PlanResources `json:"existing"`
+	// Create, Update and Delete hold the changes a reconcile would make.
+	// Service Accounts, Role copies, and Cluster Role Bindings whose subjects
+	// alone change, are updated in place. Other resources that need to change
+	// are deleted and created again.
+	Create PlanResources `json:"create"`
+	Update PlanResources `json:"update"`
+	Delete PlanResources `json:"delete"`
+}
+
+// PlanResources is a set of resources managed for an RBAC Definition
+type PlanResources struct {
+	ServiceAccounts     []v1.ServiceAccount         `json:"serviceAccounts"`
+	ClusterRoleBindings []rbacv1.ClusterRoleBinding `json:"clusterRoleBindings"`
+	RoleBindings        []rbacv1.RoleBinding        `json:"roleBindings"`
+	Roles               []rbacv1.Role               `json:"roles"`
+	// Namespaces are only ever created, for roleBindings entries with
+	// createIfMissing
+	Namespaces []v1.Namespace `json:"namespaces"`
+}
+
+// InSync reports whether a reconcile would not change anything
+func (p *Plan) InSync() bool {
+	return len(p.Create.ServiceAccounts) == 0 && len(p.Create.ClusterRoleBindings) == 0 && len(p.Create.RoleBindings) == 0 && len(p.Create.Roles) == 0 && len(p.Create.Namespaces) == 0 &&
+		len(p.Update.ServiceAccounts) == 0 && len(p.Update.ClusterRoleBindings) == 0 && len(p.Update.Roles) == 0 &&
+		len(p.Delete.ServiceAccounts) == 0 && len(p.Delete.ClusterRoleBindings) == 0 && len(p.Delete.RoleBindings) == 0 && len(p.Delete.Roles) == 0
+}
+
+// Plan determines the changes Reconcile would make for rbacDef without making
+// any of them
+func (r *Reconciler) Plan(rbacDef *rbacmanagerv1beta1.RBACDefinition) (*Plan, error) {
+	r.setDefinition(rbacDef)
+
+	p := Parser{
+		Clientset:     r.Clientset,
+		GetDefinition: r.GetDefinition,
+		ctx:           r.ctx,
+		ownerRefs:     r.definitionOwnerRefs(),
+	}
+	err := p.Parse(*rbacDef)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		RBACDefinition: rbacDef.Name,
+		Desired:        emptyPlanResources(),
+		Existing:       emptyPlanResources(),
+		Create:         emptyPlanResources(),
+		Update:         emptyPlanResources(),
+		Delete:         emptyPlanResources(),
+	}
+
+	// Parse only requests namespaces that don't exist yet
+	plan.Desired.Namespaces = append(plan.Desired.Namespaces, p.parsedNamespaces...)
+	plan.Create.Namespaces = append(plan.Create.Namespaces, p.parsedNamespaces...)
+
+	existingSAs, err := r.listServiceAccounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, requested := range p.parsedServiceAccounts {
+		r.annotate(&requested.ObjectMeta, serviceAccountSpec(&requested))
+		plan.Desired.ServiceAccounts = append(plan.Desired.ServiceAccounts, requested)
+	}
+	updated := map[string]bool{}
+	for _, requested := range plan.Desired.ServiceAccounts {
+		matched := false
+		for _, existing := range existingSAs.Items {
+			if saMatches(&existing, &requested) {
+				matched = true
+				break
+			}
+		}
+		if matched || updated[objectKey("ServiceAccount", &requested.ObjectMeta)] {
+			continue
+		}
+		if r.updatesServiceAccount(existingSAs.Items, &requested) {
+			updated[objectKey("ServiceAccount", &requested.ObjectMeta)] = true
+			plan.Update.ServiceAccounts = append(plan.Update.ServiceAccounts, requested)
+		} else {
+			plan.Create.ServiceAccounts = append(plan.Create.ServiceAccounts, requested)
+		}
+	}
+	for _, existing := range existingSAs.Items {
+		matched := false
+		for _, requested := range plan.Desired.ServiceAccounts {
+			if saMatches(&existing, &requested) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			plan.Existing.ServiceAccounts = append(plan.Existing.ServiceAccounts, existing)
+		} else if updated[objectKey("ServiceAccount", &existing.ObjectMeta)] && r.owns(&existing.ObjectMeta) {
+			plan.Existing.ServiceAccounts = append(plan.Existing.ServiceAccounts, existing)
+		} else if r.owns(&existing.ObjectMeta) {
+			plan.Existing.ServiceAccounts = append(plan.Existing.ServiceAccounts, existing)
+			// Service Accounts that are only bound are released, not deleted
+			if !p.boundServiceAccounts[objectKey("ServiceAccount", &existing.ObjectMeta)] {
+				plan.Delete.ServiceAccounts = append(plan.Delete.ServiceAccounts, existing)
+			}
+		}
+	}
+
+	existingCRBs, err := r.listClusterRoleBindings()
+	if err != nil {
+		return nil, err
+	}
+	for _, requested := range p.parsedClusterRoleBindings {
+		r.annotate(&requested.ObjectMeta, bindingSpec(requested.RoleRef, requested.Subjects))
+		plan.Desired.ClusterRoleBindings = append(plan.Desired.ClusterRoleBindings, requested)
+	}
+	updatedCRBs := map[string]bool{}
+	for _, requested := range plan.Desired.ClusterRoleBindings {
+		matched := false
+		for _, existing := range existingCRBs.Items {
+			if crbMatches(&existing, &requested) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		if r.updatesSubjects(existingCRBs.Items, &requested) {
+			updatedCRBs[objectKey("ClusterRoleBinding", &requested.ObjectMeta)] = true
+			plan.Update.ClusterRoleBindings = append(plan.Update.ClusterRoleBindings, requested)
+		} else {
+			plan.Create.ClusterRoleBindings = append(plan.Create.ClusterRoleBindings, requested)
+		}
+	}
+	for _, existing := range existingCRBs.Items {
+		matched := false
+		for _, requested := range plan.Desired.ClusterRoleBindings {
+			if crbMatches(&existing, &requested) {
+				matched = true
+				break
+			}
+		}
+		if matched || updatedCRBs[objectKey("ClusterRoleBinding", &existing.ObjectMeta)] {
+			plan.Existing.ClusterRoleBindings = append(plan.Existing.ClusterRoleBindings, existing)
+		} else if r.owns(&existing.ObjectMeta) {
+			plan.Existing.ClusterRoleBindings = append(plan.Existing.ClusterRoleBindings, existing)
+			plan.Delete.ClusterRoleBindings = append(plan.Delete.ClusterRoleBindings, existing)
+		}
+	}
+
+	// Role copies are compared with the copies the RBAC Definition owns, the
+	// same way reconcileRoles does
+	existingRoles, err := listAllRoles(r.context(), r.Clientset, kube.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+	ownedRoles := map[string]*rbacv1.Role{}
+	for i := range existingRoles.Items {
+		if r.owns(&existingRoles.Items[i].ObjectMeta) {
+			ownedRoles[objectKey("Role", &existingRoles.Items[i].ObjectMeta)] = &existingRoles.Items[i]
+			plan.Existing.Roles = append(plan.Existing.Roles, existingRoles.Items[i])
+		}
+	}
+	requestedRoles := map[string]bool{}
+	for _, requested := range p.parsedRoles {
+		r.annotate(&requested.ObjectMeta, roleSpec(requested.Rules))
+		plan.Desired.Roles = append(plan.Desired.Roles, requested)
+		key := objectKey("Role", &requested.ObjectMeta)
+		requestedRoles[key] = true
+		existing, ok := ownedRoles[key]
+		if !ok {
+			plan.Create.Roles = append(plan.Create.Roles, requested)
+		} else if !roleMatches(existing, &requested) {
+			plan.Update.Roles = append(plan.Update.Roles, requested)
+		}
+	}
+	for _, existing := range plan.Existing.Roles {
+		if !requestedRoles[objectKey("Role", &existing.ObjectMeta)] {
+			plan.Delete.Roles = append(plan.Delete.Roles, existing)
+		}
+	}
+
+	existingRBs, err := r.listRoleBindings()
+	if err != nil {
+		return nil, err
+	}
+	for _, requested := range p.parsedRoleBindings {
+		r.annotate(&requested.ObjectMeta, bindingSpec(requested.RoleRef, requested.Subjects))
+		plan.Desired.RoleBindings = append(plan.Desired.RoleBindings, requested)
+	}
+	for _, requested := range plan.Desired.RoleBindings {
+		matched := false
+		for _, existing := range existingRBs.Items {
+			if rbMatches(&existing, &requested) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			plan.Create.RoleBindings = append(plan.Create.RoleBindings, requested)
+		}
+	}
+	for _, existing := range existingRBs.Items {
+		matched := false
+		for _, requested := range plan.Desired.RoleBindings {
+			if rbMatches(&existing, &requested) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			plan.Existing.RoleBindings = append(plan.Existing.RoleBindings, existing)
+		} else if r.owns(&existing.ObjectMeta) {
+			plan.Existing.RoleBindings = append(plan.Existing.RoleBindings, existing)
+			plan.Delete.RoleBindings = append(plan.Delete.RoleBindings, existing)
+		}
+	}
+
+	return plan, nil
+}
+
+// ReportPlan records the changes Reconcile would make for an RBAC Definition
+// in report only mode in its status without making any of them
+func (r *Reconciler) ReportPlan(rbacDef *rbacmanagerv1beta1.RBACDefinition) (err error) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	cancel := r.startTimeout()
+	defer func() { err = r.stopTimeout(cancel, err) }()
+
+	logrus.Infof("Planning changes for RBACDefinition %v", rbacDef.Name)
+
+	plan, err := r.Plan(rbacDef)
+	if err != nil {
+		return err
+	}
+
+	rbacDef.Status.PlannedChanges, rbacDef.Status.PlannedChangesOmitted = plan.PlannedChanges()
+	return nil
+}
+
+// updatesServiceAccount reports whether requested would be applied by
+// updating a Service Account of the RBAC Definition in place
+func (r *Reconciler) updatesServiceAccount(existingSAs []v1.ServiceAccount, requested *v1.ServiceAccount) bool {
+	key := objectKey("ServiceAccount", &requested.ObjectMeta)
+	for i := range existingSAs {
+		if objectKey("ServiceAccount", &existingSAs[i].ObjectMeta) == key && r.owns(&existingSAs[i].ObjectMeta) {
+			return true
+		}
+	}
+	return false
+}
+
+// updatesSubjects reports whether requested would be applied by changing
+// the subjects of a Cluster Role Binding of the RBAC Definition in place
+func (r *Reconciler) updatesSubjects(existingCRBs []rbacv1.ClusterRoleBinding, requested *rbacv1.ClusterRoleBinding) bool {
+	key := objectKey("ClusterRoleBinding", &requested.ObjectMeta)
+	for i := range existingCRBs {
+		if objectKey("ClusterRoleBinding", &existingCRBs[i].ObjectMeta) == key && r.owns(&existingCRBs[i].ObjectMeta) {
+			return subjectsOnlyChanged(&existingCRBs[i], requested)
+		}
+	}
+	return false
+}
+
+func emptyPlanResources() PlanResources {
+	return PlanResources{
+		ServiceAccounts:     []v1.ServiceAccount{},
+		ClusterRoleBindings: []rbacv1.ClusterRoleBinding{},
+		RoleBindings:        []rbacv1.RoleBinding{},
+		Roles:               []rbacv1.Role{},
+		Namespaces:          []v1.Namespace{},
+	}
+}
+
+// PlannedChanges lists the changes in plan in the order Reconcile makes them,
+// returning at most MaxPlannedChanges of them and how many were left out
+func (p *Plan) PlannedChanges() ([]rbacmanagerv1beta1.PlannedChange, int) {
+	changes := []rbacmanagerv1beta1.PlannedChange{}
+	add := func(action, kind string, objectMeta *metav1.ObjectMeta, roleRef *rbacv1.RoleRef) {
+		change := rbacmanagerv1beta1.PlannedChange{
+			Action:    action,
+			Kind:      kind,
+			Namespace: objectMeta.Namespace,
+			Name:      objectMeta.Name,
+		}
+		if roleRef != nil {
+			change.RoleRef = roleRef.Kind + "/" + roleRef.Name
+		}
+		changes = append(changes, change)
+	}
+
+	for i := range p.Create.Namespaces {
+		add("create", "Namespace", &p.Create.Namespaces[i].ObjectMeta, nil)
+	}
+	for i := range p.Delete.ServiceAccounts {
+		add("delete", "ServiceAccount", &p.Delete.ServiceAccounts[i].ObjectMeta, nil)
+	}
+	for i := range p.Update.ServiceAccounts {
+		add("update", "ServiceAccount", &p.Update.ServiceAccounts[i].ObjectMeta, nil)
+	}
+	for i := range p.Create.ServiceAccounts {
+		add("create", "ServiceAccount", &p.Create.ServiceAccounts[i].ObjectMeta, nil)
+	}
+	for i := range p.Delete.ClusterRoleBindings {
+		add("delete", "ClusterRoleBinding", &p.Delete.ClusterRoleBindings[i].ObjectMeta, &p.Delete.ClusterRoleBindings[i].RoleRef)
+	}
+	for i := range p.Update.ClusterRoleBindings {
+		add("update", "ClusterRoleBinding", &p.Update.ClusterRoleBindings[i].ObjectMeta, &p.Update.ClusterRoleBindings[i].RoleRef)
+	}
+	for i := range p.Create.ClusterRoleBindings {
+		add("create", "ClusterRoleBinding", &p.Create.ClusterRoleBindings[i].ObjectMeta, &p.Create.ClusterRoleBindings[i].RoleRef)
+	}
+	for i := range p.Delete.Roles {
+		add("delete", "Role", &p.Delete.Roles[i].ObjectMeta, nil)
+	}
+	for i := range p.Update.Roles {
+		add("update", "Role", &p.Update.Roles[i].ObjectMeta, nil)
+	}
+	for i := range p.Create.Roles {
+		add("create", "Role", &p.Create.Roles[i].ObjectMeta, nil)
+	}
+	for i := range p.Delete.RoleBindings {
+		add("delete", "RoleBinding", &p.Delete.RoleBindings[i].ObjectMeta, &p.Delete.RoleBindings[i].RoleRef)
+	}
+	for i := range p.Create.RoleBindings {
+		add("create", "RoleBinding", &p.Create.RoleBindings[i].ObjectMeta, &p.Create.RoleBindings[i].RoleRef)
+	}
+
+	// Keep the order stable between reconciles so the status only changes
+	// with the plan
+	order := func(change rbacmanagerv1beta1.PlannedChange) string {
+		return change.Kind + "/" + change.Action
+	}
+	rank := map[string]int{}
+	for _, change := range changes {
+		if _, ok := rank[order(change)]; !ok {
+			rank[order(change)] = len(rank)
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if ri, rj := rank[order(changes[i])], rank[order(changes[j])]; ri != rj {
+			return ri < rj
+		}
+		if changes[i].Namespace != changes[j].Namespace {
+			return changes[i].Namespace < changes[j].Namespace
+		}
+		return changes[i].Name < changes[j].Name
+	})
+
+	if len(changes) > rbacmanagerv1beta1.MaxPlannedChanges {
+		return changes[:rbacmanagerv1beta1.MaxPlannedChanges], len(changes) - rbacmanagerv1beta1.MaxPlannedChanges
+	}
+	return changes, 0
+}
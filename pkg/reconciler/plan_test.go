@@ -0,0 +1,288 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestPlan(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "plan-example"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "admins",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "jan"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "admin",
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	plan, err := r.Plan(&rbacDef)
+	assert.NoError(t, err)
+	assert.False(t, plan.InSync())
+	assert.Len(t, plan.Desired.ClusterRoleBindings, 1)
+	assert.Len(t, plan.Existing.ClusterRoleBindings, 0)
+	if assert.Len(t, plan.Create.ClusterRoleBindings, 1) {
+		assert.Equal(t, "plan-example-admins-admin", plan.Create.ClusterRoleBindings[0].Name)
+	}
+
+	// Planning doesn't change anything
+	expectClusterRoleBindings(t, client, []rbacv1.ClusterRoleBinding{})
+
+	r = Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	plan, err = r.Plan(&rbacDef)
+	assert.NoError(t, err)
+	assert.True(t, plan.InSync())
+	assert.Len(t, plan.Existing.ClusterRoleBindings, 1)
+
+	// Changing the subjects updates the binding in place
+	rbacDef.RBACBindings[0].Subjects[0].Name = "joe"
+	plan, err = r.Plan(&rbacDef)
+	assert.NoError(t, err)
+	assert.False(t, plan.InSync())
+	assert.Empty(t, plan.Create.ClusterRoleBindings)
+	assert.Empty(t, plan.Delete.ClusterRoleBindings)
+	if assert.Len(t, plan.Update.ClusterRoleBindings, 1) {
+		assert.Equal(t, "joe", plan.Update.ClusterRoleBindings[0].Subjects[0].Name)
+	}
+
+	// Changing the role replaces it
+	rbacDef.RBACBindings[0].ClusterRoleBindings[0].ClusterRole = "edit"
+	plan, err = r.Plan(&rbacDef)
+	assert.NoError(t, err)
+	assert.Len(t, plan.Create.ClusterRoleBindings, 1)
+	if assert.Len(t, plan.Delete.ClusterRoleBindings, 1) {
+		assert.Equal(t, "jan", plan.Delete.ClusterRoleBindings[0].Subjects[0].Name)
+	}
+}
+
+func TestPlanRoleCopies(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "golden", nil)
+	createNamespace(t, client, "web", nil)
+	source := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "developer", Namespace: "golden"},
+		Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+	}
+	_, err := client.RbacV1().Roles("golden").Create(context.TODO(), source, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "golden-roles"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			RoleFrom:  &rbacmanagerv1beta1.RoleSource{Namespace: "golden", Name: "developer"},
+			Namespace: "web",
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	plan, err := r.Plan(&rbacDef)
+	assert.NoError(t, err)
+	if assert.Len(t, plan.Create.Roles, 1) {
+		assert.Equal(t, "web", plan.Create.Roles[0].Namespace)
+	}
+
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	plan, err = r.Plan(&rbacDef)
+	assert.NoError(t, err)
+	assert.True(t, plan.InSync())
+	assert.Len(t, plan.Existing.Roles, 1)
+
+	// A changed source updates the copy in place
+	source.Rules[0].Verbs = []string{"get", "list"}
+	_, err = client.RbacV1().Roles("golden").Update(context.TODO(), source, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	plan, err = r.Plan(&rbacDef)
+	assert.NoError(t, err)
+	assert.False(t, plan.InSync())
+	assert.Empty(t, plan.Create.Roles)
+	if assert.Len(t, plan.Update.Roles, 1) {
+		assert.Equal(t, []string{"get", "list"}, plan.Update.Roles[0].Rules[0].Verbs)
+	}
+
+	// Copies no longer requested are deleted
+	rbacDef.RBACBindings[0].RoleBindings[0].RoleFrom = nil
+	rbacDef.RBACBindings[0].RoleBindings[0].ClusterRole = "view"
+	plan, err = r.Plan(&rbacDef)
+	assert.NoError(t, err)
+	assert.Len(t, plan.Delete.Roles, 1)
+	changes, _ := plan.PlannedChanges()
+	assert.Contains(t, changes, rbacmanagerv1beta1.PlannedChange{Action: "delete", Kind: "Role", Namespace: "web", Name: "developer"})
+}
+
+func TestPlanMissingNamespaces(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "web", nil)
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "new-teams"
+	rbacDef.SyncMode = rbacmanagerv1beta1.SyncModeReportOnly
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:     "edit",
+			Namespaces:      []string{"web", "search"},
+			CreateIfMissing: true,
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	plan, err := r.Plan(&rbacDef)
+	assert.NoError(t, err)
+	if assert.Len(t, plan.Create.Namespaces, 1) {
+		assert.Equal(t, "search", plan.Create.Namespaces[0].Name)
+	}
+
+	assert.NoError(t, r.ReportPlan(&rbacDef))
+	assert.Equal(t, rbacmanagerv1beta1.PlannedChange{Action: "create", Kind: "Namespace", Name: "search"}, rbacDef.Status.PlannedChanges[0])
+	expectPlanApplied(t, client, &rbacDef)
+
+	plan, err = r.Plan(&rbacDef)
+	assert.NoError(t, err)
+	assert.True(t, plan.InSync())
+}
+
+func TestReportPlan(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}})
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "report-example"
+	rbacDef.SyncMode = rbacmanagerv1beta1.SyncModeReportOnly
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "ci",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "deployer", Namespace: "web"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole: "edit",
+			Namespace:   "web",
+		}},
+	}}
+
+	// Report only definitions are never reconciled, not even directly
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.NoError(t, r.ReportPlan(&rbacDef))
+	assert.Empty(t, appliedChanges(client))
+	assert.Equal(t, 0, rbacDef.Status.PlannedChangesOmitted)
+	assert.Equal(t, []rbacmanagerv1beta1.PlannedChange{
+		{Action: "create", Kind: "ServiceAccount", Namespace: "web", Name: "deployer"},
+		{Action: "create", Kind: "ClusterRoleBinding", Name: "report-example-ci-view", RoleRef: "ClusterRole/view"},
+		{Action: "create", Kind: "RoleBinding", Namespace: "web", Name: "report-example-ci-edit", RoleRef: "ClusterRole/edit"},
+	}, rbacDef.Status.PlannedChanges)
+
+	SetReadyCondition(&rbacDef, nil)
+	assert.Equal(t, "ReportOnly", rbacDef.Status.Conditions[0].Reason)
+	assert.Equal(t, "3 changes planned and not applied", rbacDef.Status.Conditions[0].Message)
+
+	// Switching to full mode makes exactly the planned changes
+	expectPlanApplied(t, client, &rbacDef)
+
+	// Including replacing Role Bindings and updating Cluster Role Bindings
+	// whose subjects changed
+	rbacDef.SyncMode = rbacmanagerv1beta1.SyncModeReportOnly
+	rbacDef.RBACBindings[0].Subjects[0].Name = "releaser"
+	assert.NoError(t, r.ReportPlan(&rbacDef))
+	assert.Len(t, rbacDef.Status.PlannedChanges, 5)
+	expectPlanApplied(t, client, &rbacDef)
+}
+
+func TestPlannedChangesOmitted(t *testing.T) {
+	plan := &Plan{Create: emptyPlanResources()}
+	for i := 0; i < rbacmanagerv1beta1.MaxPlannedChanges+5; i++ {
+		sa := corev1.ServiceAccount{}
+		sa.Name = fmt.Sprintf("sa-%03d", i)
+		plan.Create.ServiceAccounts = append(plan.Create.ServiceAccounts, sa)
+	}
+
+	changes, omitted := plan.PlannedChanges()
+	assert.Len(t, changes, rbacmanagerv1beta1.MaxPlannedChanges)
+	assert.Equal(t, 5, omitted)
+	assert.Equal(t, "sa-000", changes[0].Name)
+}
+
+// expectPlanApplied reconciles rbacDef in full mode and checks that the
+// resulting creates and deletes are the ones in its planned changes
+func expectPlanApplied(t *testing.T, client *fake.Clientset, rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	t.Helper()
+
+	planned := []string{}
+	for _, change := range rbacDef.Status.PlannedChanges {
+		planned = append(planned, change.Action+" "+change.Kind+" "+change.Namespace+"/"+change.Name)
+	}
+	sort.Strings(planned)
+
+	client.ClearActions()
+	rbacDef.SyncMode = rbacmanagerv1beta1.SyncModeFull
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(rbacDef))
+	assert.Equal(t, planned, appliedChanges(client))
+}
+
+// appliedChanges describes the Namespaces, ServiceAccounts and bindings the
+// fake client created, deleted, or changed the subjects of in the same form as
+// expectPlanApplied
+func appliedChanges(client *fake.Clientset) []string {
+	kinds := map[string]string{
+		"namespaces":          "Namespace",
+		"serviceaccounts":     "ServiceAccount",
+		"clusterrolebindings": "ClusterRoleBinding",
+		"rolebindings":        "RoleBinding",
+	}
+
+	changes := []string{}
+	for _, action := range client.Actions() {
+		kind, ok := kinds[action.GetResource().Resource]
+		if !ok {
+			continue
+		}
+		switch a := action.(type) {
+		case k8stesting.CreateAction:
+			objectMeta, err := meta.Accessor(a.GetObject())
+			if err == nil {
+				changes = append(changes, action.GetVerb()+" "+kind+" "+objectMeta.GetNamespace()+"/"+objectMeta.GetName())
+			}
+		case k8stesting.DeleteAction:
+			changes = append(changes, "delete "+kind+" "+a.GetNamespace()+"/"+a.GetName())
+		case k8stesting.PatchAction:
+			changes = append(changes, "update "+kind+" "+a.GetNamespace()+"/"+a.GetName())
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
@@ -0,0 +1,277 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// admissionDenied matches the message the API server gives errors returned
+// when a validating or mutating admission webhook rejects a request
+var admissionDenied = regexp.MustCompile(`(?s)admission webhook "([^"]+)" denied the request:?\s*(.*)`)
+
+// policyDenial is the webhook and message of a create rejected by a policy engine
+type policyDenial struct {
+	webhook string
+	message string
+}
+
+// parsePolicyDenial returns the webhook and policy message of err if an
+// admission webhook denied the request
+func parsePolicyDenial(err error) (policyDenial, bool) {
+	status, ok := err.(apierrors.APIStatus)
+	if !ok {
+		return policyDenial{}, false
+	}
+	match := admissionDenied.FindStringSubmatch(status.Status().Message)
+	if match == nil {
+		return policyDenial{}, false
+	}
+	return policyDenial{webhook: match[1], message: strings.TrimSpace(match[2])}, true
+}
+
+// PolicyBlocks tracks resources whose creation was denied by an admission
+// webhook. It is shared by all Reconcilers so that creates of these
+// resources are retried with backoff instead of on every reconcile.
+var PolicyBlocks = NewPolicyBlocks(time.Minute, time.Hour)
+
+// policyBlock is a resource whose creation was denied by an admission webhook
+type policyBlock struct {
+	definition string
+	cluster    string
+	kind       string
+	namespace  string
+	name       string
+	object     string
+	denial     policyDenial
+	specHash   string
+	failures   int
+	retryAt    time.Time
+}
+
+// PolicyBlockTracker holds the resources blocked by policies and when to try
+// creating them again
+type PolicyBlockTracker struct {
+	base time.Duration
+	max  time.Duration
+
+	blockedMux sync.Mutex
+	blocked    map[string]*policyBlock
+	// webhooks are those reported in the BlockedByPolicy metric by RBAC
+	// Definition, so that the metric can be cleared once they stop blocking
+	webhooks map[string]map[string]bool
+}
+
+// NewPolicyBlocks returns a tracker that waits base before retrying a denied
+// create, doubling the delay after each further denial up to max
+func NewPolicyBlocks(base time.Duration, max time.Duration) *PolicyBlockTracker {
+	return &PolicyBlockTracker{
+		base:     base,
+		max:      max,
+		blocked:  map[string]*policyBlock{},
+		webhooks: map[string]map[string]bool{},
+	}
+}
+
+// policyKey identifies a resource requested by the RBAC Definition being
+// reconciled in the cluster of the Reconciler
+func (r *Reconciler) policyKey(kind string, objectMeta *metav1.ObjectMeta) string {
+	return r.rbacDef.Name + ":" + r.appliedKey(kind, objectMeta)
+}
+
+// heldByPolicy reports whether the create of a resource should be skipped
+// because an admission webhook denied it recently. A change to the requested
+// spec is retried right away.
+func (r *Reconciler) heldByPolicy(kind string, objectMeta *metav1.ObjectMeta) bool {
+	if r.rbacDef == nil {
+		return false
+	}
+	key := r.policyKey(kind, objectMeta)
+
+	b := PolicyBlocks
+	b.blockedMux.Lock()
+	defer b.blockedMux.Unlock()
+
+	block, ok := b.blocked[key]
+	if !ok {
+		return false
+	}
+	if block.specHash != objectMeta.Annotations[kube.SpecHashAnnotation] {
+		delete(b.blocked, key)
+		return false
+	}
+
+	r.seePolicyBlock(key)
+	if time.Now().Before(block.retryAt) {
+		logrus.Debugf("Not creating %v before %v, it was denied by admission webhook %v", block.object, block.retryAt.Format(time.RFC3339), block.denial.webhook)
+		return true
+	}
+	return false
+}
+
+// deniedByPolicy reports whether err means an admission webhook denied the
+// create of a resource. If so the denial is recorded and the policy message
+// is passed on in an event.
+func (r *Reconciler) deniedByPolicy(kind string, objectMeta *metav1.ObjectMeta, err error) bool {
+	denial, ok := parsePolicyDenial(err)
+	if !ok || r.rbacDef == nil {
+		return false
+	}
+	key := r.policyKey(kind, objectMeta)
+	object := kind + " " + objectMeta.Name
+	if objectMeta.Namespace != "" {
+		object = kind + " " + objectMeta.Namespace + "/" + objectMeta.Name
+	}
+
+	b := PolicyBlocks
+	b.blockedMux.Lock()
+	block, ok := b.blocked[key]
+	if !ok {
+		block = &policyBlock{
+			definition: r.rbacDef.Name,
+			cluster:    r.Cluster,
+			kind:       kind,
+			namespace:  objectMeta.Namespace,
+			name:       objectMeta.Name,
+			object:     object,
+		}
+		b.blocked[key] = block
+	}
+	block.denial = denial
+	block.specHash = objectMeta.Annotations[kube.SpecHashAnnotation]
+	block.failures++
+	delay := b.base
+	for i := 1; i < block.failures && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	block.retryAt = time.Now().Add(delay)
+	r.seePolicyBlock(key)
+	b.blockedMux.Unlock()
+
+	logrus.Warnf("Admission webhook %v denied %v, retrying in %v: %v", denial.webhook, object, delay, denial.message)
+	metrics.ErrorCounter.Inc()
+	r.event(v1.EventTypeWarning, "BlockedByPolicy", "Admission webhook %q denied %v: %v", denial.webhook, object, denial.message)
+	return true
+}
+
+// seePolicyBlock notes that a blocked resource is still requested. The caller
+// must hold the lock of PolicyBlocks.
+func (r *Reconciler) seePolicyBlock(key string) {
+	if r.policySeen == nil {
+		r.policySeen = map[string]bool{}
+	}
+	r.policySeen[key] = true
+}
+
+// prunePolicyBlocks forgets the blocked resources of the RBAC Definition
+// being reconciled that were not requested or have been created during the
+// last reconcile, and updates the BlockedByPolicy metric
+func (r *Reconciler) prunePolicyBlocks() {
+	b := PolicyBlocks
+	b.blockedMux.Lock()
+	defer b.blockedMux.Unlock()
+
+	for key, block := range b.blocked {
+		if block.definition == r.rbacDef.Name && block.cluster == r.Cluster && !r.policySeen[key] {
+			delete(b.blocked, key)
+		}
+	}
+	b.updateMetric(r.rbacDef.Name)
+}
+
+// Forget drops the blocked resources of an RBAC Definition that no longer exists
+func (b *PolicyBlockTracker) Forget(definition string) {
+	b.blockedMux.Lock()
+	defer b.blockedMux.Unlock()
+
+	for key, block := range b.blocked {
+		if block.definition == definition {
+			delete(b.blocked, key)
+		}
+	}
+	b.updateMetric(definition)
+}
+
+// updateMetric sets the number of resources of an RBAC Definition blocked by
+// each webhook. The caller must hold blockedMux.
+func (b *PolicyBlockTracker) updateMetric(definition string) {
+	counts := map[string]int{}
+	for _, block := range b.blocked {
+		if block.definition == definition {
+			counts[block.denial.webhook]++
+		}
+	}
+
+	for webhook := range b.webhooks[definition] {
+		if counts[webhook] == 0 {
+			metrics.BlockedByPolicy.DeleteLabelValues(definition, webhook)
+		}
+	}
+	delete(b.webhooks, definition)
+	for webhook, count := range counts {
+		metrics.BlockedByPolicy.WithLabelValues(definition, webhook).Set(float64(count))
+		if b.webhooks[definition] == nil {
+			b.webhooks[definition] = map[string]bool{}
+		}
+		b.webhooks[definition][webhook] = true
+	}
+}
+
+// setPolicyCondition records the resources of rbacDef in the cluster of the
+// Reconciler that admission webhooks denied in the status of rbacDef
+func (r *Reconciler) setPolicyCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	b := PolicyBlocks
+	b.blockedMux.Lock()
+	blocked := []string{}
+	for _, block := range b.blocked {
+		if block.definition == rbacDef.Name && block.cluster == r.Cluster {
+			blocked = append(blocked, fmt.Sprintf("%v (%v: %v)", block.object, block.denial.webhook, block.denial.message))
+		}
+	}
+	b.blockedMux.Unlock()
+
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionBlockedByPolicy,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rbacDef.Generation,
+		Reason:             "NotBlocked",
+		Message:            "No requested resources were denied by admission webhooks",
+	}
+	if len(blocked) > 0 {
+		sort.Strings(blocked)
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "AdmissionDenied"
+		condition.Message = fmt.Sprintf("Admission webhooks denied: %v", strings.Join(blocked, "; "))
+	}
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, condition)
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// PreflightBindChecks verifies that RBAC Manager may bind a role before it
+// creates the first binding to it in a reconcile. Bindings to roles it may
+// not bind are skipped and reported once instead of failing on every create.
+var PreflightBindChecks bool
+
+// bindCheck is the outcome of checking whether a role may be bound
+type bindCheck struct {
+	permitted bool
+	role      string
+	reason    string
+}
+
+// crbPermitted reports whether RBAC Manager may bind the role of crb
+func (r *Reconciler) crbPermitted(crb *rbacv1.ClusterRoleBinding) bool {
+	if !PreflightBindChecks {
+		return true
+	}
+	return r.bindPermitted(crb.RoleRef, "", func() error {
+		_, err := kube.RBAC(r.Clientset).ClusterRoleBindings().Create(r.context(), crb, dryRunCreate())
+		return err
+	})
+}
+
+// rbPermitted reports whether RBAC Manager may bind the role of rb in its
+// namespace
+func (r *Reconciler) rbPermitted(rb *rbacv1.RoleBinding) bool {
+	if !PreflightBindChecks {
+		return true
+	}
+	return r.bindPermitted(rb.RoleRef, rb.Namespace, func() error {
+		_, err := kube.RBAC(r.Clientset).RoleBindings(rb.Namespace).Create(r.context(), rb, dryRunCreate())
+		return err
+	})
+}
+
+// bindPermitted reports whether roleRef may be bound in namespace, empty for
+// Cluster Role Bindings. Each role is checked once per reconcile: first with
+// a SelfSubjectAccessReview for the bind verb and, if that is denied, with a
+// dry run of the binding since holding every permission of a role is enough
+// to bind it.
+func (r *Reconciler) bindPermitted(roleRef rbacv1.RoleRef, namespace string, dryRun func() error) bool {
+	key := roleRef.Kind + "/" + namespace + "/" + roleRef.Name
+
+	r.bindChecksMux.Lock()
+	defer r.bindChecksMux.Unlock()
+
+	if check, ok := r.bindChecks[key]; ok {
+		return check.permitted
+	}
+
+	check := r.checkBind(roleRef, namespace, dryRun)
+	check.role = roleRef.Kind + " " + roleRef.Name
+	if namespace != "" {
+		check.role += " in namespace " + namespace
+	}
+	if r.bindChecks == nil {
+		r.bindChecks = map[string]bindCheck{}
+	}
+	r.bindChecks[key] = check
+
+	if !check.permitted {
+		logrus.Warnf("rbac-manager is not permitted to bind %v: %v", check.role, check.reason)
+		metrics.ErrorCounter.Inc()
+		r.event(v1.EventTypeWarning, "BindingForbidden", "rbac-manager is not permitted to bind %v: %v", check.role, check.reason)
+	}
+	return check.permitted
+}
+
+func (r *Reconciler) checkBind(roleRef rbacv1.RoleRef, namespace string, dryRun func() error) bindCheck {
+	resource := "clusterroles"
+	if roleRef.Kind == "Role" {
+		resource = "roles"
+	}
+
+	review, err := r.Clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(r.context(), &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "bind",
+				Group:     rbacv1.GroupName,
+				Resource:  resource,
+				Name:      roleRef.Name,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		// Leave it to the create to report the problem
+		logrus.Debugf("Error checking whether %v %v may be bound: %v", roleRef.Kind, roleRef.Name, err)
+		return bindCheck{permitted: true}
+	}
+	if review.Status.Allowed {
+		return bindCheck{permitted: true}
+	}
+
+	err = dryRun()
+	if apierrors.IsForbidden(err) {
+		return bindCheck{reason: "missing bind permission and not all of its permissions are held"}
+	}
+	return bindCheck{permitted: true}
+}
+
+// setBindingForbiddenCondition records the roles that could not be bound
+// during the last reconcile in the status of rbacDef
+func (r *Reconciler) setBindingForbiddenCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	if !PreflightBindChecks {
+		meta.RemoveStatusCondition(&rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionBindingForbidden)
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionBindingForbidden,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rbacDef.Generation,
+		Reason:             "BindingsPermitted",
+		Message:            "RBAC Manager may bind every requested role",
+	}
+
+	forbidden := []string{}
+	for _, check := range r.bindChecks {
+		if !check.permitted {
+			forbidden = append(forbidden, check.role)
+		}
+	}
+
+	if len(forbidden) > 0 {
+		sort.Strings(forbidden)
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "MissingBindPermission"
+		condition.Message = fmt.Sprintf("rbac-manager is not permitted to bind %v", strings.Join(forbidden, ", "))
+	}
+
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, condition)
+}
+
+func dryRunCreate() metav1.CreateOptions {
+	return metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}, FieldManager: kube.FieldManager}
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// MaxPrunes is the number of resources of one kind a reconcile may delete
+// because their RBAC Definition no longer requests them. Zero sets no limit.
+var MaxPrunes int
+
+// maxBlockedPruneNames is the number of withheld deletions the PruneBlocked
+// condition names
+const maxBlockedPruneNames = 10
+
+// pruneKinds are the kinds of resources the prune limit applies to
+var pruneKinds = []string{"ServiceAccount", "ClusterRoleBinding", "Role", "RoleBinding"}
+
+// allowPrunes reports whether the resources of kind in pruned, which the RBAC
+// Definition being reconciled no longer requests, may be deleted. Deleting
+// more than the prune limit at once usually means a definition was emptied
+// by mistake, so none of them are deleted until the approve-prunes
+// annotation approves at least as many.
+func (r *Reconciler) allowPrunes(kind string, pruned []*metav1.ObjectMeta) bool {
+	if len(pruned) == 0 {
+		return true
+	}
+	limit := CurrentOptions().MaxPrunes
+	if limit == 0 || len(pruned) <= limit {
+		return true
+	}
+
+	names := make([]string, 0, len(pruned))
+	for _, objectMeta := range pruned {
+		names = append(names, objectName(objectMeta))
+	}
+	sort.Strings(names)
+
+	r.prunesMux.Lock()
+	defer r.prunesMux.Unlock()
+
+	if len(pruned) <= r.approvedPrunes() {
+		if r.approved == nil {
+			r.approved = map[string][]string{}
+		}
+		r.approved[kind] = names
+		logrus.Infof("Pruning %d %vs of RBACDefinition %v as approved by annotation", len(names), kind, r.rbacDef.Name)
+		return true
+	}
+
+	if r.blocked == nil {
+		r.blocked = map[string][]string{}
+	}
+	r.blocked[kind] = names
+	logrus.Warnf("Not pruning %d %vs of RBACDefinition %v, more than the limit of %d", len(names), kind, r.rbacDef.Name, limit)
+	return false
+}
+
+// approvedPrunes returns the number of deletions of each kind the
+// approve-prunes annotation of the RBAC Definition being reconciled approves
+func (r *Reconciler) approvedPrunes() int {
+	value, ok := r.rbacDef.Annotations[kube.ApprovePrunesAnnotation]
+	if !ok {
+		return 0
+	}
+	approved, err := strconv.Atoi(value)
+	if err != nil || approved < 0 {
+		logrus.Warnf("Ignoring %v annotation of RBACDefinition %v, %q is not a number of resources", kube.ApprovePrunesAnnotation, r.rbacDef.Name, value)
+		return 0
+	}
+	return approved
+}
+
+// prunedApproved records an event summarizing the approved deletions of kind
+// after they were made
+func (r *Reconciler) prunedApproved(kind string) {
+	r.prunesMux.Lock()
+	names := r.approved[kind]
+	r.prunesMux.Unlock()
+
+	if len(names) == 0 {
+		return
+	}
+	r.event(v1.EventTypeNormal, "PrunesApproved", "Pruned %d %vs as approved by the %v annotation: %v",
+		len(names), kind, kube.ApprovePrunesAnnotation, truncateMessage(strings.Join(names, ", "), maxReadyMessageLength))
+}
+
+// setPruneBlockedCondition records the deletions withheld during the last
+// reconcile in the PruneBlocked condition and the blocked_prunes metric
+func (r *Reconciler) setPruneBlockedCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, r.PruneBlockedCondition(rbacDef.Generation))
+	for _, kind := range pruneKinds {
+		metrics.BlockedPrunes.WithLabelValues(rbacDef.Name, kind).Set(float64(len(r.blocked[kind])))
+	}
+}
+
+// PruneBlockedCondition describes the deletions withheld by the prune limit
+// during the last reconcile of an RBAC Definition with the given generation
+func (r *Reconciler) PruneBlockedCondition(generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionPruneBlocked,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             "NoPrunesBlocked",
+		Message:            "No deletions are withheld by the prune limit",
+	}
+
+	total := 0
+	names := []string{}
+	for _, kind := range pruneKinds {
+		total += len(r.blocked[kind])
+		for _, name := range r.blocked[kind] {
+			if len(names) < maxBlockedPruneNames {
+				names = append(names, kind+" "+name)
+			}
+		}
+	}
+	if total > 0 {
+		if total > len(names) {
+			names = append(names, fmt.Sprintf("and %d more", total-len(names)))
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "PruneLimitExceeded"
+		condition.Message = fmt.Sprintf("%d deletions exceed the prune limit of %d and wait for approval through the %v annotation: %v",
+			total, CurrentOptions().MaxPrunes, kube.ApprovePrunesAnnotation, strings.Join(names, ", "))
+	}
+
+	return condition
+}
+
+// ForgetBlockedPrunes removes the blocked_prunes metric of a deleted RBAC
+// Definition
+func ForgetBlockedPrunes(name string) {
+	for _, kind := range pruneKinds {
+		metrics.BlockedPrunes.DeleteLabelValues(name, kind)
+	}
+}
+
+// objectName is the namespace and name of a resource, or just its name if
+// it is cluster scoped
+func objectName(objectMeta *metav1.ObjectMeta) string {
+	if objectMeta.Namespace == "" {
+		return objectMeta.Name
+	}
+	return objectMeta.Namespace + "/" + objectMeta.Name
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestPruneLimit(t *testing.T) {
+	defer currentOptions.Store((*Options)(nil))
+	options := DefaultOptions()
+	options.MaxPrunes = 2
+	SetOptions(options)
+
+	namespaces := []string{"web", "api", "db"}
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "prunes"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+	}}
+	for _, namespace := range namespaces {
+		_, err := client.CoreV1().Namespaces().Create(context.TODO(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}, metav1.CreateOptions{})
+		assert.NoError(t, err)
+		rbacDef.RBACBindings[0].RoleBindings = append(rbacDef.RBACBindings[0].RoleBindings, rbacmanagerv1beta1.RoleBinding{ClusterRole: "edit", Namespace: namespace})
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assertRoleBindings(t, client, 3)
+
+	// Removing all three Role Bindings exceeds the limit, so none are deleted
+	rbacDef.RBACBindings[0].RoleBindings = nil
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assertRoleBindings(t, client, 3)
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.BlockedPrunes.WithLabelValues("prunes", "RoleBinding")))
+	condition := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionPruneBlocked)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "PruneLimitExceeded", condition.Reason)
+	assert.Contains(t, condition.Message, "RoleBinding api/prunes-devs-edit")
+
+	// Approving fewer deletions than are needed changes nothing
+	rbacDef.Annotations = map[string]string{kube.ApprovePrunesAnnotation: "2"}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assertRoleBindings(t, client, 3)
+
+	rbacDef.Annotations[kube.ApprovePrunesAnnotation] = "3"
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assertRoleBindings(t, client, 0)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.BlockedPrunes.WithLabelValues("prunes", "RoleBinding")))
+	condition = meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionPruneBlocked)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+
+	approved := false
+	for len(recorder.Events) > 0 {
+		event := <-recorder.Events
+		if strings.Contains(event, "PrunesApproved") {
+			approved = true
+			assert.Contains(t, event, "Pruned 3 RoleBindings")
+		}
+	}
+	assert.True(t, approved, "Approved prunes should be summarized in an event")
+
+	ForgetBlockedPrunes("prunes")
+}
+
+func TestPruneBlockedConditionNames(t *testing.T) {
+	r := Reconciler{blocked: map[string][]string{}}
+	for i := 0; i < maxBlockedPruneNames+2; i++ {
+		r.blocked["RoleBinding"] = append(r.blocked["RoleBinding"], "ns/rb")
+	}
+	condition := r.PruneBlockedCondition(1)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, maxBlockedPruneNames, strings.Count(condition.Message, "RoleBinding ns/rb"))
+	assert.True(t, strings.HasSuffix(condition.Message, "and 2 more"))
+}
+
+func assertRoleBindings(t *testing.T, client *fake.Clientset, expected int) {
+	t.Helper()
+	rbs, err := client.RbacV1().RoleBindings("").List(context.TODO(), kube.ListOptions)
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, expected)
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// parsedEntry is an rbacBindings entry of the RBAC Definition being parsed.
+// Resources it creates are named after prefix unless the entry names them.
+type parsedEntry struct {
+	name   string
+	prefix string
+	// empty entries had no subjects left after expanding selectors and
+	// stripping forbidden subjects, so they create nothing
+	empty bool
+}
+
+// noteEntry records an rbacBindings entry so that deletions of resources it
+// no longer creates can be explained
+func (p *Parser) noteEntry(name, prefix string, hasSubjects bool) {
+	for _, entry := range p.entries {
+		if entry.prefix == prefix {
+			return
+		}
+	}
+	p.entries = append(p.entries, parsedEntry{name: name, prefix: prefix, empty: !hasSubjects})
+}
+
+// pruneSources is what the last parse of the RBAC Definition being
+// reconciled requested, used to explain why owned resources are deleted
+type pruneSources struct {
+	entries []parsedEntry
+	// namespaces holds the namespaces each namespaced resource is still
+	// requested in, by kind and name
+	namespaces map[string][]string
+}
+
+func newPruneSources(p *Parser) *pruneSources {
+	sources := &pruneSources{entries: p.entries, namespaces: map[string][]string{}}
+	add := func(kind string, objectMeta *metav1.ObjectMeta) {
+		key := kind + "/" + objectMeta.Name
+		sources.namespaces[key] = append(sources.namespaces[key], objectMeta.Namespace)
+	}
+	for i := range p.parsedRoleBindings {
+		add("RoleBinding", &p.parsedRoleBindings[i].ObjectMeta)
+	}
+	for i := range p.parsedRoles {
+		add("Role", &p.parsedRoles[i].ObjectMeta)
+	}
+	return sources
+}
+
+// entry returns the rbacBindings entry whose name prefix is the longest
+// prefix of name, or nil if the resource wasn't named after any entry
+func (s *pruneSources) entry(name string) *parsedEntry {
+	var found *parsedEntry
+	for i, entry := range s.entries {
+		if name != entry.prefix && !strings.HasPrefix(name, entry.prefix+"-") {
+			continue
+		}
+		if found == nil || len(entry.prefix) > len(found.prefix) {
+			found = &s.entries[i]
+		}
+	}
+	return found
+}
+
+// pruneReason explains why an owned resource is deleted. replaced is true
+// when it is deleted so that a requested resource of the same name can be
+// created, with the drift reason of that resource.
+func (r *Reconciler) pruneReason(kind string, objectMeta *metav1.ObjectMeta, replaced bool, drift string) string {
+	if replaced {
+		if drift != "" {
+			return fmt.Sprintf("replaced, it was %v outside of rbac-manager", drift)
+		}
+		return "replaced, its spec changed"
+	}
+
+	sources := r.pruneSources
+	if sources == nil {
+		return "no longer in spec"
+	}
+	if kind == "ServiceAccount" {
+		return "no longer in spec (no longer a subject of any rbacBindings entry)"
+	}
+
+	var entry *parsedEntry
+	if kind != "Role" {
+		entry = sources.entry(objectMeta.Name)
+	}
+	switch {
+	case entry != nil && entry.empty:
+		return fmt.Sprintf("subjects of rbacBindings entry '%v' are empty (every subject was stripped or matched no Service Accounts)", entry.name)
+	case objectMeta.Namespace != "" && len(sources.namespaces[kind+"/"+objectMeta.Name]) > 0:
+		if entry != nil {
+			return fmt.Sprintf("namespace %v no longer matches rbacBindings entry '%v'", objectMeta.Namespace, entry.name)
+		}
+		return fmt.Sprintf("namespace %v no longer matches", objectMeta.Namespace)
+	case entry != nil:
+		return fmt.Sprintf("no longer in spec (rbacBindings entry '%v' no longer requests it)", entry.name)
+	default:
+		return "no longer in spec (no rbacBindings entry requests it)"
+	}
+}
+
+// prunedEvent records an event explaining why an owned resource was deleted
+func (r *Reconciler) prunedEvent(kind string, objectMeta *metav1.ObjectMeta, reason string) {
+	name := objectMeta.Name
+	if objectMeta.Namespace != "" {
+		name = objectMeta.Namespace + "/" + objectMeta.Name
+	}
+	r.event(v1.EventTypeNormal, "Pruned", "Deleted %v %v: %v", kind, name, reason)
+}
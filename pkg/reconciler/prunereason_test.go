@@ -0,0 +1,98 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestPruneReasons(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{"team": "web"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "api", Labels: map[string]string{"team": "web"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ci"}},
+	)
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "pruned"
+	rbacDef.UID = "pruned-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:     "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "devs"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRoles:      []string{"edit", "view"},
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}},
+		}},
+	}, {
+		Name:                "admins",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "admins"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "admin"}},
+	}, {
+		Name: "bots",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject:  rbacv1.Subject{Kind: rbacmanagerv1beta1.ServiceAccountSelectorKind},
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"bot": "true"}},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+	_, err := client.CoreV1().ServiceAccounts("ci").Create(context.TODO(), &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: "ci", Labels: map[string]string{"bot": "true"}},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	recorder := record.NewFakeRecorder(20)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	for len(recorder.Events) > 0 {
+		<-recorder.Events
+	}
+
+	// The api namespace stops matching, the view role and the admins entry
+	// are removed, and the selected Service Account goes away
+	api, err := client.CoreV1().Namespaces().Get(context.TODO(), "api", metav1.GetOptions{})
+	assert.NoError(t, err)
+	api.Labels = nil
+	_, err = client.CoreV1().Namespaces().Update(context.TODO(), api, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, client.CoreV1().ServiceAccounts("ci").Delete(context.TODO(), "builder", metav1.DeleteOptions{}))
+	rbacDef.RBACBindings[0].RoleBindings[0].ClusterRoles = []string{"edit"}
+	rbacDef.RBACBindings = append(rbacDef.RBACBindings[:1], rbacDef.RBACBindings[2])
+	rbacDef.Generation = 2
+
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	pruned := []string{}
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; strings.Contains(event, " Pruned ") {
+			pruned = append(pruned, event)
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"Normal Pruned Deleted ClusterRoleBinding pruned-admins-admin: no longer in spec (no rbacBindings entry requests it)",
+		"Normal Pruned Deleted ClusterRoleBinding pruned-bots-view: subjects of rbacBindings entry 'bots' are empty (every subject was stripped or matched no Service Accounts)",
+		"Normal Pruned Deleted RoleBinding api/pruned-devs-edit: namespace api no longer matches rbacBindings entry 'devs'",
+		"Normal Pruned Deleted RoleBinding api/pruned-devs-view: no longer in spec (rbacBindings entry 'devs' no longer requests it)",
+		"Normal Pruned Deleted RoleBinding web/pruned-devs-view: no longer in spec (rbacBindings entry 'devs' no longer requests it)",
+	}, pruned)
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// QuotaRetryDelays are how long to wait before each retry of a namespace in
+// which the ResourceQuota or LimitRanger admission plugins rejected creates
+var QuotaRetryDelays = []time.Duration{5 * time.Second, 15 * time.Second, 30 * time.Second}
+
+// NamespaceRetries receives the name of a namespace whenever one of its
+// retries is due, for the namespace controller to reconcile it again
+var NamespaceRetries = make(chan string, 100)
+
+// quotaMessages are parts of the messages the ResourceQuota and LimitRanger
+// admission plugins reject requests with. Quotas of a new namespace reject
+// every create until their usage has been calculated for the first time.
+var quotaMessages = []string{
+	"exceeded quota",
+	"status unknown for quota",
+	"insufficient quota",
+	"limitrange",
+}
+
+// quotaRetries counts the retries each RBAC Definition used in a namespace
+// since one of its creates there last succeeded, keyed by definition and
+// namespace
+var quotaRetries = struct {
+	sync.Mutex
+	used map[string]int
+}{used: map[string]int{}}
+
+// isQuotaDenial reports whether err is a rejection by the ResourceQuota or
+// LimitRanger admission plugins
+func isQuotaDenial(err error) bool {
+	if !apierrors.IsForbidden(err) {
+		return false
+	}
+	message := strings.ToLower(err.Error())
+	for _, part := range quotaMessages {
+		if strings.Contains(message, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// noteQuotaDenial records that a create in namespace was rejected by quota or
+// limit admission during this reconcile
+func (r *Reconciler) noteQuotaDenial(namespace string) {
+	r.quotaMux.Lock()
+	defer r.quotaMux.Unlock()
+	if r.quotaDenied == nil {
+		r.quotaDenied = map[string]bool{}
+	}
+	r.quotaDenied[namespace] = true
+}
+
+// quotaSettled resets the retries in namespace after a create in it succeeded
+func (r *Reconciler) quotaSettled(namespace string) {
+	quotaRetries.Lock()
+	defer quotaRetries.Unlock()
+	delete(quotaRetries.used, r.quotaRetryKey(namespace))
+}
+
+func (r *Reconciler) quotaRetryKey(namespace string) string {
+	if r.rbacDef == nil {
+		return "/" + namespace
+	}
+	return r.rbacDef.Name + "/" + namespace
+}
+
+// retryQuotaDenials schedules a retry of every namespace in which creates
+// were rejected by quota or limit admission during this reconcile. Rejections
+// are expected while a new namespace settles, so they are only returned as
+// an error once a namespace has used all of its retries.
+func (r *Reconciler) retryQuotaDenials() error {
+	r.quotaMux.Lock()
+	defer r.quotaMux.Unlock()
+
+	// Retries go through the namespace controller of the local cluster
+	if len(r.quotaDenied) == 0 || r.Cluster != "" {
+		return nil
+	}
+
+	quotaRetries.Lock()
+	defer quotaRetries.Unlock()
+
+	exhausted := []string{}
+	for namespace := range r.quotaDenied {
+		key := r.quotaRetryKey(namespace)
+		used := quotaRetries.used[key]
+		if used >= len(QuotaRetryDelays) {
+			exhausted = append(exhausted, namespace)
+			continue
+		}
+		quotaRetries.used[key] = used + 1
+
+		delay := QuotaRetryDelays[used]
+		logrus.Infof("Creates in namespace %v were rejected by quota or limit admission, retrying in %v", namespace, delay)
+		namespace := namespace
+		time.AfterFunc(delay, func() { requestNamespaceRetry(namespace) })
+	}
+
+	if len(exhausted) == 0 {
+		return nil
+	}
+	sort.Strings(exhausted)
+	r.event(v1.EventTypeWarning, "QuotaRetriesExhausted", "Creates in namespaces %v are still rejected by quota or limit admission after %d retries",
+		strings.Join(exhausted, ", "), len(QuotaRetryDelays))
+	return fmt.Errorf("creates in namespaces %v were rejected by quota or limit admission after %d retries", strings.Join(exhausted, ", "), len(QuotaRetryDelays))
+}
+
+// requestNamespaceRetry passes namespace on to NamespaceRetries, dropping it
+// if nothing reads them
+func requestNamespaceRetry(namespace string) {
+	select {
+	case NamespaceRetries <- namespace:
+	default:
+		logrus.Warnf("Dropping retry of namespace %v, too many retries are waiting", namespace)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// quotaFixture returns a clientset whose Role Binding creates are rejected by
+// quota admission until failures creates have failed, or always if failures
+// is negative, and a definition binding in its namespace
+func quotaFixture(failures int) (*fake.Clientset, *corev1.Namespace, rbacmanagerv1beta1.RBACDefinition) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "fresh"}}
+	client := fake.NewSimpleClientset(namespace)
+	client.PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failures == 0 {
+			return false, nil, nil
+		}
+		failures--
+		resource := schema.GroupResource{Group: rbacv1.GroupName, Resource: "rolebindings"}
+		return true, nil, apierrors.NewForbidden(resource, "quota-devs-edit", errors.New("status unknown for quota: objects"))
+	})
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "quota"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:         "devs",
+		Subjects:     []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "devs"}}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "fresh"}},
+	}}
+	return client, namespace, rbacDef
+}
+
+func setQuotaRetryDelays(t *testing.T) {
+	delays := QuotaRetryDelays
+	QuotaRetryDelays = []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}
+	quotaRetries.used = map[string]int{}
+	t.Cleanup(func() {
+		QuotaRetryDelays = delays
+		quotaRetries.used = map[string]int{}
+	})
+}
+
+func awaitNamespaceRetry(t *testing.T) string {
+	t.Helper()
+	select {
+	case namespace := <-NamespaceRetries:
+		return namespace
+	case <-time.After(time.Second):
+		t.Fatal("No retry was scheduled")
+		return ""
+	}
+}
+
+func TestQuotaRetries(t *testing.T) {
+	setQuotaRetryDelays(t)
+	client, namespace, rbacDef := quotaFixture(2)
+	r := Reconciler{Clientset: client}
+
+	// The first two creates are rejected while the namespace settles, which
+	// isn't an error yet
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, "fresh", awaitNamespaceRetry(t))
+	assert.NoError(t, r.ReconcileNamespaceChange(&rbacDef, namespace))
+	assert.Equal(t, "fresh", awaitNamespaceRetry(t))
+
+	assert.NoError(t, r.ReconcileNamespaceChange(&rbacDef, namespace))
+	rbs, err := client.RbacV1().RoleBindings("fresh").List(context.TODO(), kube.ListOptions)
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 1)
+	assert.Empty(t, quotaRetries.used, "A successful create should reset the retries")
+
+	select {
+	case namespace := <-NamespaceRetries:
+		t.Errorf("Unexpected retry of namespace %v", namespace)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestQuotaRetriesExhausted(t *testing.T) {
+	setQuotaRetryDelays(t)
+	client, namespace, rbacDef := quotaFixture(-1)
+	r := Reconciler{Clientset: client}
+
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	for i := 1; i < len(QuotaRetryDelays); i++ {
+		awaitNamespaceRetry(t)
+		assert.NoError(t, r.ReconcileNamespaceChange(&rbacDef, namespace))
+	}
+	awaitNamespaceRetry(t)
+
+	err := r.ReconcileNamespaceChange(&rbacDef, namespace)
+	assert.EqualError(t, err, "creates in namespaces fresh were rejected by quota or limit admission after 3 retries")
+}
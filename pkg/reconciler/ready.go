@@ -0,0 +1,97 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// maxReadyMessageLength bounds the message of the Ready condition so that
+// errors aggregated over many namespaces or clusters stay readable
+const maxReadyMessageLength = 1024
+
+// SetReadyCondition records the outcome of a reconcile in the Ready condition
+// and observedGeneration of rbacDef. It must be called after all other
+// conditions have been updated since a resource conflict, a role that may not
+// be bound, a resource denied by a policy, or one rejected in a dry run also
+// marks the definition as not ready, and so do fewer resources in place than requested. A reconcile that took longer than ReconcileTimeout is reported
+// with its own reason. Definitions in report only mode are ready once their
+// planned changes are recorded.
+func SetReadyCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition, reconcileErr error) {
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: rbacDef.Generation,
+		Reason:             "ReconcileSucceeded",
+		Message:            "All requested resources are up to date",
+	}
+
+	conflict := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionResourceConflict)
+	forbidden := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionBindingForbidden)
+	blocked := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionBlockedByPolicy)
+	rejected := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionValidationFailed)
+	if errors.Is(reconcileErr, ErrReconcileTimeout) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ReconcileTimedOut"
+		condition.Message = truncateMessage(reconcileErr.Error(), maxReadyMessageLength)
+	} else if reconcileErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ReconcileFailed"
+		condition.Message = truncateMessage(reconcileErr.Error(), maxReadyMessageLength)
+	} else if rbacDef.SyncMode == rbacmanagerv1beta1.SyncModeReportOnly {
+		condition.Reason = "ReportOnly"
+		condition.Message = fmt.Sprintf("%d changes planned and not applied",
+			len(rbacDef.Status.PlannedChanges)+rbacDef.Status.PlannedChangesOmitted)
+	} else if conflict != nil && conflict.Status == metav1.ConditionTrue {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ResourceConflict"
+		condition.Message = truncateMessage(conflict.Message, maxReadyMessageLength)
+	} else if forbidden != nil && forbidden.Status == metav1.ConditionTrue {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "BindingForbidden"
+		condition.Message = truncateMessage(forbidden.Message, maxReadyMessageLength)
+	} else if blocked != nil && blocked.Status == metav1.ConditionTrue {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "BlockedByPolicy"
+		condition.Message = truncateMessage(blocked.Message, maxReadyMessageLength)
+	} else if rejected != nil && rejected.Status == metav1.ConditionTrue {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ValidationFailed"
+		condition.Message = truncateMessage(rejected.Message, maxReadyMessageLength)
+	} else if missing := missingResources(rbacDef); missing != "" {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ResourcesMissing"
+		condition.Message = missing
+	}
+
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, condition)
+	rbacDef.Status.ObservedGeneration = rbacDef.Generation
+}
+
+// truncateMessage shortens message to at most max runes, marking the cut
+// with an ellipsis
+func truncateMessage(message string, max int) string {
+	runes := []rune(message)
+	if len(runes) <= max {
+		return message
+	}
+	return string(runes[:max-3]) + "..."
+}
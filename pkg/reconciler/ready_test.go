@@ -0,0 +1,72 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestSetReadyCondition(t *testing.T) {
+	rbacDef := &rbacmanagerv1beta1.RBACDefinition{ObjectMeta: metav1.ObjectMeta{Name: "ready", Generation: 3}}
+
+	SetReadyCondition(rbacDef, nil)
+	ready := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionReady)
+	assert.Equal(t, metav1.ConditionTrue, ready.Status)
+	assert.Equal(t, "ReconcileSucceeded", ready.Reason)
+	assert.Equal(t, int64(3), ready.ObservedGeneration)
+	assert.Equal(t, int64(3), rbacDef.Status.ObservedGeneration)
+
+	// A successful reconcile of a newer generation keeps the transition time
+	transitioned := metav1.NewTime(time.Now().Add(-time.Hour))
+	ready.LastTransitionTime = transitioned
+	rbacDef.Generation = 4
+	SetReadyCondition(rbacDef, nil)
+	ready = meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionReady)
+	assert.Equal(t, transitioned, ready.LastTransitionTime)
+	assert.Equal(t, int64(4), ready.ObservedGeneration)
+
+	SetReadyCondition(rbacDef, errors.New("admission webhook denied the request"))
+	ready = meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionReady)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, "ReconcileFailed", ready.Reason)
+	assert.Equal(t, "admission webhook denied the request", ready.Message)
+	assert.NotEqual(t, transitioned, ready.LastTransitionTime)
+
+	SetReadyCondition(rbacDef, errors.New(strings.Repeat("x", 5000)))
+	ready = meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionReady)
+	assert.Len(t, ready.Message, maxReadyMessageLength)
+	assert.True(t, strings.HasSuffix(ready.Message, "..."))
+}
+
+func TestSetReadyConditionConflict(t *testing.T) {
+	rbacDef := &rbacmanagerv1beta1.RBACDefinition{ObjectMeta: metav1.ObjectMeta{Name: "conflict", Generation: 1}}
+	r := Reconciler{conflicts: []string{"RoleBinding/default/existing"}}
+	r.setConflictCondition(rbacDef)
+
+	SetReadyCondition(rbacDef, nil)
+	ready := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionReady)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, "ResourceConflict", ready.Reason)
+	assert.Contains(t, ready.Message, "RoleBinding/default/existing")
+}
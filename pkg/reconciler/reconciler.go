@@ -16,129 +16,263 @@ package reconciler
 
 import (
 	"context"
-	"reflect"
 	"sync"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 	v1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 
 	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
 	"github.com/schlapzz/rbac-manager/pkg/kube"
 	"github.com/schlapzz/rbac-manager/pkg/metrics"
 )
 
+// DefaultParallelism is the number of concurrent create or delete calls a
+// Reconciler issues within a single phase when Parallelism is not set and
+// SetOptions was never called
+var DefaultParallelism = 8
+
 // Reconciler creates and deletes Kubernetes resources to achieve the desired state of an RBAC Definition
 type Reconciler struct {
-	Clientset kubernetes.Interface
-	ownerRefs []metav1.OwnerReference
+	Clientset   kubernetes.Interface
+	Parallelism int
+	// Recorder receives events about the RBAC Definition being reconciled, it may be nil
+	Recorder record.EventRecorder
+	// Cache serves existing resources, DefaultCache is used when it is nil
+	Cache *Cache
+	// GetDefinition fetches other RBAC Definitions, kube.GetRbacDefinition is used when it is nil
+	GetDefinition func(name string) (rbacmanagerv1beta1.RBACDefinition, error)
+	// SkipCache lists existing resources from the API even when a Cache is available
+	SkipCache bool
+	// Cluster names the remote cluster Clientset connects to, it is empty for
+	// the cluster RBAC Manager runs in
+	Cluster string
+	// Trigger is what caused the reconcile, TriggerUnknown is reported when
+	// it is empty
+	Trigger Trigger
+
+	// ctx is the context of the current reconcile, see startTimeout
+	ctx          context.Context
+	rbacDef      *rbacmanagerv1beta1.RBACDefinition
+	conflictsMux sync.Mutex
+	conflicts    []string
+	staleMux     sync.Mutex
+	stale        map[string]bool
+	// bindChecksMux guards bindChecks, the roles checked by bindPermitted
+	// during the current reconcile
+	bindChecksMux sync.Mutex
+	bindChecks    map[string]bindCheck
+	// rejectionsMux guards rejections, the resources whose creates were
+	// rejected in a dry run during the current reconcile
+	rejectionsMux sync.Mutex
+	rejections    map[string]rejection
+	// policySeen are the keys of resources blocked by admission webhooks
+	// that were requested during the current reconcile, guarded by the lock
+	// of PolicyBlocks
+	policySeen map[string]bool
+	// retriesMux guards retries, the number of retries each object used
+	// during the current reconcile
+	retriesMux sync.Mutex
+	retries    map[string]int
+	// repairedMux guards repaired, the resources restored during the current
+	// reconcile
+	repairedMux sync.Mutex
+	repaired    []rbacmanagerv1beta1.DriftResult
+	// unmatchedSelectors are the roleBindings entries whose selectors
+	// matched no namespace, nil unless the RBAC Definition was parsed
+	unmatchedSelectors *[]unmatchedSelector
+	// missingGuardLabels are the roleBindings entries that skipped
+	// namespaces without their required label, nil unless the RBAC
+	// Definition was parsed
+	missingGuardLabels *[]missingGuardLabel
+	// pruneSources is what the last parse requested, used to explain
+	// deletions, nil unless the RBAC Definition was parsed
+	pruneSources *pruneSources
+	// roleMissingChanged is whether the current reconcile changed the
+	// RoleMissingInNamespaces condition
+	roleMissingChanged bool
+	// resultMux guards inPlace and skipped, the keys of requested resources
+	// found or created and of those skipped due to a conflict during the
+	// current reconcile
+	resultMux sync.Mutex
+	inPlace   map[string]bool
+	skipped   map[string]bool
+	// prunesMux guards blocked and approved, the names of resources of each
+	// kind whose deletion the prune limit withheld or the approve-prunes
+	// annotation allowed during the current reconcile
+	prunesMux sync.Mutex
+	blocked   map[string][]string
+	approved  map[string][]string
+	// quotaMux guards quotaDenied, the namespaces in which quota or limit
+	// admission rejected creates during the current reconcile
+	quotaMux    sync.Mutex
+	quotaDenied map[string]bool
 }
 
 var mux = sync.Mutex{}
 
 // ReconcileNamespaceChange reconciles relevant portions of RBAC Definitions
 //   after changes to namespaces within the cluster
-func (r *Reconciler) ReconcileNamespaceChange(rbacDef *rbacmanagerv1beta1.RBACDefinition, namespace *v1.Namespace) error {
+func (r *Reconciler) ReconcileNamespaceChange(rbacDef *rbacmanagerv1beta1.RBACDefinition, namespace *v1.Namespace) (err error) {
 	mux.Lock()
 	defer mux.Unlock()
 
-	r.ownerRefs = rbacDefOwnerRefs(rbacDef)
+	cancel := r.startTimeout()
+	defer func() { err = r.stopTimeout(cancel, err) }()
+
+	// Reconcilers are reused for every RBAC Definition a namespace change
+	// affects, so nothing may be left from the previous one
+	r.rbacDef = nil
+	r.unmatchedSelectors = nil
+	r.missingGuardLabels = nil
+	r.pruneSources = nil
+
+	if !rbacDef.DeletionTimestamp.IsZero() {
+		logrus.Debugf("Skipping namespace change for %v, it is being deleted", rbacDef.Name)
+		return nil
+	}
+
+	if rbacDef.SyncMode == rbacmanagerv1beta1.SyncModeReportOnly {
+		logrus.Debugf("Skipping namespace change for %v, it only reports planned changes", rbacDef.Name)
+		return nil
+	}
+
+	if startGeneration(rbacDef) {
+		logrus.Debugf("Skipping namespace change for generation %v of %v, a newer one has been reconciled", rbacDef.Generation, rbacDef.Name)
+		return nil
+	}
+
+	if !managesNamespace(namespace.Name) {
+		logrus.Debugf("Skipping namespace change for %v, namespace %v is not managed", rbacDef.Name, namespace.Name)
+		return nil
+	}
+
+	r.setDefinition(rbacDef)
 
 	p := Parser{
-		Clientset: r.Clientset,
-		ownerRefs: r.ownerRefs,
+		Clientset:     r.Clientset,
+		GetDefinition: r.GetDefinition,
+		ctx:           r.ctx,
+		ownerRefs:     r.definitionOwnerRefs(),
 	}
 
-	err := p.Parse(*rbacDef)
+	resolved, err := p.resolveImports(*rbacDef)
 	if err != nil {
 		return err
 	}
 
-	err = r.reconcileServiceAccounts(&p.parsedServiceAccounts)
+	serviceAccounts, roleBindings := p.affectedByNamespace(&resolved, namespace)
+	if !serviceAccounts && !roleBindings {
+		logrus.Debugf("Skipping namespace change for %v, it doesn't depend on namespace %v", rbacDef.Name, namespace.Name)
+		return nil
+	}
+
+	err = p.Parse(resolved)
 	if err != nil {
 		return err
 	}
+	r.unmatchedSelectors = &p.unmatchedSelectors
+	r.missingGuardLabels = &p.missingGuardLabels
+	r.pruneSources = newPruneSources(&p)
 
-	if p.hasNamespaceSelectors(rbacDef) {
-		logrus.Infof("Reconciling %v namespace for %v", namespace.Name, rbacDef.Name)
-		err := r.reconcileRoleBindings(&p.parsedRoleBindings)
+	if serviceAccounts {
+		err = r.reconcileServiceAccounts(&p.parsedServiceAccounts, p.boundServiceAccounts)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	if roleBindings {
+		r.changeLog().Infof("Reconciling %v namespace for %v", namespace.Name, rbacDef.Name)
+		err := r.reconcileRoles(&p.parsedRoles)
+		if err != nil {
+			return err
+		}
+		err = r.reconcileRoleBindings(&p.parsedRoleBindings)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := r.retryQuotaDenials(); err != nil {
+		return err
+	}
+	return r.staleError()
 }
 
-// ReconcileOwners reconciles any RBACDefinitions found in owner references
-func (r *Reconciler) ReconcileOwners(ownerRefs []metav1.OwnerReference, kind string) error {
+// Reconcile creates, updates, or deletes Kubernetes resources to match
+//   the desired state defined in an RBAC Definition
+func (r *Reconciler) Reconcile(rbacDef *rbacmanagerv1beta1.RBACDefinition) (err error) {
 	mux.Lock()
 	defer mux.Unlock()
 
-	namespaces, err := r.Clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		logrus.Debug("Error listing namespaces")
-		return err
+	cancel := r.startTimeout()
+	defer func() { err = r.stopTimeout(cancel, err) }()
+
+	if rbacDef.SyncMode == rbacmanagerv1beta1.SyncModeReportOnly {
+		logrus.Debugf("Skipping %v, it only reports planned changes", rbacDef.Name)
+		return nil
 	}
 
-	for _, ownerRef := range ownerRefs {
-		if ownerRef.Kind == "RBACDefinition" {
-			rbacDef, err := kube.GetRbacDefinition(ownerRef.Name)
-			if err != nil {
-				return err
-			}
+	if startGeneration(rbacDef) {
+		logrus.Debugf("Skipping generation %v of %v, a newer one has been reconciled", rbacDef.Generation, rbacDef.Name)
+		return nil
+	}
 
-			r.ownerRefs = rbacDefOwnerRefs(&rbacDef)
+	r.changeLog().Infof("Reconciling RBACDefinition %v", rbacDef.Name)
 
-			p := Parser{
-				Clientset: r.Clientset,
-				ownerRefs: r.ownerRefs,
-			}
+	r.setDefinition(rbacDef)
 
-			if kind == "RoleBinding" {
-				p.parseRoleBindings(&rbacDef, namespaces)
-				return r.reconcileRoleBindings(&p.parsedRoleBindings)
-			} else if kind == "ClusterRoleBinding" {
-				p.parseClusterRoleBindings(&rbacDef)
-				return r.reconcileClusterRoleBindings(&p.parsedClusterRoleBindings)
-			} else if kind == "ServiceAccount" {
-				err := p.Parse(rbacDef)
-				if err != nil {
-					return err
-				}
-				return r.reconcileServiceAccounts(&p.parsedServiceAccounts)
-			}
+	if r.Cluster == "" {
+		err = r.setUnknownFieldsCondition(rbacDef)
+		if err != nil {
+			return err
 		}
 	}
-	return nil
-}
-
-// Reconcile creates, updates, or deletes Kubernetes resources to match
-//   the desired state defined in an RBAC Definition
-func (r *Reconciler) Reconcile(rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
-	mux.Lock()
-	defer mux.Unlock()
-
-	logrus.Infof("Reconciling RBACDefinition %v", rbacDef.Name)
-
-	r.ownerRefs = rbacDefOwnerRefs(rbacDef)
 
 	p := Parser{
-		Clientset: r.Clientset,
-		ownerRefs: r.ownerRefs,
+		Clientset:     r.Clientset,
+		GetDefinition: r.GetDefinition,
+		ctx:           r.ctx,
+		ownerRefs:     r.definitionOwnerRefs(),
 	}
 
-	var err error
-
 	err = p.Parse(*rbacDef)
 	if err != nil {
 		return err
 	}
+	r.pruneSources = newPruneSources(&p)
+
+	// Only full reconciles report stripped subjects so that watch events
+	// don't inflate the count
+	r.reportStrippedSubjects(p.strippedSubjects)
+	r.reportUnknownNamespaceGroups(p.unknownNamespaceGroups)
+	r.reportClusterServiceAccounts(p.clusterServiceAccounts)
+	if r.Cluster == "" {
+		r.setNoNamespacesMatchedCondition(rbacDef, p.unmatchedSelectors)
+		r.setEmptySubjectsCondition(rbacDef, p.entries)
+		r.setGuardLabelMissingCondition(rbacDef, p.missingGuardLabels)
+		r.roleMissingChanged = r.setRoleMissingCondition(rbacDef, p.missingRoles)
+		r.setNamespacesTrimmedCondition(rbacDef, p.trimmedNamespaces)
+		r.recordSpecChange(rbacDef, &p)
+		// Counts are recorded even if reconciling fails part way, so that
+		// they show what is missing
+		defer func() {
+			setResourceCounts(rbacDef, r.reconcileResult(&p))
+			saveState(rbacDef, &p)
+		}()
+	}
 
-	err = r.reconcileServiceAccounts(&p.parsedServiceAccounts)
+	r.createNamespaces(&p.parsedNamespaces)
+
+	err = r.reconcileServiceAccounts(&p.parsedServiceAccounts, p.boundServiceAccounts)
 	if err != nil {
 		return err
 	}
@@ -148,210 +282,765 @@ func (r *Reconciler) Reconcile(rbacDef *rbacmanagerv1beta1.RBACDefinition) error
 		return err
 	}
 
+	err = r.reconcileRoles(&p.parsedRoles)
+	if err != nil {
+		return err
+	}
+
 	err = r.reconcileRoleBindings(&p.parsedRoleBindings)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	r.prunePolicyBlocks()
+
+	// The conflicts of remote clusters are reported in their own status
+	if r.Cluster == "" {
+		r.setConflictCondition(rbacDef)
+		r.setBindingForbiddenCondition(rbacDef)
+		r.setValidationFailedCondition(rbacDef)
+		r.setPolicyCondition(rbacDef)
+		r.setPruneBlockedCondition(rbacDef)
+	}
+
+	if err := r.retryQuotaDenials(); err != nil {
+		return err
+	}
+	return r.staleError()
+}
+
+// serviceAccountUpdate is an existing Service Account to update to the
+// requested Service Account with the given index
+type serviceAccountUpdate struct {
+	existing  v1.ServiceAccount
+	requested int
 }
 
-func (r *Reconciler) reconcileServiceAccounts(requested *[]v1.ServiceAccount) error {
-	existing, err := r.Clientset.CoreV1().ServiceAccounts("").List(context.TODO(), kube.ListOptions)
+// reconcileServiceAccounts creates, updates, and deletes Service Accounts to
+// match requested. Owned Service Accounts that are only bound are released
+// instead of deleted, since something else is expected to own them.
+func (r *Reconciler) reconcileServiceAccounts(requested *[]v1.ServiceAccount, bound map[string]bool) error {
+	requestedKeys := map[string][]int{}
+	for i := range *requested {
+		sa := &(*requested)[i]
+		r.annotate(&sa.ObjectMeta, serviceAccountSpec(sa))
+		key := objectKey("ServiceAccount", &sa.ObjectMeta)
+		requestedKeys[key] = append(requestedKeys[key], i)
+	}
+
+	// Existing Service Accounts are looked at one page at a time, keeping only
+	// those to update or delete and the hashes needed to detect drift
+	matched := make([]bool, len(*requested))
+	ownedSAHashes := map[string]string{}
+	serviceAccountsToDelete := []v1.ServiceAccount{}
+	serviceAccountsToUpdate := []serviceAccountUpdate{}
+	serviceAccountsToRelease := []v1.ServiceAccount{}
+	childUpdates := []childMetadataUpdate{}
+	ownerRepairs := []ownerRefRepair{}
+
+	err := r.eachServiceAccount(func(existingSA *v1.ServiceAccount) {
+		key := objectKey("ServiceAccount", &existingSA.ObjectMeta)
+		owned := r.owns(&existingSA.ObjectMeta)
+		if owned && len(requestedKeys[key]) > 0 {
+			ownedSAHashes[key] = existingSA.Annotations[kube.SpecHashAnnotation]
+			ownerRepairs = r.driftedOwnerRefs(ownerRepairs, "ServiceAccount", existingSA)
+		}
+
+		matchingRequest := false
+		for _, i := range requestedKeys[key] {
+			if saMatches(existingSA, &(*requested)[i]) {
+				if !matchingRequest {
+					childUpdates = staleChildMetadata(childUpdates, "ServiceAccount", existingSA, &(*requested)[i].ObjectMeta)
+				}
+				matched[i] = true
+				matchingRequest = true
+			}
+		}
+
+		if matchingRequest {
+			logrus.Debugf("Matches requested Service Account %v", existingSA.Name)
+		} else if owned && len(requestedKeys[key]) > 0 {
+			// Service Accounts are updated in place rather than created again,
+			// which would invalidate their tokens
+			i := requestedKeys[key][0]
+			matched[i] = true
+			serviceAccountsToUpdate = append(serviceAccountsToUpdate, serviceAccountUpdate{existing: *existingSA, requested: i})
+		} else if owned && bound[key] {
+			serviceAccountsToRelease = append(serviceAccountsToRelease, *existingSA)
+		} else if owned {
+			serviceAccountsToDelete = append(serviceAccountsToDelete, *existingSA)
+		}
+	})
 	if err != nil {
 		return err
 	}
+	r.repairOwnerRefs(ownerRepairs)
+	r.updateChildMetadata(childUpdates)
 
-	matchingServiceAccounts := []v1.ServiceAccount{}
 	serviceAccountsToCreate := []v1.ServiceAccount{}
+	serviceAccountDrift := []string{}
+	updating := map[int]bool{}
+	for _, update := range serviceAccountsToUpdate {
+		updating[update.requested] = true
+	}
 
-	for _, requestedSA := range *requested {
-		alreadyExists := false
-		for _, existingSA := range existing.Items {
-			if saMatches(&existingSA, &requestedSA) {
-				alreadyExists = true
-				matchingServiceAccounts = append(matchingServiceAccounts, existingSA)
-				break
-			}
+	for i := range *requested {
+		requestedSA := &(*requested)[i]
+		if updating[i] {
+			continue
 		}
-
-		if !alreadyExists {
-			serviceAccountsToCreate = append(serviceAccountsToCreate, requestedSA)
+		if !matched[i] {
+			serviceAccountsToCreate = append(serviceAccountsToCreate, *requestedSA)
+			serviceAccountDrift = append(serviceAccountDrift, r.driftReason("ServiceAccount", &requestedSA.ObjectMeta, ownedSAHashes))
 		} else {
+			r.recordApplied("ServiceAccount", &requestedSA.ObjectMeta)
 			logrus.Debugf("Service Account already exists %v", requestedSA.Name)
 		}
 	}
 
-	for _, existingSA := range existing.Items {
-		if reflect.DeepEqual(existingSA.ObjectMeta.OwnerReferences, r.ownerRefs) {
-			matchingRequest := false
-			for _, matchingSA := range matchingServiceAccounts {
-				if saMatches(&existingSA, &matchingSA) {
-					matchingRequest = true
-					break
-				}
-			}
+	// Deleting a modified resource so it can be created again repairs drift too
+	serviceAccountDriftByKey := map[string]string{}
+	for i := range serviceAccountsToCreate {
+		serviceAccountDriftByKey[objectKey("ServiceAccount", &serviceAccountsToCreate[i].ObjectMeta)] = serviceAccountDrift[i]
+	}
 
-			if !matchingRequest {
-				logrus.Infof("Deleting Service Account %v", existingSA.Name)
-				err := r.Clientset.CoreV1().ServiceAccounts(existingSA.Namespace).Delete(context.TODO(), existingSA.Name, metav1.DeleteOptions{})
-				if err != nil {
-					logrus.Infof("Error deleting Service Account: %v", err)
-					metrics.ErrorCounter.Inc()
-				} else {
-					metrics.ChangeCounter.WithLabelValues("serviceaccounts", "delete").Inc()
-				}
-			} else {
-				logrus.Debugf("Matches requested Service Account %v", existingSA.Name)
+	// Service Accounts that aren't created again are pruned, which the prune
+	// limit may withhold
+	prunedSAs := []*metav1.ObjectMeta{}
+	for i := range serviceAccountsToDelete {
+		if _, replaced := serviceAccountDriftByKey[objectKey("ServiceAccount", &serviceAccountsToDelete[i].ObjectMeta)]; !replaced {
+			prunedSAs = append(prunedSAs, &serviceAccountsToDelete[i].ObjectMeta)
+		}
+	}
+	if !r.allowPrunes("ServiceAccount", prunedSAs) {
+		replacedSAs := []v1.ServiceAccount{}
+		for _, existingSA := range serviceAccountsToDelete {
+			if _, replaced := serviceAccountDriftByKey[objectKey("ServiceAccount", &existingSA.ObjectMeta)]; replaced {
+				replacedSAs = append(replacedSAs, existingSA)
 			}
 		}
+		serviceAccountsToDelete = replacedSAs
 	}
 
-	for _, serviceAccountToCreate := range serviceAccountsToCreate {
-		logrus.Infof("Creating Service Account: %v", serviceAccountToCreate.Name)
-		_, err := r.Clientset.CoreV1().ServiceAccounts(serviceAccountToCreate.ObjectMeta.Namespace).Create(context.TODO(), &serviceAccountToCreate, metav1.CreateOptions{})
+	r.validateCreates("ServiceAccount", len(serviceAccountsToCreate), func(i int) *metav1.ObjectMeta {
+		return &serviceAccountsToCreate[i].ObjectMeta
+	}, func(i int) error {
+		_, err := r.Clientset.CoreV1().ServiceAccounts(serviceAccountsToCreate[i].Namespace).Create(r.context(), &serviceAccountsToCreate[i], dryRunCreate())
+		return err
+	})
+
+	r.forEach(len(serviceAccountsToRelease), func(i int) {
+		existingSA := &serviceAccountsToRelease[i]
+		r.orphanObjectMeta(&existingSA.ObjectMeta)
+		err := r.write("ServiceAccount", "orphan", &existingSA.ObjectMeta, func() error {
+			_, err := r.Clientset.CoreV1().ServiceAccounts(existingSA.Namespace).Update(r.context(), existingSA, kube.UpdateOptions)
+			return err
+		})
+		if apierrors.IsNotFound(err) {
+			logrus.Debugf("Service Account %v was deleted before it could be released", existingSA.Name)
+			return
+		}
+		if r.recordOrphan("ServiceAccount", "serviceaccounts", &existingSA.ObjectMeta, err) == nil {
+			r.forgetApplied("ServiceAccount", &existingSA.ObjectMeta)
+		}
+	})
+
+	r.forEach(len(serviceAccountsToUpdate), func(i int) {
+		existingSA := &serviceAccountsToUpdate[i].existing
+		requestedSA := &(*requested)[serviceAccountsToUpdate[i].requested]
+		if r.heldByPolicy("ServiceAccount", &requestedSA.ObjectMeta) {
+			return
+		}
+		drift := r.driftReason("ServiceAccount", &requestedSA.ObjectMeta, ownedSAHashes)
+		patch, err := serviceAccountPatch(existingSA, requestedSA)
 		if err != nil {
+			logrus.Errorf("Error updating Service Account: %v", err)
+			metrics.ErrorCounter.Inc()
+			return
+		}
+		r.changeLog().Infof("Updating Service Account %v", existingSA.Name)
+		err = r.write("ServiceAccount", "update", &requestedSA.ObjectMeta, func() error {
+			_, err := r.Clientset.CoreV1().ServiceAccounts(existingSA.Namespace).Patch(r.context(), existingSA.Name, types.StrategicMergePatchType, patch, kube.PatchOptions)
+			return err
+		})
+		if apierrors.IsNotFound(err) {
+			logrus.Debugf("Service Account %v was deleted before it could be updated", existingSA.Name)
+		} else if r.deniedByPolicy("ServiceAccount", &requestedSA.ObjectMeta, err) {
+			return
+		} else if err != nil {
+			logrus.Errorf("Error updating Service Account: %v", err)
+			metrics.ErrorCounter.Inc()
+		} else {
+			r.recordApplied("ServiceAccount", &requestedSA.ObjectMeta)
+			metrics.ChangeCounter.WithLabelValues("serviceaccounts", "update").Inc()
+			r.recordChange("ServiceAccount", "update", changeCause(drift))
+			if drift != "" {
+				r.repairedDrift("ServiceAccount", &requestedSA.ObjectMeta, drift)
+			}
+		}
+	})
+
+	r.forEach(len(serviceAccountsToDelete), func(i int) {
+		existingSA := &serviceAccountsToDelete[i]
+		drift, replaced := serviceAccountDriftByKey[objectKey("ServiceAccount", &existingSA.ObjectMeta)]
+		if replaced && r.rejectedByDryRun("ServiceAccount", &existingSA.ObjectMeta) {
+			return
+		}
+		reason := r.pruneReason("ServiceAccount", &existingSA.ObjectMeta, replaced, drift)
+		r.changeLog().Infof("Deleting Service Account %v: %v", existingSA.Name, reason)
+		err := r.write("ServiceAccount", "delete", &existingSA.ObjectMeta, func() error {
+			return r.Clientset.CoreV1().ServiceAccounts(existingSA.Namespace).Delete(r.context(), existingSA.Name, deleteOptions(&existingSA.ObjectMeta))
+		})
+		if apierrors.IsConflict(err) {
+			r.skipStaleDelete("ServiceAccount", &existingSA.ObjectMeta)
+		} else if apierrors.IsNotFound(err) {
+			r.forgetApplied("ServiceAccount", &existingSA.ObjectMeta)
+			logrus.Debugf("Service Account %v was already deleted", existingSA.Name)
+		} else if err != nil {
+			logrus.Infof("Error deleting Service Account: %v", err)
+			metrics.ErrorCounter.Inc()
+		} else {
+			r.forgetApplied("ServiceAccount", &existingSA.ObjectMeta)
+			deleteTokenSecrets(r.context(), r.Clientset, existingSA)
+			metrics.ChangeCounter.WithLabelValues("serviceaccounts", "delete").Inc()
+			r.recordChange("ServiceAccount", "delete", changeCause(drift))
+			if !replaced {
+				r.prunedEvent("ServiceAccount", &existingSA.ObjectMeta, reason)
+			}
+		}
+	})
+	r.prunedApproved("ServiceAccount")
+
+	r.forEach(len(serviceAccountsToCreate), func(i int) {
+		serviceAccountToCreate := &serviceAccountsToCreate[i]
+		if r.skippedDelete("ServiceAccount", &serviceAccountToCreate.ObjectMeta) || r.heldByPolicy("ServiceAccount", &serviceAccountToCreate.ObjectMeta) ||
+			r.rejectedByDryRun("ServiceAccount", &serviceAccountToCreate.ObjectMeta) {
+			return
+		}
+		r.changeLog().Infof("Creating Service Account: %v", serviceAccountToCreate.Name)
+		err := r.write("ServiceAccount", "create", &serviceAccountToCreate.ObjectMeta, func() error {
+			_, err := r.Clientset.CoreV1().ServiceAccounts(serviceAccountToCreate.ObjectMeta.Namespace).Create(r.context(), serviceAccountToCreate, kube.CreateOptions)
+			return err
+		})
+		if apierrors.IsAlreadyExists(err) {
+			r.resolveConflict("ServiceAccount", &serviceAccountToCreate.ObjectMeta, func() (metav1.Object, error) {
+				return r.Clientset.CoreV1().ServiceAccounts(serviceAccountToCreate.Namespace).Get(r.context(), serviceAccountToCreate.Name, metav1.GetOptions{})
+			}, func(existing metav1.Object) error {
+				return r.adoptServiceAccount(existing.(*v1.ServiceAccount), serviceAccountToCreate)
+			})
+		} else if r.deniedByPolicy("ServiceAccount", &serviceAccountToCreate.ObjectMeta, err) {
+			return
+		} else if err != nil {
 			logrus.Errorf("Error creating Service Account: %v", err)
 			metrics.ErrorCounter.Inc()
 		} else {
+			r.recordApplied("ServiceAccount", &serviceAccountToCreate.ObjectMeta)
 			metrics.ChangeCounter.WithLabelValues("serviceaccounts", "create").Inc()
+			r.recordChange("ServiceAccount", "create", changeCause(serviceAccountDrift[i]))
+			if serviceAccountDrift[i] != "" {
+				r.repairedDrift("ServiceAccount", &serviceAccountToCreate.ObjectMeta, serviceAccountDrift[i])
+			}
 		}
-	}
+	})
 
 	return nil
 }
 
 func (r *Reconciler) reconcileClusterRoleBindings(requested *[]rbacv1.ClusterRoleBinding) error {
-	existing, err := r.Clientset.RbacV1().ClusterRoleBindings().List(context.TODO(), kube.ListOptions)
+	requestedKeys := map[string][]int{}
+	for i := range *requested {
+		crb := &(*requested)[i]
+		r.annotate(&crb.ObjectMeta, bindingSpec(crb.RoleRef, crb.Subjects))
+		key := objectKey("ClusterRoleBinding", &crb.ObjectMeta)
+		requestedKeys[key] = append(requestedKeys[key], i)
+	}
+
+	// Existing Cluster Role Bindings are looked at one page at a time, keeping only
+	// those to delete and the hashes needed to detect drift
+	matched := make([]bool, len(*requested))
+	ownedCRBHashes := map[string]string{}
+	clusterRoleBindingsToDelete := []rbacv1.ClusterRoleBinding{}
+	childUpdates := []childMetadataUpdate{}
+	ownerRepairs := []ownerRefRepair{}
+	subjectUpdates := []subjectUpdate{}
+	fieldClaims := []fieldClaim{}
+	// pendingDeletes are requested Cluster Role Bindings still marked for deletion
+	pendingDeletes := []*rbacv1.ClusterRoleBinding{}
+
+	err := r.eachClusterRoleBinding(func(existingCRB *rbacv1.ClusterRoleBinding) {
+		key := objectKey("ClusterRoleBinding", &existingCRB.ObjectMeta)
+		owned := r.owns(&existingCRB.ObjectMeta)
+		if owned && len(requestedKeys[key]) > 0 {
+			ownedCRBHashes[key] = existingCRB.Annotations[kube.SpecHashAnnotation]
+			ownerRepairs = r.driftedOwnerRefs(ownerRepairs, "ClusterRoleBinding", existingCRB)
+		}
+
+		matchingRequest := false
+		for _, i := range requestedKeys[key] {
+			if crbMatches(existingCRB, &(*requested)[i]) {
+				if !matchingRequest {
+					childUpdates = staleChildMetadata(childUpdates, "ClusterRoleBinding", existingCRB, &(*requested)[i].ObjectMeta)
+				}
+				matched[i] = true
+				matchingRequest = true
+			}
+		}
+
+		if matchingRequest {
+			logrus.Debugf("Matches requested Cluster Role Binding %v", existingCRB.Name)
+			if owned {
+				pendingDeletes = pendingDelete(pendingDeletes, existingCRB)
+				fieldClaims = r.contestedFields(fieldClaims, "ClusterRoleBinding", existingCRB)
+			}
+		} else if owned && len(requestedKeys[key]) == 1 && subjectsOnlyChanged(existingCRB, &(*requested)[requestedKeys[key][0]]) {
+			subjectUpdates = append(subjectUpdates, subjectUpdate{index: requestedKeys[key][0], existing: existingCRB.DeepCopy()})
+		} else if owned {
+			clusterRoleBindingsToDelete = append(clusterRoleBindingsToDelete, *existingCRB)
+		}
+	})
 	if err != nil {
 		metrics.ErrorCounter.Inc()
 		return err
 	}
 
-	matchingClusterRoleBindings := []rbacv1.ClusterRoleBinding{}
+	// Bindings whose subjects can't be updated in place are replaced
+	for i, updated := range r.updateSubjects(subjectUpdates, *requested) {
+		update := subjectUpdates[i]
+		if updated {
+			matched[update.index] = true
+			childUpdates = staleChildMetadata(childUpdates, "ClusterRoleBinding", update.existing, &(*requested)[update.index].ObjectMeta)
+			pendingDeletes = pendingDelete(pendingDeletes, update.existing)
+		} else {
+			clusterRoleBindingsToDelete = append(clusterRoleBindingsToDelete, *update.existing)
+		}
+	}
+	r.repairOwnerRefs(ownerRepairs)
+	r.claimFields(fieldClaims)
+	r.updateChildMetadata(childUpdates)
+	r.cancelPendingDeletes(pendingDeletes)
+
 	clusterRoleBindingsToCreate := []rbacv1.ClusterRoleBinding{}
+	clusterRoleBindingDrift := []string{}
 
-	for _, requestedCRB := range *requested {
-		alreadyExists := false
-		for _, existingCRB := range existing.Items {
-			if crbMatches(&existingCRB, &requestedCRB) {
-				alreadyExists = true
-				matchingClusterRoleBindings = append(matchingClusterRoleBindings, existingCRB)
-				break
-			}
+	for i := range *requested {
+		requestedCRB := &(*requested)[i]
+		if !matched[i] && !r.crbPermitted(requestedCRB) {
+			continue
 		}
 
-		if !alreadyExists {
-			clusterRoleBindingsToCreate = append(clusterRoleBindingsToCreate, requestedCRB)
+		if !matched[i] {
+			clusterRoleBindingsToCreate = append(clusterRoleBindingsToCreate, *requestedCRB)
+			clusterRoleBindingDrift = append(clusterRoleBindingDrift, r.driftReason("ClusterRoleBinding", &requestedCRB.ObjectMeta, ownedCRBHashes))
 		} else {
+			r.recordApplied("ClusterRoleBinding", &requestedCRB.ObjectMeta)
 			logrus.Debugf("Cluster Role Binding already exists %v", requestedCRB.Name)
 		}
 	}
 
-	for _, existingCRB := range existing.Items {
-		if reflect.DeepEqual(existingCRB.OwnerReferences, r.ownerRefs) {
-			matchingRequest := false
-			for _, requestedCRB := range matchingClusterRoleBindings {
-				if crbMatches(&existingCRB, &requestedCRB) {
-					matchingRequest = true
-					break
-				}
-			}
+	// Deleting a modified resource so it can be created again repairs drift too
+	clusterRoleBindingDriftByKey := map[string]string{}
+	for i := range clusterRoleBindingsToCreate {
+		clusterRoleBindingDriftByKey[objectKey("ClusterRoleBinding", &clusterRoleBindingsToCreate[i].ObjectMeta)] = clusterRoleBindingDrift[i]
+	}
 
-			if !matchingRequest {
-				logrus.Infof("Deleting Cluster Role Binding: %v", existingCRB.Name)
-				err := r.Clientset.RbacV1().ClusterRoleBindings().Delete(context.TODO(), existingCRB.Name, metav1.DeleteOptions{})
-				if err != nil {
-					logrus.Errorf("Error deleting Cluster Role Binding: %v", err)
-					metrics.ErrorCounter.Inc()
-				} else {
-					metrics.ChangeCounter.WithLabelValues("clusterrolebindings", "delete").Inc()
-				}
-			} else {
-				logrus.Debugf("Matches requested Cluster Role Binding: %v", existingCRB.Name)
+	deleteCRB := func(existingCRB *rbacv1.ClusterRoleBinding) {
+		drift, replaced := clusterRoleBindingDriftByKey[objectKey("ClusterRoleBinding", &existingCRB.ObjectMeta)]
+		reason := r.pruneReason("ClusterRoleBinding", &existingCRB.ObjectMeta, replaced, drift)
+		r.changeLog().Infof("Deleting Cluster Role Binding %v: %v", existingCRB.Name, reason)
+		err := r.write("ClusterRoleBinding", "delete", &existingCRB.ObjectMeta, func() error {
+			return kube.RBAC(r.Clientset).ClusterRoleBindings().Delete(r.context(), existingCRB.Name, deleteOptions(&existingCRB.ObjectMeta))
+		})
+		if apierrors.IsConflict(err) {
+			r.skipStaleDelete("ClusterRoleBinding", &existingCRB.ObjectMeta)
+		} else if apierrors.IsNotFound(err) {
+			r.forgetApplied("ClusterRoleBinding", &existingCRB.ObjectMeta)
+			logrus.Debugf("Cluster Role Binding %v was already deleted", existingCRB.Name)
+		} else if err != nil {
+			logrus.Errorf("Error deleting Cluster Role Binding: %v", err)
+			metrics.ErrorCounter.Inc()
+		} else {
+			r.forgetApplied("ClusterRoleBinding", &existingCRB.ObjectMeta)
+			metrics.ChangeCounter.WithLabelValues("clusterrolebindings", "delete").Inc()
+			r.recordChange("ClusterRoleBinding", "delete", changeCause(drift))
+			if !replaced {
+				r.prunedEvent("ClusterRoleBinding", &existingCRB.ObjectMeta, reason)
 			}
 		}
 	}
 
-	for _, clusterRoleBindingToCreate := range clusterRoleBindingsToCreate {
-		logrus.Infof("Creating Cluster Role Binding: %v", clusterRoleBindingToCreate.Name)
-		_, err := r.Clientset.RbacV1().ClusterRoleBindings().Create(context.TODO(), &clusterRoleBindingToCreate, metav1.CreateOptions{})
-		if err != nil {
+	r.validateCreates("ClusterRoleBinding", len(clusterRoleBindingsToCreate), func(i int) *metav1.ObjectMeta {
+		return &clusterRoleBindingsToCreate[i].ObjectMeta
+	}, func(i int) error {
+		_, err := kube.RBAC(r.Clientset).ClusterRoleBindings().Create(r.context(), &clusterRoleBindingsToCreate[i], dryRunCreate())
+		return err
+	})
+
+	replacedCRBs, prunedCRBs := splitCRBs(clusterRoleBindingsToDelete, clusterRoleBindingsToCreate)
+	r.forEach(len(replacedCRBs), func(i int) {
+		// Keep what a rejected binding would replace
+		if r.rejectedByDryRun("ClusterRoleBinding", &replacedCRBs[i].ObjectMeta) {
+			return
+		}
+		deleteCRB(&replacedCRBs[i])
+	})
+
+	r.forEach(len(clusterRoleBindingsToCreate), func(i int) {
+		clusterRoleBindingToCreate := &clusterRoleBindingsToCreate[i]
+		if r.skippedDelete("ClusterRoleBinding", &clusterRoleBindingToCreate.ObjectMeta) || r.heldByPolicy("ClusterRoleBinding", &clusterRoleBindingToCreate.ObjectMeta) ||
+			r.rejectedByDryRun("ClusterRoleBinding", &clusterRoleBindingToCreate.ObjectMeta) {
+			return
+		}
+		r.changeLog().Infof("Creating Cluster Role Binding: %v", clusterRoleBindingToCreate.Name)
+		err := r.write("ClusterRoleBinding", "create", &clusterRoleBindingToCreate.ObjectMeta, func() error {
+			_, err := kube.RBAC(r.Clientset).ClusterRoleBindings().Create(r.context(), clusterRoleBindingToCreate, kube.CreateOptions)
+			return err
+		})
+		if apierrors.IsAlreadyExists(err) {
+			r.resolveConflict("ClusterRoleBinding", &clusterRoleBindingToCreate.ObjectMeta, func() (metav1.Object, error) {
+				return kube.RBAC(r.Clientset).ClusterRoleBindings().Get(r.context(), clusterRoleBindingToCreate.Name, metav1.GetOptions{})
+			}, func(existing metav1.Object) error {
+				return r.adoptClusterRoleBinding(existing.(*rbacv1.ClusterRoleBinding), clusterRoleBindingToCreate)
+			})
+		} else if r.deniedByPolicy("ClusterRoleBinding", &clusterRoleBindingToCreate.ObjectMeta, err) {
+			return
+		} else if err != nil {
 			logrus.Errorf("Error creating Cluster Role Binding: %v", err)
 			metrics.ErrorCounter.Inc()
 		} else {
+			r.recordApplied("ClusterRoleBinding", &clusterRoleBindingToCreate.ObjectMeta)
 			metrics.ChangeCounter.WithLabelValues("clusterrolebindings", "create").Inc()
+			r.recordChange("ClusterRoleBinding", "create", changeCause(clusterRoleBindingDrift[i]))
+			if clusterRoleBindingDrift[i] != "" {
+				r.repairedDrift("ClusterRoleBinding", &clusterRoleBindingToCreate.ObjectMeta, clusterRoleBindingDrift[i])
+			}
 		}
+	})
+
+	// Cluster wide access is only pruned once the grace period passed
+	prunedCRBs = r.expiredPendingDeletes(prunedCRBs)
+	prunedCRBMeta := []*metav1.ObjectMeta{}
+	for i := range prunedCRBs {
+		prunedCRBMeta = append(prunedCRBMeta, &prunedCRBs[i].ObjectMeta)
 	}
+	if !r.allowPrunes("ClusterRoleBinding", prunedCRBMeta) {
+		return nil
+	}
+
+	r.forEach(len(prunedCRBs), func(i int) {
+		deleteCRB(&prunedCRBs[i])
+	})
+	r.prunedApproved("ClusterRoleBinding")
 
 	return nil
 }
 
 func (r *Reconciler) reconcileRoleBindings(requested *[]rbacv1.RoleBinding) error {
-	existing, err := r.Clientset.RbacV1().RoleBindings("").List(context.TODO(), kube.ListOptions)
+	requestedKeys := map[string][]int{}
+	for i := range *requested {
+		rb := &(*requested)[i]
+		r.annotate(&rb.ObjectMeta, bindingSpec(rb.RoleRef, rb.Subjects))
+		key := objectKey("RoleBinding", &rb.ObjectMeta)
+		requestedKeys[key] = append(requestedKeys[key], i)
+	}
+
+	// Existing Role Bindings are looked at one page at a time, keeping only
+	// those to delete and the hashes needed to detect drift
+	matched := make([]bool, len(*requested))
+	ownedRBHashes := map[string]string{}
+	roleBindingsToDelete := []rbacv1.RoleBinding{}
+	childUpdates := []childMetadataUpdate{}
+	ownerRepairs := []ownerRefRepair{}
+	fieldClaims := []fieldClaim{}
+	// managedRBs counts the Role Bindings per namespace bulkPruneSelector matches
+	managedRBs := map[string]int{}
+
+	err := r.eachRoleBinding(func(existingRB *rbacv1.RoleBinding) {
+		key := objectKey("RoleBinding", &existingRB.ObjectMeta)
+		owned := r.owns(&existingRB.ObjectMeta)
+		if _, grant := existingRB.Labels[kube.GrantLabelKey]; !grant {
+			managedRBs[existingRB.Namespace]++
+		}
+		if owned && len(requestedKeys[key]) > 0 {
+			ownedRBHashes[key] = existingRB.Annotations[kube.SpecHashAnnotation]
+			ownerRepairs = r.driftedOwnerRefs(ownerRepairs, "RoleBinding", existingRB)
+		}
+
+		matchingRequest := false
+		for _, i := range requestedKeys[key] {
+			if rbMatches(existingRB, &(*requested)[i]) {
+				if !matchingRequest {
+					childUpdates = staleChildMetadata(childUpdates, "RoleBinding", existingRB, &(*requested)[i].ObjectMeta)
+				}
+				matched[i] = true
+				matchingRequest = true
+			}
+		}
+
+		if matchingRequest {
+			logrus.Debugf("Matches requested Role Binding %v", existingRB.Name)
+			if owned {
+				fieldClaims = r.contestedFields(fieldClaims, "RoleBinding", existingRB)
+			}
+		} else if owned {
+			roleBindingsToDelete = append(roleBindingsToDelete, *existingRB)
+		}
+	})
 	if err != nil {
 		return err
 	}
+	r.repairOwnerRefs(ownerRepairs)
+	r.claimFields(fieldClaims)
+	r.updateChildMetadata(childUpdates)
 
-	matchingRoleBindings := []rbacv1.RoleBinding{}
 	roleBindingsToCreate := []rbacv1.RoleBinding{}
+	roleBindingDrift := []string{}
 
-	for _, requestedRB := range *requested {
-		alreadyExists := false
-		for _, existingRB := range existing.Items {
-			if rbMatches(&existingRB, &requestedRB) {
-				alreadyExists = true
-				matchingRoleBindings = append(matchingRoleBindings, existingRB)
-				break
-			}
+	for i := range *requested {
+		requestedRB := &(*requested)[i]
+		if !matched[i] && !r.rbPermitted(requestedRB) {
+			continue
 		}
 
-		if !alreadyExists {
-			roleBindingsToCreate = append(roleBindingsToCreate, requestedRB)
+		if !matched[i] {
+			roleBindingsToCreate = append(roleBindingsToCreate, *requestedRB)
+			roleBindingDrift = append(roleBindingDrift, r.driftReason("RoleBinding", &requestedRB.ObjectMeta, ownedRBHashes))
 		} else {
+			r.recordApplied("RoleBinding", &requestedRB.ObjectMeta)
 			logrus.Debugf("Role Binding already exists %v", requestedRB.Name)
 		}
 	}
 
-	for _, existingRB := range existing.Items {
-		if reflect.DeepEqual(existingRB.OwnerReferences, r.ownerRefs) {
-			matchingRequest := false
-			for _, requestedRB := range matchingRoleBindings {
-				if rbMatches(&existingRB, &requestedRB) {
-					matchingRequest = true
-					break
-				}
-			}
+	// Deleting a modified resource so it can be created again repairs drift too
+	roleBindingDriftByKey := map[string]string{}
+	for i := range roleBindingsToCreate {
+		roleBindingDriftByKey[objectKey("RoleBinding", &roleBindingsToCreate[i].ObjectMeta)] = roleBindingDrift[i]
+	}
 
-			if !matchingRequest {
-				logrus.Infof("Deleting Role Binding %v", existingRB.Name)
-				err := r.Clientset.RbacV1().RoleBindings(existingRB.Namespace).Delete(context.TODO(), existingRB.Name, metav1.DeleteOptions{})
-				if err != nil {
-					logrus.Infof("Error deleting Role Binding: %v", err)
-					metrics.ErrorCounter.Inc()
-				} else {
-					metrics.ChangeCounter.WithLabelValues("rolebindings", "delete").Inc()
-				}
-			} else {
-				logrus.Debugf("Matches requested Role Binding %v", existingRB.Name)
-			}
+	roleBindingPruneReason := func(existingRB *rbacv1.RoleBinding) (string, string, bool) {
+		drift, replaced := roleBindingDriftByKey[objectKey("RoleBinding", &existingRB.ObjectMeta)]
+		return r.pruneReason("RoleBinding", &existingRB.ObjectMeta, replaced, drift), drift, replaced
+	}
+
+	deletedRB := func(existingRB *rbacv1.RoleBinding) {
+		reason, drift, replaced := roleBindingPruneReason(existingRB)
+		r.forgetApplied("RoleBinding", &existingRB.ObjectMeta)
+		metrics.ChangeCounter.WithLabelValues("rolebindings", "delete").Inc()
+		r.recordChange("RoleBinding", "delete", changeCause(drift))
+		r.namespaceEvent("AccessRevoked", existingRB)
+		if !replaced {
+			r.prunedEvent("RoleBinding", &existingRB.ObjectMeta, reason)
 		}
 	}
 
-	for _, roleBindingToCreate := range roleBindingsToCreate {
-		logrus.Infof("Creating Role Binding: %v", roleBindingToCreate.Name)
-		_, err := r.Clientset.RbacV1().RoleBindings(roleBindingToCreate.ObjectMeta.Namespace).Create(context.TODO(), &roleBindingToCreate, metav1.CreateOptions{})
-		if err != nil {
+	deleteRB := func(existingRB *rbacv1.RoleBinding) {
+		reason, _, _ := roleBindingPruneReason(existingRB)
+		r.changeLog().Infof("Deleting Role Binding %v/%v: %v", existingRB.Namespace, existingRB.Name, reason)
+		err := r.write("RoleBinding", "delete", &existingRB.ObjectMeta, func() error {
+			return kube.RBAC(r.Clientset).RoleBindings(existingRB.Namespace).Delete(r.context(), existingRB.Name, deleteOptions(&existingRB.ObjectMeta))
+		})
+		if apierrors.IsConflict(err) {
+			r.skipStaleDelete("RoleBinding", &existingRB.ObjectMeta)
+		} else if apierrors.IsNotFound(err) {
+			r.forgetApplied("RoleBinding", &existingRB.ObjectMeta)
+			logrus.Debugf("Role Binding %v was already deleted", existingRB.Name)
+		} else if err != nil {
+			logrus.Infof("Error deleting Role Binding: %v", err)
+			metrics.ErrorCounter.Inc()
+		} else {
+			deletedRB(existingRB)
+		}
+	}
+
+	r.validateCreates("RoleBinding", len(roleBindingsToCreate), func(i int) *metav1.ObjectMeta {
+		return &roleBindingsToCreate[i].ObjectMeta
+	}, func(i int) error {
+		_, err := kube.RBAC(r.Clientset).RoleBindings(roleBindingsToCreate[i].Namespace).Create(r.context(), &roleBindingsToCreate[i], dryRunCreate())
+		return err
+	})
+
+	replacedRBs, prunedRBs := splitRBs(roleBindingsToDelete, roleBindingsToCreate)
+	r.forEach(len(replacedRBs), func(i int) {
+		// Keep what a rejected binding would replace
+		if r.rejectedByDryRun("RoleBinding", &replacedRBs[i].ObjectMeta) {
+			return
+		}
+		deleteRB(&replacedRBs[i])
+	})
+
+	r.forEach(len(roleBindingsToCreate), func(i int) {
+		roleBindingToCreate := &roleBindingsToCreate[i]
+		if r.skippedDelete("RoleBinding", &roleBindingToCreate.ObjectMeta) || r.heldByPolicy("RoleBinding", &roleBindingToCreate.ObjectMeta) ||
+			r.rejectedByDryRun("RoleBinding", &roleBindingToCreate.ObjectMeta) {
+			return
+		}
+		r.changeLog().Infof("Creating Role Binding: %v", roleBindingToCreate.Name)
+		err := r.write("RoleBinding", "create", &roleBindingToCreate.ObjectMeta, func() error {
+			_, err := kube.RBAC(r.Clientset).RoleBindings(roleBindingToCreate.ObjectMeta.Namespace).Create(r.context(), roleBindingToCreate, kube.CreateOptions)
+			return err
+		})
+		if apierrors.IsAlreadyExists(err) {
+			r.resolveConflict("RoleBinding", &roleBindingToCreate.ObjectMeta, func() (metav1.Object, error) {
+				return kube.RBAC(r.Clientset).RoleBindings(roleBindingToCreate.Namespace).Get(r.context(), roleBindingToCreate.Name, metav1.GetOptions{})
+			}, func(existing metav1.Object) error {
+				return r.adoptRoleBinding(existing.(*rbacv1.RoleBinding), roleBindingToCreate)
+			})
+		} else if r.deniedByPolicy("RoleBinding", &roleBindingToCreate.ObjectMeta, err) {
+			return
+		} else if err != nil {
 			logrus.Errorf("Error creating Role Binding: %v", err)
 			metrics.ErrorCounter.Inc()
 		} else {
+			r.recordApplied("RoleBinding", &roleBindingToCreate.ObjectMeta)
 			metrics.ChangeCounter.WithLabelValues("rolebindings", "create").Inc()
+			r.recordChange("RoleBinding", "create", changeCause(roleBindingDrift[i]))
+			r.namespaceEvent("AccessGranted", roleBindingToCreate)
+			if roleBindingDrift[i] != "" {
+				r.repairedDrift("RoleBinding", &roleBindingToCreate.ObjectMeta, roleBindingDrift[i])
+			}
 		}
+	})
+
+	prunedRBMeta := []*metav1.ObjectMeta{}
+	for i := range prunedRBs {
+		prunedRBMeta = append(prunedRBMeta, &prunedRBs[i].ObjectMeta)
+	}
+	if !r.allowPrunes("RoleBinding", prunedRBMeta) {
+		return nil
 	}
 
+	// Namespaces that lose all their managed Role Bindings are pruned with a
+	// single call, counting each Role Binding as it was listed
+	bulkRBs, prunedRBs := splitBulkPrunes(prunedRBs, managedRBs, *requested)
+	r.forEach(len(bulkRBs), func(i int) {
+		prune := &bulkRBs[i]
+		if r.deleteRoleBindingCollection(prune) != nil {
+			for j := range prune.roleBindings {
+				deleteRB(&prune.roleBindings[j])
+			}
+			return
+		}
+		for j := range prune.roleBindings {
+			reason, _, _ := roleBindingPruneReason(&prune.roleBindings[j])
+			r.changeLog().Infof("Deleted Role Binding %v/%v: %v", prune.namespace, prune.roleBindings[j].Name, reason)
+			deletedRB(&prune.roleBindings[j])
+		}
+	})
+
+	r.forEach(len(prunedRBs), func(i int) {
+		deleteRB(&prunedRBs[i])
+	})
+	r.prunedApproved("RoleBinding")
+
 	return nil
 }
 
+// setDefinition prepares the Reconciler to reconcile resources owned by rbacDef
+func (r *Reconciler) setDefinition(rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	r.rbacDef = rbacDef
+	r.conflicts = nil
+	r.stale = nil
+	r.bindChecks = nil
+	r.rejections = nil
+	r.policySeen = nil
+	r.retries = nil
+	r.repaired = nil
+	r.unmatchedSelectors = nil
+	r.roleMissingChanged = false
+	r.inPlace = nil
+	r.skipped = nil
+	r.blocked = nil
+	r.approved = nil
+	r.quotaDenied = nil
+}
+
+// event records an event on the RBAC Definition being reconciled if the
+// Reconciler has a Recorder
+func (r *Reconciler) event(eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil || r.rbacDef == nil {
+		return
+	}
+	if r.Cluster != "" {
+		messageFmt = "cluster " + r.Cluster + ": " + messageFmt
+	}
+	if r.Trigger != "" {
+		messageFmt += " (triggered by " + string(r.Trigger) + ")"
+	}
+	r.Recorder.Eventf(r.rbacDef, eventType, reason, messageFmt, args...)
+}
+
+// splitCRBs separates Cluster Role Bindings to delete into those replaced by a
+// requested binding of the same name, which have to be deleted before it can
+// be created, and those pruned after the requested bindings are created so
+// subjects don't lose access in between
+func splitCRBs(toDelete, toCreate []rbacv1.ClusterRoleBinding) ([]rbacv1.ClusterRoleBinding, []rbacv1.ClusterRoleBinding) {
+	creating := map[string]bool{}
+	for _, crb := range toCreate {
+		creating[crb.Name] = true
+	}
+
+	replaced := []rbacv1.ClusterRoleBinding{}
+	pruned := []rbacv1.ClusterRoleBinding{}
+	for _, crb := range toDelete {
+		if creating[crb.Name] {
+			replaced = append(replaced, crb)
+		} else {
+			pruned = append(pruned, crb)
+		}
+	}
+	return replaced, pruned
+}
+
+// splitRBs is splitCRBs for Role Bindings
+func splitRBs(toDelete, toCreate []rbacv1.RoleBinding) ([]rbacv1.RoleBinding, []rbacv1.RoleBinding) {
+	creating := map[string]bool{}
+	for i := range toCreate {
+		creating[objectKey("RoleBinding", &toCreate[i].ObjectMeta)] = true
+	}
+
+	replaced := []rbacv1.RoleBinding{}
+	pruned := []rbacv1.RoleBinding{}
+	for i := range toDelete {
+		if creating[objectKey("RoleBinding", &toDelete[i].ObjectMeta)] {
+			replaced = append(replaced, toDelete[i])
+		} else {
+			pruned = append(pruned, toDelete[i])
+		}
+	}
+	return replaced, pruned
+}
+
+// forEach calls fn once for every index in [0, n), running at most
+// Parallelism calls concurrently and returning once all have finished
+func (r *Reconciler) forEach(n int, fn func(i int)) {
+	parallelism := r.Parallelism
+	if parallelism < 1 {
+		parallelism = CurrentOptions().Parallelism
+	}
+
+	g := errgroup.Group{}
+	g.SetLimit(parallelism)
+	for i := 0; i < n; i++ {
+		i := i
+		g.Go(func() error {
+			fn(i)
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
+
+// definitionOwnerRefs returns the owner references of the RBAC Definition
+// being reconciled. They are derived from it on every call, so that nothing
+// is carried over from the definition reconciled before, and are empty in
+// remote clusters since owner references can't point to objects in another
+// cluster.
+func (r *Reconciler) definitionOwnerRefs() []metav1.OwnerReference {
+	if r.Cluster != "" || r.rbacDef == nil {
+		return nil
+	}
+	return rbacDefOwnerRefs(r.rbacDef)
+}
+
 func rbacDefOwnerRefs(rbacDef *rbacmanagerv1beta1.RBACDefinition) []metav1.OwnerReference {
 	return []metav1.OwnerReference{
 		*metav1.NewControllerRef(rbacDef, schema.GroupVersionKind{
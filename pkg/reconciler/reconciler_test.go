@@ -16,16 +16,32 @@ package reconciler
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
 	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
 	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
 )
 
 func TestReconcileRbacDefEmpty(t *testing.T) {
@@ -64,8 +80,9 @@ func TestReconcileRbacDefChanges(t *testing.T) {
 			Name: "admin",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "jan",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "jan",
 		}},
 	}}, []corev1.ServiceAccount{})
 
@@ -96,11 +113,13 @@ func TestReconcileRbacDefChanges(t *testing.T) {
 			Name: "cluster-admin",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "jan",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "jan",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "joe",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "joe",
 		}},
 	}}, []corev1.ServiceAccount{})
 
@@ -108,7 +127,7 @@ func TestReconcileRbacDefChanges(t *testing.T) {
 }
 
 func TestReconcileRbacDefServiceAccounts(t *testing.T) {
-	client := fake.NewSimpleClientset()
+	client := fake.NewSimpleClientset(&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "custom", Namespace: "bots"}})
 	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
 	rbacDef.Name = "service-account-example"
 	testEmptyExample(t, client, rbacDef.Name)
@@ -285,11 +304,13 @@ func TestReconcileNamespaceChangesLabels(t *testing.T) {
 			Name: "edit",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "Joe",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Joe",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "Sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Sue",
 		}},
 	}, {
 		ObjectMeta: metav1.ObjectMeta{
@@ -301,14 +322,17 @@ func TestReconcileNamespaceChangesLabels(t *testing.T) {
 			Name: "view",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "Joe",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Joe",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "Sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Sue",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "Kay",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Kay",
 		}},
 	}, {
 		ObjectMeta: metav1.ObjectMeta{
@@ -320,14 +344,17 @@ func TestReconcileNamespaceChangesLabels(t *testing.T) {
 			Name: "view",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "Joe",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Joe",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "Sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Sue",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "Kay",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Kay",
 		}},
 	}})
 }
@@ -432,11 +459,13 @@ func TestReconcileNamespaceChangesExpressions(t *testing.T) {
 			Name: "edit",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "Joe",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Joe",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "Sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Sue",
 		}},
 	}, {
 		ObjectMeta: metav1.ObjectMeta{
@@ -448,14 +477,17 @@ func TestReconcileNamespaceChangesExpressions(t *testing.T) {
 			Name: "view",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "Joe",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Joe",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "Sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Sue",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "Kay",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Kay",
 		}},
 	}, {
 		ObjectMeta: metav1.ObjectMeta{
@@ -467,14 +499,17 @@ func TestReconcileNamespaceChangesExpressions(t *testing.T) {
 			Name: "view",
 		},
 		Subjects: []rbacv1.Subject{{
-			Kind: rbacv1.UserKind,
-			Name: "Joe",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Joe",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "Sue",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Sue",
 		}, {
-			Kind: rbacv1.UserKind,
-			Name: "Kay",
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "Kay",
 		}},
 	}})
 }
@@ -538,104 +573,1565 @@ func TestReconcileNamespaceChangesCRB(t *testing.T) {
 	newReconcileNamespaceChangesTest(t, client, rbacDef, []rbacv1.RoleBinding{})
 }
 
-func newReconcileTest(t *testing.T, client *fake.Clientset, rbacDef rbacmanagerv1beta1.RBACDefinition, expectedRb []rbacv1.RoleBinding, expectedCrb []rbacv1.ClusterRoleBinding, expectedSa []corev1.ServiceAccount) {
-	r := Reconciler{Clientset: client}
-	_ = r.Reconcile(&rbacDef)
-	expectRoleBindings(t, client, expectedRb)
-	expectClusterRoleBindings(t, client, expectedCrb)
-	expectServiceAccounts(t, client, expectedSa)
+func TestReconcileParallelRoleBindings(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "parallel-example"
+
+	expected := []rbacv1.RoleBinding{}
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("team-%d", i)
+		createNamespace(t, client, name, map[string]string{"team": "dev"})
+		expected = append(expected, rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "parallel-example-devs-edit",
+				Namespace: name,
+			},
+			RoleRef: rbacv1.RoleRef{
+				Kind: "ClusterRole",
+				Name: "edit",
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:     rbacv1.UserKind,
+				APIGroup: rbacv1.GroupName,
+				Name:     "joe",
+			}},
+		})
+	}
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:       "edit",
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "dev"}},
+		}},
+	}}
+
+	created := testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "create"))
+	deleted := testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "delete"))
+
+	r := Reconciler{Clientset: client, Parallelism: 4}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	expectRoleBindings(t, client, expected)
+	assert.Equal(t, created+25, testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "create")))
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{}
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{})
+	assert.Equal(t, deleted+25, testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "delete")))
 }
 
-func newReconcileNamespaceChangesTest(t *testing.T, client *fake.Clientset, rbacDef rbacmanagerv1beta1.RBACDefinition, expectedRb []rbacv1.RoleBinding) {
+func TestReconcileDeleteNotFound(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "already-deleted"
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "ci-bot",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      "ci-bot",
+				Namespace: "bots",
+			},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}},
+	}}
+
 	r := Reconciler{Clientset: client}
-	// Namespace doesn't matter here, just used for logging
-	_ = r.ReconcileNamespaceChange(&rbacDef, &corev1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{Name: "test"},
-	})
-	expectRoleBindings(t, client, expectedRb)
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+
+	// Remove each object out from under the reconciler after it has been
+	// listed but before the reconciler gets a chance to delete it.
+	for _, gvr := range []schema.GroupVersionResource{
+		corev1.SchemeGroupVersion.WithResource("serviceaccounts"),
+		rbacv1.SchemeGroupVersion.WithResource("rolebindings"),
+		rbacv1.SchemeGroupVersion.WithResource("clusterrolebindings"),
+	} {
+		gvr := gvr
+		client.PrependReactor("delete", gvr.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			deleteAction := action.(k8stesting.DeleteAction)
+			_ = client.Tracker().Delete(gvr, deleteAction.GetNamespace(), deleteAction.GetName())
+			return false, nil, nil
+		})
+	}
+
+	errors := testutil.ToFloat64(metrics.ErrorCounter)
+	deletes := map[string]float64{}
+	for _, object := range []string{"serviceaccounts", "rolebindings", "clusterrolebindings"} {
+		deletes[object] = testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues(object, "delete"))
+	}
+
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{}
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{})
 	expectClusterRoleBindings(t, client, []rbacv1.ClusterRoleBinding{})
+	expectServiceAccounts(t, client, []corev1.ServiceAccount{})
+
+	assert.Equal(t, errors, testutil.ToFloat64(metrics.ErrorCounter), "NotFound deletes should not count as errors")
+	for object, count := range deletes {
+		assert.Equal(t, count, testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues(object, "delete")), "NotFound deletes should not count as changes")
+	}
 }
 
-func testEmptyExample(t *testing.T, client *fake.Clientset, name string) {
+func TestReconcileDeletePreconditions(t *testing.T) {
+	client := fake.NewSimpleClientset()
 	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
-	rbacDef.Name = name
-	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{}
+	rbacDef.Name = "racing"
 
-	newReconcileTest(t, client, rbacDef, []rbacv1.RoleBinding{}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
-}
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}},
+	}}
 
-func expectClusterRoleBindings(t *testing.T, client *fake.Clientset, expected []rbacv1.ClusterRoleBinding) {
-	actual, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), kube.ListOptions)
+	r := Reconciler{Clientset: client}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
 
-	if err != nil {
-		t.Fatal(err)
-	}
+	// The fake clientset doesn't set these, the API server does
+	listed, err := client.RbacV1().RoleBindings("web").Get(context.TODO(), "racing-devs-edit", metav1.GetOptions{})
+	assert.NoError(t, err)
+	listed.UID = "listed-uid"
+	listed.ResourceVersion = "1"
+	_, err = client.RbacV1().RoleBindings("web").Update(context.TODO(), listed, metav1.UpdateOptions{})
+	assert.NoError(t, err)
 
-	assert.Len(t, actual.Items, len(expected), "Expected length to match")
+	// Replace the binding after it has been listed but before the reconciler
+	// deletes it, the way the API server rejects the stale delete
+	race := true
+	var preconditions *metav1.Preconditions
+	client.PrependReactor("delete", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		preconditions = action.(k8stesting.DeleteAction).GetDeleteOptions().Preconditions
+		if !race {
+			return false, nil, nil
+		}
 
-	for _, expectedCrb := range expected {
-		matchFound := false
-		for _, actualCrb := range actual.Items {
-			if actualCrb.Name == expectedCrb.Name {
-				matchFound = true
-				assert.ElementsMatch(t, expectedCrb.Subjects, actualCrb.Subjects, "Expected subjects to match")
-				assert.EqualValues(t, expectedCrb.RoleRef, actualCrb.RoleRef, "Expected role ref to match")
-				break
-			}
+		replacement := listed.DeepCopy()
+		replacement.UID = "replacement-uid"
+		replacement.ResourceVersion = "2"
+		replacement.Subjects = []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "sue"}}
+		err := client.Tracker().Update(rbacv1.SchemeGroupVersion.WithResource("rolebindings"), replacement, "web")
+		if err != nil {
+			return true, nil, err
 		}
+		return true, nil, apierrors.NewConflict(rbacv1.Resource("rolebindings"), "racing-devs-edit", fmt.Errorf("precondition failed"))
+	})
 
-		if !matchFound {
-			t.Fatalf("Matching cluster role binding not found for %v", expectedCrb.Name)
+	rbacDef.RBACBindings[0].Subjects[0].Name = "jane"
+	err = r.Reconcile(&rbacDef)
+	assert.Error(t, err, "A skipped delete should requeue the RBACDefinition")
+	if assert.NotNil(t, preconditions) {
+		assert.Equal(t, "listed-uid", string(*preconditions.UID))
+		assert.Equal(t, "1", *preconditions.ResourceVersion)
+	}
+
+	// The replacement was neither deleted nor overwritten
+	rb, err := client.RbacV1().RoleBindings("web").Get(context.TODO(), "racing-devs-edit", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "replacement-uid", string(rb.UID))
+	assert.Equal(t, "sue", rb.Subjects[0].Name)
+
+	// The next reconcile evaluates the replacement
+	race = false
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, "replacement-uid", string(*preconditions.UID))
+	rb, err = client.RbacV1().RoleBindings("web").Get(context.TODO(), "racing-devs-edit", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "jane", rb.Subjects[0].Name)
+}
+
+func TestReconcileConflictPolicy(t *testing.T) {
+	newClient := func(roleRef string) *fake.Clientset {
+		return fake.NewSimpleClientset(&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "conflict-example-devs-edit",
+				Namespace: "web",
+			},
+			RoleRef: rbacv1.RoleRef{
+				Kind: "ClusterRole",
+				Name: roleRef,
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:     rbacv1.UserKind,
+				APIGroup: rbacv1.GroupName,
+				Name:     "someone-else",
+			}},
+		})
+	}
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "conflict-example"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}},
+	}}
+
+	conflictStatus := func(rbacDef *rbacmanagerv1beta1.RBACDefinition) metav1.ConditionStatus {
+		condition := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionResourceConflict)
+		if condition == nil {
+			t.Fatal("Expected ResourceConflict condition to be set")
 		}
+		return condition.Status
+	}
+
+	for _, policy := range []rbacmanagerv1beta1.ConflictPolicy{"", rbacmanagerv1beta1.ConflictPolicyFail} {
+		client := newClient("edit")
+		recorder := record.NewFakeRecorder(10)
+		rbacDef.ConflictPolicy = policy
+		rbacDef.Status = rbacmanagerv1beta1.RBACDefinitionStatus{}
+		r := Reconciler{Clientset: client, Recorder: recorder}
+		err := r.Reconcile(&rbacDef)
+		assert.NoError(t, err)
+		expectRoleBindings(t, client, []rbacv1.RoleBinding{})
+		assert.Equal(t, metav1.ConditionTrue, conflictStatus(&rbacDef))
+		assert.Contains(t, <-recorder.Events, "Warning ResourceConflict Cannot create RoleBinding web/conflict-example-devs-edit")
 	}
+
+	client := newClient("edit")
+	rbacDef.ConflictPolicy = rbacmanagerv1beta1.ConflictPolicySkip
+	rbacDef.Status = rbacmanagerv1beta1.RBACDefinitionStatus{}
+	r := Reconciler{Clientset: client}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{})
+	assert.Equal(t, metav1.ConditionFalse, conflictStatus(&rbacDef))
+
+	// The existing Role Binding references a different role, so it can't be adopted
+	client = newClient("admin")
+	rbacDef.ConflictPolicy = rbacmanagerv1beta1.ConflictPolicyAdopt
+	rbacDef.Status = rbacmanagerv1beta1.RBACDefinitionStatus{}
+	r = Reconciler{Clientset: client}
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{})
+	assert.Equal(t, metav1.ConditionTrue, conflictStatus(&rbacDef))
+
+	client = newClient("edit")
+	rbacDef.Status = rbacmanagerv1beta1.RBACDefinitionStatus{}
+	r = Reconciler{Clientset: client}
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "conflict-example-devs-edit",
+			Namespace: "web",
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind: "ClusterRole",
+			Name: "edit",
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "joe",
+		}},
+	}})
+	assert.Equal(t, metav1.ConditionFalse, conflictStatus(&rbacDef))
+
+	// Once adopted, the Role Binding is pruned like any other managed one
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{}
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	rbs, err := client.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 0)
 }
 
-func expectRoleBindings(t *testing.T, client *fake.Clientset, expected []rbacv1.RoleBinding) {
-	actual, err := client.RbacV1().RoleBindings("").List(context.TODO(), kube.ListOptions)
+func TestOrphan(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "orphan-example"
+	rbacDef.UID = "orphan-example-uid"
+	rbacDef.DeletionPolicy = rbacmanagerv1beta1.DeletionPolicyOrphan
 
-	if err != nil {
-		t.Fatal(err)
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "ci-bot",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      "ci-bot",
+				Namespace: "bots",
+			},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}},
+	}}
+
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+
+	err = r.Orphan(&rbacDef)
+	assert.NoError(t, err)
+
+	// Nothing is managed anymore
+	expectClusterRoleBindings(t, client, []rbacv1.ClusterRoleBinding{})
+	expectRoleBindings(t, client, []rbacv1.RoleBinding{})
+
+	sa, err := client.CoreV1().ServiceAccounts("bots").Get(context.TODO(), "ci-bot", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, sa.OwnerReferences)
+	assert.NotContains(t, sa.Labels, kube.LabelKey)
+	crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "orphan-example-ci-bot-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, crb.OwnerReferences)
+	assert.Len(t, crb.Subjects, 1)
+	rb, err := client.RbacV1().RoleBindings("web").Get(context.TODO(), "orphan-example-ci-bot-edit", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, rb.OwnerReferences)
+
+	close(recorder.Events)
+	events := []string{}
+	for event := range recorder.Events {
+		events = append(events, event)
 	}
+	assert.ElementsMatch(t, []string{
+		"Warning ClusterServiceAccount ServiceAccount bots/ci-bot in rbacBindings entry ci-bot is bound to ClusterRole view in every namespace, use limitToNamespaceSelector or roleBindings to bind it in fewer namespaces",
+		"Normal Orphaned Orphaned ServiceAccount bots/ci-bot",
+		"Normal Orphaned Orphaned ClusterRoleBinding orphan-example-ci-bot-view",
+		"Normal Orphaned Orphaned RoleBinding web/orphan-example-ci-bot-edit",
+	}, events)
+}
 
-	assert.Len(t, actual.Items, len(expected), "Expected length to match")
+func TestReconcileWithStaleCache(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "cache-example"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}},
+	}}
 
-	for _, expectedRb := range expected {
-		matchFound := false
-		for _, actualRb := range actual.Items {
-			if actualRb.Name == expectedRb.Name && expectedRb.Namespace == actualRb.Namespace {
-				matchFound = true
-				assert.ElementsMatch(t, expectedRb.Subjects, actualRb.Subjects, "Expected subjects to match")
-				assert.EqualValues(t, expectedRb.RoleRef, actualRb.RoleRef, "Expected role ref to match")
-				break
-			}
-		}
+	expectedRb := []rbacv1.RoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cache-example-devs-edit",
+			Namespace: "web",
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind: "ClusterRole",
+			Name: "edit",
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     "joe",
+		}},
+	}}
 
-		if !matchFound {
-			t.Fatalf("Matching role binding not found for %v", expectedRb.Name)
-		}
+	// The informers never see any of our writes
+	newIndexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
 	}
+	staleCache := newCache(
+		corev1listers.NewServiceAccountLister(newIndexer()),
+		rbacv1listers.NewClusterRoleBindingLister(newIndexer()),
+		rbacv1listers.NewRoleBindingLister(newIndexer()),
+	)
+
+	r := Reconciler{Clientset: client, Cache: staleCache}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	expectRoleBindings(t, client, expectedRb)
+
+	// Within the grace period the reconciler lists from the API, so it sees
+	// the bindings it just created
+	errors := testutil.ToFloat64(metrics.ErrorCounter)
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	expectRoleBindings(t, client, expectedRb)
+	assert.Equal(t, errors, testutil.ToFloat64(metrics.ErrorCounter))
+
+	// After the grace period the stale cache hides the bindings, creating
+	// them fails, and the existing bindings are recognized as our own
+	staleCache.writes = map[string]time.Time{}
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	expectRoleBindings(t, client, expectedRb)
+	assert.Equal(t, errors, testutil.ToFloat64(metrics.ErrorCounter))
+	condition := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionResourceConflict)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
 }
 
-func expectServiceAccounts(t *testing.T, client *fake.Clientset, expected []corev1.ServiceAccount) {
-	actual, err := client.CoreV1().ServiceAccounts("").List(context.TODO(), metav1.ListOptions{})
+func TestReconcileCacheGracePeriodFollowsWrites(t *testing.T) {
+	defer func(gracePeriod time.Duration) { CacheGracePeriod = gracePeriod }(CacheGracePeriod)
+	CacheGracePeriod = 100 * time.Millisecond
 
-	if err != nil {
-		t.Fatal(err)
+	client := fake.NewSimpleClientset()
+	// Creating the binding takes longer than the grace period
+	client.PrependReactor("create", "clusterrolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		time.Sleep(2 * CacheGracePeriod)
+		return false, nil, nil
+	})
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "slow-writes"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "devs",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+
+	newIndexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
 	}
+	staleCache := newCache(
+		corev1listers.NewServiceAccountLister(newIndexer()),
+		rbacv1listers.NewClusterRoleBindingLister(newIndexer()),
+		rbacv1listers.NewRoleBindingLister(newIndexer()),
+	)
 
-	assert.Len(t, actual.Items, len(expected), "Expected length to match")
+	r := Reconciler{Clientset: client, Cache: staleCache}
+	assert.NoError(t, r.Reconcile(&rbacDef))
 
-	for _, expectedSa := range expected {
-		matchFound := false
-		for _, actualSa := range actual.Items {
-			if actualSa.Name == expectedSa.Name && expectedSa.Namespace == actualSa.Namespace {
-				matchFound = true
-				break
-			}
+	// The grace period starts once the write returns, so the next reconcile
+	// still lists the binding from the API
+	assert.False(t, staleCache.fresh("clusterrolebindings"))
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "clusterrolebindings" {
+			assert.NotEqual(t, "create", action.GetVerb(), "the binding should not be created again")
 		}
+	}
+}
 
-		if !matchFound {
-			t.Fatalf("Matching service account not found for %v", expectedSa.Name)
-		}
+func TestReconcileRepairsDrift(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "drift-example"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}},
+	}}
+
+	drift := metrics.DriftRepairedCounter.WithLabelValues("RoleBinding", "drift-example")
+	repaired := testutil.ToFloat64(drift)
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, repaired, testutil.ToFloat64(drift), "Creating new bindings is not drift")
+
+	err = client.RbacV1().RoleBindings("web").Delete(context.TODO(), "drift-example-devs-edit", metav1.DeleteOptions{})
+	assert.NoError(t, err)
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, repaired+1, testutil.ToFloat64(drift))
+	assert.Equal(t, "Warning DriftRepaired RoleBinding web/drift-example-devs-edit was deleted outside of rbac-manager and has been restored", <-recorder.Events)
+
+	rb, err := client.RbacV1().RoleBindings("web").Get(context.TODO(), "drift-example-devs-edit", metav1.GetOptions{})
+	assert.NoError(t, err)
+	rb.Subjects = append(rb.Subjects, rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "intruder"})
+	_, err = client.RbacV1().RoleBindings("web").Update(context.TODO(), rb, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, repaired+2, testutil.ToFloat64(drift))
+	assert.Equal(t, "Warning DriftRepaired RoleBinding web/drift-example-devs-edit was modified outside of rbac-manager and has been restored", <-recorder.Events)
+	rb, err = client.RbacV1().RoleBindings("web").Get(context.TODO(), "drift-example-devs-edit", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rb.Subjects, 1)
+
+	// Replacing a binding because the definition changed is not drift
+	rbacDef.RBACBindings[0].Subjects[0].Name = "jane"
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, repaired+2, testutil.ToFloat64(drift))
+
+	changes := func(action, cause string) float64 {
+		return testutil.ToFloat64(metrics.ReconcileChanges.WithLabelValues("drift-example", "RoleBinding", action, cause, string(TriggerUnknown)))
+	}
+	assert.Equal(t, 2.0, changes("create", "spec_change"))
+	assert.Equal(t, 2.0, changes("create", "drift"))
+	assert.Equal(t, 1.0, changes("delete", "drift"), "deleting the modified binding repairs drift")
+	assert.Equal(t, 1.0, changes("delete", "spec_change"))
+}
+
+func TestReconcileMergeBindings(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "merge-example"
+	for _, name := range []string{"web-devs", "api-devs"} {
+		rbacDef.RBACBindings = append(rbacDef.RBACBindings, rbacmanagerv1beta1.RBACBinding{
+			Name: name,
+			Subjects: []rbacmanagerv1beta1.Subject{{
+				Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+			}, {
+				Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: name},
+			}},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+				Namespace:   "web",
+				ClusterRole: "edit",
+			}},
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+				ClusterRole: "view",
+			}},
+		})
+	}
+	rbacDef.RBACBindings[1].RoleBindings = append(rbacDef.RBACBindings[1].RoleBindings, rbacmanagerv1beta1.RoleBinding{
+		Namespace:   "api",
+		ClusterRole: "edit",
+	})
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	rbs, err := client.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 3)
+
+	client.ClearActions()
+	rbacDef.MergeBindings = true
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	subjects := []rbacv1.Subject{
+		{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "api-devs"},
+		{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "joe"},
+		{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "web-devs"},
+	}
+	rbs, err = client.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	names := []string{}
+	for _, rb := range rbs.Items {
+		names = append(names, rb.Namespace+"/"+rb.Name)
+		if rb.Namespace == "web" {
+			assert.Equal(t, subjects, rb.Subjects)
+		}
+	}
+	assert.ElementsMatch(t, []string{"web/merge-example-clusterrole-edit", "api/merge-example-api-devs-edit"}, names,
+		"bindings sharing a namespace and role should be merged, others keep their names")
+
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, crbs.Items, 1) {
+		assert.Equal(t, "merge-example-clusterrole-view", crbs.Items[0].Name)
+		assert.Equal(t, subjects, crbs.Items[0].Subjects)
+	}
+
+	created := false
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource != "rolebindings" {
+			continue
+		}
+		if action.GetVerb() == "create" {
+			created = true
+		} else if action.GetVerb() == "delete" {
+			assert.True(t, created, "merged bindings should be created before the originals are deleted")
+		}
+	}
+}
+
+func newReconcileTest(t *testing.T, client *fake.Clientset, rbacDef rbacmanagerv1beta1.RBACDefinition, expectedRb []rbacv1.RoleBinding, expectedCrb []rbacv1.ClusterRoleBinding, expectedSa []corev1.ServiceAccount) {
+	r := Reconciler{Clientset: client}
+	_ = r.Reconcile(&rbacDef)
+	expectRoleBindings(t, client, expectedRb)
+	expectClusterRoleBindings(t, client, expectedCrb)
+	expectServiceAccounts(t, client, expectedSa)
+}
+
+func newReconcileNamespaceChangesTest(t *testing.T, client *fake.Clientset, rbacDef rbacmanagerv1beta1.RBACDefinition, expectedRb []rbacv1.RoleBinding) {
+	r := Reconciler{Clientset: client}
+	// Namespace doesn't matter here, just used for logging
+	_ = r.ReconcileNamespaceChange(&rbacDef, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+	})
+	expectRoleBindings(t, client, expectedRb)
+	expectClusterRoleBindings(t, client, []rbacv1.ClusterRoleBinding{})
+}
+
+func testEmptyExample(t *testing.T, client *fake.Clientset, name string) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = name
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{}
+
+	newReconcileTest(t, client, rbacDef, []rbacv1.RoleBinding{}, []rbacv1.ClusterRoleBinding{}, []corev1.ServiceAccount{})
+}
+
+func expectClusterRoleBindings(t *testing.T, client *fake.Clientset, expected []rbacv1.ClusterRoleBinding) {
+	actual, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), kube.ListOptions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, actual.Items, len(expected), "Expected length to match")
+
+	for _, expectedCrb := range expected {
+		matchFound := false
+		for _, actualCrb := range actual.Items {
+			if actualCrb.Name == expectedCrb.Name {
+				matchFound = true
+				assert.ElementsMatch(t, expectedCrb.Subjects, actualCrb.Subjects, "Expected subjects to match")
+				assert.EqualValues(t, expectedCrb.RoleRef, actualCrb.RoleRef, "Expected role ref to match")
+				break
+			}
+		}
+
+		if !matchFound {
+			t.Fatalf("Matching cluster role binding not found for %v", expectedCrb.Name)
+		}
+	}
+}
+
+func expectRoleBindings(t *testing.T, client *fake.Clientset, expected []rbacv1.RoleBinding) {
+	actual, err := client.RbacV1().RoleBindings("").List(context.TODO(), kube.ListOptions)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, actual.Items, len(expected), "Expected length to match")
+
+	for _, expectedRb := range expected {
+		matchFound := false
+		for _, actualRb := range actual.Items {
+			if actualRb.Name == expectedRb.Name && expectedRb.Namespace == actualRb.Namespace {
+				matchFound = true
+				assert.ElementsMatch(t, expectedRb.Subjects, actualRb.Subjects, "Expected subjects to match")
+				assert.EqualValues(t, expectedRb.RoleRef, actualRb.RoleRef, "Expected role ref to match")
+				break
+			}
+		}
+
+		if !matchFound {
+			t.Fatalf("Matching role binding not found for %v", expectedRb.Name)
+		}
+	}
+}
+
+func expectServiceAccounts(t *testing.T, client *fake.Clientset, expected []corev1.ServiceAccount) {
+	actual, err := client.CoreV1().ServiceAccounts("").List(context.TODO(), metav1.ListOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, actual.Items, len(expected), "Expected length to match")
+
+	for _, expectedSa := range expected {
+		matchFound := false
+		for _, actualSa := range actual.Items {
+			if actualSa.Name == expectedSa.Name && expectedSa.Namespace == actualSa.Namespace {
+				matchFound = true
+				break
+			}
+		}
+
+		if !matchFound {
+			t.Fatalf("Matching service account not found for %v", expectedSa.Name)
+		}
+	}
+}
+
+func TestReconcileRelabelsStrippedBindings(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "relabel-example"
+	rbacDef.UID = "relabel-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}},
+	}}
+
+	// Tooling that rewrites Role Bindings drops their labels right after they
+	// are created
+	gvr := rbacv1.SchemeGroupVersion.WithResource("rolebindings")
+	client.PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		rb := action.(k8stesting.CreateAction).GetObject().(*rbacv1.RoleBinding).DeepCopy()
+		rb.Labels = nil
+		return true, rb, client.Tracker().Create(gvr, rb, rb.Namespace)
+	})
+
+	relabeled := testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "relabeled"))
+	r := Reconciler{Clientset: client}
+
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	rbs, err := client.RbacV1().RoleBindings("").List(context.TODO(), kube.ListOptions)
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 0, "the reactor should have stripped the label")
+
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, relabeled+1, testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "relabeled")))
+	assert.Empty(t, r.conflicts)
+
+	rbs, err = client.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 1, "no duplicate should have been created")
+	assert.Equal(t, kube.LabelValue, rbs.Items[0].Labels[kube.LabelKey])
+
+	// Relabeled bindings are pruned again
+	rbacDef.RBACBindings = nil
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	rbs, err = client.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 0)
+}
+
+func TestReconcileDoesNotRelabelUnmanagedBindings(t *testing.T) {
+	client := fake.NewSimpleClientset(&rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "unmanaged-devs-edit", Namespace: "web"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "joe"}},
+	})
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "unmanaged"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}},
+	}}
+
+	relabeled := testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "relabeled"))
+	r := Reconciler{Clientset: client}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, relabeled, testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "relabeled")))
+	assert.Equal(t, []string{"RoleBinding web/unmanaged-devs-edit"}, r.conflicts)
+}
+
+func TestReconcileRoleFrom(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "golden", map[string]string{"team": "devs"})
+	createNamespace(t, client, "web", map[string]string{"team": "devs"})
+	createNamespace(t, client, "api", map[string]string{"team": "devs"})
+	createNamespace(t, client, "db", map[string]string{"team": "db"})
+
+	source := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "developer", Namespace: "golden"},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+			Verbs:     []string{"get", "list"},
+		}},
+	}
+	_, err := client.RbacV1().Roles("golden").Create(context.TODO(), source, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "golden-roles"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			RoleFrom: &rbacmanagerv1beta1.RoleSource{Namespace: "golden", Name: "developer"},
+			NamespaceSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"team": "devs"},
+			},
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+
+	roles, err := client.RbacV1().Roles("").List(context.TODO(), kube.ListOptions)
+	assert.NoError(t, err)
+	assert.Len(t, roles.Items, 2, "the source namespace should not get a copy")
+	for _, role := range roles.Items {
+		assert.Contains(t, []string{"web", "api"}, role.Namespace)
+		assert.Equal(t, "developer", role.Name)
+		assert.Equal(t, source.Rules, role.Rules)
+		assert.Equal(t, "golden/developer", role.Annotations[kube.CopiedFromAnnotation])
+	}
+
+	rbs, err := client.RbacV1().RoleBindings("").List(context.TODO(), kube.ListOptions)
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 3)
+	for _, rb := range rbs.Items {
+		assert.Equal(t, rbacv1.RoleRef{Kind: "Role", Name: "developer"}, rb.RoleRef)
+	}
+
+	// Copies are updated in place when the source changes
+	updated := testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("roles", "update"))
+	source.Rules[0].Verbs = []string{"get", "list", "watch"}
+	_, err = client.RbacV1().Roles("golden").Update(context.TODO(), source, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, updated+2, testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("roles", "update")))
+	copied, err := client.RbacV1().Roles("web").Get(context.TODO(), "developer", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"get", "list", "watch"}, copied.Rules[0].Verbs)
+
+	// Nothing changes once the copies are in sync
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, updated+2, testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("roles", "update")))
+
+	// A missing source fails the definition instead of pruning its copies
+	err = client.RbacV1().Roles("golden").Delete(context.TODO(), "developer", metav1.DeleteOptions{})
+	assert.NoError(t, err)
+	err = r.Reconcile(&rbacDef)
+	assert.EqualError(t, err, "rbacBindings[0] 'devs': roleBindings[0]: roleFrom: Role golden/developer does not exist")
+	roles, err = client.RbacV1().Roles("").List(context.TODO(), kube.ListOptions)
+	assert.NoError(t, err)
+	assert.Len(t, roles.Items, 2)
+
+	// Removing the entry prunes the copies but never the source
+	_, err = client.RbacV1().Roles("golden").Create(context.TODO(), source, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	rbacDef.RBACBindings[0].RoleBindings[0] = rbacmanagerv1beta1.RoleBinding{Namespace: "web", ClusterRole: "view"}
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	roles, err = client.RbacV1().Roles("").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, roles.Items, 1)
+	assert.Equal(t, "golden", roles.Items[0].Namespace)
+}
+
+func TestReconcileCreateIfMissing(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "web", map[string]string{})
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "team-a"
+	rbacDef.UID = "team-a-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:     "edit",
+			Namespaces:      []string{"web", "team-a"},
+			CreateIfMissing: true,
+			NamespaceLabels: map[string]string{"team": "a"},
+		}},
+	}}
+
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, "Normal NamespaceCreated Created Namespace team-a", <-recorder.Events)
+
+	namespace, err := client.CoreV1().Namespaces().Get(context.TODO(), "team-a", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "a", kube.LabelKey: kube.LabelValue}, namespace.Labels)
+	assert.Equal(t, "team-a", namespace.Annotations[kube.ManagedByAnnotation])
+	assert.Empty(t, namespace.OwnerReferences, "namespaces must not be garbage collected with the definition")
+
+	for _, name := range []string{"web", "team-a"} {
+		_, err := client.RbacV1().RoleBindings(name).Get(context.TODO(), "team-a-devs-edit", metav1.GetOptions{})
+		assert.NoError(t, err, "Role Binding in %v", name)
+	}
+
+	web, err := client.CoreV1().Namespaces().Get(context.TODO(), "web", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, web.Labels, "existing namespaces must not be changed")
+
+	// Namespaces stay when they are no longer requested
+	rbacDef.RBACBindings = nil
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	_, err = client.CoreV1().Namespaces().Get(context.TODO(), "team-a", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// and are only deleted for the DeleteNamespaces deletion policy
+	err = r.DeleteNamespaces(&rbacDef)
+	assert.NoError(t, err)
+	_, err = client.CoreV1().Namespaces().Get(context.TODO(), "team-a", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	_, err = client.CoreV1().Namespaces().Get(context.TODO(), "web", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestReconcilePreflightBindChecks(t *testing.T) {
+	PreflightBindChecks = true
+	defer func() { PreflightBindChecks = false }()
+
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "web", map[string]string{})
+	createNamespace(t, client, "api", map[string]string{})
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "preflight"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "ops",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{
+			{ClusterRole: "view"},
+			{ClusterRole: "cluster-admin"},
+		},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole: "cluster-admin",
+			Namespaces:  []string{"web", "api"},
+		}},
+	}}
+
+	// Only view may be bound without holding its permissions
+	reviews := 0
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview).DeepCopy()
+		review.Status.Allowed = review.Spec.ResourceAttributes.Name == "view"
+		return true, review, nil
+	})
+	forbidden := func(action k8stesting.Action) (bool, runtime.Object, error) {
+		switch binding := action.(k8stesting.CreateAction).GetObject().(type) {
+		case *rbacv1.ClusterRoleBinding:
+			return binding.RoleRef.Name == "cluster-admin", nil, apierrors.NewForbidden(rbacv1.Resource("clusterrolebindings"), binding.Name, fmt.Errorf("escalation"))
+		case *rbacv1.RoleBinding:
+			return binding.RoleRef.Name == "cluster-admin" && binding.Namespace == "web", nil, apierrors.NewForbidden(rbacv1.Resource("rolebindings"), binding.Name, fmt.Errorf("escalation"))
+		}
+		return false, nil, nil
+	}
+	client.PrependReactor("create", "clusterrolebindings", forbidden)
+	client.PrependReactor("create", "rolebindings", forbidden)
+
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, reviews, "every role should be checked once per namespace")
+	assert.Equal(t, "Warning BindingForbidden rbac-manager is not permitted to bind ClusterRole cluster-admin: missing bind permission and not all of its permissions are held", <-recorder.Events)
+	assert.Equal(t, "Warning BindingForbidden rbac-manager is not permitted to bind ClusterRole cluster-admin in namespace web: missing bind permission and not all of its permissions are held", <-recorder.Events)
+	assert.Len(t, recorder.Events, 0)
+
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, crbs.Items, 1)
+	assert.Equal(t, "view", crbs.Items[0].RoleRef.Name)
+
+	// Holding every permission of a role is enough to bind it. The fake
+	// clientset ignores dry runs, so the binding in api exists from the check
+	// and is found to be managed when it is created.
+	rbs, err := client.RbacV1().RoleBindings("").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rbs.Items, 1)
+	assert.Equal(t, "api", rbs.Items[0].Namespace)
+
+	condition := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionBindingForbidden)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "rbac-manager is not permitted to bind ClusterRole cluster-admin, ClusterRole cluster-admin in namespace web", condition.Message)
+	}
+}
+
+func TestReconcileBlockedByPolicy(t *testing.T) {
+	PolicyBlocks = NewPolicyBlocks(time.Hour, time.Hour)
+	defer func() { PolicyBlocks = NewPolicyBlocks(time.Minute, time.Hour) }()
+
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "web", map[string]string{})
+	createNamespace(t, client, "api", map[string]string{})
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "policy"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole: "edit",
+			Namespaces:  []string{"web", "api"},
+		}},
+	}}
+
+	// A policy engine denies Role Bindings in web
+	creates := 0
+	client.PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		rb := action.(k8stesting.CreateAction).GetObject().(*rbacv1.RoleBinding)
+		if rb.Namespace != "web" {
+			return false, nil, nil
+		}
+		creates++
+		return true, nil, &apierrors.StatusError{ErrStatus: metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    400,
+			Reason:  metav1.StatusReasonBadRequest,
+			Message: "admission webhook \"validate.kyverno.svc-fail\" denied the request: \n\nrestrict-edit: no edit in web",
+		}}
+	})
+
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, creates)
+	assert.Equal(t, "Warning BlockedByPolicy Admission webhook \"validate.kyverno.svc-fail\" denied RoleBinding web/policy-devs-edit: restrict-edit: no edit in web", <-recorder.Events)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.BlockedByPolicy.WithLabelValues("policy", "validate.kyverno.svc-fail")))
+
+	_, err = client.RbacV1().RoleBindings("api").Get(context.TODO(), "policy-devs-edit", metav1.GetOptions{})
+	assert.NoError(t, err, "other namespaces should not be affected")
+
+	condition := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionBlockedByPolicy)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Admission webhooks denied: RoleBinding web/policy-devs-edit (validate.kyverno.svc-fail: restrict-edit: no edit in web)", condition.Message)
+	}
+
+	// The denied binding is not retried until its backoff expires
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, creates)
+	assert.Len(t, recorder.Events, 0)
+	condition = meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionBlockedByPolicy)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+
+	// unless the requested binding changes
+	rbacDef.RBACBindings[0].Subjects[0].Name = "jane"
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, creates)
+	<-recorder.Events
+
+	// Bindings that are no longer requested are forgotten
+	rbacDef.RBACBindings = nil
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	condition = meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionBlockedByPolicy)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, 0, testutil.CollectAndCount(metrics.BlockedByPolicy))
+}
+
+func TestReconcileListsPages(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "paged"
+	rbacDef.UID = "paged-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespace:   "web",
+			ClusterRole: "edit",
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	requested, err := client.RbacV1().RoleBindings("web").Get(context.TODO(), "paged-devs-edit", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	stale := requested.DeepCopy()
+	stale.Name = "paged-devs-view"
+	stale.RoleRef.Name = "view"
+	_, err = client.RbacV1().RoleBindings("web").Create(context.TODO(), stale, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	// The requested binding is on the second page and the stale one on the first
+	pages := 0
+	client.PrependReactor("list", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pages++
+		list := &rbacv1.RoleBindingList{}
+		if pages%2 == 1 {
+			list.Items = []rbacv1.RoleBinding{*stale}
+			list.Continue = "page-2"
+		} else {
+			list.Items = []rbacv1.RoleBinding{*requested}
+		}
+		return true, list, nil
+	})
+
+	created := testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "create"))
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, pages)
+	assert.Equal(t, created, testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("rolebindings", "create")), "the binding on the second page should be found")
+
+	_, err = client.RbacV1().RoleBindings("web").Get(context.TODO(), "paged-devs-view", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the binding on the first page should be pruned")
+}
+
+// BenchmarkReconcileRoleBindings reconciles a definition in a cluster with
+// 50,000 Role Bindings managed for other definitions, listed in pages of
+// ListPageSize. Allocations grow with the number of existing Role Bindings,
+// but only one page of them is live at a time.
+func BenchmarkReconcileRoleBindings(b *testing.B) {
+	const existing = 50000
+
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-1"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-2"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-3"}},
+	)
+	page := 0
+	client.PrependReactor("list", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		list := &rbacv1.RoleBindingList{}
+		for i := int64(0); i < ListPageSize; i++ {
+			n := int64(page)*ListPageSize + i
+			if n >= existing {
+				break
+			}
+			list.Items = append(list.Items, rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            fmt.Sprintf("other-%d", n),
+					Namespace:       fmt.Sprintf("ns-%d", n%1000),
+					Labels:          kube.Labels,
+					OwnerReferences: []metav1.OwnerReference{{Kind: "RBACDefinition", Name: "other", UID: "other-uid"}},
+				},
+				RoleRef:  rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"},
+				Subjects: []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "jane"}},
+			})
+		}
+		page++
+		if int64(page)*ListPageSize < existing {
+			list.Continue = fmt.Sprintf("page-%d", page)
+		} else {
+			page = 0
+		}
+		return true, list, nil
+	})
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "benchmark"
+	rbacDef.UID = "benchmark-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.UserKind,
+				Name: "joe",
+			},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			Namespaces:  []string{"ns-1", "ns-2", "ns-3"},
+			ClusterRole: "edit",
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := r.Reconcile(&rbacDef)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReconcileServiceAccountNamespaceDefaultChange(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "bots"
+	rbacDef.UID = "bots-uid"
+	rbacDef.Defaults.ServiceAccountNamespace = "ci"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "deployers",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.ServiceAccountKind,
+				Name: "deployer",
+			},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "edit",
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	_, err = client.CoreV1().ServiceAccounts("ci").Get(context.TODO(), "deployer", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// Changing the default moves the Service Account and its bindings
+	rbacDef.Defaults.ServiceAccountNamespace = "deploy"
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+
+	_, err = client.CoreV1().ServiceAccounts("deploy").Get(context.TODO(), "deployer", metav1.GetOptions{})
+	assert.NoError(t, err)
+	_, err = client.CoreV1().ServiceAccounts("ci").Get(context.TODO(), "deployer", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), "the Service Account in the old default namespace should be pruned")
+
+	crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "bots-deployers-edit", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "deployer", Namespace: "deploy"}}, crb.Subjects)
+}
+
+func TestReconcileRestoredServiceAccount(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "restored"
+	rbacDef.UID = "restored-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "deployers",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      "restored-deployer",
+				Namespace: "ci",
+			},
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	sa, err := client.CoreV1().ServiceAccounts("ci").Get(context.TODO(), "restored-deployer", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.True(t, ServiceAccountApplied(sa), "a Service Account RBAC Manager created doesn't need to be verified")
+
+	// A restore from a backup brings the Service Account back with stale content
+	err = client.CoreV1().ServiceAccounts("ci").Delete(context.TODO(), sa.Name, metav1.DeleteOptions{})
+	assert.NoError(t, err)
+	restored := sa.DeepCopy()
+	restored.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "old-registry"}}
+	_, err = client.CoreV1().ServiceAccounts("ci").Create(context.TODO(), restored, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.False(t, ServiceAccountApplied(restored), "a restored Service Account with stale content should be verified")
+
+	unknown := sa.DeepCopy()
+	unknown.Name = "restored-unknown"
+	assert.False(t, ServiceAccountApplied(unknown), "a Service Account RBAC Manager never applied should be verified")
+
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	sa, err = client.CoreV1().ServiceAccounts("ci").Get(context.TODO(), "restored-deployer", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, sa.ImagePullSecrets)
+	assert.True(t, ServiceAccountApplied(sa))
+}
+
+func TestReconcileBoundServiceAccounts(t *testing.T) {
+	chartSA := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "chart-worker", Namespace: "apps"}}
+	client := fake.NewSimpleClientset(chartSA)
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "workers"
+	rbacDef.UID = "workers-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "workers",
+		Subjects: []rbacmanagerv1beta1.Subject{
+			{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "chart-worker", Namespace: "apps"}},
+			{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "worker", Namespace: "apps"}},
+		},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+	create := false
+	rbacDef.RBACBindings[0].Subjects[0].CreateServiceAccount = &create
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	sa, err := client.CoreV1().ServiceAccounts("apps").Get(context.TODO(), "chart-worker", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, sa.OwnerReferences, "a Service Account that is only bound should not be taken over")
+	_, err = client.CoreV1().ServiceAccounts("apps").Get(context.TODO(), "worker", metav1.GetOptions{})
+	assert.NoError(t, err)
+	crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "workers-workers-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, crb.Subjects, 2, "Service Accounts that are only bound should still be subjects")
+
+	// Handing a Service Account RBAC Manager created over to a chart releases
+	// it instead of deleting it
+	rbacDef.Defaults.CreateServiceAccount = &create
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "serviceaccounts" {
+			assert.NotEqual(t, "delete", action.GetVerb())
+		}
+	}
+	sa, err = client.CoreV1().ServiceAccounts("apps").Get(context.TODO(), "worker", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, sa.OwnerReferences)
+	assert.NotContains(t, sa.Labels, kube.LabelKey)
+
+	// Nothing changes once it has been released
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "serviceaccounts" {
+			assert.Equal(t, "list", action.GetVerb())
+		}
+	}
+}
+
+func TestReconcileNamespaceChangeSkipsUnaffectedPhases(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "web", map[string]string{"team": "dev"})
+	web, err := client.CoreV1().Namespaces().Get(context.TODO(), "web", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "namespace-phases"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:       "edit",
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "dev"}},
+		}},
+	}}
+
+	listed := func(resource string) bool {
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "list" && action.GetResource().Resource == resource {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Without Service Account subjects only Role Bindings are reconciled
+	client.ClearActions()
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.ReconcileNamespaceChange(&rbacDef, web))
+	assert.False(t, listed("serviceaccounts"))
+	assert.True(t, listed("rolebindings"))
+	_, err = client.RbacV1().RoleBindings("web").Get(context.TODO(), "namespace-phases-devs-edit", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// Definitions that don't depend on the namespace aren't reconciled at all
+	rbacDef.RBACBindings[0].RoleBindings = nil
+	rbacDef.RBACBindings[0].ClusterRoleBindings = []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}}
+	client.ClearActions()
+	assert.NoError(t, r.ReconcileNamespaceChange(&rbacDef, web))
+	assert.Empty(t, client.Actions())
+}
+
+func TestReconcileNamespaceChangeKeepsOtherDefinitionsServiceAccounts(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	createNamespace(t, client, "web", map[string]string{"team": "dev"})
+	web, err := client.CoreV1().Namespaces().Get(context.TODO(), "web", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	newDefinition := func(name string) rbacmanagerv1beta1.RBACDefinition {
+		rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+		rbacDef.Name = name
+		rbacDef.UID = types.UID(name + "-uid")
+		rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+			Name: "bots",
+			Subjects: []rbacmanagerv1beta1.Subject{{
+				Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: name + "-bot", Namespace: "web"},
+			}},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+				ClusterRole:       "edit",
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "dev"}},
+			}},
+		}}
+		return rbacDef
+	}
+	alpha := newDefinition("alpha")
+	beta := newDefinition("beta")
+	deleting := newDefinition("deleting")
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+
+	for _, rbacDef := range []rbacmanagerv1beta1.RBACDefinition{alpha, beta} {
+		r := Reconciler{Clientset: client}
+		assert.NoError(t, r.Reconcile(&rbacDef))
+	}
+
+	// Like the namespace controller, one Reconciler handles the namespace
+	// events of every definition back to back, including one it skips
+	client.ClearActions()
+	r := Reconciler{Clientset: client}
+	for i := 0; i < 2; i++ {
+		for _, rbacDef := range []rbacmanagerv1beta1.RBACDefinition{alpha, deleting, beta} {
+			assert.NoError(t, r.ReconcileNamespaceChange(&rbacDef, web))
+		}
+	}
+
+	for _, action := range client.Actions() {
+		assert.NotEqual(t, "delete", action.GetVerb(), "nothing should be deleted, got %v", action)
+	}
+	for _, name := range []string{"alpha-bot", "beta-bot"} {
+		sa, err := client.CoreV1().ServiceAccounts("web").Get(context.TODO(), name, metav1.GetOptions{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, types.UID(strings.TrimSuffix(name, "-bot")+"-uid"), sa.OwnerReferences[0].UID)
+		}
+	}
+
+	// A Reconciler without a definition owns nothing
+	assert.False(t, (&Reconciler{}).owns(&corev1.ServiceAccount{}))
+}
+
+func TestReconcileSubjectPrefixChange(t *testing.T) {
+	defer func(prefix string) { DefaultUserPrefix = prefix }(DefaultUserPrefix)
+	DefaultUserPrefix = ""
+
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "prefixes"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+
+	subjectNames := func() []string {
+		crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "prefixes-devs-view", metav1.GetOptions{})
+		assert.NoError(t, err)
+		names := []string{}
+		for _, subject := range crb.Subjects {
+			names = append(names, subject.Name)
+		}
+		return names
+	}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, []string{"joe"}, subjectNames())
+
+	// Setting a default prefix updates the binding to the prefixed name
+	DefaultUserPrefix = "oidc:"
+	r = Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, []string{"oidc:joe"}, subjectNames())
+
+	// Names that carry the prefix already stay the same
+	rbacDef.RBACBindings[0].Subjects[0].Name = "oidc:joe"
+	client.ClearActions()
+	r = Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, []string{"oidc:joe"}, subjectNames())
+	for _, action := range client.Actions() {
+		assert.NotEqual(t, "create", action.GetVerb())
+	}
+}
+
+func TestReconcileRenamedClusterRoleBinding(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "scanned"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "ops",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "ops"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	_, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "scanned-ops-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// The binding under the new name exists before the old one is pruned
+	rbacDef.RBACBindings[0].ClusterRoleBindings[0].Name = "crb-ops-view"
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	writes := []string{}
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource != "clusterrolebindings" {
+			continue
+		}
+		switch action := action.(type) {
+		case k8stesting.CreateAction:
+			writes = append(writes, "create "+action.GetObject().(*rbacv1.ClusterRoleBinding).Name)
+		case k8stesting.DeleteAction:
+			writes = append(writes, "delete "+action.GetName())
+		}
+	}
+	assert.Equal(t, []string{"create crb-ops-view", "delete scanned-ops-view"}, writes)
+
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, crbs.Items, 1) {
+		assert.Equal(t, "crb-ops-view", crbs.Items[0].Name)
 	}
 }
@@ -0,0 +1,127 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// strippedMetadata tells whether an existing object is the requested one with
+// the label, annotation, or owner references rbac-manager tracks it by
+// removed by someone else. The object must still carry either our owner
+// references, next to which it may have other owners, or our managed-by
+// annotation and no owners, so that unmanaged objects with the same name and
+// spec are left to the conflict policy.
+func (r *Reconciler) strippedMetadata(existing metav1.Object, requested *metav1.ObjectMeta) bool {
+	if r.rbacDef == nil {
+		return false
+	}
+
+	managedBy := existing.GetAnnotations()[kube.ManagedByAnnotation] == r.rbacDef.Name
+	if r.owns(existing) && managedBy && existing.GetLabels()[kube.LabelKey] == kube.LabelValue {
+		return false
+	}
+
+	ownerRefs := existing.GetOwnerReferences()
+	if len(ownerRefs) > 0 {
+		if !ownerRefsMatch(&ownerRefs, &requested.OwnerReferences) {
+			return false
+		}
+	} else if !managedBy {
+		return false
+	}
+
+	spec, ok := objectSpec(existing)
+	return ok && specHash(requested, spec) == requested.Annotations[kube.SpecHashAnnotation]
+}
+
+// objectSpec returns the hashed part of an existing object
+func objectSpec(existing metav1.Object) (interface{}, bool) {
+	switch o := existing.(type) {
+	case *rbacv1.RoleBinding:
+		return bindingSpec(o.RoleRef, o.Subjects), true
+	case *rbacv1.ClusterRoleBinding:
+		return bindingSpec(o.RoleRef, o.Subjects), true
+	case *v1.ServiceAccount:
+		return serviceAccountSpec(o), true
+	case *rbacv1.Role:
+		return roleSpec(o.Rules), true
+	}
+	return nil, false
+}
+
+// relabel restores the label, annotations, and owner references of the
+// requested object on an existing one that lost them, keeping references to
+// other owners. The patch is
+// conditional on the resource version of existing so that concurrent changes
+// are picked up by the next reconcile instead of being overwritten.
+func (r *Reconciler) relabel(kind string, existing metav1.Object, requested *metav1.ObjectMeta) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": existing.GetResourceVersion(),
+			"labels":          requested.Labels,
+			"annotations":     requested.Annotations,
+			"ownerReferences": withOwnerRefs(existing.GetOwnerReferences(), requested.OwnerReferences),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	resource, err := r.mergePatch(kind, existing, patch)
+	if err != nil {
+		return err
+	}
+
+	metrics.ChangeCounter.WithLabelValues(resource, "relabeled").Inc()
+	r.recordChange(kind, "relabeled", causeDrift)
+	return nil
+}
+
+// mergePatch applies a JSON merge patch to an existing object and returns the
+// resource it belongs to
+func (r *Reconciler) mergePatch(kind string, existing metav1.Object, patch []byte) (string, error) {
+	var resource string
+	var err error
+	switch existing.(type) {
+	case *rbacv1.RoleBinding:
+		resource = "rolebindings"
+		_, err = kube.RBAC(r.Clientset).RoleBindings(existing.GetNamespace()).Patch(r.context(), existing.GetName(), types.MergePatchType, patch, kube.PatchOptions)
+	case *rbacv1.ClusterRoleBinding:
+		resource = "clusterrolebindings"
+		_, err = kube.RBAC(r.Clientset).ClusterRoleBindings().Patch(r.context(), existing.GetName(), types.MergePatchType, patch, kube.PatchOptions)
+	case *v1.ServiceAccount:
+		resource = "serviceaccounts"
+		_, err = r.Clientset.CoreV1().ServiceAccounts(existing.GetNamespace()).Patch(r.context(), existing.GetName(), types.MergePatchType, patch, kube.PatchOptions)
+	case *rbacv1.Role:
+		resource = "roles"
+		_, err = kube.RBAC(r.Clientset).Roles(existing.GetNamespace()).Patch(r.context(), existing.GetName(), types.MergePatchType, patch, kube.PatchOptions)
+	default:
+		return "", fmt.Errorf("cannot patch %v", kind)
+	}
+	if resource != "" {
+		r.noteWrite(resource)
+	}
+	return resource, err
+}
@@ -0,0 +1,55 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// owns reports whether an existing object is managed by the RBAC Definition
+// being reconciled. In the cluster RBAC Manager runs in this is decided by
+// owner references, regardless of their controller and blockOwnerDeletion
+// flags. Remote clusters can't refer to the RBAC Definition, so
+// there objects are owned if they carry the management label, name the RBAC
+// Definition in the managed-by annotation, and have no owner references.
+func (r *Reconciler) owns(existing metav1.Object) bool {
+	if r.Cluster == "" {
+		// Without a definition nothing is owned, not even objects that have
+		// no owner references either
+		ownerRefs := r.definitionOwnerRefs()
+		return len(ownerRefs) > 0 && hasOwners(existing.GetOwnerReferences(), ownerRefs)
+	}
+
+	return r.rbacDef != nil &&
+		len(existing.GetOwnerReferences()) == 0 &&
+		existing.GetLabels()[kube.LabelKey] == kube.LabelValue &&
+		existing.GetAnnotations()[kube.ManagedByAnnotation] == r.rbacDef.Name
+}
+
+// Prune deletes every resource owned by an RBAC Definition, which is how
+// resources are removed from remote clusters where garbage collection can't
+// follow owner references
+func (r *Reconciler) Prune(rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
+	logrus.Infof("Pruning resources of RBACDefinition %v from cluster %v", rbacDef.Name, r.Cluster)
+
+	empty := rbacDef.DeepCopy()
+	empty.RBACBindings = nil
+	empty.Imports = nil
+	return r.Reconcile(empty)
+}
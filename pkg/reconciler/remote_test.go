@@ -0,0 +1,110 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+func TestReconcileRemoteCluster(t *testing.T) {
+	otherDefinition := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "other-admins",
+			Labels:      kube.Labels,
+			Annotations: map[string]string{kube.ManagedByAnnotation: "other"},
+		},
+		RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "admin"},
+	}
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		otherDefinition,
+	)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "remote"
+	rbacDef.UID = "remote-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "admins",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "admin"}},
+		RoleBindings:        []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "web"}},
+	}}
+
+	r := Reconciler{Clientset: client, Cluster: "workload-1"}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Empty(t, rbacDef.Status.Conditions, "remote conflicts should not be reported on the RBAC Definition")
+
+	crbs, _ := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.Len(t, crbs.Items, 2)
+	for _, crb := range crbs.Items {
+		assert.Empty(t, crb.OwnerReferences, "remote resources can't have owner references")
+	}
+
+	rbs, _ := client.RbacV1().RoleBindings("web").List(context.TODO(), metav1.ListOptions{})
+	if assert.Len(t, rbs.Items, 1) {
+		assert.Equal(t, "remote", rbs.Items[0].Annotations[kube.ManagedByAnnotation])
+		assert.True(t, r.owns(&rbs.Items[0].ObjectMeta))
+	}
+
+	// A second reconcile finds its resources by annotation and changes nothing
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	crbs, _ = client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.Len(t, crbs.Items, 2)
+
+	assert.NoError(t, r.Prune(&rbacDef))
+	crbs, _ = client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	if assert.Len(t, crbs.Items, 1, "only resources of the pruned RBAC Definition should be deleted") {
+		assert.Equal(t, "other-admins", crbs.Items[0].Name)
+	}
+	rbs, _ = client.RbacV1().RoleBindings("web").List(context.TODO(), metav1.ListOptions{})
+	assert.Empty(t, rbs.Items)
+	assert.Len(t, rbacDef.RBACBindings, 1, "Prune should not change the RBAC Definition")
+}
+
+func TestOwnsRemote(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "remote"
+
+	r := Reconciler{Cluster: "workload-1"}
+	r.setDefinition(&rbacDef)
+	assert.Nil(t, r.definitionOwnerRefs())
+
+	objectMeta := metav1.ObjectMeta{
+		Labels:      kube.Labels,
+		Annotations: map[string]string{kube.ManagedByAnnotation: "remote"},
+	}
+	assert.True(t, r.owns(&objectMeta))
+
+	objectMeta.Annotations[kube.ManagedByAnnotation] = "other"
+	assert.False(t, r.owns(&objectMeta))
+
+	objectMeta.Annotations[kube.ManagedByAnnotation] = "remote"
+	objectMeta.OwnerReferences = rbacDefOwnerRefs(&rbacDef)
+	assert.False(t, r.owns(&objectMeta), "objects owned through references belong to a local RBAC Definition")
+
+	objectMeta.OwnerReferences = nil
+	objectMeta.Labels = nil
+	assert.False(t, r.owns(&objectMeta))
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// ReconcileResult counts the resources a reconcile requested and those of
+// them that were in place once it finished
+type ReconcileResult struct {
+	Desired rbacmanagerv1beta1.ResourceCounts
+	Actual  rbacmanagerv1beta1.ResourceCounts
+}
+
+// noteInPlace records that a requested resource exists in its requested
+// state during the current reconcile
+func (r *Reconciler) noteInPlace(kind string, objectMeta *metav1.ObjectMeta) {
+	r.resultMux.Lock()
+	defer r.resultMux.Unlock()
+	if r.inPlace == nil {
+		r.inPlace = map[string]bool{}
+	}
+	r.inPlace[objectKey(kind, objectMeta)] = true
+}
+
+// noteSkipped records that a requested resource was left out under the Skip
+// conflict policy, so it isn't expected to be in place
+func (r *Reconciler) noteSkipped(kind string, objectMeta *metav1.ObjectMeta) {
+	r.resultMux.Lock()
+	defer r.resultMux.Unlock()
+	if r.skipped == nil {
+		r.skipped = map[string]bool{}
+	}
+	r.skipped[objectKey(kind, objectMeta)] = true
+}
+
+// reconcileResult counts the resources p requested and those of them that
+// were found or created during the current reconcile
+func (r *Reconciler) reconcileResult(p *Parser) ReconcileResult {
+	r.resultMux.Lock()
+	defer r.resultMux.Unlock()
+
+	result := ReconcileResult{}
+	seen := map[string]bool{}
+	count := func(kind string, objectMeta *metav1.ObjectMeta, desired *int, actual *int) {
+		key := objectKey(kind, objectMeta)
+		if seen[key] || r.skipped[key] {
+			return
+		}
+		seen[key] = true
+		*desired++
+		if r.inPlace[key] {
+			*actual++
+		}
+	}
+
+	for i := range p.parsedServiceAccounts {
+		count("ServiceAccount", &p.parsedServiceAccounts[i].ObjectMeta, &result.Desired.ServiceAccounts, &result.Actual.ServiceAccounts)
+	}
+	for i := range p.parsedRoleBindings {
+		count("RoleBinding", &p.parsedRoleBindings[i].ObjectMeta, &result.Desired.RoleBindings, &result.Actual.RoleBindings)
+	}
+	for i := range p.parsedClusterRoleBindings {
+		count("ClusterRoleBinding", &p.parsedClusterRoleBindings[i].ObjectMeta, &result.Desired.ClusterRoleBindings, &result.Actual.ClusterRoleBindings)
+	}
+	return result
+}
+
+// setResourceCounts records result in the status of rbacDef
+func setResourceCounts(rbacDef *rbacmanagerv1beta1.RBACDefinition, result ReconcileResult) {
+	desired, actual := result.Desired, result.Actual
+	rbacDef.Status.Desired = &desired
+	rbacDef.Status.Actual = &actual
+}
+
+// missingResources describes the kinds of which fewer resources are in place
+// than requested according to the status of rbacDef, or returns an empty
+// string if every requested resource is in place
+func missingResources(rbacDef *rbacmanagerv1beta1.RBACDefinition) string {
+	desired, actual := rbacDef.Status.Desired, rbacDef.Status.Actual
+	if desired == nil || actual == nil {
+		return ""
+	}
+
+	missing := []string{}
+	for _, kind := range []struct {
+		name            string
+		desired, actual int
+	}{
+		{"Service Accounts", desired.ServiceAccounts, actual.ServiceAccounts},
+		{"Role Bindings", desired.RoleBindings, actual.RoleBindings},
+		{"Cluster Role Bindings", desired.ClusterRoleBindings, actual.ClusterRoleBindings},
+	} {
+		if kind.actual < kind.desired {
+			missing = append(missing, fmt.Sprintf("%d of %d %s", kind.actual, kind.desired, kind.name))
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	return "Only " + strings.Join(missing, ", ") + " are in place"
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestResourceCounts(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "api"}},
+	)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "counts"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "ci",
+		Subjects: []rbacmanagerv1beta1.Subject{
+			{Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "ci", Namespace: "web"}},
+			{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}},
+		},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{
+			{ClusterRole: "edit", Namespace: "web"},
+			{ClusterRole: "edit", Namespace: "api"},
+		},
+	}}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	expected := rbacmanagerv1beta1.ResourceCounts{ServiceAccounts: 1, RoleBindings: 2, ClusterRoleBindings: 1}
+	assert.Equal(t, &expected, rbacDef.Status.Desired)
+	assert.Equal(t, &expected, rbacDef.Status.Actual)
+
+	SetReadyCondition(&rbacDef, nil)
+	ready := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionReady)
+	assert.Equal(t, metav1.ConditionTrue, ready.Status)
+
+	// Role Bindings that fail to be created are only logged, but counted as
+	// missing
+	client.PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "api" {
+			return true, nil, errors.New("denied")
+		}
+		return false, nil, nil
+	})
+	rbs, err := client.RbacV1().RoleBindings("api").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	for _, rb := range rbs.Items {
+		assert.NoError(t, client.RbacV1().RoleBindings("api").Delete(context.TODO(), rb.Name, metav1.DeleteOptions{}))
+	}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, &expected, rbacDef.Status.Desired)
+	assert.Equal(t, 1, rbacDef.Status.Actual.RoleBindings)
+
+	SetReadyCondition(&rbacDef, nil)
+	ready = meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionReady)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, "ResourcesMissing", ready.Reason)
+	assert.Equal(t, "Only 1 of 2 Role Bindings are in place", ready.Message)
+}
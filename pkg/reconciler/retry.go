@@ -0,0 +1,143 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// Categories of the errors counted by metrics.ChangeErrors
+const (
+	// categoryAlreadyExists is a create of an object that exists already
+	categoryAlreadyExists = "already_exists"
+	// categoryConflict is a write that raced with another change to the object
+	categoryConflict = "conflict"
+	// categoryNotFound is a delete of an object that is gone already
+	categoryNotFound = "not_found"
+	// categoryForbidden is a write RBAC Manager isn't allowed to make
+	categoryForbidden = "forbidden"
+	// categoryPolicy is a write denied by an admission webhook
+	categoryPolicy = "policy"
+	// categoryQuota is a write denied by the ResourceQuota or LimitRanger
+	// admission plugins, which is retried once the namespace settles
+	categoryQuota = "quota"
+	// categoryInvalid is a write of an object the API server rejects
+	categoryInvalid = "invalid"
+	// categoryTransient is a timeout, throttling, or server error that may
+	// succeed when retried
+	categoryTransient = "transient"
+	categoryOther     = "other"
+)
+
+// MaxObjectRetries is the number of times a create or delete of a single
+// object is retried after transient errors during one reconcile
+var MaxObjectRetries = 3
+
+// ObjectRetryDelay is how long to wait before the first retry of an object,
+// doubling with each further retry
+var ObjectRetryDelay = 200 * time.Millisecond
+
+// errorCategory classifies an error returned by a create or delete
+func errorCategory(err error) string {
+	if _, ok := parsePolicyDenial(err); ok {
+		return categoryPolicy
+	}
+
+	if isQuotaDenial(err) {
+		return categoryQuota
+	}
+
+	switch {
+	case apierrors.IsAlreadyExists(err):
+		return categoryAlreadyExists
+	case apierrors.IsConflict(err):
+		return categoryConflict
+	case apierrors.IsNotFound(err):
+		return categoryNotFound
+	case apierrors.IsForbidden(err):
+		return categoryForbidden
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return categoryInvalid
+	case apierrors.IsServerTimeout(err), apierrors.IsTimeout(err), apierrors.IsTooManyRequests(err),
+		apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err), apierrors.IsUnexpectedServerError(err):
+		return categoryTransient
+	}
+	return categoryOther
+}
+
+// write makes a create or delete of an object, retrying it after transient
+// errors while the object has retries left in this reconcile. Every failed
+// attempt is counted by category, and the error of the last one is returned.
+func (r *Reconciler) write(kind, action string, objectMeta *metav1.ObjectMeta, call func() error) error {
+	for {
+		err := call()
+		// A failed write may still have been made
+		r.noteWrite(writtenResource(kind))
+		if err == nil {
+			if action == "create" {
+				r.quotaSettled(objectMeta.Namespace)
+			}
+			return nil
+		}
+
+		category := errorCategory(err)
+		metrics.ChangeErrors.WithLabelValues(kind, action, category).Inc()
+		if category == categoryQuota {
+			r.noteQuotaDenial(objectMeta.Namespace)
+		}
+		if category != categoryTransient {
+			return err
+		}
+
+		retry, ok := r.takeRetry(kind, objectMeta)
+		if !ok {
+			logrus.Warnf("Giving up on %v of %v %v after %d retries: %v", action, kind, objectMeta.Name, MaxObjectRetries, err)
+			return err
+		}
+		delay := ObjectRetryDelay << uint(retry)
+		logrus.Debugf("Retrying %v of %v %v in %v: %v", action, kind, objectMeta.Name, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-r.context().Done():
+			// The reconcile ran out of time, retrying is left to the next one
+			return err
+		}
+	}
+}
+
+// takeRetry uses up one retry of an object and returns how many it had used
+// before, or reports that it has none left. Creates and deletes of the same
+// object share its retries.
+func (r *Reconciler) takeRetry(kind string, objectMeta *metav1.ObjectMeta) (int, bool) {
+	r.retriesMux.Lock()
+	defer r.retriesMux.Unlock()
+
+	key := objectKey(kind, objectMeta)
+	used := r.retries[key]
+	if used >= MaxObjectRetries {
+		return used, false
+	}
+	if r.retries == nil {
+		r.retries = map[string]int{}
+	}
+	r.retries[key] = used + 1
+	return used, true
+}
@@ -0,0 +1,204 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestErrorCategory(t *testing.T) {
+	resource := schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "rolebindings"}
+	policyDenial := &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    400,
+		Reason:  metav1.StatusReasonBadRequest,
+		Message: "admission webhook \"validate.kyverno.svc-fail\" denied the request: no edit",
+	}}
+
+	tests := []struct {
+		err      error
+		category string
+	}{
+		{apierrors.NewAlreadyExists(resource, "devs"), categoryAlreadyExists},
+		{apierrors.NewConflict(resource, "devs", errors.New("precondition failed")), categoryConflict},
+		{apierrors.NewNotFound(resource, "devs"), categoryNotFound},
+		{apierrors.NewForbidden(resource, "devs", errors.New("no bind permission")), categoryForbidden},
+		{policyDenial, categoryPolicy},
+		{apierrors.NewForbidden(resource, "devs", errors.New("status unknown for quota: compute")), categoryQuota},
+		{apierrors.NewForbidden(resource, "devs", errors.New("exceeded quota: objects, requested: count/rolebindings.rbac.authorization.k8s.io=1")), categoryQuota},
+		{apierrors.NewInvalid(schema.GroupKind{Group: resource.Group, Kind: "RoleBinding"}, "devs", nil), categoryInvalid},
+		{apierrors.NewServerTimeout(resource, "create", 1), categoryTransient},
+		{apierrors.NewTooManyRequests("slow down", 1), categoryTransient},
+		{apierrors.NewInternalError(errors.New("etcd unavailable")), categoryTransient},
+		{errors.New("connection reset"), categoryOther},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.category, errorCategory(tt.err), tt.err.Error())
+	}
+}
+
+func TestReconcileChangeErrors(t *testing.T) {
+	defer func(retries int, delay time.Duration) {
+		MaxObjectRetries, ObjectRetryDelay = retries, delay
+	}(MaxObjectRetries, ObjectRetryDelay)
+	MaxObjectRetries = 3
+	ObjectRetryDelay = 0
+	defer func() { PolicyBlocks = NewPolicyBlocks(time.Minute, time.Hour) }()
+
+	resource := schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings"}
+	tests := []struct {
+		name     string
+		errs     []error
+		category string
+		attempts int
+		failures int
+		created  bool
+	}{{
+		name:     "transient errors are retried",
+		errs:     []error{apierrors.NewServerTimeout(resource, "create", 1), apierrors.NewTooManyRequests("slow down", 1)},
+		category: categoryTransient,
+		attempts: 3,
+		failures: 2,
+		created:  true,
+	}, {
+		name: "retries of an object are capped",
+		errs: []error{
+			apierrors.NewInternalError(errors.New("etcd unavailable")),
+			apierrors.NewInternalError(errors.New("etcd unavailable")),
+			apierrors.NewInternalError(errors.New("etcd unavailable")),
+			apierrors.NewInternalError(errors.New("etcd unavailable")),
+			apierrors.NewInternalError(errors.New("etcd unavailable")),
+		},
+		category: categoryTransient,
+		attempts: 4,
+		failures: 4,
+	}, {
+		name:     "forbidden errors are not retried",
+		errs:     []error{apierrors.NewForbidden(resource, "errors-devs-view", errors.New("no bind permission"))},
+		category: categoryForbidden,
+		attempts: 1,
+		failures: 1,
+	}, {
+		name:     "existing objects are not retried",
+		errs:     []error{apierrors.NewAlreadyExists(resource, "errors-devs-view")},
+		category: categoryAlreadyExists,
+		attempts: 1,
+		failures: 1,
+	}, {
+		name: "policy denials are not retried",
+		errs: []error{&apierrors.StatusError{ErrStatus: metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    400,
+			Reason:  metav1.StatusReasonBadRequest,
+			Message: "admission webhook \"validate.kyverno.svc-fail\" denied the request: no view",
+		}}},
+		category: categoryPolicy,
+		attempts: 1,
+		failures: 1,
+	}, {
+		name:     "invalid objects are not retried",
+		errs:     []error{apierrors.NewInvalid(schema.GroupKind{Group: resource.Group, Kind: "ClusterRoleBinding"}, "errors-devs-view", nil)},
+		category: categoryInvalid,
+		attempts: 1,
+		failures: 1,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			PolicyBlocks = NewPolicyBlocks(time.Hour, time.Hour)
+			defer PolicyBlocks.Forget("errors")
+			client := fake.NewSimpleClientset()
+			attempts := 0
+			client.PrependReactor("create", "clusterrolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return true, nil, tt.errs[attempts-1]
+				}
+				return false, nil, nil
+			})
+
+			counter := metrics.ChangeErrors.WithLabelValues("ClusterRoleBinding", "create", tt.category)
+			before := testutil.ToFloat64(counter)
+
+			rbacDef := errorsDefinition()
+			r := Reconciler{Clientset: client}
+			assert.NoError(t, r.Reconcile(&rbacDef))
+
+			assert.Equal(t, tt.attempts, attempts)
+			assert.Equal(t, float64(tt.failures), testutil.ToFloat64(counter)-before)
+			_, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "errors-devs-view", metav1.GetOptions{})
+			assert.Equal(t, tt.created, err == nil)
+		})
+	}
+}
+
+func TestReconcileChangeErrorsOnDelete(t *testing.T) {
+	rbacDef := errorsDefinition()
+	client := fake.NewSimpleClientset()
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	// The binding is no longer requested, but was changed or deleted by
+	// something else in the meantime
+	rbacDef.RBACBindings[0].ClusterRoleBindings = nil
+	for _, err := range []error{
+		apierrors.NewConflict(schema.GroupResource{Resource: "clusterrolebindings"}, "errors-devs-view", errors.New("precondition failed")),
+		apierrors.NewNotFound(schema.GroupResource{Resource: "clusterrolebindings"}, "errors-devs-view"),
+	} {
+		deleteErr := err
+		client.PrependReactor("delete", "clusterrolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, deleteErr
+		})
+
+		counter := metrics.ChangeErrors.WithLabelValues("ClusterRoleBinding", "delete", errorCategory(err))
+		before := testutil.ToFloat64(counter)
+		r = Reconciler{Clientset: client}
+		_ = r.Reconcile(&rbacDef)
+		assert.Equal(t, float64(1), testutil.ToFloat64(counter)-before, err.Error())
+	}
+}
+
+// errorsDefinition requests a single Cluster Role Binding
+func errorsDefinition() rbacmanagerv1beta1.RBACDefinition {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "errors"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+	}}
+	return rbacDef
+}
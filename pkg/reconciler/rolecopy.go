@@ -0,0 +1,266 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// sourceRole fetches the Role a roleFrom entry copies, fetching each Role
+// only once per parse
+func (p *Parser) sourceRole(source *rbacmanagerv1beta1.RoleSource) (*rbacv1.Role, error) {
+	key := source.Namespace + "/" + source.Name
+	if role, ok := p.sourceRoles[key]; ok {
+		return role, nil
+	}
+
+	role, err := kube.RBAC(p.Clientset).Roles(source.Namespace).Get(p.context(), source.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("Role %s does not exist", key)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if p.sourceRoles == nil {
+		p.sourceRoles = map[string]*rbacv1.Role{}
+	}
+	p.sourceRoles[key] = role
+	return role, nil
+}
+
+// copyRole requests a copy of source in namespace. The namespace of source
+// is skipped so that Role Bindings there reference the source itself, which
+// is never modified.
+func (p *Parser) copyRole(source *rbacv1.Role, namespace string) {
+	if namespace == source.Namespace {
+		return
+	}
+	for _, role := range p.parsedRoles {
+		if role.Namespace == namespace && role.Name == source.Name {
+			return
+		}
+	}
+
+	p.parsedRoles = append(p.parsedRoles, rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            source.Name,
+			Namespace:       namespace,
+			OwnerReferences: p.objectOwnerRefs(),
+			Labels:          kube.Labels,
+			Annotations:     map[string]string{kube.CopiedFromAnnotation: source.Namespace + "/" + source.Name},
+		},
+		Rules: source.Rules,
+	})
+}
+
+// CopiesRole reports whether a roleBindings entry of rbacDef copies the Role
+// name from namespace. Imported definitions are not considered.
+func CopiesRole(rbacDef *rbacmanagerv1beta1.RBACDefinition, namespace string, name string) bool {
+	for _, rbacBinding := range rbacDef.RBACBindings {
+		for _, rb := range rbacBinding.RoleBindings {
+			if rb.RoleFrom != nil && rb.RoleFrom.Namespace == namespace && rb.RoleFrom.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// roleSpec is the part of a Role that is hashed
+func roleSpec(rules []rbacv1.PolicyRule) interface{} {
+	if len(rules) == 0 {
+		return nil
+	}
+	return rules
+}
+
+func roleMatches(existingRole *rbacv1.Role, requestedRole *rbacv1.Role) bool {
+	if matches, ok := specHashesMatch(&existingRole.ObjectMeta, roleSpec(existingRole.Rules), &requestedRole.ObjectMeta); ok {
+		return matches
+	}
+
+	return metaMatches(&existingRole.ObjectMeta, &requestedRole.ObjectMeta) &&
+		reflect.DeepEqual(roleSpec(existingRole.Rules), roleSpec(requestedRole.Rules))
+}
+
+// reconcileRoles creates, updates, and deletes the Role copies of an RBAC
+// Definition. Unlike bindings, copies are updated in place when their source
+// changes so that the bindings referencing them keep working throughout.
+func (r *Reconciler) reconcileRoles(requested *[]rbacv1.Role) error {
+	for i := range *requested {
+		role := &(*requested)[i]
+		r.annotate(&role.ObjectMeta, roleSpec(role.Rules))
+	}
+
+	existing, err := listAllRoles(r.context(), r.Clientset, kube.ListOptions)
+	if err != nil {
+		metrics.ErrorCounter.Inc()
+		return err
+	}
+
+	owned := map[string]*rbacv1.Role{}
+	for i := range existing.Items {
+		if r.owns(&existing.Items[i].ObjectMeta) {
+			owned[objectKey("Role", &existing.Items[i].ObjectMeta)] = &existing.Items[i]
+		}
+	}
+
+	rolesToCreate := []rbacv1.Role{}
+	rolesToUpdate := []rbacv1.Role{}
+	childUpdates := []childMetadataUpdate{}
+	ownerRepairs := []ownerRefRepair{}
+	requestedKeys := map[string]bool{}
+
+	for i, requestedRole := range *requested {
+		key := objectKey("Role", &requestedRole.ObjectMeta)
+		requestedKeys[key] = true
+
+		existingRole, ok := owned[key]
+		if !ok {
+			rolesToCreate = append(rolesToCreate, requestedRole)
+		} else if !roleMatches(existingRole, &requestedRole) {
+			updated := existingRole.DeepCopy()
+			updated.Annotations = labels.Merge(updated.Annotations, requestedRole.Annotations)
+			updated.Rules = requestedRole.Rules
+			updated.OwnerReferences = withOwnerRefs(updated.OwnerReferences, requestedRole.OwnerReferences)
+			rolesToUpdate = append(rolesToUpdate, *updated)
+		} else {
+			r.recordApplied("Role", &requestedRole.ObjectMeta)
+			childUpdates = staleChildMetadata(childUpdates, "Role", existingRole, &(*requested)[i].ObjectMeta)
+			ownerRepairs = r.driftedOwnerRefs(ownerRepairs, "Role", existingRole)
+			logrus.Debugf("Role already exists %v", requestedRole.Name)
+		}
+	}
+	r.repairOwnerRefs(ownerRepairs)
+	r.updateChildMetadata(childUpdates)
+
+	rolesToDelete := []rbacv1.Role{}
+	prunedRoles := []*metav1.ObjectMeta{}
+	for key, existingRole := range owned {
+		if !requestedKeys[key] {
+			rolesToDelete = append(rolesToDelete, *existingRole)
+			prunedRoles = append(prunedRoles, &existingRole.ObjectMeta)
+		}
+	}
+	if !r.allowPrunes("Role", prunedRoles) {
+		rolesToDelete = nil
+	}
+
+	r.validateCreates("Role", len(rolesToCreate), func(i int) *metav1.ObjectMeta {
+		return &rolesToCreate[i].ObjectMeta
+	}, func(i int) error {
+		_, err := kube.RBAC(r.Clientset).Roles(rolesToCreate[i].Namespace).Create(r.context(), &rolesToCreate[i], dryRunCreate())
+		return err
+	})
+
+	r.forEach(len(rolesToDelete), func(i int) {
+		existingRole := &rolesToDelete[i]
+		reason := r.pruneReason("Role", &existingRole.ObjectMeta, false, "")
+		r.changeLog().Infof("Deleting Role %v/%v: %v", existingRole.Namespace, existingRole.Name, reason)
+		err := r.write("Role", "delete", &existingRole.ObjectMeta, func() error {
+			return kube.RBAC(r.Clientset).Roles(existingRole.Namespace).Delete(r.context(), existingRole.Name, deleteOptions(&existingRole.ObjectMeta))
+		})
+		if apierrors.IsConflict(err) {
+			r.skipStaleDelete("Role", &existingRole.ObjectMeta)
+		} else if apierrors.IsNotFound(err) {
+			r.forgetApplied("Role", &existingRole.ObjectMeta)
+			logrus.Debugf("Role %v was already deleted", existingRole.Name)
+		} else if err != nil {
+			logrus.Errorf("Error deleting Role: %v", err)
+			metrics.ErrorCounter.Inc()
+		} else {
+			r.forgetApplied("Role", &existingRole.ObjectMeta)
+			metrics.ChangeCounter.WithLabelValues("roles", "delete").Inc()
+			r.recordChange("Role", "delete", causeSpecChange)
+			r.prunedEvent("Role", &existingRole.ObjectMeta, reason)
+		}
+	})
+	r.prunedApproved("Role")
+
+	r.forEach(len(rolesToUpdate), func(i int) {
+		roleToUpdate := &rolesToUpdate[i]
+		r.changeLog().Infof("Updating Role %v/%v", roleToUpdate.Namespace, roleToUpdate.Name)
+		_, err := kube.RBAC(r.Clientset).Roles(roleToUpdate.Namespace).Update(r.context(), roleToUpdate, kube.UpdateOptions)
+		if err != nil {
+			logrus.Errorf("Error updating Role: %v", err)
+			metrics.ErrorCounter.Inc()
+		} else {
+			r.recordApplied("Role", &roleToUpdate.ObjectMeta)
+			metrics.ChangeCounter.WithLabelValues("roles", "update").Inc()
+			r.recordChange("Role", "update", causeSpecChange)
+		}
+	})
+
+	r.forEach(len(rolesToCreate), func(i int) {
+		roleToCreate := &rolesToCreate[i]
+		if r.skippedDelete("Role", &roleToCreate.ObjectMeta) || r.heldByPolicy("Role", &roleToCreate.ObjectMeta) ||
+			r.rejectedByDryRun("Role", &roleToCreate.ObjectMeta) {
+			return
+		}
+		r.changeLog().Infof("Creating Role %v/%v", roleToCreate.Namespace, roleToCreate.Name)
+		err := r.write("Role", "create", &roleToCreate.ObjectMeta, func() error {
+			_, err := kube.RBAC(r.Clientset).Roles(roleToCreate.Namespace).Create(r.context(), roleToCreate, kube.CreateOptions)
+			return err
+		})
+		if apierrors.IsAlreadyExists(err) {
+			r.resolveConflict("Role", &roleToCreate.ObjectMeta, func() (metav1.Object, error) {
+				return kube.RBAC(r.Clientset).Roles(roleToCreate.Namespace).Get(r.context(), roleToCreate.Name, metav1.GetOptions{})
+			}, func(existing metav1.Object) error {
+				return r.adoptRole(existing.(*rbacv1.Role), roleToCreate)
+			})
+		} else if r.deniedByPolicy("Role", &roleToCreate.ObjectMeta, err) {
+			return
+		} else if err != nil {
+			logrus.Errorf("Error creating Role: %v", err)
+			metrics.ErrorCounter.Inc()
+		} else {
+			r.recordApplied("Role", &roleToCreate.ObjectMeta)
+			metrics.ChangeCounter.WithLabelValues("roles", "create").Inc()
+			r.recordChange("Role", "create", causeSpecChange)
+		}
+	})
+
+	return nil
+}
+
+func (r *Reconciler) adoptRole(existing *rbacv1.Role, requested *rbacv1.Role) error {
+	err := checkAdoptable(&existing.ObjectMeta)
+	if err != nil {
+		return err
+	}
+
+	adoptObjectMeta(&existing.ObjectMeta, &requested.ObjectMeta)
+	existing.Rules = requested.Rules
+
+	_, err = kube.RBAC(r.Clientset).Roles(existing.Namespace).Update(r.context(), existing, kube.UpdateOptions)
+	if err != nil {
+		return err
+	}
+
+	metrics.ChangeCounter.WithLabelValues("roles", "adopt").Inc()
+	r.recordChange("Role", "adopt", causeAdoption)
+	return nil
+}
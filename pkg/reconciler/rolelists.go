@@ -0,0 +1,80 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// roleBindingEntries splits a roleBindings entry that lists several roles in
+// clusterRoles and roles into one entry per role, cluster roles first, each
+// in the order listed. Other entries are returned as they are. An entry that
+// names its bindings gets the role appended to the name when it binds more
+// than one role, so every binding keeps its own name.
+func roleBindingEntries(rb rbacmanagerv1beta1.RoleBinding) []rbacmanagerv1beta1.RoleBinding {
+	if len(rb.ClusterRoles) == 0 && len(rb.Roles) == 0 {
+		return []rbacmanagerv1beta1.RoleBinding{rb}
+	}
+
+	several := len(rb.ClusterRoles)+len(rb.Roles) > 1
+	entry := func(clusterRole, role string) rbacmanagerv1beta1.RoleBinding {
+		split := *rb.DeepCopy()
+		split.ClusterRoles = nil
+		split.Roles = nil
+		split.ClusterRole = clusterRole
+		split.Role = role
+		if rb.Name != "" && several {
+			split.Name = fmt.Sprintf("%v-%v", rb.Name, clusterRole+role)
+		}
+		return split
+	}
+
+	entries := []rbacmanagerv1beta1.RoleBinding{}
+	for _, clusterRole := range rb.ClusterRoles {
+		entries = append(entries, entry(clusterRole, ""))
+	}
+	for _, role := range rb.Roles {
+		entries = append(entries, entry("", role))
+	}
+	return entries
+}
+
+// validateRoleLists rejects empty and repeated names in the clusterRoles and
+// roles of a roleBindings entry
+func validateRoleLists(rb *rbacmanagerv1beta1.RoleBinding) error {
+	seen := map[string]string{}
+	check := func(path string, names []string) error {
+		for index, name := range names {
+			if name == "" {
+				return &ParseError{Path: fmt.Sprintf("%s[%d]", path, index), Reason: "role name required"}
+			}
+			// Named entries derive the binding names from the role names
+			// alone, so a name may only appear once across both lists
+			if other, ok := seen[name]; ok && (other == path || rb.Name != "") {
+				return &ParseError{Path: fmt.Sprintf("%s[%d]", path, index), Reason: fmt.Sprintf("%s is already listed in %s", name, other)}
+			}
+			seen[name] = path
+		}
+		return nil
+	}
+
+	err := check("clusterRoles", rb.ClusterRoles)
+	if err != nil {
+		return err
+	}
+	return check("roles", rb.Roles)
+}
@@ -0,0 +1,150 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"errors"
+	"sort"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// hasServiceAccountSelectors reports whether any subject of rbacDef is a
+// ServiceAccountSelector
+func hasServiceAccountSelectors(rbacDef *rbacmanagerv1beta1.RBACDefinition) bool {
+	for _, rbacBinding := range rbacDef.RBACBindings {
+		for _, subject := range rbacBinding.Subjects {
+			if subject.Kind == rbacmanagerv1beta1.ServiceAccountSelectorKind {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadServiceAccounts lists the Service Accounts and namespaces that
+// ServiceAccountSelector subjects of rbacDef are matched against. It has to be
+// called before parsing, since a failed list must not drop subjects.
+func (p *Parser) loadServiceAccounts(rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
+	if !hasServiceAccountSelectors(rbacDef) {
+		return nil
+	}
+
+	serviceAccounts, err := listAllServiceAccounts(p.context(), p.Clientset, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	namespaces, err := listNamespaces(p.context(), p.Clientset)
+	if err != nil {
+		return err
+	}
+
+	p.serviceAccounts = serviceAccounts
+	p.selectorNamespaces = namespaces
+	return nil
+}
+
+// selectServiceAccounts returns a ServiceAccount subject for every Service
+// Account matching a ServiceAccountSelector subject, sorted by namespace and
+// name. Matching Service Accounts are never created or deleted by RBAC Manager.
+func (p *Parser) selectServiceAccounts(subject *rbacmanagerv1beta1.Subject) []rbacmanagerv1beta1.Subject {
+	selected := []rbacmanagerv1beta1.Subject{}
+	if p.serviceAccounts == nil {
+		return selected
+	}
+
+	// Validation already rejected invalid selectors
+	selector, _ := metav1.LabelSelectorAsSelector(subject.Selector)
+	namespaceSelector := labels.Everything()
+	if subject.NamespaceSelector != nil {
+		namespaceSelector, _ = metav1.LabelSelectorAsSelector(subject.NamespaceSelector)
+	}
+
+	namespaces := map[string]bool{}
+	for _, namespace := range p.selectorNamespaces.Items {
+		namespaces[namespace.Name] = namespaceSelector.Matches(labels.Set(namespace.Labels))
+	}
+
+	for _, sa := range p.serviceAccounts.Items {
+		if !namespaces[sa.Namespace] || !selector.Matches(labels.Set(sa.Labels)) {
+			continue
+		}
+		if p.selectedServiceAccounts == nil {
+			p.selectedServiceAccounts = map[string]bool{}
+		}
+		p.selectedServiceAccounts[sa.Namespace+"/"+sa.Name] = true
+		selected = append(selected, rbacmanagerv1beta1.Subject{
+			Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: sa.Name, Namespace: sa.Namespace},
+		})
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		if selected[i].Namespace != selected[j].Namespace {
+			return selected[i].Namespace < selected[j].Namespace
+		}
+		return selected[i].Name < selected[j].Name
+	})
+	return selected
+}
+
+func validateServiceAccountSelector(subject *rbacmanagerv1beta1.Subject) error {
+	if subject.Kind != rbacmanagerv1beta1.ServiceAccountSelectorKind {
+		if subject.Selector != nil || subject.NamespaceSelector != nil {
+			return errors.New("selector and namespaceSelector are only supported for ServiceAccountSelector subjects")
+		}
+		return nil
+	}
+
+	if subject.Name != "" || subject.Namespace != "" {
+		return errors.New("ServiceAccountSelector subjects take selectors and no name or namespace")
+	}
+	if len(subject.ImagePullSecrets) > 0 {
+		return errors.New("imagePullSecrets are only supported for ServiceAccount subjects")
+	}
+	if subject.Selector == nil || isEmptySelector(subject.Selector) {
+		return &ParseError{Path: "selector", Reason: "matchLabels or matchExpressions required"}
+	}
+	if _, err := metav1.LabelSelectorAsSelector(subject.Selector); err != nil {
+		return &ParseError{Path: "selector", Reason: err.Error()}
+	}
+	if subject.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(subject.NamespaceSelector); err != nil {
+			return &ParseError{Path: "namespaceSelector", Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+// SelectsServiceAccount reports whether a Service Account with saLabels could
+// be a subject of rbacDef through a ServiceAccountSelector. Namespace
+// selectors and imports are not considered.
+func SelectsServiceAccount(rbacDef *rbacmanagerv1beta1.RBACDefinition, saLabels map[string]string) bool {
+	for _, rbacBinding := range rbacDef.RBACBindings {
+		for _, subject := range rbacBinding.Subjects {
+			if subject.Kind != rbacmanagerv1beta1.ServiceAccountSelectorKind || subject.Selector == nil {
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(subject.Selector)
+			if err == nil && selector.Matches(labels.Set(saLabels)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// selectorIndex maps label keys to the RBAC Definitions whose
+// ServiceAccountSelector subjects only match Service Accounts with that key,
+// so that label changes no selector depends on can be ignored without
+// listing every definition
+var selectorIndex = struct {
+	sync.RWMutex
+	byKey map[string]map[string]bool
+	// unkeyed holds the definitions with a selector that matches Service
+	// Accounts without any particular key, such as an empty one
+	unkeyed map[string]bool
+	// keys holds the keys indexed for every definition
+	keys map[string][]string
+}{byKey: map[string]map[string]bool{}, unkeyed: map[string]bool{}, keys: map[string][]string{}}
+
+// requiredKeys returns the label keys every Service Account matching
+// selector has, or false if it may match Service Accounts without any
+func requiredKeys(selector *metav1.LabelSelector) ([]string, bool) {
+	keys := []string{}
+	for key := range selector.MatchLabels {
+		keys = append(keys, key)
+	}
+	for _, requirement := range selector.MatchExpressions {
+		if requirement.Operator == metav1.LabelSelectorOpIn || requirement.Operator == metav1.LabelSelectorOpExists {
+			keys = append(keys, requirement.Key)
+		}
+	}
+	return keys, len(keys) > 0
+}
+
+// IndexServiceAccountSelectors records the label keys the
+// ServiceAccountSelector subjects of rbacDef depend on, replacing those
+// recorded before. It has to be called before rbacDef is reconciled, so that
+// Service Accounts relabeled after the reconcile listed them are noticed.
+func IndexServiceAccountSelectors(rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	selectorIndex.Lock()
+	defer selectorIndex.Unlock()
+
+	forgetServiceAccountSelectors(rbacDef.Name)
+	for _, rbacBinding := range rbacDef.RBACBindings {
+		for _, subject := range rbacBinding.Subjects {
+			if subject.Kind != rbacmanagerv1beta1.ServiceAccountSelectorKind || subject.Selector == nil {
+				continue
+			}
+			keys, ok := requiredKeys(subject.Selector)
+			if !ok {
+				selectorIndex.unkeyed[rbacDef.Name] = true
+				continue
+			}
+			for _, key := range keys {
+				if selectorIndex.byKey[key] == nil {
+					selectorIndex.byKey[key] = map[string]bool{}
+				}
+				selectorIndex.byKey[key][rbacDef.Name] = true
+				selectorIndex.keys[rbacDef.Name] = append(selectorIndex.keys[rbacDef.Name], key)
+			}
+		}
+	}
+}
+
+// ForgetServiceAccountSelectors drops the indexed selectors of a deleted RBAC
+// Definition
+func ForgetServiceAccountSelectors(name string) {
+	selectorIndex.Lock()
+	defer selectorIndex.Unlock()
+	forgetServiceAccountSelectors(name)
+}
+
+func forgetServiceAccountSelectors(name string) {
+	for _, key := range selectorIndex.keys[name] {
+		delete(selectorIndex.byKey[key], name)
+		if len(selectorIndex.byKey[key]) == 0 {
+			delete(selectorIndex.byKey, key)
+		}
+	}
+	delete(selectorIndex.keys, name)
+	delete(selectorIndex.unkeyed, name)
+}
+
+// MaySelectServiceAccount reports whether any indexed RBAC Definition could
+// select a Service Account labeled with any of labelSets. Only if it does do
+// the definitions have to be checked with SelectsServiceAccount.
+func MaySelectServiceAccount(labelSets ...map[string]string) bool {
+	selectorIndex.RLock()
+	defer selectorIndex.RUnlock()
+
+	if len(selectorIndex.unkeyed) > 0 {
+		return true
+	}
+	for _, saLabels := range labelSets {
+		for key := range saLabels {
+			if len(selectorIndex.byKey[key]) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestServiceAccountSelectorIndex(t *testing.T) {
+	selecting := func(name string, selector *metav1.LabelSelector) *rbacmanagerv1beta1.RBACDefinition {
+		rbacDef := &rbacmanagerv1beta1.RBACDefinition{}
+		rbacDef.Name = name
+		rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+			Name:     "tenants",
+			Subjects: []rbacmanagerv1beta1.Subject{{Selector: selector}},
+		}}
+		rbacDef.RBACBindings[0].Subjects[0].Kind = rbacmanagerv1beta1.ServiceAccountSelectorKind
+		return rbacDef
+	}
+	defer ForgetServiceAccountSelectors("tenants")
+	defer ForgetServiceAccountSelectors("others")
+
+	assert.False(t, MaySelectServiceAccount(map[string]string{"tenant": "a"}))
+
+	IndexServiceAccountSelectors(selecting("tenants", &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "a"}}))
+	assert.True(t, MaySelectServiceAccount(map[string]string{"tenant": "b"}))
+	assert.True(t, MaySelectServiceAccount(map[string]string{"app": "web"}, map[string]string{"tenant": "a"}), "the labels before a change count too")
+	assert.False(t, MaySelectServiceAccount(map[string]string{"app": "web"}))
+
+	// Reindexing replaces the keys of a definition
+	IndexServiceAccountSelectors(selecting("tenants", &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "team", Operator: metav1.LabelSelectorOpExists},
+		{Key: "tier", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"prod"}},
+	}}))
+	assert.False(t, MaySelectServiceAccount(map[string]string{"tenant": "a"}))
+	assert.True(t, MaySelectServiceAccount(map[string]string{"team": "payments"}))
+	assert.False(t, MaySelectServiceAccount(map[string]string{"tier": "dev"}), "tier is not required")
+
+	// Selectors that require no key may match any Service Account
+	IndexServiceAccountSelectors(selecting("others", &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "tier", Operator: metav1.LabelSelectorOpDoesNotExist},
+	}}))
+	assert.True(t, MaySelectServiceAccount(map[string]string{}))
+
+	ForgetServiceAccountSelectors("others")
+	ForgetServiceAccountSelectors("tenants")
+	assert.False(t, MaySelectServiceAccount(map[string]string{"team": "payments"}))
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// reservedAnnotationPrefix is the prefix of the annotations RBAC Manager sets
+// itself, which subjects can't request
+const reservedAnnotationPrefix = "rbacmanager.reactiveops.io/"
+
+// managedMetadata holds the keys of the labels and annotations an RBAC
+// Definition set on a Service Account, as kept in the managed-metadata
+// annotation
+type managedMetadata struct {
+	Labels      []string `json:"labels,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+// managedMetadataKeys reads the managed-metadata annotation of a Service
+// Account
+func managedMetadataKeys(objectMeta *metav1.ObjectMeta) managedMetadata {
+	return metadataKeys(objectMeta.Annotations, kube.ManagedMetadataAnnotation)
+}
+
+// metadataKeys reads an annotation listing label and annotation keys. An
+// annotation that can't be read lists no keys.
+func metadataKeys(annotations map[string]string, annotation string) managedMetadata {
+	keys := managedMetadata{}
+	value, ok := annotations[annotation]
+	if !ok {
+		return keys
+	}
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return managedMetadata{}
+	}
+	return keys
+}
+
+// serviceAccountMetadata returns the labels and annotations of a Service
+// Account requested by subject. RBAC Manager's own label always wins, and the
+// keys taken from subject are listed in the managed-metadata annotation.
+func serviceAccountMetadata(subject rbacmanagerv1beta1.Subject) (map[string]string, map[string]string) {
+	if len(subject.Labels) == 0 && len(subject.Annotations) == 0 {
+		return kube.Labels, nil
+	}
+
+	labels := map[string]string{}
+	keys := managedMetadata{}
+	for key, value := range subject.Labels {
+		labels[key] = value
+		if _, ok := kube.Labels[key]; !ok {
+			keys.Labels = append(keys.Labels, key)
+		}
+	}
+	for key, value := range kube.Labels {
+		labels[key] = value
+	}
+
+	annotations := map[string]string{}
+	for key, value := range subject.Annotations {
+		annotations[key] = value
+		keys.Annotations = append(keys.Annotations, key)
+	}
+
+	sort.Strings(keys.Labels)
+	sort.Strings(keys.Annotations)
+	value, _ := json.Marshal(keys)
+	annotations[kube.ManagedMetadataAnnotation] = string(value)
+
+	return labels, annotations
+}
+
+// pickKeys returns the entries of values with the given keys
+func pickKeys(values map[string]string, keys []string) map[string]string {
+	if len(keys) == 0 {
+		return nil
+	}
+	picked := map[string]string{}
+	for _, key := range keys {
+		if value, ok := values[key]; ok {
+			picked[key] = value
+		}
+	}
+	return picked
+}
+
+// serviceAccountPatch returns a strategic merge patch that gives existing the
+// labels, annotations, image pull secrets and token automounting of
+// requested. Labels and annotations the RBAC Definition set before and no
+// longer requests are removed, those set by anything else are left alone.
+func serviceAccountPatch(existing, requested *v1.ServiceAccount) ([]byte, error) {
+	previous := managedMetadataKeys(&existing.ObjectMeta)
+	previousChild := metadataKeys(existing.Annotations, kube.ChildMetadataAnnotation)
+
+	labels := map[string]interface{}{}
+	for _, key := range append(previous.Labels, previousChild.Labels...) {
+		labels[key] = nil
+	}
+	for key, value := range requested.Labels {
+		labels[key] = value
+	}
+
+	annotations := map[string]interface{}{}
+	for _, key := range append(previous.Annotations, previousChild.Annotations...) {
+		annotations[key] = nil
+	}
+	for _, key := range []string{kube.ManagedMetadataAnnotation, kube.ChildMetadataAnnotation} {
+		if _, ok := existing.Annotations[key]; ok {
+			annotations[key] = nil
+		}
+	}
+	for key, value := range requested.Annotations {
+		annotations[key] = value
+	}
+
+	var imagePullSecrets interface{}
+	if len(requested.ImagePullSecrets) > 0 {
+		imagePullSecrets = requested.ImagePullSecrets
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labels,
+			"annotations": annotations,
+		},
+		"imagePullSecrets":             imagePullSecrets,
+		"automountServiceAccountToken": requested.AutomountServiceAccountToken,
+	})
+}
+
+// validateServiceAccountMetadata rejects Service Account metadata on subjects
+// that don't create a Service Account
+func validateServiceAccountMetadata(subject *rbacmanagerv1beta1.Subject) error {
+	if subject.Kind == rbacv1.ServiceAccountKind {
+		for key := range subject.Annotations {
+			if strings.HasPrefix(key, reservedAnnotationPrefix) {
+				return &ParseError{Path: "annotations", Reason: fmt.Sprintf("%s is reserved for RBAC Manager", key)}
+			}
+		}
+		return nil
+	}
+	if len(subject.Annotations) > 0 || len(subject.Labels) > 0 || subject.AutomountServiceAccountToken != nil {
+		return errors.New("annotations, labels and automountServiceAccountToken are only supported for ServiceAccount subjects")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+func TestReconcileUpdatesServiceAccountMetadata(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "irsa"
+	rbacDef.UID = "irsa-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "uploaders",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      "uploader",
+				Namespace: "media",
+			},
+			Annotations: map[string]string{
+				"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/uploader",
+				"example.com/owner":          "media-team",
+			},
+			Labels: map[string]string{"team": "media"},
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	sa, err := client.CoreV1().ServiceAccounts("media").Get(context.TODO(), "uploader", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::111122223333:role/uploader", sa.Annotations["eks.amazonaws.com/role-arn"])
+	assert.Equal(t, "media", sa.Labels["team"])
+	assert.Equal(t, kube.LabelValue, sa.Labels[kube.LabelKey])
+
+	// Another tool annotates the Service Account
+	sa.Annotations["example.com/scanned"] = "true"
+	_, err = client.CoreV1().ServiceAccounts("media").Update(context.TODO(), sa, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	// Changing and removing annotations updates the Service Account in place
+	rbacDef.RBACBindings[0].Subjects[0].Annotations = map[string]string{
+		"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/uploader-v2",
+	}
+	automount := false
+	rbacDef.RBACBindings[0].Subjects[0].AutomountServiceAccountToken = &automount
+	client.ClearActions()
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "serviceaccounts" {
+			assert.NotContains(t, []string{"create", "delete"}, action.GetVerb(), "the Service Account should not be created again")
+		}
+	}
+
+	sa, err = client.CoreV1().ServiceAccounts("media").Get(context.TODO(), "uploader", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::111122223333:role/uploader-v2", sa.Annotations["eks.amazonaws.com/role-arn"])
+	assert.NotContains(t, sa.Annotations, "example.com/owner", "annotations the definition no longer sets should be removed")
+	assert.Equal(t, "true", sa.Annotations["example.com/scanned"], "annotations of other tools should be kept")
+	assert.Equal(t, "media", sa.Labels["team"])
+	assert.Equal(t, &automount, sa.AutomountServiceAccountToken)
+	assert.True(t, ServiceAccountApplied(sa))
+
+	// Nothing changes once the Service Account is up to date
+	client.ClearActions()
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "serviceaccounts" {
+			assert.Equal(t, "list", action.GetVerb())
+		}
+	}
+}
+
+func TestValidateServiceAccountMetadata(t *testing.T) {
+	user := rbacmanagerv1beta1.Subject{
+		Subject:     rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		Annotations: map[string]string{"example.com/owner": "joe"},
+	}
+	assert.EqualError(t, validateServiceAccountMetadata(&user), "annotations, labels and automountServiceAccountToken are only supported for ServiceAccount subjects")
+
+	reserved := rbacmanagerv1beta1.Subject{
+		Subject:     rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "bot", Namespace: "ci"},
+		Annotations: map[string]string{kube.SpecHashAnnotation: "v2:abc"},
+	}
+	assert.Error(t, validateServiceAccountMetadata(&reserved))
+
+	sa := rbacmanagerv1beta1.Subject{
+		Subject:     rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "bot", Namespace: "ci"},
+		Annotations: map[string]string{"example.com/owner": "ci"},
+		Labels:      map[string]string{"team": "ci"},
+	}
+	assert.NoError(t, validateServiceAccountMetadata(&sa))
+}
+
+func TestValidateCreateServiceAccount(t *testing.T) {
+	create := false
+	user := rbacmanagerv1beta1.Subject{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}, CreateServiceAccount: &create}
+	assert.EqualError(t, validateCreateServiceAccount(&user), "createServiceAccount is only supported for ServiceAccount subjects")
+
+	bound := rbacmanagerv1beta1.Subject{
+		Subject:              rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "bot", Namespace: "ci"},
+		CreateServiceAccount: &create,
+	}
+	assert.NoError(t, validateCreateServiceAccount(&bound))
+	bound.ImagePullSecrets = []string{"registry"}
+	assert.EqualError(t, validateCreateServiceAccount(&bound), "imagePullSecrets, annotations, labels and automountServiceAccountToken require createServiceAccount")
+}
@@ -0,0 +1,164 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// serviceAccountsGroupPrefix is the prefix of the Group every Service Account
+// of a namespace belongs to
+const serviceAccountsGroupPrefix = "system:serviceaccounts:"
+
+// serviceAccountsGroup replaces a ServiceAccountsInNamespace subject with the
+// Group of all Service Accounts in its namespace
+func serviceAccountsGroup(subject rbacmanagerv1beta1.Subject) rbacmanagerv1beta1.Subject {
+	subject.Subject = rbacv1.Subject{
+		Kind:     rbacv1.GroupKind,
+		APIGroup: rbacv1.GroupName,
+		Name:     serviceAccountsGroupPrefix + subject.Namespace,
+	}
+	return subject
+}
+
+func validateServiceAccountsInNamespace(subject *rbacmanagerv1beta1.Subject) error {
+	if subject.Namespace == "" {
+		return errors.New("namespace required for ServiceAccountsInNamespace subjects")
+	}
+	if subject.Name != "" {
+		return errors.New("ServiceAccountsInNamespace subjects take a namespace and no name")
+	}
+	if len(subject.ImagePullSecrets) > 0 {
+		return errors.New("imagePullSecrets are only supported for ServiceAccount subjects")
+	}
+	return nil
+}
+
+// createsServiceAccount reports whether the Service Account of a
+// ServiceAccount subject is created, rather than only bound
+func createsServiceAccount(subject *rbacmanagerv1beta1.Subject) bool {
+	return subject.CreateServiceAccount == nil || *subject.CreateServiceAccount
+}
+
+func validateCreateServiceAccount(subject *rbacmanagerv1beta1.Subject) error {
+	if subject.CreateServiceAccount == nil {
+		return nil
+	}
+	if subject.Kind != rbacv1.ServiceAccountKind {
+		return errors.New("createServiceAccount is only supported for ServiceAccount subjects")
+	}
+	// Whatever creates the Service Account decides what it looks like
+	if !*subject.CreateServiceAccount && (len(subject.ImagePullSecrets) > 0 || len(subject.Annotations) > 0 ||
+		len(subject.Labels) > 0 || subject.AutomountServiceAccountToken != nil) {
+		return errors.New("imagePullSecrets, annotations, labels and automountServiceAccountToken require createServiceAccount")
+	}
+	return nil
+}
+
+// unknownNamespaceGroup is a system:serviceaccounts: Group, written by hand or
+// generated for a ServiceAccountsInNamespace subject, that names a namespace
+// which doesn't exist
+type unknownNamespaceGroup struct {
+	rbacBinding string
+	group       string
+	generated   bool
+}
+
+// checkServiceAccountGroups records Groups of the Service Accounts of a
+// namespace that doesn't exist, which is most often a typo
+func (p *Parser) checkServiceAccountGroups(rbacBinding *rbacmanagerv1beta1.RBACBinding, namespaces *v1.NamespaceList) {
+	for _, subject := range rbacBinding.Subjects {
+		generated := subject.Kind == rbacmanagerv1beta1.ServiceAccountsInNamespaceKind
+		if generated {
+			subject = serviceAccountsGroup(subject)
+		}
+		if subject.Kind != rbacv1.GroupKind || isTemplate(subject.Name) || !strings.HasPrefix(subject.Name, serviceAccountsGroupPrefix) {
+			continue
+		}
+		if !namespaceExists(namespaces, strings.TrimPrefix(subject.Name, serviceAccountsGroupPrefix)) {
+			p.unknownNamespaceGroups = append(p.unknownNamespaceGroups, unknownNamespaceGroup{rbacBinding: rbacBinding.Name, group: subject.Name, generated: generated})
+		}
+	}
+}
+
+// StrictClusterServiceAccounts fails the reconcile of RBAC Definitions that
+// bind ServiceAccount subjects through clusterRoleBindings entries without
+// limitToNamespaceSelector, instead of only warning about them
+var StrictClusterServiceAccounts bool
+
+// clusterServiceAccount is a Service Account bound to a ClusterRole in every
+// namespace
+type clusterServiceAccount struct {
+	rbacBinding    string
+	serviceAccount string
+	clusterRole    string
+}
+
+// checkClusterServiceAccounts records the ServiceAccount subjects a Cluster
+// Role Binding to clusterRole would bind, which are rarely meant to hold a
+// role in every namespace. If StrictClusterServiceAccounts is set it returns
+// an error instead.
+func (p *Parser) checkClusterServiceAccounts(rbacBindingName string, clusterRole string, subjects []rbacmanagerv1beta1.Subject) error {
+	for _, subject := range subjects {
+		if subject.Kind != rbacv1.ServiceAccountKind {
+			continue
+		}
+		serviceAccount := subject.Namespace + "/" + subject.Name
+		if StrictClusterServiceAccounts {
+			return fmt.Errorf("ServiceAccount %v may not be bound to ClusterRole %v in every namespace, use limitToNamespaceSelector or roleBindings", serviceAccount, clusterRole)
+		}
+		p.clusterServiceAccounts = append(p.clusterServiceAccounts, clusterServiceAccount{rbacBinding: rbacBindingName, serviceAccount: serviceAccount, clusterRole: clusterRole})
+	}
+	return nil
+}
+
+func namespaceExists(namespaces *v1.NamespaceList, name string) bool {
+	for _, namespace := range namespaces.Items {
+		if namespace.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// reportUnknownNamespaceGroups warns about every Group found by
+// checkServiceAccountGroups in the RBAC Definition being reconciled
+func (r *Reconciler) reportUnknownNamespaceGroups(groups []unknownNamespaceGroup) {
+	for _, g := range groups {
+		logrus.Warnf("Group %v in rbacBindings entry %v of RBACDefinition %v refers to a namespace that doesn't exist", g.group, g.rbacBinding, r.rbacDef.Name)
+		if g.generated {
+			r.event(v1.EventTypeWarning, "UnknownNamespace", "ServiceAccountsInNamespace subject in rbacBindings entry %v refers to namespace %v which doesn't exist", g.rbacBinding, strings.TrimPrefix(g.group, serviceAccountsGroupPrefix))
+			continue
+		}
+		r.event(v1.EventTypeWarning, "UnknownNamespace", "Group %v in rbacBindings entry %v refers to a namespace that doesn't exist, use a ServiceAccountsInNamespace subject to bind the Service Accounts of a namespace", g.group, g.rbacBinding)
+	}
+}
+
+// reportClusterServiceAccounts warns about every Service Account found by
+// checkClusterServiceAccounts in the RBAC Definition being reconciled
+func (r *Reconciler) reportClusterServiceAccounts(serviceAccounts []clusterServiceAccount) {
+	for _, sa := range serviceAccounts {
+		logrus.Warnf("ServiceAccount %v in rbacBindings entry %v of RBACDefinition %v is bound to ClusterRole %v in every namespace", sa.serviceAccount, sa.rbacBinding, r.rbacDef.Name, sa.clusterRole)
+		r.event(v1.EventTypeWarning, "ClusterServiceAccount", "ServiceAccount %v in rbacBindings entry %v is bound to ClusterRole %v in every namespace, use limitToNamespaceSelector or roleBindings to bind it in fewer namespaces", sa.serviceAccount, sa.rbacBinding, sa.clusterRole)
+	}
+}
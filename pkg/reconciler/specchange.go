@@ -0,0 +1,344 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// specResource is a requested resource as far as spec change summaries are
+// concerned. Resources read from a fingerprint only have a hash.
+type specResource struct {
+	kind      string
+	namespace string
+	name      string
+	hash      string
+	// roleRef and subjects are only set for bindings that were parsed
+	roleRef  string
+	subjects []string
+	detailed bool
+}
+
+func (s specResource) key() string {
+	return s.kind + "/" + s.namespace + "/" + s.name
+}
+
+// describe names the resource the way summaries refer to it
+func (s specResource) describe() string {
+	if s.namespace == "" {
+		return s.kind + " " + s.name
+	}
+	return s.kind + " " + s.namespace + "/" + s.name
+}
+
+// specSnapshot holds the requested resources of one parse by key
+type specSnapshot map[string]specResource
+
+// shortHash hashes the spec of a resource for fingerprints, which only need
+// to tell whether it changed
+func shortHash(spec interface{}) string {
+	data, _ := json.Marshal(spec)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+func describeSubject(subject rbacv1.Subject) string {
+	switch subject.Kind {
+	case rbacv1.UserKind:
+		return subject.Name
+	case rbacv1.ServiceAccountKind:
+		return "serviceaccount " + subject.Namespace + "/" + subject.Name
+	default:
+		return strings.ToLower(subject.Kind) + " " + subject.Name
+	}
+}
+
+func bindingResource(kind string, objectMeta *metav1.ObjectMeta, roleRef rbacv1.RoleRef, subjects []rbacv1.Subject) specResource {
+	described := []string{}
+	for _, subject := range sortedSubjects(subjects) {
+		described = append(described, describeSubject(subject))
+	}
+	return specResource{
+		kind:      kind,
+		namespace: objectMeta.Namespace,
+		name:      objectMeta.Name,
+		hash:      shortHash(bindingSpec(roleRef, subjects)),
+		roleRef:   roleRef.Kind + " " + roleRef.Name,
+		subjects:  described,
+		detailed:  true,
+	}
+}
+
+// snapshotParsed records the resources p requested
+func snapshotParsed(p *Parser) specSnapshot {
+	snapshot := specSnapshot{}
+	add := func(resource specResource) {
+		snapshot[resource.key()] = resource
+	}
+
+	for _, ns := range p.parsedNamespaces {
+		add(specResource{kind: "Namespace", name: ns.Name, hash: shortHash(ns.Labels)})
+	}
+	for _, sa := range p.parsedServiceAccounts {
+		add(specResource{kind: "ServiceAccount", namespace: sa.Namespace, name: sa.Name, hash: shortHash(serviceAccountSpec(&sa))})
+	}
+	for _, role := range p.parsedRoles {
+		add(specResource{kind: "Role", namespace: role.Namespace, name: role.Name, hash: shortHash(roleSpec(role.Rules))})
+	}
+	for i := range p.parsedClusterRoleBindings {
+		crb := &p.parsedClusterRoleBindings[i]
+		add(bindingResource("ClusterRoleBinding", &crb.ObjectMeta, crb.RoleRef, crb.Subjects))
+	}
+	for i := range p.parsedRoleBindings {
+		rb := &p.parsedRoleBindings[i]
+		add(bindingResource("RoleBinding", &rb.ObjectMeta, rb.RoleRef, rb.Subjects))
+	}
+	return snapshot
+}
+
+// fingerprint records snapshot in the compact form kept in the status. The
+// resources are only listed if there are at most limit of them, or limit is
+// negative.
+func (s specSnapshot) fingerprint(generation int64, limit int) *rbacmanagerv1beta1.Fingerprint {
+	fingerprint := &rbacmanagerv1beta1.Fingerprint{Generation: generation, Count: len(s)}
+	if limit >= 0 && len(s) > limit {
+		return fingerprint
+	}
+	for key, resource := range s {
+		fingerprint.Resources = append(fingerprint.Resources, key+"="+resource.hash)
+	}
+	sort.Strings(fingerprint.Resources)
+	return fingerprint
+}
+
+// snapshotFromFingerprint reads a fingerprint back. It returns false if the
+// fingerprint doesn't list every resource.
+func snapshotFromFingerprint(fingerprint *rbacmanagerv1beta1.Fingerprint) (specSnapshot, bool) {
+	if len(fingerprint.Resources) != fingerprint.Count {
+		return nil, false
+	}
+
+	snapshot := specSnapshot{}
+	for _, entry := range fingerprint.Resources {
+		separator := strings.LastIndex(entry, "=")
+		if separator < 0 {
+			return nil, false
+		}
+		parts := strings.SplitN(entry[:separator], "/", 3)
+		if len(parts) != 3 {
+			return nil, false
+		}
+		resource := specResource{kind: parts[0], namespace: parts[1], name: parts[2], hash: entry[separator+1:]}
+		snapshot[resource.key()] = resource
+	}
+	return snapshot, true
+}
+
+// generationSnapshot is the last parse of a generation of an RBAC Definition
+type generationSnapshot struct {
+	generation int64
+	resources  specSnapshot
+}
+
+// definitionSnapshots holds the last parse of the current and the previous
+// generation of an RBAC Definition, so that the previous one is still known
+// whichever reconcile parses a new generation first
+type definitionSnapshots struct {
+	uid      types.UID
+	previous *generationSnapshot
+	current  *generationSnapshot
+}
+
+// specSnapshots holds the recent parses of every RBAC Definition by name.
+// They are lost on restart, when fingerprints are used instead.
+var specSnapshots = struct {
+	sync.Mutex
+	byName map[string]*definitionSnapshots
+}{byName: map[string]*definitionSnapshots{}}
+
+// rememberSnapshot records the resources parsed for rbacDef and returns the
+// last parse of an earlier generation, if there was one since RBAC Manager
+// started
+func rememberSnapshot(rbacDef *rbacmanagerv1beta1.RBACDefinition, resources specSnapshot) *generationSnapshot {
+	specSnapshots.Lock()
+	defer specSnapshots.Unlock()
+
+	snapshots := specSnapshots.byName[rbacDef.Name]
+	if snapshots == nil || snapshots.uid != rbacDef.UID || snapshots.current.generation > rbacDef.Generation {
+		snapshots = &definitionSnapshots{uid: rbacDef.UID}
+		specSnapshots.byName[rbacDef.Name] = snapshots
+	}
+
+	if snapshots.current != nil && snapshots.current.generation < rbacDef.Generation {
+		snapshots.previous = snapshots.current
+	}
+	snapshots.current = &generationSnapshot{generation: rbacDef.Generation, resources: resources}
+	return snapshots.previous
+}
+
+// ForgetSpecSnapshots drops the recent parses of a deleted RBAC Definition
+func ForgetSpecSnapshots(name string) {
+	specSnapshots.Lock()
+	defer specSnapshots.Unlock()
+	delete(specSnapshots.byName, name)
+}
+
+// recordSpecChange summarizes how the resources of rbacDef changed since its
+// previous generation in status.lastSpecChange and an event, once for every
+// generation, and updates the fingerprint in its status
+func (r *Reconciler) recordSpecChange(rbacDef *rbacmanagerv1beta1.RBACDefinition, p *Parser) {
+	current := snapshotParsed(p)
+	previous := rememberSnapshot(rbacDef, current)
+
+	summarized := int64(0)
+	if rbacDef.Status.LastSpecChange != nil {
+		summarized = rbacDef.Status.LastSpecChange.Generation
+	}
+
+	var base specSnapshot
+	if previous != nil {
+		base = previous.resources
+	} else if fingerprint := rbacDef.Status.Fingerprint; fingerprint != nil && fingerprint.Generation < rbacDef.Generation {
+		base, _ = snapshotFromFingerprint(fingerprint)
+	}
+	// The status only lists the resources of small definitions
+	if state, ok := storedState(rbacDef); base == nil && ok && state.Fingerprint != nil && state.Fingerprint.Generation < rbacDef.Generation {
+		base, _ = snapshotFromFingerprint(state.Fingerprint)
+	}
+
+	if base != nil && summarized < rbacDef.Generation {
+		summary := truncateMessage(summarizeSpecChange(base, current), maxReadyMessageLength)
+		logrus.Infof("RBACDefinition %v generation %v: %v", rbacDef.Name, rbacDef.Generation, summary)
+		r.event(v1.EventTypeNormal, "SpecChanged", "generation %v: %v", rbacDef.Generation, summary)
+		rbacDef.Status.LastSpecChange = &rbacmanagerv1beta1.SpecChange{Generation: rbacDef.Generation, Summary: summary}
+	}
+
+	rbacDef.Status.Fingerprint = current.fingerprint(rbacDef.Generation, rbacmanagerv1beta1.MaxFingerprintEntries)
+}
+
+// summarizeSpecChange describes the difference between two snapshots, such
+// as "+2 RoleBindings in ns team-a, -1 subject alice from ClusterRoleBinding
+// admin-binding". Changed resources are only described in detail if both
+// snapshots were parsed.
+func summarizeSpecChange(previous, current specSnapshot) string {
+	type group struct {
+		sign      string
+		kind      string
+		namespace string
+	}
+	counts := map[group]int{}
+	details := []string{}
+
+	for key, resource := range current {
+		old, ok := previous[key]
+		if !ok {
+			counts[group{"+", resource.kind, resource.namespace}]++
+		} else if old.hash != resource.hash {
+			if old.detailed && resource.detailed {
+				details = append(details, describeBindingChange(old, resource)...)
+			} else {
+				counts[group{"~", resource.kind, resource.namespace}]++
+			}
+		}
+	}
+	for key, resource := range previous {
+		if _, ok := current[key]; !ok {
+			counts[group{"-", resource.kind, resource.namespace}]++
+		}
+	}
+
+	groups := []group{}
+	for g := range counts {
+		groups = append(groups, g)
+	}
+	order := map[string]int{"+": 0, "-": 1, "~": 2}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].sign != groups[j].sign {
+			return order[groups[i].sign] < order[groups[j].sign]
+		}
+		if groups[i].kind != groups[j].kind {
+			return groups[i].kind < groups[j].kind
+		}
+		return groups[i].namespace < groups[j].namespace
+	})
+
+	parts := []string{}
+	for _, g := range groups {
+		part := fmt.Sprintf("%v%d %v", g.sign, counts[g], g.kind)
+		if counts[g] > 1 {
+			part += "s"
+		}
+		if g.namespace != "" {
+			part += " in ns " + g.namespace
+		}
+		parts = append(parts, part)
+	}
+	sort.Strings(details)
+	parts = append(parts, details...)
+
+	if len(parts) == 0 {
+		return "no resources changed"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// describeBindingChange describes how the role or subjects of a binding changed
+func describeBindingChange(old, current specResource) []string {
+	changes := []string{}
+	if old.roleRef != current.roleRef {
+		changes = append(changes, fmt.Sprintf("%v binds %v instead of %v", current.describe(), current.roleRef, old.roleRef))
+	}
+
+	added := subjectsNotIn(current.subjects, old.subjects)
+	if len(added) > 0 {
+		changes = append(changes, fmt.Sprintf("+%d %v %v to %v", len(added), pluralSubjects(len(added)), strings.Join(added, ", "), current.describe()))
+	}
+	removed := subjectsNotIn(old.subjects, current.subjects)
+	if len(removed) > 0 {
+		changes = append(changes, fmt.Sprintf("-%d %v %v from %v", len(removed), pluralSubjects(len(removed)), strings.Join(removed, ", "), current.describe()))
+	}
+	return changes
+}
+
+func subjectsNotIn(subjects, others []string) []string {
+	missing := []string{}
+	for _, subject := range subjects {
+		if !stringInSlice(subject, others) {
+			missing = append(missing, subject)
+		}
+	}
+	return missing
+}
+
+func pluralSubjects(count int) string {
+	if count == 1 {
+		return "subject"
+	}
+	return "subjects"
+}
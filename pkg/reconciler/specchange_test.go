@@ -0,0 +1,80 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestRecordSpecChange(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	)
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "spec-change"
+	rbacDef.UID = "spec-change-uid"
+	rbacDef.Generation = 1
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "admins",
+		Subjects: []rbacmanagerv1beta1.Subject{
+			{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "alice"}},
+			{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "bob"}},
+		},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "admin"}},
+	}}
+	defer ForgetSpecSnapshots(rbacDef.Name)
+
+	recorder := record.NewFakeRecorder(10)
+	r := Reconciler{Clientset: client, Recorder: recorder}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Nil(t, rbacDef.Status.LastSpecChange, "the first generation has nothing to compare with")
+	assert.Equal(t, &rbacmanagerv1beta1.Fingerprint{
+		Generation: 1,
+		Count:      1,
+		Resources:  []string{"ClusterRoleBinding//spec-change-admins-admin=" + shortHash(bindingSpec(rbacv1.RoleRef{Kind: "ClusterRole", Name: "admin"}, managerSubjectsToRbacSubjects(rbacDef.RBACBindings[0].Subjects)))},
+	}, rbacDef.Status.Fingerprint)
+	assert.Empty(t, recorder.Events)
+
+	rbacDef.Generation = 2
+	rbacDef.RBACBindings[0].Subjects = rbacDef.RBACBindings[0].Subjects[1:]
+	rbacDef.RBACBindings[0].RoleBindings = []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespaces: []string{"team-a"}}, {ClusterRole: "view", Namespaces: []string{"team-a"}}}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	summary := "+2 RoleBindings in ns team-a, -1 subject alice from ClusterRoleBinding spec-change-admins-admin"
+	assert.Equal(t, &rbacmanagerv1beta1.SpecChange{Generation: 2, Summary: summary}, rbacDef.Status.LastSpecChange)
+	assert.Equal(t, "Normal SpecChanged generation 2: "+summary, <-recorder.Events)
+
+	// A generation is only summarized once
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Empty(t, recorder.Events)
+
+	// After a restart the fingerprint in the status is compared with instead
+	ForgetSpecSnapshots(rbacDef.Name)
+	rbacDef.Generation = 3
+	rbacDef.RBACBindings[0].Subjects = append(rbacDef.RBACBindings[0].Subjects, rbacmanagerv1beta1.Subject{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "sre"}})
+	rbacDef.RBACBindings[0].RoleBindings[0].Namespaces = []string{"team-b"}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, "+1 RoleBinding in ns team-b, -1 RoleBinding in ns team-a, ~1 ClusterRoleBinding, ~1 RoleBinding in ns team-a", rbacDef.Status.LastSpecChange.Summary)
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// deleteOptions only lets a delete through if the object is still the one
+// that was listed, so that an object replaced in the meantime is never
+// deleted without being evaluated first
+func deleteOptions(existing *metav1.ObjectMeta) metav1.DeleteOptions {
+	preconditions := metav1.Preconditions{}
+	if existing.UID != "" {
+		uid := existing.UID
+		preconditions.UID = &uid
+	}
+	if existing.ResourceVersion != "" {
+		resourceVersion := existing.ResourceVersion
+		preconditions.ResourceVersion = &resourceVersion
+	}
+	return metav1.DeleteOptions{Preconditions: &preconditions}
+}
+
+// skipStaleDelete records a delete that failed its preconditions. The object
+// is left alone and the RBAC Definition is requeued by staleError so that the
+// next reconcile evaluates the current object.
+func (r *Reconciler) skipStaleDelete(kind string, objectMeta *metav1.ObjectMeta) {
+	logrus.Infof("%v %v changed after it was listed, leaving it for the next reconcile", kind, objectMeta.Name)
+
+	r.staleMux.Lock()
+	defer r.staleMux.Unlock()
+	if r.stale == nil {
+		r.stale = map[string]bool{}
+	}
+	r.stale[objectKey(kind, objectMeta)] = true
+}
+
+// skippedDelete reports whether a delete of the object was skipped during
+// this reconcile, in which case it must not be recreated either
+func (r *Reconciler) skippedDelete(kind string, objectMeta *metav1.ObjectMeta) bool {
+	r.staleMux.Lock()
+	defer r.staleMux.Unlock()
+	return r.stale[objectKey(kind, objectMeta)]
+}
+
+// staleError returns an error if any delete was skipped so that the RBAC
+// Definition is reconciled again
+func (r *Reconciler) staleError() error {
+	r.staleMux.Lock()
+	defer r.staleMux.Unlock()
+
+	if len(r.stale) == 0 {
+		return nil
+	}
+
+	keys := []string{}
+	for key := range r.stale {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return fmt.Errorf("resources changed before they could be deleted, requeueing: %v", strings.Join(keys, ", "))
+}
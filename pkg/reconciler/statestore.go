@@ -0,0 +1,215 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// DefinitionState is what RBAC Manager remembers about an RBAC Definition
+// across restarts
+type DefinitionState struct {
+	// UID tells apart a definition that was deleted and created again
+	UID types.UID `json:"uid"`
+	// Fingerprint records the resources of the last reconcile, with a higher
+	// limit on entries than the one in the status
+	Fingerprint *rbacmanagerv1beta1.Fingerprint `json:"fingerprint,omitempty"`
+}
+
+// maxStoredFingerprintEntries is the number of resources an RBAC Definition
+// can have for them to be listed in its stored fingerprint. Entries take
+// around a hundred bytes, and the state of every definition has to fit in
+// the 1MiB a ConfigMap can hold.
+var maxStoredFingerprintEntries = 2000
+
+// StateStore keeps the state of RBAC Definitions. It only has to be
+// consistent with itself, since state that is missing or can't be read is
+// computed again.
+type StateStore interface {
+	// Load returns the state of every RBAC Definition by name, leaving out
+	// state that can't be read
+	Load() (map[string]DefinitionState, error)
+	// Save replaces the state of the RBAC Definition called name
+	Save(name string, state DefinitionState) error
+	// Forget drops the state of the RBAC Definition called name
+	Forget(name string) error
+}
+
+// definitionStates holds the state of every RBAC Definition as last saved
+// to store, which is nil unless a store is in use
+var definitionStates = struct {
+	sync.Mutex
+	store  StateStore
+	byName map[string]DefinitionState
+}{byName: map[string]DefinitionState{}}
+
+// UseStateStore loads the state kept by store and saves the state of every
+// RBAC Definition to it after it is reconciled. If loading fails, RBAC
+// Manager starts without state.
+func UseStateStore(store StateStore) {
+	states, err := store.Load()
+	if err != nil {
+		logrus.Warnf("Error loading the state of RBAC Definitions, starting without it: %v", err)
+		states = map[string]DefinitionState{}
+	}
+
+	definitionStates.Lock()
+	defer definitionStates.Unlock()
+	definitionStates.store = store
+	definitionStates.byName = states
+}
+
+// storedState returns the state of rbacDef, if it is known
+func storedState(rbacDef *rbacmanagerv1beta1.RBACDefinition) (DefinitionState, bool) {
+	definitionStates.Lock()
+	defer definitionStates.Unlock()
+
+	state, ok := definitionStates.byName[rbacDef.Name]
+	if !ok || state.UID != rbacDef.UID {
+		return DefinitionState{}, false
+	}
+	return state, true
+}
+
+// saveState saves the state of rbacDef after a reconcile if it changed.
+// Failing to save it is only logged.
+func saveState(rbacDef *rbacmanagerv1beta1.RBACDefinition, p *Parser) {
+	definitionStates.Lock()
+	defer definitionStates.Unlock()
+	if definitionStates.store == nil {
+		return
+	}
+
+	state := DefinitionState{
+		UID:         rbacDef.UID,
+		Fingerprint: snapshotParsed(p).fingerprint(rbacDef.Generation, maxStoredFingerprintEntries),
+	}
+
+	if previous, ok := definitionStates.byName[rbacDef.Name]; ok && reflect.DeepEqual(previous, state) {
+		return
+	}
+	err := definitionStates.store.Save(rbacDef.Name, state)
+	if err != nil {
+		logrus.Warnf("Error saving the state of RBACDefinition %v: %v", rbacDef.Name, err)
+		metrics.ErrorCounter.Inc()
+		return
+	}
+	definitionStates.byName[rbacDef.Name] = state
+}
+
+// ForgetState drops the state of a deleted RBAC Definition
+func ForgetState(name string) {
+	definitionStates.Lock()
+	defer definitionStates.Unlock()
+	if _, ok := definitionStates.byName[name]; !ok || definitionStates.store == nil {
+		return
+	}
+
+	err := definitionStates.store.Forget(name)
+	if err != nil {
+		logrus.Warnf("Error dropping the state of RBACDefinition %v: %v", name, err)
+		metrics.ErrorCounter.Inc()
+	}
+	delete(definitionStates.byName, name)
+}
+
+// ConfigMapStateStore keeps the state of every RBAC Definition as JSON in
+// one key of a ConfigMap, which is created when state is first saved
+type ConfigMapStateStore struct {
+	Clientset kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+// Load implements StateStore
+func (s *ConfigMapStateStore) Load() (map[string]DefinitionState, error) {
+	states := map[string]DefinitionState{}
+	configMap, err := s.Clientset.CoreV1().ConfigMaps(s.Namespace).Get(context.TODO(), s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return states, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for name, data := range configMap.Data {
+		state := DefinitionState{}
+		err = json.Unmarshal([]byte(data), &state)
+		if err != nil {
+			logrus.Warnf("Ignoring the state of RBACDefinition %v in ConfigMap %v/%v: %v", name, s.Namespace, s.Name, err)
+			continue
+		}
+		states[name] = state
+	}
+	return states, nil
+}
+
+// Save implements StateStore
+func (s *ConfigMapStateStore) Save(name string, state DefinitionState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.update(func(configMap *v1.ConfigMap) {
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[name] = string(data)
+	})
+}
+
+// Forget implements StateStore
+func (s *ConfigMapStateStore) Forget(name string) error {
+	return s.update(func(configMap *v1.ConfigMap) {
+		delete(configMap.Data, name)
+	})
+}
+
+// update applies change to the ConfigMap, creating it if it doesn't exist
+func (s *ConfigMapStateStore) update(change func(configMap *v1.ConfigMap)) error {
+	configMaps := s.Clientset.CoreV1().ConfigMaps(s.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(context.TODO(), s.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			configMap = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: s.Name, Namespace: s.Namespace, Labels: kube.Labels}}
+			change(configMap)
+			_, err = configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(v1.Resource("configmaps"), s.Name, err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		change(configMap)
+		_, err = configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestConfigMapStateStore(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := &ConfigMapStateStore{Clientset: client, Namespace: "rbac-manager", Name: "rbac-manager-state"}
+
+	// A missing ConfigMap is no state
+	states, err := store.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, states)
+
+	state := DefinitionState{UID: "uid", Fingerprint: &rbacmanagerv1beta1.Fingerprint{Generation: 1, Count: 1, Resources: []string{"ClusterRoleBinding//devs-admin=1a2b3c4d"}}}
+	assert.NoError(t, store.Save("devs", state))
+	assert.NoError(t, store.Save("ops", DefinitionState{UID: "ops-uid"}))
+	assert.NoError(t, store.Forget("ops"))
+
+	// Entries that can't be read are left out
+	configMap, err := client.CoreV1().ConfigMaps("rbac-manager").Get(context.TODO(), "rbac-manager-state", metav1.GetOptions{})
+	assert.NoError(t, err)
+	configMap.Data["corrupt"] = "{"
+	_, err = client.CoreV1().ConfigMaps("rbac-manager").Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	states, err = store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]DefinitionState{"devs": state}, states)
+}
+
+func TestStateSurvivesRestarts(t *testing.T) {
+	defer func() {
+		definitionStates.store = nil
+		definitionStates.byName = map[string]DefinitionState{}
+	}()
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}})
+	UseStateStore(&ConfigMapStateStore{Clientset: client, Namespace: "rbac-manager", Name: "rbac-manager-state"})
+
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "stateful"
+	rbacDef.UID = "stateful-uid"
+	rbacDef.Generation = 1
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "admins",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "alice"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "admin"}},
+	}}
+	defer ForgetSpecSnapshots(rbacDef.Name)
+
+	r := Reconciler{Clientset: client, Recorder: record.NewFakeRecorder(10)}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	// After a restart without a fingerprint in the status, the stored one is
+	// compared with
+	ForgetSpecSnapshots(rbacDef.Name)
+	UseStateStore(&ConfigMapStateStore{Clientset: client, Namespace: "rbac-manager", Name: "rbac-manager-state"})
+	rbacDef.Status.Fingerprint = nil
+	rbacDef.Generation = 2
+	rbacDef.RBACBindings[0].RoleBindings = []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "team-a"}}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	if assert.NotNil(t, rbacDef.Status.LastSpecChange) {
+		assert.Equal(t, "+1 RoleBinding in ns team-a", rbacDef.Status.LastSpecChange.Summary)
+	}
+
+	// A definition created again under the same name starts over
+	ForgetSpecSnapshots(rbacDef.Name)
+	rbacDef.UID = "recreated-uid"
+	rbacDef.Status = rbacmanagerv1beta1.RBACDefinitionStatus{}
+	rbacDef.Generation = 3
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Nil(t, rbacDef.Status.LastSpecChange)
+
+	// Fingerprints of definitions with too many resources to list are
+	// stored without them
+	defer func(limit int) { maxStoredFingerprintEntries = limit }(maxStoredFingerprintEntries)
+	maxStoredFingerprintEntries = 1
+	rbacDef.Generation = 4
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	state, ok := storedState(&rbacDef)
+	if assert.True(t, ok) {
+		assert.Equal(t, &rbacmanagerv1beta1.Fingerprint{Generation: 4, Count: 2}, state.Fingerprint)
+	}
+
+	ForgetState(rbacDef.Name)
+	states, err := (&ConfigMapStateStore{Clientset: client, Namespace: "rbac-manager", Name: "rbac-manager-state"}).Load()
+	assert.NoError(t, err)
+	assert.Empty(t, states)
+}
@@ -0,0 +1,150 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// SubjectPatchLimit is the largest number of subjects added to and removed
+// from an existing Cluster Role Binding one by one with a JSON patch. Bindings
+// with more changed subjects get all of them replaced by one update, as do all
+// bindings if it is 0.
+var SubjectPatchLimit = 10
+
+// subjectUpdate is an existing Cluster Role Binding that only differs from
+// the requested one at index in its subjects
+type subjectUpdate struct {
+	index    int
+	existing *rbacv1.ClusterRoleBinding
+}
+
+// subjectsOnlyChanged reports whether existing, unchanged since RBAC Manager
+// wrote it, would match requested if it had the requested subjects
+func subjectsOnlyChanged(existing, requested *rbacv1.ClusterRoleBinding) bool {
+	existingHash := existing.Annotations[kube.SpecHashAnnotation]
+	if !strings.HasPrefix(existingHash, specHashVersion+"-") || specHash(&existing.ObjectMeta, bindingSpec(existing.RoleRef, existing.Subjects)) != existingHash {
+		return false
+	}
+	return specHash(&existing.ObjectMeta, bindingSpec(existing.RoleRef, requested.Subjects)) == requested.Annotations[kube.SpecHashAnnotation]
+}
+
+// subjectChanges returns the indexes of the subjects of existing that
+// requested doesn't have, highest first, and the subjects requested adds
+func subjectChanges(existing, requested []rbacv1.Subject) ([]int, []rbacv1.Subject) {
+	wanted := map[rbacv1.Subject]int{}
+	for _, subject := range requested {
+		wanted[normalizeSubject(subject)]++
+	}
+
+	removed := []int{}
+	for i := len(existing) - 1; i >= 0; i-- {
+		subject := normalizeSubject(existing[i])
+		if wanted[subject] > 0 {
+			wanted[subject]--
+		} else {
+			removed = append(removed, i)
+		}
+	}
+
+	added := []rbacv1.Subject{}
+	for _, subject := range requested {
+		if wanted[normalizeSubject(subject)] > 0 {
+			wanted[normalizeSubject(subject)]--
+			added = append(added, subject)
+		}
+	}
+	return removed, added
+}
+
+// subjectPatch returns a JSON patch that removes and adds the changed
+// subjects of existing one by one and updates its spec hash, or nil if more
+// than limit subjects changed. The patch fails if existing was changed since
+// it was read.
+func subjectPatch(existing, requested *rbacv1.ClusterRoleBinding, limit int) []byte {
+	removed, added := subjectChanges(existing.Subjects, requested.Subjects)
+	if len(removed)+len(added) > limit || len(existing.Subjects) == 0 {
+		return nil
+	}
+
+	type operation struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value,omitempty"`
+	}
+	patch := []operation{}
+	if existing.ResourceVersion != "" {
+		patch = append(patch, operation{Op: "test", Path: "/metadata/resourceVersion", Value: existing.ResourceVersion})
+	}
+	for _, index := range removed {
+		patch = append(patch, operation{Op: "remove", Path: fmt.Sprintf("/subjects/%d", index)})
+	}
+	for _, subject := range added {
+		patch = append(patch, operation{Op: "add", Path: "/subjects/-", Value: subject})
+	}
+	hashPath := "/metadata/annotations/" + strings.ReplaceAll(kube.SpecHashAnnotation, "/", "~1")
+	patch = append(patch, operation{Op: "add", Path: hashPath, Value: requested.Annotations[kube.SpecHashAnnotation]})
+
+	data, _ := json.Marshal(patch)
+	return data
+}
+
+// updateSubjects gives existing Cluster Role Bindings the subjects of the
+// requested ones, patching small changes and updating the binding for larger
+// ones. It reports which of updates succeeded.
+func (r *Reconciler) updateSubjects(updates []subjectUpdate, requested []rbacv1.ClusterRoleBinding) []bool {
+	updated := make([]bool, len(updates))
+	limit := CurrentOptions().SubjectPatchLimit
+	r.forEach(len(updates), func(i int) {
+		existing := updates[i].existing
+		requestedCRB := &requested[updates[i].index]
+
+		action := "patch"
+		patch := subjectPatch(existing, requestedCRB, limit)
+		if patch == nil {
+			action = "update"
+		}
+		r.changeLog().Infof("Updating subjects of Cluster Role Binding %v", existing.Name)
+		err := r.write("ClusterRoleBinding", action, &requestedCRB.ObjectMeta, func() error {
+			var err error
+			if patch != nil {
+				_, err = kube.RBAC(r.Clientset).ClusterRoleBindings().Patch(r.context(), existing.Name, types.JSONPatchType, patch, kube.PatchOptions)
+				return err
+			}
+			crb := existing.DeepCopy()
+			crb.Subjects = requestedCRB.Subjects
+			crb.Annotations[kube.SpecHashAnnotation] = requestedCRB.Annotations[kube.SpecHashAnnotation]
+			_, err = kube.RBAC(r.Clientset).ClusterRoleBindings().Update(r.context(), crb, kube.UpdateOptions)
+			return err
+		})
+		if err != nil {
+			logrus.Warnf("Error updating subjects of Cluster Role Binding %v, replacing it instead: %v", existing.Name, err)
+			return
+		}
+		updated[i] = true
+		metrics.ChangeCounter.WithLabelValues("clusterrolebindings", action).Inc()
+		r.recordChange("ClusterRoleBinding", action, causeSpecChange)
+	})
+	return updated
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestReconcilePatchesSubjects(t *testing.T) {
+	defer currentOptions.Store((*Options)(nil))
+	options := DefaultOptions()
+	options.SubjectPatchLimit = 2
+	SetOptions(options)
+
+	users := func(names ...string) []rbacmanagerv1beta1.Subject {
+		subjects := []rbacmanagerv1beta1.Subject{}
+		for _, name := range names {
+			subjects = append(subjects, rbacmanagerv1beta1.Subject{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: name}})
+		}
+		return subjects
+	}
+
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "synced"
+	rbacDef.UID = "synced-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "group",
+		Subjects:            users("ann", "bob", "cid", "dee"),
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+
+	crbVerbs := func() []string {
+		verbs := []string{}
+		for _, action := range client.Actions() {
+			if action.GetResource().Resource == "clusterrolebindings" && action.GetVerb() != "list" {
+				verbs = append(verbs, action.GetVerb())
+			}
+		}
+		return verbs
+	}
+	names := func() []string {
+		crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "synced-group-view", metav1.GetOptions{})
+		assert.NoError(t, err)
+		subjects := []string{}
+		for _, subject := range crb.Subjects {
+			subjects = append(subjects, subject.Name)
+		}
+		return subjects
+	}
+
+	// One member leaves and one joins, which is patched
+	patches := testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("clusterrolebindings", "patch"))
+	rbacDef.RBACBindings[0].Subjects = users("ann", "cid", "dee", "eve")
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, []string{"patch"}, crbVerbs())
+	crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "synced-group-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+	for _, action := range client.Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok {
+			assert.JSONEq(t, `[
+				{"op": "remove", "path": "/subjects/1"},
+				{"op": "add", "path": "/subjects/-", "value": {"kind": "User", "apiGroup": "rbac.authorization.k8s.io", "name": "eve"}},
+				{"op": "add", "path": "/metadata/annotations/rbacmanager.reactiveops.io~1spec-hash", "value": "`+crb.Annotations[kube.SpecHashAnnotation]+`"}
+			]`, string(patch.GetPatch()))
+		}
+	}
+	assert.Equal(t, []string{"ann", "cid", "dee", "eve"}, names())
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ChangeCounter.WithLabelValues("clusterrolebindings", "patch"))-patches)
+
+	// The patched binding matches
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Empty(t, crbVerbs())
+
+	// More changes than the limit update the binding as a whole
+	rbacDef.RBACBindings[0].Subjects = users("fay", "gus")
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, []string{"update"}, crbVerbs())
+	assert.Equal(t, []string{"fay", "gus"}, names())
+
+	// A binding modified by someone else is replaced
+	crb, err = client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "synced-group-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+	crb.Subjects = crb.Subjects[:1]
+	_, err = client.RbacV1().ClusterRoleBindings().Update(context.TODO(), crb, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	client.ClearActions()
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, []string{"delete", "create"}, crbVerbs())
+	assert.Equal(t, []string{"fay", "gus"}, names())
+}
+
+func TestSubjectChanges(t *testing.T) {
+	user := func(name string) rbacv1.Subject {
+		return rbacv1.Subject{Kind: rbacv1.UserKind, Name: name}
+	}
+	existing := []rbacv1.Subject{user("a"), user("b"), user("c"), user("b")}
+	requested := []rbacv1.Subject{user("c"), user("d"), user("b")}
+
+	removed, added := subjectChanges(existing, requested)
+	assert.Equal(t, []int{1, 0}, removed, "removed subjects should be listed from the last index")
+	assert.Equal(t, []rbacv1.Subject{user("d")}, added)
+}
@@ -0,0 +1,278 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// Sweeper finds resources RBAC Manager created for RBAC Definitions that no
+// longer exist. Such resources are left behind when a definition is deleted
+// while RBAC Manager isn't running to handle it, or when its finalizer is
+// removed by hand. Resources restored from a backup may also refer to an
+// earlier incarnation of a definition that was recreated since, which are
+// re-homed to the current one if it still requests them.
+type Sweeper struct {
+	Clientset       kubernetes.Interface
+	ListDefinitions func() (rbacmanagerv1beta1.RBACDefinitionList, error)
+	// ReportOnly logs orphaned resources instead of deleting them
+	ReportOnly bool
+}
+
+// Sweep deletes or reports every orphaned resource and returns them as
+// Kind/namespace/name. Only resources that carry the managed label and an
+// owner reference to an RBAC Definition, none of which exist, are orphaned.
+// An orphaned resource whose only owner reference names an existing RBAC
+// Definition that requests it as it is gets the owner reference of that
+// definition instead, and is not returned.
+func (s *Sweeper) Sweep() ([]string, error) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	// Resources are listed before definitions. A definition created in
+	// between always exists before its resources, so they can't be mistaken
+	// for orphans.
+	serviceAccounts, err := listAllServiceAccounts(context.TODO(), s.Clientset, kube.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+	clusterRoleBindings, err := listAllClusterRoleBindings(context.TODO(), s.Clientset, kube.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+	roleBindings, err := listAllRoleBindings(context.TODO(), s.Clientset, kube.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := listAllRoles(context.TODO(), s.Clientset, kube.ListOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	rbacDefs, err := s.ListDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	uids := map[types.UID]bool{}
+	definitions := map[string]*rbacmanagerv1beta1.RBACDefinition{}
+	for i := range rbacDefs.Items {
+		uids[rbacDefs.Items[i].UID] = true
+		definitions[rbacDefs.Items[i].Name] = &rbacDefs.Items[i]
+	}
+	desired := definitionParsers(s.Clientset, definitions, func(rbacDef *rbacmanagerv1beta1.RBACDefinition, err error) {
+		logrus.Errorf("Not sweeping restored resources of RBACDefinition %v, it can't be parsed: %v", rbacDef.Name, err)
+	})
+
+	swept := []string{}
+	errs := []error{}
+	sweep := func(kind string, objectMeta *metav1.ObjectMeta, requested func(p *Parser) bool, update func() error, remove func(opts metav1.DeleteOptions) error) {
+		if !orphaned(objectMeta, uids) {
+			return
+		}
+		if rbacDef, ok := restoredOwner(objectMeta, definitions); ok {
+			p, ok := desired(rbacDef)
+			if !ok {
+				return
+			}
+			if requested(p) {
+				errs = append(errs, s.rehomeObject(kind, objectMeta, rbacDef, update))
+				return
+			}
+		}
+		swept = append(swept, objectKey(kind, objectMeta))
+		errs = append(errs, s.sweepObject(kind, objectMeta, remove))
+	}
+
+	for i := range serviceAccounts.Items {
+		sa := &serviceAccounts.Items[i]
+		sweep("ServiceAccount", &sa.ObjectMeta, func(p *Parser) bool {
+			return p.requestsServiceAccount(sa)
+		}, func() error {
+			_, err := s.Clientset.CoreV1().ServiceAccounts(sa.Namespace).Update(context.TODO(), sa, kube.UpdateOptions)
+			return err
+		}, func(opts metav1.DeleteOptions) error {
+			err := s.Clientset.CoreV1().ServiceAccounts(sa.Namespace).Delete(context.TODO(), sa.Name, opts)
+			if err == nil {
+				deleteTokenSecrets(context.TODO(), s.Clientset, sa)
+			}
+			return err
+		})
+	}
+	for i := range clusterRoleBindings.Items {
+		crb := &clusterRoleBindings.Items[i]
+		sweep("ClusterRoleBinding", &crb.ObjectMeta, func(p *Parser) bool {
+			return p.requestsClusterRoleBinding(crb)
+		}, func() error {
+			_, err := kube.RBAC(s.Clientset).ClusterRoleBindings().Update(context.TODO(), crb, kube.UpdateOptions)
+			return err
+		}, func(opts metav1.DeleteOptions) error {
+			return kube.RBAC(s.Clientset).ClusterRoleBindings().Delete(context.TODO(), crb.Name, opts)
+		})
+	}
+	for i := range roleBindings.Items {
+		rb := &roleBindings.Items[i]
+		sweep("RoleBinding", &rb.ObjectMeta, func(p *Parser) bool {
+			return p.requestsRoleBinding(rb)
+		}, func() error {
+			_, err := kube.RBAC(s.Clientset).RoleBindings(rb.Namespace).Update(context.TODO(), rb, kube.UpdateOptions)
+			return err
+		}, func(opts metav1.DeleteOptions) error {
+			return kube.RBAC(s.Clientset).RoleBindings(rb.Namespace).Delete(context.TODO(), rb.Name, opts)
+		})
+	}
+	for i := range roles.Items {
+		role := &roles.Items[i]
+		sweep("Role", &role.ObjectMeta, func(p *Parser) bool {
+			return p.requestsRole(role)
+		}, func() error {
+			_, err := kube.RBAC(s.Clientset).Roles(role.Namespace).Update(context.TODO(), role, kube.UpdateOptions)
+			return err
+		}, func(opts metav1.DeleteOptions) error {
+			return kube.RBAC(s.Clientset).Roles(role.Namespace).Delete(context.TODO(), role.Name, opts)
+		})
+	}
+
+	return swept, utilerrors.NewAggregate(errs)
+}
+
+func (s *Sweeper) sweepObject(kind string, objectMeta *metav1.ObjectMeta, remove func(opts metav1.DeleteOptions) error) error {
+	owner := definitionOwner(objectMeta)
+	if s.ReportOnly {
+		logrus.Warnf("%v belongs to RBACDefinition %v which no longer exists", objectKey(kind, objectMeta), owner)
+		metrics.OrphansSweptCounter.WithLabelValues(kind, "report").Inc()
+		return nil
+	}
+
+	logrus.Infof("Deleting %v, RBACDefinition %v no longer exists", objectKey(kind, objectMeta), owner)
+	err := remove(deleteOptions(objectMeta))
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		// Already gone or changed since it was listed, the next sweep will
+		// look at it again
+		return nil
+	} else if err != nil {
+		metrics.ErrorCounter.Inc()
+		return err
+	}
+	metrics.OrphansSweptCounter.WithLabelValues(kind, "delete").Inc()
+	metrics.ReconcileChanges.WithLabelValues(owner, kind, "delete", causePruneOrphan, string(TriggerOrphanSweep)).Inc()
+	return nil
+}
+
+// rehomeObject points the owner reference of a restored resource at rbacDef
+// instead of at an earlier incarnation of it
+func (s *Sweeper) rehomeObject(kind string, objectMeta *metav1.ObjectMeta, rbacDef *rbacmanagerv1beta1.RBACDefinition, update func() error) error {
+	if s.ReportOnly {
+		logrus.Warnf("%v belongs to an earlier RBACDefinition %v, which has been recreated", objectKey(kind, objectMeta), rbacDef.Name)
+		metrics.OrphansSweptCounter.WithLabelValues(kind, "report").Inc()
+		return nil
+	}
+
+	logrus.Infof("Re-homing %v to RBACDefinition %v, which has been recreated", objectKey(kind, objectMeta), rbacDef.Name)
+	stale := []metav1.OwnerReference{}
+	for _, ownerRef := range objectMeta.OwnerReferences {
+		if isDefinitionOwnerRef(&ownerRef) && ownerRef.Name == rbacDef.Name {
+			stale = append(stale, ownerRef)
+		}
+	}
+	objectMeta.OwnerReferences = withOwnerRefs(withoutOwnerRefs(objectMeta.OwnerReferences, stale), rbacDefOwnerRefs(rbacDef))
+	err := update()
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil
+	} else if err != nil {
+		metrics.ErrorCounter.Inc()
+		return err
+	}
+	metrics.OrphansSweptCounter.WithLabelValues(kind, "rehome").Inc()
+	return nil
+}
+
+// restoredOwner returns the RBAC Definition an orphaned resource may have
+// been restored for: one named by its only owner reference. A resource whose
+// managed-by annotation names another definition is not someone else's to
+// adopt.
+func restoredOwner(objectMeta *metav1.ObjectMeta, definitions map[string]*rbacmanagerv1beta1.RBACDefinition) (*rbacmanagerv1beta1.RBACDefinition, bool) {
+	if len(objectMeta.OwnerReferences) != 1 {
+		return nil, false
+	}
+	name := objectMeta.OwnerReferences[0].Name
+	rbacDef, ok := definitions[name]
+	if !ok {
+		return nil, false
+	}
+	if managedBy, ok := objectMeta.Annotations[kube.ManagedByAnnotation]; ok && managedBy != name {
+		return nil, false
+	}
+	return rbacDef, true
+}
+
+// orphaned reports whether objectMeta is owned by RBAC Definitions only and
+// none of them is in uids
+func orphaned(objectMeta *metav1.ObjectMeta, uids map[types.UID]bool) bool {
+	owned := false
+	for _, ownerRef := range objectMeta.OwnerReferences {
+		if !isDefinitionOwnerRef(&ownerRef) {
+			return false
+		}
+		if uids[ownerRef.UID] {
+			return false
+		}
+		owned = true
+	}
+	return owned
+}
+
+func isDefinitionOwnerRef(ownerRef *metav1.OwnerReference) bool {
+	return ownerRef.Kind == "RBACDefinition" && strings.HasPrefix(ownerRef.APIVersion, rbacmanagerv1beta1.SchemeGroupVersion.Group+"/")
+}
+
+func definitionOwner(objectMeta *metav1.ObjectMeta) string {
+	for _, ownerRef := range objectMeta.OwnerReferences {
+		if isDefinitionOwnerRef(&ownerRef) {
+			return ownerRef.Name
+		}
+	}
+	return ""
+}
+
+// SweepPeriodically calls Sweep every interval until ctx is done
+func (s *Sweeper) SweepPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			logrus.Debug("Sweeping resources of deleted RBAC Definitions")
+			if _, err := s.Sweep(); err != nil {
+				logrus.Errorf("Error sweeping resources of deleted RBAC Definitions: %v", err)
+			}
+		}
+	}
+}
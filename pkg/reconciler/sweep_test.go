@@ -0,0 +1,198 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+func TestSweepOrphans(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}})
+
+	newDefinition := func(name string) rbacmanagerv1beta1.RBACDefinition {
+		rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+		rbacDef.Name = name
+		rbacDef.UID = types.UID("uid-" + name)
+		rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+			Name: "devs",
+			Subjects: []rbacmanagerv1beta1.Subject{{
+				Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: "web"},
+			}},
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+			RoleBindings:        []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "web"}},
+		}}
+		return rbacDef
+	}
+	live := newDefinition("live")
+	gone := newDefinition("gone")
+
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&live))
+	assert.NoError(t, r.Reconcile(&gone))
+
+	// Neither unlabeled resources nor resources with other owners are touched
+	goneOwner := rbacDefOwnerRefs(&gone)
+	_, err := client.RbacV1().ClusterRoleBindings().Create(context.TODO(), &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "unlabeled", OwnerReferences: goneOwner},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	_, err = client.RbacV1().ClusterRoleBindings().Create(context.TODO(), &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "shared",
+			Labels: kube.Labels,
+			OwnerReferences: append(goneOwner, metav1.OwnerReference{
+				APIVersion: "rbacmanager.reactiveops.io/v1beta1",
+				Kind:       "RBACTemporaryGrant",
+				Name:       "oncall",
+				UID:        "uid-oncall",
+			}),
+		},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	listDefinitions := func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+		return rbacmanagerv1beta1.RBACDefinitionList{Items: []rbacmanagerv1beta1.RBACDefinition{live}}, nil
+	}
+	expected := []string{
+		"ServiceAccount/web/gone",
+		"ClusterRoleBinding//gone-devs-view",
+		"RoleBinding/web/gone-devs-edit",
+	}
+
+	s := Sweeper{Clientset: client, ListDefinitions: listDefinitions, ReportOnly: true}
+	swept, err := s.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, swept)
+	crbs, _ := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.Len(t, crbs.Items, 4, "reporting should not delete anything")
+
+	s.ReportOnly = false
+	swept, err = s.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, swept)
+
+	crbs, _ = client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	names := []string{}
+	for _, crb := range crbs.Items {
+		names = append(names, crb.Name)
+	}
+	assert.ElementsMatch(t, []string{"live-devs-view", "unlabeled", "shared"}, names)
+	expectServiceAccounts(t, client, []corev1.ServiceAccount{{
+		ObjectMeta: metav1.ObjectMeta{Name: "live", Namespace: "web"},
+	}})
+
+	swept, err = s.Sweep()
+	assert.NoError(t, err)
+	assert.Empty(t, swept)
+}
+
+func TestSweepRestoredResources(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}})
+
+	// The resources of the definition before it was recreated come back from
+	// a backup with its old UID
+	previous := rbacmanagerv1beta1.RBACDefinition{}
+	previous.Name = "team"
+	previous.UID = types.UID("uid-previous")
+	previous.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "ci", Namespace: "web"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+		RoleBindings:        []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "web"}},
+	}}
+	r := Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&previous))
+
+	// The recreated definition binds the Service Account to admin instead
+	current := *previous.DeepCopy()
+	current.UID = types.UID("uid-current")
+	current.RBACBindings[0].RoleBindings[0].ClusterRole = "admin"
+	listDefinitions := func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+		return rbacmanagerv1beta1.RBACDefinitionList{Items: []rbacmanagerv1beta1.RBACDefinition{current}}, nil
+	}
+
+	s := Sweeper{Clientset: client, ListDefinitions: listDefinitions, ReportOnly: true}
+	swept, err := s.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"RoleBinding/web/team-devs-edit"}, swept)
+	crb, err := client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "team-devs-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, types.UID("uid-previous"), crb.OwnerReferences[0].UID, "reporting should not re-home anything")
+
+	s.ReportOnly = false
+	swept, err = s.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"RoleBinding/web/team-devs-edit"}, swept)
+
+	// Resources that are still requested as they are are kept and re-homed
+	crb, err = client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "team-devs-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, rbacDefOwnerRefs(&current), crb.OwnerReferences)
+	sa, err := client.CoreV1().ServiceAccounts("web").Get(context.TODO(), "ci", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, rbacDefOwnerRefs(&current), sa.OwnerReferences)
+	rbs, err := client.RbacV1().RoleBindings("web").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, rbs.Items)
+
+	swept, err = s.Sweep()
+	assert.NoError(t, err)
+	assert.Empty(t, swept)
+}
+
+func TestRestoredOwner(t *testing.T) {
+	team := rbacmanagerv1beta1.RBACDefinition{}
+	team.Name = "team"
+	team.UID = types.UID("uid-current")
+	definitions := map[string]*rbacmanagerv1beta1.RBACDefinition{"team": &team}
+
+	stale := metav1.OwnerReference{APIVersion: "rbacmanager.reactiveops.io/v1beta1", Kind: "RBACDefinition", Name: "team", UID: "uid-previous"}
+	other := metav1.OwnerReference{APIVersion: "rbacmanager.reactiveops.io/v1beta1", Kind: "RBACDefinition", Name: "other", UID: "uid-other"}
+
+	tests := []struct {
+		name       string
+		objectMeta metav1.ObjectMeta
+		restored   bool
+	}{
+		{"stale owner", metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{stale}}, true},
+		{"managed by the owner", metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{stale}, Annotations: map[string]string{kube.ManagedByAnnotation: "team"}}, true},
+		{"managed by another definition", metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{stale}, Annotations: map[string]string{kube.ManagedByAnnotation: "other"}}, false},
+		{"owner that doesn't exist", metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{other}}, false},
+		{"several owners", metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{stale, other}}, false},
+		{"no owner", metav1.ObjectMeta{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rbacDef, ok := restoredOwner(&tt.objectMeta, definitions)
+			assert.Equal(t, tt.restored, ok)
+			if tt.restored {
+				assert.Equal(t, &team, rbacDef)
+			}
+		})
+	}
+}
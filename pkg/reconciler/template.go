@@ -0,0 +1,84 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"io"
+	"strings"
+	"text/template"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// templateContext is the data templates in subject names and Role Binding
+// names are rendered with, once for every namespace a Role Binding is
+// created in
+type templateContext struct {
+	Namespace            string
+	NamespaceLabels      map[string]string
+	NamespaceAnnotations map[string]string
+	RBACDefinition       string
+}
+
+func newTemplateContext(namespace *v1.Namespace, rbacDefName string) *templateContext {
+	return &templateContext{
+		Namespace:            namespace.Name,
+		NamespaceLabels:      namespace.Labels,
+		NamespaceAnnotations: namespace.Annotations,
+		RBACDefinition:       rbacDefName,
+	}
+}
+
+func isTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+// parseTemplate parses text strictly: referring to a label or annotation a
+// namespace doesn't have fails instead of rendering an empty string
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("template").Option("missingkey=error").Parse(text)
+}
+
+// validateTemplate reports syntax errors, unknown functions, and unknown
+// fields in text without needing a namespace to render it for
+func validateTemplate(text string) error {
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return err
+	}
+
+	// Labels and annotations are empty here, so only fields are checked
+	return tmpl.Option("missingkey=zero").Execute(io.Discard, &templateContext{})
+}
+
+// renderTemplate renders text with ctx, returning text unchanged if it isn't
+// a template
+func renderTemplate(text string, ctx *templateContext) (string, error) {
+	if !isTemplate(text) {
+		return text, nil
+	}
+
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered strings.Builder
+	err = tmpl.Execute(&rendered, ctx)
+	if err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
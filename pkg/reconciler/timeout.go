@@ -0,0 +1,87 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// ReconcileTimeout bounds how long a single reconcile may hold the global
+// lock, reconciles aren't bounded when it is zero
+var ReconcileTimeout = 5 * time.Minute
+
+// ErrReconcileTimeout is wrapped by the error of a reconcile that took longer
+// than ReconcileTimeout
+var ErrReconcileTimeout = errors.New("reconcile timed out")
+
+// startTimeout gives the Reconciler a context that expires after
+// ReconcileTimeout. It is called once the global lock is held so that waiting
+// for other reconciles doesn't count against the timeout.
+func (r *Reconciler) startTimeout() context.CancelFunc {
+	if ReconcileTimeout <= 0 {
+		r.ctx = context.Background()
+		return func() {}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ReconcileTimeout)
+	r.ctx = ctx
+	return cancel
+}
+
+// stopTimeout releases the context of the reconcile. If the reconcile ran out
+// of time the timeout is counted and ErrReconcileTimeout is added to err so
+// that the RBAC Definition is retried with FailureBackoff. Every change is
+// applied on its own, the changes that were made before the deadline are
+// kept and the retry picks up the rest.
+func (r *Reconciler) stopTimeout(cancel context.CancelFunc, err error) error {
+	timedOut := errors.Is(r.ctx.Err(), context.DeadlineExceeded)
+	cancel()
+	r.ctx = nil
+	if !timedOut {
+		return err
+	}
+
+	name := ""
+	if r.rbacDef != nil {
+		name = r.rbacDef.Name
+	}
+	metrics.ReconcileTimeouts.WithLabelValues(name).Inc()
+	logrus.Errorf("Reconciling %v took longer than %v", name, ReconcileTimeout)
+	return utilerrors.NewAggregate([]error{fmt.Errorf("%w after %v", ErrReconcileTimeout, ReconcileTimeout), err})
+}
+
+// context returns the context API calls of the current reconcile are made
+// with
+func (r *Reconciler) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// context returns the context API calls of the parser are made with
+func (p *Parser) context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestReconcileTimeout(t *testing.T) {
+	defer func(timeout time.Duration, retries int, delay time.Duration) {
+		ReconcileTimeout, MaxObjectRetries, ObjectRetryDelay = timeout, retries, delay
+	}(ReconcileTimeout, MaxObjectRetries, ObjectRetryDelay)
+	ReconcileTimeout = 50 * time.Millisecond
+	MaxObjectRetries = 3
+	ObjectRetryDelay = time.Hour
+
+	client := fake.NewSimpleClientset()
+	unavailable := true
+	client.PrependReactor("create", "clusterrolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return unavailable, nil, apierrors.NewServerTimeout(schema.GroupResource{Resource: "clusterrolebindings"}, "create", 1)
+	})
+
+	counter := metrics.ReconcileTimeouts.WithLabelValues("errors")
+	before := testutil.ToFloat64(counter)
+
+	rbacDef := errorsDefinition()
+	r := Reconciler{Clientset: client}
+	start := time.Now()
+	err := r.Reconcile(&rbacDef)
+
+	// The retry of the binding is abandoned once the reconcile runs out of time
+	assert.Less(t, int64(time.Since(start)), int64(time.Minute))
+	assert.True(t, errors.Is(err, ErrReconcileTimeout), "expected a timeout, got %v", err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(counter)-before)
+
+	SetReadyCondition(&rbacDef, err)
+	ready := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionReady)
+	if assert.NotNil(t, ready) {
+		assert.Equal(t, metav1.ConditionFalse, ready.Status)
+		assert.Equal(t, "ReconcileTimedOut", ready.Reason)
+	}
+
+	// The retry picks up where the reconcile that timed out stopped
+	unavailable = false
+	r = Reconciler{Clientset: client}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	_, err = client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "errors-devs-view", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(counter)-before)
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// CleanUpTokenSecrets deletes the token Secrets of the Service Accounts RBAC
+// Manager deletes, which would otherwise keep holding valid credentials
+var CleanUpTokenSecrets = true
+
+// deleteTokenSecrets deletes the Secrets of type service-account-token that
+// belong to sa, a managed Service Account that was just deleted. Secrets of a
+// Service Account with the same name but another UID are left alone.
+func deleteTokenSecrets(ctx context.Context, clientset kubernetes.Interface, sa *v1.ServiceAccount) {
+	if !CleanUpTokenSecrets {
+		return
+	}
+
+	secrets, err := clientset.CoreV1().Secrets(sa.Namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("type", string(v1.SecretTypeServiceAccountToken)).String(),
+	})
+	if err != nil {
+		logrus.Errorf("Error listing token Secrets of Service Account %v/%v: %v", sa.Namespace, sa.Name, err)
+		metrics.ErrorCounter.Inc()
+		return
+	}
+
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if !tokenSecretOf(secret, sa) {
+			continue
+		}
+		logrus.Infof("Deleting token Secret %v/%v of deleted Service Account %v", secret.Namespace, secret.Name, sa.Name)
+		err := clientset.CoreV1().Secrets(secret.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &secret.UID},
+		})
+		if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+			// Deleted by the token controller or replaced in the meantime
+			continue
+		} else if err != nil {
+			logrus.Errorf("Error deleting token Secret %v/%v: %v", secret.Namespace, secret.Name, err)
+			metrics.ErrorCounter.Inc()
+			continue
+		}
+		metrics.TokenSecretsDeleted.Inc()
+	}
+}
+
+// tokenSecretOf reports whether secret is a token Secret of sa
+func tokenSecretOf(secret *v1.Secret, sa *v1.ServiceAccount) bool {
+	if secret.Type != v1.SecretTypeServiceAccountToken || secret.Annotations[v1.ServiceAccountNameKey] != sa.Name {
+		return false
+	}
+	uid, ok := secret.Annotations[v1.ServiceAccountUIDKey]
+	return !ok || sa.UID == "" || uid == string(sa.UID)
+}
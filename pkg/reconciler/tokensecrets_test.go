@@ -0,0 +1,84 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestReconcileDeletesTokenSecrets(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "ci"
+	rbacDef.UID = "ci-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "deployers",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "deployer", Namespace: "ci"},
+		}},
+	}}
+
+	r := Reconciler{Clientset: client}
+	err := r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+	sa, err := client.CoreV1().ServiceAccounts("ci").Get(context.TODO(), "deployer", metav1.GetOptions{})
+	assert.NoError(t, err)
+	sa.UID = "deployer-uid"
+	_, err = client.CoreV1().ServiceAccounts("ci").Update(context.TODO(), sa, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	tokenSecret := func(name, saName, saUID string) *v1.Secret {
+		secret := &v1.Secret{Type: v1.SecretTypeServiceAccountToken}
+		secret.Name = name
+		secret.Namespace = "ci"
+		secret.Annotations = map[string]string{v1.ServiceAccountNameKey: saName, v1.ServiceAccountUIDKey: saUID}
+		return secret
+	}
+	for _, secret := range []*v1.Secret{
+		tokenSecret("deployer-token", "deployer", "deployer-uid"),
+		tokenSecret("deployer-token-old", "deployer", "previous-uid"),
+		tokenSecret("builder-token", "builder", "builder-uid"),
+	} {
+		_, err = client.CoreV1().Secrets("ci").Create(context.TODO(), secret, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+
+	deleted := testutil.ToFloat64(metrics.TokenSecretsDeleted)
+	rbacDef.RBACBindings = nil
+	err = r.Reconcile(&rbacDef)
+	assert.NoError(t, err)
+
+	_, err = client.CoreV1().ServiceAccounts("ci").Get(context.TODO(), "deployer", metav1.GetOptions{})
+	assert.Error(t, err, "the Service Account should be deleted")
+	secrets, err := client.CoreV1().Secrets("ci").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	names := []string{}
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Name)
+	}
+	assert.ElementsMatch(t, []string{"deployer-token-old", "builder-token"}, names)
+	assert.Equal(t, deleted+1, testutil.ToFloat64(metrics.TokenSecretsDeleted))
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Trigger is what caused a reconcile. It is logged with the changes the
+// reconcile makes, added to the events it records, and counted in the
+// reconcile_changes_total metric.
+type Trigger string
+
+const (
+	// TriggerSpecChange is a change to the RBAC Definition, or to an RBAC
+	// Definition it imports
+	TriggerSpecChange Trigger = "spec_change"
+	// TriggerManualSync is a change to the sync annotation
+	TriggerManualSync Trigger = "manual_sync"
+	// TriggerNamespace is a namespace that was created, changed, or deleted
+	TriggerNamespace Trigger = "namespace"
+	// TriggerOwnerDrift is a change to a resource the RBAC Definition owns
+	TriggerOwnerDrift Trigger = "owner_drift"
+	// TriggerRelatedResource is a change to a Service Account the RBAC
+	// Definition selects or a Role it binds or copies
+	TriggerRelatedResource Trigger = "related_resource"
+	// TriggerFullSync is the periodic resync, or a resync requested with
+	// SIGHUP
+	TriggerFullSync Trigger = "full_sync"
+	// TriggerOrphanSweep is the sweep for resources of deleted RBAC
+	// Definitions
+	TriggerOrphanSweep Trigger = "orphan_sweep"
+	// TriggerUnknown is reported when a Reconciler isn't told its trigger
+	TriggerUnknown Trigger = "unknown"
+)
+
+// trigger returns the trigger of the current reconcile
+func (r *Reconciler) trigger() Trigger {
+	if r.Trigger == "" {
+		return TriggerUnknown
+	}
+	return r.Trigger
+}
+
+// changeLog returns a logger for the changes made by the current reconcile,
+// which names their trigger
+func (r *Reconciler) changeLog() *logrus.Entry {
+	return logrus.WithField("trigger", r.trigger())
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+func TestTrigger(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "triggered"
+	rbacDef.UID = "triggered-uid"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "admins",
+		Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "admins"}}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "admin"}},
+	}}
+	defer ForgetSpecSnapshots(rbacDef.Name)
+	changes := func(action string, trigger Trigger) float64 {
+		return testutil.ToFloat64(metrics.ReconcileChanges.WithLabelValues("triggered", "ClusterRoleBinding", action, causeSpecChange, string(trigger)))
+	}
+	created := changes("create", TriggerSpecChange)
+	deleted := changes("delete", TriggerFullSync)
+
+	recorder := record.NewFakeRecorder(20)
+	r := Reconciler{Clientset: client, Recorder: recorder, Trigger: TriggerSpecChange}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, float64(1), changes("create", TriggerSpecChange)-created)
+
+	// Changes are counted, and their events recorded, with the trigger of the
+	// reconcile that made them
+	rbacDef.RBACBindings[0].ClusterRoleBindings = nil
+	r.Trigger = TriggerFullSync
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Equal(t, float64(1), changes("delete", TriggerFullSync)-deleted)
+	assert.Equal(t, "Normal Pruned Deleted ClusterRoleBinding triggered-admins-admin: no longer in spec (rbacBindings entry 'admins' no longer requests it) (triggered by full_sync)", <-recorder.Events)
+}
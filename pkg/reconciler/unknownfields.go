@@ -0,0 +1,186 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// StrictFields fails the reconcile of RBAC Definitions whose last applied
+// configuration has fields RBAC Manager doesn't know, which the API server
+// prunes without an error. It is off for clusters where mutating controllers
+// add fields of their own.
+var StrictFields bool
+
+// setUnknownFieldsCondition records the unknown fields of the configuration
+// rbacDef was last applied with in the UnknownFieldsDetected condition, and
+// returns an error listing them. The condition is removed if StrictFields is
+// off or rbacDef wasn't applied with kubectl apply.
+func (r *Reconciler) setUnknownFieldsCondition(rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
+	applied, ok := rbacDef.Annotations[v1.LastAppliedConfigAnnotation]
+	if !StrictFields || !ok {
+		meta.RemoveStatusCondition(&rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionUnknownFieldsDetected)
+		return nil
+	}
+
+	unknown, err := unknownFields([]byte(applied))
+	if err != nil {
+		logrus.Warnf("Could not check the last applied configuration of RBACDefinition %v for unknown fields: %v", rbacDef.Name, err)
+		meta.RemoveStatusCondition(&rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionUnknownFieldsDetected)
+		return nil
+	}
+
+	condition := metav1.Condition{
+		Type:               rbacmanagerv1beta1.ConditionUnknownFieldsDetected,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rbacDef.Generation,
+		Reason:             "NoUnknownFields",
+		Message:            "The last applied configuration has no unknown fields",
+	}
+	if len(unknown) > 0 {
+		previous := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionUnknownFieldsDetected)
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "UnknownFieldsDetected"
+		condition.Message = truncateMessage("Unknown fields: "+strings.Join(unknown, ", "), maxReadyMessageLength)
+		if previous == nil || previous.Message != condition.Message {
+			r.event(v1.EventTypeWarning, "UnknownFieldsDetected", "%v", condition.Message)
+		}
+	}
+	meta.SetStatusCondition(&rbacDef.Status.Conditions, condition)
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown fields: %v", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// unknownFields returns the paths of the fields of the JSON RBAC Definition
+// applied that RBACDefinition doesn't have, in the form of ParseError paths
+func unknownFields(applied []byte) ([]string, error) {
+	var value interface{}
+	err := json.Unmarshal(applied, &value)
+	if err != nil {
+		return nil, err
+	}
+	return unknownFieldsOf(reflect.TypeOf(rbacmanagerv1beta1.RBACDefinition{}), value, ""), nil
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// unknownFieldsOf compares value with the fields t is decoded into. Values of
+// the wrong type are left to the API server.
+func unknownFieldsOf(t reflect.Type, value interface{}, path string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return nil
+	}
+
+	unknown := []string{}
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		fields := jsonFields(t)
+		keys := []string{}
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field, ok := fields[key]
+			if !ok {
+				unknown = append(unknown, fieldPath(path, key))
+				continue
+			}
+			unknown = append(unknown, unknownFieldsOf(field, object[key], fieldPath(path, key))...)
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range items {
+			unknown = append(unknown, unknownFieldsOf(t.Elem(), item, fmt.Sprintf("%v[%d]", path, i))...)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for key, item := range object {
+			unknown = append(unknown, unknownFieldsOf(t.Elem(), item, fieldPath(path, key))...)
+		}
+		sort.Strings(unknown)
+	}
+	return unknown
+}
+
+// jsonFields returns the types of the fields of struct t by their JSON names,
+// including those of inlined and embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" && field.Anonymous {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for key, fieldType := range jsonFields(embedded) {
+					if _, ok := fields[key]; !ok {
+						fields[key] = fieldType
+					}
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func fieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
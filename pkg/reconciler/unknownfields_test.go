@@ -0,0 +1,90 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestUnknownFields(t *testing.T) {
+	unknown, err := unknownFields([]byte(`{
+		"apiVersion": "rbacmanager.reactiveops.io/v1beta1",
+		"kind": "RBACDefinition",
+		"metadata": {"name": "devs", "annotations": {"any": "value"}, "lables": {}},
+		"rbacBindings": [{
+			"name": "devs",
+			"subjcts": [{"kind": "User", "name": "joe"}],
+			"roleBindings": [{"clusterRole": "view", "namespaceSelector": {"matchLabels": {"team": "dev"}, "matchLabel": {}}}]
+		}, {
+			"name": "ops",
+			"subjects": [{"kind": "User", "name": "jane", "namespaceSelector": {}, "nmae": "x"}]
+		}]
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"metadata.lables",
+		"rbacBindings[0].roleBindings[0].namespaceSelector.matchLabel",
+		"rbacBindings[0].subjcts",
+		"rbacBindings[1].subjects[0].nmae",
+	}, unknown)
+
+	_, err = unknownFields([]byte(`{`))
+	assert.Error(t, err)
+}
+
+func TestStrictFields(t *testing.T) {
+	defer func(strict bool) { StrictFields = strict }(StrictFields)
+	StrictFields = true
+
+	client := fake.NewSimpleClientset()
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "strict"
+	rbacDef.Annotations = map[string]string{
+		v1.LastAppliedConfigAnnotation: `{"metadata": {"name": "strict"}, "rbacBindings": [{"name": "admins", "subjcts": []}]}`,
+	}
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name:                "admins",
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+	}}
+
+	r := Reconciler{Clientset: client, Recorder: record.NewFakeRecorder(10)}
+	assert.EqualError(t, r.Reconcile(&rbacDef), "unknown fields: rbacBindings[0].subjcts")
+	condition := meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionUnknownFieldsDetected)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "Unknown fields: rbacBindings[0].subjcts", condition.Message)
+	}
+	crbs, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, crbs.Items)
+
+	// Turning strict mode off removes the condition
+	StrictFields = false
+	rbacDef.Generation = 2
+	rbacDef.RBACBindings[0].Subjects = []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}}
+	assert.NoError(t, r.Reconcile(&rbacDef))
+	assert.Nil(t, meta.FindStatusCondition(rbacDef.Status.Conditions, rbacmanagerv1beta1.ConditionUnknownFieldsDetected))
+}
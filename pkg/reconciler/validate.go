@@ -0,0 +1,588 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+// Validate checks an RBAC Definition for problems that can be found without
+// looking at the cluster. Any problem is returned as a *ParseError.
+func Validate(rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
+	switch rbacDef.ConflictPolicy {
+	case "", rbacmanagerv1beta1.ConflictPolicyFail, rbacmanagerv1beta1.ConflictPolicySkip, rbacmanagerv1beta1.ConflictPolicyAdopt:
+	default:
+		return &ParseError{
+			Path:   "conflictPolicy",
+			Reason: fmt.Sprintf("conflictPolicy must be one of Fail, Skip, or Adopt, got %s", rbacDef.ConflictPolicy),
+		}
+	}
+
+	switch rbacDef.DeletionPolicy {
+	case "", rbacmanagerv1beta1.DeletionPolicyDelete, rbacmanagerv1beta1.DeletionPolicyOrphan, rbacmanagerv1beta1.DeletionPolicyDeleteNamespaces:
+	default:
+		return &ParseError{
+			Path:   "deletionPolicy",
+			Reason: fmt.Sprintf("deletionPolicy must be one of Delete, Orphan, or DeleteNamespaces, got %s", rbacDef.DeletionPolicy),
+		}
+	}
+
+	for index, name := range rbacDef.Imports {
+		if name == "" {
+			return &ParseError{Path: fmt.Sprintf("imports[%d]", index), Reason: "RBACDefinition name required"}
+		}
+	}
+
+	clusterNames := map[string]bool{}
+	for index, cluster := range rbacDef.Clusters {
+		path := fmt.Sprintf("clusters[%d]", index)
+		if cluster.Name == "" {
+			return &ParseError{Path: path, Reason: "name required"}
+		}
+		if clusterNames[cluster.Name] {
+			return &ParseError{Path: path, Reason: fmt.Sprintf("cluster %s is listed more than once", cluster.Name)}
+		}
+		clusterNames[cluster.Name] = true
+		if cluster.KubeconfigSecret.Namespace == "" || cluster.KubeconfigSecret.Name == "" {
+			return &ParseError{Path: path + ".kubeconfigSecret", Reason: "namespace and name required"}
+		}
+	}
+
+	for index, rbacBinding := range rbacDef.RBACBindings {
+		err := validateRBACBinding(&rbacBinding, &rbacDef.Defaults)
+		if err != nil {
+			return newParseError(fmt.Sprintf("rbacBindings[%d]", index), rbacBinding.Name, err)
+		}
+	}
+
+	err := validateNamespaced(rbacDef)
+	if err != nil {
+		return err
+	}
+
+	err = validateBindingNames(rbacDef)
+	if err != nil {
+		return err
+	}
+
+	return validateRoleSources(rbacDef)
+}
+
+// validateBindingNames rejects names set on entries that another entry
+// generates as well, since one binding would replace the other. Role
+// Bindings are only compared in the namespaces entries list, and templated
+// names are rendered per namespace, so neither can be compared otherwise.
+// Role Bindings of clusterRoleBindings entries with limitToNamespaceSelector
+// may be created in any namespace, so their names may not be used by any
+// other Role Binding, whether they are generated or set.
+func validateBindingNames(rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
+	type binding struct {
+		path     string
+		explicit bool
+	}
+	clusterRoleBindings := map[string]binding{}
+	roleBindings := map[string]binding{}
+	add := func(names map[string]binding, kind, name, path string, explicit bool) error {
+		if isTemplate(name) {
+			return nil
+		}
+		if previous, ok := names[name]; ok && (explicit || previous.explicit) {
+			return &ParseError{Path: path + ".name", Reason: fmt.Sprintf("%s %s is also created by %s", kind, name, previous.path)}
+		}
+		names[name] = binding{path: path, explicit: explicit}
+		return nil
+	}
+	limitedRoleBindings := map[string]string{}
+	roleBindingNames := map[string]string{}
+	overlap := func(names map[string]string, name, path string) error {
+		if previous, ok := names[name]; ok && !isTemplate(name) {
+			return &ParseError{Path: path + ".name", Reason: fmt.Sprintf("RoleBinding %s is also created by %s", name, previous)}
+		}
+		return nil
+	}
+
+	for index, rbacBinding := range rbacDef.RBACBindings {
+		prefix := rdNamePrefix(rbacDef, &rbacBinding)
+		for crbIndex, crb := range rbacBinding.ClusterRoleBindings {
+			name := crb.Name
+			if name == "" {
+				name = fmt.Sprintf("%v-%v", prefix, crb.ClusterRole)
+			}
+			path := fmt.Sprintf("rbacBindings[%d].clusterRoleBindings[%d]", index, crbIndex)
+			if crb.LimitToNamespaceSelector != nil {
+				err := overlap(limitedRoleBindings, name, path)
+				if err == nil {
+					err = overlap(roleBindingNames, name, path)
+				}
+				if err != nil {
+					return err
+				}
+				limitedRoleBindings[name] = path
+				continue
+			}
+			err := add(clusterRoleBindings, "ClusterRoleBinding", name, path, crb.Name != "")
+			if err != nil {
+				return err
+			}
+		}
+		for rbIndex, rb := range rbacBinding.RoleBindings {
+			path := fmt.Sprintf("rbacBindings[%d].roleBindings[%d]", index, rbIndex)
+			namespaces := rb.Namespaces
+			if rb.Namespace != "" {
+				namespaces = append([]string{rb.Namespace}, namespaces...)
+			}
+			for _, entry := range roleBindingEntries(rb) {
+				name := entry.Name
+				if name == "" {
+					name = generatedRoleBindingName(&entry, prefix)
+				}
+				err := overlap(limitedRoleBindings, name, path)
+				if err != nil {
+					return err
+				}
+				if _, ok := roleBindingNames[name]; !ok {
+					roleBindingNames[name] = path
+				}
+				for _, namespace := range namespaces {
+					err := add(roleBindings, "RoleBinding", namespace+"/"+name, path, entry.Name != "")
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateRoleSources rejects roleFrom entries that copy Roles with the same
+// name from different namespaces, since the copies would replace each other
+// in namespaces both entries apply to
+func validateRoleSources(rbacDef *rbacmanagerv1beta1.RBACDefinition) error {
+	sources := map[string]string{}
+	for i, rbacBinding := range rbacDef.RBACBindings {
+		for j, rb := range rbacBinding.RoleBindings {
+			if rb.RoleFrom == nil {
+				continue
+			}
+			namespace, ok := sources[rb.RoleFrom.Name]
+			if ok && namespace != rb.RoleFrom.Namespace {
+				return newParseError(fmt.Sprintf("rbacBindings[%d]", i), rbacBinding.Name, &ParseError{
+					Path:   fmt.Sprintf("roleBindings[%d].roleFrom", j),
+					Reason: fmt.Sprintf("Role %s is also copied from namespace %s", rb.RoleFrom.Name, namespace),
+				})
+			}
+			sources[rb.RoleFrom.Name] = rb.RoleFrom.Namespace
+		}
+	}
+	return nil
+}
+
+func validateRBACBinding(rbacBinding *rbacmanagerv1beta1.RBACBinding, defaults *rbacmanagerv1beta1.Defaults) error {
+	if len(rbacBinding.Subjects) < 1 {
+		return &ParseError{Path: "subjects", Reason: "no subjects specified"}
+	}
+
+	templated := false
+	for index, subject := range rbacBinding.Subjects {
+		var err error
+		if subject.Kind == rbacmanagerv1beta1.ServiceAccountsInNamespaceKind {
+			err = validateServiceAccountsInNamespace(&subject)
+			subject = serviceAccountsGroup(subject)
+		}
+		if err == nil {
+			err = validateServiceAccountSelector(&subject)
+		}
+		if err == nil {
+			err = validateDefinitionServiceAccounts(&subject)
+		}
+		if err == nil {
+			err = validateServiceAccountMetadata(&subject)
+		}
+		if err == nil {
+			err = validateCreateServiceAccount(&subject)
+		}
+		if err == nil {
+			err = validateSubjectAPIGroup(&subject)
+		}
+		if err == nil {
+			err = validateSubjectRawName(&subject)
+		}
+		if err == nil {
+			err = validateSubjectTemplate(&subject)
+		}
+		if err != nil {
+			return newParseError(fmt.Sprintf("subjects[%d]", index), "", err)
+		}
+		templated = templated || isTemplate(subject.Name)
+	}
+
+	clusterScoped := false
+	for index, clusterRoleBinding := range rbacBinding.ClusterRoleBindings {
+		if clusterRoleBinding.LimitToNamespaceSelector == nil {
+			clusterScoped = true
+			if clusterRoleBinding.Name == "" {
+				continue
+			}
+			// Cluster Role Bindings aren't rendered per namespace
+			if errs := validation.IsDNS1123Subdomain(clusterRoleBinding.Name); len(errs) > 0 {
+				return &ParseError{
+					Path:   fmt.Sprintf("clusterRoleBindings[%d].name", index),
+					Reason: fmt.Sprintf("%s is not a valid name: %s", clusterRoleBinding.Name, strings.Join(errs, ", ")),
+				}
+			}
+			continue
+		}
+
+		err := validateLimitedClusterRoleBinding(&clusterRoleBinding, rbacBinding.Subjects)
+		if err != nil {
+			return newParseError(fmt.Sprintf("clusterRoleBindings[%d]", index), "", err)
+		}
+	}
+
+	if clusterScoped && templated {
+		return &ParseError{
+			Path:   "clusterRoleBindings",
+			Reason: "templated subjects are rendered per namespace and can't be bound cluster wide, use limitToNamespaceSelector",
+		}
+	}
+
+	if clusterScoped {
+		for index, subject := range rbacBinding.Subjects {
+			if subject.Kind == rbacv1.ServiceAccountKind && subject.Namespace == "" && defaults.ServiceAccountNamespace == "" {
+				return &ParseError{
+					Path:   fmt.Sprintf("subjects[%d]", index),
+					Reason: fmt.Sprintf("ServiceAccount %s requires a namespace to be used in clusterRoleBindings", subject.Name),
+				}
+			}
+		}
+	}
+
+	for index, roleBinding := range rbacBinding.RoleBindings {
+		err := validateRoleBinding(&roleBinding)
+		if err != nil {
+			return newParseError(fmt.Sprintf("roleBindings[%d]", index), "", err)
+		}
+	}
+
+	return nil
+}
+
+// clusterOnlyGroups and clusterOnlyUsers are identities of cluster components
+// whose access only makes sense when granted cluster wide
+var clusterOnlyGroups = []string{"system:masters", "system:nodes"}
+var clusterOnlyUsers = []string{"system:kube-controller-manager", "system:kube-proxy", "system:kube-scheduler"}
+
+func validateLimitedClusterRoleBinding(crb *rbacmanagerv1beta1.ClusterRoleBinding, subjects []rbacmanagerv1beta1.Subject) error {
+	if isEmptySelector(crb.LimitToNamespaceSelector) {
+		return &ParseError{Path: "limitToNamespaceSelector", Reason: "matchLabels or matchExpressions required"}
+	}
+
+	if crb.Name != "" {
+		err := validateBindingName(crb.Name)
+		if err != nil {
+			return &ParseError{Path: "name", Reason: err.Error()}
+		}
+	}
+
+	_, err := metav1.LabelSelectorAsSelector(crb.LimitToNamespaceSelector)
+	if err != nil {
+		return &ParseError{Path: "limitToNamespaceSelector", Reason: err.Error()}
+	}
+
+	for _, subject := range subjects {
+		if isClusterOnlySubject(&subject) {
+			return fmt.Errorf("%s %s can only be bound cluster wide and cannot be combined with limitToNamespaceSelector", subject.Kind, subject.Name)
+		}
+	}
+
+	return nil
+}
+
+func isClusterOnlySubject(subject *rbacmanagerv1beta1.Subject) bool {
+	switch subject.Kind {
+	case rbacv1.GroupKind:
+		return stringInSlice(subject.Name, clusterOnlyGroups)
+	case rbacv1.UserKind:
+		return strings.HasPrefix(subject.Name, "system:node:") || stringInSlice(subject.Name, clusterOnlyUsers)
+	}
+	return false
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSubjectTemplate only allows templates in the names of Users and
+// Groups, since Service Accounts are created once and not per namespace
+func validateSubjectTemplate(subject *rbacmanagerv1beta1.Subject) error {
+	if subject.Kind == rbacv1.ServiceAccountKind {
+		if isTemplate(subject.Name) || isTemplate(subject.Namespace) {
+			return errors.New("templates are only supported in the names of User and Group subjects")
+		}
+		return nil
+	}
+
+	if !isTemplate(subject.Name) {
+		return nil
+	}
+	err := validateTemplate(subject.Name)
+	if err != nil {
+		return &ParseError{Path: "name", Reason: err.Error()}
+	}
+	return nil
+}
+
+// validateBindingName checks the name of a Role Binding, or only the template
+// if it is one since it can't be rendered without a namespace
+func validateBindingName(name string) error {
+	if isTemplate(name) {
+		return validateTemplate(name)
+	}
+
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("%s is not a valid name: %s", name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// validateCreateIfMissing only allows namespaces to be created for entries
+// that name them explicitly, since selectors can only match existing ones
+func validateCreateIfMissing(rb *rbacmanagerv1beta1.RoleBinding) error {
+	if !rb.CreateIfMissing {
+		if len(rb.NamespaceLabels) > 0 {
+			return &ParseError{Path: "namespaceLabels", Reason: "namespaceLabels requires createIfMissing"}
+		}
+		return nil
+	}
+
+	if !isEmptySelector(&rb.NamespaceSelector) || rb.NamespaceAnnotationSelector != nil || rb.NamespaceOwnedBy != nil {
+		return errors.New("createIfMissing can't be combined with namespaceSelector, namespaceAnnotationSelector, or namespaceOwnedBy")
+	}
+
+	if rb.Namespace != "" {
+		if errs := validation.IsDNS1123Label(rb.Namespace); len(errs) > 0 {
+			return &ParseError{Path: "namespace", Reason: fmt.Sprintf("%s is not a valid namespace name: %s", rb.Namespace, strings.Join(errs, ", "))}
+		}
+	}
+	for index, namespace := range rb.Namespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return &ParseError{Path: fmt.Sprintf("namespaces[%d]", index), Reason: fmt.Sprintf("%s is not a valid namespace name: %s", namespace, strings.Join(errs, ", "))}
+		}
+	}
+
+	for key, value := range rb.NamespaceLabels {
+		errs := validation.IsQualifiedName(key)
+		errs = append(errs, validation.IsValidLabelValue(value)...)
+		if len(errs) > 0 {
+			return &ParseError{Path: "namespaceLabels", Reason: fmt.Sprintf("%s=%s is not a valid label: %s", key, value, strings.Join(errs, ", "))}
+		}
+	}
+	return nil
+}
+
+// validateSubjectAPIGroup allows the apiGroup of a subject to be omitted or
+// set to rbac.authorization.k8s.io, which is normalized to the right value for
+// the subject kind, but rejects any other value
+func validateSubjectAPIGroup(subject *rbacmanagerv1beta1.Subject) error {
+	if subject.APIGroup == "" || subject.APIGroup == rbacv1.GroupName {
+		return nil
+	}
+
+	return fmt.Errorf("apiGroup %s is not valid for %s %s", subject.APIGroup, subject.Kind, subject.Name)
+}
+
+func validateSubjectRawName(subject *rbacmanagerv1beta1.Subject) error {
+	if !subject.RawName || subject.Kind == rbacv1.UserKind || subject.Kind == rbacv1.GroupKind {
+		return nil
+	}
+	return &ParseError{Path: "rawName", Reason: "rawName only applies to User and Group subjects"}
+}
+
+func validateRoleBinding(rb *rbacmanagerv1beta1.RoleBinding) error {
+	if len(rb.ClusterRoles) > 0 || len(rb.Roles) > 0 {
+		err := validateRoleLists(rb)
+		if err != nil {
+			return err
+		}
+		if rb.RoleFrom != nil {
+			return errors.New("roleFrom is mutually exclusive with roles and clusterRoles")
+		}
+		if rb.ClusterRole != "" || rb.Role != "" {
+			return errors.New("roles and clusterRoles are mutually exclusive with role and clusterRole")
+		}
+		// Bindings of roles in the lists are validated like single role entries
+		for _, entry := range roleBindingEntries(*rb) {
+			err := validateRoleBinding(&entry)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if rb.RoleFrom != nil {
+		if rb.ClusterRole != "" || rb.Role != "" {
+			return errors.New("roleFrom is mutually exclusive with role and clusterRole")
+		}
+		if rb.RoleFrom.Namespace == "" || rb.RoleFrom.Name == "" {
+			return &ParseError{Path: "roleFrom", Reason: "namespace and name required"}
+		}
+	} else if rb.ClusterRole == "" && rb.Role == "" {
+		return errors.New("role or clusterRole required")
+	}
+
+	if rb.Name != "" {
+		err := validateBindingName(rb.Name)
+		if err != nil {
+			return &ParseError{Path: "name", Reason: err.Error()}
+		}
+	}
+
+	for index, namespace := range rb.Namespaces {
+		if namespace == "" {
+			return &ParseError{Path: fmt.Sprintf("namespaces[%d]", index), Reason: "namespace name required"}
+		}
+	}
+
+	err := validateCreateIfMissing(rb)
+	if err != nil {
+		return err
+	}
+
+	if rb.NamespaceAnnotationSelector != nil {
+		err := validateAnnotationSelector(rb.NamespaceAnnotationSelector)
+		if err != nil {
+			return err
+		}
+		if rb.Namespace != "" {
+			return errors.New("namespaceAnnotationSelector and namespace are mutually exclusive")
+		}
+	}
+
+	if rb.NamespaceOwnedBy != nil {
+		err := validateNamespaceOwner(rb.NamespaceOwnedBy)
+		if err != nil {
+			return err
+		}
+		if rb.Namespace != "" {
+			return errors.New("namespaceOwnedBy and namespace are mutually exclusive")
+		}
+	}
+
+	if rb.RequireNamespaceLabel != nil {
+		err := validateNamespaceLabelRequirement(rb.RequireNamespaceLabel)
+		if err != nil {
+			return err
+		}
+		if rb.CreateIfMissing {
+			return errors.New("requireNamespaceLabel can't be combined with createIfMissing")
+		}
+	}
+
+	if isEmptySelector(&rb.NamespaceSelector) {
+		if rb.Namespace == "" && len(rb.Namespaces) == 0 && rb.NamespaceAnnotationSelector == nil && rb.NamespaceOwnedBy == nil {
+			return errors.New("namespace, namespaces, namespaceSelector, namespaceAnnotationSelector, or namespaceOwnedBy required")
+		}
+		return nil
+	}
+
+	if rb.Namespace != "" {
+		return errors.New("namespaceSelector and namespace are mutually exclusive")
+	}
+
+	_, err = metav1.LabelSelectorAsSelector(&rb.NamespaceSelector)
+	if err != nil {
+		return &ParseError{Path: "namespaceSelector", Reason: err.Error()}
+	}
+
+	return nil
+}
+
+func validateNamespaceOwner(owner *rbacmanagerv1beta1.NamespaceOwner) error {
+	if owner.Label != "" {
+		if owner.Kind != "" {
+			return &ParseError{Path: "namespaceOwnedBy", Reason: "kind and label are mutually exclusive"}
+		}
+		if owner.Name == "" {
+			return &ParseError{Path: "namespaceOwnedBy.name", Reason: "name required with label"}
+		}
+		return nil
+	}
+	if owner.Kind == "" {
+		return &ParseError{Path: "namespaceOwnedBy", Reason: "kind or label required"}
+	}
+	return nil
+}
+
+func validateNamespaceLabelRequirement(requirement *rbacmanagerv1beta1.NamespaceLabelRequirement) error {
+	if requirement.Key == "" {
+		return &ParseError{Path: "requireNamespaceLabel.key", Reason: "key required"}
+	}
+	if errs := validation.IsQualifiedName(requirement.Key); len(errs) > 0 {
+		return &ParseError{Path: "requireNamespaceLabel.key", Reason: fmt.Sprintf("%s is not a valid label key: %s", requirement.Key, strings.Join(errs, ", "))}
+	}
+	if errs := validation.IsValidLabelValue(requirement.Value); len(errs) > 0 {
+		return &ParseError{Path: "requireNamespaceLabel.value", Reason: fmt.Sprintf("%s is not a valid label value: %s", requirement.Value, strings.Join(errs, ", "))}
+	}
+	return nil
+}
+
+// generatedRoleBindingName is the name parseRoleBinding gives the Role
+// Bindings of an entry without a name
+func generatedRoleBindingName(rb *rbacmanagerv1beta1.RoleBinding, prefix string) string {
+	switch {
+	case rb.ClusterRole != "":
+		return fmt.Sprintf("%v-%v", prefix, rb.ClusterRole)
+	case rb.Role != "":
+		return fmt.Sprintf("%v-%v-%v", prefix, rb.Role, rb.Namespace)
+	case rb.RoleFrom != nil:
+		return fmt.Sprintf("%v-%v", prefix, rb.RoleFrom.Name)
+	}
+	return ""
+}
+
+func validateAnnotationSelector(selector *rbacmanagerv1beta1.NamespaceAnnotationSelector) error {
+	if len(selector.MatchAnnotations) == 0 && len(selector.Exists) == 0 {
+		return &ParseError{Path: "namespaceAnnotationSelector", Reason: "matchAnnotations or exists required"}
+	}
+
+	for key := range selector.MatchAnnotations {
+		if key == "" {
+			return &ParseError{Path: "namespaceAnnotationSelector.matchAnnotations", Reason: "annotation key required"}
+		}
+	}
+
+	for index, key := range selector.Exists {
+		if key == "" {
+			return &ParseError{Path: fmt.Sprintf("namespaceAnnotationSelector.exists[%d]", index), Reason: "annotation key required"}
+		}
+	}
+
+	return nil
+}
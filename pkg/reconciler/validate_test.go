@@ -0,0 +1,556 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestValidateClusterRoleBindingServiceAccountNamespace(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "ci-bot",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      "deployer",
+				Namespace: "bots",
+			},
+		}, {
+			Subject: rbacv1.Subject{
+				Kind: rbacv1.ServiceAccountKind,
+				Name: "ci-bot",
+			},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+	}}
+
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].subjects[1]", parseErr.Path)
+	assert.Equal(t, "rbacBindings[0] 'ci-bot': subjects[1]: ServiceAccount ci-bot requires a namespace to be used in clusterRoleBindings", parseErr.Error())
+
+	rbacDef.Defaults.ServiceAccountNamespace = "bots"
+	assert.NoError(t, Validate(&rbacDef))
+
+	// A namespace isn't required when the Service Account is only used in Role Bindings
+	rbacDef.Defaults.ServiceAccountNamespace = ""
+	rbacDef.RBACBindings[0].ClusterRoleBindings = nil
+	rbacDef.RBACBindings[0].RoleBindings = []rbacmanagerv1beta1.RoleBinding{{
+		ClusterRole: "view",
+		Namespace:   "bots",
+	}}
+	assert.NoError(t, Validate(&rbacDef))
+}
+
+func TestValidateSubjectAPIGroup(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}, {
+			Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "robot", Namespace: "bots", APIGroup: rbacv1.GroupName},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+	}}
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].Subjects[0].APIGroup = "v1"
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].subjects[0]", parseErr.Path)
+	assert.Equal(t, "apiGroup v1 is not valid for User joe", parseErr.Reason)
+}
+
+func TestValidateServiceAccountsInNamespace(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "builders",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacmanagerv1beta1.ServiceAccountsInNamespaceKind, Namespace: "build"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole: "edit",
+			Namespace:   "build",
+		}},
+	}}
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].Subjects[0].Namespace = "{{ .Namespace }}"
+	assert.NoError(t, Validate(&rbacDef), "the namespace can be a template")
+
+	rbacDef.RBACBindings[0].Subjects[0].Namespace = ""
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].subjects[0]", parseErr.Path)
+	assert.Equal(t, "namespace required for ServiceAccountsInNamespace subjects", parseErr.Reason)
+
+	rbacDef.RBACBindings[0].Subjects[0].Namespace = "build"
+	rbacDef.RBACBindings[0].Subjects[0].Name = "builder"
+	assert.Error(t, Validate(&rbacDef))
+}
+
+func TestValidateServiceAccountSelector(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "tenants",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject:  rbacv1.Subject{Kind: rbacmanagerv1beta1.ServiceAccountSelectorKind},
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant-sa": "true"}},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+		}},
+	}}
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].Subjects[0].Selector = &metav1.LabelSelector{}
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].subjects[0].selector", parseErr.Path)
+
+	rbacDef.RBACBindings[0].Subjects[0].Selector = nil
+	rbacDef.RBACBindings[0].Subjects[0].Kind = rbacv1.UserKind
+	rbacDef.RBACBindings[0].Subjects[0].Name = "joe"
+	rbacDef.RBACBindings[0].Subjects[0].NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}}
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0] 'tenants': subjects[0]: selector and namespaceSelector are only supported for ServiceAccountSelector subjects")
+}
+
+func TestValidateLimitToNamespaceSelector(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "nodes",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "robot"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{
+			ClusterRole: "view",
+			LimitToNamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"team": "devs"},
+			},
+		}},
+	}}
+
+	// ServiceAccounts don't need a namespace when bound through RoleBindings
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].ClusterRoleBindings[0].LimitToNamespaceSelector = &metav1.LabelSelector{}
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].clusterRoleBindings[0].limitToNamespaceSelector", parseErr.Path)
+
+	rbacDef.RBACBindings[0].ClusterRoleBindings[0].LimitToNamespaceSelector = &metav1.LabelSelector{
+		MatchLabels: map[string]string{"team": "devs"},
+	}
+	rbacDef.RBACBindings[0].Subjects = append(rbacDef.RBACBindings[0].Subjects, rbacmanagerv1beta1.Subject{
+		Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "system:nodes"},
+	})
+	err = Validate(&rbacDef)
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].clusterRoleBindings[0]", parseErr.Path)
+	assert.Equal(t, "Group system:nodes can only be bound cluster wide and cannot be combined with limitToNamespaceSelector", parseErr.Reason)
+
+	// Role Bindings limited to selected namespaces may be created in any
+	// namespace, so no other Role Binding may share their names
+	rbacDef.RBACBindings[0].Subjects = rbacDef.RBACBindings[0].Subjects[:1]
+	rbacDef.RBACBindings[0].RoleBindings = []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "view", Namespace: "web"}}
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0]: roleBindings[0]: name: RoleBinding rbac-config-nodes-view is also created by rbacBindings[0].clusterRoleBindings[0]")
+
+	rbacDef.RBACBindings[0].RoleBindings = nil
+	rbacDef.RBACBindings[0].ClusterRoleBindings = append(rbacDef.RBACBindings[0].ClusterRoleBindings, rbacDef.RBACBindings[0].ClusterRoleBindings[0])
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0]: clusterRoleBindings[1]: name: RoleBinding rbac-config-nodes-view is also created by rbacBindings[0].clusterRoleBindings[0]")
+}
+
+func TestValidateNamespaceList(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole: "edit",
+			Namespaces:  []string{"web", "api"},
+		}},
+	}}
+
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].RoleBindings[0].Namespaces = []string{"web", ""}
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].roleBindings[0].namespaces[1]", parseErr.Path)
+
+	rbacDef.RBACBindings[0].RoleBindings[0].Namespaces = nil
+	err = Validate(&rbacDef)
+	assert.EqualError(t, err, "rbacBindings[0] 'devs': roleBindings[0]: namespace, namespaces, namespaceSelector, namespaceAnnotationSelector, or namespaceOwnedBy required")
+}
+
+func TestValidateNamespaceAnnotationSelector(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "team-x",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "team-x"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole: "edit",
+			NamespaceAnnotationSelector: &rbacmanagerv1beta1.NamespaceAnnotationSelector{
+				MatchAnnotations: map[string]string{"owner": "team-x"},
+			},
+		}},
+	}}
+
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].RoleBindings[0].NamespaceAnnotationSelector = &rbacmanagerv1beta1.NamespaceAnnotationSelector{}
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].roleBindings[0].namespaceAnnotationSelector", parseErr.Path)
+
+	rbacDef.RBACBindings[0].RoleBindings[0].NamespaceAnnotationSelector = &rbacmanagerv1beta1.NamespaceAnnotationSelector{
+		Exists: []string{"owner", ""},
+	}
+	err = Validate(&rbacDef)
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].roleBindings[0].namespaceAnnotationSelector.exists[1]", parseErr.Path)
+
+	rbacDef.RBACBindings[0].RoleBindings[0].NamespaceAnnotationSelector.Exists = []string{"owner"}
+	rbacDef.RBACBindings[0].RoleBindings[0].Namespace = "web"
+	assert.Error(t, Validate(&rbacDef))
+}
+
+func TestValidateNamespaceOwnedBy(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "team-x",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "team-x"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:      "edit",
+			NamespaceOwnedBy: &rbacmanagerv1beta1.NamespaceOwner{Kind: "Tenant"},
+		}},
+	}}
+
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].RoleBindings[0].NamespaceOwnedBy = &rbacmanagerv1beta1.NamespaceOwner{Name: "team-x"}
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].roleBindings[0].namespaceOwnedBy", parseErr.Path)
+
+	rbacDef.RBACBindings[0].RoleBindings[0].NamespaceOwnedBy = &rbacmanagerv1beta1.NamespaceOwner{Label: "vcluster.loft.sh/managed-by"}
+	err = Validate(&rbacDef)
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].roleBindings[0].namespaceOwnedBy.name", parseErr.Path)
+
+	rbacDef.RBACBindings[0].RoleBindings[0].NamespaceOwnedBy.Name = "team-x"
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].RoleBindings[0].Namespace = "web"
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0] 'team-x': roleBindings[0]: namespaceOwnedBy and namespace are mutually exclusive")
+}
+
+func TestValidateRequireNamespaceLabel(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "platform",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "platform"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:           "admin",
+			Namespace:             "web",
+			RequireNamespaceLabel: &rbacmanagerv1beta1.NamespaceLabelRequirement{Key: "rbac.example.com/allow-elevated"},
+		}},
+	}}
+
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].RoleBindings[0].RequireNamespaceLabel.Key = ""
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].roleBindings[0].requireNamespaceLabel.key", parseErr.Path)
+
+	rbacDef.RBACBindings[0].RoleBindings[0].RequireNamespaceLabel.Key = "rbac.example.com/allow-elevated"
+	rbacDef.RBACBindings[0].RoleBindings[0].CreateIfMissing = true
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0] 'platform': roleBindings[0]: requireNamespaceLabel can't be combined with createIfMissing")
+}
+
+func TestValidateBindingNames(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "ops",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "ops"},
+		}},
+		ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{
+			{Name: "crb-ops-view", ClusterRole: "view"},
+			{ClusterRole: "edit"},
+		},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{
+			{Name: "ops-admin", ClusterRole: "admin", Namespace: "web"},
+			{Name: "ops-admin", ClusterRole: "admin", Namespace: "api"},
+		},
+	}}
+
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].ClusterRoleBindings[0].Name = "CRB_ops"
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].clusterRoleBindings[0].name", parseErr.Path)
+
+	// Names set on entries may not collide with generated ones
+	rbacDef.RBACBindings[0].ClusterRoleBindings[0].Name = "rbac-config-ops-edit"
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0]: clusterRoleBindings[1]: name: ClusterRoleBinding rbac-config-ops-edit is also created by rbacBindings[0].clusterRoleBindings[0]")
+
+	// or with each other in the same namespace
+	rbacDef.RBACBindings[0].ClusterRoleBindings[0].Name = "crb-ops-view"
+	rbacDef.RBACBindings[0].RoleBindings[1].Namespaces = []string{"web"}
+	rbacDef.RBACBindings[0].RoleBindings[1].Namespace = ""
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0]: roleBindings[1]: name: RoleBinding web/ops-admin is also created by rbacBindings[0].roleBindings[0]")
+
+	// Role Bindings limited to selected namespaces may be created in any
+	// namespace, so no other Role Binding may share their names, not even a
+	// generated one
+	rbacDef.RBACBindings[0].RoleBindings[1].Namespaces = nil
+	rbacDef.RBACBindings[0].RoleBindings[1].Namespace = "api"
+	rbacDef.RBACBindings[0].ClusterRoleBindings = append(rbacDef.RBACBindings[0].ClusterRoleBindings, rbacmanagerv1beta1.ClusterRoleBinding{
+		ClusterRole:              "admin",
+		LimitToNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "ops"}},
+	})
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].RoleBindings = append(rbacDef.RBACBindings[0].RoleBindings, rbacmanagerv1beta1.RoleBinding{ClusterRole: "admin", Namespace: "ops"})
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0]: roleBindings[2]: name: RoleBinding rbac-config-ops-admin is also created by rbacBindings[0].clusterRoleBindings[2]")
+
+	rbacDef.RBACBindings[0].RoleBindings = rbacDef.RBACBindings[0].RoleBindings[:2]
+	rbacDef.RBACBindings[0].ClusterRoleBindings[2].Name = "ops-admin"
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0]: roleBindings[0]: name: RoleBinding ops-admin is also created by rbacBindings[0].clusterRoleBindings[2]")
+}
+
+func TestValidateClusters(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.Clusters = []rbacmanagerv1beta1.RemoteCluster{{
+		Name:             "workload-1",
+		KubeconfigSecret: rbacmanagerv1beta1.KubeconfigSecretReference{Namespace: "rbac-manager", Name: "workload-1"},
+	}}
+
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.Clusters = append(rbacDef.Clusters, rbacDef.Clusters[0])
+	assert.EqualError(t, Validate(&rbacDef), "clusters[1]: cluster workload-1 is listed more than once")
+
+	rbacDef.Clusters = []rbacmanagerv1beta1.RemoteCluster{{Name: "workload-2"}}
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "clusters[0].kubeconfigSecret", parseErr.Path)
+}
+
+func TestValidateRoleFrom(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			RoleFrom:  &rbacmanagerv1beta1.RoleSource{Namespace: "golden", Name: "developer"},
+			Namespace: "web",
+		}},
+	}, {
+		Name: "ops",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "jane"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			RoleFrom:  &rbacmanagerv1beta1.RoleSource{Namespace: "golden", Name: "developer"},
+			Namespace: "api",
+		}},
+	}}
+
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[1].RoleBindings[0].RoleFrom.Namespace = "platinum"
+	err := Validate(&rbacDef)
+	assert.EqualError(t, err, "rbacBindings[1] 'ops': roleBindings[0]: roleFrom: Role developer is also copied from namespace golden")
+
+	rbacDef.RBACBindings[1].RoleBindings[0].RoleFrom.Namespace = ""
+	err = Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[1].roleBindings[0].roleFrom", parseErr.Path)
+	assert.Equal(t, "namespace and name required", parseErr.Reason)
+
+	rbacDef.RBACBindings[1].RoleBindings[0].RoleFrom.Namespace = "golden"
+	rbacDef.RBACBindings[1].RoleBindings[0].ClusterRole = "edit"
+	err = Validate(&rbacDef)
+	assert.EqualError(t, err, "rbacBindings[1] 'ops': roleBindings[0]: roleFrom is mutually exclusive with role and clusterRole")
+}
+
+func TestValidateCreateIfMissing(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRole:     "edit",
+			Namespace:       "web",
+			Namespaces:      []string{"api"},
+			CreateIfMissing: true,
+			NamespaceLabels: map[string]string{"team": "devs"},
+		}},
+	}}
+
+	assert.NoError(t, Validate(&rbacDef))
+
+	rbacDef.RBACBindings[0].RoleBindings[0].NamespaceLabels = map[string]string{"team": "dev ops"}
+	err := Validate(&rbacDef)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].roleBindings[0].namespaceLabels", parseErr.Path)
+
+	rbacDef.RBACBindings[0].RoleBindings[0].NamespaceLabels = nil
+	rbacDef.RBACBindings[0].RoleBindings[0].Namespaces = []string{"API"}
+	err = Validate(&rbacDef)
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+	assert.Equal(t, "rbacBindings[0].roleBindings[0].namespaces[0]", parseErr.Path)
+
+	rbacDef.RBACBindings[0].RoleBindings[0].Namespace = ""
+	rbacDef.RBACBindings[0].RoleBindings[0].Namespaces = nil
+	rbacDef.RBACBindings[0].RoleBindings[0].NamespaceSelector = metav1.LabelSelector{MatchLabels: map[string]string{"team": "devs"}}
+	err = Validate(&rbacDef)
+	assert.EqualError(t, err, "rbacBindings[0] 'devs': roleBindings[0]: createIfMissing can't be combined with namespaceSelector, namespaceAnnotationSelector, or namespaceOwnedBy")
+
+	rbacDef.RBACBindings[0].RoleBindings[0].CreateIfMissing = false
+	rbacDef.RBACBindings[0].RoleBindings[0].NamespaceLabels = map[string]string{"team": "devs"}
+	err = Validate(&rbacDef)
+	assert.EqualError(t, err, "rbacBindings[0] 'devs': roleBindings[0]: namespaceLabels: namespaceLabels requires createIfMissing")
+}
+
+func TestValidateRoleLists(t *testing.T) {
+	rbacDef := rbacmanagerv1beta1.RBACDefinition{}
+	rbacDef.Name = "rbac-config"
+	rbacDef.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+		Name: "devs",
+		Subjects: []rbacmanagerv1beta1.Subject{{
+			Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"},
+		}},
+		RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+			ClusterRoles: []string{"view", "pod-exec"},
+			Roles:        []string{"view"},
+			Namespace:    "web",
+		}},
+	}}
+	assert.NoError(t, Validate(&rbacDef))
+
+	rb := &rbacDef.RBACBindings[0].RoleBindings[0]
+	rb.ClusterRole = "edit"
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0] 'devs': roleBindings[0]: roles and clusterRoles are mutually exclusive with role and clusterRole")
+
+	rb.ClusterRole = ""
+	rb.RoleFrom = &rbacmanagerv1beta1.RoleSource{Namespace: "templates", Name: "deployer"}
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0] 'devs': roleBindings[0]: roleFrom is mutually exclusive with roles and clusterRoles")
+
+	rb.RoleFrom = nil
+	rb.ClusterRoles = []string{"view", "view"}
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0] 'devs': roleBindings[0]: clusterRoles[1]: view is already listed in clusterRoles")
+
+	// Named entries can't bind a Cluster Role and a Role of the same name
+	rb.ClusterRoles = []string{"view"}
+	rb.Name = "devs"
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0] 'devs': roleBindings[0]: roles[0]: view is already listed in clusterRoles")
+
+	rb.Roles = []string{""}
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0] 'devs': roleBindings[0]: roles[0]: role name required")
+
+	rb.Roles = nil
+	rb.Namespace = ""
+	assert.EqualError(t, Validate(&rbacDef), "rbacBindings[0] 'devs': roleBindings[0]: namespace, namespaces, namespaceSelector, namespaceAnnotationSelector, or namespaceOwnedBy required")
+}
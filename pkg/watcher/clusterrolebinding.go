@@ -27,27 +27,24 @@ import (
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/schlapzz/rbac-manager/pkg/kube"
-	"github.com/schlapzz/rbac-manager/pkg/reconciler"
 )
 
-func watchClusterRoleBindings(clientset *kubernetes.Clientset) {
-	watcher, err := clientset.RbacV1().ClusterRoleBindings().Watch(context.TODO(), kube.ListOptions)
+func watchClusterRoleBindings(ctx context.Context, clientset *kubernetes.Clientset, queue *definitionQueue, beat func()) {
+	watcher, err := kube.RBAC(clientset).ClusterRoleBindings().Watch(ctx, kube.ListOptions)
 
 	if err != nil {
 		logrus.Error(err, "unable to watch Cluster Role Bindings")
 		runtime.HandleError(err)
+		return
 	}
 
-	ch := watcher.ResultChan()
-
-	for event := range ch {
+	watchEvents(ctx, watcher, beat, func(event watch.Event) {
 		crb, ok := event.Object.(*rbacv1.ClusterRoleBinding)
 		if !ok {
 			logrus.Error("Could not parse Cluster Role Binding")
 		} else if event.Type == watch.Modified || event.Type == watch.Deleted {
-			logrus.Debugf("Reconciling RBACDefinition for %s ClusterRoleBinding after %s event", crb.Name, event.Type)
-			r := reconciler.Reconciler{Clientset: kube.GetClientsetOrDie()}
-			_ = r.ReconcileOwners(crb.OwnerReferences, "ClusterRoleBinding")
+			logrus.Debugf("Queueing RBACDefinition for %s ClusterRoleBinding after %s event", crb.Name, event.Type)
+			queue.enqueueOwners(crb.OwnerReferences)
 		}
-	}
+	})
 }
@@ -0,0 +1,87 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HealthPath is where HealthHandler is served on the metrics address
+const HealthPath = "/healthz"
+
+// heartbeats maps the name of every supervised watcher to the time of its
+// last heartbeat
+var heartbeats = sync.Map{}
+
+// Health is the state of the watchers that are running
+type Health struct {
+	// Resources are the kinds of resources that are watched
+	Resources []string        `json:"resources"`
+	Watchers  []WatcherHealth `json:"watchers"`
+}
+
+// WatcherHealth is the state of a single watcher
+type WatcherHealth struct {
+	Name          string    `json:"name"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+	Healthy       bool      `json:"healthy"`
+}
+
+// CurrentHealth returns the state of every watcher started so far. Watchers
+// are healthy if they heartbeat within HeartbeatTimeout.
+func CurrentHealth() Health {
+	health := Health{Resources: Resources, Watchers: []WatcherHealth{}}
+	heartbeats.Range(func(name, lastBeat interface{}) bool {
+		health.Watchers = append(health.Watchers, WatcherHealth{
+			Name:          name.(string),
+			LastHeartbeat: lastBeat.(time.Time),
+			Healthy:       time.Since(lastBeat.(time.Time)) <= HeartbeatTimeout,
+		})
+		return true
+	})
+	sort.Slice(health.Watchers, func(i, j int) bool {
+		return health.Watchers[i].Name < health.Watchers[j].Name
+	})
+	return health
+}
+
+// HealthHandler serves CurrentHealth, with a 503 status if any watcher
+// missed its heartbeat
+type HealthHandler struct{}
+
+// ServeHTTP writes the health of the watchers as JSON
+func (HealthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	health := CurrentHealth()
+	status := http.StatusOK
+	for _, watcher := range health.Watchers {
+		if !watcher.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		logrus.Errorf("Error writing health response: %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+// Workers is the number of goroutines reconciling RBAC Definitions queued by watchers
+var Workers = 2
+
+// definitionQueue collects the names of RBAC Definitions that watch events
+// asked to reconcile. Duplicate names collapse while waiting and failed
+// reconciles are retried with exponential backoff per definition.
+type definitionQueue struct {
+	queue     workqueue.RateLimitingInterface
+	reconcile func(name string, trigger reconciler.Trigger) error
+	// triggersMux guards triggers, what first queued each RBAC Definition
+	// waiting to be reconciled
+	triggersMux sync.Mutex
+	triggers    map[string]reconciler.Trigger
+}
+
+func newDefinitionQueue(clientset kubernetes.Interface) *definitionQueue {
+	return &definitionQueue{
+		queue: workqueue.NewNamedRateLimitingQueue(reconciler.FailureBackoff, "rbacdefinitions"),
+		reconcile: func(name string, trigger reconciler.Trigger) error {
+			return reconcileDefinition(clientset, kube.GetRbacDefinition, name, trigger)
+		},
+	}
+}
+
+// add queues the RBAC Definition called name. A definition that is already
+// waiting keeps the trigger that queued it first.
+func (q *definitionQueue) add(name string, trigger reconciler.Trigger) {
+	q.keepTrigger(name, trigger)
+	q.queue.Add(name)
+}
+
+// keepTrigger records trigger for name unless it is waiting for another one
+func (q *definitionQueue) keepTrigger(name string, trigger reconciler.Trigger) {
+	q.triggersMux.Lock()
+	defer q.triggersMux.Unlock()
+	if q.triggers == nil {
+		q.triggers = map[string]reconciler.Trigger{}
+	}
+	if _, ok := q.triggers[name]; !ok {
+		q.triggers[name] = trigger
+	}
+}
+
+// takeTrigger returns the trigger of an RBAC Definition taken off the queue,
+// so that it can be queued again by a new trigger while it is reconciled
+func (q *definitionQueue) takeTrigger(name string) reconciler.Trigger {
+	q.triggersMux.Lock()
+	defer q.triggersMux.Unlock()
+	trigger, ok := q.triggers[name]
+	if !ok {
+		return reconciler.TriggerUnknown
+	}
+	delete(q.triggers, name)
+	return trigger
+}
+
+// enqueueOwners queues every RBAC Definition found in ownerRefs
+func (q *definitionQueue) enqueueOwners(ownerRefs []metav1.OwnerReference) {
+	for _, ownerRef := range ownerRefs {
+		if ownerRef.Kind == "RBACDefinition" {
+			q.add(ownerRef.Name, reconciler.TriggerOwnerDrift)
+		}
+	}
+	metrics.QueueDepth.Set(float64(q.queue.Len()))
+}
+
+// enqueueAll queues every RBAC Definition listDefinitions returns
+func (q *definitionQueue) enqueueAll(listDefinitions func() (rbacmanagerv1beta1.RBACDefinitionList, error)) error {
+	rbacDefs, err := listDefinitions()
+	if err != nil {
+		return err
+	}
+
+	for _, rbacDef := range rbacDefs.Items {
+		q.add(rbacDef.Name, reconciler.TriggerFullSync)
+	}
+	metrics.QueueDepth.Set(float64(q.queue.Len()))
+	return nil
+}
+
+// enqueueSelecting queues every RBAC Definition listDefinitions returns that
+// selects a Service Account labeled with any of labelSets, along with the
+// definitions importing them
+func (q *definitionQueue) enqueueSelecting(listDefinitions func() (rbacmanagerv1beta1.RBACDefinitionList, error), labelSets ...map[string]string) error {
+	return q.enqueueMatching(listDefinitions, func(rbacDef *rbacmanagerv1beta1.RBACDefinition) bool {
+		for _, saLabels := range labelSets {
+			if reconciler.SelectsServiceAccount(rbacDef, saLabels) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// enqueueUsingRole queues every RBAC Definition listDefinitions returns that
+// copies the Role name from namespace or binds a Role of that name, along
+// with the definitions importing them
+func (q *definitionQueue) enqueueUsingRole(listDefinitions func() (rbacmanagerv1beta1.RBACDefinitionList, error), namespace string, name string) error {
+	return q.enqueueMatching(listDefinitions, func(rbacDef *rbacmanagerv1beta1.RBACDefinition) bool {
+		return reconciler.CopiesRole(rbacDef, namespace, name) || reconciler.BindsRole(rbacDef, name)
+	})
+}
+
+// enqueueMatching queues every RBAC Definition listDefinitions returns that
+// matches, along with the definitions importing them
+func (q *definitionQueue) enqueueMatching(listDefinitions func() (rbacmanagerv1beta1.RBACDefinitionList, error), matches func(rbacDef *rbacmanagerv1beta1.RBACDefinition) bool) error {
+	rbacDefs, err := listDefinitions()
+	if err != nil {
+		return err
+	}
+
+	matching := map[string]bool{}
+	for i := range rbacDefs.Items {
+		if matches(&rbacDefs.Items[i]) {
+			matching[rbacDefs.Items[i].Name] = true
+		}
+	}
+
+	// Imports can be nested, so repeat until no more importers are found
+	for found := len(matching) > 0; found; {
+		found = false
+		for _, rbacDef := range rbacDefs.Items {
+			if matching[rbacDef.Name] {
+				continue
+			}
+			for _, name := range rbacDef.Imports {
+				if matching[name] {
+					matching[rbacDef.Name] = true
+					found = true
+					break
+				}
+			}
+		}
+	}
+
+	for name := range matching {
+		q.add(name, reconciler.TriggerRelatedResource)
+	}
+	metrics.QueueDepth.Set(float64(q.queue.Len()))
+	return nil
+}
+
+// run starts workers that process the queue until it is shut down
+func (q *definitionQueue) run(workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for q.processNextItem() {
+			}
+		}()
+	}
+}
+
+func (q *definitionQueue) processNextItem() bool {
+	key, shutdown := q.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer q.queue.Done(key)
+	metrics.QueueDepth.Set(float64(q.queue.Len()))
+
+	name := key.(string)
+	trigger := q.takeTrigger(name)
+	start := time.Now()
+	err := q.reconcile(name, trigger)
+	metrics.QueueWorkDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		logrus.Errorf("Error reconciling RBACDefinition %v, retrying: %v", name, err)
+		metrics.ErrorCounter.Inc()
+		metrics.QueueRetries.Inc()
+		// The retry keeps the trigger unless the definition was queued again
+		q.keepTrigger(name, trigger)
+		q.queue.AddRateLimited(key)
+		return true
+	}
+
+	q.queue.Forget(key)
+	return true
+}
+
+// reconcileDefinition reconciles the RBAC Definition getDefinition returns
+// for name because of trigger. It is read when the name is taken off the
+// queue, so changes made while it waited are reconciled at once rather than
+// one revision at a time.
+func reconcileDefinition(clientset kubernetes.Interface, getDefinition func(name string) (rbacmanagerv1beta1.RBACDefinition, error), name string, trigger reconciler.Trigger) error {
+	rbacDef, err := getDefinition(name)
+	if apierrors.IsNotFound(err) {
+		// Mostly queued by events of resources it owned that are being
+		// garbage collected
+		logrus.Debugf("RBACDefinition %v no longer exists", name)
+		metrics.StaleOwnerEvents.Inc()
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !rbacDef.DeletionTimestamp.IsZero() {
+		logrus.Debugf("Skipping RBACDefinition %v, it is being deleted", name)
+		return nil
+	}
+
+	r := reconciler.Reconciler{Clientset: clientset, Trigger: trigger}
+	err = r.Reconcile(&rbacDef)
+	if err != nil {
+		return err
+	}
+	r.WriteDriftReport(&rbacDef)
+
+	// Roles that were missing may have been created
+	if r.RoleMissingChanged() {
+		err = kube.UpdateRbacDefinitionStatus(&rbacDef)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Nothing watches remote clusters, so this is where drift in them is
+	// repaired. Their status is only updated by the controller.
+	for _, cluster := range rbacDef.Clusters {
+		remote, err := kube.GetRemoteClientset(clientset, cluster.KubeconfigSecret)
+		if err == nil {
+			rr := reconciler.Reconciler{Clientset: remote, Cluster: cluster.Name, Trigger: trigger}
+			err = rr.Reconcile(&rbacDef)
+		}
+
+		if err != nil {
+			metrics.RemoteClusterSyncCounter.WithLabelValues(cluster.Name, rbacDef.Name, "error").Inc()
+			return fmt.Errorf("cluster %v: %v", cluster.Name, err)
+		}
+		metrics.RemoteClusterSyncCounter.WithLabelValues(cluster.Name, rbacDef.Name, "success").Inc()
+	}
+	return nil
+}
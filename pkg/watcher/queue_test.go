@@ -0,0 +1,260 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/workqueue"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
+)
+
+func newTestQueue(reconcile func(name string, trigger reconciler.Trigger) error) *definitionQueue {
+	return &definitionQueue{
+		queue:     workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, 10*time.Millisecond)),
+		reconcile: reconcile,
+	}
+}
+
+func TestQueueCoalescesOwners(t *testing.T) {
+	reconciled := []string{}
+	q := newTestQueue(func(name string, trigger reconciler.Trigger) error {
+		reconciled = append(reconciled, name)
+		return nil
+	})
+
+	ownerRefs := []metav1.OwnerReference{
+		{Kind: "RBACDefinition", Name: "devs"},
+		{Kind: "Deployment", Name: "unrelated"},
+	}
+	for i := 0; i < 5; i++ {
+		q.enqueueOwners(ownerRefs)
+	}
+	q.enqueueOwners([]metav1.OwnerReference{{Kind: "RBACDefinition", Name: "ops"}})
+
+	assert.Equal(t, 2, q.queue.Len())
+	assert.True(t, q.processNextItem())
+	assert.True(t, q.processNextItem())
+	assert.Equal(t, []string{"devs", "ops"}, reconciled)
+	assert.Equal(t, 0, q.queue.Len())
+}
+
+func TestQueueRetriesFailures(t *testing.T) {
+	attempts := 0
+	q := newTestQueue(func(name string, trigger reconciler.Trigger) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("apiserver unavailable")
+		}
+		return nil
+	})
+
+	q.enqueueOwners([]metav1.OwnerReference{{Kind: "RBACDefinition", Name: "devs"}})
+	for i := 0; i < 3; i++ {
+		assert.True(t, q.processNextItem())
+	}
+
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 0, q.queue.NumRequeues("devs"), "Success should reset the backoff")
+
+	q.queue.ShutDown()
+	assert.False(t, q.processNextItem())
+}
+
+func TestQueueDeletedOwner(t *testing.T) {
+	resource := rbacmanagerv1beta1.SchemeGroupVersion.WithResource("rbacdefinitions").GroupResource()
+	lookupErr := apierrors.NewForbidden(resource, "devs", errors.New("denied"))
+	getDefinition := func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+		if name == "devs" {
+			return rbacmanagerv1beta1.RBACDefinition{}, lookupErr
+		}
+		return rbacmanagerv1beta1.RBACDefinition{}, apierrors.NewNotFound(resource, name)
+	}
+	staleEvents := testutil.ToFloat64(metrics.StaleOwnerEvents)
+
+	// A definition deleted before its owned resources is counted, not retried
+	assert.NoError(t, reconcileDefinition(fake.NewSimpleClientset(), getDefinition, "deleted", reconciler.TriggerOwnerDrift))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.StaleOwnerEvents)-staleEvents)
+
+	assert.Equal(t, lookupErr, reconcileDefinition(fake.NewSimpleClientset(), getDefinition, "devs", reconciler.TriggerOwnerDrift))
+}
+
+func TestQueueEnqueueAll(t *testing.T) {
+	q := newTestQueue(func(name string, trigger reconciler.Trigger) error { return nil })
+	q.enqueueOwners([]metav1.OwnerReference{{Kind: "RBACDefinition", Name: "devs"}})
+
+	err := q.enqueueAll(func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+		list := rbacmanagerv1beta1.RBACDefinitionList{Items: make([]rbacmanagerv1beta1.RBACDefinition, 2)}
+		list.Items[0].Name = "devs"
+		list.Items[1].Name = "ops"
+		return list, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, q.queue.Len(), "Definitions already queued should not be queued twice")
+
+	err = q.enqueueAll(func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+		return rbacmanagerv1beta1.RBACDefinitionList{}, errors.New("apiserver unavailable")
+	})
+	assert.EqualError(t, err, "apiserver unavailable")
+}
+
+func TestQueueEnqueueSelecting(t *testing.T) {
+	q := newTestQueue(func(name string, trigger reconciler.Trigger) error { return nil })
+	listDefinitions := func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+		list := rbacmanagerv1beta1.RBACDefinitionList{Items: make([]rbacmanagerv1beta1.RBACDefinition, 4)}
+		list.Items[0].Name = "tenants"
+		list.Items[0].RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+			Subjects: []rbacmanagerv1beta1.Subject{{
+				Subject:  rbacv1.Subject{Kind: rbacmanagerv1beta1.ServiceAccountSelectorKind},
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant-sa": "true"}},
+			}},
+		}}
+		list.Items[1].Name = "devs"
+		list.Items[2].Name = "platform"
+		list.Items[2].Imports = []string{"all"}
+		list.Items[3].Name = "all"
+		list.Items[3].Imports = []string{"tenants"}
+		return list, nil
+	}
+
+	assert.NoError(t, q.enqueueSelecting(listDefinitions, map[string]string{"app": "web"}))
+	assert.Equal(t, 0, q.queue.Len())
+
+	assert.NoError(t, q.enqueueSelecting(listDefinitions, map[string]string{"app": "web"}, map[string]string{"tenant-sa": "true"}))
+	assert.Equal(t, 3, q.queue.Len(), "definitions importing a selecting definition should be queued too")
+}
+
+func TestQueueEnqueueUsingRole(t *testing.T) {
+	q := newTestQueue(func(name string, trigger reconciler.Trigger) error { return nil })
+	listDefinitions := func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+		list := rbacmanagerv1beta1.RBACDefinitionList{Items: make([]rbacmanagerv1beta1.RBACDefinition, 3)}
+		list.Items[0].Name = "devs"
+		list.Items[0].RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+				RoleFrom: &rbacmanagerv1beta1.RoleSource{Namespace: "golden", Name: "developer"},
+			}},
+		}}
+		list.Items[1].Name = "ops"
+		list.Items[1].RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{Role: "deployer", Namespace: "api"}},
+		}}
+		list.Items[2].Name = "all"
+		list.Items[2].Imports = []string{"devs"}
+		return list, nil
+	}
+
+	assert.NoError(t, q.enqueueUsingRole(listDefinitions, "web", "developer"))
+	assert.Equal(t, 0, q.queue.Len())
+
+	assert.NoError(t, q.enqueueUsingRole(listDefinitions, "golden", "developer"))
+	assert.Equal(t, 2, q.queue.Len(), "definitions importing a copying definition should be queued too")
+
+	assert.NoError(t, q.enqueueUsingRole(listDefinitions, "api", "deployer"))
+	assert.Equal(t, 3, q.queue.Len(), "definitions binding the Role should be queued")
+}
+
+func TestQueueReconcilesLatestRevision(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "view"}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "edit"}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "admin"}},
+	)
+	stored := rbacmanagerv1beta1.RBACDefinition{}
+	stored.Name = "rapid"
+	stored.UID = "rapid-uid"
+	// Keeps the reconcile from writing the status, which has no fake client
+	stored.Status.Conditions = []metav1.Condition{{
+		Type:               rbacmanagerv1beta1.ConditionRoleMissingInNamespaces,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: 3,
+		Reason:             "RolesFound",
+		Message:            "Every referenced Role exists",
+	}}
+	getDefinition := func(name string) (rbacmanagerv1beta1.RBACDefinition, error) {
+		return *stored.DeepCopy(), nil
+	}
+	q := newTestQueue(func(name string, trigger reconciler.Trigger) error {
+		return reconcileDefinition(client, getDefinition, name, trigger)
+	})
+
+	// Three revisions are applied before a worker gets to the definition
+	for generation, role := range []string{"view", "edit", "admin"} {
+		stored.Generation = int64(generation + 1)
+		stored.RBACBindings = []rbacmanagerv1beta1.RBACBinding{{
+			Name:         "devs",
+			Subjects:     []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "devs"}}},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{Namespace: "web", ClusterRole: role}},
+		}}
+		q.enqueueOwners([]metav1.OwnerReference{{Kind: "RBACDefinition", Name: "rapid"}})
+	}
+	assert.Equal(t, 1, q.queue.Len())
+	assert.True(t, q.processNextItem())
+
+	created := []string{}
+	for _, action := range client.Actions() {
+		assert.NotEqual(t, "delete", action.GetVerb())
+		if create, ok := action.(clienttesting.CreateAction); ok && action.GetResource().Resource == "rolebindings" {
+			created = append(created, create.GetObject().(*rbacv1.RoleBinding).RoleRef.Name)
+		}
+	}
+	assert.Equal(t, []string{"admin"}, created, "only the final revision should be reconciled")
+}
+
+func TestQueueTriggers(t *testing.T) {
+	triggers := map[string][]reconciler.Trigger{}
+	q := newTestQueue(func(name string, trigger reconciler.Trigger) error {
+		triggers[name] = append(triggers[name], trigger)
+		if name == "failing" && len(triggers[name]) == 1 {
+			return errors.New("apiserver unavailable")
+		}
+		return nil
+	})
+
+	q.enqueueOwners([]metav1.OwnerReference{{Kind: "RBACDefinition", Name: "devs"}, {Kind: "RBACDefinition", Name: "failing"}})
+	err := q.enqueueAll(func() (rbacmanagerv1beta1.RBACDefinitionList, error) {
+		list := rbacmanagerv1beta1.RBACDefinitionList{Items: make([]rbacmanagerv1beta1.RBACDefinition, 2)}
+		list.Items[0].Name = "devs"
+		list.Items[1].Name = "ops"
+		return list, nil
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		assert.True(t, q.processNextItem())
+	}
+
+	// Definitions that are already waiting keep the trigger that queued them,
+	// and retries keep the trigger of the failed reconcile
+	assert.Equal(t, map[string][]reconciler.Trigger{
+		"devs":    {reconciler.TriggerOwnerDrift},
+		"ops":     {reconciler.TriggerFullSync},
+		"failing": {reconciler.TriggerOwnerDrift, reconciler.TriggerOwnerDrift},
+	}, triggers)
+}
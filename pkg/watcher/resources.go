@@ -0,0 +1,76 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AllResources are the kinds of resources RBAC Manager can watch for changes
+var AllResources = []string{"clusterrolebindings", "namespaces", "rolebindings", "roles", "serviceaccounts"}
+
+// Resources are the kinds of resources RBAC Manager watches. Changes to the
+// other kinds are only picked up by the periodic resync. It is read when the
+// watchers start, so changing it requires a restart.
+var Resources = AllResources
+
+// ParseResources parses a comma separated list of the kinds of resources to
+// watch, named like AllResources
+func ParseResources(value string) ([]string, error) {
+	resources := []string{}
+	seen := map[string]bool{}
+	for _, resource := range strings.Split(value, ",") {
+		resource = strings.ToLower(strings.TrimSpace(resource))
+		if resource == "" || seen[resource] {
+			continue
+		}
+		if !contains(AllResources, resource) {
+			return nil, fmt.Errorf("%s can't be watched, expected one of %s", resource, strings.Join(AllResources, ", "))
+		}
+		seen[resource] = true
+		resources = append(resources, resource)
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("expected at least one of %s", strings.Join(AllResources, ", "))
+	}
+	return resources, nil
+}
+
+// Watches reports whether changes to resource are watched
+func Watches(resource string) bool {
+	return contains(Resources, resource)
+}
+
+// WatchesAll reports whether every kind of resource is watched
+func WatchesAll() bool {
+	for _, resource := range AllResources {
+		if !Watches(resource) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResources(t *testing.T) {
+	resources, err := ParseResources(" RoleBindings,namespaces,rolebindings ")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rolebindings", "namespaces"}, resources)
+
+	_, err = ParseResources("rolebindings,secrets")
+	assert.EqualError(t, err, "secrets can't be watched, expected one of clusterrolebindings, namespaces, rolebindings, roles, serviceaccounts")
+
+	_, err = ParseResources("")
+	assert.Error(t, err)
+}
+
+func TestWatches(t *testing.T) {
+	defer func(resources []string) { Resources = resources }(Resources)
+
+	assert.True(t, WatchesAll())
+	Resources = []string{"rolebindings", "namespaces"}
+	assert.True(t, Watches("rolebindings"))
+	assert.False(t, Watches("clusterrolebindings"))
+	assert.False(t, WatchesAll())
+}
+
+func TestHealthHandler(t *testing.T) {
+	defer func(resources []string) { Resources = resources }(Resources)
+	// Forget the watchers of other tests
+	heartbeats.Range(func(name, _ interface{}) bool {
+		heartbeats.Delete(name)
+		return true
+	})
+	defer heartbeats.Delete("rolebindings")
+	Resources = []string{"rolebindings"}
+
+	serve := func() (int, Health) {
+		recorder := httptest.NewRecorder()
+		HealthHandler{}.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, HealthPath, nil))
+		health := Health{}
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &health))
+		return recorder.Code, health
+	}
+
+	heartbeats.Store("rolebindings", time.Now())
+	code, health := serve()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"rolebindings"}, health.Resources)
+	assert.Len(t, health.Watchers, 1)
+	assert.True(t, health.Watchers[0].Healthy)
+
+	heartbeats.Store("rolebindings", time.Now().Add(-2*HeartbeatTimeout))
+	code, health = serve()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, health.Watchers[0].Healthy)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+func watchRoles(ctx context.Context, clientset *kubernetes.Clientset, namespace string, queue *definitionQueue, beat func()) {
+	watcher, err := kube.RBAC(clientset).Roles(namespace).Watch(ctx, kube.ListOptions)
+
+	if err != nil {
+		logrus.Error(err, "unable to watch Roles")
+		runtime.HandleError(err)
+		return
+	}
+
+	watchEvents(ctx, watcher, beat, func(event watch.Event) {
+		role, ok := event.Object.(*rbacv1.Role)
+		if !ok {
+			logrus.Error("Could not parse Role")
+		} else if event.Type == watch.Modified || event.Type == watch.Deleted {
+			logrus.Debugf("Queueing RBACDefinition for %s Role after %s event", role.Name, event.Type)
+			queue.enqueueOwners(role.OwnerReferences)
+		}
+	})
+}
+
+// watchSourceRoles queues the RBAC Definitions copying or binding a Role
+// whenever it is added, changed, or deleted so that the copies follow it and
+// bindings waiting for it are created. Unlike watchRoles it sees Roles RBAC
+// Manager doesn't manage.
+func watchSourceRoles(ctx context.Context, clientset *kubernetes.Clientset, namespace string, queue *definitionQueue, beat func()) {
+	// Listing first starts the watch after the existing Roles, so they don't
+	// queue anything
+	list, err := kube.RBAC(clientset).Roles(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logrus.Error(err, "unable to list Roles")
+		runtime.HandleError(err)
+		return
+	}
+
+	watcher, err := kube.RBAC(clientset).Roles(namespace).Watch(ctx, metav1.ListOptions{ResourceVersion: list.ResourceVersion})
+
+	if err != nil {
+		logrus.Error(err, "unable to watch Roles")
+		runtime.HandleError(err)
+		return
+	}
+
+	watchEvents(ctx, watcher, beat, func(event watch.Event) {
+		role, ok := event.Object.(*rbacv1.Role)
+		if !ok {
+			logrus.Error("Could not parse Role")
+			return
+		}
+
+		// Copies are handled by watchRoles
+		if role.Labels[kube.LabelKey] == kube.LabelValue {
+			return
+		}
+
+		logrus.Debugf("Queueing RBACDefinitions using %s/%s Role after %s event", role.Namespace, role.Name, event.Type)
+		err := queue.enqueueUsingRole(kube.GetRbacDefinitions, role.Namespace, role.Name)
+		if err != nil {
+			logrus.Errorf("Error listing RBAC Definitions: %v", err)
+		}
+	})
+}
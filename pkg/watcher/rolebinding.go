@@ -26,27 +26,24 @@ import (
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/schlapzz/rbac-manager/pkg/kube"
-	"github.com/schlapzz/rbac-manager/pkg/reconciler"
 )
 
-func watchRoleBindings(clientset *kubernetes.Clientset) {
-	watcher, err := clientset.RbacV1().RoleBindings("").Watch(context.TODO(), kube.ListOptions)
+func watchRoleBindings(ctx context.Context, clientset *kubernetes.Clientset, namespace string, queue *definitionQueue, beat func()) {
+	watcher, err := kube.RBAC(clientset).RoleBindings(namespace).Watch(ctx, kube.ListOptions)
 
 	if err != nil {
 		logrus.Error(err, "unable to watch Role Bindings")
 		runtime.HandleError(err)
+		return
 	}
 
-	ch := watcher.ResultChan()
-
-	for event := range ch {
+	watchEvents(ctx, watcher, beat, func(event watch.Event) {
 		rb, ok := event.Object.(*rbacv1.RoleBinding)
 		if !ok {
 			logrus.Error("Could not parse Role Binding")
 		} else if event.Type == watch.Modified || event.Type == watch.Deleted {
-			logrus.Debugf("Reconciling RBACDefinition for %s RoleBinding after %s event", rb.Name, event.Type)
-			r := reconciler.Reconciler{Clientset: kube.GetClientsetOrDie()}
-			_ = r.ReconcileOwners(rb.OwnerReferences, "RoleBinding")
+			logrus.Debugf("Queueing RBACDefinition for %s RoleBinding after %s event", rb.Name, event.Type)
+			queue.enqueueOwners(rb.OwnerReferences)
 		}
-	}
+	})
 }
@@ -18,9 +18,11 @@ package watcher
 
 import (
 	"context"
+	"reflect"
 
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
@@ -29,24 +31,101 @@ import (
 	"github.com/schlapzz/rbac-manager/pkg/reconciler"
 )
 
-func watchServiceAccounts(clientset *kubernetes.Clientset) {
-	watcher, err := clientset.CoreV1().ServiceAccounts("").Watch(context.TODO(), kube.ListOptions)
+func watchServiceAccounts(ctx context.Context, clientset *kubernetes.Clientset, namespace string, queue *definitionQueue, beat func()) {
+	watcher, err := clientset.CoreV1().ServiceAccounts(namespace).Watch(ctx, kube.ListOptions)
 
 	if err != nil {
 		logrus.Error(err, "unable to watch Service Accounts")
 		runtime.HandleError(err)
+		return
 	}
 
-	ch := watcher.ResultChan()
-
-	for event := range ch {
+	watchEvents(ctx, watcher, beat, func(event watch.Event) {
 		sa, ok := event.Object.(*corev1.ServiceAccount)
 		if !ok {
 			logrus.Error("Could not parse Service Account")
-		} else if event.Type == watch.Modified || event.Type == watch.Deleted {
-			logrus.Debugf("Reconciling RBACDefinition for %s ServiceAccount after %s event", sa.Name, event.Type)
-			r := reconciler.Reconciler{Clientset: kube.GetClientsetOrDie()}
-			_ = r.ReconcileOwners(sa.OwnerReferences, "ServiceAccount")
+		} else if serviceAccountEventQueues(event.Type, sa) {
+			logrus.Debugf("Queueing RBACDefinition for %s ServiceAccount after %s event", sa.Name, event.Type)
+			queue.enqueueOwners(sa.OwnerReferences)
 		}
+	})
+}
+
+// serviceAccountEventQueues reports whether a watch event for a managed Service
+// Account should queue its RBAC Definitions. Added Service Accounts are only
+// queued if RBAC Manager didn't apply them as they are, which catches
+// restores from backups with stale content without reconciling again after
+// every create.
+func serviceAccountEventQueues(eventType watch.EventType, sa *corev1.ServiceAccount) bool {
+	switch eventType {
+	case watch.Modified, watch.Deleted:
+		return true
+	case watch.Added:
+		return !reconciler.ServiceAccountApplied(sa)
+	}
+	return false
+}
+
+// watchSelectedServiceAccounts queues the RBAC Definitions whose
+// ServiceAccountSelector subjects match a Service Account, before or after its
+// labels changed, whenever one is added, relabeled, or deleted. Unlike
+// watchServiceAccounts it sees Service Accounts RBAC Manager doesn't manage,
+// so definitions are only listed if a label key of the Service Account is one
+// that selectors depend on.
+func watchSelectedServiceAccounts(ctx context.Context, clientset *kubernetes.Clientset, namespace string, queue *definitionQueue, beat func()) {
+	// Listing first records the current labels and starts the watch after
+	// them, so existing Service Accounts don't queue anything
+	list, err := clientset.CoreV1().ServiceAccounts(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logrus.Error(err, "unable to list Service Accounts")
+		runtime.HandleError(err)
+		return
+	}
+
+	lastLabels := map[string]map[string]string{}
+	for _, sa := range list.Items {
+		lastLabels[sa.Namespace+"/"+sa.Name] = sa.Labels
+	}
+
+	watcher, err := clientset.CoreV1().ServiceAccounts(namespace).Watch(ctx, metav1.ListOptions{ResourceVersion: list.ResourceVersion})
+
+	if err != nil {
+		logrus.Error(err, "unable to watch Service Accounts")
+		runtime.HandleError(err)
+		return
 	}
+
+	watchEvents(ctx, watcher, beat, func(event watch.Event) {
+		sa, ok := event.Object.(*corev1.ServiceAccount)
+		if !ok {
+			logrus.Error("Could not parse Service Account")
+			return
+		}
+
+		key := sa.Namespace + "/" + sa.Name
+		labelSets := []map[string]string{sa.Labels}
+		if previous, known := lastLabels[key]; known {
+			if event.Type == watch.Modified && reflect.DeepEqual(previous, sa.Labels) {
+				return
+			}
+			labelSets = append(labelSets, previous)
+		}
+		if event.Type == watch.Deleted {
+			delete(lastLabels, key)
+		} else {
+			lastLabels[key] = sa.Labels
+		}
+
+		// Every RBAC Definition is indexed before it is reconciled, so those
+		// that aren't can't have missed this change yet
+		if !reconciler.MaySelectServiceAccount(labelSets...) {
+			return
+		}
+
+		logrus.Debugf("Queueing RBACDefinitions selecting %s ServiceAccount after %s event", key, event.Type)
+		err := queue.enqueueSelecting(kube.GetRbacDefinitions, labelSets...)
+		if err != nil {
+			logrus.Errorf("Error listing RBAC Definitions: %v", err)
+		}
+	})
 }
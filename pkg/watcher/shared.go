@@ -17,13 +17,103 @@ limitations under the License.
 package watcher
 
 import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+
 	"github.com/schlapzz/rbac-manager/pkg/kube"
+	"github.com/schlapzz/rbac-manager/pkg/reconciler"
 )
 
-// WatchRelatedResources watches all resources owned by RBAC Definitions
-func WatchRelatedResources() {
+// resyncPoll is how often ResyncPeriodically checks whether a resync is due,
+// which bounds how long a changed sync interval takes to apply
+var resyncPoll = 10 * time.Second
+
+// resyncQueue is the queue of the running watchers that Resync adds to
+var resyncQueue *definitionQueue
+
+// namespacedWatcher watches one kind of resource in a namespace
+type namespacedWatcher struct {
+	kind  string
+	watch func(context.Context, *kubernetes.Clientset, string, *definitionQueue, func())
+}
+
+// WatchRelatedResources watches the Resources owned by RBAC Definitions until
+// ctx is done. Each watcher is supervised and restarted if it stops.
+func WatchRelatedResources(ctx context.Context) {
 	clientset := kube.GetClientsetOrDie()
-	go watchClusterRoleBindings(clientset)
-	go watchRoleBindings(clientset)
-	go watchServiceAccounts(clientset)
+	queue := newDefinitionQueue(clientset)
+	queue.run(Workers)
+	resyncQueue = queue
+
+	if !reconciler.NamespacedMode() && Watches("clusterrolebindings") {
+		go supervise(ctx, "clusterrolebindings", func(ctx context.Context, beat func()) {
+			watchClusterRoleBindings(ctx, clientset, queue, beat)
+		})
+	} else if !reconciler.NamespacedMode() {
+		logrus.Info("Not watching clusterrolebindings, changes to them are picked up by the periodic resync")
+	}
+
+	// In namespaced mode every managed namespace is watched separately, since
+	// RBAC Manager may not watch them all at once
+	watchers := map[string]namespacedWatcher{
+		"rolebindings":             {kind: "rolebindings", watch: watchRoleBindings},
+		"serviceaccounts":          {kind: "serviceaccounts", watch: watchServiceAccounts},
+		"selected-serviceaccounts": {kind: "serviceaccounts", watch: watchSelectedServiceAccounts},
+		"roles":                    {kind: "roles", watch: watchRoles},
+		"source-roles":             {kind: "roles", watch: watchSourceRoles},
+	}
+	for resource, watcher := range watchers {
+		if !Watches(watcher.kind) {
+			logrus.Infof("Not watching %v, changes to them are picked up by the periodic resync", resource)
+			continue
+		}
+		for _, namespace := range reconciler.ListedNamespaces() {
+			watch, namespace := watcher.watch, namespace
+			name := resource
+			if namespace != "" {
+				name = resource + "/" + namespace
+			}
+			go supervise(ctx, name, func(ctx context.Context, beat func()) {
+				watch(ctx, clientset, namespace, queue, beat)
+			})
+		}
+	}
+}
+
+// Resync queues every RBAC Definition for a full reconcile by the watcher
+// workers. It must only be called after WatchRelatedResources.
+func Resync() error {
+	if resyncQueue == nil {
+		return errors.New("watchers are not running")
+	}
+	return resyncQueue.enqueueAll(kube.GetRbacDefinitions)
+}
+
+// ResyncPeriodically calls Resync whenever the sync interval of the current
+// reconciler options has passed since the last resync, until ctx is done
+func ResyncPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(resyncPoll)
+	defer ticker.Stop()
+
+	lastResync := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			interval := reconciler.CurrentOptions().SyncInterval
+			if interval <= 0 || now.Sub(lastResync) < interval {
+				continue
+			}
+			lastResync = now
+			logrus.Debug("Resyncing all RBAC Definitions")
+			if err := Resync(); err != nil {
+				logrus.Errorf("Error resyncing RBAC Definitions: %v", err)
+			}
+		}
+	}
 }
@@ -0,0 +1,139 @@
+/*
+Copyright 2019 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// HeartbeatTimeout is how long a watcher may go without a heartbeat before
+// it is considered stuck and restarted. Idle watchers heartbeat four times
+// per HeartbeatTimeout.
+var HeartbeatTimeout = 2 * time.Minute
+
+// restartBackoffBase and restartBackoffMax bound the delay before restarting
+// a watcher, which doubles while the watcher keeps failing
+var restartBackoffBase = time.Second
+var restartBackoffMax = time.Minute
+
+// watchFunc watches a resource until ctx is done or the watch ends, calling
+// beat whenever it is ready for the next event
+type watchFunc func(ctx context.Context, beat func())
+
+// supervise runs watcher until ctx is done, restarting it whenever it
+// returns, panics, or misses its heartbeat. Restarts are delayed by a
+// backoff that is reset once the watcher has run for a HeartbeatTimeout.
+func supervise(ctx context.Context, resource string, watcher watchFunc) {
+	delay := restartBackoffBase
+	for {
+		started := time.Now()
+		reason := runWatcher(ctx, resource, watcher)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > HeartbeatTimeout {
+			delay = restartBackoffBase
+		}
+		metrics.WatcherRestarts.WithLabelValues(resource).Inc()
+		logrus.Warnf("Watcher for %v %v, restarting it in %v", resource, reason, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > restartBackoffMax {
+			delay = restartBackoffMax
+		}
+	}
+}
+
+// runWatcher runs watcher once and returns why it stopped. A watcher that
+// missed its heartbeat is abandoned with its context cancelled, since it may
+// be blocked on something that doesn't watch the context.
+func runWatcher(ctx context.Context, resource string, watcher watchFunc) string {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var lastBeat int64
+	beat := func() {
+		now := time.Now()
+		atomic.StoreInt64(&lastBeat, now.UnixNano())
+		heartbeats.Store(resource, now)
+		metrics.WatcherHeartbeat.WithLabelValues(resource).Set(float64(now.Unix()))
+	}
+	beat()
+
+	stopped := make(chan string, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				stopped <- fmt.Sprintf("panicked: %v", p)
+			}
+		}()
+		watcher(runCtx, beat)
+		stopped <- "exited"
+	}()
+
+	ticker := time.NewTicker(HeartbeatTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return "stopped"
+		case reason := <-stopped:
+			return reason
+		case <-ticker.C:
+			if time.Since(time.Unix(0, atomic.LoadInt64(&lastBeat))) > HeartbeatTimeout {
+				return fmt.Sprintf("missed its heartbeat for %v", HeartbeatTimeout)
+			}
+		}
+	}
+}
+
+// watchEvents passes the events of w to handle until ctx is done or w ends,
+// beating before each event and while no events arrive
+func watchEvents(ctx context.Context, w watch.Interface, beat func(), handle func(event watch.Event)) {
+	defer w.Stop()
+
+	ticker := time.NewTicker(HeartbeatTimeout / 4)
+	defer ticker.Stop()
+	for {
+		beat()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			handle(event)
+		}
+	}
+}
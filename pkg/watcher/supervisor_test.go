@@ -0,0 +1,158 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/schlapzz/rbac-manager/pkg/metrics"
+)
+
+// withFastSupervisor shortens the heartbeat timeout and restart backoff for
+// the duration of a test
+func withFastSupervisor(t *testing.T) {
+	timeout, base, max := HeartbeatTimeout, restartBackoffBase, restartBackoffMax
+	t.Cleanup(func() {
+		HeartbeatTimeout, restartBackoffBase, restartBackoffMax = timeout, base, max
+	})
+	HeartbeatTimeout = 40 * time.Millisecond
+	restartBackoffBase = time.Millisecond
+	restartBackoffMax = 4 * time.Millisecond
+}
+
+// runSupervisor supervises watcher in the background and returns a channel
+// closed once supervise returns
+func runSupervisor(ctx context.Context, resource string, watcher watchFunc) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		supervise(ctx, resource, watcher)
+	}()
+	return done
+}
+
+func waitFor(t *testing.T, ch chan struct{}, what string) {
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %v", what)
+	}
+}
+
+func TestSuperviseRestartsWatchers(t *testing.T) {
+	withFastSupervisor(t)
+
+	tests := []struct {
+		name    string
+		watcher func(ctx context.Context, beat func())
+	}{{
+		name:    "exited",
+		watcher: func(ctx context.Context, beat func()) {},
+	}, {
+		name:    "panicked",
+		watcher: func(ctx context.Context, beat func()) { panic("watch closed") },
+	}, {
+		name: "stuck",
+		watcher: func(ctx context.Context, beat func()) {
+			// The abandoned watcher must be told to stop
+			<-ctx.Done()
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource := "test-" + tt.name
+			restarts := metrics.WatcherRestarts.WithLabelValues(resource)
+			before := testutil.ToFloat64(restarts)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			runs := make(chan struct{}, 10)
+			done := runSupervisor(ctx, resource, func(ctx context.Context, beat func()) {
+				runs <- struct{}{}
+				tt.watcher(ctx, beat)
+			})
+
+			for i := 0; i < 3; i++ {
+				select {
+				case <-runs:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Watcher was only started %d times", i)
+				}
+			}
+			cancel()
+			waitFor(t, done, "supervise to return")
+
+			assert.GreaterOrEqual(t, testutil.ToFloat64(restarts)-before, float64(2))
+		})
+	}
+}
+
+func TestSuperviseStopsWithContext(t *testing.T) {
+	withFastSupervisor(t)
+
+	restarts := metrics.WatcherRestarts.WithLabelValues("test-shutdown")
+	before := testutil.ToFloat64(restarts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	done := runSupervisor(ctx, "test-shutdown", func(ctx context.Context, beat func()) {
+		close(started)
+		defer close(stopped)
+		w := watch.NewFake()
+		watchEvents(ctx, w, beat, func(event watch.Event) {})
+	})
+
+	// A watcher that keeps beating is left alone
+	waitFor(t, started, "the watcher to start")
+	time.Sleep(3 * HeartbeatTimeout)
+	assert.Equal(t, float64(0), testutil.ToFloat64(restarts)-before)
+
+	cancel()
+	waitFor(t, done, "supervise to return")
+	waitFor(t, stopped, "the watcher to stop")
+	assert.Equal(t, float64(0), testutil.ToFloat64(restarts)-before)
+}
+
+func TestWatchEvents(t *testing.T) {
+	w := watch.NewFake()
+	handled := []string{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchEvents(context.Background(), w, func() {}, func(event watch.Event) {
+			handled = append(handled, event.Object.(*rbacv1.Role).Name)
+		})
+	}()
+
+	w.Add(&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "first"}})
+	w.Modify(&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "second"}})
+	w.Stop()
+
+	// watchEvents returns once the watch ends so that it can be restarted
+	waitFor(t, done, "watchEvents to return")
+	assert.Equal(t, []string{"first", "second"}, handled)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+	"github.com/schlapzz/rbac-manager/pkg/kube"
+)
+
+// GrantPath is the path the RBAC Temporary Grant webhook is served on
+const GrantPath = "/validate-rbactemporarygrant"
+
+// ApproverClusterRole is the ClusterRole that users must be bound to, cluster
+// wide or in the namespace of a grant, to approve RBAC Temporary Grants
+var ApproverClusterRole = "rbac-manager-grant-approver"
+
+// GrantValidator only lets holders of ApproverClusterRole approve RBAC
+// Temporary Grants or change grants that are approved
+type GrantValidator struct {
+	Clientset kubernetes.Interface
+	decoder   *admission.Decoder
+}
+
+// InjectDecoder is called by controller-runtime to set the decoder
+func (v *GrantValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+// Handle validates a create or update of an RBAC Temporary Grant
+func (v *GrantValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	grant := &rbacmanagerv1beta1.RBACTemporaryGrant{}
+	err := v.decoder.Decode(req, grant)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var old *rbacmanagerv1beta1.RBACTemporaryGrant
+	if req.Operation == admissionv1.Update {
+		old = &rbacmanagerv1beta1.RBACTemporaryGrant{}
+		err = v.decoder.DecodeRaw(req.OldObject, old)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	// Grants that nobody approved yet can be changed freely, approving a
+	// grant or changing an approved one requires the approver role
+	approving := grant.Spec.Approved && (old == nil || old.Spec != grant.Spec)
+	changingApproved := old != nil && old.Spec.Approved && old.Spec != grant.Spec
+	if !approving && !changingApproved {
+		return admission.Allowed("")
+	}
+
+	if req.UserInfo.Username == grant.Spec.Requester {
+		return admission.Denied("the requester of a grant cannot approve or change it")
+	}
+
+	approver, err := v.holdsApproverRole(ctx, &req.UserInfo, grant.Namespace)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if !approver {
+		return admission.Denied(fmt.Sprintf("only holders of the %v ClusterRole can approve or change approved grants", ApproverClusterRole))
+	}
+
+	action := "change"
+	if approving && (old == nil || !old.Spec.Approved) {
+		action = "approve"
+	} else if !grant.Spec.Approved {
+		action = "withdraw"
+	}
+	logrus.WithFields(logrus.Fields{
+		"audit":          true,
+		"action":         action,
+		"grant":          grant.Namespace + "/" + grant.Name,
+		"requester":      grant.Spec.Requester,
+		"approver":       req.UserInfo.Username,
+		"rbacDefinition": grant.Spec.RBACDefinition,
+		"rbacBinding":    grant.Spec.RBACBinding,
+		"duration":       grant.Spec.Duration.Duration.String(),
+	}).Infof("%v %v temporary grant for %v", req.UserInfo.Username, action, grant.Spec.Requester)
+
+	return admission.Allowed("")
+}
+
+// holdsApproverRole reports whether user is bound to ApproverClusterRole
+// cluster wide or in namespace
+func (v *GrantValidator) holdsApproverRole(ctx context.Context, user *authenticationv1.UserInfo, namespace string) (bool, error) {
+	crbs, err := kube.RBAC(v.Clientset).ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, crb := range crbs.Items {
+		if bindsApproverRole(&crb.RoleRef, crb.Subjects, user) {
+			return true, nil
+		}
+	}
+
+	rbs, err := kube.RBAC(v.Clientset).RoleBindings(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, rb := range rbs.Items {
+		if bindsApproverRole(&rb.RoleRef, rb.Subjects, user) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func bindsApproverRole(roleRef *rbacv1.RoleRef, subjects []rbacv1.Subject, user *authenticationv1.UserInfo) bool {
+	if roleRef.Kind != "ClusterRole" || roleRef.Name != ApproverClusterRole {
+		return false
+	}
+
+	for _, subject := range subjects {
+		switch subject.Kind {
+		case rbacv1.UserKind:
+			if subject.Name == user.Username {
+				return true
+			}
+		case rbacv1.GroupKind:
+			for _, group := range user.Groups {
+				if subject.Name == group {
+					return true
+				}
+			}
+		case rbacv1.ServiceAccountKind:
+			if fmt.Sprintf("system:serviceaccount:%v:%v", subject.Namespace, subject.Name) == user.Username {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2022 FairwindsOps Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func TestGrantValidator(t *testing.T) {
+	client := fake.NewSimpleClientset(&rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "approvers"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: ApproverClusterRole},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "sre"}},
+	}, &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "approvers", Namespace: "checkout"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: ApproverClusterRole},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Namespace: "ops", Name: "approver-bot"}},
+	})
+	scheme := runtime.NewScheme()
+	assert.NoError(t, rbacmanagerv1beta1.AddToScheme(scheme))
+	decoder, err := admission.NewDecoder(scheme)
+	assert.NoError(t, err)
+	v := &GrantValidator{Clientset: client}
+	assert.NoError(t, v.InjectDecoder(decoder))
+
+	pending := rbacmanagerv1beta1.RBACTemporaryGrant{
+		ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "checkout"},
+		Spec: rbacmanagerv1beta1.RBACTemporaryGrantSpec{
+			RBACDefinition: "oncall",
+			RBACBinding:    "debuggers",
+			Requester:      "jane",
+			Duration:       metav1.Duration{Duration: time.Hour},
+		},
+	}
+	approved := *pending.DeepCopy()
+	approved.Spec.Approved = true
+
+	tests := []struct {
+		name    string
+		user    authenticationv1.UserInfo
+		old     *rbacmanagerv1beta1.RBACTemporaryGrant
+		grant   rbacmanagerv1beta1.RBACTemporaryGrant
+		allowed bool
+	}{
+		{"requesting", authenticationv1.UserInfo{Username: "jane"}, nil, pending, true},
+		{"self approval", authenticationv1.UserInfo{Username: "jane", Groups: []string{"sre"}}, &pending, approved, false},
+		{"approval without role", authenticationv1.UserInfo{Username: "joe"}, &pending, approved, false},
+		{"approval by group", authenticationv1.UserInfo{Username: "joe", Groups: []string{"sre"}}, &pending, approved, true},
+		{"approval by service account", authenticationv1.UserInfo{Username: "system:serviceaccount:ops:approver-bot"}, &pending, approved, true},
+		{"created approved", authenticationv1.UserInfo{Username: "joe"}, nil, approved, false},
+		{"withdrawn without role", authenticationv1.UserInfo{Username: "jane"}, &approved, pending, false},
+		{"status update", authenticationv1.UserInfo{Username: "system:serviceaccount:rbac-manager:rbac-manager"}, &approved, approved, true},
+	}
+
+	for _, tc := range tests {
+		req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			UserInfo:  tc.user,
+			Object:    runtime.RawExtension{Raw: marshalGrant(t, &tc.grant)},
+		}}
+		if tc.old != nil {
+			req.Operation = admissionv1.Update
+			req.OldObject = runtime.RawExtension{Raw: marshalGrant(t, tc.old)}
+		}
+
+		resp := v.Handle(context.TODO(), req)
+		assert.Equal(t, tc.allowed, resp.Allowed, tc.name)
+	}
+}
+
+func marshalGrant(t *testing.T, grant *rbacmanagerv1beta1.RBACTemporaryGrant) []byte {
+	grant = grant.DeepCopy()
+	grant.APIVersion = rbacmanagerv1beta1.SchemeGroupVersion.String()
+	grant.Kind = "RBACTemporaryGrant"
+	raw, err := json.Marshal(grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
@@ -0,0 +1,21 @@
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package integration holds tests that run RBAC Manager against a real API
+// server started by envtest, with the CRDs from deploy installed. They only
+// build with the integration tag and need the envtest binaries, which
+// `make test-integration` downloads:
+//
+//	go test -tags integration ./test/integration/...
+package integration
@@ -0,0 +1,161 @@
+//go:build integration
+// +build integration
+
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	rbacmanagerv1beta1 "github.com/schlapzz/rbac-manager/pkg/apis/rbacmanager/v1beta1"
+)
+
+func createNamespace(t *testing.T, name string, labels map[string]string) {
+	_, err := clientset.CoreV1().Namespaces().Create(context.TODO(), &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+func createDefinition(t *testing.T, rbacDef *rbacmanagerv1beta1.RBACDefinition) {
+	require.NoError(t, k8sClient.Create(context.TODO(), rbacDef))
+	t.Cleanup(func() {
+		assert.NoError(t, client.IgnoreNotFound(k8sClient.Delete(context.TODO(), rbacDef)))
+	})
+}
+
+func roleBindingExists(namespace, name string) func() bool {
+	return func() bool {
+		_, err := clientset.RbacV1().RoleBindings(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		return err == nil
+	}
+}
+
+func roleBindingGone(namespace, name string) func() bool {
+	return func() bool {
+		_, err := clientset.RbacV1().RoleBindings(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		return apierrors.IsNotFound(err)
+	}
+}
+
+func TestReconcileCreatesBindings(t *testing.T) {
+	createNamespace(t, "created", nil)
+	rbacDef := &rbacmanagerv1beta1.RBACDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "created"},
+		RBACBindings: []rbacmanagerv1beta1.RBACBinding{{
+			Name:                "devs",
+			Subjects:            []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+			ClusterRoleBindings: []rbacmanagerv1beta1.ClusterRoleBinding{{ClusterRole: "view"}},
+			RoleBindings:        []rbacmanagerv1beta1.RoleBinding{{ClusterRole: "edit", Namespace: "created"}},
+		}},
+	}
+	createDefinition(t, rbacDef)
+
+	assert.Eventually(t, func() bool {
+		_, err := clientset.RbacV1().ClusterRoleBindings().Get(context.TODO(), "created-devs-view", metav1.GetOptions{})
+		return err == nil
+	}, timeout, interval)
+	assert.Eventually(t, roleBindingExists("created", "created-devs-edit"), timeout, interval)
+
+	// The status is written through the status subresource
+	assert.Eventually(t, func() bool {
+		current := &rbacmanagerv1beta1.RBACDefinition{}
+		if err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(rbacDef), current); err != nil {
+			return false
+		}
+		return meta.IsStatusConditionTrue(current.Status.Conditions, rbacmanagerv1beta1.ConditionReady)
+	}, timeout, interval)
+
+	rb, err := clientset.RbacV1().RoleBindings("created").Get(context.TODO(), "created-devs-edit", metav1.GetOptions{})
+	require.NoError(t, err)
+	if assert.Len(t, rb.OwnerReferences, 1) {
+		assert.Equal(t, "RBACDefinition", rb.OwnerReferences[0].Kind)
+		assert.Equal(t, "created", rb.OwnerReferences[0].Name)
+	}
+	assert.Equal(t, []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "joe", APIGroup: rbacv1.GroupName}}, rb.Subjects)
+}
+
+func TestReconcilePrunesOnSpecChange(t *testing.T) {
+	createNamespace(t, "pruned", nil)
+	rbacDef := &rbacmanagerv1beta1.RBACDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "pruned"},
+		RBACBindings: []rbacmanagerv1beta1.RBACBinding{{
+			Name:     "devs",
+			Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{
+				{ClusterRole: "edit", Namespace: "pruned"},
+				{ClusterRole: "view", Namespace: "pruned"},
+			},
+		}},
+	}
+	createDefinition(t, rbacDef)
+	assert.Eventually(t, roleBindingExists("pruned", "pruned-devs-edit"), timeout, interval)
+	assert.Eventually(t, roleBindingExists("pruned", "pruned-devs-view"), timeout, interval)
+
+	require.NoError(t, k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(rbacDef), rbacDef))
+	rbacDef.RBACBindings[0].RoleBindings = rbacDef.RBACBindings[0].RoleBindings[1:]
+	require.NoError(t, k8sClient.Update(context.TODO(), rbacDef))
+
+	assert.Eventually(t, roleBindingGone("pruned", "pruned-devs-edit"), timeout, interval)
+	assert.True(t, roleBindingExists("pruned", "pruned-devs-view")())
+}
+
+func TestReconcileFollowsNamespaces(t *testing.T) {
+	rbacDef := &rbacmanagerv1beta1.RBACDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "followed"},
+		RBACBindings: []rbacmanagerv1beta1.RBACBinding{{
+			Name:     "devs",
+			Subjects: []rbacmanagerv1beta1.Subject{{Subject: rbacv1.Subject{Kind: rbacv1.UserKind, Name: "joe"}}},
+			RoleBindings: []rbacmanagerv1beta1.RoleBinding{{
+				ClusterRole:       "edit",
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "followed"}},
+			}},
+		}},
+	}
+	createDefinition(t, rbacDef)
+
+	// A namespace created after the definition is picked up by the
+	// namespace watcher
+	createNamespace(t, "followed", map[string]string{"team": "followed"})
+	assert.Eventually(t, roleBindingExists("followed", "followed-devs-edit"), timeout, interval)
+
+	// and so is a namespace that is labeled later
+	createNamespace(t, "relabeled", nil)
+	namespace, err := clientset.CoreV1().Namespaces().Get(context.TODO(), "relabeled", metav1.GetOptions{})
+	require.NoError(t, err)
+	namespace.Labels = map[string]string{"team": "followed"}
+	_, err = clientset.CoreV1().Namespaces().Update(context.TODO(), namespace, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, roleBindingExists("relabeled", "followed-devs-edit"), timeout, interval)
+
+	// Removing the label deletes the Role Binding again
+	namespace, err = clientset.CoreV1().Namespaces().Get(context.TODO(), "relabeled", metav1.GetOptions{})
+	require.NoError(t, err)
+	namespace.Labels = nil
+	_, err = clientset.CoreV1().Namespaces().Update(context.TODO(), namespace, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, roleBindingGone("relabeled", "followed-devs-edit"), timeout, interval)
+}
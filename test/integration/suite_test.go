@@ -0,0 +1,139 @@
+//go:build integration
+// +build integration
+
+// Copyright 2022 FairwindsOps Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/schlapzz/rbac-manager/pkg/apis"
+	"github.com/schlapzz/rbac-manager/pkg/controller"
+	"github.com/schlapzz/rbac-manager/pkg/watcher"
+)
+
+const (
+	// timeout is how long a test waits for RBAC Manager to catch up
+	timeout = 30 * time.Second
+	// interval is how often a test checks whether it has
+	interval = 250 * time.Millisecond
+)
+
+var (
+	// clientset and k8sClient talk to the API server RBAC Manager runs
+	// against
+	clientset *kubernetes.Clientset
+	k8sClient client.Client
+)
+
+// TestMain starts an API server with the CRDs installed and RBAC Manager
+// running against it, the way cmd/manager starts it, for all tests
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "deploy", "2_crd.yaml")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting the test environment: %v\n", err)
+		return 1
+	}
+	defer func() {
+		if err := testEnv.Stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error stopping the test environment: %v\n", err)
+		}
+	}()
+
+	// The watchers build their clients from the default config, so they
+	// are pointed at the test API server through KUBECONFIG
+	kubeConfig, err := useKubeConfig(testEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing a kubeconfig for the test environment: %v\n", err)
+		return 1
+	}
+	defer os.Remove(kubeConfig)
+
+	mgr, err := manager.New(cfg, manager.Options{MetricsBindAddress: "0"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up the manager: %v\n", err)
+		return 1
+	}
+	if err := apis.AddToScheme(mgr.GetScheme()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error adding APIs to the scheme: %v\n", err)
+		return 1
+	}
+	if err := controller.Add(mgr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error adding controllers: %v\n", err)
+		return 1
+	}
+
+	clientset = kubernetes.NewForConfigOrDie(cfg)
+	k8sClient = mgr.GetClient()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher.WatchRelatedResources(ctx)
+	go func() {
+		if err := mgr.Start(ctx); err != nil {
+			logrus.Errorf("Error running the manager: %v", err)
+		}
+	}()
+	if !mgr.GetCache().WaitForCacheSync(ctx) {
+		fmt.Fprintln(os.Stderr, "Error waiting for the manager's caches to sync")
+		return 1
+	}
+
+	return m.Run()
+}
+
+// useKubeConfig writes a kubeconfig for an admin of testEnv to a temporary
+// file and points KUBECONFIG at it. It returns the path of the file.
+func useKubeConfig(testEnv *envtest.Environment) (string, error) {
+	user, err := testEnv.AddUser(envtest.User{Name: "rbac-manager", Groups: []string{"system:masters"}}, nil)
+	if err != nil {
+		return "", err
+	}
+	kubeConfig, err := user.KubeConfig()
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp("", "rbac-manager-kubeconfig")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	_, err = file.Write(kubeConfig)
+	if err != nil {
+		return file.Name(), err
+	}
+	return file.Name(), os.Setenv("KUBECONFIG", file.Name())
+}